@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/shopspring/decimal"
+)
+
+func main() {
+	// 命令行参数
+	var (
+		symbol          = flag.String("symbol", "", "交易对，如 BTCUSDT")
+		strategyType    = flag.String("type", "simple", "策略类型：simple/iceberg/custom")
+		side            = flag.String("side", "BUY", "交易方向：BUY/SELL")
+		price           = flag.Float64("price", 0, "触发价格")
+		totalQuantity   = flag.Float64("quantity", 0, "总数量")
+		buyQuantities   = flag.String("buy-quantities", "", "买入数量分配，逗号分隔的比例")
+		sellQuantities  = flag.String("sell-quantities", "", "卖出数量分配，逗号分隔的比例")
+		buyDepthLevels  = flag.String("buy-depth-levels", "", "买入深度级别，逗号分隔")
+		sellDepthLevels = flag.String("sell-depth-levels", "", "卖出深度级别，逗号分隔")
+		buyBasisPoints  = flag.String("buy-basis-points", "", "买入价格偏移（万分比），逗号分隔")
+		sellBasisPoints = flag.String("sell-basis-points", "", "卖出价格偏移（万分比），逗号分隔")
+		cancelAfter     = flag.Int("cancel-after", 120, "订单自动取消时间（分钟）")
+		makerFee        = flag.Float64("maker-fee", 0.001, "挂单手续费率")
+		takerFee        = flag.Float64("taker-fee", 0.001, "吃单手续费率")
+		maxTriggers     = flag.Int("max-triggers", 0, "最多触发次数，0表示不限制")
+		offline         = flag.Bool("offline", false, "不请求交易所精度信息，使用默认精度")
+		jsonOutput      = flag.Bool("json", false, "以JSON格式输出完整结果（包含成交明细）")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "用法: %s [参数] <K线或aggTrades CSV/zip文件>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *symbol == "" || *price <= 0 || *totalQuantity <= 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	strategy := models.Strategy{
+		Symbol:             strings.ToUpper(*symbol),
+		StrategyType:       *strategyType,
+		Side:               strings.ToUpper(*side),
+		Price:              decimal.NewFromFloat(*price),
+		TotalQuantity:      decimal.NewFromFloat(*totalQuantity),
+		BuyQuantities:      *buyQuantities,
+		SellQuantities:     *sellQuantities,
+		BuyDepthLevels:     *buyDepthLevels,
+		SellDepthLevels:    *sellDepthLevels,
+		BuyBasisPoints:     *buyBasisPoints,
+		SellBasisPoints:    *sellBasisPoints,
+		CancelAfterMinutes: *cancelAfter,
+	}
+
+	// 读取历史数据
+	var ticks []tasks.BacktestTick
+	for _, path := range flag.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("读取 %s 失败: %v", path, err)
+		}
+		fileTicks, err := tasks.ReadBacktestData(data)
+		if err != nil {
+			log.Fatalf("解析 %s 失败: %v", path, err)
+		}
+		ticks = append(ticks, fileTicks...)
+	}
+
+	opts := tasks.BacktestOptions{
+		MakerFeeRate: *makerFee,
+		TakerFeeRate: *takerFee,
+		MaxTriggers:  *maxTriggers,
+	}
+	if !*offline {
+		exchangeInfo, err := services.NewExchange("", "").GetExchangeInfo(context.Background(), strategy.Symbol)
+		if err != nil {
+			log.Printf("获取 %s 交易所信息失败，使用默认精度: %v", strategy.Symbol, err)
+		} else {
+			for i := range exchangeInfo.Symbols {
+				if exchangeInfo.Symbols[i].Symbol == strategy.Symbol {
+					opts.SymbolInfo = &exchangeInfo.Symbols[i]
+					break
+				}
+			}
+		}
+	}
+
+	result, err := tasks.RunBacktest(strategy, ticks, opts)
+	if err != nil {
+		log.Fatalf("回测失败: %v", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatalf("输出结果失败: %v", err)
+		}
+		return
+	}
+
+	printResult(result)
+}
+
+// printResult 输出回测摘要
+func printResult(result *tasks.BacktestResult) {
+	fmt.Printf("=== %s %s %s 策略回测 ===\n", result.Symbol, result.StrategyType, result.Side)
+	fmt.Printf("区间: %s ~ %s（%d 个价格点）\n",
+		result.Start.Format("2006-01-02 15:04:05"), result.End.Format("2006-01-02 15:04:05"), result.Ticks)
+	fmt.Printf("触发次数: %d\n", result.Triggers)
+	fmt.Printf("委托: %d  成交: %d  超时取消: %d  未完成: %d  成交率: %.2f%%\n",
+		result.Orders, result.Filled, result.Cancelled, result.Open, result.FillRate*100)
+	fmt.Printf("成交数量: %.8f  成交均价: %.8f  手续费: %.8f\n", result.FilledQty, result.AvgPrice, result.Fees)
+	fmt.Printf("结束价格: %.8f  盈亏: %.8f\n", result.FinalPrice, result.PnL)
+	fmt.Println()
+
+	fmt.Printf("%-6s %8s %8s %8s %10s %16s %16s %14s %16s\n",
+		"层级", "委托", "成交", "取消", "成交率", "成交数量", "成交均价", "手续费", "盈亏")
+	for _, layer := range result.Layers {
+		fmt.Printf("%-6d %8d %8d %8d %9.2f%% %16.8f %16.8f %14.8f %16.8f\n",
+			layer.Layer+1, layer.Orders, layer.Filled, layer.Cancelled, layer.FillRate*100,
+			layer.FilledQty, layer.AvgPrice, layer.Fees, layer.PnL)
+	}
+}
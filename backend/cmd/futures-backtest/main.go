@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/shopspring/decimal"
+)
+
+func main() {
+	// 命令行参数
+	var (
+		symbol             = flag.String("symbol", "", "交易对，如 BTCUSDT")
+		strategyType       = flag.String("type", "simple", "策略类型：simple/iceberg/slow_iceberg")
+		side               = flag.String("side", "LONG", "持仓方向：LONG/SHORT")
+		basePrice          = flag.Float64("base-price", 0, "触发价格")
+		entryPriceFloat    = flag.Float64("entry-float", 0, "开仓价格浮动千分比")
+		leverage           = flag.Int("leverage", 1, "杠杆倍数")
+		quantity           = flag.Float64("quantity", 0, "开仓保证金（USDT）")
+		takeProfitRate     = flag.Float64("take-profit", 0, "止盈万分比")
+		stopLossRate       = flag.Float64("stop-loss", 0, "止损百分比")
+		marginType         = flag.String("margin-type", "CROSSED", "保证金模式：ISOLATED/CROSSED")
+		icebergQuantities  = flag.String("iceberg-quantities", "", "冰山各层数量比例，逗号分隔")
+		icebergPriceGaps   = flag.String("iceberg-price-gaps", "", "冰山各层价格间隔（万分比），逗号分隔")
+		slowIcebergTimeout = flag.Int("slow-timeout", 5, "慢冰山各层超时时间（分钟）")
+		autoRestart        = flag.Bool("auto-restart", false, "完成后自动重启")
+		trailingMode       = flag.String("trailing-mode", "", "跟踪止损模式：exchange/local，留空关闭")
+		trailingCallback   = flag.Float64("trailing-callback", 0, "跟踪回调百分比")
+		trailingActivation = flag.Float64("trailing-activation", 0, "跟踪激活价格，0表示开仓后立即激活")
+		trailingTakeProfit = flag.Bool("trailing-take-profit", false, "跟踪止盈：以止盈价格激活并替代固定止盈单")
+		markFiles          = flag.String("mark", "", "标记价格K线文件，逗号分隔（可选）")
+		fundingFiles       = flag.String("funding", "", "资金费率文件，逗号分隔（可选）")
+		fundingRate        = flag.Float64("funding-rate", 0.0001, "无资金费率文件时使用的固定费率")
+		makerFee           = flag.Float64("maker-fee", 0.0002, "挂单手续费率")
+		takerFee           = flag.Float64("taker-fee", 0.0005, "吃单手续费率")
+		maintenanceRate    = flag.Float64("maintenance-rate", 0.004, "维持保证金率")
+		balance            = flag.Float64("balance", 10000, "初始资金（USDT）")
+		maxCycles          = flag.Int("max-cycles", 0, "最多回测的周期数，0表示不限制")
+		offline            = flag.Bool("offline", false, "不请求交易所精度信息，使用默认精度")
+		jsonOutput         = flag.Bool("json", false, "以JSON格式输出完整结果（包含资金曲线）")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "用法: %s [参数] <成交/K线 CSV或zip文件>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *symbol == "" || *basePrice <= 0 || *quantity <= 0 || flag.NArg() == 0 && *markFiles == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	strategy := models.FuturesStrategy{
+		Symbol:                  strings.ToUpper(*symbol),
+		StrategyType:            *strategyType,
+		Side:                    strings.ToUpper(*side),
+		BasePrice:               decimal.NewFromFloat(*basePrice),
+		EntryPriceFloat:         *entryPriceFloat,
+		Leverage:                *leverage,
+		Quantity:                decimal.NewFromFloat(*quantity),
+		TakeProfitRate:          *takeProfitRate,
+		StopLossRate:            *stopLossRate,
+		MarginType:              strings.ToUpper(*marginType),
+		IcebergQuantities:       *icebergQuantities,
+		IcebergPriceGaps:        *icebergPriceGaps,
+		SlowIcebergTimeout:      *slowIcebergTimeout,
+		AutoRestart:             *autoRestart,
+		TrailingMode:            *trailingMode,
+		TrailingCallbackRate:    *trailingCallback,
+		TrailingActivationPrice: decimal.NewFromFloat(*trailingActivation),
+		TrailingTakeProfit:      *trailingTakeProfit,
+	}
+
+	// 读取历史数据
+	var data tasks.FuturesBacktestData
+	for _, path := range flag.Args() {
+		data.Trades = append(data.Trades, readTicks(path)...)
+	}
+	for _, path := range splitPaths(*markFiles) {
+		data.MarkPrices = append(data.MarkPrices, readTicks(path)...)
+	}
+	for _, path := range splitPaths(*fundingFiles) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("读取 %s 失败: %v", path, err)
+		}
+		rates, err := tasks.ReadFundingRateData(content)
+		if err != nil {
+			log.Fatalf("解析 %s 失败: %v", path, err)
+		}
+		data.FundingRates = append(data.FundingRates, rates...)
+	}
+
+	opts := tasks.FuturesBacktestOptions{
+		MakerFeeRate:          *makerFee,
+		TakerFeeRate:          *takerFee,
+		FundingRate:           fundingRate,
+		MaintenanceMarginRate: *maintenanceRate,
+		InitialBalance:        *balance,
+		MaxCycles:             *maxCycles,
+	}
+	if !*offline {
+		exchangeInfo, err := services.NewExchange("", "").GetFuturesExchangeInfo(context.Background())
+		if err != nil {
+			log.Printf("获取期货交易所信息失败，使用默认精度: %v", err)
+		} else {
+			for i := range exchangeInfo.Symbols {
+				if exchangeInfo.Symbols[i].Symbol == strategy.Symbol {
+					opts.SymbolInfo = &exchangeInfo.Symbols[i]
+					break
+				}
+			}
+		}
+	}
+
+	result, err := tasks.RunFuturesBacktest(strategy, data, opts)
+	if err != nil {
+		log.Fatalf("回测失败: %v", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatalf("输出结果失败: %v", err)
+		}
+		return
+	}
+
+	printResult(result)
+}
+
+func splitPaths(s string) []string {
+	var paths []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func readTicks(path string) []tasks.BacktestTick {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("读取 %s 失败: %v", path, err)
+	}
+	ticks, err := tasks.ReadBacktestData(content)
+	if err != nil {
+		log.Fatalf("解析 %s 失败: %v", path, err)
+	}
+	return ticks
+}
+
+// printResult 输出回测摘要
+func printResult(result *tasks.FuturesBacktestResult) {
+	fmt.Printf("=== %s %s %s %dx %s 期货策略回测 ===\n",
+		result.Symbol, result.StrategyType, result.Side, result.Leverage, result.MarginType)
+	fmt.Printf("区间: %s ~ %s\n", result.Start.Format("2006-01-02 15:04:05"), result.End.Format("2006-01-02 15:04:05"))
+	fmt.Printf("触发次数: %d  开仓超时: %d  周期: %d  自动重启: %d  强平: %d  最终状态: %s\n",
+		result.Triggers, result.EntryTimeouts, len(result.Cycles), result.AutoRestarts, result.Liquidations, result.FinalStatus)
+	if result.StatusReason != "" {
+		fmt.Printf("状态原因: %s\n", result.StatusReason)
+	}
+	fmt.Printf("止盈: %d  止损: %d  跟踪止损: %d\n", result.TakeProfits, result.StopLosses, result.TrailingStops)
+	fmt.Printf("已实现盈亏: %.4f  手续费: %.4f  资金费: %.4f  净盈亏: %.4f\n",
+		result.RealizedPnl, result.Fees, result.Funding, result.NetPnl)
+	fmt.Printf("初始资金: %.4f  最终权益: %.4f  最大回撤: %.4f (%.2f%%)\n",
+		result.InitialBalance, result.FinalEquity, result.MaxDrawdown, result.MaxDrawdownPct)
+	fmt.Println()
+
+	fmt.Printf("%-4s %-19s %-19s %14s %14s %12s %14s %-12s %12s %10s %10s %12s\n",
+		"周期", "开仓时间", "平仓时间", "开仓均价", "平仓均价", "数量", "强平价", "结果", "盈亏", "手续费", "资金费", "净盈亏")
+	for _, cycle := range result.Cycles {
+		closedAt := "-"
+		if cycle.ClosedAt != nil {
+			closedAt = cycle.ClosedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-4d %-19s %-19s %14.6f %14.6f %12.4f %14.6f %-12s %12.4f %10.4f %10.4f %12.4f\n",
+			cycle.Index, cycle.OpenedAt.Format("2006-01-02 15:04:05"), closedAt,
+			cycle.EntryPrice, cycle.ExitPrice, cycle.Quantity, cycle.LiquidationPrice, cycle.ExitReason,
+			cycle.RealizedPnl+cycle.UnrealizedPnl, cycle.Fees, cycle.Funding, cycle.NetPnl)
+	}
+}
@@ -1,62 +1,139 @@
 package config
 
 import (
+	"fmt"
+	"log"
+	"time"
+
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"log"
-	"os"
-	"time"
+)
+
+// 运行模式，生产模式下拒绝使用不安全的默认配置启动
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
 )
 
 type Config struct {
 	DB        *gorm.DB
 	JWTSecret string
+
+	// 默认交易所环境：production, spot_testnet, futures_testnet, custom
+	// 未指定环境的交易所账户和公共行情数据流使用该环境
+	BinanceEnv     string
+	BinanceBaseURL string // custom 环境的交易所地址
+
+	Mode          string // development, production
+	EncryptionKey string // API密钥加密密钥
+	Server        ServerConfig
+	Database      DatabaseConfig
+	Notify        NotifyConfig
+	Tasks         TaskConfig
+	Cluster       ClusterConfig
+}
+
+// ServerConfig HTTP服务配置
+type ServerConfig struct {
+	Port      int
+	GinMode   string // debug 时输出 gin 调试日志
+	AccessLog bool   // 记录错误请求和慢请求
+
+	ShutdownTimeout time.Duration // 优雅停止的最长等待时间，包括等待进行中的下单
+}
+
+// DatabaseConfig 数据库连接和连接池配置
+type DatabaseConfig struct {
+	DSN             string
+	Debug           bool // 输出SQL日志
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
+// NotifyConfig 系统通知渠道配置，Telegram机器人和SMTP服务器由所有用户共用
+type NotifyConfig struct {
+	TelegramBotToken string
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+}
+
+// TaskConfig 后台任务的执行间隔和超时
+type TaskConfig struct {
+	OrderCheckInterval          time.Duration // 现货订单状态轮询
+	DCAInterval                 time.Duration // 定投计划检查
+	WithdrawalInterval          time.Duration // 自动提币规则检查
+	RiskInterval                time.Duration // 单日亏损检查
+	PriceCleanupInterval        time.Duration // 清理不活跃的现货行情连接
+	FuturesPriceInterval        time.Duration // 合约行情连接同步
+	FuturesPositionInterval     time.Duration // 合约持仓同步
+	FuturesOrderInterval        time.Duration // 合约订单状态轮询
+	FuturesEntryTimeout         time.Duration // 合约开仓订单超时撤单
+	DualProductSyncInterval     time.Duration // 双币投资产品同步
+	DualStrategyInterval        time.Duration // 双币投资策略执行
+	DualSettlementInterval      time.Duration // 双币投资结算检查
+	UserStreamScanInterval      time.Duration // 扫描需要建立用户数据流的账户
+	UserStreamReconcileInterval time.Duration // 数据流正常时轮询对账的间隔
+	AlertRefreshInterval        time.Duration // 重新加载价格提醒规则
+	CandleFlushInterval         time.Duration // K线写入数据库
+	CandleCleanupInterval       time.Duration // 清理过期K线
+	NotificationRetryInterval   time.Duration // 通知投递重试的基础间隔
+}
+
+// ClusterConfig 多实例部署配置，开启后各后台任务通过数据库租约保证同一时间只在一个实例上运行
+type ClusterConfig struct {
+	Enabled       bool
+	InstanceID    string        // 实例ID，默认为主机名和进程号
+	LeaseTTL      time.Duration // 租约有效期，持有实例失联超过该时间后由其他实例接管
+	RenewInterval time.Duration // 续约和竞争租约的间隔，必须小于租约有效期
+	Tasks         string        // 本实例优先运行的任务，逗号分隔，空表示全部；其他任务只在无人持有时接管
+}
+
+// NewConfig 从配置文件和环境变量加载配置并连接数据库，供命令行工具使用
 func NewConfig() *Config {
-	dsn := os.Getenv("DATABASE_DSN")
-	if dsn == "" {
-		dsn = "root:123456@tcp(mysql:3306)/binance?charset=utf8mb4&parseTime=True&loc=Local"
-		log.Println("未设置 DATABASE_DSN，使用默认值")
+	cfg, err := Load(nil)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
 	}
+	if err := cfg.OpenDB(); err != nil {
+		log.Fatal("连接数据库失败: ", err)
+	}
+	return cfg
+}
 
-	// 根据环境变量设置日志级别
+// OpenDB 按数据库配置连接数据库并设置连接池
+func (c *Config) OpenDB() error {
 	logLevel := logger.Silent // 默认静默，不输出SQL日志
-	if os.Getenv("DB_DEBUG") == "true" {
+	if c.Database.Debug {
 		logLevel = logger.Info // 只在需要调试时开启
 	}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(mysql.Open(c.Database.DSN), &gorm.Config{
 		Logger:                                   logger.Default.LogMode(logLevel), // 设置日志级别
 		PrepareStmt:                              true,                             // 启用预编译语句
 		SkipDefaultTransaction:                   true,                             // 跳过默认事务
 		DisableForeignKeyConstraintWhenMigrating: true,
 	})
 	if err != nil {
-		log.Fatal("连接数据库失败: ", err)
+		return err
 	}
 
 	// 配置连接池
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal("获取数据库实例失败: ", err)
+		return fmt.Errorf("获取数据库实例失败: %v", err)
 	}
 
-	sqlDB.SetMaxIdleConns(25)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(5 * time.Minute)
-	sqlDB.SetConnMaxIdleTime(10 * time.Minute)
+	sqlDB.SetMaxIdleConns(c.Database.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(c.Database.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(c.Database.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(c.Database.ConnMaxIdleTime)
 
-	// 从环境变量加载 JWT 密钥
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Println("警告：未设置 JWT_SECRET，使用默认值（生产环境中应设置此环境变量）")
-		jwtSecret = "your_jwt_secret_key_change_in_production"
-	}
-
-	return &Config{
-		DB:        db,
-		JWTSecret: jwtSecret,
-	}
+	c.DB = db
+	return nil
 }
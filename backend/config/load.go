@@ -0,0 +1,293 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccj241/binance/utils"
+)
+
+// 不安全的默认值，只允许在开发模式下使用
+const (
+	defaultDSN       = "root:123456@tcp(mysql:3306)/binance?charset=utf8mb4&parseTime=True&loc=Local"
+	defaultJWTSecret = "your_jwt_secret_key_change_in_production"
+)
+
+// setting 一个配置项；命令行参数名与配置文件中的键相同，如 tasks.order_check_interval
+type setting struct {
+	key string
+	env string
+}
+
+// newFlagSet 注册所有配置项及其默认值，配置文件、环境变量和命令行参数都通过 flag.Value 解析
+func newFlagSet(c *Config) (*flag.FlagSet, []setting) {
+	fs := flag.NewFlagSet("binance", flag.ContinueOnError)
+	var settings []setting
+	str := func(p *string, key, env, value, usage string) {
+		fs.StringVar(p, key, value, usage)
+		settings = append(settings, setting{key, env})
+	}
+	num := func(p *int, key, env string, value int, usage string) {
+		fs.IntVar(p, key, value, usage)
+		settings = append(settings, setting{key, env})
+	}
+	boolean := func(p *bool, key, env string, value bool, usage string) {
+		fs.BoolVar(p, key, value, usage)
+		settings = append(settings, setting{key, env})
+	}
+	duration := func(p *time.Duration, key, env string, value time.Duration, usage string) {
+		fs.DurationVar(p, key, value, usage)
+		settings = append(settings, setting{key, env})
+	}
+
+	str(&c.Mode, "mode", "APP_MODE", ModeDevelopment, "运行模式：development/production")
+	str(&c.JWTSecret, "security.jwt_secret", "JWT_SECRET", defaultJWTSecret, "JWT签名密钥")
+	str(&c.EncryptionKey, "security.encryption_key", "ENCRYPTION_KEY", "", "API密钥加密密钥（32字节）")
+
+	num(&c.Server.Port, "server.port", "PORT", 23337, "HTTP监听端口")
+	str(&c.Server.GinMode, "server.gin_mode", "GIN_MODE", "", "gin运行模式，debug 时输出调试日志")
+	boolean(&c.Server.AccessLog, "server.access_log", "GIN_ACCESS_LOG", false, "记录错误请求和慢请求")
+	duration(&c.Server.ShutdownTimeout, "server.shutdown_timeout", "SHUTDOWN_TIMEOUT", 30*time.Second, "优雅停止的最长等待时间")
+
+	str(&c.Database.DSN, "database.dsn", "DATABASE_DSN", defaultDSN, "MySQL连接串")
+	boolean(&c.Database.Debug, "database.debug", "DB_DEBUG", false, "输出SQL日志")
+	num(&c.Database.MaxIdleConns, "database.max_idle_conns", "DB_MAX_IDLE_CONNS", 25, "最大空闲连接数")
+	num(&c.Database.MaxOpenConns, "database.max_open_conns", "DB_MAX_OPEN_CONNS", 100, "最大连接数")
+	duration(&c.Database.ConnMaxLifetime, "database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", 5*time.Minute, "连接最长存活时间")
+	duration(&c.Database.ConnMaxIdleTime, "database.conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", 10*time.Minute, "连接最长空闲时间")
+
+	str(&c.BinanceEnv, "binance.env", "BINANCE_ENV", "production", "默认交易所环境：production/spot_testnet/futures_testnet/custom")
+	str(&c.BinanceBaseURL, "binance.base_url", "BINANCE_BASE_URL", "", "custom 环境的交易所地址")
+
+	str(&c.Notify.TelegramBotToken, "notify.telegram_bot_token", "TELEGRAM_BOT_TOKEN", "", "Telegram机器人令牌")
+	str(&c.Notify.SMTPHost, "notify.smtp_host", "SMTP_HOST", "", "SMTP服务器")
+	num(&c.Notify.SMTPPort, "notify.smtp_port", "SMTP_PORT", 587, "SMTP端口")
+	str(&c.Notify.SMTPUsername, "notify.smtp_username", "SMTP_USERNAME", "", "SMTP用户名")
+	str(&c.Notify.SMTPPassword, "notify.smtp_password", "SMTP_PASSWORD", "", "SMTP密码")
+	str(&c.Notify.SMTPFrom, "notify.smtp_from", "SMTP_FROM", "", "发件人，默认使用SMTP用户名")
+
+	boolean(&c.Cluster.Enabled, "cluster.enabled", "CLUSTER_ENABLED", false, "多实例部署，后台任务按租约运行")
+	str(&c.Cluster.InstanceID, "cluster.instance_id", "CLUSTER_INSTANCE_ID", "", "实例ID，默认为主机名和进程号")
+	duration(&c.Cluster.LeaseTTL, "cluster.lease_ttl", "CLUSTER_LEASE_TTL", 30*time.Second, "任务租约有效期")
+	duration(&c.Cluster.RenewInterval, "cluster.renew_interval", "CLUSTER_RENEW_INTERVAL", 10*time.Second, "任务租约续约间隔")
+	str(&c.Cluster.Tasks, "cluster.tasks", "CLUSTER_TASKS", "", "本实例优先运行的任务，逗号分隔，空表示全部")
+
+	t := &c.Tasks
+	duration(&t.OrderCheckInterval, "tasks.order_check_interval", "TASK_ORDER_CHECK_INTERVAL", 30*time.Second, "现货订单状态轮询间隔")
+	duration(&t.DCAInterval, "tasks.dca_interval", "TASK_DCA_INTERVAL", 30*time.Second, "定投计划检查间隔")
+	duration(&t.WithdrawalInterval, "tasks.withdrawal_interval", "TASK_WITHDRAWAL_INTERVAL", 5*time.Minute, "自动提币规则检查间隔")
+	duration(&t.RiskInterval, "tasks.risk_interval", "TASK_RISK_INTERVAL", time.Minute, "单日亏损检查间隔")
+	duration(&t.PriceCleanupInterval, "tasks.price_cleanup_interval", "TASK_PRICE_CLEANUP_INTERVAL", 5*time.Minute, "清理不活跃行情连接的间隔")
+	duration(&t.FuturesPriceInterval, "tasks.futures_price_interval", "TASK_FUTURES_PRICE_INTERVAL", time.Second, "合约行情连接同步间隔")
+	duration(&t.FuturesPositionInterval, "tasks.futures_position_interval", "TASK_FUTURES_POSITION_INTERVAL", 30*time.Second, "合约持仓同步间隔")
+	duration(&t.FuturesOrderInterval, "tasks.futures_order_interval", "TASK_FUTURES_ORDER_INTERVAL", 10*time.Second, "合约订单状态轮询间隔")
+	duration(&t.FuturesEntryTimeout, "tasks.futures_entry_timeout", "TASK_FUTURES_ENTRY_TIMEOUT", 10*time.Minute, "合约开仓订单超时时间，超时未成交撤单")
+	duration(&t.DualProductSyncInterval, "tasks.dual_product_sync_interval", "TASK_DUAL_PRODUCT_SYNC_INTERVAL", 5*time.Minute, "双币投资产品同步间隔")
+	duration(&t.DualStrategyInterval, "tasks.dual_strategy_interval", "TASK_DUAL_STRATEGY_INTERVAL", time.Minute, "双币投资策略执行间隔")
+	duration(&t.DualSettlementInterval, "tasks.dual_settlement_interval", "TASK_DUAL_SETTLEMENT_INTERVAL", 10*time.Minute, "双币投资结算检查间隔")
+	duration(&t.UserStreamScanInterval, "tasks.user_stream_scan_interval", "TASK_USER_STREAM_SCAN_INTERVAL", time.Minute, "扫描用户数据流账户的间隔")
+	duration(&t.UserStreamReconcileInterval, "tasks.user_stream_reconcile_interval", "TASK_USER_STREAM_RECONCILE_INTERVAL", 5*time.Minute, "数据流正常时轮询对账的间隔")
+	duration(&t.AlertRefreshInterval, "tasks.alert_refresh_interval", "TASK_ALERT_REFRESH_INTERVAL", 10*time.Second, "价格提醒规则刷新间隔")
+	duration(&t.CandleFlushInterval, "tasks.candle_flush_interval", "TASK_CANDLE_FLUSH_INTERVAL", 5*time.Second, "K线写库间隔")
+	duration(&t.CandleCleanupInterval, "tasks.candle_cleanup_interval", "TASK_CANDLE_CLEANUP_INTERVAL", 6*time.Hour, "过期K线清理间隔")
+	duration(&t.NotificationRetryInterval, "tasks.notification_retry_interval", "TASK_NOTIFICATION_RETRY_INTERVAL", 30*time.Second, "通知重试基础间隔")
+
+	return fs, settings
+}
+
+// Load 加载配置，优先级从低到高：默认值、配置文件、环境变量、命令行参数
+// 配置文件路径由 -config 参数或 CONFIG_FILE 环境变量指定，格式为按 . 分层的JSON
+// args 为 nil 时不解析命令行参数
+func Load(args []string) (*Config, error) {
+	c := &Config{}
+	fs, settings := newFlagSet(c)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON配置文件路径")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 命令行显式指定的参数优先级最高，不再被配置文件和环境变量覆盖
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if fs.Lookup(key) == nil || key == "config" {
+				return nil, fmt.Errorf("配置文件 %s 包含未知配置项: %s", *configFile, key)
+			}
+			// 空字符串与未设置相同，保留默认值
+			if explicit[key] || value == "" {
+				continue
+			}
+			if err := fs.Set(key, value); err != nil {
+				return nil, fmt.Errorf("配置文件 %s 中 %s 无效: %v", *configFile, key, err)
+			}
+		}
+		log.Printf("已加载配置文件: %s", *configFile)
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok || value == "" || explicit[s.key] {
+			continue
+		}
+		if err := fs.Set(s.key, value); err != nil {
+			return nil, fmt.Errorf("环境变量 %s 无效: %v", s.env, err)
+		}
+	}
+
+	// 未指定实例ID时用主机名和进程号区分实例
+	if c.Cluster.InstanceID == "" {
+		host, _ := os.Hostname()
+		c.Cluster.InstanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	utils.SetEncryptionKey(c.EncryptionKey)
+	return c, nil
+}
+
+// readConfigFile 读取JSON配置文件并展开为 a.b 形式的键
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	values := make(map[string]string)
+	if err := flattenConfig("", raw, values); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	return values, nil
+}
+
+func flattenConfig(prefix string, raw map[string]interface{}, values map[string]string) error {
+	for name, v := range raw {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if err := flattenConfig(key, value, values); err != nil {
+				return err
+			}
+		case string:
+			values[key] = value
+		case float64:
+			values[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		default:
+			return fmt.Errorf("%s 的值类型不支持", key)
+		}
+	}
+	return nil
+}
+
+// validate 校验配置，生产模式下不允许使用不安全的默认值
+func (c *Config) validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch c.Mode {
+	case ModeDevelopment, ModeProduction:
+	default:
+		add("mode 必须是 %s 或 %s", ModeDevelopment, ModeProduction)
+	}
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		add("server.port 无效: %d", c.Server.Port)
+	}
+	if c.Database.DSN == "" {
+		add("database.dsn 不能为空")
+	}
+	if c.Database.MaxOpenConns <= 0 || c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		add("数据库连接池配置无效: max_idle_conns=%d max_open_conns=%d", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.JWTSecret == "" {
+		add("security.jwt_secret 不能为空")
+	}
+	if c.Notify.SMTPPort <= 0 || c.Notify.SMTPPort > 65535 {
+		add("notify.smtp_port 无效: %d", c.Notify.SMTPPort)
+	}
+
+	// 所有任务间隔和超时必须为正数
+	durations := map[string]time.Duration{
+		"server.shutdown_timeout":              c.Server.ShutdownTimeout,
+		"database.conn_max_lifetime":           c.Database.ConnMaxLifetime,
+		"database.conn_max_idle_time":          c.Database.ConnMaxIdleTime,
+		"tasks.order_check_interval":           c.Tasks.OrderCheckInterval,
+		"tasks.dca_interval":                   c.Tasks.DCAInterval,
+		"tasks.withdrawal_interval":            c.Tasks.WithdrawalInterval,
+		"tasks.risk_interval":                  c.Tasks.RiskInterval,
+		"tasks.price_cleanup_interval":         c.Tasks.PriceCleanupInterval,
+		"tasks.futures_price_interval":         c.Tasks.FuturesPriceInterval,
+		"tasks.futures_position_interval":      c.Tasks.FuturesPositionInterval,
+		"tasks.futures_order_interval":         c.Tasks.FuturesOrderInterval,
+		"tasks.futures_entry_timeout":          c.Tasks.FuturesEntryTimeout,
+		"tasks.dual_product_sync_interval":     c.Tasks.DualProductSyncInterval,
+		"tasks.dual_strategy_interval":         c.Tasks.DualStrategyInterval,
+		"tasks.dual_settlement_interval":       c.Tasks.DualSettlementInterval,
+		"tasks.user_stream_scan_interval":      c.Tasks.UserStreamScanInterval,
+		"tasks.user_stream_reconcile_interval": c.Tasks.UserStreamReconcileInterval,
+		"tasks.alert_refresh_interval":         c.Tasks.AlertRefreshInterval,
+		"tasks.candle_flush_interval":          c.Tasks.CandleFlushInterval,
+		"tasks.candle_cleanup_interval":        c.Tasks.CandleCleanupInterval,
+		"tasks.notification_retry_interval":    c.Tasks.NotificationRetryInterval,
+		"cluster.lease_ttl":                    c.Cluster.LeaseTTL,
+		"cluster.renew_interval":               c.Cluster.RenewInterval,
+	}
+	for key, d := range durations {
+		if d <= 0 {
+			add("%s 必须大于0: %v", key, d)
+		}
+	}
+
+	if c.Cluster.Enabled && c.Cluster.RenewInterval >= c.Cluster.LeaseTTL {
+		add("cluster.renew_interval 必须小于 cluster.lease_ttl")
+	}
+	if c.Mode == ModeProduction {
+		if c.JWTSecret == defaultJWTSecret {
+			add("生产模式必须设置 JWT_SECRET")
+		}
+		if c.EncryptionKey == "" || c.EncryptionKey == utils.DevEncryptionKey {
+			add("生产模式必须设置 ENCRYPTION_KEY")
+		} else if len(c.EncryptionKey) < 32 {
+			add("生产模式下 ENCRYPTION_KEY 至少需要32字节")
+		}
+		if c.Database.DSN == defaultDSN {
+			add("生产模式必须设置 DATABASE_DSN")
+		}
+	} else {
+		if c.JWTSecret == defaultJWTSecret {
+			log.Println("警告：未设置 JWT_SECRET，使用默认值（生产环境中应设置此环境变量）")
+		}
+		if c.Database.DSN == defaultDSN {
+			log.Println("未设置 DATABASE_DSN，使用默认值")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("配置无效:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
@@ -260,3 +262,127 @@ func (ctrl *AdminController) GetUserStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, stats)
 }
+
+// GetRateLimits 获取币安API限流预算使用情况
+func (ctrl *AdminController) GetRateLimits(c *gin.Context) {
+	// 检查是否为管理员
+	if !ctrl.checkAdminRole(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.RateLimitStatus())
+}
+
+// TriggerKillSwitch 管理员紧急停止，不指定用户时对全系统生效
+func (ctrl *AdminController) TriggerKillSwitch(c *gin.Context) {
+	// 检查是否为管理员
+	if !ctrl.checkAdminRole(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+
+	var req struct {
+		UserID         uint   `json:"userId"`
+		Reason         string `json:"reason" binding:"required,max=500"`
+		ClosePositions bool   `json:"closePositions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	event := &models.KillSwitchEvent{
+		Scope:          tasks.KillSwitchScopeSystem,
+		TriggeredBy:    adminID.(uint),
+		Reason:         req.Reason,
+		ClosePositions: req.ClosePositions,
+	}
+	if req.UserID != 0 {
+		var user models.User
+		if err := ctrl.Config.DB.First(&user, req.UserID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+			return
+		}
+		event.Scope = tasks.KillSwitchScopeUser
+		event.TargetUserID = user.ID
+	}
+
+	if err := tasks.TriggerKillSwitch(ctrl.Config, event); err != nil {
+		log.Printf("触发紧急停止失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "触发紧急停止失败"})
+		return
+	}
+
+	// 系统级需逐个用户撤单，后台执行，新开仓在记录写入后即被拒绝
+	if event.Scope == tasks.KillSwitchScopeSystem {
+		go tasks.RunKillSwitch(ctrl.Config, event)
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "系统级紧急停止已触发，正在后台执行",
+			"event":   event,
+		})
+		return
+	}
+
+	tasks.RunKillSwitch(ctrl.Config, event)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "紧急停止已执行",
+		"event":   event,
+	})
+}
+
+// GetKillSwitches 获取紧急停止记录
+func (ctrl *AdminController) GetKillSwitches(c *gin.Context) {
+	// 检查是否为管理员
+	if !ctrl.checkAdminRole(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+
+	query := ctrl.Config.DB.Model(&models.KillSwitchEvent{})
+	if c.Query("active") == "true" {
+		query = query.Where("active = ?", true)
+	}
+	if userID := c.Query("userId"); userID != "" {
+		query = query.Where("target_user_id = ?", userID)
+	}
+
+	var events []models.KillSwitchEvent
+	if err := query.Order("created_at desc").Limit(200).Find(&events).Error; err != nil {
+		log.Printf("获取紧急停止记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取紧急停止记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// ReleaseKillSwitch 解除紧急停止
+func (ctrl *AdminController) ReleaseKillSwitch(c *gin.Context) {
+	// 检查是否为管理员
+	if !ctrl.checkAdminRole(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+
+	var event models.KillSwitchEvent
+	if err := ctrl.Config.DB.First(&event, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "紧急停止记录未找到"})
+		return
+	}
+	if !event.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "紧急停止已解除"})
+		return
+	}
+
+	if err := tasks.ReleaseKillSwitch(ctrl.Config.DB, &event, adminID.(uint)); err != nil {
+		log.Printf("解除紧急停止失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解除紧急停止失败"})
+		return
+	}
+
+	log.Printf("管理员 %d 解除紧急停止 %d (范围=%s, 用户=%d)", adminID, event.ID, event.Scope, event.TargetUserID)
+	c.JSON(http.StatusOK, gin.H{"message": "紧急停止已解除，策略需手动重新启用"})
+}
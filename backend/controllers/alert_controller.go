@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+type AlertController struct {
+	Config *config.Config
+}
+
+// validatePriceAlert 按提醒类型校验市场、阈值和窗口
+func validatePriceAlert(alert *models.PriceAlert) string {
+	if alert.Market != models.AlertMarketSpot && alert.Market != models.AlertMarketFutures {
+		return "市场必须为 spot 或 futures"
+	}
+	if alert.Symbol == "" {
+		return "交易对不能为空"
+	}
+	if alert.CooldownMinutes < 1 {
+		return "冷却时间不能少于1分钟"
+	}
+
+	switch alert.Type {
+	case models.AlertPriceAbove, models.AlertPriceBelow:
+		if alert.Threshold <= 0 {
+			return "价格阈值必须大于0"
+		}
+	case models.AlertPercentChange:
+		if alert.Threshold <= 0 {
+			return "涨跌幅阈值必须大于0"
+		}
+		if alert.WindowMinutes < 1 || alert.WindowMinutes > tasks.MaxAlertWindowMinutes {
+			return fmt.Sprintf("统计窗口必须在1-%d分钟之间", tasks.MaxAlertWindowMinutes)
+		}
+	case models.AlertVolumeSpike:
+		// 合约只有标记价格推送，没有成交额
+		if !models.CandleHasVolume(alert.Market) {
+			return "成交额异动提醒仅支持现货"
+		}
+		if alert.Threshold <= 1 {
+			return "成交额倍数必须大于1"
+		}
+		if alert.WindowMinutes < 1 || alert.WindowMinutes > tasks.MaxVolumeWindowMinutes {
+			return fmt.Sprintf("统计窗口必须在1-%d分钟之间", tasks.MaxVolumeWindowMinutes)
+		}
+	case models.AlertFundingRateAbove, models.AlertFundingRateBelow:
+		if alert.Market != models.AlertMarketFutures {
+			return "资金费率提醒仅支持合约"
+		}
+	default:
+		return "不支持的提醒类型"
+	}
+	return ""
+}
+
+// CreateAlert 创建价格提醒
+func (ctrl *AlertController) CreateAlert(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Name            string  `json:"name" binding:"max=100"`
+		Market          string  `json:"market" binding:"required"`
+		Symbol          string  `json:"symbol" binding:"required"`
+		Type            string  `json:"type" binding:"required"`
+		Threshold       float64 `json:"threshold"`
+		WindowMinutes   int     `json:"windowMinutes"`
+		CooldownMinutes *int    `json:"cooldownMinutes"`
+		Repeat          *bool   `json:"repeat"`
+		Note            string  `json:"note" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
+	}
+
+	alert := models.PriceAlert{
+		UserID:          userID.(uint),
+		Name:            req.Name,
+		Market:          req.Market,
+		Symbol:          strings.ToUpper(strings.TrimSpace(req.Symbol)),
+		Type:            req.Type,
+		Threshold:       req.Threshold,
+		WindowMinutes:   req.WindowMinutes,
+		CooldownMinutes: 15,
+		Repeat:          true,
+		Enabled:         true,
+		Note:            req.Note,
+	}
+	if req.CooldownMinutes != nil {
+		alert.CooldownMinutes = *req.CooldownMinutes
+	}
+	if req.Repeat != nil {
+		alert.Repeat = *req.Repeat
+	}
+	if msg := validatePriceAlert(&alert); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := ctrl.Config.DB.Create(&alert).Error; err != nil {
+		log.Printf("创建价格提醒失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建提醒失败"})
+		return
+	}
+	// gorm 不会写入零值，显式保存一次性提醒
+	if !alert.Repeat {
+		ctrl.Config.DB.Model(&alert).Update("repeat", false)
+	}
+	go tasks.RefreshPriceAlerts(ctrl.Config)
+
+	log.Printf("用户 %d 创建价格提醒: %s %s %s %.8f", alert.UserID, alert.Market, alert.Symbol, alert.Type, alert.Threshold)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "提醒创建成功",
+		"alert":   alert,
+	})
+}
+
+// GetAlerts 获取价格提醒列表
+func (ctrl *AlertController) GetAlerts(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	query := ctrl.Config.DB.Where("user_id = ?", userID)
+	if symbol := c.Query("symbol"); symbol != "" {
+		query = query.Where("symbol = ?", strings.ToUpper(symbol))
+	}
+
+	var alerts []models.PriceAlert
+	if err := query.Order("created_at desc").Find(&alerts).Error; err != nil {
+		log.Printf("获取价格提醒失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取提醒列表失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// UpdateAlert 更新价格提醒，未传的字段保持不变
+func (ctrl *AlertController) UpdateAlert(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var alert models.PriceAlert
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&alert).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "提醒未找到或无权访问"})
+		return
+	}
+
+	var req struct {
+		Name            *string  `json:"name"`
+		Threshold       *float64 `json:"threshold"`
+		WindowMinutes   *int     `json:"windowMinutes"`
+		CooldownMinutes *int     `json:"cooldownMinutes"`
+		Repeat          *bool    `json:"repeat"`
+		Enabled         *bool    `json:"enabled"`
+		Note            *string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	if req.Name != nil {
+		alert.Name = *req.Name
+	}
+	if req.Threshold != nil {
+		alert.Threshold = *req.Threshold
+	}
+	if req.WindowMinutes != nil {
+		alert.WindowMinutes = *req.WindowMinutes
+	}
+	if req.CooldownMinutes != nil {
+		alert.CooldownMinutes = *req.CooldownMinutes
+	}
+	if req.Repeat != nil {
+		alert.Repeat = *req.Repeat
+	}
+	if req.Enabled != nil {
+		alert.Enabled = *req.Enabled
+	}
+	if req.Note != nil {
+		alert.Note = *req.Note
+	}
+	if msg := validatePriceAlert(&alert); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := ctrl.Config.DB.Save(&alert).Error; err != nil {
+		log.Printf("更新价格提醒失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新提醒失败"})
+		return
+	}
+	go tasks.RefreshPriceAlerts(ctrl.Config)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "提醒已更新",
+		"alert":   alert,
+	})
+}
+
+// DeleteAlert 删除价格提醒
+func (ctrl *AlertController) DeleteAlert(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	result := ctrl.Config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.PriceAlert{})
+	if result.Error != nil {
+		log.Printf("删除价格提醒失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除提醒失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "提醒未找到或无权访问"})
+		return
+	}
+	go tasks.RefreshPriceAlerts(ctrl.Config)
+
+	c.JSON(http.StatusOK, gin.H{"message": "提醒已删除"})
+}
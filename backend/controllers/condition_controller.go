@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+type ConditionController struct {
+	Config *config.Config
+}
+
+// DryRunCondition 按当前行情对触发条件求值，返回每个比较项的数值，不会下单
+func (ctrl *ConditionController) DryRunCondition(c *gin.Context) {
+	var req struct {
+		Market    string `json:"market"`
+		Symbol    string `json:"symbol" binding:"required"`
+		Condition string `json:"condition" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
+	}
+	if req.Market == "" {
+		req.Market = models.CandleMarketSpot
+	}
+	if req.Market != models.CandleMarketSpot && req.Market != models.CandleMarketFutures {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "市场必须为 spot 或 futures"})
+		return
+	}
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+
+	condition, err := services.ParseCondition(req.Condition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的触发条件", "details": err.Error()})
+		return
+	}
+
+	// 以最新一根1分钟K线的收盘价作为当前价格，合约为标记价格
+	var price float64
+	if candles, err := tasks.GetCandles(ctrl.Config.DB, req.Market, req.Symbol, "1m", 0, 1); err == nil && len(candles) > 0 {
+		price = candles[len(candles)-1].Close.InexactFloat64()
+	}
+
+	result, err := tasks.EvaluateCondition(ctrl.Config.DB, req.Market, req.Symbol, condition.String(), price)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的触发条件", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"market":     req.Market,
+		"symbol":     req.Symbol,
+		"condition":  condition.String(),
+		"timeframes": condition.Timeframes(),
+		"price":      price,
+		"result":     result.Result,
+		"terms":      result.Terms,
+	})
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type DCAController struct {
+	Config *config.Config
+}
+
+// validateDipMultipliers 校验加倍配置并序列化为JSON
+func validateDipMultipliers(items []models.DCADipMultiplier) (string, string) {
+	if len(items) == 0 {
+		return "", ""
+	}
+	for _, item := range items {
+		if item.DropPercent <= 0 || item.DropPercent >= 100 {
+			return "", "加倍配置的跌幅必须在0-100%之间"
+		}
+		if item.Multiplier <= 0 || item.Multiplier > 10 {
+			return "", "加倍配置的倍数必须在0-10之间"
+		}
+	}
+	data, _ := json.Marshal(items)
+	return string(data), ""
+}
+
+// CreateStrategy 创建定投策略
+func (ctrl *DCAController) CreateStrategy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Symbol         string                    `json:"symbol" binding:"required"`
+		ScheduleType   string                    `json:"scheduleType" binding:"required,oneof=daily weekly custom"`
+		ScheduleTime   string                    `json:"scheduleTime"`
+		Weekday        int                       `json:"weekday"`
+		CronExpr       string                    `json:"cronExpr"`
+		QuoteAmount    float64                   `json:"quoteAmount" binding:"required,gt=0"`
+		DipMultipliers []models.DCADipMultiplier `json:"dipMultipliers"`
+		MaxTotalQuote  float64                   `json:"maxTotalQuote" binding:"gte=0"`
+		Paper          bool                      `json:"paper"`
+		AccountID      uint                      `json:"accountId"` // 交易所账户，为0时使用默认账户
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := ctrl.Config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+		return
+	}
+	accountID, err := services.ResolveTradingAccountID(ctrl.Config.DB, user.ID, req.AccountID, models.AccountPermSpot, req.Paper || user.PaperTrading)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dipMultipliers, msg := validateDipMultipliers(req.DipMultipliers)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	strategy := models.DCAStrategy{
+		UserID:         userID.(uint),
+		AccountID:      accountID,
+		Symbol:         strings.ToUpper(req.Symbol),
+		ScheduleType:   req.ScheduleType,
+		ScheduleTime:   req.ScheduleTime,
+		Weekday:        req.Weekday,
+		CronExpr:       strings.TrimSpace(req.CronExpr),
+		QuoteAmount:    decimal.NewFromFloat(req.QuoteAmount),
+		DipMultipliers: dipMultipliers,
+		MaxTotalQuote:  decimal.NewFromFloat(req.MaxTotalQuote),
+		Enabled:        true,
+		Status:         "active",
+		Paper:          req.Paper,
+	}
+
+	next, err := tasks.NextDCARun(strategy, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的定投计划", "details": err.Error()})
+		return
+	}
+	strategy.NextRunAt = &next
+
+	if err := ctrl.Config.DB.Create(&strategy).Error; err != nil {
+		log.Printf("创建定投策略失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建策略失败"})
+		return
+	}
+
+	log.Printf("用户 %d 创建定投策略: %s 每次 %s，下次执行 %s",
+		strategy.UserID, strategy.Symbol, strategy.QuoteAmount, next.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "策略创建成功",
+		"strategy": strategy,
+	})
+}
+
+// GetStrategies 获取用户的定投策略列表
+func (ctrl *DCAController) GetStrategies(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var strategies []models.DCAStrategy
+	if err := ctrl.Config.DB.Where("user_id = ? AND deleted_at IS NULL", userID).
+		Order("created_at desc").
+		Find(&strategies).Error; err != nil {
+		log.Printf("获取定投策略失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取策略列表失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"strategies": strategies})
+}
+
+// UpdateStrategy 更新定投策略
+func (ctrl *DCAController) UpdateStrategy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	strategyID := c.Param("id")
+
+	var strategy models.DCAStrategy
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ? AND deleted_at IS NULL", strategyID, userID).
+		First(&strategy).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "策略未找到或无权访问"})
+		return
+	}
+
+	var req struct {
+		Enabled        *bool                      `json:"enabled"`
+		ScheduleType   *string                    `json:"scheduleType"`
+		ScheduleTime   *string                    `json:"scheduleTime"`
+		Weekday        *int                       `json:"weekday"`
+		CronExpr       *string                    `json:"cronExpr"`
+		QuoteAmount    *float64                   `json:"quoteAmount"`
+		DipMultipliers *[]models.DCADipMultiplier `json:"dipMultipliers"`
+		MaxTotalQuote  *float64                   `json:"maxTotalQuote"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	scheduleChanged := false
+	if req.ScheduleType != nil {
+		strategy.ScheduleType = *req.ScheduleType
+		scheduleChanged = true
+	}
+	if req.ScheduleTime != nil {
+		strategy.ScheduleTime = *req.ScheduleTime
+		scheduleChanged = true
+	}
+	if req.Weekday != nil {
+		strategy.Weekday = *req.Weekday
+		scheduleChanged = true
+	}
+	if req.CronExpr != nil {
+		strategy.CronExpr = strings.TrimSpace(*req.CronExpr)
+		scheduleChanged = true
+	}
+	if req.QuoteAmount != nil {
+		if *req.QuoteAmount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "每次投入金额必须大于0"})
+			return
+		}
+		updates["quote_amount"] = *req.QuoteAmount
+	}
+	if req.MaxTotalQuote != nil {
+		if *req.MaxTotalQuote < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "累计投入上限不能为负数"})
+			return
+		}
+		maxTotalQuote := decimal.NewFromFloat(*req.MaxTotalQuote)
+		updates["max_total_quote"] = maxTotalQuote
+		// 提高上限后已完成的策略恢复执行
+		if strategy.Status == "completed" && (maxTotalQuote.IsZero() || maxTotalQuote.GreaterThan(strategy.TotalQuote)) {
+			updates["status"] = "active"
+		}
+	}
+	if req.DipMultipliers != nil {
+		dipMultipliers, msg := validateDipMultipliers(*req.DipMultipliers)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updates["dip_multipliers"] = dipMultipliers
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+		// 重新启用时从当前时间重新计算，避免补执行停用期间的计划
+		if *req.Enabled && !strategy.Enabled {
+			scheduleChanged = true
+		}
+	}
+
+	if scheduleChanged {
+		next, err := tasks.NextDCARun(strategy, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的定投计划", "details": err.Error()})
+			return
+		}
+		updates["schedule_type"] = strategy.ScheduleType
+		updates["schedule_time"] = strategy.ScheduleTime
+		updates["weekday"] = strategy.Weekday
+		updates["cron_expr"] = strategy.CronExpr
+		updates["next_run_at"] = next
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有需要更新的字段"})
+		return
+	}
+
+	if err := ctrl.Config.DB.Model(&strategy).Updates(updates).Error; err != nil {
+		log.Printf("更新定投策略失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新策略失败"})
+		return
+	}
+
+	ctrl.Config.DB.First(&strategy, strategy.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "策略更新成功",
+		"strategy": strategy,
+	})
+}
+
+// DeleteStrategy 删除定投策略
+func (ctrl *DCAController) DeleteStrategy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	strategyID := c.Param("id")
+
+	result := ctrl.Config.DB.Where("id = ? AND user_id = ?", strategyID, userID).Delete(&models.DCAStrategy{})
+	if result.Error != nil {
+		log.Printf("删除定投策略失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除策略失败"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "策略未找到"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "策略删除成功"})
+}
+
+// GetOrders 获取定投策略的订单记录
+func (ctrl *DCAController) GetOrders(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	strategyID := c.Param("id")
+
+	var strategy models.DCAStrategy
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ? AND deleted_at IS NULL", strategyID, userID).
+		First(&strategy).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "策略未找到或无权访问"})
+		return
+	}
+
+	var orders []models.Order
+	if err := ctrl.Config.DB.Where("dca_strategy_id = ? AND deleted_at IS NULL", strategy.ID).
+		Order("created_at desc").
+		Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取订单失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":        orders,
+		"total":         len(orders),
+		"averageCost":   strategy.AverageCost,
+		"totalQuote":    strategy.TotalQuote,
+		"totalQuantity": strategy.TotalQuantity,
+	})
+}
@@ -9,10 +9,11 @@ import (
 	"time"
 
 	"context"
-	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -88,6 +89,7 @@ func (ctrl *DualInvestmentController) CreateStrategy(c *gin.Context) {
 		TriggerType  string  `json:"triggerType"`
 		// 梯度策略参数 - 直接接收数组
 		LadderConfig []models.LadderConfigItem `json:"ladderConfig"`
+		AccountID    uint                      `json:"accountId"` // 交易所账户，为0时使用默认账户
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -95,6 +97,12 @@ func (ctrl *DualInvestmentController) CreateStrategy(c *gin.Context) {
 		return
 	}
 
+	accountID, err := services.ResolveTradingAccountID(ctrl.Config.DB, userID.(uint), req.AccountID, models.AccountPermDCI, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 验证逻辑
 	if req.TargetAPYMax > 0 && req.TargetAPYMax < req.TargetAPYMin {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "最大年化收益率不能小于最小年化收益率"})
@@ -165,6 +173,7 @@ func (ctrl *DualInvestmentController) CreateStrategy(c *gin.Context) {
 	// 创建策略
 	strategy := models.DualInvestmentStrategy{
 		UserID:               userID.(uint),
+		AccountID:            accountID,
 		StrategyName:         req.StrategyName,
 		StrategyType:         req.StrategyType,
 		BaseAsset:            req.BaseAsset,
@@ -172,8 +181,8 @@ func (ctrl *DualInvestmentController) CreateStrategy(c *gin.Context) {
 		DirectionPreference:  req.DirectionPreference,
 		TargetAPYMin:         req.TargetAPYMin,
 		TargetAPYMax:         req.TargetAPYMax,
-		MaxSingleAmount:      req.MaxSingleAmount,
-		TotalInvestmentLimit: req.TotalInvestmentLimit,
+		MaxSingleAmount:      decimal.NewFromFloat(req.MaxSingleAmount),
+		TotalInvestmentLimit: decimal.NewFromFloat(req.TotalInvestmentLimit),
 		MaxStrikePriceOffset: req.MaxStrikePriceOffset,
 		MinDuration:          req.MinDuration,
 		MaxDuration:          req.MaxDuration,
@@ -346,7 +355,7 @@ func (ctrl *DualInvestmentController) UpdateStrategy(c *gin.Context) {
 	updates["updated_at"] = time.Now()
 
 	// 记录更新前的数据（用于日志）
-	log.Printf("更新策略 %s 前: enabled=%v, target_apy_min=%.2f, target_apy_max=%.2f, max_single_amount=%.2f",
+	log.Printf("更新策略 %s 前: enabled=%v, target_apy_min=%.2f, target_apy_max=%.2f, max_single_amount=%s",
 		strategyID, strategy.Enabled, strategy.TargetAPYMin, strategy.TargetAPYMax, strategy.MaxSingleAmount)
 
 	// 执行更新
@@ -360,7 +369,7 @@ func (ctrl *DualInvestmentController) UpdateStrategy(c *gin.Context) {
 	if err := ctrl.Config.DB.First(&strategy, strategyID).Error; err != nil {
 		log.Printf("重新查询策略失败: %v", err)
 	} else {
-		log.Printf("更新策略 %s 后: enabled=%v, target_apy_min=%.2f, target_apy_max=%.2f, max_single_amount=%.2f",
+		log.Printf("更新策略 %s 后: enabled=%v, target_apy_min=%.2f, target_apy_max=%.2f, max_single_amount=%s",
 			strategyID, strategy.Enabled, strategy.TargetAPYMin, strategy.TargetAPYMax, strategy.MaxSingleAmount)
 	}
 
@@ -453,6 +462,7 @@ func (ctrl *DualInvestmentController) CreateOrder(c *gin.Context) {
 		ProductID    uint    `json:"productId" binding:"required"`
 		InvestAmount float64 `json:"investAmount" binding:"required,gt=0"`
 		StrategyID   *uint   `json:"strategyId"` // 可选，手动下单时为空
+		AccountID    uint    `json:"accountId"`  // 交易所账户，为0时使用策略账户或默认账户
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -467,11 +477,6 @@ func (ctrl *DualInvestmentController) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	if user.APIKey == "" || user.SecretKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请先设置API密钥"})
-		return
-	}
-
 	// 获取产品信息
 	var product models.DualInvestmentProduct
 	if err := ctrl.Config.DB.First(&product, req.ProductID).Error; err != nil {
@@ -503,29 +508,39 @@ func (ctrl *DualInvestmentController) CreateOrder(c *gin.Context) {
 		}
 
 		// 检查策略限额
-		if strategy.CurrentInvested+req.InvestAmount > strategy.TotalInvestmentLimit {
+		if strategy.CurrentInvested.Add(decimal.NewFromFloat(req.InvestAmount)).GreaterThan(strategy.TotalInvestmentLimit) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "超出策略总投资限额"})
 			return
 		}
+		if req.AccountID == 0 {
+			req.AccountID = strategy.AccountID
+		}
+	}
+
+	accountID, err := services.ResolveTradingAccountID(ctrl.Config.DB, user.ID, req.AccountID, models.AccountPermDCI, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// TODO: 调用币安API创建订单
 	// 这里需要根据币安实际的双币投资API进行调整
 	/*
-		client := binance.NewClient(user.APIKey, user.SecretKey)
-		// 调用双币投资下单接口
+		exchange, err := services.NewUserExchange(ctrl.Config.DB, user.ID, accountID)
+		// 调用双币投资下单接口 exchange.SubscribeDCIProduct
 	*/
 
 	// 创建订单记录
 	order := models.DualInvestmentOrder{
 		UserID:         userID.(uint),
+		AccountID:      accountID,
 		StrategyID:     req.StrategyID,
 		ProductID:      req.ProductID,
 		OrderID:        fmt.Sprintf("DUAL_%d_%d", userID, time.Now().Unix()), // 临时订单号
 		Symbol:         product.Symbol,
 		InvestAsset:    product.BaseAsset, // 根据产品类型确定
-		InvestAmount:   req.InvestAmount,
-		StrikePrice:    product.StrikePrice,
+		InvestAmount:   decimal.NewFromFloat(req.InvestAmount),
+		StrikePrice:    decimal.NewFromFloat(product.StrikePrice),
 		APY:            product.APY,
 		Direction:      product.Direction,
 		Duration:       product.Duration,
@@ -534,7 +549,7 @@ func (ctrl *DualInvestmentController) CreateOrder(c *gin.Context) {
 	}
 
 	// 开启事务
-	err := ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
+	err = ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
 		// 创建订单
 		if err := tx.Create(&order).Error; err != nil {
 			return err
@@ -559,7 +574,7 @@ func (ctrl *DualInvestmentController) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	log.Printf("用户 %d 创建双币投资订单: %s, 金额: %.2f", userID, order.Symbol, order.InvestAmount)
+	log.Printf("用户 %d 创建双币投资订单: %s, 金额: %s", userID, order.Symbol, order.InvestAmount.StringFixed(2))
 	c.JSON(http.StatusOK, gin.H{
 		"message": "订单创建成功",
 		"order":   order,
@@ -613,8 +628,9 @@ func (ctrl *DualInvestmentController) GetStats(c *gin.Context) {
 		return
 	}
 
-	// 如果用户没有设置API密钥，返回空统计
-	if user.APIKey == "" || user.SecretKey == "" {
+	// 如果用户没有设置交易所账户，返回空统计
+	exchangeAccount, err := services.ResolveAccount(ctrl.Config.DB, user.ID, 0)
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"stats": models.DualInvestmentStats{
 				UserID: userID.(uint),
@@ -623,38 +639,18 @@ func (ctrl *DualInvestmentController) GetStats(c *gin.Context) {
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		log.Printf("解密用户 %d API Key失败: %v", user.ID, err)
-		// 如果解密失败，使用本地数据
-		stats := ctrl.getLocalStats(userID.(uint))
-		c.JSON(http.StatusOK, gin.H{"stats": stats})
-		return
-	}
-
-	secretKey, err := user.GetDecryptedSecretKey()
+	// 从币安获取双币投资统计数据
+	exchange, err := services.NewAccountExchange(exchangeAccount)
 	if err != nil {
-		log.Printf("解密用户 %d Secret Key失败: %v", user.ID, err)
-		// 如果解密失败，使用本地数据
+		log.Printf("用户 %d 创建交易所客户端失败: %v", user.ID, err)
+		// 如果创建失败，使用本地数据
 		stats := ctrl.getLocalStats(userID.(uint))
 		c.JSON(http.StatusOK, gin.H{"stats": stats})
 		return
 	}
 
-	// 检查解密后的密钥是否为空
-	if apiKey == "" || secretKey == "" {
-		log.Printf("用户 %d 解密后的API密钥为空", user.ID)
-		stats := ctrl.getLocalStats(userID.(uint))
-		c.JSON(http.StatusOK, gin.H{"stats": stats})
-		return
-	}
-
-	// 从币安获取双币投资统计数据
-	client := binance.NewClient(apiKey, secretKey)
-
 	// 获取账户总览信息
-	account, err := client.NewGetAccountService().Do(context.Background())
+	account, err := exchange.GetAccount(context.Background())
 	if err != nil {
 		log.Printf("获取币安账户信息失败: %v", err)
 		// 如果API失败，使用本地数据
@@ -678,7 +674,7 @@ func (ctrl *DualInvestmentController) GetStats(c *gin.Context) {
 				// 其他资产需要转换为USDT价值
 				// 这里简化处理，实际应该获取实时价格
 				symbol := balance.Asset + "USDT"
-				prices, err := client.NewListPricesService().Symbol(symbol).Do(context.Background())
+				prices, err := exchange.ListPrices(context.Background(), symbol)
 				if err == nil && len(prices) > 0 {
 					price, _ := strconv.ParseFloat(prices[0].Price, 64)
 					totalAssetValue += total * price
@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ExchangeAccountController struct {
+	Config *config.Config
+}
+
+// accountResponse 账户信息，API密钥只返回掩码
+func accountResponse(account models.ExchangeAccount) gin.H {
+	maskedAPIKey, maskedSecretKey := "解密失败", "解密失败"
+	if apiKey, err := account.GetDecryptedAPIKey(); err == nil {
+		maskedAPIKey = maskAPIKey(apiKey)
+	}
+	if secretKey, err := account.GetDecryptedSecretKey(); err == nil {
+		maskedSecretKey = maskAPIKey(secretKey)
+	}
+	permissions := []string{}
+	for _, p := range strings.Split(account.Permissions, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			permissions = append(permissions, p)
+		}
+	}
+	return gin.H{
+		"id":          account.ID,
+		"label":       account.Label,
+		"apiKey":      maskedAPIKey,
+		"secretKey":   maskedSecretKey,
+		"permissions": permissions,
+		"environment": account.Environment,
+		"baseUrl":     account.BaseURL,
+		"isDefault":   account.IsDefault,
+		"status":      account.Status,
+		"createdAt":   account.CreatedAt,
+		"updatedAt":   account.UpdatedAt,
+	}
+}
+
+// normalizePermissions 校验并去重账户权限
+func normalizePermissions(permissions []string) (string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, p := range permissions {
+		p = strings.ToLower(strings.TrimSpace(p))
+		valid := false
+		for _, allowed := range models.AccountPermissions {
+			if p == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("无效的账户权限: %s", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// accountBaseURL 只有自定义环境保存交易所地址
+func accountBaseURL(environment, baseURL string) string {
+	if environment != models.AccountEnvCustom {
+		return ""
+	}
+	return strings.TrimRight(strings.TrimSpace(baseURL), "/")
+}
+
+// setDefaultAccount 将账户设为用户的默认账户
+func setDefaultAccount(tx *gorm.DB, userID, accountID uint) error {
+	if err := tx.Model(&models.ExchangeAccount{}).Where("user_id = ? AND id <> ?", userID, accountID).
+		Update("is_default", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.ExchangeAccount{}).Where("user_id = ? AND id = ?", userID, accountID).
+		Update("is_default", true).Error
+}
+
+// deleteAccount 删除账户；删除默认账户时把最早的账户设为默认，删除最后一个账户时一并清除用户表中的旧密钥
+func deleteAccount(tx *gorm.DB, account *models.ExchangeAccount) error {
+	if err := tx.Delete(account).Error; err != nil {
+		return err
+	}
+	var next models.ExchangeAccount
+	err := tx.Where("user_id = ?", account.UserID).Order("id").First(&next).Error
+	if err == gorm.ErrRecordNotFound {
+		return tx.Model(&models.User{}).Where("id = ?", account.UserID).
+			Updates(map[string]interface{}{"api_key": "", "secret_key": ""}).Error
+	}
+	if err != nil {
+		return err
+	}
+	if account.IsDefault {
+		return setDefaultAccount(tx, account.UserID, next.ID)
+	}
+	return nil
+}
+
+// GetAccounts 获取用户的交易所账户列表
+func (ctrl *ExchangeAccountController) GetAccounts(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var accounts []models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("user_id = ?", userID).Order("is_default desc, id").Find(&accounts).Error; err != nil {
+		log.Printf("获取交易所账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取交易所账户失败"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, accountResponse(account))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"accounts":           result,
+		"permissions":        models.AccountPermissions,
+		"environments":       models.AccountEnvironments,
+		"defaultEnvironment": ctrl.Config.BinanceEnv,
+	})
+}
+
+// CreateAccount 添加交易所账户，用户的第一个账户自动成为默认账户
+func (ctrl *ExchangeAccountController) CreateAccount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Label       string   `json:"label" binding:"required,max=100"`
+		APIKey      string   `json:"apiKey" binding:"required"`
+		APISecret   string   `json:"apiSecret" binding:"required"`
+		Permissions []string `json:"permissions" binding:"required,min=1"`
+		Environment string   `json:"environment" binding:"omitempty,oneof=production spot_testnet futures_testnet custom"`
+		BaseURL     string   `json:"baseUrl" binding:"omitempty,max=255"`
+		IsDefault   bool     `json:"isDefault"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
+	}
+	permissions, err := normalizePermissions(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	baseURL := accountBaseURL(req.Environment, req.BaseURL)
+	if err := services.ValidateAccountEnvironment(req.Environment, baseURL, permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account := models.ExchangeAccount{
+		UserID:      userID.(uint),
+		Label:       strings.TrimSpace(req.Label),
+		APIKey:      strings.TrimSpace(req.APIKey),
+		SecretKey:   strings.TrimSpace(req.APISecret),
+		Permissions: permissions,
+		Environment: req.Environment,
+		BaseURL:     baseURL,
+		Status:      "active",
+	}
+	err = ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ExchangeAccount{}).Where("user_id = ?", account.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		if count == 0 || req.IsDefault {
+			account.IsDefault = true
+			return setDefaultAccount(tx, account.UserID, account.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("创建交易所账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建交易所账户失败"})
+		return
+	}
+
+	log.Printf("用户 %d 添加交易所账户 %d: %s", account.UserID, account.ID, account.Label)
+	c.JSON(http.StatusOK, gin.H{"message": "交易所账户创建成功", "account": accountResponse(account)})
+}
+
+// UpdateAccount 更新交易所账户，API密钥留空时保持不变
+func (ctrl *ExchangeAccountController) UpdateAccount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var account models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "交易所账户未找到"})
+		return
+	}
+
+	var req struct {
+		Label       *string   `json:"label" binding:"omitempty,max=100"`
+		APIKey      string    `json:"apiKey"`
+		APISecret   string    `json:"apiSecret"`
+		Permissions *[]string `json:"permissions"`
+		Environment *string   `json:"environment" binding:"omitempty,oneof=production spot_testnet futures_testnet custom"`
+		BaseURL     *string   `json:"baseUrl" binding:"omitempty,max=255"`
+		Status      *string   `json:"status" binding:"omitempty,oneof=active disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
+	}
+
+	if req.Label != nil {
+		account.Label = strings.TrimSpace(*req.Label)
+	}
+	if (req.APIKey == "") != (req.APISecret == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API Key 和 Secret Key 需要同时更新"})
+		return
+	}
+	if req.APIKey != "" {
+		account.APIKey = strings.TrimSpace(req.APIKey)
+		account.SecretKey = strings.TrimSpace(req.APISecret)
+	}
+	if req.Permissions != nil {
+		permissions, err := normalizePermissions(*req.Permissions)
+		if err != nil || permissions == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要一个有效的账户权限"})
+			return
+		}
+		account.Permissions = permissions
+	}
+	if req.Environment != nil {
+		account.Environment = *req.Environment
+	}
+	if req.BaseURL != nil {
+		account.BaseURL = *req.BaseURL
+	}
+	account.BaseURL = accountBaseURL(account.Environment, account.BaseURL)
+	if err := services.ValidateAccountEnvironment(account.Environment, account.BaseURL, account.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status != nil {
+		account.Status = *req.Status
+	}
+
+	if err := ctrl.Config.DB.Save(&account).Error; err != nil {
+		log.Printf("更新交易所账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新交易所账户失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "交易所账户更新成功", "account": accountResponse(account)})
+}
+
+// DeleteAccount 删除交易所账户，仍有运行中的策略或提币规则时拒绝删除
+func (ctrl *ExchangeAccountController) DeleteAccount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var account models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "交易所账户未找到"})
+		return
+	}
+
+	inUse := []struct {
+		model    interface{}
+		name     string
+		hasPaper bool
+	}{
+		{&models.Strategy{}, "现货策略", true},
+		{&models.FuturesStrategy{}, "合约策略", true},
+		{&models.DualInvestmentStrategy{}, "双币投资策略", false},
+		{&models.DCAStrategy{}, "定投策略", true},
+		{&models.Withdrawal{}, "提币规则", false},
+	}
+	for _, item := range inUse {
+		query := ctrl.Config.DB.Model(item.model).
+			Where("user_id = ? AND account_id = ? AND enabled = ?", account.UserID, account.ID, true)
+		// 模拟盘策略不使用交易所账户
+		if item.hasPaper {
+			query = query.Where("paper = ?", false)
+		}
+		var count int64
+		query.Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该账户仍有 %d 个启用中的%s，请先停用", count, item.name)})
+			return
+		}
+	}
+
+	if err := ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteAccount(tx, &account)
+	}); err != nil {
+		log.Printf("删除交易所账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除交易所账户失败"})
+		return
+	}
+
+	log.Printf("用户 %d 删除交易所账户 %d", account.UserID, account.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "交易所账户删除成功"})
+}
+
+// SetDefaultAccount 设置默认交易所账户
+func (ctrl *ExchangeAccountController) SetDefaultAccount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var account models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "交易所账户未找到"})
+		return
+	}
+
+	if err := ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
+		return setDefaultAccount(tx, account.UserID, account.ID)
+	}); err != nil {
+		log.Printf("设置默认交易所账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "设置默认账户失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "默认账户设置成功"})
+}
@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net/http"
@@ -10,11 +12,13 @@ import (
 	"strings"
 	"time"
 
-	"github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 )
 
 type FuturesController struct {
@@ -26,22 +30,29 @@ func (ctrl *FuturesController) CreateStrategy(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
 	var req struct {
-		StrategyName       string    `json:"strategyName" binding:"required"`
-		Symbol             string    `json:"symbol" binding:"required"`
-		Side               string    `json:"side" binding:"required,oneof=LONG SHORT"`
-		StrategyType       string    `json:"strategyType" binding:"omitempty,oneof=simple iceberg slow_iceberg"`
-		BasePrice          float64   `json:"basePrice" binding:"required,gt=0"`
-		EntryPriceFloat    float64   `json:"entryPriceFloat"` // 移除 binding，允许为0
-		Leverage           int       `json:"leverage" binding:"required,min=1,max=125"`
-		Quantity           float64   `json:"quantity" binding:"required,gt=0"`
-		TakeProfitRate     float64   `json:"takeProfitRate" binding:"required,gt=0"`
-		StopLossRate       float64   `json:"stopLossRate"` // 移除 binding，允许为0
-		MarginType         string    `json:"marginType" binding:"omitempty,oneof=ISOLATED CROSSED"`
-		IcebergLevels      int       `json:"icebergLevels" binding:"omitempty,min=2,max=10"`
-		IcebergQuantities  []float64 `json:"icebergQuantities"`
-		IcebergPriceGaps   []float64 `json:"icebergPriceGaps"`
-		SlowIcebergTimeout int       `json:"slowIcebergTimeout" binding:"omitempty,min=1,max=60"` // 添加慢冰山超时字段
-		AutoRestart        bool      `json:"autoRestart"`                                         // 添加自动重启字段
+		StrategyName            string    `json:"strategyName" binding:"required"`
+		Symbol                  string    `json:"symbol" binding:"required"`
+		Side                    string    `json:"side" binding:"required,oneof=LONG SHORT"`
+		StrategyType            string    `json:"strategyType" binding:"omitempty,oneof=simple iceberg slow_iceberg"`
+		BasePrice               float64   `json:"basePrice" binding:"gte=0"`
+		EntryPriceFloat         float64   `json:"entryPriceFloat"` // 移除 binding，允许为0
+		Leverage                int       `json:"leverage" binding:"required,min=1,max=125"`
+		Quantity                float64   `json:"quantity" binding:"required,gt=0"`
+		TakeProfitRate          float64   `json:"takeProfitRate" binding:"required,gt=0"`
+		StopLossRate            float64   `json:"stopLossRate"` // 移除 binding，允许为0
+		MarginType              string    `json:"marginType" binding:"omitempty,oneof=ISOLATED CROSSED"`
+		IcebergLevels           int       `json:"icebergLevels" binding:"omitempty,min=2,max=10"`
+		IcebergQuantities       []float64 `json:"icebergQuantities"`
+		IcebergPriceGaps        []float64 `json:"icebergPriceGaps"`
+		SlowIcebergTimeout      int       `json:"slowIcebergTimeout" binding:"omitempty,min=1,max=60"`   // 添加慢冰山超时字段
+		AutoRestart             bool      `json:"autoRestart"`                                           // 添加自动重启字段
+		Paper                   bool      `json:"paper"`                                                 // 模拟盘策略
+		TrailingMode            string    `json:"trailingMode" binding:"omitempty,oneof=exchange local"` // 跟踪止损模式
+		TrailingCallbackRate    float64   `json:"trailingCallbackRate"`                                  // 跟踪回调百分比
+		TrailingActivationPrice float64   `json:"trailingActivationPrice" binding:"omitempty,gte=0"`     // 跟踪激活价格
+		TrailingTakeProfit      bool      `json:"trailingTakeProfit"`                                    // 跟踪止盈
+		TriggerCondition        string    `json:"triggerCondition"`                                      // 指标触发条件
+		AccountID               uint      `json:"accountId"`                                             // 交易所账户，为0时使用默认账户
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -49,6 +60,27 @@ func (ctrl *FuturesController) CreateStrategy(c *gin.Context) {
 		return
 	}
 
+	var user models.User
+	if err := ctrl.Config.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+		return
+	}
+	accountID, err := services.ResolveTradingAccountID(ctrl.Config.DB, user.ID, req.AccountID, models.AccountPermFutures, req.Paper || user.PaperTrading)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.TriggerCondition = strings.TrimSpace(req.TriggerCondition)
+	if err := validateTriggerCondition(decimal.NewFromFloat(req.BasePrice), req.TriggerCondition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateTrailing(req.TrailingMode, req.TrailingCallbackRate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 设置默认值
 	if req.StrategyType == "" {
 		req.StrategyType = "simple"
@@ -123,26 +155,33 @@ func (ctrl *FuturesController) CreateStrategy(c *gin.Context) {
 
 	// 创建策略
 	strategy := models.FuturesStrategy{
-		UserID:             userID.(uint),
-		StrategyName:       req.StrategyName,
-		Symbol:             req.Symbol,
-		Side:               req.Side,
-		StrategyType:       req.StrategyType,
-		BasePrice:          req.BasePrice,
-		EntryPrice:         0, // 开仓价格将在触发时计算
-		EntryPriceFloat:    req.EntryPriceFloat,
-		Leverage:           req.Leverage,
-		Quantity:           req.Quantity,
-		TakeProfitRate:     req.TakeProfitRate,
-		StopLossRate:       req.StopLossRate,
-		MarginType:         req.MarginType,
-		IcebergLevels:      req.IcebergLevels,
-		IcebergQuantities:  icebergQuantitiesStr,
-		IcebergPriceGaps:   icebergPriceGapsStr,
-		SlowIcebergTimeout: req.SlowIcebergTimeout, // 添加慢冰山超时字段
-		AutoRestart:        req.AutoRestart,        // 添加自动重启字段
-		Enabled:            true,
-		Status:             "waiting",
+		UserID:                  userID.(uint),
+		AccountID:               accountID,
+		StrategyName:            req.StrategyName,
+		Symbol:                  req.Symbol,
+		Side:                    req.Side,
+		StrategyType:            req.StrategyType,
+		BasePrice:               decimal.NewFromFloat(req.BasePrice),
+		EntryPrice:              decimal.Zero, // 开仓价格将在触发时计算
+		EntryPriceFloat:         req.EntryPriceFloat,
+		Leverage:                req.Leverage,
+		Quantity:                decimal.NewFromFloat(req.Quantity),
+		TakeProfitRate:          req.TakeProfitRate,
+		StopLossRate:            req.StopLossRate,
+		MarginType:              req.MarginType,
+		IcebergLevels:           req.IcebergLevels,
+		IcebergQuantities:       icebergQuantitiesStr,
+		IcebergPriceGaps:        icebergPriceGapsStr,
+		SlowIcebergTimeout:      req.SlowIcebergTimeout, // 添加慢冰山超时字段
+		AutoRestart:             req.AutoRestart,        // 添加自动重启字段
+		Paper:                   req.Paper,
+		TrailingMode:            req.TrailingMode,
+		TrailingCallbackRate:    req.TrailingCallbackRate,
+		TrailingActivationPrice: decimal.NewFromFloat(req.TrailingActivationPrice),
+		TrailingTakeProfit:      req.TrailingTakeProfit,
+		TriggerCondition:        req.TriggerCondition,
+		Enabled:                 true,
+		Status:                  "waiting",
 	}
 
 	// 暂时不计算止盈止损价格，将在触发时根据实际开仓价格计算
@@ -160,6 +199,43 @@ func (ctrl *FuturesController) CreateStrategy(c *gin.Context) {
 	})
 }
 
+// validateTrailing 校验跟踪止损配置：币安跟踪止损单回调比例范围为0.1%~10%，本地跟踪需小于100%
+func validateTrailing(mode string, callbackRate float64) error {
+	switch mode {
+	case "":
+		return nil
+	case "exchange":
+		// 币安要求回调比例在0.1到10之间，步长0.1
+		if callbackRate < 0.1 || callbackRate > 10 {
+			return fmt.Errorf("交易所跟踪止损的回调比例必须在0.1到10之间")
+		}
+		if !decimal.NewFromFloat(callbackRate).Shift(1).IsInteger() {
+			return fmt.Errorf("交易所跟踪止损的回调比例最多保留1位小数")
+		}
+	case "local":
+		if callbackRate <= 0 || callbackRate >= 100 {
+			return fmt.Errorf("本地跟踪止损的回调比例必须大于0且小于100")
+		}
+	default:
+		return fmt.Errorf("无效的跟踪止损模式: %s", mode)
+	}
+	return nil
+}
+
+// validateTriggerCondition 校验触发方式：基准价格和指标条件至少设置一个
+func validateTriggerCondition(basePrice decimal.Decimal, condition string) error {
+	if condition == "" {
+		if !basePrice.IsPositive() {
+			return fmt.Errorf("基准价格必须大于0，或设置指标触发条件")
+		}
+		return nil
+	}
+	if _, err := services.ParseCondition(condition); err != nil {
+		return fmt.Errorf("无效的触发条件: %v", err)
+	}
+	return nil
+}
+
 // GetStrategies 获取用户的永续期货策略列表
 func (ctrl *FuturesController) GetStrategies(c *gin.Context) {
 	// 获取用户ID并确保类型正确
@@ -238,18 +314,24 @@ func (ctrl *FuturesController) UpdateStrategy(c *gin.Context) {
 
 	// 允许更新的字段映射（前端字段名 -> 数据库字段名）
 	allowedFields := map[string]string{
-		"strategyName":       "strategy_name",
-		"enabled":            "enabled",
-		"basePrice":          "base_price",
-		"entryPriceFloat":    "entry_price_float",
-		"quantity":           "quantity",
-		"takeProfitRate":     "take_profit_rate",
-		"stopLossRate":       "stop_loss_rate",
-		"icebergLevels":      "iceberg_levels",
-		"icebergQuantities":  "iceberg_quantities",
-		"icebergPriceGaps":   "iceberg_price_gaps",
-		"slowIcebergTimeout": "slow_iceberg_timeout", // 添加慢冰山超时字段
-		"autoRestart":        "auto_restart",
+		"strategyName":            "strategy_name",
+		"enabled":                 "enabled",
+		"basePrice":               "base_price",
+		"entryPriceFloat":         "entry_price_float",
+		"quantity":                "quantity",
+		"takeProfitRate":          "take_profit_rate",
+		"stopLossRate":            "stop_loss_rate",
+		"icebergLevels":           "iceberg_levels",
+		"icebergQuantities":       "iceberg_quantities",
+		"icebergPriceGaps":        "iceberg_price_gaps",
+		"slowIcebergTimeout":      "slow_iceberg_timeout", // 添加慢冰山超时字段
+		"autoRestart":             "auto_restart",
+		"paper":                   "paper",
+		"trailingMode":            "trailing_mode",
+		"trailingCallbackRate":    "trailing_callback_rate",
+		"trailingActivationPrice": "trailing_activation_price",
+		"trailingTakeProfit":      "trailing_take_profit",
+		"triggerCondition":        "trigger_condition",
 	}
 
 	updates := make(map[string]interface{})
@@ -286,6 +368,39 @@ func (ctrl *FuturesController) UpdateStrategy(c *gin.Context) {
 		}
 	}
 
+	// 校验更新后的跟踪止损配置
+	trailingMode, trailingCallbackRate := strategy.TrailingMode, strategy.TrailingCallbackRate
+	if v, ok := updates["trailing_mode"].(string); ok {
+		trailingMode = v
+	} else if _, ok := updates["trailing_mode"]; ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的跟踪止损模式"})
+		return
+	}
+	if v, ok := updates["trailing_callback_rate"].(float64); ok {
+		trailingCallbackRate = v
+	}
+	if err := validateTrailing(trailingMode, trailingCallbackRate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 校验更新后的触发方式
+	basePrice, triggerCondition := strategy.BasePrice, strategy.TriggerCondition
+	if v, ok := updates["base_price"].(float64); ok {
+		basePrice = decimal.NewFromFloat(v)
+	}
+	if v, ok := updates["trigger_condition"].(string); ok {
+		triggerCondition = strings.TrimSpace(v)
+		updates["trigger_condition"] = triggerCondition
+	} else if _, ok := updates["trigger_condition"]; ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的触发条件"})
+		return
+	}
+	if err := validateTriggerCondition(basePrice, triggerCondition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 记录更新内容，便于调试
 	log.Printf("更新策略 %s，更新内容: %+v", strategyID, updates)
 
@@ -317,16 +432,17 @@ func (ctrl *FuturesController) UpdateStrategy(c *gin.Context) {
 		ctrl.Config.DB.First(&strategy, strategyID)
 
 		// 计算预估的开仓价格（基于触发价格的估算）
-		if strategy.BasePrice > 0 {
+		if strategy.BasePrice.IsPositive() {
 			// 这里使用触发价格作为预估，实际开仓价格将在触发时根据买卖价计算
 			estimatedEntryPrice := strategy.BasePrice
 			if strategy.EntryPriceFloat > 0 {
+				floatRate := decimal.NewFromFloat(strategy.EntryPriceFloat / 10000)
 				if strategy.Side == "LONG" {
 					// 做多时，开仓价格会低于触发价格
-					estimatedEntryPrice = strategy.BasePrice * (1 - strategy.EntryPriceFloat/10000)
+					estimatedEntryPrice = strategy.BasePrice.Mul(decimal.NewFromInt(1).Sub(floatRate))
 				} else {
 					// 做空时，开仓价格会高于触发价格
-					estimatedEntryPrice = strategy.BasePrice * (1 + strategy.EntryPriceFloat/10000)
+					estimatedEntryPrice = strategy.BasePrice.Mul(decimal.NewFromInt(1).Add(floatRate))
 				}
 			}
 
@@ -403,6 +519,97 @@ func (ctrl *FuturesController) DeleteStrategy(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "策略删除成功"})
 }
 
+// Backtest 用历史标记价格/成交数据回测期货策略
+// multipart 表单字段：tradeFile（成交或K线，可多个CSV或zip）、markFile（标记价格K线，可选）、fundingFile（资金费率，可选）、
+// strategyId（回测已有策略）或 strategy（策略JSON）、options（回测参数JSON）
+func (ctrl *FuturesController) Backtest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var strategy models.FuturesStrategy
+	if idStr := c.PostForm("strategyId"); idStr != "" {
+		if err := ctrl.Config.DB.Where("id = ? AND user_id = ? AND deleted_at IS NULL", idStr, userID).
+			First(&strategy).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "策略未找到或无权访问"})
+			return
+		}
+	} else if raw := c.PostForm("strategy"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &strategy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的策略配置", "details": err.Error()})
+			return
+		}
+		strategy.Symbol = strings.ToUpper(strategy.Symbol)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供 strategyId 或 strategy"})
+		return
+	}
+
+	var opts tasks.FuturesBacktestOptions
+	if raw := c.PostForm("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的回测参数", "details": err.Error()})
+			return
+		}
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["tradeFile"])+len(form.File["markFile"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传历史数据文件"})
+		return
+	}
+
+	var data tasks.FuturesBacktestData
+	for field, load := range map[string]func([]byte) error{
+		"tradeFile": func(b []byte) error {
+			ticks, err := tasks.ReadBacktestData(b)
+			data.Trades = append(data.Trades, ticks...)
+			return err
+		},
+		"markFile": func(b []byte) error {
+			ticks, err := tasks.ReadBacktestData(b)
+			data.MarkPrices = append(data.MarkPrices, ticks...)
+			return err
+		},
+		"fundingFile": func(b []byte) error {
+			rates, err := tasks.ReadFundingRateData(b)
+			data.FundingRates = append(data.FundingRates, rates...)
+			return err
+		},
+	} {
+		for _, header := range form.File[field] {
+			f, err := header.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败", "details": err.Error()})
+				return
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败", "details": err.Error()})
+				return
+			}
+			if err := load(content); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "解析历史数据失败: " + header.Filename, "details": err.Error()})
+				return
+			}
+		}
+	}
+
+	// 获取合约精度，失败时使用默认精度
+	if symbolInfo, err := services.FuturesSymbolInfo(context.Background(), services.DefaultEndpoints(), strategy.Symbol); err != nil {
+		log.Printf("回测获取期货交易所信息失败，使用默认精度: %v", err)
+	} else {
+		opts.SymbolInfo = symbolInfo
+	}
+
+	result, err := tasks.RunFuturesBacktest(strategy, data, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "回测失败", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 // GetOrders 获取策略相关订单
 func (ctrl *FuturesController) GetOrders(c *gin.Context) {
 	userID, _ := c.Get("user_id")
@@ -480,20 +687,23 @@ func (ctrl *FuturesController) GetStats(c *gin.Context) {
 	ctrl.Config.DB.Where("user_id = ? AND status = ?", userID, "closed").
 		Find(&positions)
 
+	totalPnl := decimal.Zero
 	for _, pos := range positions {
-		if pos.RealizedPnl > 0 {
+		pnl := pos.RealizedPnl.InexactFloat64()
+		if pos.RealizedPnl.IsPositive() {
 			stats.WinTrades++
-			if pos.RealizedPnl > stats.MaxWin {
-				stats.MaxWin = pos.RealizedPnl
+			if pnl > stats.MaxWin {
+				stats.MaxWin = pnl
 			}
-		} else if pos.RealizedPnl < 0 {
+		} else if pos.RealizedPnl.IsNegative() {
 			stats.LossTrades++
-			if pos.RealizedPnl < stats.MaxLoss {
-				stats.MaxLoss = pos.RealizedPnl
+			if pnl < stats.MaxLoss {
+				stats.MaxLoss = pnl
 			}
 		}
-		stats.TotalPnl += pos.RealizedPnl
+		totalPnl = totalPnl.Add(pos.RealizedPnl)
 	}
+	stats.TotalPnl = totalPnl.InexactFloat64()
 
 	// 计算胜率
 	if stats.TotalTrades > 0 {
@@ -528,7 +738,7 @@ func (ctrl *FuturesController) GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"stats": stats})
 }
 
-// GetFuturesBalance 获取期货账户余额
+// GetFuturesBalance 获取期货账户余额，实盘汇总所有开通合约权限的交易所账户，指定 accountId 时只查询该账户
 func (ctrl *FuturesController) GetFuturesBalance(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
@@ -539,59 +749,109 @@ func (ctrl *FuturesController) GetFuturesBalance(c *gin.Context) {
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "解密API Key失败"})
+	// 模拟盘用户显示模拟账户
+	if user.PaperTrading {
+		account, err := services.NewUserPaperExchange(ctrl.Config.DB, user.ID).GetFuturesAccount(context.Background())
+		if err != nil {
+			log.Printf("获取期货账户信息失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取账户信息失败"})
+			return
+		}
+		availableBalance, _ := strconv.ParseFloat(account.AvailableBalance, 64)
+		totalBalance, _ := strconv.ParseFloat(account.TotalWalletBalance, 64)
+		c.JSON(http.StatusOK, gin.H{
+			"availableBalance": availableBalance,
+			"totalBalance":     totalBalance,
+			"assets":           account.Assets, // 资产详情
+			"paper":            true,
+		})
 		return
 	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "解密Secret Key失败"})
+
+	query := ctrl.Config.DB.Where("user_id = ? AND status = ?", user.ID, "active")
+	if accountID := c.Query("accountId"); accountID != "" {
+		query = query.Where("id = ?", accountID)
+	}
+	var exchangeAccounts []models.ExchangeAccount
+	if err := query.Order("is_default desc, id").Find(&exchangeAccounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取交易所账户失败"})
 		return
 	}
 
-	// 创建期货客户端
-	client := binance.NewFuturesClient(apiKey, secretKey)
+	var availableTotal, walletTotal float64
+	var assets []*futures.AccountAsset
+	breakdown := make([]gin.H, 0, len(exchangeAccounts))
+	for i := range exchangeAccounts {
+		exchangeAccount := &exchangeAccounts[i]
+		if !exchangeAccount.HasPermission(models.AccountPermFutures) {
+			continue
+		}
+		entry := gin.H{"accountId": exchangeAccount.ID, "label": exchangeAccount.Label, "environment": services.AccountEnvironment(exchangeAccount)}
 
-	// 获取账户信息
-	account, err := client.NewGetAccountService().Do(context.Background())
-	if err != nil {
-		log.Printf("获取期货账户信息失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取账户信息失败"})
+		exchange, err := services.NewAccountExchange(exchangeAccount)
+		if err != nil {
+			entry["error"] = err.Error()
+			breakdown = append(breakdown, entry)
+			continue
+		}
+		account, err := exchange.GetFuturesAccount(context.Background())
+		if err != nil {
+			log.Printf("获取账户 %d 期货账户信息失败: %v", exchangeAccount.ID, err)
+			entry["error"] = "获取账户信息失败"
+			breakdown = append(breakdown, entry)
+			continue
+		}
+
+		availableBalance, _ := strconv.ParseFloat(account.AvailableBalance, 64)
+		totalBalance, _ := strconv.ParseFloat(account.TotalWalletBalance, 64)
+		entry["availableBalance"] = availableBalance
+		entry["totalBalance"] = totalBalance
+		entry["assets"] = account.Assets
+		breakdown = append(breakdown, entry)
+
+		// 只汇总正式环境的余额
+		if services.IsProductionAccount(exchangeAccount) {
+			availableTotal += availableBalance
+			walletTotal += totalBalance
+			assets = append(assets, account.Assets...)
+		}
+	}
+
+	if len(breakdown) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有开通合约权限的交易所账户"})
 		return
 	}
 
 	// 返回可用余额和总余额
-	availableBalance, _ := strconv.ParseFloat(account.AvailableBalance, 64)
-	totalBalance, _ := strconv.ParseFloat(account.TotalWalletBalance, 64)
-
 	c.JSON(http.StatusOK, gin.H{
-		"availableBalance": availableBalance,
-		"totalBalance":     totalBalance,
-		"assets":           account.Assets, // 资产详情
+		"availableBalance": availableTotal,
+		"totalBalance":     walletTotal,
+		"assets":           assets, // 资产详情
+		"accounts":         breakdown,
+		"paper":            false,
 	})
 }
 
 // closePosition 平仓辅助函数
 func (ctrl *FuturesController) closePosition(user models.User, strategy *models.FuturesStrategy) error {
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return fmt.Errorf("解密API Key失败: %v", err)
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	// 持仓在哪个账户开的就在哪个账户平
+	paper := strategy.Paper || user.PaperTrading
+	accountID := strategy.AccountID
+	var openPosition models.FuturesPosition
+	if err := ctrl.Config.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "open").
+		First(&openPosition).Error; err == nil {
+		paper = openPosition.Paper
+		accountID = openPosition.AccountID
+	}
+
+	// 创建交易所实例
+	exchange, err := services.NewTradingExchange(ctrl.Config.DB, user.ID, accountID, paper)
 	if err != nil {
-		return fmt.Errorf("解密Secret Key失败: %v", err)
+		return err
 	}
 
-	// 创建期货客户端
-	client := binance.NewFuturesClient(apiKey, secretKey)
-
 	// 获取当前持仓
-	positions, err := client.NewGetPositionRiskService().
-		Symbol(strategy.Symbol).
-		Do(context.Background())
+	positions, err := exchange.GetPositionRisk(context.Background(), strategy.Symbol)
 	if err != nil {
 		return fmt.Errorf("获取持仓信息失败: %v", err)
 	}
@@ -610,11 +870,20 @@ func (ctrl *FuturesController) closePosition(user models.User, strategy *models.
 	}
 
 	// 获取持仓数量
-	positionAmt, _ := strconv.ParseFloat(position.PositionAmt, 64)
-	if positionAmt == 0 {
+	positionAmt, err := decimal.NewFromString(position.PositionAmt)
+	if err != nil {
+		return fmt.Errorf("解析持仓数量 %q 失败: %v", position.PositionAmt, err)
+	}
+	if positionAmt.IsZero() {
 		return nil // 已经没有持仓了
 	}
 
+	// 持仓数量按交易对步长格式化，原样平掉全部持仓
+	quantity := positionAmt.Abs().String()
+	if filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol); err == nil {
+		quantity = filters.QuantityString(filters.QuantizeQuantity(positionAmt.Abs(), services.RoundDown))
+	}
+
 	// 确定平仓方向
 	side := futures.SideTypeBuy
 	if strategy.Side == "LONG" {
@@ -622,13 +891,13 @@ func (ctrl *FuturesController) closePosition(user models.User, strategy *models.
 	}
 
 	// 创建市价平仓订单
-	order, err := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeMarket).
-		Quantity(fmt.Sprintf("%.8f", abs(positionAmt))).
-		Do(context.Background())
+	order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeMarket,
+		Quantity:     quantity,
+	})
 
 	if err != nil {
 		return fmt.Errorf("创建平仓订单失败: %v", err)
@@ -645,14 +914,6 @@ func (ctrl *FuturesController) closePosition(user models.User, strategy *models.
 	return nil
 }
 
-// abs 取绝对值
-func abs(x float64) float64 {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
 // updatePositionsRealtime 更新持仓实时数据
 func (ctrl *FuturesController) updatePositionsRealtime(userID uint, positions []models.FuturesPosition) {
 	// 获取用户信息
@@ -661,27 +922,44 @@ func (ctrl *FuturesController) updatePositionsRealtime(userID uint, positions []
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		return
+	// 模拟盘和各交易所账户的持仓分别从各自的账户获取
+	type accountKey struct {
+		paper     bool
+		accountID uint
 	}
+	positionMaps := make(map[accountKey]map[string]*futures.PositionRisk)
 
-	if apiKey == "" || secretKey == "" {
-		return
+	// 更新本地持仓数据
+	for i := range positions {
+		account := accountKey{paper: positions[i].Paper, accountID: positions[i].AccountID}
+		if account.paper {
+			account.accountID = 0
+		}
+		if _, loaded := positionMaps[account]; !loaded {
+			positionMaps[account] = ctrl.getPositionRiskMap(user.ID, account.accountID, account.paper)
+		}
+
+		key := positions[i].Symbol + "_" + positions[i].PositionSide
+		if riskPos, exists := positionMaps[account][key]; exists {
+			// 更新实时数据
+			positions[i].UnrealizedPnl, _ = decimal.NewFromString(riskPos.UnRealizedProfit)
+			positions[i].MarkPrice, _ = decimal.NewFromString(riskPos.MarkPrice)
+			positions[i].LiquidationPrice, _ = decimal.NewFromString(riskPos.LiquidationPrice)
+		}
 	}
+}
 
-	// 创建期货客户端
-	client := binance.NewFuturesClient(apiKey, secretKey)
+// getPositionRiskMap 获取账户持仓风险信息，按 交易对_持仓方向 建立映射
+func (ctrl *FuturesController) getPositionRiskMap(userID, accountID uint, paper bool) map[string]*futures.PositionRisk {
+	exchange, err := services.NewTradingExchange(ctrl.Config.DB, userID, accountID, paper)
+	if err != nil {
+		return nil
+	}
 
 	// 获取所有持仓
-	riskPositions, err := client.NewGetPositionRiskService().Do(context.Background())
+	riskPositions, err := exchange.GetPositionRisk(context.Background(), "")
 	if err != nil {
-		return
+		return nil
 	}
 
 	// 创建映射
@@ -690,15 +968,5 @@ func (ctrl *FuturesController) updatePositionsRealtime(userID uint, positions []
 		key := pos.Symbol + "_" + string(pos.PositionSide)
 		positionMap[key] = pos // pos 已经是指针类型
 	}
-
-	// 更新本地持仓数据
-	for i := range positions {
-		key := positions[i].Symbol + "_" + positions[i].PositionSide
-		if riskPos, exists := positionMap[key]; exists {
-			// 更新实时数据
-			positions[i].UnrealizedPnl, _ = strconv.ParseFloat(riskPos.UnRealizedProfit, 64)
-			positions[i].MarkPrice, _ = strconv.ParseFloat(riskPos.MarkPrice, 64)
-			positions[i].LiquidationPrice, _ = strconv.ParseFloat(riskPos.LiquidationPrice, 64)
-		}
-	}
+	return positionMap
 }
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+type KlineController struct {
+	Config *config.Config
+}
+
+// GetKlines 获取K线，用于图表和指标计算
+func (ctrl *KlineController) GetKlines(c *gin.Context) {
+	symbol := strings.ToUpper(strings.TrimSpace(c.Query("symbol")))
+	interval := c.Query("interval")
+	market := c.DefaultQuery("market", models.CandleMarketSpot)
+
+	if symbol == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "交易对不能为空"})
+		return
+	}
+	validInterval := false
+	for _, i := range models.CandleIntervals {
+		if i == interval {
+			validInterval = true
+			break
+		}
+	}
+	if !validInterval {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "K线周期必须为 " + strings.Join(models.CandleIntervals, "/")})
+		return
+	}
+
+	limit := 500
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > tasks.MaxCandleLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须在 1 到 " + strconv.Itoa(tasks.MaxCandleLimit) + " 之间"})
+			return
+		}
+		limit = n
+	}
+	var endTime int64
+	if v := c.Query("endTime"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的 endTime"})
+			return
+		}
+		endTime = n
+	}
+
+	// 校验交易对存在，避免为无效交易对发起同步
+	var err error
+	switch market {
+	case models.CandleMarketSpot:
+		_, err = services.SpotSymbolInfo(c.Request.Context(), services.DefaultEndpoints(), symbol)
+	case models.CandleMarketFutures:
+		_, err = services.FuturesSymbolInfo(c.Request.Context(), services.DefaultEndpoints(), symbol)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "市场必须为 spot 或 futures"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的交易对", "details": err.Error()})
+		return
+	}
+
+	candles, err := tasks.GetCandles(ctrl.Config.DB, market, symbol, interval, endTime, limit)
+	if err != nil {
+		log.Printf("获取 %s %s K线失败: %v", symbol, interval, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取K线失败"})
+		return
+	}
+
+	// 本地没有数据的交易对按需回补，稍后再次请求即可获取
+	if len(candles) == 0 {
+		tasks.RequestCandleSync(market, symbol)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"market":   market,
+		"symbol":   symbol,
+		"interval": interval,
+		// 合约K线按标记价格聚合，只有价格，volume 和 quoteVolume 恒为0
+		"hasVolume": models.CandleHasVolume(market),
+		"klines":    candles,
+	})
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/ccj241/binance/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationController struct {
+	Config *config.Config
+}
+
+// GetPreferences 获取通知偏好
+func (ctrl *NotificationController) GetPreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	pref, err := tasks.GetNotificationPreference(ctrl.Config.DB, userID.(uint))
+	if err != nil {
+		log.Printf("获取通知偏好失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取通知偏好失败"})
+		return
+	}
+	if pref == nil {
+		pref = &models.NotificationPreference{UserID: userID.(uint)}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"preferences":      pref,
+		"webhookSecretSet": pref.WebhookSecret != "",
+		"events":           models.NotifyEvents,
+	})
+}
+
+// UpdatePreferences 更新通知偏好，未传的字段保持不变
+func (ctrl *NotificationController) UpdatePreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Events          *[]string `json:"events"`
+		TelegramEnabled *bool     `json:"telegramEnabled"`
+		TelegramChatID  *string   `json:"telegramChatId"`
+		EmailEnabled    *bool     `json:"emailEnabled"`
+		Email           *string   `json:"email"`
+		WebhookEnabled  *bool     `json:"webhookEnabled"`
+		WebhookURL      *string   `json:"webhookUrl"`
+		WebhookSecret   *string   `json:"webhookSecret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	pref, err := tasks.GetNotificationPreference(ctrl.Config.DB, userID.(uint))
+	if err != nil {
+		log.Printf("获取通知偏好失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取通知偏好失败"})
+		return
+	}
+	if pref == nil {
+		pref = &models.NotificationPreference{UserID: userID.(uint)}
+	}
+
+	if req.Events != nil {
+		for _, event := range *req.Events {
+			if !isNotifyEvent(event) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的通知事件: " + event})
+				return
+			}
+		}
+		pref.Events = strings.Join(*req.Events, ",")
+	}
+	if req.TelegramEnabled != nil {
+		pref.TelegramEnabled = *req.TelegramEnabled
+	}
+	if req.TelegramChatID != nil {
+		pref.TelegramChatID = strings.TrimSpace(*req.TelegramChatID)
+	}
+	if req.EmailEnabled != nil {
+		pref.EmailEnabled = *req.EmailEnabled
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" && !strings.Contains(email, "@") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "邮箱格式无效"})
+			return
+		}
+		pref.Email = email
+	}
+	if req.WebhookEnabled != nil {
+		pref.WebhookEnabled = *req.WebhookEnabled
+	}
+	if req.WebhookURL != nil {
+		webhookURL := strings.TrimSpace(*req.WebhookURL)
+		if webhookURL != "" {
+			if err := services.ValidateWebhookURL(c.Request.Context(), webhookURL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		pref.WebhookURL = webhookURL
+	}
+	if req.WebhookSecret != nil {
+		encrypted, err := utils.Encrypt(*req.WebhookSecret)
+		if err != nil {
+			log.Printf("加密Webhook密钥失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存Webhook密钥失败"})
+			return
+		}
+		pref.WebhookSecret = encrypted
+	}
+
+	if pref.TelegramEnabled && pref.TelegramChatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "启用Telegram通知需要填写Chat ID"})
+		return
+	}
+	if pref.EmailEnabled && pref.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "启用邮件通知需要填写邮箱"})
+		return
+	}
+	if pref.WebhookEnabled && pref.WebhookURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "启用Webhook通知需要填写地址"})
+		return
+	}
+
+	if err := ctrl.Config.DB.Save(pref).Error; err != nil {
+		log.Printf("保存通知偏好失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存通知偏好失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "通知偏好已更新",
+		"preferences":      pref,
+		"webhookSecretSet": pref.WebhookSecret != "",
+	})
+}
+
+// SendTest 向所有已启用的渠道发送测试通知
+func (ctrl *NotificationController) SendTest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	tasks.EmitNotification(tasks.NotificationEvent{
+		UserID:  userID.(uint),
+		Event:   models.NotifyTest,
+		Title:   "测试通知",
+		Message: "通知渠道配置成功",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "测试通知已发送，请在投递记录中查看结果"})
+}
+
+// GetDeliveries 获取通知投递记录
+func (ctrl *NotificationController) GetDeliveries(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	query := ctrl.Config.DB.Where("user_id = ?", userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	var deliveries []models.NotificationDelivery
+	if err := query.Order("created_at desc").Limit(limit).Find(&deliveries).Error; err != nil {
+		log.Printf("获取通知投递记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取通知投递记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// isNotifyEvent 是否为可订阅的通知事件
+func isNotifyEvent(event string) bool {
+	for _, e := range models.NotifyEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type RiskController struct {
+	Config *config.Config
+}
+
+// GetLimits 获取风控限额和当日盈亏
+func (ctrl *RiskController) GetLimits(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	limit, err := tasks.GetRiskLimit(ctrl.Config.DB, userID.(uint))
+	if err != nil {
+		log.Printf("获取风控限额失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取风控限额失败"})
+		return
+	}
+	if limit == nil {
+		// 未配置时返回全部不限制的默认值
+		limit = &models.RiskLimit{UserID: userID.(uint)}
+	}
+
+	dayStart := tasks.RiskDayStart(time.Now())
+	dailyPnl, err := tasks.DailyPnL(ctrl.Config.DB, userID.(uint), dayStart)
+	if err != nil {
+		log.Printf("计算当日盈亏失败: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"limits":   limit,
+		"dailyPnl": dailyPnl,
+		"halted":   limit.HaltedSince(dayStart),
+	})
+}
+
+// UpdateLimits 设置风控限额，0表示不限制
+func (ctrl *RiskController) UpdateLimits(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Enabled             *bool    `json:"enabled"`
+		MaxSymbolNotional   *float64 `json:"maxSymbolNotional"`
+		MaxLeverageExposure *float64 `json:"maxLeverageExposure"`
+		MaxOpenOrders       *int     `json:"maxOpenOrders"`
+		MaxDailyLoss        *float64 `json:"maxDailyLoss"`
+		MinFreeBalance      *float64 `json:"minFreeBalance"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	limit, err := tasks.GetRiskLimit(ctrl.Config.DB, userID.(uint))
+	if err != nil {
+		log.Printf("获取风控限额失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取风控限额失败"})
+		return
+	}
+	if limit == nil {
+		limit = &models.RiskLimit{UserID: userID.(uint), Enabled: true}
+	}
+
+	for _, value := range []*float64{req.MaxSymbolNotional, req.MaxLeverageExposure, req.MaxDailyLoss, req.MinFreeBalance} {
+		if value != nil && *value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "风控限额不能为负数"})
+			return
+		}
+	}
+	if req.MaxOpenOrders != nil && *req.MaxOpenOrders < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "最大挂单数不能为负数"})
+		return
+	}
+
+	if req.Enabled != nil {
+		limit.Enabled = *req.Enabled
+	}
+	if req.MaxSymbolNotional != nil {
+		limit.MaxSymbolNotional = decimal.NewFromFloat(*req.MaxSymbolNotional)
+	}
+	if req.MaxLeverageExposure != nil {
+		limit.MaxLeverageExposure = *req.MaxLeverageExposure
+	}
+	if req.MaxOpenOrders != nil {
+		limit.MaxOpenOrders = *req.MaxOpenOrders
+	}
+	if req.MaxDailyLoss != nil {
+		limit.MaxDailyLoss = decimal.NewFromFloat(*req.MaxDailyLoss)
+	}
+	if req.MinFreeBalance != nil {
+		limit.MinFreeBalance = decimal.NewFromFloat(*req.MinFreeBalance)
+	}
+
+	if err := ctrl.Config.DB.Save(limit).Error; err != nil {
+		log.Printf("保存风控限额失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存风控限额失败"})
+		return
+	}
+
+	log.Printf("用户 %d 更新风控限额: 启用=%v, 单交易对=%s, 杠杆=%.2f, 挂单数=%d, 单日亏损=%s, 保留余额=%s",
+		limit.UserID, limit.Enabled, limit.MaxSymbolNotional, limit.MaxLeverageExposure,
+		limit.MaxOpenOrders, limit.MaxDailyLoss, limit.MinFreeBalance)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "风控限额已更新",
+		"limits":  limit,
+	})
+}
+
+// GetEvents 获取风控事件记录
+func (ctrl *RiskController) GetEvents(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	query := ctrl.Config.DB.Where("user_id = ?", userID)
+	if rule := c.Query("rule"); rule != "" {
+		query = query.Where("rule = ?", rule)
+	}
+
+	var events []models.RiskEvent
+	if err := query.Order("created_at desc").Limit(limit).Find(&events).Error; err != nil {
+		log.Printf("获取风控事件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取风控事件失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// TriggerKillSwitch 用户紧急停止：停用全部策略和提币规则，撤销全部挂单，可选市价平仓
+func (ctrl *RiskController) TriggerKillSwitch(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req struct {
+		Reason         string `json:"reason" binding:"max=500"`
+		ClosePositions bool   `json:"closePositions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+		return
+	}
+
+	event := &models.KillSwitchEvent{
+		Scope:          tasks.KillSwitchScopeUser,
+		TargetUserID:   userID.(uint),
+		TriggeredBy:    userID.(uint),
+		Reason:         req.Reason,
+		ClosePositions: req.ClosePositions,
+	}
+	if err := tasks.TriggerKillSwitch(ctrl.Config, event); err != nil {
+		log.Printf("触发紧急停止失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "触发紧急停止失败"})
+		return
+	}
+	tasks.RunKillSwitch(ctrl.Config, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "紧急停止已执行",
+		"event":   event,
+	})
+}
+
+// GetKillSwitch 获取当前生效的紧急停止和历史记录
+func (ctrl *RiskController) GetKillSwitch(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	active, err := tasks.ActiveKillSwitch(ctrl.Config.DB, userID.(uint))
+	if err != nil {
+		log.Printf("获取紧急停止状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取紧急停止状态失败"})
+		return
+	}
+
+	var events []models.KillSwitchEvent
+	if err := ctrl.Config.DB.Where("scope = ? AND target_user_id = ?", tasks.KillSwitchScopeUser, userID).
+		Order("created_at desc").Limit(50).Find(&events).Error; err != nil {
+		log.Printf("获取紧急停止记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取紧急停止记录失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active": active,
+		"events": events,
+	})
+}
+
+// ReleaseKillSwitch 解除本人触发的紧急停止，管理员触发的需由管理员解除
+func (ctrl *RiskController) ReleaseKillSwitch(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var events []models.KillSwitchEvent
+	if err := ctrl.Config.DB.Where("scope = ? AND target_user_id = ? AND active = ?",
+		tasks.KillSwitchScopeUser, userID, true).Find(&events).Error; err != nil {
+		log.Printf("获取紧急停止记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取紧急停止记录失败"})
+		return
+	}
+	if len(events) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有生效中的紧急停止"})
+		return
+	}
+
+	for i := range events {
+		if events[i].TriggeredBy != userID.(uint) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "该紧急停止由管理员触发，需管理员解除"})
+			return
+		}
+	}
+	for i := range events {
+		if err := tasks.ReleaseKillSwitch(ctrl.Config.DB, &events[i], userID.(uint)); err != nil {
+			log.Printf("解除紧急停止失败: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "解除紧急停止失败"})
+			return
+		}
+	}
+
+	log.Printf("用户 %d 解除紧急停止", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "紧急停止已解除，策略需手动重新启用"})
+}
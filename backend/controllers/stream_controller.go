@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+// 心跳间隔，防止代理关闭空闲连接
+const streamHeartbeatInterval = 20 * time.Second
+
+type StreamController struct {
+	Config *config.Config
+}
+
+// Stream 以 Server-Sent Events 推送当前用户的价格、订单、持仓、策略状态和通知
+func (ctrl *StreamController) Stream(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	uid := userID.(uint)
+
+	events, cancel := tasks.SubscribeUserEvents(uid)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭 nginx 缓冲
+	c.Status(http.StatusOK)
+
+	// 连接建立后先推送一次价格快照，之后只推送变化
+	c.SSEvent(tasks.StreamEventPrice, gin.H{"prices": tasks.UserPrices(uid), "snapshot": true})
+	c.Writer.Flush()
+
+	log.Printf("用户 %d 建立推送连接", uid)
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-events:
+			c.SSEvent(event.Type, event)
+			return true
+		case now := <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": now})
+			return true
+		}
+	})
+	log.Printf("用户 %d 推送连接关闭", uid)
+}
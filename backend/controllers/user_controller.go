@@ -5,6 +5,7 @@ import (
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
 	"strings"
@@ -36,7 +37,7 @@ func (ctrl *UserController) getUserIDFromContext(c *gin.Context) (uint, error) {
 	return uid, nil
 }
 
-// SetAPIKey 保存用户的 API 密钥
+// SetAPIKey 保存用户的 API 密钥，写入默认交易所账户；没有账户时创建开通全部权限的主账户
 func (ctrl *UserController) SetAPIKey(c *gin.Context) {
 	var input APIKeyInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -51,53 +52,89 @@ func (ctrl *UserController) SetAPIKey(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := ctrl.Config.DB.First(&user, userID).Error; err != nil {
-		log.Printf("用户未找到: userID=%d, error=%v", userID, err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+	log.Printf("为用户 %d 保存 API 密钥: APIKey=%s..., SecretKey=%s...",
+		userID, maskAPIKey(input.APIKey), maskAPIKey(input.APISecret))
+
+	var account models.ExchangeAccount
+	err = ctrl.Config.DB.Where("user_id = ? AND is_default = ?", userID, true).First(&account).Error
+	if err == gorm.ErrRecordNotFound {
+		account = models.ExchangeAccount{
+			UserID:      userID,
+			Label:       "主账户",
+			Permissions: strings.Join(models.AccountPermissions, ","),
+			IsDefault:   true,
+			Status:      "active",
+		}
+	} else if err != nil {
+		log.Printf("查询默认交易所账户失败，用户 %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 API 密钥失败", "details": err.Error()})
 		return
 	}
 
-	log.Printf("为用户 %d 保存 API 密钥: APIKey长度=%d, SecretKey长度=%d",
-		userID, len(input.APIKey), len(input.APISecret))
-
 	// 直接赋值，BeforeSave钩子会自动加密
-	user.APIKey = input.APIKey
-	user.SecretKey = input.APISecret
-
-	// 保存前打印原始值长度
-	log.Printf("保存前 - 用户 %d: APIKey=%s..., SecretKey=%s...",
-		userID, maskAPIKey(input.APIKey), maskAPIKey(input.APISecret))
-
-	if err := ctrl.Config.DB.Save(&user).Error; err != nil {
+	account.APIKey = strings.TrimSpace(input.APIKey)
+	account.SecretKey = strings.TrimSpace(input.APISecret)
+	if err := ctrl.Config.DB.Save(&account).Error; err != nil {
 		log.Printf("保存 API 密钥失败，用户 %d: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 API 密钥失败", "details": err.Error()})
 		return
 	}
 
-	// 重新查询验证是否保存成功
-	var savedUser models.User
-	if err := ctrl.Config.DB.First(&savedUser, userID).Error; err != nil {
-		log.Printf("重新查询用户失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证保存失败"})
+	c.JSON(http.StatusOK, gin.H{"message": "API 密钥更新成功", "accountId": account.ID})
+}
+
+// GetAPIKey 获取用户默认交易所账户的 API 密钥（部分掩码）
+func (ctrl *UserController) GetAPIKey(c *gin.Context) {
+	userID, err := ctrl.getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户认证"})
+		return
+	}
+
+	var account models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("user_id = ? AND is_default = ?", userID, true).First(&account).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"apiKey":    "",
+			"secretKey": "",
+		})
+		return
+	}
+
+	response := accountResponse(account)
+	c.JSON(http.StatusOK, gin.H{
+		"apiKey":    response["apiKey"],
+		"secretKey": response["secretKey"],
+		"accountId": account.ID,
+	})
+}
+
+// DeleteAPIKey 删除用户的默认交易所账户
+func (ctrl *UserController) DeleteAPIKey(c *gin.Context) {
+	userID, err := ctrl.getUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户认证"})
 		return
 	}
 
-	// 验证是否真的保存了
-	if savedUser.APIKey == "" || savedUser.SecretKey == "" {
-		log.Printf("警告：用户 %d 的API密钥保存后为空", userID)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "API密钥保存异常"})
+	var account models.ExchangeAccount
+	if err := ctrl.Config.DB.Where("user_id = ? AND is_default = ?", userID, true).First(&account).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "API 密钥删除成功"})
 		return
 	}
 
-	log.Printf("API密钥保存成功 - 用户 %d: 加密后APIKey长度=%d, SecretKey长度=%d",
-		userID, len(savedUser.APIKey), len(savedUser.SecretKey))
+	if err := ctrl.Config.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteAccount(tx, &account)
+	}); err != nil {
+		log.Printf("删除 API 密钥失败，用户 %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除 API 密钥失败"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "API 密钥更新成功"})
+	c.JSON(http.StatusOK, gin.H{"message": "API 密钥删除成功"})
 }
 
-// GetAPIKey 获取用户的 API 密钥（部分掩码）
-func (ctrl *UserController) GetAPIKey(c *gin.Context) {
+// GetPaperTrading 获取用户的模拟盘模式
+func (ctrl *UserController) GetPaperTrading(c *gin.Context) {
 	userID, err := ctrl.getUserIDFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户认证"})
@@ -106,43 +143,23 @@ func (ctrl *UserController) GetAPIKey(c *gin.Context) {
 
 	var user models.User
 	if err := ctrl.Config.DB.First(&user, userID).Error; err != nil {
-		log.Printf("用户未找到: userID=%d, error=%v", userID, err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
 		return
 	}
 
-	var maskedAPIKey, maskedSecretKey string
+	c.JSON(http.StatusOK, gin.H{"paperTrading": user.PaperTrading})
+}
 
-	// 解密API Key
-	if user.APIKey != "" {
-		decryptedAPIKey, err := user.GetDecryptedAPIKey()
-		if err != nil {
-			log.Printf("解密API Key失败: %v", err)
-			maskedAPIKey = "解密失败"
-		} else {
-			maskedAPIKey = maskAPIKey(decryptedAPIKey)
-		}
+// SetPaperTrading 开启或关闭用户的模拟盘模式
+func (ctrl *UserController) SetPaperTrading(c *gin.Context) {
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
 	}
-
-	// 解密Secret Key
-	if user.SecretKey != "" {
-		decryptedSecretKey, err := user.GetDecryptedSecretKey()
-		if err != nil {
-			log.Printf("解密Secret Key失败: %v", err)
-			maskedSecretKey = "解密失败"
-		} else {
-			maskedSecretKey = maskAPIKey(decryptedSecretKey)
-		}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据", "details": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"apiKey":    maskedAPIKey,
-		"secretKey": maskedSecretKey,
-	})
-}
-
-// DeleteAPIKey 删除用户的 API 密钥
-func (ctrl *UserController) DeleteAPIKey(c *gin.Context) {
 	userID, err := ctrl.getUserIDFromContext(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的用户认证"})
@@ -151,20 +168,39 @@ func (ctrl *UserController) DeleteAPIKey(c *gin.Context) {
 
 	var user models.User
 	if err := ctrl.Config.DB.First(&user, userID).Error; err != nil {
-		log.Printf("用户未找到: userID=%d, error=%v", userID, err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
 		return
 	}
 
-	user.APIKey = ""
-	user.SecretKey = ""
-	if err := ctrl.Config.DB.Save(&user).Error; err != nil {
-		log.Printf("删除 API 密钥失败，用户 %d: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除 API 密钥失败"})
+	if user.PaperTrading == *input.Enabled {
+		c.JSON(http.StatusOK, gin.H{"message": "模拟盘模式未变化", "paperTrading": user.PaperTrading})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "API 密钥删除成功"})
+	// 执行中的订单和持仓会按切换前的模式继续监控，切换前必须先处理完
+	var pendingOrders, runningStrategies int64
+	ctrl.Config.DB.Model(&models.Order{}).
+		Where("user_id = ? AND status = ? AND deleted_at IS NULL", userID, "pending").
+		Count(&pendingOrders)
+	ctrl.Config.DB.Model(&models.FuturesStrategy{}).
+		Where("user_id = ? AND status IN ? AND deleted_at IS NULL", userID, []string{"triggered", "position_opened"}).
+		Count(&runningStrategies)
+	if pendingOrders > 0 || runningStrategies > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("存在 %d 个待处理订单和 %d 个执行中的期货策略，请先处理后再切换模拟盘模式",
+				pendingOrders, runningStrategies),
+		})
+		return
+	}
+
+	if err := ctrl.Config.DB.Model(&user).Update("paper_trading", *input.Enabled).Error; err != nil {
+		log.Printf("切换模拟盘模式失败，用户 %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "切换模拟盘模式失败"})
+		return
+	}
+
+	log.Printf("用户 %d 模拟盘模式切换为: %v", userID, *input.Enabled)
+	c.JSON(http.StatusOK, gin.H{"message": "模拟盘模式切换成功", "paperTrading": *input.Enabled})
 }
 
 // maskAPIKey 对API密钥进行掩码处理
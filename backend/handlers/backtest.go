@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+// maxBacktestUploadSize 回测上传请求体的大小上限
+const maxBacktestUploadSize = 64 << 20
+
+// GinBacktestHandler 用历史K线/归集成交数据回测现货策略
+// multipart 表单字段：file（可多个CSV或zip）、strategyId（回测已有策略）或 strategy（策略JSON）、options（回测参数JSON）
+func GinBacktestHandler(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := getUserFromGinContext(c, cfg)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+			return
+		}
+
+		// 先限制请求体大小再解析表单，超限时直接拒绝
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBacktestUploadSize)
+		form, err := c.MultipartForm()
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("上传文件总大小不能超过 %dMB", maxBacktestUploadSize>>20)})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "解析上传表单失败", "details": err.Error()})
+			return
+		}
+
+		var strategy models.Strategy
+		if idStr := c.PostForm("strategyId"); idStr != "" {
+			strategyID, err := strconv.ParseUint(idStr, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的策略ID"})
+				return
+			}
+			if err := cfg.DB.Where("id = ? AND user_id = ? AND deleted_at IS NULL", strategyID, user.ID).
+				First(&strategy).Error; err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "策略未找到或无权访问"})
+				return
+			}
+		} else if raw := c.PostForm("strategy"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &strategy); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的策略配置", "details": err.Error()})
+				return
+			}
+			strategy.Symbol = strings.ToUpper(strategy.Symbol)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "需要提供 strategyId 或 strategy"})
+			return
+		}
+
+		var opts tasks.BacktestOptions
+		if raw := c.PostForm("options"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的回测参数", "details": err.Error()})
+				return
+			}
+		}
+
+		if len(form.File["file"]) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "请上传历史数据文件"})
+			return
+		}
+
+		var ticks []tasks.BacktestTick
+		for _, header := range form.File["file"] {
+			f, err := header.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败", "details": err.Error()})
+				return
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败", "details": err.Error()})
+				return
+			}
+			fileTicks, err := tasks.ReadBacktestData(data)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "解析历史数据失败: " + header.Filename, "details": err.Error()})
+				return
+			}
+			ticks = append(ticks, fileTicks...)
+		}
+
+		// 获取交易对精度，失败时使用默认精度
+		if symbolInfo, err := services.SpotSymbolInfo(context.Background(), services.DefaultEndpoints(), strategy.Symbol); err != nil {
+			log.Printf("回测获取 %s 交易所信息失败，使用默认精度: %v", strategy.Symbol, err)
+		} else {
+			opts.SymbolInfo = &symbolInfo
+		}
+
+		result, err := tasks.RunBacktest(strategy, ticks, opts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "回测失败", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
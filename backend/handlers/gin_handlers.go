@@ -15,8 +15,10 @@ import (
 	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 	"github.com/ccj241/binance/tasks"
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 )
 
 // getUserFromGinContext 从Gin上下文中获取用户信息
@@ -77,7 +79,8 @@ func GinPricesHandler(cfg *config.Config) gin.HandlerFunc {
 	}
 }
 
-// GinBalanceHandler Gin版本的余额处理器 - 修复版本（使用解密的API密钥）
+// GinBalanceHandler Gin版本的余额处理器，汇总用户全部交易所账户的余额并返回各账户明细
+// 指定 accountId 时只查询该账户
 func GinBalanceHandler(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, err := getUserFromGinContext(c, cfg)
@@ -87,87 +90,144 @@ func GinBalanceHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// 解密API密钥
-		apiKey, err := user.GetDecryptedAPIKey()
-		if err != nil {
-			log.Printf("解密用户 %d API Key失败: %v", user.ID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "API密钥解密失败"})
-			return
+		query := cfg.DB.Where("user_id = ? AND status = ?", user.ID, "active")
+		if accountID := c.Query("accountId"); accountID != "" {
+			query = query.Where("id = ?", accountID)
 		}
-
-		secretKey, err := user.GetDecryptedSecretKey()
-		if err != nil {
-			log.Printf("解密用户 %d Secret Key失败: %v", user.ID, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Secret密钥解密失败"})
+		var accounts []models.ExchangeAccount
+		if err := query.Order("is_default desc, id").Find(&accounts).Error; err != nil {
+			log.Printf("获取用户 %d 的交易所账户失败: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取交易所账户失败"})
 			return
 		}
-
-		if apiKey == "" || secretKey == "" {
+		if len(accounts) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "API 密钥未设置"})
 			return
 		}
 
-		// 创建币安客户端
-		client := binance.NewClient(apiKey, secretKey)
-
 		// 设置超时上下文
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		// 获取账户信息
-		account, err := client.NewGetAccountService().Do(ctx)
-		if err != nil {
-			// 只记录错误，移除成功日志
-			log.Printf("获取用户 %d 的账户信息失败: %v", user.ID, err)
-
-			// 检查具体的错误类型
-			errStr := err.Error()
-			if strings.Contains(errStr, "Invalid API-key") || strings.Contains(errStr, "API-key format invalid") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "API 密钥无效，请检查您的密钥"})
-				return
-			} else if strings.Contains(errStr, "Signature for this request is not valid") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Secret 密钥无效，请检查您的密钥"})
-				return
-			} else if strings.Contains(errStr, "Timestamp for this request") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "时间同步错误，请检查系统时间"})
-				return
-			} else if strings.Contains(errStr, "IP address is not allowed") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "IP 地址未在白名单中，请检查 API 设置"})
-				return
-			}
-
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取余额失败"})
-			return
+		type assetBalance struct {
+			free   float64
+			locked float64
 		}
+		totals := make(map[string]*assetBalance)
+		var assets []string
+		breakdown := make([]gin.H, 0, len(accounts))
+		var firstErr error
+		succeeded := 0
 
-		// 处理余额数据
-		balances := make([]map[string]interface{}, 0)
-		for _, b := range account.Balances {
-			free, err := strconv.ParseFloat(b.Free, 64)
-			if err != nil {
-				continue
-			}
+		for i := range accounts {
+			account := &accounts[i]
+			entry := gin.H{"accountId": account.ID, "label": account.Label, "environment": services.AccountEnvironment(account)}
 
-			locked, err := strconv.ParseFloat(b.Locked, 64)
+			balances, err := accountBalances(ctx, account)
 			if err != nil {
+				// 只记录错误，移除成功日志
+				log.Printf("获取用户 %d 账户 %d 的余额失败: %v", user.ID, account.ID, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				_, entry["error"] = balanceErrorMessage(err)
+				breakdown = append(breakdown, entry)
 				continue
 			}
 
-			// 只返回有余额的资产
-			if free > 0 || locked > 0 {
-				balances = append(balances, map[string]interface{}{
-					"asset":  b.Asset,
-					"free":   free,
-					"locked": locked,
-				})
+			for _, b := range balances {
+				// 只汇总正式环境的余额
+				if !services.IsProductionAccount(account) {
+					continue
+				}
+				asset := b["asset"].(string)
+				total, ok := totals[asset]
+				if !ok {
+					total = &assetBalance{}
+					totals[asset] = total
+					assets = append(assets, asset)
+				}
+				total.free += b["free"].(float64)
+				total.locked += b["locked"].(float64)
 			}
+			entry["balances"] = balances
+			breakdown = append(breakdown, entry)
+			succeeded++
+		}
+
+		// 所有账户都失败时返回第一个错误原因
+		if succeeded == 0 {
+			status, message := balanceErrorMessage(firstErr)
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+
+		balances := make([]map[string]interface{}, 0, len(assets))
+		for _, asset := range assets {
+			balances = append(balances, map[string]interface{}{
+				"asset":  asset,
+				"free":   totals[asset].free,
+				"locked": totals[asset].locked,
+			})
 		}
 
 		// 移除成功日志
-		c.JSON(http.StatusOK, gin.H{"balances": balances})
+		c.JSON(http.StatusOK, gin.H{"balances": balances, "accounts": breakdown})
 	}
 }
 
+// accountBalances 获取交易所账户中有余额的资产
+func accountBalances(ctx context.Context, account *models.ExchangeAccount) ([]map[string]interface{}, error) {
+	exchange, err := services.NewAccountExchange(account)
+	if err != nil {
+		return nil, err
+	}
+	info, err := exchange.GetAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]map[string]interface{}, 0)
+	for _, b := range info.Balances {
+		free, err := strconv.ParseFloat(b.Free, 64)
+		if err != nil {
+			continue
+		}
+
+		locked, err := strconv.ParseFloat(b.Locked, 64)
+		if err != nil {
+			continue
+		}
+
+		// 只返回有余额的资产
+		if free > 0 || locked > 0 {
+			balances = append(balances, map[string]interface{}{
+				"asset":  b.Asset,
+				"free":   free,
+				"locked": locked,
+			})
+		}
+	}
+	return balances, nil
+}
+
+// balanceErrorMessage 将查询余额的错误转换为提示信息
+func balanceErrorMessage(err error) (int, string) {
+	errStr := err.Error()
+	if strings.Contains(errStr, "Invalid API-key") || strings.Contains(errStr, "API-key format invalid") {
+		return http.StatusBadRequest, "API 密钥无效，请检查您的密钥"
+	} else if strings.Contains(errStr, "Signature for this request is not valid") {
+		return http.StatusBadRequest, "Secret 密钥无效，请检查您的密钥"
+	} else if strings.Contains(errStr, "Timestamp for this request") {
+		return http.StatusBadRequest, "时间同步错误，请检查系统时间"
+	} else if strings.Contains(errStr, "IP address is not allowed") {
+		return http.StatusBadRequest, "IP 地址未在白名单中，请检查 API 设置"
+	} else if strings.Contains(errStr, "解密") {
+		return http.StatusInternalServerError, "API密钥解密失败"
+	}
+	return http.StatusInternalServerError, "获取余额失败"
+}
+
 // GinTradesHandler Gin版本的交易记录处理器 - 修复版本
 func GinTradesHandler(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -185,77 +245,57 @@ func GinTradesHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// 如果用户设置了API密钥，尝试从币安获取最新交易
-		if user.APIKey != "" && user.SecretKey != "" {
-			// 解密API密钥
-			apiKey, err := user.GetDecryptedAPIKey()
-			if err != nil {
-				log.Printf("解密用户 %d API Key失败: %v", user.ID, err)
-				// 继续返回数据库中的交易记录
-			} else {
-				secretKey, err := user.GetDecryptedSecretKey()
+		// 如果用户设置了交易所账户，尝试从币安获取最新交易
+		if exchange, err := services.NewUserExchange(cfg.DB, user.ID, 0); err == nil {
+			// 获取用户的所有交易对
+			var symbols []string
+			cfg.DB.Model(&models.CustomSymbol{}).
+				Where("user_id = ? AND deleted_at IS NULL", user.ID).
+				Pluck("symbol", &symbols)
+
+			// 为每个交易对获取最近的交易
+			for _, symbol := range symbols {
+				// 获取最近24小时的交易
+				endTime := time.Now().UnixMilli()
+				startTime := time.Now().Add(-24 * time.Hour).UnixMilli()
+
+				// 每个交易对最多获取100条记录
+				trades, err := exchange.ListTrades(context.Background(), symbol, startTime, endTime, 100)
+
 				if err != nil {
-					log.Printf("解密用户 %d Secret Key失败: %v", user.ID, err)
-					// 继续返回数据库中的交易记录
-				} else if apiKey != "" && secretKey != "" {
-					// 使用解密后的密钥创建客户端
-					client := binance.NewClient(apiKey, secretKey)
-
-					// 获取用户的所有交易对
-					var symbols []string
-					cfg.DB.Model(&models.CustomSymbol{}).
-						Where("user_id = ? AND deleted_at IS NULL", user.ID).
-						Pluck("symbol", &symbols)
-
-					// 为每个交易对获取最近的交易
-					for _, symbol := range symbols {
-						// 获取最近24小时的交易
-						endTime := time.Now().UnixMilli()
-						startTime := time.Now().Add(-24 * time.Hour).UnixMilli()
-
-						trades, err := client.NewListTradesService().
-							Symbol(symbol).
-							StartTime(startTime).
-							EndTime(endTime).
-							Limit(100). // 每个交易对最多获取100条记录
-							Do(context.Background())
-
-						if err != nil {
-							log.Printf("获取 %s 交易记录失败: %v", symbol, err)
-							continue
-						}
+					log.Printf("获取 %s 交易记录失败: %v", symbol, err)
+					continue
+				}
+
+				// 将新交易保存到数据库
+				for _, trade := range trades {
+					price, _ := strconv.ParseFloat(trade.Price, 64)
+					qty, _ := strconv.ParseFloat(trade.Quantity, 64) // 使用 Quantity 而不是 Qty
+
+					// 检查交易是否已存在
+					var exists bool
+					cfg.DB.Model(&models.Trade{}).
+						Where("user_id = ? AND symbol = ? AND time = ?", user.ID, symbol, trade.Time).
+						Select("count(*) > 0").
+						Find(&exists)
 
-						// 将新交易保存到数据库
-						for _, trade := range trades {
-							price, _ := strconv.ParseFloat(trade.Price, 64)
-							qty, _ := strconv.ParseFloat(trade.Quantity, 64) // 使用 Quantity 而不是 Qty
-
-							// 检查交易是否已存在
-							var exists bool
-							cfg.DB.Model(&models.Trade{}).
-								Where("user_id = ? AND symbol = ? AND time = ?", user.ID, symbol, trade.Time).
-								Select("count(*) > 0").
-								Find(&exists)
-
-							if !exists {
-								newTrade := models.Trade{
-									UserID: user.ID,
-									Symbol: symbol,
-									Price:  price,
-									Qty:    qty,
-									Time:   trade.Time,
-								}
-								if err := cfg.DB.Create(&newTrade).Error; err != nil {
-									log.Printf("保存交易记录失败: %v", err)
-								}
-							}
+					if !exists {
+						newTrade := models.Trade{
+							UserID: user.ID,
+							Symbol: symbol,
+							Price:  price,
+							Qty:    qty,
+							Time:   trade.Time,
+						}
+						if err := cfg.DB.Create(&newTrade).Error; err != nil {
+							log.Printf("保存交易记录失败: %v", err)
 						}
 					}
-
-					// 重新查询数据库以获取所有交易（包括新添加的）
-					cfg.DB.Where("user_id = ?", user.ID).Order("time desc").Find(&dbTrades)
 				}
 			}
+
+			// 重新查询数据库以获取所有交易（包括新添加的）
+			cfg.DB.Where("user_id = ?", user.ID).Order("time desc").Find(&dbTrades)
 		}
 
 		// 格式化交易记录
@@ -292,83 +332,56 @@ func GinOrdersHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// 如果用户设置了API密钥，同步开放订单
-		if user.APIKey != "" && user.SecretKey != "" {
-			// 解密API密钥
-			apiKey, err := user.GetDecryptedAPIKey()
+		// 同步用户各交易所账户的开放订单
+		var accounts []models.ExchangeAccount
+		cfg.DB.Where("user_id = ? AND status = ?", user.ID, "active").Find(&accounts)
+		for i := range accounts {
+			exchange, err := services.NewAccountExchange(&accounts[i])
 			if err != nil {
-				log.Printf("解密用户 %d API Key失败: %v", user.ID, err)
-				// 继续返回数据库中的订单
-			} else if apiKey == "" {
-				log.Printf("用户 %d 解密后的API Key为空", user.ID)
-			} else if len(apiKey) != 64 {
-				log.Printf("用户 %d API Key格式错误，长度=%d，期望=64", user.ID, len(apiKey))
-			} else {
-				// API Key格式正确，继续解密Secret Key
-				secretKey, err := user.GetDecryptedSecretKey()
-				if err != nil {
-					log.Printf("解密用户 %d Secret Key失败: %v", user.ID, err)
-				} else if secretKey == "" {
-					log.Printf("用户 %d 解密后的Secret Key为空", user.ID)
-				} else if len(secretKey) != 64 {
-					log.Printf("用户 %d Secret Key格式错误，长度=%d，期望=64", user.ID, len(secretKey))
-				} else {
-					// 两个密钥都正确，尝试获取开放订单
-					client := binance.NewClient(apiKey, secretKey)
-
-					// 获取所有开放订单
-					openOrders, err := client.NewListOpenOrdersService().Do(context.Background())
-					if err != nil {
-						log.Printf("获取开放订单失败: %v", err)
-						// 即使API调用失败，也继续返回数据库中的订单
-					} else {
-						// 创建开放订单映射
-						openOrderMap := make(map[int64]bool)
-						for _, order := range openOrders {
-							openOrderMap[order.OrderID] = true
-
-							// 检查订单是否已在数据库中
-							var dbOrder models.Order
-							result := cfg.DB.Where("order_id = ? AND user_id = ?", order.OrderID, user.ID).First(&dbOrder)
-
-							price, _ := strconv.ParseFloat(order.Price, 64)
-							quantity, _ := strconv.ParseFloat(order.OrigQuantity, 64)
-
-							if result.Error != nil {
-								// 订单不存在，创建新订单
-								newOrder := models.Order{
-									UserID:      user.ID,
-									Symbol:      order.Symbol,
-									Side:        string(order.Side),
-									Price:       price,
-									Quantity:    quantity,
-									OrderID:     order.OrderID,
-									Status:      "pending",
-									CancelAfter: time.Now().Add(2 * time.Hour),
-								}
-								cfg.DB.Create(&newOrder)
-							} else {
-								// 更新现有订单状态
-								if dbOrder.Status != "pending" {
-									cfg.DB.Model(&dbOrder).Update("status", "pending")
-								}
-							}
-						}
+				log.Printf("用户 %d 账户 %d 创建交易所客户端失败: %v", user.ID, accounts[i].ID, err)
+				continue
+			}
 
-						// 更新数据库中的订单状态
-						for i := range dbOrders {
-							if dbOrders[i].Status == "pending" && !openOrderMap[dbOrders[i].OrderID] {
-								// 订单不在开放订单列表中，可能已完成或取消
-								// 这里暂时不更新状态，让后台任务处理
-							}
-						}
-					}
+			// 获取所有开放订单
+			openOrders, err := exchange.ListOpenOrders(context.Background(), "")
+			if err != nil {
+				log.Printf("获取开放订单失败: %v", err)
+				// 即使API调用失败，也继续返回数据库中的订单
+				continue
+			}
 
-					// 重新查询数据库
-					cfg.DB.Where("user_id = ?", user.ID).Order("created_at desc").Find(&dbOrders)
+			for _, order := range openOrders {
+				// 检查订单是否已在数据库中
+				var dbOrder models.Order
+				result := cfg.DB.Where("order_id = ? AND user_id = ? AND account_id = ?", order.OrderID, user.ID, accounts[i].ID).First(&dbOrder)
+
+				price, _ := decimal.NewFromString(order.Price)
+				quantity, _ := decimal.NewFromString(order.OrigQuantity)
+
+				if result.Error != nil {
+					// 订单不存在，创建新订单
+					newOrder := models.Order{
+						UserID:      user.ID,
+						AccountID:   accounts[i].ID,
+						Symbol:      order.Symbol,
+						Side:        string(order.Side),
+						Price:       price,
+						Quantity:    quantity,
+						OrderID:     order.OrderID,
+						Status:      "pending",
+						CancelAfter: time.Now().Add(2 * time.Hour),
+					}
+					cfg.DB.Create(&newOrder)
+				} else if dbOrder.Status != "pending" {
+					// 更新现有订单状态
+					cfg.DB.Model(&dbOrder).Update("status", "pending")
 				}
 			}
 		}
+		if len(accounts) > 0 {
+			// 重新查询数据库
+			cfg.DB.Where("user_id = ?", user.ID).Order("created_at desc").Find(&dbOrders)
+		}
 
 		// 格式化订单数据
 		orders := make([]map[string]interface{}, 0, len(dbOrders))
@@ -382,6 +395,7 @@ func GinOrdersHandler(cfg *config.Config) gin.HandlerFunc {
 				"quantity":    order.Quantity,
 				"status":      order.Status,
 				"cancelAfter": order.CancelAfter,
+				"accountId":   order.AccountID,
 				"createdAt":   order.CreatedAt,
 				"updatedAt":   order.UpdatedAt,
 			})
@@ -439,16 +453,12 @@ func GinCreateOrderHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if user.APIKey == "" || user.SecretKey == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "API 密钥未设置"})
-			return
-		}
-
 		var orderReq struct {
-			Symbol   string  `json:"symbol" binding:"required"`
-			Side     string  `json:"side" binding:"required"`
-			Quantity float64 `json:"quantity" binding:"required,gt=0"`
-			Price    float64 `json:"price" binding:"required,gt=0"`
+			Symbol    string          `json:"symbol" binding:"required"`
+			Side      string          `json:"side" binding:"required"`
+			Quantity  decimal.Decimal `json:"quantity"`
+			Price     decimal.Decimal `json:"price"`
+			AccountID uint            `json:"accountId"` // 为0时使用默认账户
 		}
 
 		if err := c.ShouldBindJSON(&orderReq); err != nil {
@@ -456,23 +466,88 @@ func GinCreateOrderHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		// 模拟盘用户不需要交易所账户
+		accountID, err := services.ResolveTradingAccountID(cfg.DB, user.ID, orderReq.AccountID, models.AccountPermSpot, user.PaperTrading)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !orderReq.Price.IsPositive() || !orderReq.Quantity.IsPositive() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "价格和数量必须大于0"})
+			return
+		}
+
 		// 验证side
 		if orderReq.Side != "BUY" && orderReq.Side != "SELL" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的交易方向"})
 			return
 		}
 
-		client := binance.NewClient(user.APIKey, user.SecretKey)
+		exchange, err := services.NewTradingExchange(cfg.DB, user.ID, accountID, user.PaperTrading)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 按账户所在环境的交易规则校验
+		filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), orderReq.Symbol)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("获取交易对规则失败: %v", err)})
+			return
+		}
+
+		// 按交易所精度规整价格和数量后再校验
+		orderReq.Price = filters.QuantizePrice(orderReq.Price)
+		orderReq.Quantity = filters.QuantizeQuantity(orderReq.Quantity, services.RoundDown)
+		priceStr := filters.PriceString(orderReq.Price)
+		quantityStr := filters.QuantityString(orderReq.Quantity)
+		// 参考价取下单账户所在环境的最新成交价，用于价格偏离和名义价值检查
+		var refPrice float64
+		if prices, err := exchange.ListPrices(c.Request.Context(), orderReq.Symbol); err == nil && len(prices) > 0 {
+			refPrice, _ = strconv.ParseFloat(prices[0].Price, 64)
+		}
+		if err := filters.Validate(orderReq.Side, orderReq.Price.InexactFloat64(), orderReq.Quantity.InexactFloat64(), refPrice, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 风控检查
+		notional := orderReq.Price.Mul(orderReq.Quantity)
+		spendAsset, spend := filters.QuoteAsset, notional
+		if orderReq.Side == "SELL" {
+			spendAsset, spend = filters.BaseAsset, orderReq.Quantity
+		}
+		if err := tasks.CheckOrderRisk(cfg, exchange, tasks.RiskOrder{
+			UserID:        user.ID,
+			AccountID:     accountID,
+			Market:        tasks.RiskMarketSpot,
+			Source:        "manual",
+			Symbol:        orderReq.Symbol,
+			Side:          orderReq.Side,
+			Notional:      notional.InexactFloat64(),
+			NotionalAsset: filters.QuoteAsset,
+			SpendAsset:    spendAsset,
+			Spend:         spend.InexactFloat64(),
+			Orders:        1,
+			Paper:         user.PaperTrading,
+		}); err != nil {
+			if tasks.IsRiskViolation(err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
 
 		// 创建订单
-		order, err := client.NewCreateOrderService().
-			Symbol(orderReq.Symbol).
-			Side(binance.SideType(orderReq.Side)).
-			Type(binance.OrderTypeLimit).
-			TimeInForce(binance.TimeInForceTypeGTC).
-			Quantity(fmt.Sprintf("%.8f", orderReq.Quantity)).
-			Price(fmt.Sprintf("%.8f", orderReq.Price)).
-			Do(context.Background())
+		order, err := exchange.CreateOrder(context.Background(), services.SpotOrderRequest{
+			Symbol:      orderReq.Symbol,
+			Side:        binance.SideType(orderReq.Side),
+			Type:        binance.OrderTypeLimit,
+			TimeInForce: binance.TimeInForceTypeGTC,
+			Quantity:    quantityStr,
+			Price:       priceStr,
+		})
 
 		if err != nil {
 			log.Printf("创建订单失败: %v", err)
@@ -483,6 +558,7 @@ func GinCreateOrderHandler(cfg *config.Config) gin.HandlerFunc {
 		// 保存到数据库
 		dbOrder := models.Order{
 			UserID:      user.ID,
+			AccountID:   accountID,
 			Symbol:      orderReq.Symbol,
 			Side:        orderReq.Side,
 			Price:       orderReq.Price,
@@ -490,6 +566,7 @@ func GinCreateOrderHandler(cfg *config.Config) gin.HandlerFunc {
 			OrderID:     order.OrderID,
 			Status:      "pending",
 			CancelAfter: time.Now().Add(2 * time.Hour),
+			Paper:       user.PaperTrading,
 		}
 
 		if err := cfg.DB.Create(&dbOrder).Error; err != nil {
@@ -509,6 +586,7 @@ func GinCreateOrderHandler(cfg *config.Config) gin.HandlerFunc {
 				"price":    dbOrder.Price,
 				"quantity": dbOrder.Quantity,
 				"status":   dbOrder.Status,
+				"paper":    dbOrder.Paper,
 			},
 		})
 	}
@@ -523,11 +601,6 @@ func GinCancelOrderHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if user.APIKey == "" || user.SecretKey == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "API 密钥未设置"})
-			return
-		}
-
 		orderIDStr := c.Param("orderId")
 		orderID, err := strconv.ParseInt(orderIDStr, 10, 64)
 		if err != nil {
@@ -547,13 +620,15 @@ func GinCancelOrderHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		client := binance.NewClient(user.APIKey, user.SecretKey)
+		// 模拟盘订单在模拟撮合引擎中撤销，不需要API密钥
+		exchange, err := services.NewTradingExchange(cfg.DB, user.ID, order.AccountID, order.Paper)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// 取消订单
-		_, err = client.NewCancelOrderService().
-			Symbol(order.Symbol).
-			OrderID(order.OrderID).
-			Do(context.Background())
+		err = exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
 
 		if err != nil {
 			// 检查是否因为订单已经不存在
@@ -587,11 +662,6 @@ func GinBatchCancelOrdersHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if user.APIKey == "" || user.SecretKey == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "API 密钥未设置"})
-			return
-		}
-
 		var request struct {
 			OrderIDs []int64 `json:"orderIds" binding:"required"`
 		}
@@ -619,7 +689,6 @@ func GinBatchCancelOrdersHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		client := binance.NewClient(user.APIKey, user.SecretKey)
 		results := struct {
 			Success []int64 `json:"success"`
 			Failed  []struct {
@@ -634,12 +703,28 @@ func GinBatchCancelOrdersHandler(cfg *config.Config) gin.HandlerFunc {
 			}{},
 		}
 
-		// 批量取消订单
+		// 批量取消订单，模拟盘和各交易所账户的订单分别走各自的交易所
+		type exchangeKey struct {
+			paper     bool
+			accountID uint
+		}
+		exchanges := make(map[exchangeKey]services.Exchange)
 		for _, order := range orders {
-			_, err := client.NewCancelOrderService().
-				Symbol(order.Symbol).
-				OrderID(order.OrderID).
-				Do(context.Background())
+			var err error
+			key := exchangeKey{paper: order.Paper, accountID: order.AccountID}
+			if order.Paper {
+				key.accountID = 0
+			}
+			exchange, ok := exchanges[key]
+			if !ok {
+				exchange, err = services.NewTradingExchange(cfg.DB, user.ID, order.AccountID, order.Paper)
+				if err == nil {
+					exchanges[key] = exchange
+				}
+			}
+			if err == nil {
+				err = exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
+			}
 
 			if err != nil {
 				// 检查是否因为订单已经不存在
@@ -690,28 +775,28 @@ func GinWithdrawalHistoryHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		// 如果用户设置了API密钥，尝试从币安获取最新提币历史
-		if user.APIKey != "" && user.SecretKey != "" && len(history) == 0 {
-			client := binance.NewClient(user.APIKey, user.SecretKey)
-
+		// 如果用户设置了默认交易所账户，尝试从币安获取最新提币历史
+		if account, err := services.ResolveAccount(cfg.DB, user.ID, 0); err == nil && len(history) == 0 {
 			// 获取最近90天的提币历史
 			endTime := time.Now().UnixMilli()
 			startTime := time.Now().AddDate(0, 0, -90).UnixMilli()
 
-			withdrawals, err := client.NewListWithdrawsService().
-				StartTime(startTime).
-				EndTime(endTime).
-				Do(context.Background())
+			var withdrawals []*binance.Withdraw
+			exchange, err := services.NewAccountExchange(account)
+			if err == nil {
+				withdrawals, err = exchange.ListWithdraws(context.Background(), startTime, endTime)
+			}
 
 			if err != nil {
 				log.Printf("获取币安提币历史失败: %v", err)
 			} else {
 				// 保存提币历史到数据库
 				for _, w := range withdrawals {
-					amount, _ := strconv.ParseFloat(w.Amount, 64)
+					amount, _ := decimal.NewFromString(w.Amount)
 
 					withdrawalHistory := models.WithdrawalHistory{
 						UserID:       user.ID,
+						AccountID:    account.ID,
 						Asset:        w.Coin,
 						Amount:       amount,
 						Address:      w.Address,
@@ -908,11 +993,12 @@ func GinCreateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 
 		// 使用自定义结构体接收请求数据
 		var req struct {
-			Asset     string  `json:"asset" binding:"required"`
-			Threshold float64 `json:"threshold" binding:"required,gt=0"`
-			Amount    float64 `json:"amount" binding:"min=0"` // 允许为0，表示提取最大值
-			Address   string  `json:"address" binding:"required"`
-			Enabled   bool    `json:"enabled"`
+			Asset     string          `json:"asset" binding:"required"`
+			Threshold decimal.Decimal `json:"threshold"`
+			Amount    decimal.Decimal `json:"amount"` // 允许为0，表示提取最大值
+			Address   string          `json:"address" binding:"required"`
+			Enabled   bool            `json:"enabled"`
+			AccountID uint            `json:"accountId"` // 交易所账户，为0时使用默认账户
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -930,11 +1016,28 @@ func GinCreateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		if !req.Threshold.IsPositive() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "阈值必须大于0"})
+			return
+		}
+
+		if req.Amount.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "提币金额不能为负数"})
+			return
+		}
+
 		if len(strings.TrimSpace(req.Address)) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "提币地址不能为空"})
 			return
 		}
 
+		// 提币账户需开通提币权限
+		accountID, err := services.ResolveTradingAccountID(cfg.DB, user.ID, req.AccountID, models.AccountPermWithdraw, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// 检查是否已存在相同资产和地址的规则
 		var existingRule models.Withdrawal
 		if err := cfg.DB.Where("user_id = ? AND asset = ? AND address = ? AND deleted_at IS NULL",
@@ -947,6 +1050,7 @@ func GinCreateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 		// 创建提币规则
 		rule := models.Withdrawal{
 			UserID:    user.ID,
+			AccountID: accountID,
 			Asset:     strings.ToUpper(strings.TrimSpace(req.Asset)),
 			Threshold: req.Threshold,
 			Amount:    req.Amount, // 如果为0，表示提取最大可用金额
@@ -961,7 +1065,7 @@ func GinCreateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		log.Printf("用户 %d 创建提币规则成功: %s, 阈值=%.8f, 金额=%.8f",
+		log.Printf("用户 %d 创建提币规则成功: %s, 阈值=%s, 金额=%s",
 			user.ID, rule.Asset, rule.Threshold, rule.Amount)
 
 		c.JSON(http.StatusOK, gin.H{
@@ -973,6 +1077,7 @@ func GinCreateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 				"amount":    rule.Amount,
 				"address":   rule.Address,
 				"enabled":   rule.Enabled,
+				"accountId": rule.AccountID,
 				"status":    rule.Status,
 				"createdAt": rule.CreatedAt,
 			},
@@ -1008,6 +1113,7 @@ func GinListWithdrawalRulesHandler(cfg *config.Config) gin.HandlerFunc {
 				"amount":    rule.Amount,
 				"address":   rule.Address,
 				"enabled":   rule.Enabled,
+				"accountId": rule.AccountID,
 				"status":    rule.Status,
 				"createdAt": rule.CreatedAt,
 				"updatedAt": rule.UpdatedAt,
@@ -1048,11 +1154,11 @@ func GinUpdateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 
 		// 使用自定义结构体接收更新数据
 		var updateReq struct {
-			Asset     string  `json:"asset"`
-			Threshold float64 `json:"threshold"`
-			Amount    float64 `json:"amount"`
-			Address   string  `json:"address"`
-			Enabled   bool    `json:"enabled"`
+			Asset     string          `json:"asset"`
+			Threshold decimal.Decimal `json:"threshold"`
+			Amount    decimal.Decimal `json:"amount"`
+			Address   string          `json:"address"`
+			Enabled   bool            `json:"enabled"`
 		}
 
 		if err := c.ShouldBindJSON(&updateReq); err != nil {
@@ -1069,12 +1175,12 @@ func GinUpdateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		if updateReq.Threshold <= 0 {
+		if !updateReq.Threshold.IsPositive() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "阈值必须大于0"})
 			return
 		}
 
-		if updateReq.Amount < 0 {
+		if updateReq.Amount.IsNegative() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "提币金额不能为负数"})
 			return
 		}
@@ -1115,6 +1221,7 @@ func GinUpdateWithdrawalRuleHandler(cfg *config.Config) gin.HandlerFunc {
 				"amount":    rule.Amount,
 				"address":   rule.Address,
 				"enabled":   rule.Enabled,
+				"accountId": rule.AccountID,
 				"status":    rule.Status,
 				"updatedAt": rule.UpdatedAt,
 			},
@@ -1161,18 +1268,27 @@ func GinCreateStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		var strategyReq struct {
-			Symbol             string    `json:"symbol" binding:"required"`
-			StrategyType       string    `json:"strategyType" binding:"required"`
-			Side               string    `json:"side" binding:"required"`
-			Price              float64   `json:"price" binding:"required,gt=0"`
-			TotalQuantity      float64   `json:"totalQuantity" binding:"required,gt=0"`
-			BuyQuantities      []float64 `json:"buyQuantities"`
-			SellQuantities     []float64 `json:"sellQuantities"`
-			BuyDepthLevels     []int     `json:"buyDepthLevels"`
-			SellDepthLevels    []int     `json:"sellDepthLevels"`
-			BuyBasisPoints     []float64 `json:"buyBasisPoints"`  // 新增：买入万分比
-			SellBasisPoints    []float64 `json:"sellBasisPoints"` // 新增：卖出万分比
-			CancelAfterMinutes int       `json:"cancelAfterMinutes"`
+			Symbol             string          `json:"symbol" binding:"required"`
+			StrategyType       string          `json:"strategyType" binding:"required"`
+			Side               string          `json:"side"`
+			Price              decimal.Decimal `json:"price"`
+			TotalQuantity      decimal.Decimal `json:"totalQuantity"`
+			BuyQuantities      []float64       `json:"buyQuantities"`
+			SellQuantities     []float64       `json:"sellQuantities"`
+			BuyDepthLevels     []int           `json:"buyDepthLevels"`
+			SellDepthLevels    []int           `json:"sellDepthLevels"`
+			BuyBasisPoints     []float64       `json:"buyBasisPoints"`  // 新增：买入万分比
+			SellBasisPoints    []float64       `json:"sellBasisPoints"` // 新增：卖出万分比
+			CancelAfterMinutes int             `json:"cancelAfterMinutes"`
+			Paper              bool            `json:"paper"`            // 模拟盘策略
+			TriggerCondition   string          `json:"triggerCondition"` // 指标触发条件
+			// 网格策略参数
+			GridLowerPrice float64 `json:"gridLowerPrice"`
+			GridUpperPrice float64 `json:"gridUpperPrice"`
+			GridCount      int     `json:"gridCount"`
+			GridQuantity   float64 `json:"gridQuantity"`
+			GridRebalance  bool    `json:"gridRebalance"`
+			AccountID      uint    `json:"accountId"` // 交易所账户，为0时使用默认账户
 		}
 
 		if err := c.ShouldBindJSON(&strategyReq); err != nil {
@@ -1180,16 +1296,64 @@ func GinCreateStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
+		accountID, err := services.ResolveTradingAccountID(cfg.DB, user.ID, strategyReq.AccountID, models.AccountPermSpot,
+			strategyReq.Paper || user.PaperTrading)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// 验证策略类型
-		if strategyReq.StrategyType != "simple" && strategyReq.StrategyType != "iceberg" && strategyReq.StrategyType != "custom" {
+		if strategyReq.StrategyType != "simple" && strategyReq.StrategyType != "iceberg" &&
+			strategyReq.StrategyType != "custom" && strategyReq.StrategyType != "grid" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的策略类型"})
 			return
 		}
 
-		// 验证交易方向
-		if strategyReq.Side != "BUY" && strategyReq.Side != "SELL" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的交易方向"})
-			return
+		strategyReq.TriggerCondition = strings.TrimSpace(strategyReq.TriggerCondition)
+		if strategyReq.TriggerCondition != "" {
+			if strategyReq.StrategyType == "grid" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "网格策略不支持指标触发条件"})
+				return
+			}
+			if _, err := services.ParseCondition(strategyReq.TriggerCondition); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的触发条件", "details": err.Error()})
+				return
+			}
+		}
+
+		if strategyReq.StrategyType == "grid" {
+			// 网格策略双向挂单，不使用触发价格和交易方向
+			if strategyReq.GridLowerPrice <= 0 || strategyReq.GridUpperPrice <= strategyReq.GridLowerPrice {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "网格上限价格必须大于下限价格，且下限价格必须大于0"})
+				return
+			}
+			if strategyReq.GridCount < 2 || strategyReq.GridCount > 200 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "网格数量必须在2-200之间"})
+				return
+			}
+			if strategyReq.GridQuantity <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "每格数量必须大于0"})
+				return
+			}
+			strategyReq.Side = "BOTH"
+			strategyReq.Price = decimal.Zero
+			strategyReq.TotalQuantity = decimal.NewFromFloat(strategyReq.GridQuantity).Mul(decimal.NewFromInt(int64(strategyReq.GridCount)))
+		} else {
+			// 验证交易方向
+			if strategyReq.Side != "BUY" && strategyReq.Side != "SELL" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的交易方向"})
+				return
+			}
+			if !strategyReq.TotalQuantity.IsPositive() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "总数量必须大于0"})
+				return
+			}
+			// 设置了指标条件时可以不设触发价格
+			if strategyReq.Price.IsNegative() || (strategyReq.TriggerCondition == "" && !strategyReq.Price.IsPositive()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "触发价格必须大于0"})
+				return
+			}
 		}
 
 		// 设置默认的取消时间
@@ -1356,6 +1520,7 @@ func GinCreateStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 		// 创建策略
 		strategy := models.Strategy{
 			UserID:             user.ID,
+			AccountID:          accountID,
 			Symbol:             strings.ToUpper(strategyReq.Symbol),
 			StrategyType:       strategyReq.StrategyType,
 			Side:               strategyReq.Side,
@@ -1370,6 +1535,13 @@ func GinCreateStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 			BuyBasisPoints:     buyBasisPointsStr,  // 新增
 			SellBasisPoints:    sellBasisPointsStr, // 新增
 			CancelAfterMinutes: strategyReq.CancelAfterMinutes,
+			Paper:              strategyReq.Paper,
+			TriggerCondition:   strategyReq.TriggerCondition,
+			GridLowerPrice:     decimal.NewFromFloat(strategyReq.GridLowerPrice),
+			GridUpperPrice:     decimal.NewFromFloat(strategyReq.GridUpperPrice),
+			GridCount:          strategyReq.GridCount,
+			GridQuantity:       decimal.NewFromFloat(strategyReq.GridQuantity),
+			GridRebalance:      strategyReq.GridRebalance,
 		}
 
 		if err := cfg.DB.Create(&strategy).Error; err != nil {
@@ -1485,6 +1657,13 @@ func GinListStrategiesHandler(cfg *config.Config) gin.HandlerFunc {
 				"sellBasisPoints":    sellBasisPoints, // 新增
 				"pendingBatch":       s.PendingBatch,
 				"cancelAfterMinutes": s.CancelAfterMinutes,
+				"gridLowerPrice":     s.GridLowerPrice,
+				"gridUpperPrice":     s.GridUpperPrice,
+				"gridCount":          s.GridCount,
+				"gridQuantity":       s.GridQuantity,
+				"gridRebalance":      s.GridRebalance,
+				"triggerCondition":   s.TriggerCondition,
+				"accountId":          s.AccountID,
 				"createdAt":          s.CreatedAt,
 				"updatedAt":          s.UpdatedAt,
 			})
@@ -1533,14 +1712,10 @@ func GinToggleStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 			if err := cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "pending").
 				Find(&orders).Error; err == nil && len(orders) > 0 {
 
-				// 获取用户API密钥
-				if user.APIKey != "" && user.SecretKey != "" {
-					client := binance.NewClient(user.APIKey, user.SecretKey)
-					for _, order := range orders {
-						client.NewCancelOrderService().
-							Symbol(order.Symbol).
-							OrderID(order.OrderID).
-							Do(context.Background())
+				for _, order := range orders {
+					// 模拟盘订单在模拟撮合引擎中撤销
+					if exchange, err := services.NewTradingExchange(cfg.DB, user.ID, order.AccountID, order.Paper); err == nil {
+						exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
 
 						cfg.DB.Model(&order).Update("status", "cancelled")
 					}
@@ -1590,14 +1765,10 @@ func GinDeleteStrategyHandler(cfg *config.Config) gin.HandlerFunc {
 		if err := cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "pending").
 			Find(&orders).Error; err == nil && len(orders) > 0 {
 
-			// 获取用户API密钥
-			if user.APIKey != "" && user.SecretKey != "" {
-				client := binance.NewClient(user.APIKey, user.SecretKey)
-				for _, order := range orders {
-					client.NewCancelOrderService().
-						Symbol(order.Symbol).
-						OrderID(order.OrderID).
-						Do(context.Background())
+			for _, order := range orders {
+				// 模拟盘订单在模拟撮合引擎中撤销
+				if exchange, err := services.NewTradingExchange(cfg.DB, user.ID, order.AccountID, order.Paper); err == nil {
+					exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
 
 					cfg.DB.Model(&order).Update("status", "cancelled")
 				}
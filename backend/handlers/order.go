@@ -13,30 +13,43 @@ import (
 	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
 )
 
 func OrdersHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username := r.Header.Get("username")
 		var user models.User
-		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil || user.APIKey == "" || user.SecretKey == "" {
-			log.Printf("用户未找到或未设置 API 密钥: %s", username)
+		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil {
+			log.Printf("用户未找到: %s", username)
+			http.Error(w, `{"error": "用户未找到"}`, http.StatusNotFound)
+			return
+		}
+		account, err := services.ResolveAccount(cfg.DB, user.ID, 0)
+		if err != nil {
+			log.Printf("用户 %s 未设置 API 密钥: %v", username, err)
 			http.Error(w, `{"error": "API 密钥未设置"}`, http.StatusBadRequest)
 			return
 		}
-		client := binance.NewClient(user.APIKey, user.SecretKey)
-		orders, err := client.NewListOpenOrdersService().Do(context.Background())
+		exchange, err := services.NewAccountExchange(account)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		orders, err := exchange.ListOpenOrders(context.Background(), "")
 		if err != nil {
 			log.Printf("获取订单失败: %v", err)
 			http.Error(w, fmt.Sprintf(`{"error": "获取订单失败: %v"}`, err), http.StatusInternalServerError)
 			return
 		}
 		for _, o := range orders {
-			price, _ := strconv.ParseFloat(o.Price, 64)
-			quantity, _ := strconv.ParseFloat(o.OrigQuantity, 64)
+			price, _ := decimal.NewFromString(o.Price)
+			quantity, _ := decimal.NewFromString(o.OrigQuantity)
 			dbOrder := models.Order{
 				UserID:      user.ID,
+				AccountID:   account.ID,
 				Symbol:      o.Symbol,
 				Side:        string(o.Side),
 				Price:       price,
@@ -77,9 +90,9 @@ func CancelOrderHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username := r.Header.Get("username")
 		var user models.User
-		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil || user.APIKey == "" || user.SecretKey == "" {
-			log.Printf("用户未找到或未设置 API 密钥: %s", username)
-			http.Error(w, `{"error": "API 密钥未设置"}`, http.StatusBadRequest)
+		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil {
+			log.Printf("用户未找到: %s", username)
+			http.Error(w, `{"error": "用户未找到"}`, http.StatusNotFound)
 			return
 		}
 		vars := mux.Vars(r)
@@ -101,8 +114,12 @@ func CancelOrderHandler(cfg *config.Config) http.HandlerFunc {
 			http.Error(w, `{"error": "订单 symbol 为空"}`, http.StatusBadRequest)
 			return
 		}
-		client := binance.NewClient(user.APIKey, user.SecretKey)
-		_, err = client.NewCancelOrderService().Symbol(order.Symbol).OrderID(order.OrderID).Do(context.Background())
+		exchange, err := services.NewUserExchange(cfg.DB, user.ID, order.AccountID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusBadRequest)
+			return
+		}
+		err = exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
 		if err != nil {
 			if strings.Contains(err.Error(), "Order does not exist") {
 				order.Status = "cancelled"
@@ -128,31 +145,41 @@ func CreateOrderHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username := r.Header.Get("username")
 		var user models.User
-		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil || user.APIKey == "" || user.SecretKey == "" {
-			log.Printf("用户未找到或未设置 API 密钥: %s", username)
+		if err := cfg.DB.Where("username = ?", username).First(&user).Error; err != nil {
+			log.Printf("用户未找到: %s", username)
+			http.Error(w, `{"error": "用户未找到"}`, http.StatusNotFound)
+			return
+		}
+		account, err := services.ResolveAccount(cfg.DB, user.ID, 0)
+		if err != nil {
+			log.Printf("用户 %s 未设置 API 密钥: %v", username, err)
 			http.Error(w, `{"error": "API 密钥未设置"}`, http.StatusBadRequest)
 			return
 		}
 		var orderReq struct {
-			Symbol   string  `json:"symbol"`
-			Side     string  `json:"side"`
-			Quantity float64 `json:"quantity"`
-			Price    float64 `json:"price"`
+			Symbol   string          `json:"symbol"`
+			Side     string          `json:"side"`
+			Quantity decimal.Decimal `json:"quantity"`
+			Price    decimal.Decimal `json:"price"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&orderReq); err != nil {
 			log.Printf("JSON 解码错误: %v", err)
 			http.Error(w, `{"error": "无效的请求"}`, http.StatusBadRequest)
 			return
 		}
-		client := binance.NewClient(user.APIKey, user.SecretKey)
-		order, err := client.NewCreateOrderService().
-			Symbol(orderReq.Symbol).
-			Side(binance.SideType(orderReq.Side)).
-			Type(binance.OrderTypeLimit).
-			TimeInForce(binance.TimeInForceTypeGTC).
-			Quantity(fmt.Sprintf("%.8f", orderReq.Quantity)).
-			Price(fmt.Sprintf("%.8f", orderReq.Price)).
-			Do(context.Background())
+		exchange, err := services.NewAccountExchange(account)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		order, err := exchange.CreateOrder(context.Background(), services.SpotOrderRequest{
+			Symbol:      orderReq.Symbol,
+			Side:        binance.SideType(orderReq.Side),
+			Type:        binance.OrderTypeLimit,
+			TimeInForce: binance.TimeInForceTypeGTC,
+			Quantity:    orderReq.Quantity.String(),
+			Price:       orderReq.Price.String(),
+		})
 		if err != nil {
 			log.Printf("下单失败: %v", err)
 			http.Error(w, fmt.Sprintf(`{"error": "下单失败: %v"}`, err), http.StatusInternalServerError)
@@ -160,6 +187,7 @@ func CreateOrderHandler(cfg *config.Config) http.HandlerFunc {
 		}
 		dbOrder := models.Order{
 			UserID:      user.ID,
+			AccountID:   account.ID,
 			Symbol:      orderReq.Symbol,
 			Side:        orderReq.Side,
 			Price:       orderReq.Price,
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/tasks"
+	"github.com/gin-gonic/gin"
+)
+
+// GinPnLHandler 按成交明细计算成本和盈亏
+// 查询参数：method（fifo/average）、groupBy（asset/strategy）、symbol、strategyId、dcaStrategyId、paper
+func GinPnLHandler(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := getUserFromGinContext(c, cfg)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户未找到"})
+			return
+		}
+
+		// 模拟盘和实盘的持仓互不相关，默认跟随用户当前模式
+		paper := user.PaperTrading
+		if v := c.Query("paper"); v != "" {
+			paper, err = strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的paper参数"})
+				return
+			}
+		}
+
+		query := cfg.DB.Where("user_id = ? AND paper = ? AND deleted_at IS NULL", user.ID, paper)
+		if symbol := c.Query("symbol"); symbol != "" {
+			query = query.Where("symbol = ?", strings.ToUpper(symbol))
+		}
+		if idStr := c.Query("strategyId"); idStr != "" {
+			id, err := strconv.ParseUint(idStr, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的策略ID"})
+				return
+			}
+			query = query.Where("strategy_id = ?", id)
+		}
+		if idStr := c.Query("dcaStrategyId"); idStr != "" {
+			id, err := strconv.ParseUint(idStr, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的定投策略ID"})
+				return
+			}
+			query = query.Where("dca_strategy_id = ?", id)
+		}
+
+		var fills []models.Fill
+		if err := query.Find(&fills).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取成交记录失败"})
+			return
+		}
+
+		summaries, err := tasks.ComputePnL(fills, c.Query("method"), c.Query("groupBy"), tasks.PnLMarkPrices(fills))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var total struct {
+			RealizedPnL   float64 `json:"realizedPnl"`
+			UnrealizedPnL float64 `json:"unrealizedPnl"`
+			Commission    float64 `json:"commission"`
+			CostBasis     float64 `json:"costBasis"`
+		}
+		for _, s := range summaries {
+			total.RealizedPnL += s.RealizedPnL
+			total.UnrealizedPnL += s.UnrealizedPnL
+			total.Commission += s.Commission
+			total.CostBasis += s.CostBasis
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"summaries": summaries,
+			"total":     total,
+			"paper":     paper,
+			"currency":  "USDT",
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
 	"github.com/ccj241/binance/tasks"
+	"github.com/shopspring/decimal"
 )
 
 func CreateStrategyHandler(cfg *config.Config) http.HandlerFunc {
@@ -35,15 +36,15 @@ func CreateStrategyHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 		var strategyReq struct {
-			Symbol          string    `json:"symbol"`
-			StrategyType    string    `json:"strategyType"`
-			Side            string    `json:"side"`
-			Price           float64   `json:"price"`
-			TotalQuantity   float64   `json:"totalQuantity"`
-			BuyQuantities   []float64 `json:"buyQuantities"`
-			SellQuantities  []float64 `json:"sellQuantities"`
-			BuyDepthLevels  []int     `json:"buyDepthLevels"`
-			SellDepthLevels []int     `json:"sellDepthLevels"`
+			Symbol          string          `json:"symbol"`
+			StrategyType    string          `json:"strategyType"`
+			Side            string          `json:"side"`
+			Price           decimal.Decimal `json:"price"`
+			TotalQuantity   decimal.Decimal `json:"totalQuantity"`
+			BuyQuantities   []float64       `json:"buyQuantities"`
+			SellQuantities  []float64       `json:"sellQuantities"`
+			BuyDepthLevels  []int           `json:"buyDepthLevels"`
+			SellDepthLevels []int           `json:"sellDepthLevels"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&strategyReq); err != nil {
 			log.Printf("JSON 解码错误: %v", err)
@@ -3,7 +3,9 @@ package handlers
 import (
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/tasks"
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"net/http"
 	"strconv"
 )
@@ -66,11 +68,48 @@ func GinStrategyStatsHandler(cfg *config.Config) gin.HandlerFunc {
 		var orders []models.Order
 		cfg.DB.Where("strategy_id = ?", strategy.ID).Find(&orders)
 
+		var totalVolume, filledVolume decimal.Decimal
 		for _, order := range orders {
-			volume := order.Price * order.Quantity
-			stats.TotalVolume += volume
-			if order.Status == "filled" {
-				stats.FilledVolume += volume
+			totalVolume = totalVolume.Add(order.Price.Mul(order.Quantity))
+			// 已同步成交明细的订单按实际成交额统计，包括部分成交后撤销的订单
+			if order.ExecutedQty.IsPositive() {
+				filledVolume = filledVolume.Add(order.AvgPrice.Mul(order.ExecutedQty))
+			} else if order.Status == "filled" {
+				filledVolume = filledVolume.Add(order.Price.Mul(order.Quantity))
+			}
+		}
+		stats.TotalVolume = totalVolume.InexactFloat64()
+		stats.FilledVolume = filledVolume.InexactFloat64()
+
+		// 按成交明细计算策略盈亏
+		var fills []models.Fill
+		cfg.DB.Where("strategy_id = ? AND user_id = ? AND deleted_at IS NULL", strategy.ID, user.ID).Find(&fills)
+		var pnl *tasks.PnLSummary
+		if summaries, err := tasks.ComputePnL(fills, c.Query("method"), "strategy", tasks.PnLMarkPrices(fills)); err == nil && len(summaries) > 0 {
+			pnl = &summaries[0]
+		}
+
+		// 网格策略统计已完成的往返次数和已实现利润
+		var grid map[string]interface{}
+		if strategy.StrategyType == "grid" {
+			var gridStats struct {
+				RoundTrips     int64
+				RealizedProfit float64
+			}
+			cfg.DB.Model(&models.Order{}).
+				Select("COUNT(*) AS round_trips, COALESCE(SUM(grid_profit), 0) AS realized_profit").
+				Where("strategy_id = ? AND status = ? AND grid_entry_price > 0", strategy.ID, "filled").
+				Scan(&gridStats)
+
+			grid = map[string]interface{}{
+				"lowerPrice":     strategy.GridLowerPrice,
+				"upperPrice":     strategy.GridUpperPrice,
+				"gridCount":      strategy.GridCount,
+				"gridQuantity":   strategy.GridQuantity,
+				"gridStep":       strategy.GridStep(),
+				"rebalance":      strategy.GridRebalance,
+				"roundTrips":     gridStats.RoundTrips,
+				"realizedProfit": gridStats.RealizedProfit,
 			}
 		}
 
@@ -85,18 +124,23 @@ func GinStrategyStatsHandler(cfg *config.Config) gin.HandlerFunc {
 		formattedOrders := make([]map[string]interface{}, 0, len(recentOrders))
 		for _, order := range recentOrders {
 			formattedOrders = append(formattedOrders, map[string]interface{}{
-				"id":        order.ID,
-				"orderId":   order.OrderID,
-				"side":      order.Side,
-				"price":     order.Price,
-				"quantity":  order.Quantity,
-				"status":    order.Status,
-				"createdAt": order.CreatedAt,
+				"id":          order.ID,
+				"orderId":     order.OrderID,
+				"side":        order.Side,
+				"price":       order.Price,
+				"quantity":    order.Quantity,
+				"status":      order.Status,
+				"executedQty": order.ExecutedQty,
+				"avgPrice":    order.AvgPrice,
+				"gridLevel":   order.GridLevel,
+				"createdAt":   order.CreatedAt,
 			})
 		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"stats":        stats,
+			"grid":         grid,
+			"pnl":          pnl,
 			"recentOrders": formattedOrders,
 			"strategy": map[string]interface{}{
 				"id":            strategy.ID,
@@ -9,9 +9,9 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 	"github.com/ccj241/binance/tasks"
 )
 
@@ -100,14 +100,13 @@ func BalanceHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		if user.APIKey == "" || user.SecretKey == "" {
-			log.Printf("用户 %s 未设置 API 密钥", user.Username)
+		exchange, err := services.NewUserExchange(cfg.DB, user.ID, 0)
+		if err != nil {
+			log.Printf("用户 %s 未设置 API 密钥: %v", user.Username, err)
 			http.Error(w, `{"error": "API 密钥未设置"}`, http.StatusBadRequest)
 			return
 		}
-
-		client := binance.NewClient(user.APIKey, user.SecretKey)
-		account, err := client.NewGetAccountService().Do(context.Background())
+		account, err := exchange.GetAccount(context.Background())
 		if err != nil {
 			log.Printf("获取余额失败，用户 %d: %v", user.ID, err)
 			http.Error(w, `{"error": "获取余额失败"}`, http.StatusInternalServerError)
@@ -1,24 +1,53 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/migrations" // 添加这行
 	"github.com/ccj241/binance/models"
 	"github.com/ccj241/binance/routes"
+	"github.com/ccj241/binance/services"
 	"github.com/ccj241/binance/tasks"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
+	// 加载配置：默认值、配置文件、环境变量、命令行参数
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	log.Printf("运行模式: %s", cfg.Mode)
+
 	// 设置Gin模式
-	if os.Getenv("GIN_MODE") != "debug" {
+	if cfg.Server.GinMode != "debug" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	cfg := config.NewConfig()
+	if err := cfg.OpenDB(); err != nil {
+		log.Fatal("连接数据库失败: ", err)
+	}
+
+	// 交易所环境，必须在创建任何交易所实例之前设置
+	if err := services.SetDefaultEnvironment(cfg.BinanceEnv, cfg.BinanceBaseURL); err != nil {
+		log.Fatalf("交易所环境配置错误: %v", err)
+	}
+	services.SetNotifierConfig(services.NotifierConfig{
+		TelegramBotToken: cfg.Notify.TelegramBotToken,
+		SMTPHost:         cfg.Notify.SMTPHost,
+		SMTPPort:         cfg.Notify.SMTPPort,
+		SMTPUsername:     cfg.Notify.SMTPUsername,
+		SMTPPassword:     cfg.Notify.SMTPPassword,
+		SMTPFrom:         cfg.Notify.SMTPFrom,
+	})
 
 	// 数据库迁移
 	if err := models.MigrateDB(cfg.DB); err != nil {
@@ -44,6 +73,57 @@ func main() {
 	if err := migrations.AddSlowIcebergTimeout(cfg.DB); err != nil {
 		log.Fatalf("添加慢冰山超时字段失败: %v", err)
 	}
+	// 添加跟踪止损字段
+	if err := migrations.AddFuturesTrailingStop(cfg.DB); err != nil {
+		log.Fatalf("添加跟踪止损字段失败: %v", err)
+	}
+	// 迁移成交明细表
+	if err := models.MigrateFillTables(cfg.DB); err != nil {
+		log.Fatalf("成交明细表迁移失败: %v", err)
+	}
+	// 迁移定投相关表
+	if err := models.MigrateDCATables(cfg.DB); err != nil {
+		log.Fatalf("定投表迁移失败: %v", err)
+	}
+	// 添加现货网格字段
+	if err := migrations.AddSpotGrid(cfg.DB); err != nil {
+		log.Fatalf("添加网格策略字段失败: %v", err)
+	}
+	// 迁移模拟盘相关表
+	if err := models.MigratePaperTables(cfg.DB); err != nil {
+		log.Fatalf("模拟盘表迁移失败: %v", err)
+	}
+	if err := migrations.AddPaperTrading(cfg.DB); err != nil {
+		log.Fatalf("添加模拟盘字段失败: %v", err)
+	}
+	// 迁移风控相关表
+	if err := models.MigrateRiskTables(cfg.DB); err != nil {
+		log.Fatalf("风控表迁移失败: %v", err)
+	}
+	// 迁移通知相关表
+	if err := models.MigrateNotificationTables(cfg.DB); err != nil {
+		log.Fatalf("通知表迁移失败: %v", err)
+	}
+	// 迁移价格提醒相关表
+	if err := models.MigrateAlertTables(cfg.DB); err != nil {
+		log.Fatalf("价格提醒表迁移失败: %v", err)
+	}
+	// 迁移K线表
+	if err := models.MigrateCandleTables(cfg.DB); err != nil {
+		log.Fatalf("K线表迁移失败: %v", err)
+	}
+	// 迁移任务租约表
+	if err := models.MigrateLeaseTables(cfg.DB); err != nil {
+		log.Fatalf("任务租约表迁移失败: %v", err)
+	}
+	// 迁移交易所账户表，需在各业务表迁移之后执行以回填账户ID
+	if err := models.MigrateExchangeAccountTables(cfg.DB); err != nil {
+		log.Fatalf("交易所账户表迁移失败: %v", err)
+	}
+	// 价格、数量、金额字段转换为定点小数
+	if err := migrations.ConvertDecimalColumns(cfg.DB); err != nil {
+		log.Fatalf("转换定点小数字段失败: %v", err)
+	}
 	// 添加性能优化索引
 	if err := migrations.AddPerformanceIndexes(cfg.DB); err != nil {
 		log.Printf("添加性能索引时出现错误: %v", err)
@@ -55,7 +135,7 @@ func main() {
 	router.Use(gin.Recovery())
 
 	// 可选：添加自定义的精简日志中间件
-	if os.Getenv("GIN_ACCESS_LOG") == "true" {
+	if cfg.Server.AccessLog {
 		router.Use(func(c *gin.Context) {
 			start := time.Now()
 			c.Next()
@@ -69,13 +149,42 @@ func main() {
 	routes.SetupRoutes(router, cfg)
 
 	// 启动后台任务
-	go tasks.StartPriceMonitoring(cfg)
-	go tasks.CheckOrders(cfg)
-	go tasks.CheckWithdrawals(cfg)
-	go tasks.StartDualInvestmentTasks(cfg)
-	go tasks.StartFuturesMonitoring(cfg) // 添加这行
-
-	// 启动服务器
-	log.Printf("服务器启动在端口 23337")
-	log.Fatal(router.Run(":23337"))
+	supervisor, err := tasks.NewSupervisor(cfg)
+	if err != nil {
+		log.Fatalf("创建后台任务失败: %v", err)
+	}
+	supervisor.Start()
+
+	// 收到 SIGINT/SIGTERM 后优雅停止
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 请求上下文随服务停止取消，结束SSE等长连接
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		log.Printf("服务器启动在端口 %d", cfg.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("服务器启动失败: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("收到停止信号，开始优雅停止（最长 %v）", cfg.Server.ShutdownTimeout)
+
+	deadline := time.Now().Add(cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭HTTP服务失败: %v", err)
+	}
+	if err := supervisor.Shutdown(time.Until(deadline)); err != nil {
+		log.Printf("后台任务未完全停止: %v", err)
+	}
+	log.Println("服务已停止")
 }
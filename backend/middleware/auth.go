@@ -104,3 +104,16 @@ func AdminMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// StreamAuthMiddleware 推送接口认证，浏览器 EventSource 无法设置请求头，允许通过 token 查询参数传递
+func StreamAuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+	auth := AuthMiddleware(cfg)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		auth(c)
+	}
+}
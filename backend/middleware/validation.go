@@ -3,6 +3,7 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/ccj241/binance/services"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
@@ -179,13 +180,29 @@ func validateStrategy(c *gin.Context) {
 		})
 	}
 
-	// 验证价格
+	// 验证指标触发条件
+	triggerCondition, _ := data["triggerCondition"].(string)
+	if strings.TrimSpace(triggerCondition) != "" {
+		if _, err := services.ParseCondition(triggerCondition); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   "triggerCondition",
+				Message: err.Error(),
+			})
+		}
+	}
+
+	// 验证价格，设置了指标条件时触发价格可以为空
 	price, ok := getFloat64(data["price"])
-	if !ok || price <= 0 {
+	if strings.TrimSpace(triggerCondition) == "" && (!ok || price <= 0) {
 		errors = append(errors, ValidationError{
 			Field:   "price",
 			Message: "价格必须大于 0",
 		})
+	} else if ok && price < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "price",
+			Message: "价格不能为负数",
+		})
 	}
 
 	// 验证数量
@@ -304,6 +321,7 @@ func validateOrder(c *gin.Context) {
 		return
 	}
 
+	// 交易所规则（价格精度、数量步长、名义价值）与下单账户所在环境有关，由下单处理器校验
 	c.Next()
 }
 
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+)
+
+// AddFuturesTrailingStop 添加期货跟踪止损/止盈字段
+func AddFuturesTrailingStop(db *gorm.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"futures_strategies", "trailing_mode", "VARCHAR(20) DEFAULT '' COMMENT '跟踪止损模式'"},
+		{"futures_strategies", "trailing_callback_rate", "DOUBLE DEFAULT 0 COMMENT '跟踪回调百分比'"},
+		{"futures_strategies", "trailing_activation_price", "DOUBLE DEFAULT 0 COMMENT '跟踪激活价格'"},
+		{"futures_strategies", "trailing_take_profit", "BOOLEAN DEFAULT FALSE COMMENT '跟踪止盈'"},
+		{"futures_strategies", "trailing_activated", "BOOLEAN DEFAULT FALSE COMMENT '跟踪已激活'"},
+		{"futures_strategies", "trailing_high_water", "DOUBLE DEFAULT 0 COMMENT '跟踪最高/最低价'"},
+		{"futures_strategies", "trailing_stop_price", "DOUBLE DEFAULT 0 COMMENT '当前跟踪止损价'"},
+		{"futures_orders", "activation_price", "DOUBLE DEFAULT 0 COMMENT '跟踪激活价格'"},
+		{"futures_orders", "callback_rate", "DOUBLE DEFAULT 0 COMMENT '跟踪回调百分比'"},
+		{"futures_orders", "high_water_mark", "DOUBLE DEFAULT 0 COMMENT '挂单时的最高/最低价'"},
+		{"futures_orders", "replaced_order_id", "BIGINT DEFAULT 0 COMMENT '被替换的订单ID'"},
+	}
+
+	// 检查并添加字段
+	for _, col := range columns {
+		if db.Migrator().HasColumn(col.table, col.column) {
+			continue
+		}
+		sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.column, col.definition)
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("添加 %s.%s 字段失败: %v", col.table, col.column, err)
+			return err
+		}
+		log.Printf("成功添加 %s.%s 字段", col.table, col.column)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+)
+
+// AddPaperTrading 添加模拟盘标记字段
+func AddPaperTrading(db *gorm.DB) error {
+	columns := []struct {
+		table   string
+		column  string
+		comment string
+	}{
+		{"users", "paper_trading", "模拟盘模式"},
+		{"strategies", "paper", "模拟盘策略"},
+		{"orders", "paper", "模拟盘订单"},
+		{"futures_strategies", "paper", "模拟盘策略"},
+		{"futures_orders", "paper", "模拟盘订单"},
+		{"futures_positions", "paper", "模拟盘持仓"},
+	}
+
+	// 检查并添加字段
+	for _, col := range columns {
+		if db.Migrator().HasColumn(col.table, col.column) {
+			continue
+		}
+		sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s BOOLEAN DEFAULT FALSE COMMENT '%s'", col.table, col.column, col.comment)
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("添加 %s.%s 字段失败: %v", col.table, col.column, err)
+			return err
+		}
+		log.Printf("成功添加 %s.%s 字段", col.table, col.column)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+)
+
+// AddSpotGrid 添加现货网格策略字段
+func AddSpotGrid(db *gorm.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"strategies", "grid_lower_price", "DOUBLE DEFAULT 0 COMMENT '网格下限价格'"},
+		{"strategies", "grid_upper_price", "DOUBLE DEFAULT 0 COMMENT '网格上限价格'"},
+		{"strategies", "grid_count", "INT DEFAULT 0 COMMENT '网格数量'"},
+		{"strategies", "grid_quantity", "DOUBLE DEFAULT 0 COMMENT '每格数量'"},
+		{"strategies", "grid_rebalance", "BOOLEAN DEFAULT FALSE COMMENT '超出区间时重新平衡'"},
+		{"orders", "grid_level", "INT DEFAULT 0 COMMENT '网格价格线'"},
+		{"orders", "grid_entry_price", "DOUBLE DEFAULT 0 COMMENT '配对开仓成交价'"},
+		{"orders", "grid_profit", "DOUBLE DEFAULT 0 COMMENT '网格已实现利润'"},
+	}
+
+	// 检查并添加字段
+	for _, col := range columns {
+		if db.Migrator().HasColumn(col.table, col.column) {
+			continue
+		}
+		sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.column, col.definition)
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("添加 %s.%s 字段失败: %v", col.table, col.column, err)
+			return err
+		}
+		log.Printf("成功添加 %s.%s 字段", col.table, col.column)
+	}
+
+	return nil
+}
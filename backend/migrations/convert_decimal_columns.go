@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+)
+
+// ConvertDecimalColumns 将价格、数量、金额字段从浮点/字符串转换为 DECIMAL(36,18)
+func ConvertDecimalColumns(db *gorm.DB) error {
+	columns := []struct {
+		table  string
+		column string
+	}{
+		{"orders", "price"},
+		{"orders", "quantity"},
+		{"orders", "executed_qty"},
+		{"orders", "avg_price"},
+		{"orders", "grid_entry_price"},
+		{"orders", "grid_profit"},
+		{"strategies", "price"},
+		{"strategies", "total_quantity"},
+		{"strategies", "grid_lower_price"},
+		{"strategies", "grid_upper_price"},
+		{"strategies", "grid_quantity"},
+		{"prices", "price"},
+		{"withdrawals", "amount"},
+		{"withdrawals", "threshold"},
+		{"withdrawal_histories", "amount"},
+		{"fills", "price"},
+		{"fills", "quantity"},
+		{"fills", "quote_quantity"},
+		{"fills", "commission"},
+		{"futures_strategies", "base_price"},
+		{"futures_strategies", "quantity"},
+		{"futures_strategies", "entry_price"},
+		{"futures_strategies", "take_profit_price"},
+		{"futures_strategies", "stop_loss_price"},
+		{"futures_strategies", "trailing_activation_price"},
+		{"futures_strategies", "trailing_high_water"},
+		{"futures_strategies", "trailing_stop_price"},
+		{"futures_orders", "price"},
+		{"futures_orders", "quantity"},
+		{"futures_orders", "executed_qty"},
+		{"futures_orders", "avg_price"},
+		{"futures_orders", "commission"},
+		{"futures_orders", "realized_pnl"},
+		{"futures_orders", "activation_price"},
+		{"futures_orders", "high_water_mark"},
+		{"futures_positions", "entry_price"},
+		{"futures_positions", "quantity"},
+		{"futures_positions", "unrealized_pnl"},
+		{"futures_positions", "realized_pnl"},
+		{"futures_positions", "isolated_margin"},
+		{"futures_positions", "mark_price"},
+		{"futures_positions", "liquidation_price"},
+		{"futures_executions", "filled_qty"},
+		{"futures_executions", "weighted_price_sum"},
+		{"dual_investment_strategies", "max_single_amount"},
+		{"dual_investment_strategies", "total_investment_limit"},
+		{"dual_investment_strategies", "current_invested"},
+		{"dual_investment_orders", "invest_amount"},
+		{"dual_investment_orders", "strike_price"},
+		{"dual_investment_orders", "settlement_amount"},
+		{"dca_strategies", "quote_amount"},
+		{"dca_strategies", "max_total_quote"},
+		{"dca_strategies", "total_quote"},
+		{"dca_strategies", "total_quantity"},
+		{"dca_strategies", "average_cost"},
+		{"paper_orders", "price"},
+		{"paper_orders", "stop_price"},
+		{"paper_orders", "activation_price"},
+		{"paper_orders", "high_water_mark"},
+		{"paper_orders", "quantity"},
+		{"paper_orders", "executed_qty"},
+		{"paper_orders", "avg_price"},
+		{"paper_orders", "commission"},
+		{"paper_orders", "realized_pnl"},
+		{"paper_positions", "amount"},
+		{"paper_positions", "entry_price"},
+		{"paper_positions", "mark_price"},
+		{"risk_limits", "max_symbol_notional"},
+		{"risk_limits", "max_daily_loss"},
+		{"risk_limits", "min_free_balance"},
+	}
+
+	types := make(map[string]map[string]string)
+	for _, col := range columns {
+		if _, ok := types[col.table]; ok {
+			continue
+		}
+		types[col.table] = make(map[string]string)
+		columnTypes, err := db.Migrator().ColumnTypes(col.table)
+		if err != nil {
+			log.Printf("读取 %s 字段类型失败: %v", col.table, err)
+			return err
+		}
+		for _, ct := range columnTypes {
+			types[col.table][ct.Name()] = strings.ToUpper(ct.DatabaseTypeName())
+		}
+	}
+
+	// 已经是 DECIMAL 的字段跳过，原有数值按 MySQL 规则转换
+	for _, col := range columns {
+		current, ok := types[col.table][col.column]
+		if !ok || current == "DECIMAL" {
+			continue
+		}
+		sql := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s DECIMAL(36,18) DEFAULT 0", col.table, col.column)
+		if err := db.Exec(sql).Error; err != nil {
+			log.Printf("转换 %s.%s 字段为 DECIMAL 失败: %v", col.table, col.column, err)
+			return err
+		}
+		log.Printf("成功将 %s.%s 字段从 %s 转换为 DECIMAL(36,18)", col.table, col.column, current)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 价格提醒类型
+const (
+	AlertPriceAbove       = "price_above"        // 价格上穿阈值
+	AlertPriceBelow       = "price_below"        // 价格下穿阈值
+	AlertPercentChange    = "percent_change"     // 窗口内涨跌幅超过阈值百分比
+	AlertVolumeSpike      = "volume_spike"       // 窗口内成交额达到基准的倍数，仅现货
+	AlertFundingRateAbove = "funding_rate_above" // 资金费率高于阈值百分比，仅合约
+	AlertFundingRateBelow = "funding_rate_below" // 资金费率低于阈值百分比，仅合约
+)
+
+// 价格提醒市场
+const (
+	AlertMarketSpot    = "spot"
+	AlertMarketFutures = "futures"
+)
+
+// PriceAlert 价格提醒规则，只发送通知，不下单
+type PriceAlert struct {
+	gorm.Model
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index" json:"userId"`
+	Name            string     `gorm:"type:varchar(100)" json:"name"`         // 提醒名称
+	Market          string     `gorm:"type:varchar(10);index" json:"market"`  // spot/futures
+	Symbol          string     `gorm:"type:varchar(50);index" json:"symbol"`  // 交易对
+	Type            string     `gorm:"type:varchar(30)" json:"type"`          // 提醒类型
+	Threshold       float64    `json:"threshold" gorm:"comment:阈值"`           // 价格、百分比或成交额倍数
+	WindowMinutes   int        `json:"windowMinutes" gorm:"comment:统计窗口分钟"`   // 涨跌幅和成交额的统计窗口
+	CooldownMinutes int        `gorm:"default:15" json:"cooldownMinutes"`     // 两次触发的最小间隔
+	Repeat          bool       `gorm:"default:true" json:"repeat"`            // 触发后是否继续生效
+	Enabled         bool       `gorm:"default:true;index" json:"enabled"`     // 是否启用
+	LastTriggeredAt *time.Time `json:"lastTriggeredAt" gorm:"comment:最近触发时间"` // 最近触发时间
+	LastValue       float64    `json:"lastValue" gorm:"comment:最近触发时的数值"`     // 最近触发时的价格、涨跌幅、倍数或费率
+	TriggerCount    int        `json:"triggerCount" gorm:"comment:触发次数"`      // 累计触发次数
+	Note            string     `gorm:"type:varchar(500)" json:"note"`         // 备注，随通知发送
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// MigrateAlertTables 迁移价格提醒相关表
+func MigrateAlertTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&PriceAlert{},
+	)
+}
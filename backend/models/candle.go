@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// K线市场，现货按成交价聚合，合约按标记价格聚合
+const (
+	CandleMarketSpot    = "spot"
+	CandleMarketFutures = "futures"
+)
+
+// CandleHasVolume K线是否包含成交量；合约K线按标记价格聚合，只有价格，成交量和成交额恒为0
+func CandleHasVolume(market string) bool {
+	return market == CandleMarketSpot
+}
+
+// CandleIntervals 支持的K线周期
+var CandleIntervals = []string{"1m", "5m", "1h", "1d"}
+
+// Candle OHLCV K线，同一市场、交易对、周期和开盘时间唯一
+type Candle struct {
+	ID          uint            `gorm:"primaryKey" json:"-"`
+	Market      string          `gorm:"type:varchar(10);uniqueIndex:idx_candle_key,priority:1" json:"market"`                // spot/futures
+	Symbol      string          `gorm:"type:varchar(50);uniqueIndex:idx_candle_key,priority:2" json:"symbol"`                // 交易对
+	Interval    string          `gorm:"column:period;type:varchar(5);uniqueIndex:idx_candle_key,priority:3" json:"interval"` // 1m/5m/1h/1d，interval 为 MySQL 保留字
+	OpenTime    int64           `gorm:"uniqueIndex:idx_candle_key,priority:4;index" json:"openTime"`                         // 开盘时间，毫秒
+	Open        decimal.Decimal `json:"open" gorm:"type:decimal(36,18)"`                                                     // 开盘价
+	High        decimal.Decimal `json:"high" gorm:"type:decimal(36,18)"`                                                     // 最高价
+	Low         decimal.Decimal `json:"low" gorm:"type:decimal(36,18)"`                                                      // 最低价
+	Close       decimal.Decimal `json:"close" gorm:"type:decimal(36,18)"`                                                    // 收盘价
+	Volume      decimal.Decimal `json:"volume" gorm:"type:decimal(36,18)"`                                                   // 成交量，合约标记价格K线恒为0
+	QuoteVolume decimal.Decimal `json:"quoteVolume" gorm:"type:decimal(36,18)"`                                              // 成交额
+	CloseTime   int64           `json:"closeTime"`                                                                           // 收盘时间，毫秒
+	UpdatedAt   time.Time       `json:"-"`
+}
+
+// CandleRetention K线保留时长，0 表示永久保留
+var CandleRetention = map[string]time.Duration{
+	"1m": 7 * 24 * time.Hour,
+	"5m": 30 * 24 * time.Hour,
+	"1h": 365 * 24 * time.Hour,
+	"1d": 0,
+}
+
+// MigrateCandleTables 迁移K线相关表
+func MigrateCandleTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&Candle{},
+	)
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DCAStrategy 定投策略：按计划时间用固定计价币金额市价买入
+type DCAStrategy struct {
+	gorm.Model
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"index" json:"userId"`
+	AccountID      uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Symbol         string          `gorm:"type:varchar(50)" json:"symbol"`
+	ScheduleType   string          `gorm:"type:varchar(20)" json:"scheduleType"`                    // daily/weekly/custom
+	ScheduleTime   string          `gorm:"type:varchar(5)" json:"scheduleTime"`                     // daily/weekly 的执行时间 HH:MM（服务器时区）
+	Weekday        int             `json:"weekday" gorm:"comment:每周执行日(0=周日)"`                      // weekly 的执行日
+	CronExpr       string          `gorm:"type:varchar(100)" json:"cronExpr"`                       // custom 的 cron 表达式：分 时 日 月 周
+	QuoteAmount    decimal.Decimal `json:"quoteAmount" gorm:"type:decimal(36,18);comment:每次投入金额"`   // 每次投入的计价币金额
+	DipMultipliers string          `gorm:"type:text;comment:低于均价加倍配置JSON" json:"dipMultipliers"`    // []DCADipMultiplier
+	MaxTotalQuote  decimal.Decimal `json:"maxTotalQuote" gorm:"type:decimal(36,18);comment:累计投入上限"` // 累计投入上限，0表示不限
+	TotalQuote     decimal.Decimal `json:"totalQuote" gorm:"type:decimal(36,18);comment:累计投入金额"`    // 累计成交金额（计价币）
+	TotalQuantity  decimal.Decimal `json:"totalQuantity" gorm:"type:decimal(36,18);comment:累计买入数量"` // 累计成交数量
+	AverageCost    decimal.Decimal `json:"averageCost" gorm:"type:decimal(36,18);comment:平均成本"`     // 持仓平均成本
+	RunCount       int             `json:"runCount" gorm:"comment:已执行次数"`                           // 已执行次数
+	Enabled        bool            `gorm:"default:true" json:"enabled"`                             // 是否启用
+	Status         string          `gorm:"type:varchar(20);default:'active'" json:"status"`         // active/completed
+	Paper          bool            `gorm:"default:false;comment:模拟盘策略" json:"paper"`                // 模拟盘策略，订单只在模拟撮合引擎中成交
+	LastRunAt      *time.Time      `json:"lastRunAt" gorm:"comment:最后执行时间"`                         // 最后执行时间
+	NextRunAt      *time.Time      `gorm:"index" json:"nextRunAt"`                                  // 下次执行时间
+	LastError      string          `gorm:"type:varchar(500)" json:"lastError"`                      // 最近一次执行失败原因
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+// DCADipMultiplier 价格低于平均成本一定比例时放大投入金额
+type DCADipMultiplier struct {
+	DropPercent float64 `json:"dropPercent"` // 低于平均成本的百分比
+	Multiplier  float64 `json:"multiplier"`  // 投入金额倍数
+}
+
+func (DCAStrategy) TableName() string {
+	return "dca_strategies"
+}
+
+// ParseDipMultipliers 解析加倍配置
+func (s *DCAStrategy) ParseDipMultipliers() ([]DCADipMultiplier, error) {
+	if s.DipMultipliers == "" {
+		return nil, nil
+	}
+	var items []DCADipMultiplier
+	if err := json.Unmarshal([]byte(s.DipMultipliers), &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RecordFill 累计一笔成交并更新平均成本
+func (s *DCAStrategy) RecordFill(quantity, quote decimal.Decimal) {
+	s.TotalQuantity = s.TotalQuantity.Add(quantity)
+	s.TotalQuote = s.TotalQuote.Add(quote)
+	if s.TotalQuantity.IsPositive() {
+		s.AverageCost = s.TotalQuote.Div(s.TotalQuantity)
+	}
+}
+
+// MigrateDCATables 迁移定投相关表
+func MigrateDCATables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&DCAStrategy{},
+	)
+}
@@ -0,0 +1,9 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// 价格、数量和金额使用定点小数，数据库中为 DECIMAL(36,18)，避免浮点误差
+func init() {
+	// 接口中仍以数字输出，保留完整小数位，前端无需改动
+	decimal.MarshalJSONWithoutQuotes = true
+}
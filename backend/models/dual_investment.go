@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"time"
 )
@@ -30,18 +31,19 @@ type DualInvestmentProduct struct {
 // DualInvestmentStrategy 双币投资策略
 type DualInvestmentStrategy struct {
 	gorm.Model
-	ID                   uint    `gorm:"primaryKey" json:"id"`
-	UserID               uint    `gorm:"index" json:"userId"`
-	StrategyName         string  `gorm:"type:varchar(100)" json:"strategyName"`       // 策略名称
-	StrategyType         string  `gorm:"type:varchar(50)" json:"strategyType"`        // single/auto_reinvest/ladder/price_trigger
-	BaseAsset            string  `gorm:"type:varchar(20)" json:"baseAsset"`           // 基础资产
-	QuoteAsset           string  `gorm:"type:varchar(20)" json:"quoteAsset"`          // 计价资产
-	DirectionPreference  string  `gorm:"type:varchar(20)" json:"directionPreference"` // UP/DOWN/BOTH
-	TargetAPYMin         float64 `json:"targetApyMin" gorm:"comment:目标最小年化收益率"`       // 目标最小年化收益率
-	TargetAPYMax         float64 `json:"targetApyMax" gorm:"comment:目标最大年化收益率"`       // 目标最大年化收益率
-	MaxSingleAmount      float64 `json:"maxSingleAmount" gorm:"comment:单笔最大投资额"`      // 单笔最大投资额
-	TotalInvestmentLimit float64 `json:"totalInvestmentLimit" gorm:"comment:总投资限额"`   // 总投资限额
-	CurrentInvested      float64 `json:"currentInvested" gorm:"comment:当前已投资金额"`      // 当前已投资金额
+	ID                   uint            `gorm:"primaryKey" json:"id"`
+	UserID               uint            `gorm:"index" json:"userId"`
+	AccountID            uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"`              // 所属交易所账户
+	StrategyName         string          `gorm:"type:varchar(100)" json:"strategyName"`                         // 策略名称
+	StrategyType         string          `gorm:"type:varchar(50)" json:"strategyType"`                          // single/auto_reinvest/ladder/price_trigger
+	BaseAsset            string          `gorm:"type:varchar(20)" json:"baseAsset"`                             // 基础资产
+	QuoteAsset           string          `gorm:"type:varchar(20)" json:"quoteAsset"`                            // 计价资产
+	DirectionPreference  string          `gorm:"type:varchar(20)" json:"directionPreference"`                   // UP/DOWN/BOTH
+	TargetAPYMin         float64         `json:"targetApyMin" gorm:"comment:目标最小年化收益率"`                         // 目标最小年化收益率
+	TargetAPYMax         float64         `json:"targetApyMax" gorm:"comment:目标最大年化收益率"`                         // 目标最大年化收益率
+	MaxSingleAmount      decimal.Decimal `json:"maxSingleAmount" gorm:"type:decimal(36,18);comment:单笔最大投资额"`    // 单笔最大投资额
+	TotalInvestmentLimit decimal.Decimal `json:"totalInvestmentLimit" gorm:"type:decimal(36,18);comment:总投资限额"` // 总投资限额
+	CurrentInvested      decimal.Decimal `json:"currentInvested" gorm:"type:decimal(36,18);comment:当前已投资金额"`    // 当前已投资金额
 	// 风险参数JSON字段
 	MaxStrikePriceOffset float64 `json:"maxStrikePriceOffset" gorm:"comment:最大执行价格偏离度(%)"`      // 最大执行价格偏离度
 	MinDuration          int     `json:"minDuration" gorm:"comment:最小投资期限(天)"`                  // 最小投资期限
@@ -74,29 +76,30 @@ type LadderConfigItem struct {
 // DualInvestmentOrder 双币投资订单
 type DualInvestmentOrder struct {
 	gorm.Model
-	ID               uint       `gorm:"primaryKey" json:"id"`
-	UserID           uint       `gorm:"index" json:"userId"`
-	StrategyID       *uint      `gorm:"index" json:"strategyId"`                 // 可能为空（手动下单）
-	ProductID        uint       `gorm:"index" json:"productId"`                  // 关联产品ID
-	OrderID          string     `gorm:"type:varchar(100);index" json:"orderId"`  // 币安订单ID
-	Symbol           string     `gorm:"type:varchar(50)" json:"symbol"`          // 币种对
-	InvestAsset      string     `gorm:"type:varchar(20)" json:"investAsset"`     // 投资币种
-	InvestAmount     float64    `json:"investAmount" gorm:"comment:投资金额"`        // 投资金额
-	StrikePrice      float64    `json:"strikePrice" gorm:"comment:执行价格"`         // 执行价格
-	APY              float64    `json:"apy" gorm:"comment:年化收益率"`                // 年化收益率
-	Direction        string     `gorm:"type:varchar(10)" json:"direction"`       // UP/DOWN
-	Duration         int        `json:"duration" gorm:"comment:期限(天)"`           // 期限（天）
-	SettlementTime   time.Time  `json:"settlementTime" gorm:"comment:结算时间"`      // 结算时间
-	SettlementAsset  string     `gorm:"type:varchar(20)" json:"settlementAsset"` // 结算币种
-	SettlementAmount float64    `json:"settlementAmount" gorm:"comment:结算金额"`    // 结算金额
-	ActualAPY        float64    `json:"actualApy" gorm:"comment:实际年化收益率"`        // 实际年化收益率
-	Status           string     `gorm:"type:varchar(20)" json:"status"`          // pending/active/settled/cancelled
-	SettledAt        *time.Time `json:"settledAt" gorm:"comment:实际结算时间"`         // 实际结算时间
-	PnL              float64    `json:"pnl" gorm:"comment:盈亏金额"`                 // 盈亏金额
-	PnLPercent       float64    `json:"pnlPercent" gorm:"comment:盈亏百分比"`         // 盈亏百分比
-	Notes            string     `gorm:"type:text" json:"notes"`                  // 备注
-	CreatedAt        time.Time  `json:"createdAt"`
-	UpdatedAt        time.Time  `json:"updatedAt"`
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	UserID           uint            `gorm:"index" json:"userId"`
+	AccountID        uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"`         // 所属交易所账户
+	StrategyID       *uint           `gorm:"index" json:"strategyId"`                                  // 可能为空（手动下单）
+	ProductID        uint            `gorm:"index" json:"productId"`                                   // 关联产品ID
+	OrderID          string          `gorm:"type:varchar(100);index" json:"orderId"`                   // 币安订单ID
+	Symbol           string          `gorm:"type:varchar(50)" json:"symbol"`                           // 币种对
+	InvestAsset      string          `gorm:"type:varchar(20)" json:"investAsset"`                      // 投资币种
+	InvestAmount     decimal.Decimal `json:"investAmount" gorm:"type:decimal(36,18);comment:投资金额"`     // 投资金额
+	StrikePrice      decimal.Decimal `json:"strikePrice" gorm:"type:decimal(36,18);comment:执行价格"`      // 执行价格
+	APY              float64         `json:"apy" gorm:"comment:年化收益率"`                                 // 年化收益率
+	Direction        string          `gorm:"type:varchar(10)" json:"direction"`                        // UP/DOWN
+	Duration         int             `json:"duration" gorm:"comment:期限(天)"`                            // 期限（天）
+	SettlementTime   time.Time       `json:"settlementTime" gorm:"comment:结算时间"`                       // 结算时间
+	SettlementAsset  string          `gorm:"type:varchar(20)" json:"settlementAsset"`                  // 结算币种
+	SettlementAmount decimal.Decimal `json:"settlementAmount" gorm:"type:decimal(36,18);comment:结算金额"` // 结算金额
+	ActualAPY        float64         `json:"actualApy" gorm:"comment:实际年化收益率"`                         // 实际年化收益率
+	Status           string          `gorm:"type:varchar(20)" json:"status"`                           // pending/active/settled/cancelled
+	SettledAt        *time.Time      `json:"settledAt" gorm:"comment:实际结算时间"`                          // 实际结算时间
+	PnL              float64         `json:"pnl" gorm:"comment:盈亏金额"`                                  // 盈亏金额
+	PnLPercent       float64         `json:"pnlPercent" gorm:"comment:盈亏百分比"`                          // 盈亏百分比
+	Notes            string          `gorm:"type:text" json:"notes"`                                   // 备注
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
 // DualInvestmentStats 双币投资统计
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ccj241/binance/utils"
+	"gorm.io/gorm"
+)
+
+// 交易所账户权限，由用户按API密钥实际开通的权限勾选
+const (
+	AccountPermSpot     = "spot"     // 现货交易
+	AccountPermFutures  = "futures"  // U本位合约
+	AccountPermWithdraw = "withdraw" // 提币
+	AccountPermDCI      = "dci"      // 双币投资
+)
+
+// AccountPermissions 可选的账户权限
+var AccountPermissions = []string{AccountPermSpot, AccountPermFutures, AccountPermWithdraw, AccountPermDCI}
+
+// 交易所环境，决定账户的REST和WebSocket请求发往哪里
+const (
+	AccountEnvProduction     = "production"      // 币安正式环境
+	AccountEnvSpotTestnet    = "spot_testnet"    // 币安现货测试网
+	AccountEnvFuturesTestnet = "futures_testnet" // 币安合约测试网
+	AccountEnvCustom         = "custom"          // 自定义地址，如本地模拟交易所
+)
+
+// AccountEnvironments 可选的交易所环境
+var AccountEnvironments = []string{AccountEnvProduction, AccountEnvSpotTestnet, AccountEnvFuturesTestnet, AccountEnvCustom}
+
+// ExchangeAccount 交易所账户，一个用户可以管理主账户和多个子账户
+type ExchangeAccount struct {
+	gorm.Model
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"index" json:"userId"`
+	Label       string    `gorm:"type:varchar(100)" json:"label"`                  // 账户名称，如 主账户、网格子账户
+	APIKey      string    `gorm:"type:varchar(500)" json:"-"`                      // 加密存储，不序列化
+	SecretKey   string    `gorm:"type:varchar(500)" json:"-"`                      // 加密存储，不序列化
+	Permissions string    `gorm:"type:varchar(100)" json:"permissions"`            // 逗号分隔的权限
+	Environment string    `gorm:"type:varchar(20);default:''" json:"environment"`  // 交易所环境，为空时使用系统默认环境
+	BaseURL     string    `gorm:"type:varchar(255)" json:"baseUrl"`                // 自定义环境的交易所地址
+	IsDefault   bool      `gorm:"default:false;comment:默认账户" json:"isDefault"`     // 未指定账户时使用
+	Status      string    `gorm:"type:varchar(20);default:'active'" json:"status"` // active, disabled
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// BeforeSave 保存前加密API密钥
+func (a *ExchangeAccount) BeforeSave(tx *gorm.DB) error {
+	var err error
+	if a.APIKey, err = encryptIfPlain(a.APIKey); err != nil {
+		return fmt.Errorf("加密API Key失败: %v", err)
+	}
+	if a.SecretKey, err = encryptIfPlain(a.SecretKey); err != nil {
+		return fmt.Errorf("加密Secret Key失败: %v", err)
+	}
+	return nil
+}
+
+// encryptIfPlain 未加密的值加密后返回，已加密的原样返回
+func encryptIfPlain(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if _, err := utils.Decrypt(value); err == nil {
+		return value, nil
+	}
+	return utils.Encrypt(value)
+}
+
+// GetDecryptedAPIKey 获取解密后的API Key
+func (a *ExchangeAccount) GetDecryptedAPIKey() (string, error) {
+	if a.APIKey == "" {
+		return "", nil
+	}
+	return utils.Decrypt(a.APIKey)
+}
+
+// GetDecryptedSecretKey 获取解密后的Secret Key
+func (a *ExchangeAccount) GetDecryptedSecretKey() (string, error) {
+	if a.SecretKey == "" {
+		return "", nil
+	}
+	return utils.Decrypt(a.SecretKey)
+}
+
+// HasPermission 账户是否开通了指定权限
+func (a *ExchangeAccount) HasPermission(permission string) bool {
+	for _, p := range strings.Split(a.Permissions, ",") {
+		if strings.TrimSpace(p) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// accountScopedModels 引用交易所账户的表，迁移时为旧数据补上默认账户
+var accountScopedModels = []interface{}{
+	&Strategy{},
+	&Order{},
+	&Withdrawal{},
+	&WithdrawalHistory{},
+	&FuturesStrategy{},
+	&FuturesOrder{},
+	&FuturesPosition{},
+	&DualInvestmentStrategy{},
+	&DualInvestmentOrder{},
+	&DCAStrategy{},
+	&Fill{},
+}
+
+// MigrateExchangeAccountTables 迁移交易所账户表，并把用户表中的旧密钥迁移为默认账户
+func MigrateExchangeAccountTables(db *gorm.DB) error {
+	if err := db.AutoMigrate(&ExchangeAccount{}); err != nil {
+		return err
+	}
+
+	// 早期的测试网开关迁移为交易所环境
+	if db.Migrator().HasColumn(&ExchangeAccount{}, "testnet") {
+		if err := db.Exec("UPDATE exchange_accounts SET environment = ? WHERE testnet = ? AND environment = ''",
+			AccountEnvSpotTestnet, true).Error; err != nil {
+			return fmt.Errorf("迁移测试网账户失败: %v", err)
+		}
+		if err := db.Migrator().DropColumn(&ExchangeAccount{}, "testnet"); err != nil {
+			return fmt.Errorf("删除 testnet 字段失败: %v", err)
+		}
+	}
+
+	var users []User
+	if err := db.Where("api_key <> '' AND secret_key <> ''").Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		var count int64
+		if err := db.Model(&ExchangeAccount{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		account := ExchangeAccount{
+			UserID:      user.ID,
+			Label:       "主账户",
+			APIKey:      user.APIKey,
+			SecretKey:   user.SecretKey,
+			Permissions: strings.Join(AccountPermissions, ","),
+			IsDefault:   true,
+			Status:      "active",
+		}
+		if err := db.Create(&account).Error; err != nil {
+			return fmt.Errorf("迁移用户 %d 的API密钥失败: %v", user.ID, err)
+		}
+		log.Printf("用户 %d 的API密钥已迁移为默认交易所账户 %d", user.ID, account.ID)
+	}
+
+	// 旧数据归属到用户的默认账户
+	var defaults []ExchangeAccount
+	if err := db.Where("is_default = ?", true).Find(&defaults).Error; err != nil {
+		return err
+	}
+	for _, account := range defaults {
+		for _, model := range accountScopedModels {
+			if err := db.Unscoped().Model(model).Where("user_id = ? AND account_id = 0", account.UserID).
+				Update("account_id", account.ID).Error; err != nil {
+				return fmt.Errorf("回填账户ID失败: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Fill 现货成交明细，来自交易所 myTrades，按成交ID去重
+// 金额在记录时按当时的计价币/USDT汇率折算，成本和盈亏统一以USDT计算
+type Fill struct {
+	gorm.Model
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	UserID          uint            `gorm:"uniqueIndex:idx_fill_trade;index" json:"userId"`
+	AccountID       uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Paper           bool            `gorm:"uniqueIndex:idx_fill_trade;default:false" json:"paper"`
+	Symbol          string          `gorm:"uniqueIndex:idx_fill_trade;type:varchar(50)" json:"symbol"`
+	TradeID         int64           `gorm:"uniqueIndex:idx_fill_trade" json:"tradeId"` // 交易所成交ID
+	OrderID         int64           `gorm:"index" json:"orderId"`                      // 交易所订单号
+	OrderRef        uint            `gorm:"index" json:"orderRef"`                     // 本地订单记录ID
+	StrategyID      uint            `gorm:"index" json:"strategyId"`                   // 现货策略ID
+	DCAStrategyID   uint            `gorm:"index" json:"dcaStrategyId"`                // 定投策略ID
+	BaseAsset       string          `gorm:"type:varchar(20);index" json:"baseAsset"`   // 基础资产
+	QuoteAsset      string          `gorm:"type:varchar(20)" json:"quoteAsset"`        // 计价资产
+	Side            string          `gorm:"type:varchar(10)" json:"side"`              // BUY/SELL
+	Price           decimal.Decimal `json:"price" gorm:"type:decimal(36,18)"`          // 成交价格（计价币）
+	Quantity        decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18)"`       // 成交数量
+	QuoteQuantity   decimal.Decimal `json:"quoteQuantity" gorm:"type:decimal(36,18)"`  // 成交金额（计价币）
+	Commission      decimal.Decimal `json:"commission" gorm:"type:decimal(36,18)"`     // 手续费数量
+	CommissionAsset string          `gorm:"type:varchar(20)" json:"commissionAsset"`   // 手续费资产
+	CommissionUSDT  float64         `json:"commissionUsdt" gorm:"comment:手续费折合USDT"`   // 手续费折合USDT
+	QuoteUSDTRate   float64         `json:"quoteUsdtRate" gorm:"comment:计价币对USDT汇率"`   // 成交时计价币对USDT汇率
+	IsMaker         bool            `json:"isMaker"`                                   // 是否挂单成交
+	TradeTime       time.Time       `gorm:"index" json:"tradeTime"`                    // 成交时间
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+// NetQuantity 扣除以基础资产收取的手续费后实际增减的持仓数量
+func (f *Fill) NetQuantity() float64 {
+	if f.CommissionAsset == f.BaseAsset && f.Side == "BUY" {
+		return f.Quantity.Sub(f.Commission).InexactFloat64()
+	}
+	if f.CommissionAsset == f.BaseAsset && f.Side == "SELL" {
+		return f.Quantity.Add(f.Commission).InexactFloat64()
+	}
+	return f.Quantity.InexactFloat64()
+}
+
+// FeeUSDT 需要从盈亏中单独扣除的手续费（USDT）
+// 以基础资产收取的手续费已体现在持仓数量中，不重复扣除
+func (f *Fill) FeeUSDT() float64 {
+	if f.CommissionAsset == f.BaseAsset {
+		return 0
+	}
+	return f.CommissionUSDT
+}
+
+// QuoteUSDT 成交金额折合USDT
+func (f *Fill) QuoteUSDT() float64 {
+	return f.QuoteQuantity.InexactFloat64() * f.QuoteUSDTRate
+}
+
+// MigrateFillTables 迁移成交明细表
+func MigrateFillTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&Fill{},
+	)
+}
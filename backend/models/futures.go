@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"time"
 )
@@ -8,82 +9,119 @@ import (
 // FuturesStrategy 永续期货策略
 type FuturesStrategy struct {
 	gorm.Model
-	ID                 uint       `gorm:"primaryKey" json:"id"`
-	UserID             uint       `gorm:"index" json:"userId"`
-	StrategyName       string     `gorm:"type:varchar(100)" json:"strategyName"`                     // 策略名称
-	Symbol             string     `gorm:"type:varchar(50)" json:"symbol"`                            // 交易对，如BTCUSDT
-	Side               string     `gorm:"type:varchar(10)" json:"side"`                              // LONG/SHORT
-	StrategyType       string     `gorm:"type:varchar(20);default:'simple'" json:"strategyType"`     // simple/iceberg/slow_iceberg
-	BasePrice          float64    `json:"basePrice" gorm:"comment:基准价格"`                             // 触发价格
-	EntryPrice         float64    `json:"entryPrice" gorm:"comment:开仓价格"`                            // 限价单价格（将在触发时计算）
-	EntryPriceFloat    float64    `json:"entryPriceFloat" gorm:"comment:开仓价格浮动千分比"`                  // 开仓价格浮动千分比
-	Leverage           int        `json:"leverage" gorm:"comment:杠杆倍数"`                              // 杠杆倍数 1-125
-	Quantity           float64    `json:"quantity" gorm:"comment:开仓数量"`                              // 开仓数量（USDT）
-	TakeProfitRate     float64    `json:"takeProfitRate" gorm:"comment:止盈百分比"`                       // 止盈百分比（扣除手续费后）
-	TakeProfitPrice    float64    `json:"takeProfitPrice" gorm:"comment:止盈价格"`                       // 计算后的止盈价格
-	StopLossRate       float64    `json:"stopLossRate" gorm:"comment:止损百分比"`                         // 止损百分比（可选）
-	StopLossPrice      float64    `json:"stopLossPrice" gorm:"comment:止损价格"`                         // 计算后的止损价格
-	MarginType         string     `gorm:"type:varchar(20);default:'CROSSED'" json:"marginType"`      // ISOLATED/CROSSED
-	IcebergLevels      int        `json:"icebergLevels" gorm:"default:5;comment:冰山层数"`               // 冰山策略层数
-	IcebergQuantities  string     `gorm:"type:text;comment:冰山策略各层数量比例" json:"icebergQuantities"`     // 逗号分隔的比例
-	IcebergPriceGaps   string     `gorm:"type:text;comment:冰山策略各层价格间隔(万分比)" json:"icebergPriceGaps"` // 逗号分隔的万分比
-	SlowIcebergTimeout int        `json:"slowIcebergTimeout" gorm:"default:5;comment:慢冰山各层超时时间(分钟)"` // 慢冰山超时时间
-	AutoRestart        bool       `gorm:"default:false;comment:完成后自动重启" json:"autoRestart"`          // 完成后自动重启
-	Enabled            bool       `gorm:"default:true" json:"enabled"`                               // 是否启用
-	Status             string     `gorm:"type:varchar(20);default:'waiting'" json:"status"`          // waiting/triggered/position_opened/completed/cancelled
-	TriggeredAt        *time.Time `json:"triggeredAt" gorm:"comment:触发时间"`                           // 触发时间
-	CompletedAt        *time.Time `json:"completedAt" gorm:"comment:完成时间"`                           // 完成时间
-	CurrentPositionId  int64      `json:"currentPositionId" gorm:"comment:当前持仓ID"`                   // 币安持仓ID
-	CreatedAt          time.Time  `json:"createdAt"`
-	UpdatedAt          time.Time  `json:"updatedAt"`
+	ID                      uint            `gorm:"primaryKey" json:"id"`
+	UserID                  uint            `gorm:"index" json:"userId"`
+	AccountID               uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"`                  // 所属交易所账户
+	StrategyName            string          `gorm:"type:varchar(100)" json:"strategyName"`                             // 策略名称
+	Symbol                  string          `gorm:"type:varchar(50)" json:"symbol"`                                    // 交易对，如BTCUSDT
+	Side                    string          `gorm:"type:varchar(10)" json:"side"`                                      // LONG/SHORT
+	StrategyType            string          `gorm:"type:varchar(20);default:'simple'" json:"strategyType"`             // simple/iceberg/slow_iceberg
+	BasePrice               decimal.Decimal `json:"basePrice" gorm:"type:decimal(36,18);comment:基准价格"`                 // 触发价格
+	EntryPrice              decimal.Decimal `json:"entryPrice" gorm:"type:decimal(36,18);comment:开仓价格"`                // 限价单价格（将在触发时计算）
+	EntryPriceFloat         float64         `json:"entryPriceFloat" gorm:"comment:开仓价格浮动千分比"`                          // 开仓价格浮动千分比
+	Leverage                int             `json:"leverage" gorm:"comment:杠杆倍数"`                                      // 杠杆倍数 1-125
+	Quantity                decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18);comment:开仓数量"`                  // 开仓数量（USDT）
+	TakeProfitRate          float64         `json:"takeProfitRate" gorm:"comment:止盈百分比"`                               // 止盈百分比（扣除手续费后）
+	TakeProfitPrice         decimal.Decimal `json:"takeProfitPrice" gorm:"type:decimal(36,18);comment:止盈价格"`           // 计算后的止盈价格
+	StopLossRate            float64         `json:"stopLossRate" gorm:"comment:止损百分比"`                                 // 止损百分比（可选）
+	StopLossPrice           decimal.Decimal `json:"stopLossPrice" gorm:"type:decimal(36,18);comment:止损价格"`             // 计算后的止损价格
+	MarginType              string          `gorm:"type:varchar(20);default:'CROSSED'" json:"marginType"`              // ISOLATED/CROSSED
+	IcebergLevels           int             `json:"icebergLevels" gorm:"default:5;comment:冰山层数"`                       // 冰山策略层数
+	IcebergQuantities       string          `gorm:"type:text;comment:冰山策略各层数量比例" json:"icebergQuantities"`             // 逗号分隔的比例
+	IcebergPriceGaps        string          `gorm:"type:text;comment:冰山策略各层价格间隔(万分比)" json:"icebergPriceGaps"`         // 逗号分隔的万分比
+	SlowIcebergTimeout      int             `json:"slowIcebergTimeout" gorm:"default:5;comment:慢冰山各层超时时间(分钟)"`         // 慢冰山超时时间
+	AutoRestart             bool            `gorm:"default:false;comment:完成后自动重启" json:"autoRestart"`                  // 完成后自动重启
+	TrailingMode            string          `gorm:"type:varchar(20);default:'';comment:跟踪止损模式" json:"trailingMode"`    // 空为关闭，exchange：币安TRAILING_STOP_MARKET，local：本地跟踪并重挂止损单
+	TrailingCallbackRate    float64         `json:"trailingCallbackRate" gorm:"comment:跟踪回调百分比"`                       // 回调百分比，如1表示1%
+	TrailingActivationPrice decimal.Decimal `json:"trailingActivationPrice" gorm:"type:decimal(36,18);comment:跟踪激活价格"` // 激活价格，0表示开仓后立即激活
+	TrailingTakeProfit      bool            `gorm:"default:false;comment:跟踪止盈" json:"trailingTakeProfit"`              // 以止盈价格作为激活价格，并替代固定止盈单
+	TrailingActivated       bool            `gorm:"default:false;comment:跟踪已激活" json:"trailingActivated"`              // 当前持仓的跟踪是否已激活
+	TrailingHighWater       decimal.Decimal `json:"trailingHighWater" gorm:"type:decimal(36,18);comment:跟踪最高/最低价"`     // 激活后的最高价（做空为最低价）
+	TrailingStopPrice       decimal.Decimal `json:"trailingStopPrice" gorm:"type:decimal(36,18);comment:当前跟踪止损价"`      // 当前跟踪止损触发价
+	Enabled                 bool            `gorm:"default:true" json:"enabled"`                                       // 是否启用
+	Status                  string          `gorm:"type:varchar(20);default:'waiting'" json:"status"`                  // waiting/triggered/position_opened/completed/cancelled
+	TriggeredAt             *time.Time      `json:"triggeredAt" gorm:"comment:触发时间"`                                   // 触发时间
+	CompletedAt             *time.Time      `json:"completedAt" gorm:"comment:完成时间"`                                   // 完成时间
+	CurrentPositionId       int64           `json:"currentPositionId" gorm:"comment:当前持仓ID"`                           // 币安持仓ID
+	Paper                   bool            `gorm:"default:false;comment:模拟盘策略" json:"paper"`                          // 模拟盘策略
+	TriggerCondition        string          `gorm:"type:varchar(500);comment:指标触发条件" json:"triggerCondition"`          // 指标条件，与基准价格同时设置时需同时满足
+	CreatedAt               time.Time       `json:"createdAt"`
+	UpdatedAt               time.Time       `json:"updatedAt"`
 }
 
 // FuturesOrder 永续期货订单
 type FuturesOrder struct {
 	gorm.Model
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	UserID          uint      `gorm:"index" json:"userId"`
-	StrategyID      uint      `gorm:"index" json:"strategyId"`                 // 关联策略
-	Symbol          string    `gorm:"type:varchar(50)" json:"symbol"`          // 交易对
-	Side            string    `gorm:"type:varchar(10)" json:"side"`            // BUY/SELL
-	PositionSide    string    `gorm:"type:varchar(10)" json:"positionSide"`    // LONG/SHORT
-	Type            string    `gorm:"type:varchar(20)" json:"type"`            // LIMIT/MARKET/STOP_MARKET等
-	Price           float64   `json:"price"`                                   // 价格
-	Quantity        float64   `json:"quantity"`                                // 数量
-	OrderID         int64     `gorm:"index" json:"orderId"`                    // 币安订单ID
-	Status          string    `gorm:"type:varchar(20)" json:"status"`          // NEW/FILLED/CANCELED等
-	OrderPurpose    string    `gorm:"type:varchar(20)" json:"orderPurpose"`    // entry/take_profit/stop_loss
-	ExecutedQty     float64   `json:"executedQty" gorm:"comment:已成交数量"`        // 已成交数量
-	AvgPrice        float64   `json:"avgPrice" gorm:"comment:平均成交价"`           // 平均成交价
-	Commission      float64   `json:"commission" gorm:"comment:手续费"`           // 手续费
-	CommissionAsset string    `gorm:"type:varchar(20)" json:"commissionAsset"` // 手续费资产
-	RealizedPnl     float64   `json:"realizedPnl" gorm:"comment:已实现盈亏"`        // 已实现盈亏
-	CreatedAt       time.Time `json:"createdAt"`
-	UpdatedAt       time.Time `json:"updatedAt"`
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	UserID          uint            `gorm:"index" json:"userId"`
+	AccountID       uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"`            // 所属交易所账户
+	StrategyID      uint            `gorm:"index" json:"strategyId"`                                     // 关联策略
+	Symbol          string          `gorm:"type:varchar(50)" json:"symbol"`                              // 交易对
+	Side            string          `gorm:"type:varchar(10)" json:"side"`                                // BUY/SELL
+	PositionSide    string          `gorm:"type:varchar(10)" json:"positionSide"`                        // LONG/SHORT
+	Type            string          `gorm:"type:varchar(20)" json:"type"`                                // LIMIT/MARKET/STOP_MARKET等
+	Price           decimal.Decimal `json:"price" gorm:"type:decimal(36,18)"`                            // 价格
+	Quantity        decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18)"`                         // 数量
+	OrderID         int64           `gorm:"index" json:"orderId"`                                        // 币安订单ID
+	Status          string          `gorm:"type:varchar(20)" json:"status"`                              // NEW/FILLED/CANCELED等
+	OrderPurpose    string          `gorm:"type:varchar(20)" json:"orderPurpose"`                        // entry/take_profit/stop_loss/trailing_stop
+	ExecutedQty     decimal.Decimal `json:"executedQty" gorm:"type:decimal(36,18);comment:已成交数量"`        // 已成交数量
+	AvgPrice        decimal.Decimal `json:"avgPrice" gorm:"type:decimal(36,18);comment:平均成交价"`           // 平均成交价
+	Commission      decimal.Decimal `json:"commission" gorm:"type:decimal(36,18);comment:手续费"`           // 手续费
+	CommissionAsset string          `gorm:"type:varchar(20)" json:"commissionAsset"`                     // 手续费资产
+	RealizedPnl     decimal.Decimal `json:"realizedPnl" gorm:"type:decimal(36,18);comment:已实现盈亏"`        // 已实现盈亏
+	Paper           bool            `gorm:"default:false;comment:模拟盘订单" json:"paper"`                    // 模拟盘订单
+	ActivationPrice decimal.Decimal `json:"activationPrice" gorm:"type:decimal(36,18);comment:跟踪激活价格"`   // 跟踪止损激活价格
+	CallbackRate    float64         `json:"callbackRate" gorm:"comment:跟踪回调百分比"`                         // 跟踪止损回调百分比
+	HighWaterMark   decimal.Decimal `json:"highWaterMark" gorm:"type:decimal(36,18);comment:挂单时的最高/最低价"` // 本地跟踪调整时的最高价（做空为最低价）
+	ReplacedOrderID int64           `json:"replacedOrderId" gorm:"comment:被替换的订单ID"`                     // 跟踪止损调整时被撤销的上一笔订单
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
 }
 
 // FuturesPosition 永续期货持仓记录
 type FuturesPosition struct {
 	gorm.Model
-	ID               uint       `gorm:"primaryKey" json:"id"`
-	UserID           uint       `gorm:"index" json:"userId"`
-	StrategyID       uint       `gorm:"index" json:"strategyId"`              // 关联策略
-	Symbol           string     `gorm:"type:varchar(50)" json:"symbol"`       // 交易对
-	PositionSide     string     `gorm:"type:varchar(10)" json:"positionSide"` // LONG/SHORT
-	EntryPrice       float64    `json:"entryPrice" gorm:"comment:开仓均价"`       // 开仓均价
-	Quantity         float64    `json:"quantity" gorm:"comment:持仓数量"`         // 持仓数量
-	UnrealizedPnl    float64    `json:"unrealizedPnl" gorm:"comment:未实现盈亏"`   // 未实现盈亏
-	RealizedPnl      float64    `json:"realizedPnl" gorm:"comment:已实现盈亏"`     // 已实现盈亏
-	Leverage         int        `json:"leverage" gorm:"comment:杠杆倍数"`         // 杠杆倍数
-	MarginType       string     `gorm:"type:varchar(20)" json:"marginType"`   // ISOLATED/CROSSED
-	IsolatedMargin   float64    `json:"isolatedMargin" gorm:"comment:逐仓保证金"`  // 逐仓保证金
-	MarkPrice        float64    `json:"markPrice" gorm:"comment:标记价格"`        // 标记价格
-	LiquidationPrice float64    `json:"liquidationPrice" gorm:"comment:强平价格"` // 强平价格
-	Status           string     `gorm:"type:varchar(20)" json:"status"`       // open/closed
-	OpenedAt         time.Time  `json:"openedAt" gorm:"comment:开仓时间"`         // 开仓时间
-	ClosedAt         *time.Time `json:"closedAt" gorm:"comment:平仓时间"`         // 平仓时间
-	CreatedAt        time.Time  `json:"createdAt"`
-	UpdatedAt        time.Time  `json:"updatedAt"`
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	UserID           uint            `gorm:"index" json:"userId"`
+	AccountID        uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"`         // 所属交易所账户
+	StrategyID       uint            `gorm:"index" json:"strategyId"`                                  // 关联策略
+	Symbol           string          `gorm:"type:varchar(50)" json:"symbol"`                           // 交易对
+	PositionSide     string          `gorm:"type:varchar(10)" json:"positionSide"`                     // LONG/SHORT
+	EntryPrice       decimal.Decimal `json:"entryPrice" gorm:"type:decimal(36,18);comment:开仓均价"`       // 开仓均价
+	Quantity         decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18);comment:持仓数量"`         // 持仓数量
+	UnrealizedPnl    decimal.Decimal `json:"unrealizedPnl" gorm:"type:decimal(36,18);comment:未实现盈亏"`   // 未实现盈亏
+	RealizedPnl      decimal.Decimal `json:"realizedPnl" gorm:"type:decimal(36,18);comment:已实现盈亏"`     // 已实现盈亏
+	Leverage         int             `json:"leverage" gorm:"comment:杠杆倍数"`                             // 杠杆倍数
+	MarginType       string          `gorm:"type:varchar(20)" json:"marginType"`                       // ISOLATED/CROSSED
+	IsolatedMargin   decimal.Decimal `json:"isolatedMargin" gorm:"type:decimal(36,18);comment:逐仓保证金"`  // 逐仓保证金
+	MarkPrice        decimal.Decimal `json:"markPrice" gorm:"type:decimal(36,18);comment:标记价格"`        // 标记价格
+	LiquidationPrice decimal.Decimal `json:"liquidationPrice" gorm:"type:decimal(36,18);comment:强平价格"` // 强平价格
+	Status           string          `gorm:"type:varchar(20)" json:"status"`                           // open/closed
+	OpenedAt         time.Time       `json:"openedAt" gorm:"comment:开仓时间"`                             // 开仓时间
+	ClosedAt         *time.Time      `json:"closedAt" gorm:"comment:平仓时间"`                             // 平仓时间
+	Paper            bool            `gorm:"default:false;comment:模拟盘持仓" json:"paper"`                 // 模拟盘持仓
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+}
+
+// FuturesExecution 期货策略开仓阶段的执行状态，每次挂单、成交、换层都写入数据库，
+// 服务重启后据此恢复开仓订单监控；开仓流程结束时删除
+type FuturesExecution struct {
+	gorm.Model
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	StrategyID       uint            `gorm:"uniqueIndex" json:"strategyId"`                               // 关联策略，每个策略最多一条
+	StrategyType     string          `gorm:"type:varchar(20)" json:"strategyType"`                        // simple/iceberg/slow_iceberg
+	Layer            int             `json:"layer" gorm:"comment:慢冰山当前层"`                                 // 慢冰山当前层，从0开始
+	OrderIDs         string          `gorm:"type:text;comment:监控中的开仓订单ID" json:"orderIds"`                // 逗号分隔的币安订单ID
+	DoneOrderIDs     string          `gorm:"type:text;comment:已处理的开仓订单ID" json:"doneOrderIds"`            // 冰山策略中已成交或已失败的订单
+	FilledQty        decimal.Decimal `json:"filledQty" gorm:"type:decimal(36,18);comment:已成交数量"`          // 冰山策略累计成交数量
+	WeightedPriceSum decimal.Decimal `json:"weightedPriceSum" gorm:"type:decimal(36,18);comment:成交价格加权和"` // 冰山策略成交价×数量之和
+	HandledOrderID   int64           `json:"handledOrderId" gorm:"comment:已处理成交的开仓订单ID"`                  // 已挂出止盈止损并计入持仓的订单，恢复时不再重复处理
+	StartedAt        time.Time       `json:"startedAt" gorm:"comment:开仓订单挂出时间"`                           // 简单和冰山策略的超时起点
+	LayerStartedAt   time.Time       `json:"layerStartedAt" gorm:"comment:当前层挂单时间"`                       // 慢冰山当前层的超时起点
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
 // FuturesStats 永续期货统计
@@ -103,21 +141,26 @@ type FuturesStats struct {
 	ActiveStrategies int     `json:"activeStrategies"` // 活跃策略数
 }
 
+// OrderValue 实际开仓价值（USDT）：本金×杠杆
+func (s *FuturesStrategy) OrderValue() decimal.Decimal {
+	return s.Quantity.Mul(decimal.NewFromInt(int64(s.Leverage)))
+}
+
 // CalculateTakeProfitPrice 计算止盈价格
 func (s *FuturesStrategy) CalculateTakeProfitPrice() {
-	if s.EntryPrice <= 0 || s.TakeProfitRate <= 0 {
+	if !s.EntryPrice.IsPositive() || s.TakeProfitRate <= 0 {
 		return
 	}
 
 	// takeProfitRate 是价格变动的万分比
-	priceChangeRate := s.TakeProfitRate / 10000.0
+	priceChangeRate := decimal.NewFromFloat(s.TakeProfitRate).Div(decimal.NewFromInt(10000))
 
 	if s.Side == "LONG" {
 		// 多头：止盈价格 = 开仓价格 × (1 + 价格变动率)
-		s.TakeProfitPrice = s.EntryPrice * (1 + priceChangeRate)
+		s.TakeProfitPrice = s.EntryPrice.Mul(decimal.NewFromInt(1).Add(priceChangeRate))
 	} else {
 		// 空头：止盈价格 = 开仓价格 × (1 - 价格变动率)
-		s.TakeProfitPrice = s.EntryPrice * (1 - priceChangeRate)
+		s.TakeProfitPrice = s.EntryPrice.Mul(decimal.NewFromInt(1).Sub(priceChangeRate))
 	}
 }
 
@@ -127,15 +170,32 @@ func (s *FuturesStrategy) CalculateStopLossPrice() {
 		return
 	}
 
-	stopLossRate := s.StopLossRate / 100.0
+	stopLossRate := decimal.NewFromFloat(s.StopLossRate).Div(decimal.NewFromInt(100))
 
 	if s.Side == "LONG" {
 		// 多头：止损价格 = 开仓价格 * (1 - 止损率)
-		s.StopLossPrice = s.EntryPrice * (1 - stopLossRate)
+		s.StopLossPrice = s.EntryPrice.Mul(decimal.NewFromInt(1).Sub(stopLossRate))
 	} else {
 		// 空头：止损价格 = 开仓价格 * (1 + 止损率)
-		s.StopLossPrice = s.EntryPrice * (1 + stopLossRate)
+		s.StopLossPrice = s.EntryPrice.Mul(decimal.NewFromInt(1).Add(stopLossRate))
+	}
+}
+
+// TrailingEnabled 是否开启跟踪止损/止盈
+func (s *FuturesStrategy) TrailingEnabled() bool {
+	return (s.TrailingMode == "exchange" || s.TrailingMode == "local") && s.TrailingCallbackRate > 0
+}
+
+// TrailingStopFor 根据激活后的最高价（做空为最低价）计算跟踪止损价
+func (s *FuturesStrategy) TrailingStopFor(highWater decimal.Decimal) decimal.Decimal {
+	callbackRate := decimal.NewFromFloat(s.TrailingCallbackRate).Div(decimal.NewFromInt(100))
+
+	if s.Side == "LONG" {
+		// 多头：止损价格 = 最高价 × (1 - 回调比例)
+		return highWater.Mul(decimal.NewFromInt(1).Sub(callbackRate))
 	}
+	// 空头：止损价格 = 最低价 × (1 + 回调比例)
+	return highWater.Mul(decimal.NewFromInt(1).Add(callbackRate))
 }
 
 // TableName 指定表名
@@ -151,11 +211,16 @@ func (FuturesPosition) TableName() string {
 	return "futures_positions"
 }
 
+func (FuturesExecution) TableName() string {
+	return "futures_executions"
+}
+
 // MigrateFuturesTables 迁移期货相关表
 func MigrateFuturesTables(db *gorm.DB) error {
 	return db.AutoMigrate(
 		&FuturesStrategy{},
 		&FuturesOrder{},
 		&FuturesPosition{},
+		&FuturesExecution{},
 	)
 }
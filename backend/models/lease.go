@@ -0,0 +1,25 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// TaskLease 后台任务租约，多实例部署时同一任务只由持有租约的实例运行
+type TaskLease struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"type:varchar(50);uniqueIndex" json:"name"` // 任务名称
+	Holder    string    `gorm:"type:varchar(100)" json:"holder"`          // 持有租约的实例ID，空表示无人持有
+	ExpiresAt time.Time `json:"expiresAt" gorm:"comment:租约到期时间"`          // 到期后其他实例可以接管
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (TaskLease) TableName() string {
+	return "task_leases"
+}
+
+// MigrateLeaseTables 迁移任务租约表
+func MigrateLeaseTables(db *gorm.DB) error {
+	return db.AutoMigrate(&TaskLease{})
+}
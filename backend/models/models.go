@@ -4,6 +4,7 @@ package models
 import (
 	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/utils"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"log"
 	"time"
@@ -11,15 +12,17 @@ import (
 
 type User struct {
 	gorm.Model
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"type:varchar(255);uniqueIndex" json:"username"`
-	Password  string    `gorm:"type:varchar(255)" json:"-"`                       // 不序列化
-	APIKey    string    `gorm:"type:varchar(500)" json:"-"`                       // 加密存储，不序列化
-	SecretKey string    `gorm:"type:varchar(500)" json:"-"`                       // 加密存储，不序列化
-	Role      string    `gorm:"type:varchar(20);default:'user'" json:"role"`      // admin, user
-	Status    string    `gorm:"type:varchar(20);default:'pending'" json:"status"` // pending, active, disabled
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	Username  string `gorm:"type:varchar(255);uniqueIndex" json:"username"`
+	Password  string `gorm:"type:varchar(255)" json:"-"`                       // 不序列化
+	APIKey    string `gorm:"type:varchar(500)" json:"-"`                       // 加密存储，不序列化
+	SecretKey string `gorm:"type:varchar(500)" json:"-"`                       // 加密存储，不序列化
+	Role      string `gorm:"type:varchar(20);default:'user'" json:"role"`      // admin, user
+	Status    string `gorm:"type:varchar(20);default:'pending'" json:"status"` // pending, active, disabled
+	// 模拟盘模式：开启后该用户所有策略都走模拟撮合，不会向交易所下真实订单
+	PaperTrading bool      `gorm:"default:false;comment:模拟盘模式" json:"paperTrading"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
 // BeforeSave 保存前加密API密钥
@@ -97,10 +100,10 @@ type Symbol struct {
 
 type Price struct {
 	gorm.Model
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Symbol    string    `gorm:"unique" json:"symbol"`
-	Price     string    `json:"price"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Symbol    string          `gorm:"unique" json:"symbol"`
+	Price     decimal.Decimal `json:"price" gorm:"type:decimal(36,18)"`
+	UpdatedAt time.Time       `json:"updatedAt"`
 }
 
 type Trade struct {
@@ -117,70 +120,109 @@ type Trade struct {
 
 type Strategy struct {
 	gorm.Model
-	ID              uint    `gorm:"primaryKey" json:"id"`
-	UserID          uint    `gorm:"index" json:"userId"`
-	Symbol          string  `gorm:"type:varchar(50)" json:"symbol"`
-	StrategyType    string  `gorm:"type:varchar(20)" json:"strategyType"` // simple, iceberg, custom
-	Side            string  `gorm:"type:varchar(10)" json:"side"`         // BUY, SELL
-	Price           float64 `json:"price" gorm:"comment:触发价格"`        // 触发价格：买入策略在价格<=此值时触发，卖出策略在价格>=此值时触发
-	TotalQuantity   float64 `json:"totalQuantity" gorm:"comment:总数量"`  // 策略的总交易数量
-	Status          string  `gorm:"type:varchar(20);default:'active'" json:"status"`
-	Enabled         bool    `gorm:"default:true" json:"enabled"`
-	BuyQuantities   string  `gorm:"type:text;comment:买入数量分配(逗号分隔的比例)" json:"buyQuantities"`  // 逗号分隔的百分比，总和应为1.0
-	SellQuantities  string  `gorm:"type:text;comment:卖出数量分配(逗号分隔的比例)" json:"sellQuantities"` // 逗号分隔的百分比，总和应为1.0
-	BuyDepthLevels  string  `gorm:"type:text;comment:买入深度级别(逗号分隔)" json:"buyDepthLevels"`       // 逗号分隔的深度级别(1,2,3...)
-	SellDepthLevels string  `gorm:"type:text;comment:卖出深度级别(逗号分隔)" json:"sellDepthLevels"`      // 逗号分隔的深度级别(1,2,3...)
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	UserID          uint            `gorm:"index" json:"userId"`
+	AccountID       uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Symbol          string          `gorm:"type:varchar(50)" json:"symbol"`
+	StrategyType    string          `gorm:"type:varchar(20)" json:"strategyType"`                 // simple, iceberg, custom, grid
+	Side            string          `gorm:"type:varchar(10)" json:"side"`                         // BUY, SELL
+	Price           decimal.Decimal `json:"price" gorm:"type:decimal(36,18);comment:触发价格"`        // 触发价格：买入策略在价格<=此值时触发，卖出策略在价格>=此值时触发
+	TotalQuantity   decimal.Decimal `json:"totalQuantity" gorm:"type:decimal(36,18);comment:总数量"` // 策略的总交易数量
+	Status          string          `gorm:"type:varchar(20);default:'active'" json:"status"`
+	Enabled         bool            `gorm:"default:true" json:"enabled"`
+	BuyQuantities   string          `gorm:"type:text;comment:买入数量分配(逗号分隔的比例)" json:"buyQuantities"`  // 逗号分隔的百分比，总和应为1.0
+	SellQuantities  string          `gorm:"type:text;comment:卖出数量分配(逗号分隔的比例)" json:"sellQuantities"` // 逗号分隔的百分比，总和应为1.0
+	BuyDepthLevels  string          `gorm:"type:text;comment:买入深度级别(逗号分隔)" json:"buyDepthLevels"`    // 逗号分隔的深度级别(1,2,3...)
+	SellDepthLevels string          `gorm:"type:text;comment:卖出深度级别(逗号分隔)" json:"sellDepthLevels"`   // 逗号分隔的深度级别(1,2,3...)
 	// 新增字段：万分比配置（预留）
-	BuyBasisPoints     string    `gorm:"type:text;comment:买入价格偏移(万分比)" json:"buyBasisPoints"`         // 逗号分隔的万分比 (如: -10,-5,0,5,10)
-	SellBasisPoints    string    `gorm:"type:text;comment:卖出价格偏移(万分比)" json:"sellBasisPoints"`        // 逗号分隔的万分比
+	BuyBasisPoints     string    `gorm:"type:text;comment:买入价格偏移(万分比)" json:"buyBasisPoints"`        // 逗号分隔的万分比 (如: -10,-5,0,5,10)
+	SellBasisPoints    string    `gorm:"type:text;comment:卖出价格偏移(万分比)" json:"sellBasisPoints"`       // 逗号分隔的万分比
 	CancelAfterMinutes int       `gorm:"default:120;comment:订单自动取消时间(分钟)" json:"cancelAfterMinutes"` // 订单自动取消时间（分钟），默认120分钟
 	CreatedAt          time.Time `json:"createdAt"`
 	UpdatedAt          time.Time `json:"updatedAt"`
-	PendingBatch       bool      `gorm:"default:false;comment:是否有待处理订单批次" json:"pendingBatch"` // 标记是否有活跃订单批次
+	PendingBatch       bool      `gorm:"default:false;comment:是否有待处理订单批次" json:"pendingBatch"`     // 标记是否有活跃订单批次
+	Paper              bool      `gorm:"default:false;comment:模拟盘策略" json:"paper"`                 // 模拟盘策略，订单只在模拟撮合引擎中成交
+	TriggerCondition   string    `gorm:"type:varchar(500);comment:指标触发条件" json:"triggerCondition"` // 指标条件，如 RSI(14) ON 15m < 30，与触发价格同时设置时需同时满足
+
+	// 网格策略配置
+	GridLowerPrice decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:网格下限价格" json:"gridLowerPrice"`
+	GridUpperPrice decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:网格上限价格" json:"gridUpperPrice"`
+	GridCount      int             `gorm:"default:0;comment:网格数量" json:"gridCount"`                        // 区间等分的格数，共 GridCount+1 条价格线
+	GridQuantity   decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:每格数量" json:"gridQuantity"` // 每条价格线的委托数量
+	GridRebalance  bool            `gorm:"default:false;comment:超出区间时重新平衡" json:"gridRebalance"`           // 价格离开区间后撤单并以当前价格为中心重建网格
+}
+
+// GridStep 返回网格相邻价格线的间距
+func (s *Strategy) GridStep() decimal.Decimal {
+	if s.GridCount <= 0 {
+		return decimal.Zero
+	}
+	return s.GridUpperPrice.Sub(s.GridLowerPrice).Div(decimal.NewFromInt(int64(s.GridCount)))
+}
+
+// GridLevelPrice 返回第 level 条价格线的价格，level 从1开始
+func (s *Strategy) GridLevelPrice(level int) decimal.Decimal {
+	return s.GridLowerPrice.Add(decimal.NewFromInt(int64(level - 1)).Mul(s.GridStep()))
 }
 
 type Order struct {
 	gorm.Model
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	StrategyID  uint      `gorm:"index" json:"strategyId"`
-	UserID      uint      `gorm:"index" json:"userId"`
-	Symbol      string    `gorm:"type:varchar(50)" json:"symbol"`
-	Side        string    `gorm:"type:varchar(10)" json:"side"`
-	Price       float64   `json:"price"`
-	Quantity    float64   `json:"quantity"`
-	OrderID     int64     `gorm:"index" json:"orderId"`
-	Status      string    `gorm:"type:varchar(20)" json:"status"` // pending, filled, cancelled, expired, rejected
-	CancelAfter time.Time `json:"cancelAfter" gorm:"comment:自动取消时间"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	StrategyID  uint            `gorm:"index" json:"strategyId"`
+	UserID      uint            `gorm:"index" json:"userId"`
+	AccountID   uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Symbol      string          `gorm:"type:varchar(50)" json:"symbol"`
+	Side        string          `gorm:"type:varchar(10)" json:"side"`
+	Price       decimal.Decimal `json:"price" gorm:"type:decimal(36,18)"`
+	Quantity    decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18)"`
+	OrderID     int64           `gorm:"index" json:"orderId"`
+	Status      string          `gorm:"type:varchar(20)" json:"status"` // pending, filled, cancelled, expired, rejected
+	CancelAfter time.Time       `json:"cancelAfter" gorm:"comment:自动取消时间"`
+	Paper       bool            `gorm:"default:false;comment:模拟盘订单" json:"paper"` // 模拟盘订单，OrderID 为模拟撮合引擎的订单号
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+
+	// 网格订单字段
+	GridLevel      int             `gorm:"default:0;comment:网格价格线" json:"gridLevel"`                            // 从1开始，0表示非网格订单
+	GridEntryPrice decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:配对开仓成交价" json:"gridEntryPrice"` // 由反向成交挂出的配对单记录开仓成交价，>0 表示平仓单
+	GridProfit     decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:网格已实现利润" json:"gridProfit"`     // 平仓单成交后记录的一次往返利润（计价币）
+
+	DCAStrategyID uint `gorm:"index;default:0;comment:定投策略ID" json:"dcaStrategyId"` // 定投订单所属策略，0表示非定投订单
+
+	// 成交汇总，由成交明细同步
+	ExecutedQty    decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:已成交数量" json:"executedQty"`
+	AvgPrice       decimal.Decimal `gorm:"type:decimal(36,18);default:0;comment:成交均价" json:"avgPrice"`
+	CommissionUSDT float64         `gorm:"default:0;comment:手续费折合USDT" json:"commissionUsdt"`
 }
 
 type Withdrawal struct {
 	gorm.Model
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"index" json:"userId"`
-	Asset     string    `gorm:"type:varchar(20)" json:"asset"`
-	Amount    float64   `json:"amount" gorm:"comment:提币金额，0表示提取全部"` // 0表示提取最大可用金额
-	Address   string    `gorm:"type:varchar(500)" json:"address"`
-	Threshold float64   `json:"threshold" gorm:"comment:触发阈值"` // 余额达到此值时触发提币
-	Enabled   bool      `gorm:"default:true" json:"enabled"`
-	Status    string    `gorm:"type:varchar(20)" json:"status"` // active, paused
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	UserID    uint            `gorm:"index" json:"userId"`
+	AccountID uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Asset     string          `gorm:"type:varchar(20)" json:"asset"`
+	Amount    decimal.Decimal `json:"amount" gorm:"type:decimal(36,18);comment:提币金额，0表示提取全部"` // 0表示提取最大可用金额
+	Address   string          `gorm:"type:varchar(500)" json:"address"`
+	Threshold decimal.Decimal `json:"threshold" gorm:"type:decimal(36,18);comment:触发阈值"` // 余额达到此值时触发提币
+	Enabled   bool            `gorm:"default:true" json:"enabled"`
+	Status    string          `gorm:"type:varchar(20)" json:"status"` // active, paused
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
 }
 
 type WithdrawalHistory struct {
 	gorm.Model
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	UserID       uint      `gorm:"index" json:"userId"`
-	Asset        string    `gorm:"type:varchar(20)" json:"asset"`
-	Amount       float64   `json:"amount"`
-	Address      string    `gorm:"type:varchar(500)" json:"address"`
-	WithdrawalID string    `gorm:"type:varchar(100)" json:"withdrawalId"`
-	TxID         string    `gorm:"type:varchar(100)" json:"txId"`
-	Status       string    `gorm:"type:varchar(20)" json:"status"` // processing, completed, failed
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	UserID       uint            `gorm:"index" json:"userId"`
+	AccountID    uint            `gorm:"index;default:0;comment:交易所账户ID" json:"accountId"` // 所属交易所账户
+	Asset        string          `gorm:"type:varchar(20)" json:"asset"`
+	Amount       decimal.Decimal `json:"amount" gorm:"type:decimal(36,18)"`
+	Address      string          `gorm:"type:varchar(500)" json:"address"`
+	WithdrawalID string          `gorm:"type:varchar(100)" json:"withdrawalId"`
+	TxID         string          `gorm:"type:varchar(100)" json:"txId"`
+	Status       string          `gorm:"type:varchar(20)" json:"status"` // processing, completed, failed
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    time.Time       `json:"updatedAt"`
 }
 
 type CustomSymbol struct {
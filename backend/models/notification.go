@@ -0,0 +1,110 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 通知事件类型
+const (
+	NotifyStrategyTriggered = "strategy_triggered"
+	NotifyOrderFilled       = "order_filled"
+	NotifyPositionClosed    = "position_closed"
+	NotifyWithdrawalSent    = "withdrawal_sent"
+	NotifyWithdrawalFailed  = "withdrawal_failed"
+	NotifyDCISettled        = "dci_settled"
+	NotifyAPIKeyInvalid     = "api_key_invalid"
+	NotifyPriceAlert        = "price_alert"
+	NotifyTest              = "test"
+)
+
+// NotifyEvents 可订阅的通知事件
+var NotifyEvents = []string{
+	NotifyStrategyTriggered,
+	NotifyOrderFilled,
+	NotifyPositionClosed,
+	NotifyWithdrawalSent,
+	NotifyWithdrawalFailed,
+	NotifyDCISettled,
+	NotifyAPIKeyInvalid,
+	NotifyPriceAlert,
+}
+
+// 通知渠道
+const (
+	NotifyChannelTelegram = "telegram"
+	NotifyChannelEmail    = "email"
+	NotifyChannelWebhook  = "webhook"
+)
+
+// NotificationPreference 用户通知偏好
+type NotificationPreference struct {
+	gorm.Model
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"uniqueIndex" json:"userId"`
+	Events          string    `gorm:"type:varchar(500)" json:"events"`      // 订阅的事件，逗号分隔，为空表示全部
+	TelegramEnabled bool      `gorm:"default:false" json:"telegramEnabled"` // 启用Telegram
+	TelegramChatID  string    `gorm:"type:varchar(100)" json:"telegramChatId"`
+	EmailEnabled    bool      `gorm:"default:false" json:"emailEnabled"` // 启用邮件
+	Email           string    `gorm:"type:varchar(255)" json:"email"`
+	WebhookEnabled  bool      `gorm:"default:false" json:"webhookEnabled"` // 启用Webhook
+	WebhookURL      string    `gorm:"type:varchar(500)" json:"webhookUrl"`
+	WebhookSecret   string    `gorm:"type:varchar(500)" json:"-"` // 签名密钥，加密存储，不序列化
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// Subscribed 是否订阅了指定事件，测试通知始终发送
+func (p *NotificationPreference) Subscribed(event string) bool {
+	if event == NotifyTest || strings.TrimSpace(p.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(p.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels 已启用的通知渠道
+func (p *NotificationPreference) Channels() []string {
+	var channels []string
+	if p.TelegramEnabled && p.TelegramChatID != "" {
+		channels = append(channels, NotifyChannelTelegram)
+	}
+	if p.EmailEnabled && p.Email != "" {
+		channels = append(channels, NotifyChannelEmail)
+	}
+	if p.WebhookEnabled && p.WebhookURL != "" {
+		channels = append(channels, NotifyChannelWebhook)
+	}
+	return channels
+}
+
+// NotificationDelivery 通知投递记录，同时作为失败重试的队列
+type NotificationDelivery struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserID        uint       `gorm:"index" json:"userId"`
+	Event         string     `gorm:"type:varchar(50);index" json:"event"`  // 事件类型
+	Channel       string     `gorm:"type:varchar(20)" json:"channel"`      // telegram/email/webhook
+	Title         string     `gorm:"type:varchar(255)" json:"title"`       // 标题
+	Payload       string     `gorm:"type:text" json:"payload"`             // 通知内容JSON
+	Status        string     `gorm:"type:varchar(20);index" json:"status"` // pending/sent/retrying/failed
+	Attempts      int        `json:"attempts"`                             // 已尝试次数
+	LastError     string     `gorm:"type:varchar(1000)" json:"lastError"`  // 最近一次失败原因
+	NextAttemptAt *time.Time `gorm:"index" json:"nextAttemptAt"`           // 下次重试时间
+	SentAt        *time.Time `json:"sentAt"`                               // 发送成功时间
+	CreatedAt     time.Time  `gorm:"index" json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// MigrateNotificationTables 迁移通知相关表
+func MigrateNotificationTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&NotificationPreference{},
+		&NotificationDelivery{},
+	)
+}
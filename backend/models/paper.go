@@ -0,0 +1,67 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"time"
+)
+
+// PaperOrder 模拟撮合引擎中的订单，OrderID 即为本表主键
+type PaperOrder struct {
+	gorm.Model
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	UserID          uint            `gorm:"index" json:"userId"`
+	Market          string          `gorm:"type:varchar(10);index:idx_paper_orders_match" json:"market"` // spot/futures
+	Symbol          string          `gorm:"type:varchar(50);index:idx_paper_orders_match" json:"symbol"` // 交易对
+	Side            string          `gorm:"type:varchar(10)" json:"side"`                                // BUY/SELL
+	PositionSide    string          `gorm:"type:varchar(10)" json:"positionSide"`                        // LONG/SHORT（仅合约）
+	Type            string          `gorm:"type:varchar(30)" json:"type"`                                // LIMIT/MARKET/STOP_MARKET/TAKE_PROFIT_MARKET
+	TimeInForce     string          `gorm:"type:varchar(10)" json:"timeInForce"`                         // GTC等
+	Price           decimal.Decimal `json:"price" gorm:"type:decimal(36,18);comment:委托价格"`               // 限价单价格
+	StopPrice       decimal.Decimal `json:"stopPrice" gorm:"type:decimal(36,18);comment:触发价格"`           // 止盈止损触发价格
+	ActivationPrice decimal.Decimal `json:"activationPrice" gorm:"type:decimal(36,18);comment:跟踪激活价格"`   // 跟踪止损激活价格，0表示立即激活
+	CallbackRate    float64         `json:"callbackRate" gorm:"comment:跟踪回调百分比"`                         // 跟踪止损回调百分比
+	HighWaterMark   decimal.Decimal `json:"highWaterMark" gorm:"type:decimal(36,18);comment:跟踪最高/最低价"`   // 跟踪止损激活后的最高价（买单为最低价）
+	Quantity        decimal.Decimal `json:"quantity" gorm:"type:decimal(36,18);comment:委托数量"`            // 委托数量
+	ExecutedQty     decimal.Decimal `json:"executedQty" gorm:"type:decimal(36,18);comment:已成交数量"`        // 已成交数量
+	AvgPrice        decimal.Decimal `json:"avgPrice" gorm:"type:decimal(36,18);comment:成交均价"`            // 成交均价
+	Commission      decimal.Decimal `json:"commission" gorm:"type:decimal(36,18);comment:手续费(计价资产)"`     // 手续费，以计价资产计
+	RealizedPnl     decimal.Decimal `json:"realizedPnl" gorm:"type:decimal(36,18);comment:已实现盈亏"`        // 平仓产生的已实现盈亏（仅合约）
+	Status          string          `gorm:"type:varchar(20);index:idx_paper_orders_match" json:"status"` // NEW/FILLED/CANCELED/EXPIRED
+	ClientOrderID   string          `gorm:"type:varchar(36)" json:"clientOrderId"`                       // 自定义订单ID（仅合约）
+	FilledAt        *time.Time      `json:"filledAt" gorm:"comment:成交时间"`                                // 成交时间
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+// PaperPosition 模拟盘合约持仓，数量为0时保留记录以便持仓同步识别平仓
+type PaperPosition struct {
+	gorm.Model
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	UserID       uint            `gorm:"index" json:"userId"`
+	Symbol       string          `gorm:"type:varchar(50)" json:"symbol"`                     // 交易对
+	PositionSide string          `gorm:"type:varchar(10)" json:"positionSide"`               // LONG/SHORT
+	Amount       decimal.Decimal `json:"amount" gorm:"type:decimal(36,18);comment:持仓数量"`     // 持仓数量（正数）
+	EntryPrice   decimal.Decimal `json:"entryPrice" gorm:"type:decimal(36,18);comment:开仓均价"` // 开仓均价
+	MarkPrice    decimal.Decimal `json:"markPrice" gorm:"type:decimal(36,18);comment:标记价格"`  // 最近一次撮合使用的价格
+	Leverage     int             `json:"leverage" gorm:"default:20;comment:杠杆倍数"`
+	MarginType   string          `gorm:"type:varchar(20);default:'CROSSED'" json:"marginType"` // ISOLATED/CROSSED
+	CreatedAt    time.Time       `json:"createdAt"`
+	UpdatedAt    time.Time       `json:"updatedAt"`
+}
+
+func (PaperOrder) TableName() string {
+	return "paper_orders"
+}
+
+func (PaperPosition) TableName() string {
+	return "paper_positions"
+}
+
+// MigratePaperTables 迁移模拟盘相关表
+func MigratePaperTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&PaperOrder{},
+		&PaperPosition{},
+	)
+}
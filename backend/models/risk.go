@@ -0,0 +1,84 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 风控规则
+const (
+	RiskRuleSymbolNotional   = "max_symbol_notional"
+	RiskRuleLeverageExposure = "max_leverage_exposure"
+	RiskRuleOpenOrders       = "max_open_orders"
+	RiskRuleDailyLoss        = "max_daily_loss"
+	RiskRuleBalanceReserve   = "min_free_balance"
+	RiskRuleKillSwitch       = "kill_switch"
+)
+
+// RiskLimit 用户级风控限额，金额均为USDT，0表示不限制
+type RiskLimit struct {
+	gorm.Model
+	ID                  uint            `gorm:"primaryKey" json:"id"`
+	UserID              uint            `gorm:"uniqueIndex" json:"userId"`
+	Enabled             bool            `gorm:"default:true" json:"enabled"`                                     // 是否启用风控检查
+	MaxSymbolNotional   decimal.Decimal `json:"maxSymbolNotional" gorm:"type:decimal(36,18);comment:单交易对最大名义价值"` // 单个交易对的挂单和持仓名义价值上限
+	MaxLeverageExposure float64         `json:"maxLeverageExposure" gorm:"comment:合约最大总杠杆"`                      // 合约总名义价值/合约钱包余额上限
+	MaxOpenOrders       int             `json:"maxOpenOrders" gorm:"comment:最大挂单数"`                              // 现货和合约未完成订单总数上限
+	MaxDailyLoss        decimal.Decimal `json:"maxDailyLoss" gorm:"type:decimal(36,18);comment:单日最大亏损"`          // 当日亏损上限，触发后停用策略
+	MinFreeBalance      decimal.Decimal `json:"minFreeBalance" gorm:"type:decimal(36,18);comment:最低可用余额"`        // 下单后需保留的可用余额
+	HaltedAt            *time.Time      `json:"haltedAt" gorm:"comment:单日亏损触发时间"`                                // 当日亏损超限时间，当天内拒绝新开仓
+	HaltReason          string          `gorm:"type:varchar(500)" json:"haltReason"`                             // 停止交易原因
+	CreatedAt           time.Time       `json:"createdAt"`
+	UpdatedAt           time.Time       `json:"updatedAt"`
+}
+
+// HaltedSince 是否在指定时间之后因单日亏损停止交易
+func (l *RiskLimit) HaltedSince(dayStart time.Time) bool {
+	return l.HaltedAt != nil && !l.HaltedAt.Before(dayStart)
+}
+
+// RiskEvent 风控拒单和停用策略的记录
+type RiskEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index" json:"userId"`
+	Rule       string    `gorm:"type:varchar(50);index" json:"rule"`     // 触发的规则
+	Source     string    `gorm:"type:varchar(50)" json:"source"`         // strategy/grid/dca/futures_strategy/dual_investment/manual/monitor
+	StrategyID uint      `json:"strategyId"`                             // 关联策略
+	Symbol     string    `gorm:"type:varchar(50)" json:"symbol"`         // 交易对
+	Value      float64   `json:"value" gorm:"comment:触发时的数值"`            // 触发时的数值
+	Threshold  float64   `json:"threshold" gorm:"comment:限额"`            // 对应限额
+	Message    string    `gorm:"type:varchar(500)" json:"message"`       // 说明
+	Paper      bool      `gorm:"default:false;comment:模拟盘" json:"paper"` // 模拟盘订单
+	CreatedAt  time.Time `gorm:"index" json:"createdAt"`
+}
+
+// KillSwitchEvent 紧急停止记录，生效期间拒绝目标用户的所有新开仓
+type KillSwitchEvent struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	Scope              string     `gorm:"type:varchar(20);index" json:"scope"`      // user/system
+	TargetUserID       uint       `gorm:"index" json:"targetUserId"`                // 用户级为目标用户，系统级为0
+	TriggeredBy        uint       `json:"triggeredBy"`                              // 触发人
+	Reason             string     `gorm:"type:varchar(500)" json:"reason"`          // 触发原因
+	ClosePositions     bool       `gorm:"default:false" json:"closePositions"`      // 是否市价平掉合约持仓
+	Active             bool       `gorm:"default:true;index" json:"active"`         // 是否仍在生效
+	StrategiesDisabled int64      `json:"strategiesDisabled" gorm:"comment:停用的策略数"` // 停用的策略数（含定投）
+	RulesDisabled      int64      `json:"rulesDisabled" gorm:"comment:停用的提币规则数"`    // 停用的提币规则数
+	OrdersCancelled    int        `json:"ordersCancelled" gorm:"comment:撤销的订单数"`    // 撤销的现货和合约订单数
+	PositionsClosed    int        `json:"positionsClosed" gorm:"comment:平仓的持仓数"`    // 市价平仓的持仓数
+	Errors             string     `gorm:"type:text" json:"errors"`                  // 执行中的错误，逐行记录
+	CompletedAt        *time.Time `json:"completedAt" gorm:"comment:执行完成时间"`        // 执行完成时间
+	ReleasedBy         uint       `json:"releasedBy"`                               // 解除人
+	ReleasedAt         *time.Time `json:"releasedAt" gorm:"comment:解除时间"`           // 解除时间
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// MigrateRiskTables 迁移风控相关表
+func MigrateRiskTables(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&RiskLimit{},
+		&RiskEvent{},
+		&KillSwitchEvent{},
+	)
+}
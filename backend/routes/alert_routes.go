@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAlertRoutes 配置价格提醒相关路由
+func SetupAlertRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	alertController := &controllers.AlertController{Config: cfg}
+
+	// 价格提醒路由组
+	alertGroup := router.Group("/alerts")
+	alertGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		alertGroup.GET("", alertController.GetAlerts)          // 获取提醒列表
+		alertGroup.POST("", alertController.CreateAlert)       // 创建提醒
+		alertGroup.PUT("/:id", alertController.UpdateAlert)    // 更新提醒
+		alertGroup.DELETE("/:id", alertController.DeleteAlert) // 删除提醒
+	}
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupConditionRoutes 配置指标触发条件相关路由
+func SetupConditionRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	conditionController := &controllers.ConditionController{Config: cfg}
+
+	// 触发条件路由组
+	conditionGroup := router.Group("/conditions")
+	conditionGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		conditionGroup.POST("/dry-run", conditionController.DryRunCondition) // 试运行条件
+	}
+}
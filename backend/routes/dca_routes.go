@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupDCARoutes 配置定投相关路由
+func SetupDCARoutes(router *gin.RouterGroup, cfg *config.Config) {
+	dcaController := &controllers.DCAController{Config: cfg}
+
+	// 定投路由组
+	dcaGroup := router.Group("/dca")
+	dcaGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		dcaGroup.GET("/strategies", dcaController.GetStrategies)         // 获取策略列表
+		dcaGroup.POST("/strategies", dcaController.CreateStrategy)       // 创建策略
+		dcaGroup.PUT("/strategies/:id", dcaController.UpdateStrategy)    // 更新策略
+		dcaGroup.DELETE("/strategies/:id", dcaController.DeleteStrategy) // 删除策略
+		dcaGroup.GET("/strategies/:id/orders", dcaController.GetOrders)  // 获取策略订单
+	}
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupExchangeAccountRoutes 配置交易所账户相关路由
+func SetupExchangeAccountRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	accountController := &controllers.ExchangeAccountController{Config: cfg}
+
+	// 交易所账户路由组
+	accountGroup := router.Group("/accounts")
+	accountGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		accountGroup.GET("", accountController.GetAccounts)                    // 获取账户列表
+		accountGroup.POST("", accountController.CreateAccount)                 // 添加账户
+		accountGroup.PUT("/:id", accountController.UpdateAccount)              // 更新账户
+		accountGroup.DELETE("/:id", accountController.DeleteAccount)           // 删除账户
+		accountGroup.POST("/:id/default", accountController.SetDefaultAccount) // 设为默认账户
+	}
+}
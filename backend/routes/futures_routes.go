@@ -21,6 +21,9 @@ func SetupFuturesRoutes(router *gin.RouterGroup, cfg *config.Config) {
 		futuresGroup.PUT("/strategies/:id", futuresController.UpdateStrategy)    // 更新策略
 		futuresGroup.DELETE("/strategies/:id", futuresController.DeleteStrategy) // 删除策略
 
+		// 策略回测
+		futuresGroup.POST("/backtest", futuresController.Backtest) // 用历史数据回测策略
+
 		// 订单管理
 		futuresGroup.GET("/orders", futuresController.GetOrders) // 获取订单列表
 
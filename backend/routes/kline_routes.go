@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupKlineRoutes 配置K线相关路由
+func SetupKlineRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	klineController := &controllers.KlineController{Config: cfg}
+
+	// K线路由组
+	klineGroup := router.Group("/klines")
+	klineGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		klineGroup.GET("", klineController.GetKlines) // 获取K线
+	}
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupNotificationRoutes 配置通知相关路由
+func SetupNotificationRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	notificationController := &controllers.NotificationController{Config: cfg}
+
+	// 通知路由组
+	notificationGroup := router.Group("/notifications")
+	notificationGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		notificationGroup.GET("/preferences", notificationController.GetPreferences)    // 获取通知偏好
+		notificationGroup.PUT("/preferences", notificationController.UpdatePreferences) // 更新通知偏好
+		notificationGroup.POST("/test", notificationController.SendTest)                // 发送测试通知
+		notificationGroup.GET("/deliveries", notificationController.GetDeliveries)      // 获取投递记录
+	}
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupRiskRoutes 配置风控相关路由
+func SetupRiskRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	riskController := &controllers.RiskController{Config: cfg}
+
+	// 风控路由组
+	riskGroup := router.Group("/risk")
+	riskGroup.Use(middleware.AuthMiddleware(cfg))
+	{
+		riskGroup.GET("/limits", riskController.GetLimits)    // 获取风控限额和当日盈亏
+		riskGroup.PUT("/limits", riskController.UpdateLimits) // 设置风控限额
+		riskGroup.GET("/events", riskController.GetEvents)    // 获取风控事件
+
+		riskGroup.POST("/kill-switch", riskController.TriggerKillSwitch)   // 紧急停止
+		riskGroup.GET("/kill-switch", riskController.GetKillSwitch)        // 获取紧急停止状态
+		riskGroup.DELETE("/kill-switch", riskController.ReleaseKillSwitch) // 解除紧急停止
+	}
+}
@@ -27,7 +27,7 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 
 	// 添加请求日志中间件
 	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
-		SkipPaths: []string{"/health"}, // 跳过健康检查日志
+		SkipPaths: []string{"/health", "/stream"}, // 跳过健康检查日志，推送连接的查询参数包含 token
 	}))
 
 	// 添加错误恢复中间件
@@ -49,6 +49,9 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 	router.POST("/register", gin.WrapH(handlers.RegisterHandler(cfg)))
 	router.POST("/login", gin.WrapH(handlers.LoginHandler(cfg)))
 
+	// 实时推送，通过查询参数认证
+	SetupStreamRoutes(&router.RouterGroup, cfg)
+
 	// 受保护路由，需要认证
 	protected := router.Group("/")
 	protected.Use(middleware.AuthMiddleware(cfg))
@@ -62,6 +65,10 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		protected.GET("/api-key", userController.GetAPIKey)
 		protected.DELETE("/api-key/delete", userController.DeleteAPIKey)
 
+		// 模拟盘模式
+		protected.GET("/paper-trading", userController.GetPaperTrading)
+		protected.PUT("/paper-trading", userController.SetPaperTrading)
+
 		// 订单管理
 		protected.GET("/orders", handlers.GinOrdersHandler(cfg))
 		protected.GET("/cancelled_orders", handlers.GinCancelledOrdersHandler(cfg))
@@ -88,6 +95,7 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		protected.DELETE("/delete_strategy", handlers.GinDeleteStrategyHandler(cfg))
 		protected.GET("/strategy/:id/stats", handlers.GinStrategyStatsHandler(cfg))
 		protected.GET("/strategy/:id/orders", handlers.GinStrategyOrdersHandler(cfg))
+		protected.POST("/strategy/backtest", handlers.GinBacktestHandler(cfg))
 
 		// 交易对和价格
 		protected.GET("/symbols", handlers.GinListSymbolsHandler(cfg))
@@ -98,6 +106,7 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		// 账户信息
 		protected.GET("/balance", handlers.GinBalanceHandler(cfg))
 		protected.GET("/trades", handlers.GinTradesHandler(cfg))
+		protected.GET("/pnl", handlers.GinPnLHandler(cfg))
 
 		// 提币历史
 		protected.GET("/withdrawalhistory", handlers.GinWithdrawalHistoryHandler(cfg))
@@ -116,6 +125,27 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 
 		// 永续期货路由
 		SetupFuturesRoutes(protected, cfg)
+
+		// 定投路由
+		SetupDCARoutes(protected, cfg)
+
+		// 风控路由
+		SetupRiskRoutes(protected, cfg)
+
+		// 通知路由
+		SetupNotificationRoutes(protected, cfg)
+
+		// 价格提醒路由
+		SetupAlertRoutes(protected, cfg)
+
+		// K线路由
+		SetupKlineRoutes(protected, cfg)
+
+		// 指标触发条件路由
+		SetupConditionRoutes(protected, cfg)
+
+		// 交易所账户路由
+		SetupExchangeAccountRoutes(protected, cfg)
 	}
 
 	// 管理员路由
@@ -129,6 +159,10 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		admin.PUT("/users/status", adminController.UpdateUserStatus)
 		admin.PUT("/users/role", adminController.UpdateUserRole)
 		admin.GET("/users/stats", adminController.GetUserStats)
+		admin.GET("/rate-limits", adminController.GetRateLimits)
+		admin.POST("/kill-switch", adminController.TriggerKillSwitch)
+		admin.GET("/kill-switch", adminController.GetKillSwitches)
+		admin.DELETE("/kill-switch/:id", adminController.ReleaseKillSwitch)
 	}
 
 	// 404 处理
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/controllers"
+	"github.com/ccj241/binance/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupStreamRoutes 配置实时推送路由，不能挂在受保护路由组下，组内认证只接受请求头
+func SetupStreamRoutes(router *gin.RouterGroup, cfg *config.Config) {
+	streamController := &controllers.StreamController{Config: cfg}
+
+	router.GET("/stream", middleware.StreamAuthMiddleware(cfg), streamController.Stream) // 价格、订单、持仓、策略和通知推送
+}
@@ -2,22 +2,300 @@ package services
 
 import (
 	"context"
+
 	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
 )
 
-type BinanceService struct {
-	Client *binance.Client
+// BinanceExchange 基于 go-binance 的真实交易所实现
+type BinanceExchange struct {
+	apiKey        string
+	secretKey     string
+	endpoints     Endpoints
+	Client        *binance.Client
+	FuturesClient *futures.Client
 }
 
-func NewBinanceService(apiKey, secretKey string) *BinanceService {
+// NewBinanceExchange 创建币安交易所实例，使用系统默认交易所环境，所有REST请求经过全局限流器
+func NewBinanceExchange(apiKey, secretKey string) *BinanceExchange {
 	client := binance.NewClient(apiKey, secretKey)
-	return &BinanceService{Client: client}
+	client.HTTPClient = NewRateLimitedClient(apiKey, 0)
+	futuresClient := binance.NewFuturesClient(apiKey, secretKey)
+	futuresClient.HTTPClient = NewRateLimitedClient(apiKey, 0)
+
+	exchange := &BinanceExchange{
+		apiKey:        apiKey,
+		secretKey:     secretKey,
+		Client:        client,
+		FuturesClient: futuresClient,
+	}
+	exchange.UseEndpoints(DefaultEndpoints())
+	return exchange
+}
+
+// UseEndpoints 将现货、合约和双币投资请求切换到指定环境
+func (e *BinanceExchange) UseEndpoints(endpoints Endpoints) {
+	e.endpoints = endpoints
+	e.Client.BaseURL = endpoints.SpotREST
+	e.FuturesClient.BaseURL = endpoints.FuturesREST
+}
+
+// StreamEndpoints 获取用户数据流使用的地址
+func (e *BinanceExchange) StreamEndpoints() Endpoints {
+	return e.endpoints
 }
 
-func (s *BinanceService) GetBalance() ([]*binance.Balance, error) {
-	account, err := s.Client.NewGetAccountService().Do(context.Background())
+// GetBalance 获取现货账户余额
+func (e *BinanceExchange) GetBalance(ctx context.Context) ([]binance.Balance, error) {
+	account, err := e.GetAccount(ctx)
 	if err != nil {
 		return nil, err
 	}
 	return account.Balances, nil
 }
+
+// ==================== 现货 ====================
+
+func (e *BinanceExchange) GetAccount(ctx context.Context) (*binance.Account, error) {
+	return e.Client.NewGetAccountService().Do(ctx)
+}
+
+func (e *BinanceExchange) ListPrices(ctx context.Context, symbol string) ([]*binance.SymbolPrice, error) {
+	service := e.Client.NewListPricesService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) GetDepth(ctx context.Context, symbol string, limit int) (*binance.DepthResponse, error) {
+	return e.Client.NewDepthService().Symbol(symbol).Limit(limit).Do(ctx)
+}
+
+// ListKlines 获取K线，startTime/endTime 为0时不限制
+func (e *BinanceExchange) ListKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*binance.Kline, error) {
+	service := e.Client.NewKlinesService().Symbol(symbol).Interval(interval)
+	if startTime > 0 {
+		service = service.StartTime(startTime)
+	}
+	if endTime > 0 {
+		service = service.EndTime(endTime)
+	}
+	if limit > 0 {
+		service = service.Limit(limit)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) GetExchangeInfo(ctx context.Context, symbol string) (*binance.ExchangeInfo, error) {
+	service := e.Client.NewExchangeInfoService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) CreateOrder(ctx context.Context, req SpotOrderRequest) (*binance.CreateOrderResponse, error) {
+	service := e.Client.NewCreateOrderService().
+		Symbol(req.Symbol).
+		Side(req.Side).
+		Type(req.Type).
+		Quantity(req.Quantity)
+	if req.TimeInForce != "" {
+		service = service.TimeInForce(req.TimeInForce)
+	}
+	if req.Price != "" {
+		service = service.Price(req.Price)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) GetOrder(ctx context.Context, symbol string, orderID int64) (*binance.Order, error) {
+	return e.Client.NewGetOrderService().Symbol(symbol).OrderID(orderID).Do(ctx)
+}
+
+func (e *BinanceExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	_, err := e.Client.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx)
+	return err
+}
+
+func (e *BinanceExchange) ListOpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error) {
+	service := e.Client.NewListOpenOrdersService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) ListTrades(ctx context.Context, symbol string, startTime, endTime int64, limit int) ([]*binance.TradeV3, error) {
+	service := e.Client.NewListTradesService().Symbol(symbol)
+	if startTime > 0 {
+		service = service.StartTime(startTime)
+	}
+	if endTime > 0 {
+		service = service.EndTime(endTime)
+	}
+	if limit > 0 {
+		service = service.Limit(limit)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) ListOrderTrades(ctx context.Context, symbol string, orderID int64) ([]*binance.TradeV3, error) {
+	return e.Client.NewListTradesService().Symbol(symbol).OrderId(orderID).Do(ctx)
+}
+
+// ==================== 提币 ====================
+
+func (e *BinanceExchange) Withdraw(ctx context.Context, req WithdrawRequest) (*binance.CreateWithdrawResponse, error) {
+	service := e.Client.NewCreateWithdrawService().
+		Coin(req.Coin).
+		Address(req.Address).
+		Amount(req.Amount)
+	if req.Network != "" {
+		service = service.Network(req.Network)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) ListWithdraws(ctx context.Context, startTime, endTime int64) ([]*binance.Withdraw, error) {
+	service := e.Client.NewListWithdrawsService()
+	if startTime > 0 {
+		service = service.StartTime(startTime)
+	}
+	if endTime > 0 {
+		service = service.EndTime(endTime)
+	}
+	return service.Do(ctx)
+}
+
+// ==================== 永续合约 ====================
+
+func (e *BinanceExchange) GetFuturesAccount(ctx context.Context) (*futures.Account, error) {
+	return e.FuturesClient.NewGetAccountService().Do(ctx)
+}
+
+func (e *BinanceExchange) GetFuturesExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	return e.FuturesClient.NewExchangeInfoService().Do(ctx)
+}
+
+func (e *BinanceExchange) GetFuturesDepth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	return e.FuturesClient.NewDepthService().Symbol(symbol).Limit(limit).Do(ctx)
+}
+
+// ListFuturesMarkPriceKlines 获取合约标记价格K线，与标记价格推送口径一致
+func (e *BinanceExchange) ListFuturesMarkPriceKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*futures.Kline, error) {
+	service := e.FuturesClient.NewMarkPriceKlinesService().Symbol(symbol).Interval(interval)
+	if startTime > 0 {
+		service = service.StartTime(startTime)
+	}
+	if endTime > 0 {
+		service = service.EndTime(endTime)
+	}
+	if limit > 0 {
+		service = service.Limit(limit)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) CreateFuturesOrder(ctx context.Context, req FuturesOrderRequest) (*futures.CreateOrderResponse, error) {
+	service := e.FuturesClient.NewCreateOrderService().
+		Symbol(req.Symbol).
+		Side(req.Side).
+		PositionSide(req.PositionSide).
+		Type(req.Type).
+		Quantity(req.Quantity)
+	if req.TimeInForce != "" {
+		service = service.TimeInForce(req.TimeInForce)
+	}
+	if req.Price != "" {
+		service = service.Price(req.Price)
+	}
+	if req.StopPrice != "" {
+		service = service.StopPrice(req.StopPrice)
+	}
+	if req.ActivationPrice != "" {
+		service = service.ActivationPrice(req.ActivationPrice)
+	}
+	if req.CallbackRate != "" {
+		service = service.CallbackRate(req.CallbackRate)
+	}
+	if req.ClientOrderID != "" {
+		service = service.NewClientOrderID(req.ClientOrderID)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) GetFuturesOrder(ctx context.Context, symbol string, orderID int64) (*futures.Order, error) {
+	return e.FuturesClient.NewGetOrderService().Symbol(symbol).OrderID(orderID).Do(ctx)
+}
+
+func (e *BinanceExchange) CancelFuturesOrder(ctx context.Context, symbol string, orderID int64) error {
+	_, err := e.FuturesClient.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx)
+	return err
+}
+
+func (e *BinanceExchange) ListFuturesOpenOrders(ctx context.Context, symbol string) ([]*futures.Order, error) {
+	service := e.FuturesClient.NewListOpenOrdersService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) ListFuturesOrders(ctx context.Context, symbol string, startTime int64) ([]*futures.Order, error) {
+	service := e.FuturesClient.NewListOrdersService().Symbol(symbol)
+	if startTime > 0 {
+		service = service.StartTime(startTime)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error) {
+	service := e.FuturesClient.NewGetPositionRiskService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+	return service.Do(ctx)
+}
+
+func (e *BinanceExchange) ChangeLeverage(ctx context.Context, symbol string, leverage int) error {
+	_, err := e.FuturesClient.NewChangeLeverageService().
+		Symbol(symbol).
+		Leverage(leverage).
+		Do(ctx)
+	return err
+}
+
+func (e *BinanceExchange) ChangeMarginType(ctx context.Context, symbol string, marginType string) error {
+	return e.FuturesClient.NewChangeMarginTypeService().
+		Symbol(symbol).
+		MarginType(futures.MarginType(marginType)).
+		Do(ctx)
+}
+
+// ==================== 用户数据流 ====================
+
+func (e *BinanceExchange) StartUserStream(ctx context.Context) (string, error) {
+	return e.Client.NewStartUserStreamService().Do(ctx)
+}
+
+func (e *BinanceExchange) KeepaliveUserStream(ctx context.Context, listenKey string) error {
+	return e.Client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx)
+}
+
+func (e *BinanceExchange) CloseUserStream(ctx context.Context, listenKey string) error {
+	return e.Client.NewCloseUserStreamService().ListenKey(listenKey).Do(ctx)
+}
+
+func (e *BinanceExchange) StartFuturesUserStream(ctx context.Context) (string, error) {
+	return e.FuturesClient.NewStartUserStreamService().Do(ctx)
+}
+
+func (e *BinanceExchange) KeepaliveFuturesUserStream(ctx context.Context, listenKey string) error {
+	return e.FuturesClient.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx)
+}
+
+func (e *BinanceExchange) CloseFuturesUserStream(ctx context.Context, listenKey string) error {
+	return e.FuturesClient.NewCloseUserStreamService().ListenKey(listenKey).Do(ctx)
+}
@@ -0,0 +1,742 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 条件语法示例：
+//
+//	RSI(14) ON 15m < 30 AND PRICE < SMA(50)
+//	EMA(12) ON 1h CROSSES ABOVE EMA(26) ON 1h
+//	NOT (PRICE > BB_UPPER(20, 2) ON 4h) OR RSI(6) >= 80
+//
+// 指标：RSI(n)、SMA(n)、EMA(n)、BB_UPPER(n[,k])、BB_MIDDLE(n[,k])、BB_LOWER(n[,k])，
+// 未写 ON 时使用 DefaultConditionTimeframe；PRICE 为最新成交价（合约为标记价格）。
+// 比较运算符：< <= > >= 以及 CROSSES ABOVE / CROSSES BELOW（与上一根K线相比发生穿越）。
+// 逻辑运算符：AND、OR、NOT 和括号，关键字不区分大小写。
+
+const (
+	// DefaultConditionTimeframe 指标未指定周期时使用的K线周期
+	DefaultConditionTimeframe = "1h"
+	// MaxConditionLength 条件表达式最大长度
+	MaxConditionLength = 500
+	// MaxIndicatorPeriod 指标周期上限
+	MaxIndicatorPeriod = 500
+)
+
+// ConditionTimeframes 条件支持的K线周期
+var ConditionTimeframes = map[string]time.Duration{
+	"1m":  time.Minute,
+	"3m":  3 * time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"2h":  2 * time.Hour,
+	"4h":  4 * time.Hour,
+	"6h":  6 * time.Hour,
+	"12h": 12 * time.Hour,
+	"1d":  24 * time.Hour,
+}
+
+// ConditionBaseInterval 周期对应的存储K线周期，其余周期由存储K线合成
+func ConditionBaseInterval(timeframe string) string {
+	switch timeframe {
+	case "1m", "3m":
+		return "1m"
+	case "5m", "15m", "30m":
+		return "5m"
+	case "1d":
+		return "1d"
+	}
+	return "1h"
+}
+
+// CloseSource 返回指定周期至多 bars 根K线的收盘价，按时间升序，最后一根为当前未收盘K线
+type CloseSource func(timeframe string, bars int) ([]float64, error)
+
+// Condition 解析后的触发条件
+type Condition struct {
+	expr string
+	root conditionNode
+}
+
+// ConditionTerm 单个比较项的求值结果
+type ConditionTerm struct {
+	Expr   string  `json:"expr"`
+	Left   float64 `json:"left"`
+	Right  float64 `json:"right"`
+	Result bool    `json:"result"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// ConditionResult 条件求值结果
+type ConditionResult struct {
+	Result bool            `json:"result"`
+	Terms  []ConditionTerm `json:"terms"`
+}
+
+// ParseCondition 解析触发条件表达式
+func ParseCondition(expr string) (*Condition, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("条件不能为空")
+	}
+	if len(expr) > MaxConditionLength {
+		return nil, fmt.Errorf("条件长度不能超过 %d 个字符", MaxConditionLength)
+	}
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("条件在 %q 附近有多余内容", p.peek().text)
+	}
+	return &Condition{expr: expr, root: root}, nil
+}
+
+// String 返回原始表达式
+func (c *Condition) String() string {
+	return c.expr
+}
+
+// Timeframes 条件用到的K线周期
+func (c *Condition) Timeframes() []string {
+	seen := make(map[string]bool)
+	var result []string
+	c.root.timeframes(func(tf string) {
+		if !seen[tf] {
+			seen[tf] = true
+			result = append(result, tf)
+		}
+	})
+	return result
+}
+
+// Evaluate 以最新价格和K线收盘价求值，数据不足的比较项视为不满足
+func (c *Condition) Evaluate(price float64, source CloseSource) ConditionResult {
+	ctx := &conditionContext{price: price, source: source, closes: make(map[string][]float64), errs: make(map[string]error)}
+	result := c.root.eval(ctx)
+	return ConditionResult{Result: result, Terms: ctx.terms}
+}
+
+// ==================== 词法分析 ====================
+
+type conditionToken struct {
+	kind string // num/ident/op/lparen/rparen/comma
+	text string
+}
+
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, conditionToken{"lparen", "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, conditionToken{"rparen", ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, conditionToken{"comma", ","})
+			i++
+		case r == '<' || r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, conditionToken{"op", string(r) + "="})
+				i += 2
+			} else {
+				tokens = append(tokens, conditionToken{"op", string(r)})
+				i++
+			}
+		case unicode.IsDigit(r) || r == '.' || r == '-':
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// 数字后紧跟字母的是周期，如 15m、4h
+			if i < len(runes) && unicode.IsLetter(runes[i]) {
+				for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+					i++
+				}
+				tokens = append(tokens, conditionToken{"ident", string(runes[start:i])})
+				continue
+			}
+			tokens = append(tokens, conditionToken{"num", string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, conditionToken{"ident", string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("条件第 %d 个字符 %q 无效", i+1, string(r))
+		}
+	}
+	return tokens, nil
+}
+
+// ==================== 语法分析 ====================
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *conditionParser) peek() conditionToken {
+	if p.done() {
+		return conditionToken{kind: "eof", text: "结尾"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() conditionToken {
+	t := p.peek()
+	if !p.done() {
+		p.pos++
+	}
+	return t
+}
+
+// keyword 下一个词是否为指定关键字，是则消费
+func (p *conditionParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == "ident" && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) expect(kind, desc string) (conditionToken, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("条件在 %q 处应为%s", t.text, desc)
+	}
+	return t, nil
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner: inner}, nil
+	}
+	if p.peek().kind == "lparen" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("rparen", "右括号"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	var op string
+	switch t := p.peek(); {
+	case t.kind == "op":
+		op = p.next().text
+	case p.keyword("CROSSES"):
+		if p.keyword("ABOVE") {
+			op = "CROSSES ABOVE"
+		} else if p.keyword("BELOW") {
+			op = "CROSSES BELOW"
+		} else {
+			return nil, fmt.Errorf("CROSSES 后应为 ABOVE 或 BELOW")
+		}
+	default:
+		return nil, fmt.Errorf("条件在 %q 处应为比较运算符", t.text)
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// PRICE 的上一根K线取另一侧指标周期的收盘价
+	if lp, ok := left.(*priceOperand); ok {
+		if ind, ok := right.(*indicatorOperand); ok {
+			lp.timeframe = ind.timeframe
+		}
+	}
+	if rp, ok := right.(*priceOperand); ok {
+		if ind, ok := left.(*indicatorOperand); ok {
+			rp.timeframe = ind.timeframe
+		}
+	}
+	if strings.HasPrefix(op, "CROSSES") {
+		_, leftConst := left.(*numberOperand)
+		_, rightConst := right.(*numberOperand)
+		if leftConst && rightConst {
+			return nil, fmt.Errorf("CROSSES 两侧不能都是常数")
+		}
+	}
+	return &compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *conditionParser) parseOperand() (conditionOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case "num":
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的数字 %q", t.text)
+		}
+		return &numberOperand{number: v}, nil
+	case "ident":
+		name := strings.ToUpper(t.text)
+		if name == "PRICE" {
+			return &priceOperand{timeframe: DefaultConditionTimeframe}, nil
+		}
+		if _, ok := indicatorArgs[name]; !ok {
+			return nil, fmt.Errorf("未知的指标 %q，支持 RSI、SMA、EMA、BB_UPPER、BB_MIDDLE、BB_LOWER 和 PRICE", t.text)
+		}
+		return p.parseIndicator(name)
+	}
+	return nil, fmt.Errorf("条件在 %q 处应为数字、PRICE 或指标", t.text)
+}
+
+// indicatorArgs 指标的参数个数范围
+var indicatorArgs = map[string][2]int{
+	"RSI":       {1, 1},
+	"SMA":       {1, 1},
+	"EMA":       {1, 1},
+	"BB_UPPER":  {1, 2},
+	"BB_MIDDLE": {1, 2},
+	"BB_LOWER":  {1, 2},
+}
+
+func (p *conditionParser) parseIndicator(name string) (conditionOperand, error) {
+	if _, err := p.expect("lparen", name+" 后的左括号"); err != nil {
+		return nil, err
+	}
+	var args []float64
+	for {
+		t, err := p.expect("num", name+" 的参数")
+		if err != nil {
+			return nil, err
+		}
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s 的参数 %q 无效", name, t.text)
+		}
+		args = append(args, v)
+		if p.peek().kind != "comma" {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect("rparen", name+" 的右括号"); err != nil {
+		return nil, err
+	}
+
+	limits := indicatorArgs[name]
+	if len(args) < limits[0] || len(args) > limits[1] {
+		return nil, fmt.Errorf("%s 的参数个数无效", name)
+	}
+	period := int(args[0])
+	if float64(period) != args[0] || period < 1 || period > MaxIndicatorPeriod {
+		return nil, fmt.Errorf("%s 的周期必须是 1 到 %d 之间的整数", name, MaxIndicatorPeriod)
+	}
+	if name == "RSI" && period < 2 {
+		return nil, fmt.Errorf("RSI 的周期至少为 2")
+	}
+	width := 2.0
+	if len(args) > 1 {
+		width = args[1]
+		if width <= 0 || width > 10 {
+			return nil, fmt.Errorf("%s 的标准差倍数必须在 0 到 10 之间", name)
+		}
+	}
+
+	timeframe := DefaultConditionTimeframe
+	if p.keyword("ON") {
+		t, err := p.expect("ident", "K线周期")
+		if err != nil {
+			return nil, err
+		}
+		timeframe = strings.ToLower(t.text)
+		if _, ok := ConditionTimeframes[timeframe]; !ok {
+			return nil, fmt.Errorf("不支持的K线周期 %q", t.text)
+		}
+	}
+	return &indicatorOperand{name: name, period: period, width: width, timeframe: timeframe}, nil
+}
+
+// ==================== 求值 ====================
+
+type conditionContext struct {
+	price  float64
+	source CloseSource
+	closes map[string][]float64
+	errs   map[string]error
+	terms  []ConditionTerm
+}
+
+// series 获取周期的收盘价，同一次求值内复用
+func (ctx *conditionContext) series(timeframe string, bars int) ([]float64, error) {
+	key := fmt.Sprintf("%s|%d", timeframe, bars)
+	if closes, ok := ctx.closes[key]; ok {
+		return closes, ctx.errs[key]
+	}
+	closes, err := ctx.source(timeframe, bars)
+	ctx.closes[key] = closes
+	ctx.errs[key] = err
+	return closes, err
+}
+
+type conditionNode interface {
+	eval(ctx *conditionContext) bool
+	timeframes(add func(string))
+}
+
+type logicNode struct {
+	op          string
+	left, right conditionNode
+}
+
+// eval 两侧都求值，便于试运行展示全部比较项
+func (n *logicNode) eval(ctx *conditionContext) bool {
+	left := n.left.eval(ctx)
+	right := n.right.eval(ctx)
+	if n.op == "AND" {
+		return left && right
+	}
+	return left || right
+}
+
+func (n *logicNode) timeframes(add func(string)) {
+	n.left.timeframes(add)
+	n.right.timeframes(add)
+}
+
+type notNode struct {
+	inner conditionNode
+}
+
+func (n *notNode) eval(ctx *conditionContext) bool {
+	return !n.inner.eval(ctx)
+}
+
+func (n *notNode) timeframes(add func(string)) {
+	n.inner.timeframes(add)
+}
+
+type compareNode struct {
+	op          string
+	left, right conditionOperand
+}
+
+func (n *compareNode) eval(ctx *conditionContext) bool {
+	term := ConditionTerm{Expr: n.left.String() + " " + n.op + " " + n.right.String()}
+	result, err := n.compare(ctx, &term)
+	if err != nil {
+		term.Error = err.Error()
+		result = false
+	}
+	term.Result = result
+	ctx.terms = append(ctx.terms, term)
+	return result
+}
+
+func (n *compareNode) compare(ctx *conditionContext, term *ConditionTerm) (bool, error) {
+	left, err := n.left.value(ctx, 0)
+	if err != nil {
+		return false, err
+	}
+	right, err := n.right.value(ctx, 0)
+	if err != nil {
+		return false, err
+	}
+	term.Left, term.Right = left, right
+
+	switch n.op {
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	}
+
+	prevLeft, err := n.left.value(ctx, 1)
+	if err != nil {
+		return false, err
+	}
+	prevRight, err := n.right.value(ctx, 1)
+	if err != nil {
+		return false, err
+	}
+	if n.op == "CROSSES ABOVE" {
+		return prevLeft <= prevRight && left > right, nil
+	}
+	return prevLeft >= prevRight && left < right, nil
+}
+
+func (n *compareNode) timeframes(add func(string)) {
+	n.left.timeframes(add)
+	n.right.timeframes(add)
+}
+
+// conditionOperand 比较项的一侧，offset 为0表示当前K线，1表示上一根
+type conditionOperand interface {
+	value(ctx *conditionContext, offset int) (float64, error)
+	timeframes(add func(string))
+	String() string
+}
+
+type numberOperand struct {
+	number float64
+}
+
+type priceOperand struct {
+	timeframe string
+}
+
+type indicatorOperand struct {
+	name      string
+	period    int
+	width     float64
+	timeframe string
+}
+
+func (o *numberOperand) value(ctx *conditionContext, offset int) (float64, error) {
+	return o.number, nil
+}
+
+func (o *numberOperand) timeframes(add func(string)) {}
+
+func (o *numberOperand) String() string {
+	return strconv.FormatFloat(o.number, 'f', -1, 64)
+}
+
+func (o *priceOperand) value(ctx *conditionContext, offset int) (float64, error) {
+	if offset == 0 {
+		if ctx.price <= 0 {
+			return 0, fmt.Errorf("暂无最新价格")
+		}
+		return ctx.price, nil
+	}
+	closes, err := ctx.series(o.timeframe, offset+1)
+	if err != nil {
+		return 0, err
+	}
+	if len(closes) < offset+1 {
+		return 0, fmt.Errorf("%s K线数据不足", o.timeframe)
+	}
+	return closes[len(closes)-1-offset], nil
+}
+
+func (o *priceOperand) timeframes(add func(string)) {
+	add(o.timeframe)
+}
+
+func (o *priceOperand) String() string {
+	return "PRICE"
+}
+
+// lookback 计算指标需要的K线数，EMA 和 RSI 多取数据让平滑结果收敛
+func (o *indicatorOperand) lookback() int {
+	switch o.name {
+	case "EMA", "RSI":
+		n := o.period*4 + 1
+		if n > MaxIndicatorPeriod*2 {
+			n = MaxIndicatorPeriod * 2
+		}
+		return n
+	}
+	return o.period
+}
+
+func (o *indicatorOperand) value(ctx *conditionContext, offset int) (float64, error) {
+	closes, err := ctx.series(o.timeframe, o.lookback()+1)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		if len(closes) <= offset {
+			return 0, fmt.Errorf("%s K线数据不足", o.timeframe)
+		}
+		closes = closes[:len(closes)-offset]
+	}
+	// 当前价格作为当前K线的收盘价
+	if offset == 0 && ctx.price > 0 && len(closes) > 0 {
+		live := make([]float64, len(closes))
+		copy(live, closes)
+		live[len(live)-1] = ctx.price
+		closes = live
+	}
+
+	var v float64
+	var ok bool
+	switch o.name {
+	case "SMA", "BB_MIDDLE":
+		v, ok = SMA(closes, o.period)
+	case "EMA":
+		v, ok = EMA(closes, o.period)
+	case "RSI":
+		v, ok = RSI(closes, o.period)
+	case "BB_UPPER", "BB_LOWER":
+		var mid, dev float64
+		mid, dev, ok = Bollinger(closes, o.period)
+		if o.name == "BB_UPPER" {
+			v = mid + o.width*dev
+		} else {
+			v = mid - o.width*dev
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("%s K线数据不足，%s 需要至少 %d 根", o.timeframe, o.String(), o.period+1)
+	}
+	return v, nil
+}
+
+func (o *indicatorOperand) timeframes(add func(string)) {
+	add(o.timeframe)
+}
+
+func (o *indicatorOperand) String() string {
+	args := strconv.Itoa(o.period)
+	if strings.HasPrefix(o.name, "BB_") {
+		args += ", " + strconv.FormatFloat(o.width, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s(%s) ON %s", o.name, args, o.timeframe)
+}
+
+// ==================== 指标计算 ====================
+
+// SMA 最后 period 个收盘价的简单移动平均
+func SMA(closes []float64, period int) (float64, bool) {
+	if period <= 0 || len(closes) < period {
+		return 0, false
+	}
+	var sum float64
+	for _, c := range closes[len(closes)-period:] {
+		sum += c
+	}
+	return sum / float64(period), true
+}
+
+// EMA 指数移动平均，以前 period 个收盘价的简单平均作为初值
+func EMA(closes []float64, period int) (float64, bool) {
+	if period <= 0 || len(closes) < period {
+		return 0, false
+	}
+	ema, _ := SMA(closes[:period], period)
+	k := 2 / float64(period+1)
+	for _, c := range closes[period:] {
+		ema = c*k + ema*(1-k)
+	}
+	return ema, true
+}
+
+// RSI 相对强弱指标，使用 Wilder 平滑
+func RSI(closes []float64, period int) (float64, bool) {
+	if period <= 0 || len(closes) < period+1 {
+		return 0, false
+	}
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		change := closes[i] - closes[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	gain /= float64(period)
+	loss /= float64(period)
+	for i := period + 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		up, down := 0.0, 0.0
+		if change > 0 {
+			up = change
+		} else {
+			down = -change
+		}
+		gain = (gain*float64(period-1) + up) / float64(period)
+		loss = (loss*float64(period-1) + down) / float64(period)
+	}
+	if loss == 0 {
+		if gain == 0 {
+			return 50, true
+		}
+		return 100, true
+	}
+	return 100 - 100/(1+gain/loss), true
+}
+
+// Bollinger 布林带中轨和标准差（总体标准差）
+func Bollinger(closes []float64, period int) (float64, float64, bool) {
+	mid, ok := SMA(closes, period)
+	if !ok {
+		return 0, 0, false
+	}
+	var variance float64
+	for _, c := range closes[len(closes)-period:] {
+		variance += (c - mid) * (c - mid)
+	}
+	return mid, math.Sqrt(variance / float64(period)), true
+}
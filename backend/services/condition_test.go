@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// staticCloses 按周期返回固定收盘价序列的最后 bars 根
+func staticCloses(series map[string][]float64) CloseSource {
+	return func(timeframe string, bars int) ([]float64, error) {
+		closes, ok := series[timeframe]
+		if !ok {
+			return nil, fmt.Errorf("没有 %s K线", timeframe)
+		}
+		if len(closes) > bars {
+			closes = closes[len(closes)-bars:]
+		}
+		return closes, nil
+	}
+}
+
+func TestParseConditionValid(t *testing.T) {
+	cases := []struct {
+		expr       string
+		timeframes []string
+	}{
+		{"RSI(14) ON 15m < 30 AND PRICE < SMA(50)", []string{"15m", "1h"}},
+		{"EMA(12) ON 1h CROSSES ABOVE EMA(26) ON 1h", []string{"1h"}},
+		{"NOT (PRICE > BB_UPPER(20, 2) ON 4h) OR RSI(6) >= 80", []string{"4h", "1h"}},
+		{"rsi(14) on 15M <= 30", []string{"15m"}},
+		{"PRICE CROSSES BELOW 100", []string{"1h"}},
+	}
+	for _, c := range cases {
+		cond, err := ParseCondition(c.expr)
+		if err != nil {
+			t.Errorf("ParseCondition(%q): %v", c.expr, err)
+			continue
+		}
+		if cond.String() != c.expr {
+			t.Errorf("String() = %q, want %q", cond.String(), c.expr)
+		}
+		if got := cond.Timeframes(); !reflect.DeepEqual(got, c.timeframes) {
+			t.Errorf("Timeframes(%q) = %v, want %v", c.expr, got, c.timeframes)
+		}
+	}
+}
+
+func TestParseConditionInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"RSI(14) <",
+		"FOO(3) > 1",
+		"RSI(1) < 30",
+		"SMA(0) > 1",
+		"SMA(2.5) > 1",
+		"SMA(14) ON 7m > 1",
+		"BB_UPPER(20, 11) > PRICE",
+		"SMA(14, 2) > 1",
+		"1 CROSSES ABOVE 2",
+		"PRICE CROSSES 100",
+		"PRICE > 100 PRICE",
+		"(PRICE > 100",
+		"PRICE > 100 AND",
+		"PRICE > " + strings.Repeat("1", MaxConditionLength),
+	}
+	for _, expr := range cases {
+		if _, err := ParseCondition(expr); err == nil {
+			t.Errorf("ParseCondition(%q) 应返回错误", expr)
+		}
+	}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	source := staticCloses(map[string][]float64{"1h": {1, 2, 3, 4}})
+
+	// 当前价格替换最后一根K线的收盘价：SMA(3) = (2+3+10)/3 = 5
+	cond, err := ParseCondition("PRICE > SMA(3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := cond.Evaluate(10, source)
+	if !result.Result || len(result.Terms) != 1 {
+		t.Fatalf("Evaluate = %+v", result)
+	}
+	if term := result.Terms[0]; term.Left != 10 || term.Right != 5 || term.Expr != "PRICE > SMA(3) ON 1h" {
+		t.Errorf("比较项 = %+v", term)
+	}
+
+	// 逻辑运算两侧都会求值，试运行时展示全部比较项
+	cond, _ = ParseCondition("NOT (PRICE > 100) OR PRICE > 1000")
+	result = cond.Evaluate(50, source)
+	if !result.Result || len(result.Terms) != 2 {
+		t.Errorf("NOT/OR 求值 = %+v", result)
+	}
+	cond, _ = ParseCondition("PRICE > 1 AND PRICE < 2")
+	if cond.Evaluate(5, source).Result {
+		t.Error("AND 一侧不满足时应为 false")
+	}
+}
+
+func TestConditionCrosses(t *testing.T) {
+	// 上一根K线收盘10等于 SMA(3)=10，当前价格12高于 SMA(3)=(10+10+12)/3
+	source := staticCloses(map[string][]float64{"1h": {10, 10, 10, 9}})
+	cond, err := ParseCondition("PRICE CROSSES ABOVE SMA(3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cond.Evaluate(12, source).Result {
+		t.Error("价格上穿 SMA 应满足条件")
+	}
+	if cond.Evaluate(10, source).Result {
+		t.Error("价格没有高于 SMA 时不应满足条件")
+	}
+
+	below, _ := ParseCondition("PRICE CROSSES BELOW SMA(3)")
+	if below.Evaluate(12, source).Result {
+		t.Error("上穿时不应满足 CROSSES BELOW")
+	}
+}
+
+func TestConditionInsufficientData(t *testing.T) {
+	cond, _ := ParseCondition("SMA(5) > 1 OR RSI(14) ON 4h < 30")
+	result := cond.Evaluate(10, staticCloses(map[string][]float64{"1h": {1, 2}}))
+	if result.Result {
+		t.Error("数据不足的比较项应视为不满足")
+	}
+	for _, term := range result.Terms {
+		if term.Error == "" {
+			t.Errorf("比较项 %q 应记录错误", term.Expr)
+		}
+	}
+
+	cond, _ = ParseCondition("PRICE > 1")
+	if result := cond.Evaluate(0, staticCloses(nil)); result.Result || result.Terms[0].Error == "" {
+		t.Errorf("没有最新价格时 = %+v", result)
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	closes := []float64{1, 2, 3, 4, 5}
+	if v, ok := SMA(closes, 3); !ok || v != 4 {
+		t.Errorf("SMA = %v, %v", v, ok)
+	}
+	if _, ok := SMA(closes, 6); ok {
+		t.Error("数据不足时 SMA 应返回 false")
+	}
+	if v, ok := EMA([]float64{7, 7, 7, 7, 7}, 3); !ok || v != 7 {
+		t.Errorf("常数序列 EMA = %v, %v", v, ok)
+	}
+	// EMA(2)：初值 (1+2)/2=1.5，k=2/3，依次 2.5、3.5、4.5
+	if v, ok := EMA(closes, 2); !ok || math.Abs(v-4.5) > 1e-9 {
+		t.Errorf("EMA = %v, %v", v, ok)
+	}
+	if v, ok := RSI(closes, 3); !ok || v != 100 {
+		t.Errorf("持续上涨 RSI = %v, %v", v, ok)
+	}
+	if v, ok := RSI([]float64{5, 5, 5, 5}, 3); !ok || v != 50 {
+		t.Errorf("无波动 RSI = %v, %v", v, ok)
+	}
+	if v, ok := RSI([]float64{1, 2, 1, 2, 1}, 2); !ok || v <= 0 || v >= 100 {
+		t.Errorf("震荡 RSI = %v, %v", v, ok)
+	}
+	if _, ok := RSI(closes, 5); ok {
+		t.Error("RSI 需要 period+1 根K线")
+	}
+	mid, dev, ok := Bollinger([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 8)
+	if !ok || mid != 5 || dev != 2 {
+		t.Errorf("Bollinger = %v, %v, %v", mid, dev, ok)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 双币投资API响应结构体
+type DCIProductListResponse struct {
+	Total int                  `json:"total"`
+	List  []DCIProductListItem `json:"list"` // 币安实际返回的是list
+}
+
+type DCIProductListItem struct {
+	Id              string `json:"id"`
+	OrderId         int64  `json:"orderId"` // 添加orderId字段
+	Symbol          string `json:"symbol"`
+	Direction       string `json:"direction"` // UP/DOWN
+	StrikePrice     string `json:"strikePrice"`
+	Duration        int    `json:"duration"`
+	Apy             string `json:"apy"`
+	MinAmount       string `json:"minAmount"`
+	MaxAmount       string `json:"maxAmount"`
+	DeliveryDate    int64  `json:"deliveryDate"`
+	ProductStatus   string `json:"productStatus"`
+	PurchaseEndTime int64  `json:"purchaseEndTime"`
+	BaseAsset       string `json:"baseAsset"`
+	QuoteAsset      string `json:"quoteAsset"`
+	InvestAsset     string `json:"investAsset"`
+	InvestCoin      string `json:"investCoin"`    // 币安返回的字段名
+	ExercisedCoin   string `json:"exercisedCoin"` // 币安返回的字段名
+
+	// 添加更多可能的字段名
+	AnnualizedYield string `json:"annualizedYield"` // 可能的年化收益率字段
+	Yield           string `json:"yield"`           // 可能的收益率字段
+	SettleDate      int64  `json:"settleDate"`      // 可能的结算日期字段
+	ExpiryDate      int64  `json:"expiryDate"`      // 可能的到期日期字段
+	Status          string `json:"status"`          // 可能的状态字段
+
+	// 币安实际返回的字段名
+	APR                  string   `json:"apr"`                  // 年化收益率（币安使用apr而不是apy）
+	CanPurchase          bool     `json:"canPurchase"`          // 是否可购买
+	CreateTimestamp      int64    `json:"createTimestamp"`      // 创建时间戳
+	IsAutoCompoundEnable bool     `json:"isAutoCompoundEnable"` // 是否支持自动复投
+	OptionType           string   `json:"optionType"`           // 期权类型 PUT/CALL
+	PurchaseDecimal      int      `json:"purchaseDecimal"`      // 购买精度
+	AutoCompoundPlanList []string `json:"autoCompoundPlanList"` // 自动复投计划列表
+}
+
+type DCISubscribeResponse struct {
+	PositionId   string `json:"positionId"`
+	PurchaseTime int64  `json:"purchaseTime"`
+}
+
+type DCIPositionResponse struct {
+	Total int               `json:"total"`
+	Rows  []DCIPositionItem `json:"rows"`
+}
+
+type DCIPositionItem struct {
+	Id           string `json:"id"`
+	PositionId   string `json:"positionId"`
+	ProductId    string `json:"productId"`
+	Symbol       string `json:"symbol"`
+	Direction    string `json:"direction"`
+	StrikePrice  string `json:"strikePrice"`
+	Duration     int    `json:"duration"`
+	Apy          string `json:"apy"`
+	InvestAmount string `json:"investAmount"`
+	InvestAsset  string `json:"investAsset"`
+	PurchaseTime int64  `json:"purchaseTime"`
+	DeliveryDate int64  `json:"deliveryDate"`
+	Status       string `json:"status"` // PENDING/ACTIVE/SETTLED
+	SettleAsset  string `json:"settleAsset"`
+	SettleAmount string `json:"settleAmount"`
+	ProfitAmount string `json:"profitAmount"`
+	ProfitAsset  string `json:"profitAsset"`
+}
+
+// BinanceAPIError 币安API错误响应
+type BinanceAPIError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// ListDCIProducts 获取指定期权类型的双币投资产品
+func (e *BinanceExchange) ListDCIProducts(ctx context.Context, optionType, investCoin, exercisedCoin string) ([]DCIProductListItem, error) {
+	params := map[string]interface{}{
+		"optionType":    optionType,
+		"investCoin":    investCoin,
+		"exercisedCoin": exercisedCoin,
+		"pageSize":      100,
+		"pageIndex":     1,
+	}
+
+	res, err := e.dciRequest(ctx, "GET", "/sapi/v1/dci/product/list", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response DCIProductListResponse
+	if err := json.Unmarshal(res, &response); err != nil {
+		return nil, fmt.Errorf("解析产品列表失败: %v", err)
+	}
+	return response.List, nil
+}
+
+// SubscribeDCIProduct 申购双币投资产品
+func (e *BinanceExchange) SubscribeDCIProduct(ctx context.Context, req DCISubscribeRequest) (map[string]interface{}, error) {
+	autoCompoundPlan := req.AutoCompoundPlan
+	if autoCompoundPlan == "" {
+		autoCompoundPlan = "NONE"
+	}
+
+	// 根据官方文档构造参数
+	params := map[string]interface{}{
+		"id":               req.ID,
+		"orderId":          req.OrderID,
+		"depositAmount":    req.DepositAmount.String(),
+		"autoCompoundPlan": autoCompoundPlan,
+		"recvWindow":       5000,
+	}
+
+	res, err := e.dciRequest(ctx, "POST", "/sapi/v1/dci/product/subscribe", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribeResp map[string]interface{}
+	if err := json.Unmarshal(res, &subscribeResp); err != nil {
+		return nil, fmt.Errorf("解析下单响应失败: %v", err)
+	}
+	return subscribeResp, nil
+}
+
+// GetDCIPositions 获取双币投资持仓
+func (e *BinanceExchange) GetDCIPositions(ctx context.Context) ([]DCIPositionItem, error) {
+	params := map[string]interface{}{
+		"pageSize":  100,
+		"pageIndex": 1,
+	}
+
+	res, err := e.dciRequest(ctx, "GET", "/sapi/v1/dci/product/positions", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response DCIPositionResponse
+	if err := json.Unmarshal(res, &response); err != nil {
+		return nil, fmt.Errorf("解析持仓响应失败: %v", err)
+	}
+
+	return response.Rows, nil
+}
+
+// dciRequest 用于处理双币投资API请求的辅助函数
+func (e *BinanceExchange) dciRequest(ctx context.Context, method, endpoint string, params map[string]interface{}) ([]byte, error) {
+	baseURL := e.endpoints.SpotREST
+
+	// 添加时间戳
+	params["timestamp"] = fmt.Sprintf("%d", time.Now().UnixMilli())
+
+	// 构建查询字符串
+	query := buildQueryString(params)
+
+	// 生成签名
+	signature := sign(query, e.secretKey)
+	query += "&signature=" + signature
+
+	// 构建完整URL
+	fullURL := baseURL + endpoint
+	if method == "GET" {
+		fullURL += "?" + query
+	}
+
+	// 创建请求
+	var req *http.Request
+	var err error
+
+	if method == "GET" {
+		req, err = http.NewRequestWithContext(ctx, method, fullURL, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, fullURL, strings.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+
+	// 设置请求头
+	req.Header.Set("X-MBX-APIKEY", e.apiKey)
+
+	// 发送请求
+	client := NewRateLimitedClient(e.apiKey, 10*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 读取响应
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	// 检查HTTP状态码
+	if resp.StatusCode != http.StatusOK {
+		var apiErr BinanceAPIError
+		if err := json.Unmarshal(body, &apiErr); err == nil {
+			return nil, fmt.Errorf("API错误 [%d]: %s", apiErr.Code, apiErr.Msg)
+		}
+		return nil, fmt.Errorf("HTTP错误 %d: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// buildQueryString 构建查询字符串
+func buildQueryString(params map[string]interface{}) string {
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		v := params[k]
+		parts = append(parts, fmt.Sprintf("%s=%v", k, url.QueryEscape(fmt.Sprintf("%v", v))))
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// sign 生成签名
+func sign(message, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
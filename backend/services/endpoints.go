@@ -0,0 +1,154 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ccj241/binance/models"
+)
+
+// Endpoints 一个交易所环境下的REST和WebSocket地址
+type Endpoints struct {
+	Environment string
+	SpotREST    string // 现货和 sapi 接口，如 https://api.binance.com
+	FuturesREST string // U本位合约接口，如 https://fapi.binance.com
+	SpotWS      string // 现货数据流，如 wss://stream.binance.com:9443/ws
+	FuturesWS   string // 合约数据流，如 wss://fstream.binance.com/ws
+}
+
+var productionEndpoints = Endpoints{
+	Environment: models.AccountEnvProduction,
+	SpotREST:    "https://api.binance.com",
+	FuturesREST: "https://fapi.binance.com",
+	SpotWS:      "wss://stream.binance.com:9443/ws",
+	FuturesWS:   "wss://fstream.binance.com/ws",
+}
+
+// 币安现货测试网和合约测试网是两套独立的系统，API密钥互不通用；
+// 测试网账户的另一个市场也指向测试网，保证测试账户不会请求到正式环境
+var testnetEndpoints = Endpoints{
+	SpotREST:    "https://testnet.binance.vision",
+	FuturesREST: "https://testnet.binancefuture.com",
+	SpotWS:      "wss://stream.testnet.binance.vision/ws",
+	FuturesWS:   "wss://stream.binancefuture.com/ws",
+}
+
+var (
+	endpointsMu      sync.RWMutex
+	defaultEndpoints = productionEndpoints
+)
+
+// ResolveEndpoints 根据环境名称解析地址，custom 环境使用 baseURL 作为现货和合约的共同地址
+func ResolveEndpoints(environment, baseURL string) (Endpoints, error) {
+	switch environment {
+	case models.AccountEnvProduction:
+		return productionEndpoints, nil
+	case models.AccountEnvSpotTestnet, models.AccountEnvFuturesTestnet:
+		endpoints := testnetEndpoints
+		endpoints.Environment = environment
+		return endpoints, nil
+	case models.AccountEnvCustom:
+		restURL, wsURL, err := parseCustomBaseURL(baseURL)
+		if err != nil {
+			return Endpoints{}, err
+		}
+		return Endpoints{
+			Environment: environment,
+			SpotREST:    restURL,
+			FuturesREST: restURL,
+			SpotWS:      wsURL,
+			FuturesWS:   wsURL,
+		}, nil
+	}
+	return Endpoints{}, fmt.Errorf("无效的交易所环境: %s", environment)
+}
+
+// parseCustomBaseURL 校验自定义地址，返回REST地址和对应的 ws/wss 数据流地址
+func parseCustomBaseURL(baseURL string) (string, string, error) {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return "", "", fmt.Errorf("自定义环境需要设置交易所地址")
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("无效的交易所地址: %s", baseURL)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", "", fmt.Errorf("交易所地址必须以 http:// 或 https:// 开头: %s", baseURL)
+	}
+	return baseURL, u.String() + "/ws", nil
+}
+
+// SetDefaultEnvironment 设置系统默认交易所环境，未指定环境的账户和公共行情数据流都使用该环境
+func SetDefaultEnvironment(environment, baseURL string) error {
+	endpoints, err := ResolveEndpoints(environment, baseURL)
+	if err != nil {
+		return err
+	}
+
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	defaultEndpoints = endpoints
+	return nil
+}
+
+// DefaultEndpoints 获取系统默认交易所环境的地址
+func DefaultEndpoints() Endpoints {
+	endpointsMu.RLock()
+	defer endpointsMu.RUnlock()
+	return defaultEndpoints
+}
+
+// AccountEndpoints 获取交易所账户实际使用的地址，账户未指定环境时使用系统默认环境
+func AccountEndpoints(account *models.ExchangeAccount) (Endpoints, error) {
+	if account.Environment == "" {
+		return DefaultEndpoints(), nil
+	}
+	return ResolveEndpoints(account.Environment, account.BaseURL)
+}
+
+// AccountEnvironment 获取交易所账户实际使用的环境名称
+func AccountEnvironment(account *models.ExchangeAccount) string {
+	if account.Environment == "" {
+		return DefaultEndpoints().Environment
+	}
+	return account.Environment
+}
+
+// IsProductionAccount 账户是否连接币安正式环境，测试网和自定义环境的余额不计入资产汇总
+func IsProductionAccount(account *models.ExchangeAccount) bool {
+	return AccountEnvironment(account) == models.AccountEnvProduction
+}
+
+// ValidateAccountEnvironment 校验账户环境和权限是否匹配
+// 现货测试网只支持现货交易，合约测试网只支持合约交易，测试网都不支持提币和双币投资
+func ValidateAccountEnvironment(environment, baseURL, permissions string) error {
+	if environment == "" {
+		return nil
+	}
+	if _, err := ResolveEndpoints(environment, baseURL); err != nil {
+		return err
+	}
+	var allowed string
+	switch environment {
+	case models.AccountEnvSpotTestnet:
+		allowed = models.AccountPermSpot
+	case models.AccountEnvFuturesTestnet:
+		allowed = models.AccountPermFutures
+	default:
+		return nil
+	}
+	for _, p := range strings.Split(permissions, ",") {
+		if p = strings.TrimSpace(p); p != "" && p != allowed {
+			return fmt.Errorf("%s 环境不支持 %s 权限", environment, p)
+		}
+	}
+	return nil
+}
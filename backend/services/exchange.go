@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Exchange 交易所抽象接口，所有任务和处理器都通过它访问交易所
+type Exchange interface {
+	// 现货账户与行情
+	GetAccount(ctx context.Context) (*binance.Account, error)
+	ListPrices(ctx context.Context, symbol string) ([]*binance.SymbolPrice, error)
+	GetDepth(ctx context.Context, symbol string, limit int) (*binance.DepthResponse, error)
+	GetExchangeInfo(ctx context.Context, symbol string) (*binance.ExchangeInfo, error)
+	ListKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*binance.Kline, error)
+
+	// 现货订单
+	CreateOrder(ctx context.Context, req SpotOrderRequest) (*binance.CreateOrderResponse, error)
+	GetOrder(ctx context.Context, symbol string, orderID int64) (*binance.Order, error)
+	CancelOrder(ctx context.Context, symbol string, orderID int64) error
+	ListOpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error)
+	ListTrades(ctx context.Context, symbol string, startTime, endTime int64, limit int) ([]*binance.TradeV3, error)
+	ListOrderTrades(ctx context.Context, symbol string, orderID int64) ([]*binance.TradeV3, error) // 单个订单的成交明细
+
+	// 提币
+	Withdraw(ctx context.Context, req WithdrawRequest) (*binance.CreateWithdrawResponse, error)
+	ListWithdraws(ctx context.Context, startTime, endTime int64) ([]*binance.Withdraw, error)
+
+	// U本位永续合约
+	GetFuturesAccount(ctx context.Context) (*futures.Account, error)
+	GetFuturesExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error)
+	GetFuturesDepth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error)
+	ListFuturesMarkPriceKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*futures.Kline, error)
+	CreateFuturesOrder(ctx context.Context, req FuturesOrderRequest) (*futures.CreateOrderResponse, error)
+	GetFuturesOrder(ctx context.Context, symbol string, orderID int64) (*futures.Order, error)
+	CancelFuturesOrder(ctx context.Context, symbol string, orderID int64) error
+	ListFuturesOpenOrders(ctx context.Context, symbol string) ([]*futures.Order, error)
+	ListFuturesOrders(ctx context.Context, symbol string, startTime int64) ([]*futures.Order, error) // 交易对的全部订单，含已成交和已撤销
+	GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error)
+	ChangeLeverage(ctx context.Context, symbol string, leverage int) error
+	ChangeMarginType(ctx context.Context, symbol string, marginType string) error
+
+	// 双币投资
+	ListDCIProducts(ctx context.Context, optionType, investCoin, exercisedCoin string) ([]DCIProductListItem, error)
+	SubscribeDCIProduct(ctx context.Context, req DCISubscribeRequest) (map[string]interface{}, error)
+	GetDCIPositions(ctx context.Context) ([]DCIPositionItem, error)
+}
+
+// UserStreamExchange 支持用户数据流的交易所，通过 listenKey 订阅订单和账户推送
+// 模拟盘和测试用交易所不实现该接口，相关任务退回轮询
+type UserStreamExchange interface {
+	StartUserStream(ctx context.Context) (string, error)
+	KeepaliveUserStream(ctx context.Context, listenKey string) error
+	CloseUserStream(ctx context.Context, listenKey string) error
+	StartFuturesUserStream(ctx context.Context) (string, error)
+	KeepaliveFuturesUserStream(ctx context.Context, listenKey string) error
+	CloseFuturesUserStream(ctx context.Context, listenKey string) error
+	StreamEndpoints() Endpoints
+}
+
+// SpotOrderRequest 现货下单参数
+type SpotOrderRequest struct {
+	Symbol      string
+	Side        binance.SideType
+	Type        binance.OrderType
+	TimeInForce binance.TimeInForceType // 市价单留空
+	Quantity    string
+	Price       string // 市价单留空
+}
+
+// FuturesOrderRequest 合约下单参数
+type FuturesOrderRequest struct {
+	Symbol       string
+	Side         futures.SideType
+	PositionSide futures.PositionSideType
+	Type         futures.OrderType
+	TimeInForce  futures.TimeInForceType // 市价单、止损单留空
+	Quantity     string
+	Price        string // 限价单价格
+	StopPrice    string // 止损/止盈触发价格
+	// 跟踪止损单（TRAILING_STOP_MARKET）参数
+	ActivationPrice string // 激活价格，为空时立即激活
+	CallbackRate    string // 回调百分比，如 "1" 表示1%
+	ClientOrderID   string // 自定义订单ID，为空时由交易所生成
+}
+
+// WithdrawRequest 提币参数
+type WithdrawRequest struct {
+	Coin    string
+	Address string
+	Amount  string
+	Network string // 为空时使用默认网络
+}
+
+// DCISubscribeRequest 双币投资申购参数
+type DCISubscribeRequest struct {
+	ID               string
+	OrderID          string
+	DepositAmount    decimal.Decimal
+	AutoCompoundPlan string
+}
+
+// ExchangeFactory 根据API密钥创建交易所实例
+type ExchangeFactory func(apiKey, secretKey string) Exchange
+
+var (
+	factoryMu       sync.RWMutex
+	exchangeFactory ExchangeFactory = func(apiKey, secretKey string) Exchange {
+		return NewBinanceExchange(apiKey, secretKey)
+	}
+)
+
+// SetExchangeFactory 替换交易所工厂（集成测试中注入 FakeExchange）
+func SetExchangeFactory(factory ExchangeFactory) {
+	factoryMu.Lock()
+	defer factoryMu.Unlock()
+	exchangeFactory = factory
+}
+
+// NewExchange 使用当前工厂创建交易所实例
+func NewExchange(apiKey, secretKey string) Exchange {
+	factoryMu.RLock()
+	defer factoryMu.RUnlock()
+	return exchangeFactory(apiKey, secretKey)
+}
+
+// ResolveAccount 获取用户的交易所账户，accountID 为0时使用默认账户
+func ResolveAccount(db *gorm.DB, userID, accountID uint) (*models.ExchangeAccount, error) {
+	var account models.ExchangeAccount
+	query := db.Where("user_id = ?", userID)
+	if accountID > 0 {
+		query = query.Where("id = ?", accountID)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+	if err := query.First(&account).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			if accountID > 0 {
+				return nil, fmt.Errorf("交易所账户 %d 不存在", accountID)
+			}
+			return nil, fmt.Errorf("未设置默认交易所账户")
+		}
+		return nil, fmt.Errorf("查询交易所账户失败: %v", err)
+	}
+	return &account, nil
+}
+
+// ResolveAccountWith 获取交易所账户并检查账户已启用且开通了指定权限
+func ResolveAccountWith(db *gorm.DB, userID, accountID uint, permission string) (*models.ExchangeAccount, error) {
+	account, err := ResolveAccount(db, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status != "active" {
+		return nil, fmt.Errorf("交易所账户 %s 已停用", account.Label)
+	}
+	if permission != "" && !account.HasPermission(permission) {
+		return nil, fmt.Errorf("交易所账户 %s 未开通 %s 权限", account.Label, permission)
+	}
+	return account, nil
+}
+
+// ResolveTradingAccountID 校验下单或创建策略时选择的交易所账户，返回实际使用的账户ID
+// 模拟盘不需要交易所账户，原样返回
+func ResolveTradingAccountID(db *gorm.DB, userID, accountID uint, permission string, paper bool) (uint, error) {
+	if paper {
+		return accountID, nil
+	}
+	account, err := ResolveAccountWith(db, userID, accountID, permission)
+	if err != nil {
+		return 0, err
+	}
+	return account.ID, nil
+}
+
+// NewAccountExchange 解密交易所账户的API密钥并创建交易所实例，停用的账户返回错误
+func NewAccountExchange(account *models.ExchangeAccount) (Exchange, error) {
+	if account.Status != "active" {
+		return nil, fmt.Errorf("交易所账户 %s 已停用", account.Label)
+	}
+	return NewAccountExchangeAnyStatus(account)
+}
+
+// NewAccountExchangeAnyStatus 创建交易所实例但不检查账户状态
+// 仅用于紧急停止：停用的账户上仍可能有挂单和持仓需要撤销和平仓
+func NewAccountExchangeAnyStatus(account *models.ExchangeAccount) (Exchange, error) {
+	apiKey, err := account.GetDecryptedAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("解密API Key失败: %v", err)
+	}
+	secretKey, err := account.GetDecryptedSecretKey()
+	if err != nil {
+		return nil, fmt.Errorf("解密Secret Key失败: %v", err)
+	}
+	if apiKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("API 密钥未设置")
+	}
+	endpoints, err := AccountEndpoints(account)
+	if err != nil {
+		return nil, fmt.Errorf("交易所账户 %s 环境配置错误: %v", account.Label, err)
+	}
+	exchange := NewExchange(apiKey, secretKey)
+	if binanceExchange, ok := exchange.(*BinanceExchange); ok {
+		binanceExchange.UseEndpoints(endpoints)
+	}
+	return exchange, nil
+}
+
+// NewUserExchange 创建用户指定交易所账户的交易所实例，accountID 为0时使用默认账户
+func NewUserExchange(db *gorm.DB, userID, accountID uint) (Exchange, error) {
+	account, err := ResolveAccount(db, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return NewAccountExchange(account)
+}
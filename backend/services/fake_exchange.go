@@ -0,0 +1,1053 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/shopspring/decimal"
+)
+
+// FakeSymbol 模拟交易所中的交易对规则
+type FakeSymbol struct {
+	Symbol      string
+	BaseAsset   string
+	QuoteAsset  string
+	TickSize    float64
+	StepSize    float64
+	MinQty      float64
+	MinNotional float64
+}
+
+// FakeExchange 内存模拟交易所，使用确定性订单簿，供集成测试使用
+//
+// 订单簿以最新价格为中心，第 i 档买价为 price-(i+1)*tick，卖价为 price+(i+1)*tick，
+// 第 i 档数量为 LevelQuantity*(i+1)。限价单在价格穿越挂单价时以挂单价全部成交，
+// 市价单以对手方一档价格立即成交，同样的价格序列总是得到同样的结果。
+type FakeExchange struct {
+	mu sync.Mutex
+
+	LevelQuantity  float64 // 每档基础数量
+	CommissionRate float64 // 手续费率
+
+	symbols       map[string]FakeSymbol
+	prices        map[string]float64
+	balances      map[string]float64
+	futuresWallet float64
+	leverage      map[string]int
+	marginType    map[string]string
+
+	nextOrderID   int64
+	nextTradeID   int64
+	spotOrders    map[int64]*binance.Order
+	futuresOrders map[int64]*futures.Order
+	trailing      map[int64]float64 // 跟踪止损单 -> 激活后的最高/最低价
+	trades        []*binance.TradeV3
+	positions     map[string]*fakePosition
+
+	withdraws    []*binance.Withdraw
+	dciProducts  []DCIProductListItem
+	dciPositions []DCIPositionItem
+}
+
+// fakePosition 模拟合约持仓
+type fakePosition struct {
+	symbol       string
+	positionSide string
+	amount       float64 // 做多为正，做空为负
+	entryPrice   float64
+}
+
+// NewFakeExchange 创建模拟交易所
+func NewFakeExchange() *FakeExchange {
+	return &FakeExchange{
+		LevelQuantity:  1,
+		CommissionRate: 0.001,
+		symbols:        make(map[string]FakeSymbol),
+		prices:         make(map[string]float64),
+		balances:       make(map[string]float64),
+		leverage:       make(map[string]int),
+		marginType:     make(map[string]string),
+		nextOrderID:    1,
+		nextTradeID:    1,
+		spotOrders:     make(map[int64]*binance.Order),
+		futuresOrders:  make(map[int64]*futures.Order),
+		trailing:       make(map[int64]float64),
+		positions:      make(map[string]*fakePosition),
+	}
+}
+
+// Factory 返回总是使用本实例的交易所工厂
+func (f *FakeExchange) Factory() ExchangeFactory {
+	return func(apiKey, secretKey string) Exchange {
+		return f
+	}
+}
+
+// AddSymbol 注册交易对并设置初始价格
+func (f *FakeExchange) AddSymbol(symbol FakeSymbol, price float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if symbol.TickSize <= 0 {
+		symbol.TickSize = 0.01
+	}
+	if symbol.StepSize <= 0 {
+		symbol.StepSize = 0.00001
+	}
+	if symbol.BaseAsset == "" || symbol.QuoteAsset == "" {
+		symbol.BaseAsset, symbol.QuoteAsset = splitSymbol(symbol.Symbol)
+	}
+	f.symbols[symbol.Symbol] = symbol
+	f.prices[symbol.Symbol] = price
+}
+
+// SetBalance 设置现货余额
+func (f *FakeExchange) SetBalance(asset string, amount float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.balances[asset] = amount
+}
+
+// SetFuturesBalance 设置合约钱包余额（USDT）
+func (f *FakeExchange) SetFuturesBalance(amount float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.futuresWallet = amount
+}
+
+// SetPrice 更新最新价格并撮合所有挂单
+func (f *FakeExchange) SetPrice(symbol string, price float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.prices[symbol] = price
+	f.matchSpot(symbol, price)
+	f.matchFutures(symbol, price)
+}
+
+// AddDCIProduct 添加双币投资产品
+func (f *FakeExchange) AddDCIProduct(product DCIProductListItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dciProducts = append(f.dciProducts, product)
+}
+
+// SettleDCIPosition 将双币投资持仓标记为已结算
+func (f *FakeExchange) SettleDCIPosition(positionID, settleAsset string, settleAmount, profitAmount float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.dciPositions {
+		if f.dciPositions[i].PositionId == positionID {
+			f.dciPositions[i].Status = "SETTLED"
+			f.dciPositions[i].SettleAsset = settleAsset
+			f.dciPositions[i].SettleAmount = formatFakeFloat(settleAmount)
+			f.dciPositions[i].ProfitAmount = formatFakeFloat(profitAmount)
+			f.balances[settleAsset] += settleAmount
+		}
+	}
+}
+
+// ==================== 现货 ====================
+
+func (f *FakeExchange) GetAccount(ctx context.Context) (*binance.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assets := make([]string, 0, len(f.balances))
+	for asset := range f.balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	locked := f.lockedBalances()
+	account := &binance.Account{CanTrade: true, CanWithdraw: true, CanDeposit: true}
+	for _, asset := range assets {
+		account.Balances = append(account.Balances, binance.Balance{
+			Asset:  asset,
+			Free:   formatFakeFloat(f.balances[asset] - locked[asset]),
+			Locked: formatFakeFloat(locked[asset]),
+		})
+	}
+	return account, nil
+}
+
+func (f *FakeExchange) ListPrices(ctx context.Context, symbol string) ([]*binance.SymbolPrice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if symbol != "" {
+		price, ok := f.prices[symbol]
+		if !ok {
+			return nil, fakeInvalidSymbol()
+		}
+		return []*binance.SymbolPrice{{Symbol: symbol, Price: formatFakeFloat(price)}}, nil
+	}
+
+	var result []*binance.SymbolPrice
+	for _, s := range f.sortedSymbols() {
+		result = append(result, &binance.SymbolPrice{Symbol: s, Price: formatFakeFloat(f.prices[s])})
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) GetDepth(ctx context.Context, symbol string, limit int) (*binance.DepthResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bids, asks, err := f.book(symbol, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &binance.DepthResponse{LastUpdateID: f.nextOrderID, Bids: bids, Asks: asks}, nil
+}
+
+// ListKlines 模拟交易所不保存历史行情，返回空K线
+func (f *FakeExchange) ListKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*binance.Kline, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.symbols[symbol]; !ok {
+		return nil, fakeInvalidSymbol()
+	}
+	return []*binance.Kline{}, nil
+}
+
+func (f *FakeExchange) GetExchangeInfo(ctx context.Context, symbol string) (*binance.ExchangeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info := &binance.ExchangeInfo{ServerTime: time.Now().UnixMilli()}
+	for _, name := range f.sortedSymbols() {
+		if symbol != "" && name != symbol {
+			continue
+		}
+		s := f.symbols[name]
+		info.Symbols = append(info.Symbols, binance.Symbol{
+			Symbol:               s.Symbol,
+			Status:               "TRADING",
+			BaseAsset:            s.BaseAsset,
+			QuoteAsset:           s.QuoteAsset,
+			IsSpotTradingAllowed: true,
+			Filters:              fakeFilters(s),
+		})
+	}
+	if symbol != "" && len(info.Symbols) == 0 {
+		return nil, fakeInvalidSymbol()
+	}
+	return info, nil
+}
+
+func (f *FakeExchange) CreateOrder(ctx context.Context, req SpotOrderRequest) (*binance.CreateOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.symbols[req.Symbol]
+	if !ok {
+		return nil, fakeInvalidSymbol()
+	}
+	quantity, _ := strconv.ParseFloat(req.Quantity, 64)
+	price, _ := strconv.ParseFloat(req.Price, 64)
+	if quantity <= 0 || quantity < s.MinQty {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}
+	}
+	if req.Type == binance.OrderTypeLimit && price <= 0 {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: PRICE_FILTER"}
+	}
+
+	order := &binance.Order{
+		Symbol:           req.Symbol,
+		OrderID:          f.nextOrderID,
+		Price:            req.Price,
+		OrigQuantity:     req.Quantity,
+		ExecutedQuantity: "0",
+		Status:           binance.OrderStatusTypeNew,
+		TimeInForce:      req.TimeInForce,
+		Type:             req.Type,
+		Side:             req.Side,
+		Time:             time.Now().UnixMilli(),
+		UpdateTime:       time.Now().UnixMilli(),
+		IsWorking:        true,
+	}
+	f.nextOrderID++
+	f.spotOrders[order.OrderID] = order
+
+	// 市价单或穿价限价单立即成交
+	last := f.prices[req.Symbol]
+	if req.Type == binance.OrderTypeMarket {
+		fillPrice := last + s.TickSize
+		if req.Side == binance.SideTypeSell {
+			fillPrice = last - s.TickSize
+		}
+		f.fillSpot(order, fillPrice)
+	} else if (req.Side == binance.SideTypeBuy && price >= last+s.TickSize) ||
+		(req.Side == binance.SideTypeSell && price <= last-s.TickSize) {
+		f.fillSpot(order, price)
+	}
+
+	return &binance.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          order.OrderID,
+		TransactTime:     order.Time,
+		Price:            order.Price,
+		OrigQuantity:     order.OrigQuantity,
+		ExecutedQuantity: order.ExecutedQuantity,
+		Status:           order.Status,
+		TimeInForce:      order.TimeInForce,
+		Type:             order.Type,
+		Side:             order.Side,
+	}, nil
+}
+
+func (f *FakeExchange) GetOrder(ctx context.Context, symbol string, orderID int64) (*binance.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.spotOrders[orderID]
+	if !ok || order.Symbol != symbol {
+		return nil, fakeOrderNotFound()
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (f *FakeExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.spotOrders[orderID]
+	if !ok || order.Symbol != symbol || !isFakeSpotOpen(order) {
+		return fakeOrderNotFound()
+	}
+	order.Status = binance.OrderStatusTypeCanceled
+	order.IsWorking = false
+	order.UpdateTime = time.Now().UnixMilli()
+	return nil
+}
+
+func (f *FakeExchange) ListOpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*binance.Order
+	for _, id := range f.sortedSpotOrderIDs() {
+		order := f.spotOrders[id]
+		if (symbol == "" || order.Symbol == symbol) && isFakeSpotOpen(order) {
+			copied := *order
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) ListTrades(ctx context.Context, symbol string, startTime, endTime int64, limit int) ([]*binance.TradeV3, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*binance.TradeV3
+	for _, trade := range f.trades {
+		if trade.Symbol != symbol {
+			continue
+		}
+		if (startTime > 0 && trade.Time < startTime) || (endTime > 0 && trade.Time > endTime) {
+			continue
+		}
+		copied := *trade
+		result = append(result, &copied)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) ListOrderTrades(ctx context.Context, symbol string, orderID int64) ([]*binance.TradeV3, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*binance.TradeV3
+	for _, trade := range f.trades {
+		if trade.Symbol == symbol && trade.OrderID == orderID {
+			copied := *trade
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+// ==================== 提币 ====================
+
+func (f *FakeExchange) Withdraw(ctx context.Context, req WithdrawRequest) (*binance.CreateWithdrawResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	amount, _ := strconv.ParseFloat(req.Amount, 64)
+	if amount <= 0 || f.balances[req.Coin]-f.lockedBalances()[req.Coin] < amount {
+		return nil, &common.APIError{Code: -4026, Message: "User has insufficient balance"}
+	}
+	f.balances[req.Coin] -= amount
+
+	id := fmt.Sprintf("fake-withdraw-%d", len(f.withdraws)+1)
+	f.withdraws = append(f.withdraws, &binance.Withdraw{
+		ID:        id,
+		Coin:      req.Coin,
+		Address:   req.Address,
+		Amount:    req.Amount,
+		Network:   req.Network,
+		ApplyTime: time.Now().UTC().Format("2006-01-02 15:04:05"),
+		Status:    6,
+	})
+	return &binance.CreateWithdrawResponse{ID: id}, nil
+}
+
+func (f *FakeExchange) ListWithdraws(ctx context.Context, startTime, endTime int64) ([]*binance.Withdraw, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]*binance.Withdraw, 0, len(f.withdraws))
+	for _, w := range f.withdraws {
+		copied := *w
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// ==================== 永续合约 ====================
+
+func (f *FakeExchange) GetFuturesAccount(ctx context.Context) (*futures.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unrealized := 0.0
+	account := &futures.Account{CanTrade: true, CanDeposit: true, CanWithdraw: true}
+	for _, key := range f.sortedPositionKeys() {
+		pos := f.positions[key]
+		pnl := (f.prices[pos.symbol] - pos.entryPrice) * pos.amount
+		unrealized += pnl
+		account.Positions = append(account.Positions, &futures.AccountPosition{
+			Symbol:           pos.symbol,
+			PositionSide:     futures.PositionSideType(pos.positionSide),
+			PositionAmt:      formatFakeFloat(pos.amount),
+			EntryPrice:       formatFakeFloat(pos.entryPrice),
+			UnrealizedProfit: formatFakeFloat(pnl),
+			Leverage:         strconv.Itoa(f.leverageOf(pos.symbol)),
+			Isolated:         f.marginType[pos.symbol] == "ISOLATED",
+		})
+	}
+
+	account.TotalWalletBalance = formatFakeFloat(f.futuresWallet)
+	account.TotalUnrealizedProfit = formatFakeFloat(unrealized)
+	account.TotalMarginBalance = formatFakeFloat(f.futuresWallet + unrealized)
+	account.AvailableBalance = formatFakeFloat(f.futuresWallet + unrealized)
+	account.Assets = []*futures.AccountAsset{{
+		Asset:            "USDT",
+		WalletBalance:    account.TotalWalletBalance,
+		UnrealizedProfit: account.TotalUnrealizedProfit,
+		MarginBalance:    account.TotalMarginBalance,
+		AvailableBalance: account.AvailableBalance,
+	}}
+	return account, nil
+}
+
+func (f *FakeExchange) GetFuturesExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info := &futures.ExchangeInfo{ServerTime: time.Now().UnixMilli()}
+	for _, name := range f.sortedSymbols() {
+		s := f.symbols[name]
+		info.Symbols = append(info.Symbols, futures.Symbol{
+			Symbol:            s.Symbol,
+			Pair:              s.Symbol,
+			ContractType:      futures.ContractTypePerpetual,
+			Status:            "TRADING",
+			PricePrecision:    fakePrecision(s.TickSize),
+			QuantityPrecision: fakePrecision(s.StepSize),
+			BaseAsset:         s.BaseAsset,
+			QuoteAsset:        s.QuoteAsset,
+			MarginAsset:       s.QuoteAsset,
+			Filters:           fakeFilters(s),
+		})
+	}
+	return info, nil
+}
+
+func (f *FakeExchange) GetFuturesDepth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bids, asks, err := f.book(symbol, limit)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	return &futures.DepthResponse{LastUpdateID: f.nextOrderID, Time: now, TradeTime: now, Bids: bids, Asks: asks}, nil
+}
+
+func (f *FakeExchange) ListFuturesMarkPriceKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*futures.Kline, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.symbols[symbol]; !ok {
+		return nil, fakeInvalidSymbol()
+	}
+	return []*futures.Kline{}, nil
+}
+
+func (f *FakeExchange) CreateFuturesOrder(ctx context.Context, req FuturesOrderRequest) (*futures.CreateOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.symbols[req.Symbol]
+	if !ok {
+		return nil, fakeInvalidSymbol()
+	}
+	quantity, _ := strconv.ParseFloat(req.Quantity, 64)
+	if quantity <= 0 || quantity < s.MinQty {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}
+	}
+
+	now := time.Now().UnixMilli()
+	order := &futures.Order{
+		Symbol:           req.Symbol,
+		OrderID:          f.nextOrderID,
+		ClientOrderID:    req.ClientOrderID,
+		Price:            req.Price,
+		OrigQuantity:     req.Quantity,
+		ExecutedQuantity: "0",
+		AvgPrice:         "0",
+		Status:           futures.OrderStatusTypeNew,
+		TimeInForce:      req.TimeInForce,
+		Type:             req.Type,
+		OrigType:         req.Type,
+		Side:             req.Side,
+		StopPrice:        req.StopPrice,
+		ActivatePrice:    req.ActivationPrice,
+		PriceRate:        req.CallbackRate,
+		PositionSide:     req.PositionSide,
+		Time:             now,
+		UpdateTime:       now,
+	}
+	f.nextOrderID++
+	f.futuresOrders[order.OrderID] = order
+
+	last := f.prices[req.Symbol]
+	price, _ := strconv.ParseFloat(req.Price, 64)
+	switch req.Type {
+	case futures.OrderTypeMarket:
+		fillPrice := last + s.TickSize
+		if req.Side == futures.SideTypeSell {
+			fillPrice = last - s.TickSize
+		}
+		f.fillFutures(order, fillPrice)
+	case futures.OrderTypeLimit:
+		if (req.Side == futures.SideTypeBuy && price >= last+s.TickSize) ||
+			(req.Side == futures.SideTypeSell && price <= last-s.TickSize) {
+			f.fillFutures(order, price)
+		}
+	case futures.OrderTypeTrailingStopMarket:
+		callbackRate, _ := strconv.ParseFloat(req.CallbackRate, 64)
+		if callbackRate < 0.1 || callbackRate > 10 {
+			delete(f.futuresOrders, order.OrderID)
+			return nil, &common.APIError{Code: -2007, Message: "Invalid callBackRate."}
+		}
+		// 未设置激活价格时以当前价格立即激活
+		if req.ActivationPrice == "" {
+			f.trailing[order.OrderID] = last
+		}
+	}
+
+	return &futures.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          order.OrderID,
+		Price:            order.Price,
+		OrigQuantity:     order.OrigQuantity,
+		ExecutedQuantity: order.ExecutedQuantity,
+		Status:           order.Status,
+		StopPrice:        order.StopPrice,
+		TimeInForce:      order.TimeInForce,
+		Type:             order.Type,
+		Side:             order.Side,
+		UpdateTime:       order.UpdateTime,
+		AvgPrice:         order.AvgPrice,
+		PositionSide:     order.PositionSide,
+		OrigType:         order.OrigType,
+	}, nil
+}
+
+func (f *FakeExchange) GetFuturesOrder(ctx context.Context, symbol string, orderID int64) (*futures.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.futuresOrders[orderID]
+	if !ok || order.Symbol != symbol {
+		return nil, fakeOrderNotFound()
+	}
+	copied := *order
+	return &copied, nil
+}
+
+func (f *FakeExchange) CancelFuturesOrder(ctx context.Context, symbol string, orderID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.futuresOrders[orderID]
+	if !ok || order.Symbol != symbol || !isFakeFuturesOpen(order) {
+		return fakeOrderNotFound()
+	}
+	order.Status = futures.OrderStatusTypeCanceled
+	order.UpdateTime = time.Now().UnixMilli()
+	return nil
+}
+
+func (f *FakeExchange) ListFuturesOpenOrders(ctx context.Context, symbol string) ([]*futures.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*futures.Order
+	for _, id := range f.sortedFuturesOrderIDs() {
+		order := f.futuresOrders[id]
+		if (symbol == "" || order.Symbol == symbol) && isFakeFuturesOpen(order) {
+			copied := *order
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) ListFuturesOrders(ctx context.Context, symbol string, startTime int64) ([]*futures.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*futures.Order
+	for _, id := range f.sortedFuturesOrderIDs() {
+		order := f.futuresOrders[id]
+		if order.Symbol == symbol && order.Time >= startTime {
+			copied := *order
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*futures.PositionRisk
+	for _, key := range f.sortedPositionKeys() {
+		pos := f.positions[key]
+		if symbol != "" && pos.symbol != symbol {
+			continue
+		}
+		mark := f.prices[pos.symbol]
+		leverage := f.leverageOf(pos.symbol)
+		marginType := strings.ToLower(f.marginType[pos.symbol])
+		if marginType == "" {
+			marginType = "cross"
+		}
+		result = append(result, &futures.PositionRisk{
+			Symbol:           pos.symbol,
+			PositionSide:     pos.positionSide,
+			PositionAmt:      formatFakeFloat(pos.amount),
+			EntryPrice:       formatFakeFloat(pos.entryPrice),
+			MarkPrice:        formatFakeFloat(mark),
+			UnRealizedProfit: formatFakeFloat((mark - pos.entryPrice) * pos.amount),
+			LiquidationPrice: formatFakeFloat(fakeLiquidationPrice(pos, leverage)),
+			Leverage:         strconv.Itoa(leverage),
+			MarginType:       marginType,
+			Notional:         formatFakeFloat(mark * pos.amount),
+		})
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) ChangeLeverage(ctx context.Context, symbol string, leverage int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.symbols[symbol]; !ok {
+		return fakeInvalidSymbol()
+	}
+	if leverage < 1 || leverage > 125 {
+		return &common.APIError{Code: -4028, Message: "Leverage is not valid"}
+	}
+	f.leverage[symbol] = leverage
+	return nil
+}
+
+func (f *FakeExchange) ChangeMarginType(ctx context.Context, symbol string, marginType string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.symbols[symbol]; !ok {
+		return fakeInvalidSymbol()
+	}
+	current := f.marginType[symbol]
+	if current == "" {
+		current = "CROSSED"
+	}
+	if current == marginType {
+		return &common.APIError{Code: -4046, Message: "No need to change margin type."}
+	}
+	f.marginType[symbol] = marginType
+	return nil
+}
+
+// ==================== 双币投资 ====================
+
+func (f *FakeExchange) ListDCIProducts(ctx context.Context, optionType, investCoin, exercisedCoin string) ([]DCIProductListItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []DCIProductListItem
+	for _, p := range f.dciProducts {
+		if p.OptionType == optionType && p.InvestCoin == investCoin && p.ExercisedCoin == exercisedCoin {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (f *FakeExchange) SubscribeDCIProduct(ctx context.Context, req DCISubscribeRequest) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var product *DCIProductListItem
+	for i := range f.dciProducts {
+		if f.dciProducts[i].Id == req.ID {
+			product = &f.dciProducts[i]
+			break
+		}
+	}
+	if product == nil {
+		return nil, &common.APIError{Code: -6001, Message: "Product does not exist"}
+	}
+	amount := req.DepositAmount.InexactFloat64()
+	if f.balances[product.InvestCoin] < amount {
+		return nil, &common.APIError{Code: -6012, Message: "Insufficient balance"}
+	}
+	f.balances[product.InvestCoin] -= amount
+
+	positionID := int64(len(f.dciPositions) + 1)
+	now := time.Now().UnixMilli()
+	f.dciPositions = append(f.dciPositions, DCIPositionItem{
+		Id:           strconv.FormatInt(positionID, 10),
+		PositionId:   strconv.FormatInt(positionID, 10),
+		ProductId:    product.Id,
+		Symbol:       product.Symbol,
+		Direction:    product.Direction,
+		StrikePrice:  product.StrikePrice,
+		Duration:     product.Duration,
+		Apy:          product.APR,
+		InvestAmount: req.DepositAmount.String(),
+		InvestAsset:  product.InvestCoin,
+		PurchaseTime: now,
+		DeliveryDate: product.DeliveryDate,
+		Status:       "ACTIVE",
+	})
+
+	return map[string]interface{}{
+		"positionId":   float64(positionID),
+		"investCoin":   product.InvestCoin,
+		"purchaseTime": float64(now),
+	}, nil
+}
+
+func (f *FakeExchange) GetDCIPositions(ctx context.Context) ([]DCIPositionItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]DCIPositionItem, len(f.dciPositions))
+	copy(result, f.dciPositions)
+	return result, nil
+}
+
+// ==================== 内部撮合 ====================
+
+// book 生成以最新价为中心的确定性订单簿
+func (f *FakeExchange) book(symbol string, limit int) ([]common.PriceLevel, []common.PriceLevel, error) {
+	s, ok := f.symbols[symbol]
+	if !ok {
+		return nil, nil, fakeInvalidSymbol()
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	price := f.prices[symbol]
+	precision := fakePrecision(s.TickSize)
+
+	bids := make([]common.PriceLevel, 0, limit)
+	asks := make([]common.PriceLevel, 0, limit)
+	for i := 0; i < limit; i++ {
+		qty := formatFakeFloat(f.LevelQuantity * float64(i+1))
+		bidPrice := price - float64(i+1)*s.TickSize
+		if bidPrice > 0 {
+			bids = append(bids, common.PriceLevel{Price: strconv.FormatFloat(bidPrice, 'f', precision, 64), Quantity: qty})
+		}
+		asks = append(asks, common.PriceLevel{Price: strconv.FormatFloat(price+float64(i+1)*s.TickSize, 'f', precision, 64), Quantity: qty})
+	}
+	return bids, asks, nil
+}
+
+// matchSpot 撮合现货挂单
+func (f *FakeExchange) matchSpot(symbol string, price float64) {
+	for _, id := range f.sortedSpotOrderIDs() {
+		order := f.spotOrders[id]
+		if order.Symbol != symbol || !isFakeSpotOpen(order) || order.Type != binance.OrderTypeLimit {
+			continue
+		}
+		limit, _ := strconv.ParseFloat(order.Price, 64)
+		if (order.Side == binance.SideTypeBuy && price <= limit) ||
+			(order.Side == binance.SideTypeSell && price >= limit) {
+			f.fillSpot(order, limit)
+		}
+	}
+}
+
+// fillSpot 以指定价格全部成交现货订单
+func (f *FakeExchange) fillSpot(order *binance.Order, price float64) {
+	s := f.symbols[order.Symbol]
+	quantity, _ := strconv.ParseFloat(order.OrigQuantity, 64)
+	quote := price * quantity
+	commission := quote * f.CommissionRate
+
+	if order.Side == binance.SideTypeBuy {
+		f.balances[s.BaseAsset] += quantity
+		f.balances[s.QuoteAsset] -= quote + commission
+	} else {
+		f.balances[s.BaseAsset] -= quantity
+		f.balances[s.QuoteAsset] += quote - commission
+	}
+
+	order.Status = binance.OrderStatusTypeFilled
+	order.ExecutedQuantity = order.OrigQuantity
+	order.CummulativeQuoteQuantity = formatFakeFloat(quote)
+	order.IsWorking = false
+	order.UpdateTime = time.Now().UnixMilli()
+
+	f.trades = append(f.trades, &binance.TradeV3{
+		ID:              f.nextTradeID,
+		Symbol:          order.Symbol,
+		OrderID:         order.OrderID,
+		Price:           formatFakeFloat(price),
+		Quantity:        order.OrigQuantity,
+		QuoteQuantity:   formatFakeFloat(quote),
+		Commission:      formatFakeFloat(commission),
+		CommissionAsset: s.QuoteAsset,
+		Time:            order.UpdateTime,
+		IsBuyer:         order.Side == binance.SideTypeBuy,
+		IsMaker:         order.Type == binance.OrderTypeLimit,
+	})
+	f.nextTradeID++
+}
+
+// matchFutures 撮合合约挂单和条件单
+func (f *FakeExchange) matchFutures(symbol string, price float64) {
+	for _, id := range f.sortedFuturesOrderIDs() {
+		order := f.futuresOrders[id]
+		if order.Symbol != symbol || !isFakeFuturesOpen(order) {
+			continue
+		}
+		switch order.Type {
+		case futures.OrderTypeLimit:
+			limit, _ := strconv.ParseFloat(order.Price, 64)
+			if (order.Side == futures.SideTypeBuy && price <= limit) ||
+				(order.Side == futures.SideTypeSell && price >= limit) {
+				f.fillFutures(order, limit)
+			}
+		case futures.OrderTypeStopMarket, futures.OrderTypeTakeProfitMarket:
+			stop, _ := strconv.ParseFloat(order.StopPrice, 64)
+			// 止损：卖单在价格跌破时触发，买单在价格涨破时触发；止盈相反
+			triggered := (order.Side == futures.SideTypeSell && price <= stop) ||
+				(order.Side == futures.SideTypeBuy && price >= stop)
+			if order.Type == futures.OrderTypeTakeProfitMarket {
+				triggered = (order.Side == futures.SideTypeSell && price >= stop) ||
+					(order.Side == futures.SideTypeBuy && price <= stop)
+			}
+			if triggered {
+				f.fillFutures(order, stop)
+			}
+		case futures.OrderTypeTrailingStopMarket:
+			callbackRate, _ := strconv.ParseFloat(order.PriceRate, 64)
+			current := decimal.NewFromFloat(price)
+			extreme, stop, triggered := trailingStopTrigger(order.Side == futures.SideTypeSell,
+				parsePaperDecimal(order.ActivatePrice), callbackRate, decimal.NewFromFloat(f.trailing[id]), current, current)
+			f.trailing[id] = extreme.InexactFloat64()
+			if triggered {
+				f.fillFutures(order, stop.InexactFloat64())
+			}
+		}
+	}
+}
+
+// fillFutures 以指定价格全部成交合约订单并更新持仓
+func (f *FakeExchange) fillFutures(order *futures.Order, price float64) {
+	quantity, _ := strconv.ParseFloat(order.OrigQuantity, 64)
+	signed := quantity
+	if order.Side == futures.SideTypeSell {
+		signed = -quantity
+	}
+
+	key := order.Symbol + "_" + string(order.PositionSide)
+	pos, ok := f.positions[key]
+	if !ok {
+		pos = &fakePosition{symbol: order.Symbol, positionSide: string(order.PositionSide)}
+		f.positions[key] = pos
+	}
+
+	// 加仓时更新均价，减仓时结算已实现盈亏
+	if pos.amount == 0 || (pos.amount > 0) == (signed > 0) {
+		total := math.Abs(pos.amount) + quantity
+		pos.entryPrice = (pos.entryPrice*math.Abs(pos.amount) + price*quantity) / total
+		pos.amount += signed
+	} else {
+		closed := math.Min(quantity, math.Abs(pos.amount))
+		direction := 1.0
+		if pos.amount < 0 {
+			direction = -1.0
+		}
+		f.futuresWallet += (price - pos.entryPrice) * closed * direction
+		pos.amount += signed
+		if math.Abs(pos.amount) < 1e-12 {
+			pos.amount = 0
+			pos.entryPrice = 0
+		}
+	}
+	f.futuresWallet -= price * quantity * f.CommissionRate
+
+	order.Status = futures.OrderStatusTypeFilled
+	order.ExecutedQuantity = order.OrigQuantity
+	order.CumQuantity = order.OrigQuantity
+	order.AvgPrice = formatFakeFloat(price)
+	order.CumQuote = formatFakeFloat(price * quantity)
+	order.UpdateTime = time.Now().UnixMilli()
+}
+
+// lockedBalances 计算挂单冻结的资产
+func (f *FakeExchange) lockedBalances() map[string]float64 {
+	locked := make(map[string]float64)
+	for _, order := range f.spotOrders {
+		if !isFakeSpotOpen(order) {
+			continue
+		}
+		s := f.symbols[order.Symbol]
+		quantity, _ := strconv.ParseFloat(order.OrigQuantity, 64)
+		if order.Side == binance.SideTypeBuy {
+			price, _ := strconv.ParseFloat(order.Price, 64)
+			locked[s.QuoteAsset] += price * quantity
+		} else {
+			locked[s.BaseAsset] += quantity
+		}
+	}
+	return locked
+}
+
+func (f *FakeExchange) leverageOf(symbol string) int {
+	if leverage, ok := f.leverage[symbol]; ok {
+		return leverage
+	}
+	return 20
+}
+
+func (f *FakeExchange) sortedSymbols() []string {
+	names := make([]string, 0, len(f.symbols))
+	for name := range f.symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *FakeExchange) sortedSpotOrderIDs() []int64 {
+	ids := make([]int64, 0, len(f.spotOrders))
+	for id := range f.spotOrders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (f *FakeExchange) sortedFuturesOrderIDs() []int64 {
+	ids := make([]int64, 0, len(f.futuresOrders))
+	for id := range f.futuresOrders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (f *FakeExchange) sortedPositionKeys() []string {
+	keys := make([]string, 0, len(f.positions))
+	for key, pos := range f.positions {
+		if pos.amount != 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isFakeSpotOpen(order *binance.Order) bool {
+	return order.Status == binance.OrderStatusTypeNew || order.Status == binance.OrderStatusTypePartiallyFilled
+}
+
+func isFakeFuturesOpen(order *futures.Order) bool {
+	return order.Status == futures.OrderStatusTypeNew || order.Status == futures.OrderStatusTypePartiallyFilled
+}
+
+// fakeFilters 生成与币安格式一致的交易规则过滤器
+func fakeFilters(s FakeSymbol) []map[string]interface{} {
+	return []map[string]interface{}{
+		{"filterType": "PRICE_FILTER", "tickSize": formatFakeFloat(s.TickSize), "minPrice": formatFakeFloat(s.TickSize), "maxPrice": "1000000"},
+		{"filterType": "LOT_SIZE", "stepSize": formatFakeFloat(s.StepSize), "minQty": formatFakeFloat(s.MinQty), "maxQty": "9000000"},
+		{"filterType": "NOTIONAL", "minNotional": formatFakeFloat(s.MinNotional)},
+	}
+}
+
+// fakeLiquidationPrice 按逐仓公式粗略估算强平价格
+func fakeLiquidationPrice(pos *fakePosition, leverage int) float64 {
+	if pos.amount == 0 || leverage <= 0 {
+		return 0
+	}
+	if pos.amount > 0 {
+		return pos.entryPrice * (1 - 1/float64(leverage))
+	}
+	return pos.entryPrice * (1 + 1/float64(leverage))
+}
+
+func fakePrecision(step float64) int {
+	if step <= 0 || step >= 1 {
+		return 0
+	}
+	return int(math.Round(-math.Log10(step)))
+}
+
+func splitSymbol(symbol string) (string, string) {
+	for _, quote := range []string{"USDT", "BUSD", "USDC", "BTC", "ETH", "BNB"} {
+		if strings.HasSuffix(symbol, quote) && len(symbol) > len(quote) {
+			return strings.TrimSuffix(symbol, quote), quote
+		}
+	}
+	return symbol, ""
+}
+
+func formatFakeFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func fakeOrderNotFound() error {
+	return &common.APIError{Code: -2013, Message: "Order does not exist."}
+}
+
+func fakeInvalidSymbol() error {
+	return &common.APIError{Code: -1121, Message: "Invalid symbol."}
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func newTestFakeExchange() *FakeExchange {
+	f := NewFakeExchange()
+	f.AddSymbol(FakeSymbol{Symbol: "BTCUSDT", TickSize: 0.01, StepSize: 0.001, MinQty: 0.001}, 100)
+	f.SetBalance("USDT", 1000)
+	f.SetFuturesBalance(1000)
+	return f
+}
+
+func fakeBalance(t *testing.T, f *FakeExchange, asset string) float64 {
+	t.Helper()
+	account, err := f.GetAccount(context.Background())
+	if err != nil {
+		t.Fatalf("GetAccount: %v", err)
+	}
+	for _, b := range account.Balances {
+		if b.Asset == asset {
+			return parseTestFloat(t, b.Free) + parseTestFloat(t, b.Locked)
+		}
+	}
+	return 0
+}
+
+func parseTestFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatalf("解析 %q 失败: %v", s, err)
+	}
+	return v
+}
+
+func TestFakeExchangeSpotLimitOrderFillsWhenPriceCrosses(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	resp, err := f.CreateOrder(ctx, SpotOrderRequest{
+		Symbol:      "BTCUSDT",
+		Side:        binance.SideTypeBuy,
+		Type:        binance.OrderTypeLimit,
+		TimeInForce: binance.TimeInForceTypeGTC,
+		Quantity:    "2",
+		Price:       "99",
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if resp.Status != binance.OrderStatusTypeNew {
+		t.Fatalf("未穿价的限价单状态 = %s, want NEW", resp.Status)
+	}
+
+	f.SetPrice("BTCUSDT", 99.5)
+	order, _ := f.GetOrder(ctx, "BTCUSDT", resp.OrderID)
+	if order.Status != binance.OrderStatusTypeNew {
+		t.Fatalf("价格未到挂单价时状态 = %s, want NEW", order.Status)
+	}
+
+	f.SetPrice("BTCUSDT", 98.5)
+	order, _ = f.GetOrder(ctx, "BTCUSDT", resp.OrderID)
+	if order.Status != binance.OrderStatusTypeFilled || order.ExecutedQuantity != "2" {
+		t.Fatalf("穿价后订单 = %s/%s, want FILLED/2", order.Status, order.ExecutedQuantity)
+	}
+
+	// 以挂单价成交：2×99=198，手续费0.198
+	if got := fakeBalance(t, f, "BTC"); got != 2 {
+		t.Errorf("BTC 余额 = %v, want 2", got)
+	}
+	if got := fakeBalance(t, f, "USDT"); got != 1000-198-0.198 {
+		t.Errorf("USDT 余额 = %v, want %v", got, 1000-198-0.198)
+	}
+
+	trades, err := f.ListOrderTrades(ctx, "BTCUSDT", resp.OrderID)
+	if err != nil || len(trades) != 1 {
+		t.Fatalf("ListOrderTrades = %d 条, %v", len(trades), err)
+	}
+	if trades[0].Price != "99" || !trades[0].IsBuyer || !trades[0].IsMaker {
+		t.Errorf("成交记录 = %+v", trades[0])
+	}
+}
+
+func TestFakeExchangeSpotMarketOrderFillsAtTouch(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	resp, err := f.CreateOrder(ctx, SpotOrderRequest{
+		Symbol:   "BTCUSDT",
+		Side:     binance.SideTypeBuy,
+		Type:     binance.OrderTypeMarket,
+		Quantity: "1",
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if resp.Status != binance.OrderStatusTypeFilled {
+		t.Fatalf("市价单状态 = %s, want FILLED", resp.Status)
+	}
+	trades, _ := f.ListOrderTrades(ctx, "BTCUSDT", resp.OrderID)
+	if len(trades) != 1 || trades[0].Price != "100.01" {
+		t.Fatalf("市价买单应以卖一价 100.01 成交，得到 %+v", trades)
+	}
+}
+
+func TestFakeExchangeRejectsInvalidOrders(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	_, err := f.CreateOrder(ctx, SpotOrderRequest{Symbol: "BTCUSDT", Side: binance.SideTypeBuy, Type: binance.OrderTypeLimit, Quantity: "0.0001", Price: "99"})
+	if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != -1013 {
+		t.Errorf("数量低于最小值应返回 LOT_SIZE 错误，得到 %v", err)
+	}
+	_, err = f.CreateOrder(ctx, SpotOrderRequest{Symbol: "ETHUSDT", Side: binance.SideTypeBuy, Type: binance.OrderTypeMarket, Quantity: "1"})
+	if apiErr, ok := err.(*common.APIError); !ok || apiErr.Code != -1121 {
+		t.Errorf("未知交易对应返回 Invalid symbol，得到 %v", err)
+	}
+}
+
+func TestFakeExchangeCancelOrder(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	resp, err := f.CreateOrder(ctx, SpotOrderRequest{Symbol: "BTCUSDT", Side: binance.SideTypeBuy, Type: binance.OrderTypeLimit, Quantity: "1", Price: "95"})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	open, _ := f.ListOpenOrders(ctx, "BTCUSDT")
+	if len(open) != 1 {
+		t.Fatalf("挂单数 = %d, want 1", len(open))
+	}
+
+	if err := f.CancelOrder(ctx, "BTCUSDT", resp.OrderID); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	order, _ := f.GetOrder(ctx, "BTCUSDT", resp.OrderID)
+	if order.Status != binance.OrderStatusTypeCanceled {
+		t.Fatalf("撤单后状态 = %s, want CANCELED", order.Status)
+	}
+	if open, _ := f.ListOpenOrders(ctx, "BTCUSDT"); len(open) != 0 {
+		t.Errorf("撤单后挂单数 = %d, want 0", len(open))
+	}
+
+	// 已撤销的订单不再撮合，也不能重复撤销
+	f.SetPrice("BTCUSDT", 90)
+	if order, _ := f.GetOrder(ctx, "BTCUSDT", resp.OrderID); order.Status != binance.OrderStatusTypeCanceled {
+		t.Errorf("已撤销订单被撮合，状态 = %s", order.Status)
+	}
+	if err := f.CancelOrder(ctx, "BTCUSDT", resp.OrderID); err == nil {
+		t.Error("重复撤单应返回订单不存在")
+	}
+}
+
+func TestFakeExchangeFuturesEntryAndStopLoss(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	entry, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol:       "BTCUSDT",
+		Side:         futures.SideTypeBuy,
+		PositionSide: futures.PositionSideTypeLong,
+		Type:         futures.OrderTypeLimit,
+		TimeInForce:  futures.TimeInForceTypeGTC,
+		Quantity:     "0.5",
+		Price:        "98",
+	})
+	if err != nil {
+		t.Fatalf("CreateFuturesOrder: %v", err)
+	}
+	f.SetPrice("BTCUSDT", 97.5)
+	order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", entry.OrderID)
+	if order.Status != futures.OrderStatusTypeFilled || order.AvgPrice != "98" {
+		t.Fatalf("开仓单 = %s @ %s, want FILLED @ 98", order.Status, order.AvgPrice)
+	}
+
+	positions, _ := f.GetPositionRisk(ctx, "BTCUSDT")
+	if len(positions) != 1 || positions[0].PositionAmt != "0.5" || positions[0].EntryPrice != "98" {
+		t.Fatalf("开仓后持仓 = %+v", positions)
+	}
+
+	stop, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol:       "BTCUSDT",
+		Side:         futures.SideTypeSell,
+		PositionSide: futures.PositionSideTypeLong,
+		Type:         futures.OrderTypeStopMarket,
+		StopPrice:    "95",
+		Quantity:     "0.5",
+	})
+	if err != nil {
+		t.Fatalf("创建止损单: %v", err)
+	}
+	f.SetPrice("BTCUSDT", 96)
+	if order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", stop.OrderID); order.Status != futures.OrderStatusTypeNew {
+		t.Fatalf("未到止损价时状态 = %s, want NEW", order.Status)
+	}
+	f.SetPrice("BTCUSDT", 94)
+	if order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", stop.OrderID); order.Status != futures.OrderStatusTypeFilled || order.AvgPrice != "95" {
+		t.Fatalf("止损单 = %s @ %s, want FILLED @ 95", order.Status, order.AvgPrice)
+	}
+	// 已平仓的持仓不再返回
+	if positions, _ = f.GetPositionRisk(ctx, "BTCUSDT"); len(positions) != 0 {
+		t.Fatalf("止损后持仓 = %+v", positions)
+	}
+}
+
+func TestFakeExchangeCancelFuturesOrder(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	resp, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol:       "BTCUSDT",
+		Side:         futures.SideTypeSell,
+		PositionSide: futures.PositionSideTypeShort,
+		Type:         futures.OrderTypeLimit,
+		TimeInForce:  futures.TimeInForceTypeGTC,
+		Quantity:     "1",
+		Price:        "105",
+	})
+	if err != nil {
+		t.Fatalf("CreateFuturesOrder: %v", err)
+	}
+	if err := f.CancelFuturesOrder(ctx, "BTCUSDT", resp.OrderID); err != nil {
+		t.Fatalf("CancelFuturesOrder: %v", err)
+	}
+	f.SetPrice("BTCUSDT", 106)
+	order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", resp.OrderID)
+	if order.Status != futures.OrderStatusTypeCanceled {
+		t.Fatalf("撤单后状态 = %s, want CANCELED", order.Status)
+	}
+	if open, _ := f.ListFuturesOpenOrders(ctx, "BTCUSDT"); len(open) != 0 {
+		t.Errorf("撤单后挂单数 = %d, want 0", len(open))
+	}
+	if positions, _ := f.GetPositionRisk(ctx, "BTCUSDT"); len(positions) != 0 {
+		t.Errorf("撤销的订单不应产生持仓: %+v", positions)
+	}
+}
+
+func TestFakeExchangeTrailingStop(t *testing.T) {
+	f := newTestFakeExchange()
+	ctx := context.Background()
+
+	if _, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol:       "BTCUSDT",
+		Side:         futures.SideTypeBuy,
+		PositionSide: futures.PositionSideTypeLong,
+		Type:         futures.OrderTypeMarket,
+		Quantity:     "1",
+	}); err != nil {
+		t.Fatalf("市价开仓: %v", err)
+	}
+
+	// 回调率超出币安允许范围
+	if _, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol: "BTCUSDT", Side: futures.SideTypeSell, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeTrailingStopMarket, Quantity: "1", CallbackRate: "20",
+	}); err == nil {
+		t.Fatal("回调率20%应被拒绝")
+	}
+
+	trailing, err := f.CreateFuturesOrder(ctx, FuturesOrderRequest{
+		Symbol: "BTCUSDT", Side: futures.SideTypeSell, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeTrailingStopMarket, Quantity: "1", CallbackRate: "1",
+	})
+	if err != nil {
+		t.Fatalf("创建跟踪止损单: %v", err)
+	}
+
+	// 最高价涨到110，止损价跟随到108.9；回落到109不触发，跌破108.9触发
+	f.SetPrice("BTCUSDT", 110)
+	f.SetPrice("BTCUSDT", 109)
+	if order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", trailing.OrderID); order.Status != futures.OrderStatusTypeNew {
+		t.Fatalf("回调不足时状态 = %s, want NEW", order.Status)
+	}
+	f.SetPrice("BTCUSDT", 108.5)
+	order, _ := f.GetFuturesOrder(ctx, "BTCUSDT", trailing.OrderID)
+	if order.Status != futures.OrderStatusTypeFilled || order.AvgPrice != "108.9" {
+		t.Fatalf("跟踪止损单 = %s @ %s, want FILLED @ 108.9", order.Status, order.AvgPrice)
+	}
+}
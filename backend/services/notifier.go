@@ -0,0 +1,280 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 通知渠道请求超时
+const notifierTimeout = 10 * time.Second
+
+// Notification 投递到各渠道的通知内容
+type Notification struct {
+	Event   string                 `json:"event"`
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// Text 纯文本格式，用于Telegram和邮件正文
+func (n Notification) Text() string {
+	return fmt.Sprintf("%s\n\n%s\n\n%s", n.Title, n.Message, n.Time.Format("2006-01-02 15:04:05"))
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+var notifierClient = &http.Client{Timeout: notifierTimeout}
+
+// webhookClient 请求用户配置的Webhook地址，连接时检查解析出的IP，
+// 拒绝访问本机和内网地址（包括DNS重绑定和重定向到内网的情况）
+var webhookClient = &http.Client{
+	Timeout: notifierTimeout,
+	Transport: &http.Transport{
+		Proxy: nil, // 走代理时连接的是代理地址，无法检查目标IP
+		DialContext: (&net.Dialer{
+			Timeout: notifierTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+					return fmt.Errorf("不允许访问内网地址 %s", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: notifierTimeout,
+	},
+}
+
+// isInternalIP 本机、内网、链路本地、未指定和组播地址
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// ValidateWebhookURL 检查Webhook地址：只允许 http/https，且域名解析出的地址都不能是本机或内网
+// 发送时连接阶段会再次检查，避免保存后域名改为解析到内网
+func ValidateWebhookURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("Webhook地址无效")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("解析Webhook域名失败: %v", err)
+	}
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("Webhook地址不能指向本机或内网地址")
+		}
+	}
+	return nil
+}
+
+// NotifierConfig 系统通知渠道配置，Telegram机器人和SMTP服务器由所有用户共用
+type NotifierConfig struct {
+	TelegramBotToken string
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+}
+
+var (
+	notifierConfigMu sync.RWMutex
+	notifierConfig   NotifierConfig
+)
+
+// SetNotifierConfig 设置系统通知渠道，启动时由配置调用
+func SetNotifierConfig(cfg NotifierConfig) {
+	notifierConfigMu.Lock()
+	defer notifierConfigMu.Unlock()
+	notifierConfig = cfg
+}
+
+func currentNotifierConfig() NotifierConfig {
+	notifierConfigMu.RLock()
+	defer notifierConfigMu.RUnlock()
+	return notifierConfig
+}
+
+// TelegramNotifier 通过系统 Telegram 机器人发送消息，机器人令牌来自配置 notify.telegram_bot_token
+type TelegramNotifier struct {
+	Token  string
+	ChatID string
+}
+
+// NewTelegramNotifier 创建 Telegram 通知渠道
+func NewTelegramNotifier(chatID string) (*TelegramNotifier, error) {
+	token := currentNotifierConfig().TelegramBotToken
+	if token == "" {
+		return nil, fmt.Errorf("未配置 TELEGRAM_BOT_TOKEN")
+	}
+	return &TelegramNotifier{Token: token, ChatID: chatID}, nil
+}
+
+// Send 发送 Telegram 消息
+func (t *TelegramNotifier) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  t.ChatID,
+		"text":                     n.Text(),
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.Token)
+	return postNotification(ctx, notifierClient, endpoint, body, nil)
+}
+
+// EmailNotifier 通过 SMTP 发送邮件，服务器配置来自配置 notify.smtp_*
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       string
+}
+
+// NewEmailNotifier 创建邮件通知渠道
+func NewEmailNotifier(to string) (*EmailNotifier, error) {
+	cfg := currentNotifierConfig()
+	if cfg.SMTPHost == "" {
+		return nil, fmt.Errorf("未配置 SMTP_HOST")
+	}
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = cfg.SMTPUsername
+	}
+	return &EmailNotifier{
+		Host:     cfg.SMTPHost,
+		Port:     port,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     from,
+		To:       to,
+	}, nil
+}
+
+// Send 发送邮件，net/smtp 不支持 context，由连接超时兜底
+func (e *EmailNotifier) Send(ctx context.Context, n Notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", e.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Title)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, e.From, []string{e.To}, msg.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("发送邮件超时: %v", ctx.Err())
+	}
+}
+
+// WebhookNotifier 向用户配置的地址推送签名的JSON
+// 签名为 HMAC-SHA256(secret, timestamp + "." + body)，通过 X-Signature 和 X-Timestamp 头传递
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+}
+
+// NewWebhookNotifier 创建 Webhook 通知渠道
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Secret: secret}
+}
+
+// Send 推送 Webhook
+func (w *WebhookNotifier) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{
+		"X-Event":     n.Event,
+		"X-Timestamp": timestamp,
+	}
+	if w.Secret != "" {
+		headers["X-Signature"] = SignWebhook(w.Secret, timestamp, body)
+	}
+	return postNotification(ctx, webhookClient, w.URL, body, headers)
+}
+
+// SignWebhook 计算 Webhook 签名，接收方可用同样方式校验
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postNotification 发送JSON请求，非2xx响应视为失败
+// 错误只包含状态码和底层网络错误，不带响应内容和请求地址（地址可能含机器人令牌），会展示给用户
+func postNotification(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
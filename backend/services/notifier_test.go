@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateWebhookURLRejectsInternalAddresses(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+		"ftp://8.8.8.8/hook",
+	} {
+		if err := ValidateWebhookURL(context.Background(), rawURL); err == nil {
+			t.Errorf("%s 应被拒绝", rawURL)
+		}
+	}
+	if err := ValidateWebhookURL(context.Background(), "https://8.8.8.8/hook"); err != nil {
+		t.Errorf("公网地址不应被拒绝: %v", err)
+	}
+}
+
+func TestWebhookRefusesLoopbackAndHidesResponseBody(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		http.Error(w, "secret internal data", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL, "").Send(context.Background(), Notification{Event: "test"})
+	if err == nil || called {
+		t.Fatalf("应拒绝连接本机地址，err=%v called=%v", err, called)
+	}
+
+	// 用不检查地址的客户端连接测试服务器，检查失败时不返回响应内容
+	err = postNotification(context.Background(), server.Client(), server.URL, []byte("{}"), nil)
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("错误不应包含响应内容: %v", err)
+	}
+}
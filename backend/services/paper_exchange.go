@@ -0,0 +1,922 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PaperMarketSpot    = "spot"
+	PaperMarketFutures = "futures"
+
+	// PaperInitialBalance 模拟盘初始资金（USDT），现货与合约账户各一份
+	PaperInitialBalance = 10000.0
+	// PaperCommissionRate 模拟盘手续费率，按成交额收取
+	PaperCommissionRate = 0.001
+)
+
+// PaperExchange 模拟盘交易所
+//
+// 深度、交易规则、价格等行情数据仍从真实交易所获取，订单只写入 paper_orders 表，
+// 由价格推送驱动 MatchPaperOrders 撮合成交。账户余额和合约持仓都根据模拟成交计算，
+// 所有数据都落库，服务重启后模拟盘可以继续运行。
+type PaperExchange struct {
+	db     *gorm.DB
+	userID uint
+	market Exchange // 行情数据来源
+}
+
+// NewPaperExchange 创建模拟盘交易所实例，market 仅用于获取行情，不会用它下单
+func NewPaperExchange(db *gorm.DB, userID uint, market Exchange) *PaperExchange {
+	return &PaperExchange{db: db, userID: userID, market: market}
+}
+
+// NewUserPaperExchange 创建用户的模拟盘交易所，行情走公共接口，不需要API密钥
+func NewUserPaperExchange(db *gorm.DB, userID uint) Exchange {
+	return NewPaperExchange(db, userID, NewExchange("", ""))
+}
+
+// NewTradingExchange 根据模拟盘标记创建用户下单用的交易所实例，模拟盘按用户记账，不区分交易所账户
+func NewTradingExchange(db *gorm.DB, userID, accountID uint, paper bool) (Exchange, error) {
+	if paper {
+		return NewUserPaperExchange(db, userID), nil
+	}
+	return NewUserExchange(db, userID, accountID)
+}
+
+// IsPaperExchange 判断交易所实例是否为模拟盘
+func IsPaperExchange(exchange Exchange) bool {
+	_, ok := exchange.(*PaperExchange)
+	return ok
+}
+
+// ==================== 现货 ====================
+
+func (p *PaperExchange) GetAccount(ctx context.Context) (*binance.Account, error) {
+	var orders []models.PaperOrder
+	if err := p.db.WithContext(ctx).
+		Where("user_id = ? AND market = ? AND status IN ?", p.userID, PaperMarketSpot, []string{"NEW", "FILLED"}).
+		Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟盘订单失败: %v", err)
+	}
+
+	balances := map[string]decimal.Decimal{"USDT": decimal.NewFromFloat(PaperInitialBalance)}
+	locked := make(map[string]decimal.Decimal)
+	for _, order := range orders {
+		base, quote := splitSymbol(order.Symbol)
+		if order.Status == "NEW" {
+			// 挂单冻结资产
+			if order.Side == "BUY" {
+				locked[quote] = locked[quote].Add(order.Price.Mul(order.Quantity))
+			} else {
+				locked[base] = locked[base].Add(order.Quantity)
+			}
+			continue
+		}
+		quoteAmount := order.AvgPrice.Mul(order.ExecutedQty)
+		if order.Side == "BUY" {
+			balances[base] = balances[base].Add(order.ExecutedQty)
+			balances[quote] = balances[quote].Sub(quoteAmount.Add(order.Commission))
+		} else {
+			balances[base] = balances[base].Sub(order.ExecutedQty)
+			balances[quote] = balances[quote].Add(quoteAmount.Sub(order.Commission))
+		}
+	}
+
+	assets := make([]string, 0, len(balances))
+	for asset := range balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	account := &binance.Account{CanTrade: true, AccountType: "SPOT", UpdateTime: uint64(time.Now().UnixMilli())}
+	for _, asset := range assets {
+		account.Balances = append(account.Balances, binance.Balance{
+			Asset:  asset,
+			Free:   balances[asset].Sub(locked[asset]).String(),
+			Locked: locked[asset].String(),
+		})
+	}
+	return account, nil
+}
+
+func (p *PaperExchange) ListPrices(ctx context.Context, symbol string) ([]*binance.SymbolPrice, error) {
+	return p.market.ListPrices(ctx, symbol)
+}
+
+func (p *PaperExchange) GetDepth(ctx context.Context, symbol string, limit int) (*binance.DepthResponse, error) {
+	return p.market.GetDepth(ctx, symbol, limit)
+}
+
+func (p *PaperExchange) ListKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*binance.Kline, error) {
+	return p.market.ListKlines(ctx, symbol, interval, startTime, endTime, limit)
+}
+
+func (p *PaperExchange) GetExchangeInfo(ctx context.Context, symbol string) (*binance.ExchangeInfo, error) {
+	return p.market.GetExchangeInfo(ctx, symbol)
+}
+
+func (p *PaperExchange) CreateOrder(ctx context.Context, req SpotOrderRequest) (*binance.CreateOrderResponse, error) {
+	order, err := p.newOrder(PaperMarketSpot, req.Symbol, string(req.Side), "", string(req.Type),
+		string(req.TimeInForce), req.Quantity, req.Price, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// 市价单以对手方一档成交，穿价限价单以对手方一档立即成交
+	if order.Type == string(binance.OrderTypeMarket) || order.Type == string(binance.OrderTypeLimit) {
+		depth, err := p.market.GetDepth(ctx, req.Symbol, 5)
+		if err != nil && order.Type == string(binance.OrderTypeMarket) {
+			return nil, fmt.Errorf("获取深度失败: %v", err)
+		}
+		if err == nil {
+			if price, ok := takerPrice(order, depth.Bids, depth.Asks); ok {
+				if err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					return fillPaperOrder(tx, order, price)
+				}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return &binance.CreateOrderResponse{
+		Symbol:                   order.Symbol,
+		OrderID:                  int64(order.ID),
+		TransactTime:             order.CreatedAt.UnixMilli(),
+		Price:                    order.Price.String(),
+		OrigQuantity:             order.Quantity.String(),
+		ExecutedQuantity:         order.ExecutedQty.String(),
+		CummulativeQuoteQuantity: order.AvgPrice.Mul(order.ExecutedQty).String(),
+		Status:                   binance.OrderStatusType(order.Status),
+		TimeInForce:              req.TimeInForce,
+		Type:                     req.Type,
+		Side:                     req.Side,
+	}, nil
+}
+
+func (p *PaperExchange) GetOrder(ctx context.Context, symbol string, orderID int64) (*binance.Order, error) {
+	order, err := p.findOrder(ctx, PaperMarketSpot, symbol, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return toSpotOrder(order), nil
+}
+
+func (p *PaperExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	return p.cancelOrder(ctx, PaperMarketSpot, symbol, orderID)
+}
+
+func (p *PaperExchange) ListOpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error) {
+	query := p.db.WithContext(ctx).Where("user_id = ? AND market = ? AND status = ?", p.userID, PaperMarketSpot, "NEW")
+	if symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	var orders []models.PaperOrder
+	if err := query.Order("id").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟盘挂单失败: %v", err)
+	}
+
+	result := make([]*binance.Order, 0, len(orders))
+	for i := range orders {
+		result = append(result, toSpotOrder(&orders[i]))
+	}
+	return result, nil
+}
+
+func (p *PaperExchange) ListTrades(ctx context.Context, symbol string, startTime, endTime int64, limit int) ([]*binance.TradeV3, error) {
+	query := p.db.WithContext(ctx).
+		Where("user_id = ? AND market = ? AND symbol = ? AND status = ?", p.userID, PaperMarketSpot, symbol, "FILLED")
+	if startTime > 0 {
+		query = query.Where("filled_at >= ?", time.UnixMilli(startTime))
+	}
+	if endTime > 0 {
+		query = query.Where("filled_at <= ?", time.UnixMilli(endTime))
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var orders []models.PaperOrder
+	if err := query.Order("id").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟盘成交失败: %v", err)
+	}
+
+	result := make([]*binance.TradeV3, 0, len(orders))
+	for i := range orders {
+		result = append(result, toSpotTrade(&orders[i]))
+	}
+	return result, nil
+}
+
+func (p *PaperExchange) ListOrderTrades(ctx context.Context, symbol string, orderID int64) ([]*binance.TradeV3, error) {
+	order, err := p.findOrder(ctx, PaperMarketSpot, symbol, orderID)
+	if err != nil {
+		return nil, err
+	}
+	// 模拟撮合一次性全部成交，每个订单只有一条成交
+	if order.Status != "FILLED" {
+		return nil, nil
+	}
+	return []*binance.TradeV3{toSpotTrade(order)}, nil
+}
+
+// toSpotTrade 将已成交的模拟盘订单转换为成交记录，成交ID与订单号相同
+func toSpotTrade(order *models.PaperOrder) *binance.TradeV3 {
+	_, quote := splitSymbol(order.Symbol)
+	var tradeTime int64
+	if order.FilledAt != nil {
+		tradeTime = order.FilledAt.UnixMilli()
+	}
+	return &binance.TradeV3{
+		ID:              int64(order.ID),
+		Symbol:          order.Symbol,
+		OrderID:         int64(order.ID),
+		Price:           order.AvgPrice.String(),
+		Quantity:        order.ExecutedQty.String(),
+		QuoteQuantity:   order.AvgPrice.Mul(order.ExecutedQty).String(),
+		Commission:      order.Commission.String(),
+		CommissionAsset: quote,
+		Time:            tradeTime,
+		IsBuyer:         order.Side == "BUY",
+		IsMaker:         order.Type == string(binance.OrderTypeLimit),
+	}
+}
+
+// ==================== 提币 ====================
+
+func (p *PaperExchange) Withdraw(ctx context.Context, req WithdrawRequest) (*binance.CreateWithdrawResponse, error) {
+	return nil, fmt.Errorf("模拟盘模式不支持提币")
+}
+
+func (p *PaperExchange) ListWithdraws(ctx context.Context, startTime, endTime int64) ([]*binance.Withdraw, error) {
+	return []*binance.Withdraw{}, nil
+}
+
+// ==================== 永续合约 ====================
+
+func (p *PaperExchange) GetFuturesAccount(ctx context.Context) (*futures.Account, error) {
+	var summary struct {
+		RealizedPnl decimal.Decimal
+		Commission  decimal.Decimal
+	}
+	if err := p.db.WithContext(ctx).Model(&models.PaperOrder{}).
+		Select("COALESCE(SUM(realized_pnl), 0) AS realized_pnl, COALESCE(SUM(commission), 0) AS commission").
+		Where("user_id = ? AND market = ? AND status = ?", p.userID, PaperMarketFutures, "FILLED").
+		Scan(&summary).Error; err != nil {
+		return nil, fmt.Errorf("汇总模拟盘合约盈亏失败: %v", err)
+	}
+
+	positions, err := p.listPositions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	wallet := decimal.NewFromFloat(PaperInitialBalance).Add(summary.RealizedPnl).Sub(summary.Commission)
+	unrealized := decimal.Zero
+	account := &futures.Account{CanTrade: true, UpdateTime: time.Now().UnixMilli()}
+	for _, pos := range positions {
+		pnl := paperUnrealizedPnl(&pos)
+		unrealized = unrealized.Add(pnl)
+		account.Positions = append(account.Positions, &futures.AccountPosition{
+			Symbol:           pos.Symbol,
+			PositionSide:     futures.PositionSideType(pos.PositionSide),
+			PositionAmt:      paperSignedAmount(&pos).String(),
+			EntryPrice:       pos.EntryPrice.String(),
+			UnrealizedProfit: pnl.String(),
+			Leverage:         strconv.Itoa(pos.Leverage),
+			Isolated:         pos.MarginType == "ISOLATED",
+		})
+	}
+
+	account.TotalWalletBalance = wallet.String()
+	account.TotalUnrealizedProfit = unrealized.String()
+	account.TotalMarginBalance = wallet.Add(unrealized).String()
+	account.AvailableBalance = wallet.Add(unrealized).String()
+	account.Assets = []*futures.AccountAsset{{
+		Asset:            "USDT",
+		WalletBalance:    account.TotalWalletBalance,
+		UnrealizedProfit: account.TotalUnrealizedProfit,
+		MarginBalance:    account.TotalMarginBalance,
+		AvailableBalance: account.AvailableBalance,
+	}}
+	return account, nil
+}
+
+func (p *PaperExchange) GetFuturesExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	return p.market.GetFuturesExchangeInfo(ctx)
+}
+
+func (p *PaperExchange) GetFuturesDepth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	return p.market.GetFuturesDepth(ctx, symbol, limit)
+}
+
+func (p *PaperExchange) ListFuturesMarkPriceKlines(ctx context.Context, symbol, interval string, startTime, endTime int64, limit int) ([]*futures.Kline, error) {
+	return p.market.ListFuturesMarkPriceKlines(ctx, symbol, interval, startTime, endTime, limit)
+}
+
+func (p *PaperExchange) CreateFuturesOrder(ctx context.Context, req FuturesOrderRequest) (*futures.CreateOrderResponse, error) {
+	if req.Type == futures.OrderTypeTrailingStopMarket {
+		return p.createTrailingStopOrder(ctx, req)
+	}
+
+	order, err := p.newOrder(PaperMarketFutures, req.Symbol, string(req.Side), string(req.PositionSide),
+		string(req.Type), string(req.TimeInForce), req.Quantity, req.Price, req.StopPrice, req.ClientOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 条件单等待价格推送触发，市价单和穿价限价单立即成交
+	if order.Type == string(futures.OrderTypeMarket) || order.Type == string(futures.OrderTypeLimit) {
+		depth, err := p.market.GetFuturesDepth(ctx, req.Symbol, 5)
+		if err != nil && order.Type == string(futures.OrderTypeMarket) {
+			return nil, fmt.Errorf("获取深度失败: %v", err)
+		}
+		if err == nil {
+			if price, ok := takerPrice(order, depth.Bids, depth.Asks); ok {
+				if err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					return fillPaperOrder(tx, order, price)
+				}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return &futures.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          int64(order.ID),
+		Price:            order.Price.String(),
+		OrigQuantity:     order.Quantity.String(),
+		ExecutedQuantity: order.ExecutedQty.String(),
+		CumQuote:         order.AvgPrice.Mul(order.ExecutedQty).String(),
+		Status:           futures.OrderStatusType(order.Status),
+		StopPrice:        order.StopPrice.String(),
+		TimeInForce:      req.TimeInForce,
+		Type:             req.Type,
+		Side:             req.Side,
+		UpdateTime:       order.UpdatedAt.UnixMilli(),
+		AvgPrice:         order.AvgPrice.String(),
+		PositionSide:     req.PositionSide,
+		OrigType:         req.Type,
+	}, nil
+}
+
+// createTrailingStopOrder 创建模拟跟踪止损单，价格推送时由 MatchPaperOrders 跟踪极值并触发
+func (p *PaperExchange) createTrailingStopOrder(ctx context.Context, req FuturesOrderRequest) (*futures.CreateOrderResponse, error) {
+	quantity := parsePaperDecimal(req.Quantity)
+	activationPrice := parsePaperDecimal(req.ActivationPrice)
+	callbackRate, _ := strconv.ParseFloat(req.CallbackRate, 64)
+	if !quantity.IsPositive() {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}
+	}
+	if callbackRate < 0.1 || callbackRate > 10 {
+		return nil, &common.APIError{Code: -2007, Message: "Invalid callBackRate."}
+	}
+
+	order := &models.PaperOrder{
+		UserID:          p.userID,
+		Market:          PaperMarketFutures,
+		Symbol:          req.Symbol,
+		Side:            string(req.Side),
+		PositionSide:    string(req.PositionSide),
+		Type:            string(req.Type),
+		ActivationPrice: activationPrice,
+		CallbackRate:    callbackRate,
+		Quantity:        quantity,
+		Status:          "NEW",
+	}
+	if err := p.db.WithContext(ctx).Create(order).Error; err != nil {
+		return nil, fmt.Errorf("保存模拟盘订单失败: %v", err)
+	}
+
+	return &futures.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          int64(order.ID),
+		OrigQuantity:     order.Quantity.String(),
+		ExecutedQuantity: "0",
+		Status:           futures.OrderStatusType(order.Status),
+		Type:             req.Type,
+		Side:             req.Side,
+		UpdateTime:       order.UpdatedAt.UnixMilli(),
+		PositionSide:     req.PositionSide,
+		OrigType:         req.Type,
+		ActivatePrice:    req.ActivationPrice,
+		PriceRate:        req.CallbackRate,
+	}, nil
+}
+
+func (p *PaperExchange) GetFuturesOrder(ctx context.Context, symbol string, orderID int64) (*futures.Order, error) {
+	order, err := p.findOrder(ctx, PaperMarketFutures, symbol, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return toFuturesOrder(order), nil
+}
+
+func (p *PaperExchange) CancelFuturesOrder(ctx context.Context, symbol string, orderID int64) error {
+	return p.cancelOrder(ctx, PaperMarketFutures, symbol, orderID)
+}
+
+func (p *PaperExchange) ListFuturesOpenOrders(ctx context.Context, symbol string) ([]*futures.Order, error) {
+	query := p.db.WithContext(ctx).Where("user_id = ? AND market = ? AND status = ?", p.userID, PaperMarketFutures, "NEW")
+	if symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	var orders []models.PaperOrder
+	if err := query.Order("id").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟盘挂单失败: %v", err)
+	}
+
+	result := make([]*futures.Order, 0, len(orders))
+	for i := range orders {
+		result = append(result, toFuturesOrder(&orders[i]))
+	}
+	return result, nil
+}
+
+func (p *PaperExchange) ListFuturesOrders(ctx context.Context, symbol string, startTime int64) ([]*futures.Order, error) {
+	query := p.db.WithContext(ctx).Where("user_id = ? AND market = ? AND symbol = ?", p.userID, PaperMarketFutures, symbol)
+	if startTime > 0 {
+		query = query.Where("created_at >= ?", time.UnixMilli(startTime))
+	}
+	var orders []models.PaperOrder
+	if err := query.Order("id").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟盘订单失败: %v", err)
+	}
+
+	result := make([]*futures.Order, 0, len(orders))
+	for i := range orders {
+		result = append(result, toFuturesOrder(&orders[i]))
+	}
+	return result, nil
+}
+
+func (p *PaperExchange) GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error) {
+	positions, err := p.listPositions(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*futures.PositionRisk, 0, len(positions))
+	for _, pos := range positions {
+		marginType := "cross"
+		if pos.MarginType == "ISOLATED" {
+			marginType = "isolated"
+		}
+		signed := paperSignedAmount(&pos)
+		result = append(result, &futures.PositionRisk{
+			Symbol:           pos.Symbol,
+			PositionSide:     pos.PositionSide,
+			PositionAmt:      signed.String(),
+			EntryPrice:       pos.EntryPrice.String(),
+			MarkPrice:        pos.MarkPrice.String(),
+			UnRealizedProfit: paperUnrealizedPnl(&pos).String(),
+			LiquidationPrice: formatFakeFloat(fakeLiquidationPrice(&fakePosition{amount: signed.InexactFloat64(), entryPrice: pos.EntryPrice.InexactFloat64()}, pos.Leverage)),
+			Leverage:         strconv.Itoa(pos.Leverage),
+			MarginType:       marginType,
+			Notional:         pos.MarkPrice.Mul(signed).String(),
+		})
+	}
+	return result, nil
+}
+
+func (p *PaperExchange) ChangeLeverage(ctx context.Context, symbol string, leverage int) error {
+	if leverage < 1 || leverage > 125 {
+		return &common.APIError{Code: -4028, Message: "Leverage is not valid"}
+	}
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, side := range []string{"LONG", "SHORT"} {
+			pos, err := lockPaperPosition(tx, p.userID, symbol, side)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(pos).Update("leverage", leverage).Error; err != nil {
+				return fmt.Errorf("更新模拟盘杠杆失败: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (p *PaperExchange) ChangeMarginType(ctx context.Context, symbol string, marginType string) error {
+	unchanged := false
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, side := range []string{"LONG", "SHORT"} {
+			pos, err := lockPaperPosition(tx, p.userID, symbol, side)
+			if err != nil {
+				return err
+			}
+			if pos.MarginType == marginType {
+				unchanged = true
+				continue
+			}
+			if err := tx.Model(pos).Update("margin_type", marginType).Error; err != nil {
+				return fmt.Errorf("更新模拟盘保证金模式失败: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		// 与币安保持一致，调用方会忽略该错误
+		return &common.APIError{Code: -4046, Message: "No need to change margin type."}
+	}
+	return nil
+}
+
+// ==================== 双币投资 ====================
+
+func (p *PaperExchange) ListDCIProducts(ctx context.Context, optionType, investCoin, exercisedCoin string) ([]DCIProductListItem, error) {
+	return p.market.ListDCIProducts(ctx, optionType, investCoin, exercisedCoin)
+}
+
+func (p *PaperExchange) SubscribeDCIProduct(ctx context.Context, req DCISubscribeRequest) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("模拟盘模式不支持双币投资申购")
+}
+
+func (p *PaperExchange) GetDCIPositions(ctx context.Context) ([]DCIPositionItem, error) {
+	return []DCIPositionItem{}, nil
+}
+
+// ==================== 撮合 ====================
+
+// MatchPaperOrders 用一段时间内的价格区间撮合模拟盘挂单
+//
+// low/high 为上次撮合以来的最低价和最高价，避免限流期间错过瞬间穿价；last 为最新价格，
+// 用于更新模拟持仓的标记价格。限价单以挂单价成交，止盈止损单以触发价成交。
+func MatchPaperOrders(db *gorm.DB, market, symbol string, lowPrice, highPrice, lastPrice float64) error {
+	low, high, last := decimal.NewFromFloat(lowPrice), decimal.NewFromFloat(highPrice), decimal.NewFromFloat(lastPrice)
+	if market == PaperMarketFutures && last.IsPositive() {
+		if err := db.Model(&models.PaperPosition{}).
+			Where("symbol = ?", symbol).
+			Update("mark_price", last).Error; err != nil {
+			return fmt.Errorf("更新模拟持仓标记价格失败: %v", err)
+		}
+	}
+
+	var orders []models.PaperOrder
+	if err := db.Where("market = ? AND symbol = ? AND status = ?", market, symbol, "NEW").
+		Order("id").Find(&orders).Error; err != nil {
+		return fmt.Errorf("查询模拟盘挂单失败: %v", err)
+	}
+
+	for i := range orders {
+		order := &orders[i]
+		price, ok := triggerPrice(order, low, high)
+		if order.Type == "TRAILING_STOP_MARKET" {
+			extreme, stop, triggered := trailingStopTrigger(order.Side == "SELL",
+				order.ActivationPrice, order.CallbackRate, order.HighWaterMark, low, high)
+			if !triggered && !extreme.Equal(order.HighWaterMark) {
+				if err := db.Model(order).Updates(map[string]interface{}{
+					"high_water_mark": extreme,
+					"stop_price":      stop,
+				}).Error; err != nil {
+					return fmt.Errorf("更新模拟盘跟踪止损失败: %v", err)
+				}
+			}
+			price, ok = stop, triggered
+		}
+		if !ok {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			// 加锁后重新检查状态，避免与撤单或其他撮合并发
+			var current models.PaperOrder
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, order.ID).Error; err != nil {
+				return err
+			}
+			if current.Status != "NEW" {
+				return nil
+			}
+			return fillPaperOrder(tx, &current, price)
+		}); err != nil {
+			return fmt.Errorf("模拟盘订单 %d 成交失败: %v", order.ID, err)
+		}
+	}
+	return nil
+}
+
+// PaperOpenSymbols 返回有模拟盘挂单的交易对，价格监控需要为它们保持推送
+func PaperOpenSymbols(db *gorm.DB, market string) ([]string, error) {
+	var symbols []string
+	err := db.Model(&models.PaperOrder{}).
+		Where("market = ? AND status = ?", market, "NEW").
+		Distinct().Pluck("symbol", &symbols).Error
+	return symbols, err
+}
+
+// triggerPrice 判断挂单在价格区间内是否成交，返回成交价
+func triggerPrice(order *models.PaperOrder, low, high decimal.Decimal) (decimal.Decimal, bool) {
+	switch order.Type {
+	case "LIMIT":
+		if (order.Side == "BUY" && low.LessThanOrEqual(order.Price)) || (order.Side == "SELL" && high.GreaterThanOrEqual(order.Price)) {
+			return order.Price, true
+		}
+	case "STOP_MARKET", "STOP_LOSS":
+		// 止损：卖单在价格跌破时触发，买单在价格涨破时触发
+		if (order.Side == "SELL" && low.LessThanOrEqual(order.StopPrice)) || (order.Side == "BUY" && high.GreaterThanOrEqual(order.StopPrice)) {
+			return order.StopPrice, true
+		}
+	case "TAKE_PROFIT_MARKET", "TAKE_PROFIT":
+		if (order.Side == "SELL" && high.GreaterThanOrEqual(order.StopPrice)) || (order.Side == "BUY" && low.LessThanOrEqual(order.StopPrice)) {
+			return order.StopPrice, true
+		}
+	}
+	return decimal.Zero, false
+}
+
+// trailingStopTrigger 跟踪止损单撮合：卖单（平多）跟踪最高价，买单（平空）跟踪最低价。
+// 先用上次的极值判断本区间是否回调触发，再用本区间价格更新极值（区间内高低点先后未知，更新后不再判断触发）。
+// extreme 为0表示尚未激活，activation 为0时首次撮合即激活。返回新的极值、对应的止损价和是否触发。
+func trailingStopTrigger(sell bool, activation decimal.Decimal, callbackRate float64, extreme, low, high decimal.Decimal) (decimal.Decimal, decimal.Decimal, bool) {
+	rate := decimal.NewFromFloat(callbackRate).Div(decimal.NewFromInt(100))
+	stopFor := func(extreme decimal.Decimal) decimal.Decimal {
+		if sell {
+			return extreme.Mul(decimal.NewFromInt(1).Sub(rate))
+		}
+		return extreme.Mul(decimal.NewFromInt(1).Add(rate))
+	}
+
+	if extreme.IsPositive() {
+		stop := stopFor(extreme)
+		if (sell && low.LessThanOrEqual(stop)) || (!sell && high.GreaterThanOrEqual(stop)) {
+			return extreme, stop, true
+		}
+	}
+
+	switch {
+	case extreme.IsZero() && activation.IsPositive() && ((sell && high.LessThan(activation)) || (!sell && low.GreaterThan(activation))):
+		return decimal.Zero, decimal.Zero, false
+	case sell && high.GreaterThan(extreme):
+		extreme = high
+	case !sell && (extreme.IsZero() || low.LessThan(extreme)):
+		extreme = low
+	}
+	return extreme, stopFor(extreme), false
+}
+
+// takerPrice 市价单或穿价限价单的对手方一档价格
+func takerPrice(order *models.PaperOrder, bids []common.PriceLevel, asks []common.PriceLevel) (decimal.Decimal, bool) {
+	levels := asks
+	if order.Side == "SELL" {
+		levels = bids
+	}
+	if len(levels) == 0 {
+		return decimal.Zero, false
+	}
+	best, err := decimal.NewFromString(levels[0].Price)
+	if err != nil || !best.IsPositive() {
+		return decimal.Zero, false
+	}
+	if order.Type == "MARKET" {
+		return best, true
+	}
+	if order.Type == "LIMIT" &&
+		((order.Side == "BUY" && order.Price.GreaterThanOrEqual(best)) || (order.Side == "SELL" && order.Price.LessThanOrEqual(best))) {
+		return best, true
+	}
+	return decimal.Zero, false
+}
+
+// fillPaperOrder 以指定价格全部成交模拟盘订单，合约订单同时更新模拟持仓
+func fillPaperOrder(tx *gorm.DB, order *models.PaperOrder, price decimal.Decimal) error {
+	now := time.Now()
+	quantity := order.Quantity
+	realizedPnl := decimal.Zero
+	status := "FILLED"
+
+	if order.Market == PaperMarketFutures {
+		pos, err := lockPaperPosition(tx, order.UserID, order.Symbol, order.PositionSide)
+		if err != nil {
+			return err
+		}
+
+		opening := (order.PositionSide == "LONG") == (order.Side == "BUY")
+		if opening {
+			total := pos.Amount.Add(quantity)
+			pos.EntryPrice = pos.EntryPrice.Mul(pos.Amount).Add(price.Mul(quantity)).Div(total)
+			pos.Amount = total
+		} else {
+			// 平仓数量不能超过持仓，没有持仓时订单失效
+			quantity = decimal.Min(quantity, pos.Amount)
+			if !quantity.IsPositive() {
+				quantity = decimal.Zero
+				status = "EXPIRED"
+			} else {
+				realizedPnl = price.Sub(pos.EntryPrice).Mul(quantity)
+				if order.PositionSide == "SHORT" {
+					realizedPnl = realizedPnl.Neg()
+				}
+				pos.Amount = pos.Amount.Sub(quantity)
+				if !pos.Amount.IsPositive() {
+					pos.Amount = decimal.Zero
+					pos.EntryPrice = decimal.Zero
+				}
+			}
+		}
+		pos.MarkPrice = price
+		if err := tx.Save(pos).Error; err != nil {
+			return fmt.Errorf("更新模拟持仓失败: %v", err)
+		}
+	}
+
+	order.Status = status
+	order.ExecutedQty = quantity
+	order.RealizedPnl = realizedPnl
+	order.Commission = price.Mul(quantity).Mul(decimal.NewFromFloat(PaperCommissionRate))
+	if quantity.IsPositive() {
+		order.AvgPrice = price
+		order.FilledAt = &now
+	}
+	if err := tx.Model(order).Updates(map[string]interface{}{
+		"status":       order.Status,
+		"executed_qty": order.ExecutedQty,
+		"avg_price":    order.AvgPrice,
+		"commission":   order.Commission,
+		"realized_pnl": order.RealizedPnl,
+		"filled_at":    order.FilledAt,
+	}).Error; err != nil {
+		return fmt.Errorf("更新模拟盘订单失败: %v", err)
+	}
+	return nil
+}
+
+// lockPaperPosition 加锁读取模拟持仓，不存在时创建空持仓
+func lockPaperPosition(tx *gorm.DB, userID uint, symbol, positionSide string) (*models.PaperPosition, error) {
+	var pos models.PaperPosition
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND symbol = ? AND position_side = ?", userID, symbol, positionSide).
+		First(&pos).Error
+	if err == gorm.ErrRecordNotFound {
+		pos = models.PaperPosition{
+			UserID:       userID,
+			Symbol:       symbol,
+			PositionSide: positionSide,
+			Leverage:     20,
+			MarginType:   "CROSSED",
+		}
+		if err := tx.Create(&pos).Error; err != nil {
+			return nil, fmt.Errorf("创建模拟持仓失败: %v", err)
+		}
+		return &pos, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询模拟持仓失败: %v", err)
+	}
+	return &pos, nil
+}
+
+// newOrder 校验参数并写入一笔新的模拟盘订单
+func (p *PaperExchange) newOrder(market, symbol, side, positionSide, orderType, timeInForce, quantityStr, priceStr, stopPriceStr, clientOrderID string) (*models.PaperOrder, error) {
+	quantity := parsePaperDecimal(quantityStr)
+	price := parsePaperDecimal(priceStr)
+	stopPrice := parsePaperDecimal(stopPriceStr)
+	if !quantity.IsPositive() {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}
+	}
+	if orderType == "LIMIT" && !price.IsPositive() {
+		return nil, &common.APIError{Code: -1013, Message: "Filter failure: PRICE_FILTER"}
+	}
+	if orderType != "LIMIT" && orderType != "MARKET" && !stopPrice.IsPositive() {
+		return nil, &common.APIError{Code: -1102, Message: "Mandatory parameter 'stopPrice' was not sent, was empty/null, or malformed."}
+	}
+
+	order := &models.PaperOrder{
+		UserID:        p.userID,
+		Market:        market,
+		Symbol:        symbol,
+		Side:          side,
+		PositionSide:  positionSide,
+		Type:          orderType,
+		TimeInForce:   timeInForce,
+		Price:         price,
+		StopPrice:     stopPrice,
+		Quantity:      quantity,
+		Status:        "NEW",
+		ClientOrderID: clientOrderID,
+	}
+	if err := p.db.Create(order).Error; err != nil {
+		return nil, fmt.Errorf("保存模拟盘订单失败: %v", err)
+	}
+	return order, nil
+}
+
+func (p *PaperExchange) findOrder(ctx context.Context, market, symbol string, orderID int64) (*models.PaperOrder, error) {
+	var order models.PaperOrder
+	err := p.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND market = ? AND symbol = ?", orderID, p.userID, market, symbol).
+		First(&order).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, fakeOrderNotFound()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询模拟盘订单失败: %v", err)
+	}
+	return &order, nil
+}
+
+func (p *PaperExchange) cancelOrder(ctx context.Context, market, symbol string, orderID int64) error {
+	result := p.db.WithContext(ctx).Model(&models.PaperOrder{}).
+		Where("id = ? AND user_id = ? AND market = ? AND symbol = ? AND status = ?", orderID, p.userID, market, symbol, "NEW").
+		Update("status", "CANCELED")
+	if result.Error != nil {
+		return fmt.Errorf("撤销模拟盘订单失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fakeOrderNotFound()
+	}
+	return nil
+}
+
+func (p *PaperExchange) listPositions(ctx context.Context, symbol string) ([]models.PaperPosition, error) {
+	query := p.db.WithContext(ctx).Where("user_id = ?", p.userID)
+	if symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	var positions []models.PaperPosition
+	if err := query.Order("symbol, position_side").Find(&positions).Error; err != nil {
+		return nil, fmt.Errorf("查询模拟持仓失败: %v", err)
+	}
+	return positions, nil
+}
+
+// parsePaperDecimal 解析下单参数中的数值，空字符串或格式错误按0处理，由调用方按交易所规则拒绝
+func parsePaperDecimal(value string) decimal.Decimal {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+// paperSignedAmount 与币安双向持仓一致：多头为正，空头为负
+func paperSignedAmount(pos *models.PaperPosition) decimal.Decimal {
+	if pos.PositionSide == "SHORT" {
+		return pos.Amount.Neg()
+	}
+	return pos.Amount
+}
+
+func paperUnrealizedPnl(pos *models.PaperPosition) decimal.Decimal {
+	if pos.Amount.IsZero() || !pos.MarkPrice.IsPositive() {
+		return decimal.Zero
+	}
+	return pos.MarkPrice.Sub(pos.EntryPrice).Mul(paperSignedAmount(pos))
+}
+
+func toSpotOrder(order *models.PaperOrder) *binance.Order {
+	return &binance.Order{
+		Symbol:                   order.Symbol,
+		OrderID:                  int64(order.ID),
+		Price:                    order.Price.String(),
+		OrigQuantity:             order.Quantity.String(),
+		ExecutedQuantity:         order.ExecutedQty.String(),
+		CummulativeQuoteQuantity: order.AvgPrice.Mul(order.ExecutedQty).String(),
+		Status:                   binance.OrderStatusType(order.Status),
+		TimeInForce:              binance.TimeInForceType(order.TimeInForce),
+		Type:                     binance.OrderType(order.Type),
+		Side:                     binance.SideType(order.Side),
+		StopPrice:                order.StopPrice.String(),
+		Time:                     order.CreatedAt.UnixMilli(),
+		UpdateTime:               order.UpdatedAt.UnixMilli(),
+		IsWorking:                order.Status == "NEW",
+	}
+}
+
+func toFuturesOrder(order *models.PaperOrder) *futures.Order {
+	return &futures.Order{
+		Symbol:           order.Symbol,
+		OrderID:          int64(order.ID),
+		ClientOrderID:    order.ClientOrderID,
+		Price:            order.Price.String(),
+		OrigQuantity:     order.Quantity.String(),
+		ExecutedQuantity: order.ExecutedQty.String(),
+		CumQuantity:      order.ExecutedQty.String(),
+		CumQuote:         order.AvgPrice.Mul(order.ExecutedQty).String(),
+		AvgPrice:         order.AvgPrice.String(),
+		Status:           futures.OrderStatusType(order.Status),
+		TimeInForce:      futures.TimeInForceType(order.TimeInForce),
+		Type:             futures.OrderType(order.Type),
+		OrigType:         futures.OrderType(order.Type),
+		Side:             futures.SideType(order.Side),
+		PositionSide:     futures.PositionSideType(order.PositionSide),
+		StopPrice:        order.StopPrice.String(),
+		ActivatePrice:    order.ActivationPrice.String(),
+		PriceRate:        formatFakeFloat(order.CallbackRate),
+		Time:             order.CreatedAt.UnixMilli(),
+		UpdateTime:       order.UpdatedAt.UnixMilli(),
+	}
+}
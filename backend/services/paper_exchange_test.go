@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ccj241/binance/models"
+	"github.com/shopspring/decimal"
+)
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestTriggerPrice(t *testing.T) {
+	cases := []struct {
+		name    string
+		order   models.PaperOrder
+		low     string
+		high    string
+		want    string
+		trigger bool
+	}{
+		{"限价买单触及", models.PaperOrder{Type: "LIMIT", Side: "BUY", Price: dec("99.5")}, "99.5", "101", "99.5", true},
+		{"限价买单未触及", models.PaperOrder{Type: "LIMIT", Side: "BUY", Price: dec("99.5")}, "99.51", "101", "0", false},
+		{"限价卖单触及", models.PaperOrder{Type: "LIMIT", Side: "SELL", Price: dec("100.1")}, "99", "100.1", "100.1", true},
+		{"止损卖单跌破", models.PaperOrder{Type: "STOP_MARKET", Side: "SELL", StopPrice: dec("95")}, "94.99", "96", "95", true},
+		{"止损买单未涨破", models.PaperOrder{Type: "STOP_MARKET", Side: "BUY", StopPrice: dec("105")}, "100", "104.99", "0", false},
+		{"止盈卖单涨破", models.PaperOrder{Type: "TAKE_PROFIT_MARKET", Side: "SELL", StopPrice: dec("110")}, "100", "110", "110", true},
+	}
+	for _, c := range cases {
+		price, ok := triggerPrice(&c.order, dec(c.low), dec(c.high))
+		if ok != c.trigger || !price.Equal(dec(c.want)) {
+			t.Errorf("%s: triggerPrice = %s, %v, want %s, %v", c.name, price, ok, c.want, c.trigger)
+		}
+	}
+}
+
+func TestTrailingStopTrigger(t *testing.T) {
+	// 平多跟踪止损，激活价105，回调1%
+	extreme, _, triggered := trailingStopTrigger(true, dec("105"), 1, decimal.Zero, dec("100"), dec("104"))
+	if triggered || !extreme.IsZero() {
+		t.Fatalf("未到激活价时 extreme=%s triggered=%v", extreme, triggered)
+	}
+	extreme, stop, triggered := trailingStopTrigger(true, dec("105"), 1, decimal.Zero, dec("104"), dec("110"))
+	if triggered || !extreme.Equal(dec("110")) || !stop.Equal(dec("108.9")) {
+		t.Fatalf("激活后 extreme=%s stop=%s triggered=%v", extreme, stop, triggered)
+	}
+	// 用上次的最高价判断回调，止损价按定点计算不产生二进制误差
+	extreme, stop, triggered = trailingStopTrigger(true, dec("105"), 1, extreme, dec("108.9"), dec("109.5"))
+	if !triggered || !extreme.Equal(dec("110")) || stop.String() != "108.9" {
+		t.Fatalf("回调触发 extreme=%s stop=%s triggered=%v", extreme, stop, triggered)
+	}
+
+	// 平空跟踪最低价，未设置激活价时立即激活
+	extreme, stop, triggered = trailingStopTrigger(false, decimal.Zero, 0.5, decimal.Zero, dec("200"), dec("201"))
+	if triggered || !extreme.Equal(dec("200")) || !stop.Equal(dec("201")) {
+		t.Fatalf("平空激活 extreme=%s stop=%s triggered=%v", extreme, stop, triggered)
+	}
+	if _, _, triggered = trailingStopTrigger(false, decimal.Zero, 0.5, extreme, dec("199"), dec("201")); !triggered {
+		t.Fatal("价格反弹到止损价应触发")
+	}
+}
@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 币安请求权重按IP和接口类别统计，下单次数按API Key统计
+const (
+	spotWeightLimit    = 6000  // 现货 /api 每分钟权重
+	sapiWeightLimit    = 12000 // /sapi 每分钟IP权重
+	futuresWeightLimit = 2400  // U本位合约每分钟权重
+	spotOrderLimit     = 100   // 现货每10秒下单数
+	futuresOrderLimit  = 1200  // 合约每分钟下单数
+
+	// 查询类请求最多使用的预算比例，剩余预算留给下单和撤单
+	lowPriorityShare = 0.8
+	// 排队等待超过该时长的请求直接返回限流错误，避免协程长期堆积
+	maxRateLimitWait = time.Minute
+	// 418 未返回 Retry-After 时的默认封禁时长
+	defaultBanDuration = 2 * time.Minute
+)
+
+// RequestPriority 请求优先级，写请求（下单、撤单等）优先于状态查询：
+// 查询最多使用 lowPriorityShare 的预算，且有写请求排队时查询让行，等写请求发出后再竞争
+type RequestPriority int
+
+const (
+	PriorityLow RequestPriority = iota
+	PriorityHigh
+)
+
+// rateBucket 一个固定窗口内的限额，用量以交易所响应头为准，未返回时按本地估算累加
+type rateBucket struct {
+	name        string
+	limit       int
+	window      time.Duration
+	header      string // 返回已用量的响应头
+	used        int
+	windowStart time.Time
+	bannedUntil time.Time
+	waiting     int
+	waitingHigh int           // 排队中的高优先级请求数
+	wake        chan struct{} // 高优先级请求离开队列时关闭，唤醒让行的低优先级请求
+	throttled   int64         // 因预算不足排队的请求数
+	rejected    int64         // 收到 429/418 的次数
+}
+
+// RateBucketStatus 限额当前状态，供管理接口展示
+type RateBucketStatus struct {
+	Name        string     `json:"name"`
+	Limit       int        `json:"limit"`
+	Used        int        `json:"used"`
+	Remaining   int        `json:"remaining"`
+	Window      string     `json:"window"`
+	ResetAt     time.Time  `json:"resetAt"`
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"`
+	Waiting     int        `json:"waiting"`
+	Throttled   int64      `json:"throttled"`
+	Rejected    int64      `json:"rejected"`
+}
+
+// roll 进入新窗口时清零用量
+func (b *rateBucket) roll(now time.Time) {
+	start := now.Truncate(b.window)
+	if start.After(b.windowStart) {
+		b.windowStart = start
+		b.used = 0
+	}
+}
+
+// allowance 指定优先级可以使用的额度
+func (b *rateBucket) allowance(priority RequestPriority) int {
+	if priority == PriorityHigh {
+		return b.limit
+	}
+	return int(float64(b.limit) * lowPriorityShare)
+}
+
+// waitFor 返回请求需要等待的时长，0 表示可以立即发送
+// 有高优先级请求排队时低优先级请求最多等到窗口结束，期间高优先级请求离开队列会提前唤醒
+func (b *rateBucket) waitFor(now time.Time, weight int, priority RequestPriority) time.Duration {
+	if now.Before(b.bannedUntil) {
+		return b.bannedUntil.Sub(now)
+	}
+	b.roll(now)
+	if b.used > 0 && b.used+weight > b.allowance(priority) {
+		return b.windowStart.Add(b.window).Sub(now)
+	}
+	if priority == PriorityLow && b.waitingHigh > 0 {
+		return b.windowStart.Add(b.window).Sub(now)
+	}
+	return 0
+}
+
+// enqueue 请求开始排队
+func (b *rateBucket) enqueue(priority RequestPriority) {
+	b.waiting++
+	b.throttled++
+	if priority == PriorityHigh {
+		b.waitingHigh++
+	}
+}
+
+// dequeue 请求离开队列，高优先级请求离开时唤醒让行的请求重新检查
+func (b *rateBucket) dequeue(priority RequestPriority) {
+	b.waiting--
+	if priority == PriorityHigh {
+		b.waitingHigh--
+		close(b.wake)
+		b.wake = make(chan struct{})
+	}
+}
+
+func (b *rateBucket) status(now time.Time) RateBucketStatus {
+	b.roll(now)
+	s := RateBucketStatus{
+		Name:      b.name,
+		Limit:     b.limit,
+		Used:      b.used,
+		Remaining: b.limit - b.used,
+		Window:    b.window.String(),
+		ResetAt:   b.windowStart.Add(b.window),
+		Waiting:   b.waiting,
+		Throttled: b.throttled,
+		Rejected:  b.rejected,
+	}
+	if s.Remaining < 0 {
+		s.Remaining = 0
+	}
+	if now.Before(b.bannedUntil) {
+		banned := b.bannedUntil
+		s.BannedUntil = &banned
+	}
+	return s
+}
+
+// RateGovernor 所有币安REST请求共享的限流器
+// 主网和测试网等不同域名的限额互相独立，限额按域名区分
+type RateGovernor struct {
+	mu      sync.Mutex
+	ip      map[string]*rateBucket // 按域名和接口类别的IP权重
+	account map[string]*rateBucket // 按域名、API Key和市场的下单次数
+}
+
+// rateGovernor 全局限流器，同一进程内的所有交易所实例共享
+var rateGovernor = &RateGovernor{
+	ip:      make(map[string]*rateBucket),
+	account: make(map[string]*rateBucket),
+}
+
+// requestCategory 按域名和路径区分权重类别
+func requestCategory(req *http.Request) string {
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/fapi/"):
+		return "futures"
+	case strings.HasPrefix(req.URL.Path, "/sapi/"):
+		return "sapi"
+	default:
+		return "spot"
+	}
+}
+
+// isOrderRequest 是否为计入下单次数的请求
+func isOrderRequest(req *http.Request) bool {
+	if req.Method != http.MethodPost {
+		return false
+	}
+	switch req.URL.Path {
+	case "/api/v3/order", "/api/v3/order/oco", "/fapi/v1/order", "/fapi/v1/batchOrders":
+		return true
+	}
+	return false
+}
+
+// requestPriorityOf 下单、撤单、续期等写请求为高优先级
+func requestPriorityOf(req *http.Request) RequestPriority {
+	if req.Method != http.MethodGet {
+		return PriorityHigh
+	}
+	return PriorityLow
+}
+
+// requestWeight 估算请求权重，响应返回后以交易所统计为准
+func requestWeight(req *http.Request) int {
+	query := req.URL.Query()
+	switch req.URL.Path {
+	case "/api/v3/exchangeInfo", "/api/v3/account", "/api/v3/myTrades":
+		return 20
+	case "/api/v3/openOrders":
+		if query.Get("symbol") == "" {
+			return 80
+		}
+		return 6
+	case "/api/v3/order":
+		if req.Method == http.MethodGet {
+			return 4
+		}
+		return 1
+	case "/api/v3/ticker/price":
+		if query.Get("symbol") == "" {
+			return 4
+		}
+		return 2
+	case "/api/v3/depth", "/fapi/v1/depth":
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		switch {
+		case limit > 500:
+			return 50
+		case limit > 100:
+			return 25
+		default:
+			return 5
+		}
+	case "/fapi/v2/account", "/fapi/v2/positionRisk":
+		return 5
+	case "/fapi/v1/exchangeInfo":
+		return 1
+	}
+	return 1
+}
+
+// ipBucket 返回域名和类别对应的权重限额，调用方需持有锁
+func (g *RateGovernor) ipBucket(host, category string) *rateBucket {
+	key := host + "/" + category
+	if b, ok := g.ip[key]; ok {
+		return b
+	}
+	b := &rateBucket{name: key, window: time.Minute, header: "X-Mbx-Used-Weight-1m", wake: make(chan struct{})}
+	switch category {
+	case "futures":
+		b.limit = futuresWeightLimit
+	case "sapi":
+		b.limit = sapiWeightLimit
+		b.header = "X-Sapi-Used-Ip-Weight-1m"
+	default:
+		b.limit = spotWeightLimit
+	}
+	g.ip[key] = b
+	return b
+}
+
+// accountBucket 返回API Key在指定域名和市场的下单次数限额，调用方需持有锁
+func (g *RateGovernor) accountBucket(host, apiKey, category string) *rateBucket {
+	key := host + "/" + category + ":" + apiKey
+	if b, ok := g.account[key]; ok {
+		return b
+	}
+	b := &rateBucket{name: host + "/" + category + ":" + maskAPIKey(apiKey), wake: make(chan struct{})}
+	if category == "futures" {
+		b.limit, b.window, b.header = futuresOrderLimit, time.Minute, "X-Mbx-Order-Count-1m"
+	} else {
+		b.limit, b.window, b.header = spotOrderLimit, 10*time.Second, "X-Mbx-Order-Count-10s"
+	}
+	g.account[key] = b
+	return b
+}
+
+// acquire 按优先级等待预算，预算足够时预先记入估算用量
+func (g *RateGovernor) acquire(ctx context.Context, apiKey string, req *http.Request) error {
+	host, category := req.URL.Host, requestCategory(req)
+	weight := requestWeight(req)
+	priority := requestPriorityOf(req)
+	order := apiKey != "" && isOrderRequest(req)
+
+	deadline := time.Now().Add(maxRateLimitWait)
+	queued := false
+	defer func() {
+		if queued {
+			g.mu.Lock()
+			g.ipBucket(host, category).dequeue(priority)
+			g.mu.Unlock()
+		}
+	}()
+
+	for {
+		g.mu.Lock()
+		now := time.Now()
+		ip := g.ipBucket(host, category)
+		wait := ip.waitFor(now, weight, priority)
+		var account *rateBucket
+		if order {
+			account = g.accountBucket(host, apiKey, category)
+			if w := account.waitFor(now, 1, PriorityHigh); w > wait {
+				wait = w
+			}
+		}
+		if wait == 0 {
+			ip.used += weight
+			if account != nil {
+				account.used++
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		if !queued {
+			queued = true
+			ip.enqueue(priority)
+		}
+		wake := ip.wake
+		g.mu.Unlock()
+
+		if now.Add(wait).After(deadline) {
+			return fmt.Errorf("币安请求限流中，%v 后恢复", wait.Round(time.Second))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// observe 根据响应头更新实际用量，429/418 时按 Retry-After 暂停后续请求
+func (g *RateGovernor) observe(apiKey string, req *http.Request, resp *http.Response) {
+	host, category := req.URL.Host, requestCategory(req)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+
+	ip := g.ipBucket(host, category)
+	ip.roll(now)
+	if used, err := strconv.Atoi(resp.Header.Get(ip.header)); err == nil {
+		ip.used = used
+	}
+
+	var account *rateBucket
+	if apiKey != "" && isOrderRequest(req) {
+		account = g.accountBucket(host, apiKey, category)
+		account.roll(now)
+		if used, err := strconv.Atoi(resp.Header.Get(account.header)); err == nil {
+			account.used = used
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusTeapot {
+		return
+	}
+
+	retryAfter := defaultBanDuration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = ip.windowStart.Add(ip.window).Sub(now)
+	}
+
+	// 下单次数超限只暂停该账户的下单，权重超限暂停该类别的所有请求
+	target := ip
+	if account != nil && account.used >= account.limit {
+		target = account
+	}
+	target.rejected++
+	if until := now.Add(retryAfter); until.After(target.bannedUntil) {
+		target.bannedUntil = until
+	}
+}
+
+// RateLimitStatus 返回当前各类限额的使用情况
+func RateLimitStatus() map[string][]RateBucketStatus {
+	g := rateGovernor
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	result := map[string][]RateBucketStatus{
+		"ip":      {},
+		"account": {},
+	}
+	for _, b := range g.ip {
+		result["ip"] = append(result["ip"], b.status(now))
+	}
+	for _, b := range g.account {
+		result["account"] = append(result["account"], b.status(now))
+	}
+	for _, list := range result {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	return result
+}
+
+// maskAPIKey 只保留API Key首尾用于区分账户
+func maskAPIKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return "****"
+	}
+	return apiKey[:4] + "****" + apiKey[len(apiKey)-4:]
+}
+
+// rateLimitedTransport 在发送前经过全局限流器，并从响应中同步用量
+type rateLimitedTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+// NewRateLimitedClient 创建经过全局限流器的HTTP客户端
+func NewRateLimitedClient(apiKey string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &rateLimitedTransport{apiKey: apiKey, base: http.DefaultTransport},
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rateGovernor.acquire(req.Context(), t.apiKey, req); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rateGovernor.observe(t.apiKey, req, resp)
+	return resp, nil
+}
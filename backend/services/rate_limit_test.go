@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRateGovernor() *RateGovernor {
+	return &RateGovernor{
+		ip:      make(map[string]*rateBucket),
+		account: make(map[string]*rateBucket),
+	}
+}
+
+func TestRateBucketPriority(t *testing.T) {
+	now := time.Now()
+	b := &rateBucket{limit: 100, window: time.Minute, wake: make(chan struct{})}
+	b.roll(now)
+
+	// 查询最多使用80%的预算，剩余留给写请求
+	b.used = 79
+	if wait := b.waitFor(now, 1, PriorityLow); wait != 0 {
+		t.Errorf("预算内的查询等待 %v", wait)
+	}
+	b.used = 80
+	if wait := b.waitFor(now, 1, PriorityLow); wait <= 0 {
+		t.Error("超过查询预算时应等待到窗口结束")
+	}
+	if wait := b.waitFor(now, 1, PriorityHigh); wait != 0 {
+		t.Errorf("写请求可以使用全部预算，等待 %v", wait)
+	}
+	b.used = 100
+	if wait := b.waitFor(now, 1, PriorityHigh); wait != b.windowStart.Add(b.window).Sub(now) {
+		t.Errorf("预算用尽时写请求等待 %v，应等到窗口结束", wait)
+	}
+
+	// 新窗口清零用量
+	later := b.windowStart.Add(b.window)
+	if wait := b.waitFor(later, 1, PriorityLow); wait != 0 || b.used != 0 {
+		t.Errorf("新窗口 wait=%v used=%d", wait, b.used)
+	}
+}
+
+func TestRateBucketLowPriorityYieldsToQueuedWrites(t *testing.T) {
+	now := time.Now()
+	b := &rateBucket{limit: 100, window: time.Minute, wake: make(chan struct{})}
+
+	b.enqueue(PriorityHigh)
+	wake := b.wake
+	if wait := b.waitFor(now, 1, PriorityLow); wait <= 0 {
+		t.Fatal("有写请求排队时查询应让行")
+	}
+	if wait := b.waitFor(now, 1, PriorityHigh); wait != 0 {
+		t.Fatalf("写请求不应等待其他写请求，等待 %v", wait)
+	}
+
+	b.dequeue(PriorityHigh)
+	select {
+	case <-wake:
+	default:
+		t.Fatal("写请求离开队列时应唤醒让行的查询")
+	}
+	if wait := b.waitFor(now, 1, PriorityLow); wait != 0 {
+		t.Errorf("写请求离开后查询仍等待 %v", wait)
+	}
+	if b.waiting != 0 || b.throttled != 1 {
+		t.Errorf("waiting=%d throttled=%d", b.waiting, b.throttled)
+	}
+}
+
+func TestRateGovernorRecordsWeight(t *testing.T) {
+	g := newTestRateGovernor()
+	req := httptest.NewRequest(http.MethodGet, "https://api.binance.com/api/v3/depth?symbol=BTCUSDT&limit=1000", nil)
+	if err := g.acquire(context.Background(), "", req); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if used := g.ipBucket("api.binance.com", "spot").used; used != 50 {
+		t.Errorf("深度1000档权重 = %d, want 50", used)
+	}
+
+	order := httptest.NewRequest(http.MethodPost, "https://fapi.binance.com/fapi/v1/order", nil)
+	if err := g.acquire(context.Background(), "key", order); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if used := g.accountBucket("fapi.binance.com", "key", "futures").used; used != 1 {
+		t.Errorf("合约下单次数 = %d, want 1", used)
+	}
+}
+
+func TestRateGovernorBucketsAreKeyedByHost(t *testing.T) {
+	g := newTestRateGovernor()
+	mainnet := httptest.NewRequest(http.MethodGet, "https://api.binance.com/api/v3/account", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("X-Mbx-Used-Weight-1m", "6000")
+	g.observe("", mainnet, resp)
+
+	// 测试网的限额与主网互不影响
+	testnet := httptest.NewRequest(http.MethodGet, "https://testnet.binance.vision/api/v3/account", nil)
+	if err := g.acquire(context.Background(), "", testnet); err != nil {
+		t.Fatalf("测试网请求被主网用量限流: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.acquire(ctx, "", mainnet); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("主网权重用尽时应排队等待，得到 %v", err)
+	}
+	if b := g.ipBucket("api.binance.com", "spot"); b.throttled != 1 || b.waiting != 0 {
+		t.Errorf("throttled=%d waiting=%d", b.throttled, b.waiting)
+	}
+}
+
+func TestRateGovernorBacksOffAfterRejection(t *testing.T) {
+	g := newTestRateGovernor()
+	req := httptest.NewRequest(http.MethodGet, "https://fapi.binance.com/fapi/v2/positionRisk", nil)
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "30")
+	g.observe("", req, resp)
+
+	b := g.ipBucket("fapi.binance.com", "futures")
+	if b.rejected != 1 {
+		t.Errorf("rejected = %d, want 1", b.rejected)
+	}
+	if until := time.Until(b.bannedUntil); until < 29*time.Second || until > 30*time.Second {
+		t.Errorf("按 Retry-After 暂停 %v, want 30s", until)
+	}
+
+	// 封禁期间写请求同样等待
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	order := httptest.NewRequest(http.MethodPost, "https://fapi.binance.com/fapi/v1/order", nil)
+	if err := g.acquire(ctx, "key", order); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("封禁期间请求应等待，得到 %v", err)
+	}
+}
+
+func TestRateGovernorOrderLimitOnlyPausesAccount(t *testing.T) {
+	g := newTestRateGovernor()
+	order := httptest.NewRequest(http.MethodPost, "https://api.binance.com/api/v3/order", nil)
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("X-Mbx-Order-Count-10s", "100")
+	resp.Header.Set("Retry-After", "10")
+	g.observe("key", order, resp)
+
+	if account := g.accountBucket("api.binance.com", "key", "spot"); account.rejected != 1 || !account.bannedUntil.After(time.Now()) {
+		t.Errorf("下单次数超限应暂停该账户: %+v", account.status(time.Now()))
+	}
+	if ip := g.ipBucket("api.binance.com", "spot"); ip.rejected != 0 || ip.bannedUntil.After(time.Now()) {
+		t.Error("下单次数超限不应暂停IP权重")
+	}
+
+	// 其他账户和查询不受影响
+	if err := g.acquire(context.Background(), "other", order); err != nil {
+		t.Errorf("其他账户下单被限流: %v", err)
+	}
+	query := httptest.NewRequest(http.MethodGet, "https://api.binance.com/api/v3/openOrders?symbol=BTCUSDT", nil)
+	if err := g.acquire(context.Background(), "key", query); err != nil {
+		t.Errorf("查询被下单限额限流: %v", err)
+	}
+}
+
+func TestRequestClassification(t *testing.T) {
+	cases := []struct {
+		method   string
+		url      string
+		category string
+		weight   int
+		order    bool
+		priority RequestPriority
+	}{
+		{http.MethodGet, "https://api.binance.com/api/v3/openOrders", "spot", 80, false, PriorityLow},
+		{http.MethodGet, "https://api.binance.com/api/v3/openOrders?symbol=BTCUSDT", "spot", 6, false, PriorityLow},
+		{http.MethodPost, "https://api.binance.com/api/v3/order", "spot", 1, true, PriorityHigh},
+		{http.MethodDelete, "https://api.binance.com/api/v3/order", "spot", 1, false, PriorityHigh},
+		{http.MethodGet, "https://fapi.binance.com/fapi/v1/depth?limit=50", "futures", 5, false, PriorityLow},
+		{http.MethodPost, "https://fapi.binance.com/fapi/v1/batchOrders", "futures", 1, true, PriorityHigh},
+		{http.MethodPost, "https://api.binance.com/sapi/v1/capital/withdraw/apply", "sapi", 1, false, PriorityHigh},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		if got := requestCategory(req); got != c.category {
+			t.Errorf("%s %s 类别 = %s, want %s", c.method, c.url, got, c.category)
+		}
+		if got := requestWeight(req); got != c.weight {
+			t.Errorf("%s %s 权重 = %d, want %d", c.method, c.url, got, c.weight)
+		}
+		if got := isOrderRequest(req); got != c.order {
+			t.Errorf("%s %s 下单请求 = %v, want %v", c.method, c.url, got, c.order)
+		}
+		if got := requestPriorityOf(req); got != c.priority {
+			t.Errorf("%s %s 优先级 = %v, want %v", c.method, c.url, got, c.priority)
+		}
+	}
+}
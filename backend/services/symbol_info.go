@@ -0,0 +1,517 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// SymbolInfoRefreshInterval 交易规则缓存的定时刷新间隔
+	SymbolInfoRefreshInterval = 10 * time.Minute
+	// symbolMissRefresh 缓存中找不到交易对时（如新上线）最短的强制刷新间隔
+	symbolMissRefresh = time.Minute
+)
+
+// SymbolFilters 交易对的下单规则，来自 exchangeInfo 的 filters
+type SymbolFilters struct {
+	Symbol     string `json:"symbol"`
+	BaseAsset  string `json:"baseAsset"`
+	QuoteAsset string `json:"quoteAsset"`
+	Status     string `json:"status"`
+
+	// PRICE_FILTER
+	TickSize float64 `json:"tickSize"`
+	MinPrice float64 `json:"minPrice"`
+	MaxPrice float64 `json:"maxPrice"`
+
+	// LOT_SIZE
+	StepSize float64 `json:"stepSize"`
+	MinQty   float64 `json:"minQty"`
+	MaxQty   float64 `json:"maxQty"`
+
+	// MARKET_LOT_SIZE，未设置时市价单沿用 LOT_SIZE
+	MarketStepSize float64 `json:"marketStepSize"`
+	MarketMinQty   float64 `json:"marketMinQty"`
+	MarketMaxQty   float64 `json:"marketMaxQty"`
+
+	// MIN_NOTIONAL / NOTIONAL
+	MinNotional float64 `json:"minNotional"`
+	MaxNotional float64 `json:"maxNotional"`
+
+	// PERCENT_PRICE / PERCENT_PRICE_BY_SIDE，相对最新价的允许范围
+	BidMultiplierUp   float64 `json:"bidMultiplierUp"`
+	BidMultiplierDown float64 `json:"bidMultiplierDown"`
+	AskMultiplierUp   float64 `json:"askMultiplierUp"`
+	AskMultiplierDown float64 `json:"askMultiplierDown"`
+
+	// MAX_NUM_ORDERS / MAX_NUM_ALGO_ORDERS
+	MaxNumOrders     int `json:"maxNumOrders"`
+	MaxNumAlgoOrders int `json:"maxNumAlgoOrders"`
+}
+
+// filterFloat 读取过滤器数值，交易所可能返回字符串或数字
+func filterFloat(filter map[string]interface{}, key string) float64 {
+	switch v := filter[key].(type) {
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// ParseSymbolFilters 解析现货或合约交易对的过滤器
+func ParseSymbolFilters(symbol string, filters []map[string]interface{}) *SymbolFilters {
+	f := &SymbolFilters{Symbol: symbol}
+	for _, filter := range filters {
+		switch filter["filterType"] {
+		case "PRICE_FILTER":
+			f.TickSize = filterFloat(filter, "tickSize")
+			f.MinPrice = filterFloat(filter, "minPrice")
+			f.MaxPrice = filterFloat(filter, "maxPrice")
+		case "LOT_SIZE":
+			f.StepSize = filterFloat(filter, "stepSize")
+			f.MinQty = filterFloat(filter, "minQty")
+			f.MaxQty = filterFloat(filter, "maxQty")
+		case "MARKET_LOT_SIZE":
+			f.MarketStepSize = filterFloat(filter, "stepSize")
+			f.MarketMinQty = filterFloat(filter, "minQty")
+			f.MarketMaxQty = filterFloat(filter, "maxQty")
+		case "MIN_NOTIONAL":
+			// 现货为 minNotional，U本位合约为 notional
+			f.MinNotional = filterFloat(filter, "minNotional")
+			if f.MinNotional == 0 {
+				f.MinNotional = filterFloat(filter, "notional")
+			}
+		case "NOTIONAL":
+			f.MinNotional = filterFloat(filter, "minNotional")
+			f.MaxNotional = filterFloat(filter, "maxNotional")
+		case "PERCENT_PRICE":
+			f.BidMultiplierUp = filterFloat(filter, "multiplierUp")
+			f.BidMultiplierDown = filterFloat(filter, "multiplierDown")
+			f.AskMultiplierUp, f.AskMultiplierDown = f.BidMultiplierUp, f.BidMultiplierDown
+		case "PERCENT_PRICE_BY_SIDE":
+			f.BidMultiplierUp = filterFloat(filter, "bidMultiplierUp")
+			f.BidMultiplierDown = filterFloat(filter, "bidMultiplierDown")
+			f.AskMultiplierUp = filterFloat(filter, "askMultiplierUp")
+			f.AskMultiplierDown = filterFloat(filter, "askMultiplierDown")
+		case "MAX_NUM_ORDERS":
+			// 现货为 maxNumOrders，U本位合约为 limit
+			f.MaxNumOrders = int(filterFloat(filter, "maxNumOrders"))
+			if f.MaxNumOrders == 0 {
+				f.MaxNumOrders = int(filterFloat(filter, "limit"))
+			}
+		case "MAX_NUM_ALGO_ORDERS":
+			f.MaxNumAlgoOrders = int(filterFloat(filter, "maxNumAlgoOrders"))
+			if f.MaxNumAlgoOrders == 0 {
+				f.MaxNumAlgoOrders = int(filterFloat(filter, "limit"))
+			}
+		}
+	}
+	return f
+}
+
+// ==================== 精度处理 ====================
+
+// RoundMode 按步长取整的方向
+type RoundMode int
+
+const (
+	RoundNearest RoundMode = iota
+	RoundDown
+	RoundUp
+)
+
+// QuantizeToStep 将定点数按步长（tickSize/stepSize）取整，结果为步长的精确整数倍
+func QuantizeToStep(value decimal.Decimal, step float64, mode RoundMode) decimal.Decimal {
+	if step <= 0 {
+		return value
+	}
+	s := decimal.NewFromFloat(step)
+	// 整数商向零截断，余数与被除数同号
+	q, r := value.QuoRem(s, 0)
+	one := decimal.NewFromInt(1)
+	switch mode {
+	case RoundDown:
+		if r.IsNegative() {
+			q = q.Sub(one)
+		}
+	case RoundUp:
+		if r.IsPositive() {
+			q = q.Add(one)
+		}
+	default:
+		// 余数不小于一半时远离零进位
+		if r.Abs().Mul(decimal.NewFromInt(2)).GreaterThanOrEqual(s) {
+			if r.IsNegative() {
+				q = q.Sub(one)
+			} else {
+				q = q.Add(one)
+			}
+		}
+	}
+	return q.Mul(s)
+}
+
+// RoundToStep 将数值按步长（tickSize/stepSize）取整
+// 浮点数按最短十进制表示转换为定点数后取整，避免二进制误差影响结果
+func RoundToStep(value, step float64, mode RoundMode) float64 {
+	return QuantizeToStep(decimal.NewFromFloat(value), step, mode).InexactFloat64()
+}
+
+// FormatToStep 按步长取整并格式化为下单使用的字符串
+func FormatToStep(value, step float64, mode RoundMode) string {
+	if step <= 0 {
+		return strconv.FormatFloat(value, 'f', 8, 64)
+	}
+	return QuantizeToStep(decimal.NewFromFloat(value), step, mode).StringFixed(int32(StepPrecision(step)))
+}
+
+// StepPrecision 返回步长的小数位数，如 0.01→2、0.5→1、5→0
+func StepPrecision(step float64) int {
+	if step <= 0 {
+		return 8
+	}
+	s := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// isStepMultiple 判断数值是否为步长的整数倍
+func isStepMultiple(value, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	_, r := decimal.NewFromFloat(value).QuoRem(decimal.NewFromFloat(step), 0)
+	return r.IsZero()
+}
+
+// PricePrecision 价格小数位数
+func (f *SymbolFilters) PricePrecision() int {
+	return StepPrecision(f.TickSize)
+}
+
+// QuantityPrecision 数量小数位数
+func (f *SymbolFilters) QuantityPrecision() int {
+	return StepPrecision(f.StepSize)
+}
+
+// RoundPrice 价格取整到最近的 tickSize 整数倍
+func (f *SymbolFilters) RoundPrice(price float64) float64 {
+	return RoundToStep(price, f.TickSize, RoundNearest)
+}
+
+// FloorQuantity 数量向下取整到 stepSize 整数倍
+func (f *SymbolFilters) FloorQuantity(quantity float64) float64 {
+	return RoundToStep(quantity, f.StepSize, RoundDown)
+}
+
+// CeilQuantity 数量向上取整到 stepSize 整数倍，用于补足最小名义价值
+func (f *SymbolFilters) CeilQuantity(quantity float64) float64 {
+	return RoundToStep(quantity, f.StepSize, RoundUp)
+}
+
+// FormatPrice 价格按 tickSize 取整后格式化
+func (f *SymbolFilters) FormatPrice(price float64) string {
+	return FormatToStep(price, f.TickSize, RoundNearest)
+}
+
+// FormatQuantity 数量按 stepSize 向下取整后格式化
+func (f *SymbolFilters) FormatQuantity(quantity float64) string {
+	return FormatToStep(quantity, f.StepSize, RoundDown)
+}
+
+// QuantizePrice 定点价格取整到最近的 tickSize 整数倍
+func (f *SymbolFilters) QuantizePrice(price decimal.Decimal) decimal.Decimal {
+	return QuantizeToStep(price, f.TickSize, RoundNearest)
+}
+
+// QuantizeQuantity 定点数量按 stepSize 取整
+func (f *SymbolFilters) QuantizeQuantity(quantity decimal.Decimal, mode RoundMode) decimal.Decimal {
+	return QuantizeToStep(quantity, f.StepSize, mode)
+}
+
+// PriceString 按价格精度输出，下单和入库使用同一个值
+func (f *SymbolFilters) PriceString(price decimal.Decimal) string {
+	return price.StringFixed(int32(f.PricePrecision()))
+}
+
+// QuantityString 按数量精度输出
+func (f *SymbolFilters) QuantityString(quantity decimal.Decimal) string {
+	return quantity.StringFixed(int32(f.QuantityPrecision()))
+}
+
+// Validate 下单前按交易所规则校验价格和数量
+// market 为 true 时按市价单校验，refPrice 为最新价，用于市价单名义价值和价格偏离检查，为0时跳过
+func (f *SymbolFilters) Validate(side string, price, quantity, refPrice float64, market bool) error {
+	if f.Status != "" && f.Status != "TRADING" {
+		return fmt.Errorf("交易对 %s 当前不可交易（%s）", f.Symbol, f.Status)
+	}
+
+	if !market {
+		if f.MinPrice > 0 && price < f.MinPrice {
+			return fmt.Errorf("价格 %v 低于最低价格 %v", price, f.MinPrice)
+		}
+		if f.MaxPrice > 0 && price > f.MaxPrice {
+			return fmt.Errorf("价格 %v 高于最高价格 %v", price, f.MaxPrice)
+		}
+		if !isStepMultiple(price, f.TickSize) {
+			return fmt.Errorf("价格 %v 不是最小价格变动 %v 的整数倍", price, f.TickSize)
+		}
+	}
+
+	minQty, maxQty, stepSize := f.MinQty, f.MaxQty, f.StepSize
+	if market {
+		if f.MarketMinQty > 0 {
+			minQty = f.MarketMinQty
+		}
+		if f.MarketMaxQty > 0 {
+			maxQty = f.MarketMaxQty
+		}
+		if f.MarketStepSize > 0 {
+			stepSize = f.MarketStepSize
+		}
+	}
+	if minQty > 0 && quantity < minQty {
+		return fmt.Errorf("数量 %v 低于最小数量 %v", quantity, minQty)
+	}
+	if maxQty > 0 && quantity > maxQty {
+		return fmt.Errorf("数量 %v 超过最大数量 %v", quantity, maxQty)
+	}
+	if !isStepMultiple(quantity, stepSize) {
+		return fmt.Errorf("数量 %v 不是数量步长 %v 的整数倍", quantity, stepSize)
+	}
+
+	notionalPrice := price
+	if market {
+		notionalPrice = refPrice
+	}
+	if notionalPrice > 0 {
+		notional := notionalPrice * quantity
+		if f.MinNotional > 0 && notional < f.MinNotional {
+			return fmt.Errorf("订单金额 %.8f 小于最小名义价值 %v", notional, f.MinNotional)
+		}
+		if f.MaxNotional > 0 && notional > f.MaxNotional {
+			return fmt.Errorf("订单金额 %.8f 超过最大名义价值 %v", notional, f.MaxNotional)
+		}
+	}
+
+	if !market && refPrice > 0 {
+		up, down := f.BidMultiplierUp, f.BidMultiplierDown
+		if side == "SELL" {
+			up, down = f.AskMultiplierUp, f.AskMultiplierDown
+		}
+		if up > 0 && price > refPrice*up {
+			return fmt.Errorf("价格 %v 高于最新价 %v 的允许范围（%v 倍）", price, refPrice, up)
+		}
+		if down > 0 && price < refPrice*down {
+			return fmt.Errorf("价格 %v 低于最新价 %v 的允许范围（%v 倍）", price, refPrice, down)
+		}
+	}
+	return nil
+}
+
+// ==================== 交易规则缓存 ====================
+
+// symbolInfoCache 一个交易所环境的现货和合约交易规则缓存，该环境的所有交易所实例共享
+// 首次使用时加载，之后由 RefreshSymbolInfo 定时刷新
+type symbolInfoCache struct {
+	mu        sync.Mutex
+	endpoints Endpoints
+
+	spot          map[string]binance.Symbol
+	spotFilters   map[string]*SymbolFilters
+	spotMissRetry time.Time
+
+	futures          map[string]futures.Symbol
+	futuresFilters   map[string]*SymbolFilters
+	futuresMissRetry time.Time
+}
+
+// symbolCaches 按交易所环境区分的交易规则缓存，测试网和自定义环境的交易对和精度可能与正式环境不同
+var symbolCaches = struct {
+	mu      sync.Mutex
+	entries map[Endpoints]*symbolInfoCache
+}{entries: make(map[Endpoints]*symbolInfoCache)}
+
+// symbolCacheFor 返回环境对应的缓存
+func symbolCacheFor(endpoints Endpoints) *symbolInfoCache {
+	symbolCaches.mu.Lock()
+	defer symbolCaches.mu.Unlock()
+	c, ok := symbolCaches.entries[endpoints]
+	if !ok {
+		c = &symbolInfoCache{endpoints: endpoints}
+		symbolCaches.entries[endpoints] = c
+	}
+	return c
+}
+
+// publicExchange 创建请求该环境公共接口的交易所实例
+func (c *symbolInfoCache) publicExchange() Exchange {
+	exchange := NewExchange("", "")
+	if binanceExchange, ok := exchange.(*BinanceExchange); ok {
+		binanceExchange.UseEndpoints(c.endpoints)
+	}
+	return exchange
+}
+
+// refreshSpot 拉取全部现货交易规则，调用方需持有锁
+func (c *symbolInfoCache) refreshSpot(ctx context.Context) error {
+	info, err := c.publicExchange().GetExchangeInfo(ctx, "")
+	if err != nil {
+		return fmt.Errorf("获取 %s 现货交易规则失败: %v", c.endpoints.SpotREST, err)
+	}
+	symbols := make(map[string]binance.Symbol, len(info.Symbols))
+	filters := make(map[string]*SymbolFilters, len(info.Symbols))
+	for _, s := range info.Symbols {
+		symbols[s.Symbol] = s
+		f := ParseSymbolFilters(s.Symbol, s.Filters)
+		f.BaseAsset, f.QuoteAsset, f.Status = s.BaseAsset, s.QuoteAsset, s.Status
+		filters[s.Symbol] = f
+	}
+	c.spot, c.spotFilters = symbols, filters
+	return nil
+}
+
+// refreshFutures 拉取全部U本位合约交易规则，调用方需持有锁
+func (c *symbolInfoCache) refreshFutures(ctx context.Context) error {
+	info, err := c.publicExchange().GetFuturesExchangeInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("获取 %s 合约交易规则失败: %v", c.endpoints.FuturesREST, err)
+	}
+	symbols := make(map[string]futures.Symbol, len(info.Symbols))
+	filters := make(map[string]*SymbolFilters, len(info.Symbols))
+	for _, s := range info.Symbols {
+		symbols[s.Symbol] = s
+		f := ParseSymbolFilters(s.Symbol, s.Filters)
+		f.BaseAsset, f.QuoteAsset, f.Status = s.BaseAsset, s.QuoteAsset, s.Status
+		filters[s.Symbol] = f
+	}
+	c.futures, c.futuresFilters = symbols, filters
+	return nil
+}
+
+// spotEntry 返回现货交易对的缓存记录，首次使用或交易对缺失时刷新
+func (c *symbolInfoCache) spotEntry(ctx context.Context, symbol string) (binance.Symbol, *SymbolFilters, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.spot == nil {
+		if err := c.refreshSpot(ctx); err != nil {
+			return binance.Symbol{}, nil, err
+		}
+	}
+	if s, ok := c.spot[symbol]; ok {
+		return s, c.spotFilters[symbol], nil
+	}
+	if time.Since(c.spotMissRetry) > symbolMissRefresh {
+		c.spotMissRetry = time.Now()
+		if err := c.refreshSpot(ctx); err != nil {
+			return binance.Symbol{}, nil, err
+		}
+		if s, ok := c.spot[symbol]; ok {
+			return s, c.spotFilters[symbol], nil
+		}
+	}
+	return binance.Symbol{}, nil, fmt.Errorf("交易对 %s 不存在", symbol)
+}
+
+// futuresEntry 返回合约交易对的缓存记录，首次使用或交易对缺失时刷新
+func (c *symbolInfoCache) futuresEntry(ctx context.Context, symbol string) (futures.Symbol, *SymbolFilters, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.futures == nil {
+		if err := c.refreshFutures(ctx); err != nil {
+			return futures.Symbol{}, nil, err
+		}
+	}
+	if s, ok := c.futures[symbol]; ok {
+		return s, c.futuresFilters[symbol], nil
+	}
+	if time.Since(c.futuresMissRetry) > symbolMissRefresh {
+		c.futuresMissRetry = time.Now()
+		if err := c.refreshFutures(ctx); err != nil {
+			return futures.Symbol{}, nil, err
+		}
+		if s, ok := c.futures[symbol]; ok {
+			return s, c.futuresFilters[symbol], nil
+		}
+	}
+	return futures.Symbol{}, nil, fmt.Errorf("合约 %s 不存在", symbol)
+}
+
+// RefreshSymbolInfo 刷新所有已加载环境的交易规则，刷新失败时沿用旧数据
+func RefreshSymbolInfo(ctx context.Context) {
+	symbolCaches.mu.Lock()
+	caches := make([]*symbolInfoCache, 0, len(symbolCaches.entries))
+	for _, c := range symbolCaches.entries {
+		caches = append(caches, c)
+	}
+	symbolCaches.mu.Unlock()
+
+	for _, c := range caches {
+		c.mu.Lock()
+		if c.spot != nil {
+			if err := c.refreshSpot(ctx); err != nil {
+				log.Printf("%v，继续使用缓存", err)
+			}
+		}
+		if c.futures != nil {
+			if err := c.refreshFutures(ctx); err != nil {
+				log.Printf("%v，继续使用缓存", err)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// ExchangeEndpoints 交易所实例实际请求的地址，模拟盘取行情来源的地址，其他实现使用系统默认环境
+func ExchangeEndpoints(exchange Exchange) Endpoints {
+	switch e := exchange.(type) {
+	case *BinanceExchange:
+		return e.endpoints
+	case *PaperExchange:
+		return ExchangeEndpoints(e.market)
+	}
+	return DefaultEndpoints()
+}
+
+// SpotSymbolInfo 从缓存获取环境中的现货交易对信息
+func SpotSymbolInfo(ctx context.Context, endpoints Endpoints, symbol string) (binance.Symbol, error) {
+	s, _, err := symbolCacheFor(endpoints).spotEntry(ctx, symbol)
+	return s, err
+}
+
+// SpotSymbolFilters 从缓存获取环境中的现货交易对下单规则
+func SpotSymbolFilters(ctx context.Context, endpoints Endpoints, symbol string) (*SymbolFilters, error) {
+	_, f, err := symbolCacheFor(endpoints).spotEntry(ctx, symbol)
+	return f, err
+}
+
+// FuturesSymbolInfo 从缓存获取环境中的U本位合约交易对信息
+func FuturesSymbolInfo(ctx context.Context, endpoints Endpoints, symbol string) (*futures.Symbol, error) {
+	s, _, err := symbolCacheFor(endpoints).futuresEntry(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// FuturesSymbolFilters 从缓存获取环境中的U本位合约交易对下单规则
+func FuturesSymbolFilters(ctx context.Context, endpoints Endpoints, symbol string) (*SymbolFilters, error) {
+	_, f, err := symbolCacheFor(endpoints).futuresEntry(ctx, symbol)
+	return f, err
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestQuantizeToStep(t *testing.T) {
+	cases := []struct {
+		value string
+		step  float64
+		mode  RoundMode
+		want  string
+	}{
+		{"1.23456", 0.01, RoundDown, "1.23"},
+		{"1.23456", 0.01, RoundUp, "1.24"},
+		{"1.235", 0.01, RoundNearest, "1.24"},
+		{"1.2349", 0.01, RoundNearest, "1.23"},
+		{"1.23", 0.01, RoundUp, "1.23"}, // 已是整数倍时不进位
+		{"-1.235", 0.01, RoundDown, "-1.24"},
+		{"-1.235", 0.01, RoundUp, "-1.23"},
+		{"-1.235", 0.01, RoundNearest, "-1.24"},
+		{"17", 5, RoundDown, "15"},
+		{"17", 0.5, RoundUp, "17"},
+		{"0.00012345", 0.00001, RoundDown, "0.00012"},
+		{"1.23456", 0, RoundDown, "1.23456"}, // 步长为0时原样返回
+	}
+	for _, c := range cases {
+		got := QuantizeToStep(decimal.RequireFromString(c.value), c.step, c.mode)
+		if !got.Equal(decimal.RequireFromString(c.want)) {
+			t.Errorf("QuantizeToStep(%s, %v, %v) = %s, want %s", c.value, c.step, c.mode, got, c.want)
+		}
+	}
+}
+
+func TestRoundToStepAvoidsBinaryError(t *testing.T) {
+	// 0.1+0.2 的二进制结果略大于0.3，向下取整不能得到0.2；0.29 按0.01向上取整不能得到0.3以上
+	if got := RoundToStep(0.1+0.2, 0.1, RoundDown); got != 0.3 {
+		t.Errorf("RoundToStep(0.1+0.2, 0.1, RoundDown) = %v, want 0.3", got)
+	}
+	if got := RoundToStep(0.29, 0.01, RoundUp); got != 0.29 {
+		t.Errorf("RoundToStep(0.29, 0.01, RoundUp) = %v, want 0.29", got)
+	}
+	if got := RoundToStep(1.005, 0.01, RoundNearest); got != 1.01 {
+		t.Errorf("RoundToStep(1.005, 0.01, RoundNearest) = %v, want 1.01", got)
+	}
+}
+
+func TestFormatToStep(t *testing.T) {
+	cases := []struct {
+		value float64
+		step  float64
+		mode  RoundMode
+		want  string
+	}{
+		{1.23456, 0.001, RoundDown, "1.234"},
+		{1.2, 0.001, RoundDown, "1.200"},
+		{25000.5, 0.1, RoundNearest, "25000.5"},
+		{17, 5, RoundDown, "15"},
+		{0.3, 0, RoundDown, "0.30000000"},
+	}
+	for _, c := range cases {
+		if got := FormatToStep(c.value, c.step, c.mode); got != c.want {
+			t.Errorf("FormatToStep(%v, %v, %v) = %q, want %q", c.value, c.step, c.mode, got, c.want)
+		}
+	}
+}
+
+func TestStepPrecision(t *testing.T) {
+	cases := map[float64]int{0.01: 2, 0.5: 1, 5: 0, 1: 0, 0.00001: 5, 0: 8}
+	for step, want := range cases {
+		if got := StepPrecision(step); got != want {
+			t.Errorf("StepPrecision(%v) = %d, want %d", step, got, want)
+		}
+	}
+}
+
+func TestSymbolFiltersQuantizeAndString(t *testing.T) {
+	f := &SymbolFilters{TickSize: 0.1, StepSize: 0.001}
+
+	price := f.QuantizePrice(decimal.RequireFromString("25000.26"))
+	if got := f.PriceString(price); got != "25000.3" {
+		t.Errorf("PriceString = %q, want 25000.3", got)
+	}
+	quantity := f.QuantizeQuantity(decimal.RequireFromString("0.0129"), RoundDown)
+	if got := f.QuantityString(quantity); got != "0.012" {
+		t.Errorf("QuantityString = %q, want 0.012", got)
+	}
+	if got := f.FormatQuantity(0.0129); got != "0.012" {
+		t.Errorf("FormatQuantity = %q, want 0.012", got)
+	}
+}
+
+func TestParseSymbolFiltersFromFakeExchange(t *testing.T) {
+	f := ParseSymbolFilters("BTCUSDT", fakeFilters(FakeSymbol{TickSize: 0.01, StepSize: 0.0001, MinQty: 0.0001, MinNotional: 5}))
+	if f.TickSize != 0.01 || f.StepSize != 0.0001 || f.MinQty != 0.0001 || f.MinNotional != 5 || f.MaxPrice != 1000000 {
+		t.Errorf("ParseSymbolFilters = %+v", f)
+	}
+}
+
+func TestSymbolFiltersValidate(t *testing.T) {
+	f := &SymbolFilters{
+		Symbol:            "BTCUSDT",
+		Status:            "TRADING",
+		TickSize:          0.01,
+		StepSize:          0.001,
+		MinQty:            0.001,
+		MinNotional:       5,
+		BidMultiplierUp:   5,
+		BidMultiplierDown: 0.2,
+	}
+	cases := []struct {
+		name     string
+		price    float64
+		quantity float64
+		ref      float64
+		market   bool
+		ok       bool
+	}{
+		{"合法限价单", 100, 0.1, 100, false, true},
+		{"价格不是tick整数倍", 100.005, 0.1, 100, false, false},
+		{"数量不是step整数倍", 100, 0.1005, 100, false, false},
+		{"数量低于最小值", 100, 0.0001, 100, false, false},
+		{"名义价值不足", 100, 0.01, 100, false, false},
+		{"价格偏离最新价", 600, 0.1, 100, false, false},
+		{"市价单按最新价计算名义价值", 0, 0.01, 100, true, false},
+		{"合法市价单", 0, 0.1, 100, true, true},
+	}
+	for _, c := range cases {
+		err := f.Validate("BUY", c.price, c.quantity, c.ref, c.market)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: Validate error = %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+
+	f.Status = "BREAK"
+	if err := f.Validate("BUY", 100, 0.1, 100, false); err == nil {
+		t.Error("非交易状态的交易对应该校验失败")
+	}
+}
@@ -0,0 +1,366 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// 价格采样间隔，涨跌幅按采样计算，避免逐笔成交占用内存
+	alertSampleInterval = 5 * time.Second
+	// 价格和成交额最多保留的历史
+	alertHistory = 24 * time.Hour
+	// 成交额基准取前多少个同长度窗口的平均值
+	alertVolumeBaselineWindows = 12
+	// 计算基准至少需要的完整窗口数
+	alertVolumeMinBaselineWindows = 3
+
+	// MaxAlertWindowMinutes 涨跌幅窗口上限
+	MaxAlertWindowMinutes = 1440
+	// MaxVolumeWindowMinutes 成交额窗口上限，需保留基准窗口的历史
+	MaxVolumeWindowMinutes = 60
+)
+
+// alertSample 价格采样或每分钟成交额
+type alertSample struct {
+	at    time.Time
+	value float64
+}
+
+// alertSeries 单个交易对的行情历史
+type alertSeries struct {
+	lastPrice float64
+	prices    []alertSample // 每 alertSampleInterval 一个采样
+	volumes   []alertSample // 每分钟一个成交额（计价资产）
+}
+
+// alertEngine 在行情推送中评估价格提醒，规则按市场和交易对缓存
+type alertEngine struct {
+	mu     sync.Mutex
+	alerts map[string][]*models.PriceAlert // market|symbol -> 提醒
+	series map[string]*alertSeries         // market|symbol -> 行情历史
+}
+
+var priceAlerts = &alertEngine{
+	alerts: make(map[string][]*models.PriceAlert),
+	series: make(map[string]*alertSeries),
+}
+
+func alertKey(market, symbol string) string {
+	return market + "|" + symbol
+}
+
+// StartPriceAlerts 定期加载启用的提醒规则，并确保现货交易对有行情推送
+// 首次加载等待一个刷新间隔，避免与启动时的价格监控同时创建同一交易对的连接
+func StartPriceAlerts(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.AlertRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		RefreshPriceAlerts(cfg)
+	}
+}
+
+// RefreshPriceAlerts 重新加载提醒规则，规则增删改后立即调用
+func RefreshPriceAlerts(cfg *config.Config) {
+	var alerts []models.PriceAlert
+	if err := cfg.DB.Where("enabled = ?", true).Find(&alerts).Error; err != nil {
+		log.Printf("加载价格提醒失败: %v", err)
+		return
+	}
+
+	grouped := make(map[string][]*models.PriceAlert)
+	for i := range alerts {
+		alert := &alerts[i]
+		key := alertKey(alert.Market, alert.Symbol)
+		grouped[key] = append(grouped[key], alert)
+
+		// 现货提醒复用策略的逐笔成交推送
+		if alert.Market == models.AlertMarketSpot {
+			monitorPublicSymbol(alert.Symbol, alert.UserID, cfg)
+		}
+	}
+
+	priceAlerts.mu.Lock()
+	defer priceAlerts.mu.Unlock()
+
+	// 保留内存中更新过的触发时间，避免数据库异步写入前重复触发
+	for key, list := range grouped {
+		previous := make(map[uint]*models.PriceAlert)
+		for _, alert := range priceAlerts.alerts[key] {
+			previous[alert.ID] = alert
+		}
+		for _, alert := range list {
+			if old, ok := previous[alert.ID]; ok && old.LastTriggeredAt != nil &&
+				(alert.LastTriggeredAt == nil || old.LastTriggeredAt.After(*alert.LastTriggeredAt)) {
+				alert.LastTriggeredAt = old.LastTriggeredAt
+			}
+		}
+	}
+	priceAlerts.alerts = grouped
+
+	// 不再有提醒的交易对释放行情历史
+	for key := range priceAlerts.series {
+		if _, ok := grouped[key]; !ok {
+			delete(priceAlerts.series, key)
+		}
+	}
+}
+
+// hasActiveAlerts 用户在该现货交易对上是否有启用的提醒，用于保持行情连接
+func hasActiveAlerts(userID uint, symbol string) bool {
+	priceAlerts.mu.Lock()
+	defer priceAlerts.mu.Unlock()
+	for _, alert := range priceAlerts.alerts[alertKey(models.AlertMarketSpot, symbol)] {
+		if alert.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// alertSymbols 有启用提醒的交易对
+func alertSymbols(market string) []string {
+	priceAlerts.mu.Lock()
+	defer priceAlerts.mu.Unlock()
+	var symbols []string
+	for _, list := range priceAlerts.alerts {
+		if len(list) > 0 && list[0].Market == market {
+			symbols = append(symbols, list[0].Symbol)
+		}
+	}
+	return symbols
+}
+
+// evaluateSpotAlerts 现货逐笔成交：quoteVolume 为本笔成交额
+func evaluateSpotAlerts(cfg *config.Config, symbol string, price, quoteVolume float64) {
+	priceAlerts.evaluate(cfg, models.AlertMarketSpot, symbol, price, quoteVolume, math.NaN())
+}
+
+// evaluateFuturesAlerts 合约标记价格推送，fundingRate 为小数形式的资金费率
+func evaluateFuturesAlerts(cfg *config.Config, symbol string, markPrice, fundingRate float64) {
+	priceAlerts.evaluate(cfg, models.AlertMarketFutures, symbol, markPrice, 0, fundingRate)
+}
+
+func (e *alertEngine) evaluate(cfg *config.Config, market, symbol string, price, quoteVolume, fundingRate float64) {
+	key := alertKey(market, symbol)
+	now := time.Now()
+
+	e.mu.Lock()
+	alerts := e.alerts[key]
+	if len(alerts) == 0 {
+		e.mu.Unlock()
+		return
+	}
+
+	series, ok := e.series[key]
+	if !ok {
+		series = &alertSeries{}
+		e.series[key] = series
+	}
+	previousPrice := series.lastPrice
+	series.record(now, price, quoteVolume)
+
+	var fired []models.PriceAlert
+	var values []float64
+	for _, alert := range alerts {
+		if alert.LastTriggeredAt != nil && now.Sub(*alert.LastTriggeredAt) < time.Duration(alert.CooldownMinutes)*time.Minute {
+			continue
+		}
+		value, triggered := alertTriggered(alert, series, now, previousPrice, price, fundingRate)
+		if !triggered {
+			continue
+		}
+		triggeredAt := now
+		alert.LastTriggeredAt = &triggeredAt
+		fired = append(fired, *alert)
+		values = append(values, value)
+	}
+
+	// 一次性提醒在下次刷新时因已停用不再加载，之前由冷却时间挡住重复触发
+	e.mu.Unlock()
+
+	for i, alert := range fired {
+		go fireAlert(cfg, alert, values[i], price, now)
+	}
+}
+
+// record 记录价格采样和每分钟成交额，并裁剪过期历史
+func (s *alertSeries) record(now time.Time, price, quoteVolume float64) {
+	s.lastPrice = price
+
+	if n := len(s.prices); n == 0 || now.Sub(s.prices[n-1].at) >= alertSampleInterval {
+		s.prices = append(s.prices, alertSample{at: now, value: price})
+	}
+
+	minute := now.Truncate(time.Minute)
+	if n := len(s.volumes); n > 0 && s.volumes[n-1].at.Equal(minute) {
+		s.volumes[n-1].value += quoteVolume
+	} else {
+		s.volumes = append(s.volumes, alertSample{at: minute, value: quoteVolume})
+	}
+
+	cutoff := now.Add(-alertHistory)
+	if len(s.prices) > 0 && s.prices[0].at.Before(cutoff) {
+		i := sort.Search(len(s.prices), func(i int) bool { return !s.prices[i].at.Before(cutoff) })
+		s.prices = append([]alertSample(nil), s.prices[i:]...)
+	}
+	if len(s.volumes) > 0 && s.volumes[0].at.Before(cutoff) {
+		i := sort.Search(len(s.volumes), func(i int) bool { return !s.volumes[i].at.Before(cutoff) })
+		s.volumes = append([]alertSample(nil), s.volumes[i:]...)
+	}
+}
+
+// alertTriggered 判断提醒是否满足条件，返回触发时的数值
+func alertTriggered(alert *models.PriceAlert, series *alertSeries, now time.Time, previousPrice, price, fundingRate float64) (float64, bool) {
+	switch alert.Type {
+	case models.AlertPriceAbove:
+		// 只在穿越时触发，首个价格没有参照
+		return price, previousPrice > 0 && previousPrice < alert.Threshold && price >= alert.Threshold
+	case models.AlertPriceBelow:
+		return price, previousPrice > 0 && previousPrice > alert.Threshold && price <= alert.Threshold
+	case models.AlertPercentChange:
+		change, ok := series.percentChange(now, time.Duration(alert.WindowMinutes)*time.Minute)
+		return change, ok && math.Abs(change) >= alert.Threshold
+	case models.AlertVolumeSpike:
+		ratio, ok := series.volumeRatio(now, alert.WindowMinutes)
+		return ratio, ok && ratio >= alert.Threshold
+	case models.AlertFundingRateAbove:
+		rate := fundingRate * 100
+		return rate, !math.IsNaN(fundingRate) && rate >= alert.Threshold
+	case models.AlertFundingRateBelow:
+		rate := fundingRate * 100
+		return rate, !math.IsNaN(fundingRate) && rate <= alert.Threshold
+	}
+	return 0, false
+}
+
+// percentChange 当前价格相对窗口起点的涨跌幅，历史不足一个窗口时不计算
+func (s *alertSeries) percentChange(now time.Time, window time.Duration) (float64, bool) {
+	if len(s.prices) == 0 || window <= 0 {
+		return 0, false
+	}
+	start := now.Add(-window)
+	if s.prices[0].at.After(start.Add(alertSampleInterval)) {
+		return 0, false
+	}
+	i := sort.Search(len(s.prices), func(i int) bool { return !s.prices[i].at.Before(start) })
+	if i >= len(s.prices) || s.prices[i].value <= 0 {
+		return 0, false
+	}
+	base := s.prices[i].value
+	return (s.lastPrice - base) / base * 100, true
+}
+
+// volumeRatio 最近窗口成交额与之前同长度窗口平均成交额的倍数
+func (s *alertSeries) volumeRatio(now time.Time, windowMinutes int) (float64, bool) {
+	if windowMinutes <= 0 || len(s.volumes) == 0 {
+		return 0, false
+	}
+	window := time.Duration(windowMinutes) * time.Minute
+	currentStart := now.Truncate(time.Minute).Add(-window + time.Minute)
+
+	var current float64
+	baselines := make([]float64, alertVolumeBaselineWindows)
+	for _, bucket := range s.volumes {
+		if !bucket.at.Before(currentStart) {
+			current += bucket.value
+			continue
+		}
+		index := int(currentStart.Sub(bucket.at)-time.Nanosecond) / int(window)
+		if index < alertVolumeBaselineWindows {
+			baselines[index] += bucket.value
+		}
+	}
+
+	// 只统计历史完整覆盖的基准窗口
+	complete := int(currentStart.Sub(s.volumes[0].at) / window)
+	if complete > alertVolumeBaselineWindows {
+		complete = alertVolumeBaselineWindows
+	}
+	if complete < alertVolumeMinBaselineWindows {
+		return 0, false
+	}
+	var total float64
+	for _, v := range baselines[:complete] {
+		total += v
+	}
+	average := total / float64(complete)
+	if average <= 0 {
+		return 0, false
+	}
+	return current / average, true
+}
+
+// fireAlert 持久化触发记录并发送通知
+func fireAlert(cfg *config.Config, alert models.PriceAlert, value, price float64, at time.Time) {
+	updates := map[string]interface{}{
+		"last_triggered_at": at,
+		"last_value":        value,
+		"trigger_count":     gorm.Expr("trigger_count + 1"),
+	}
+	if !alert.Repeat {
+		updates["enabled"] = false
+	}
+	if err := cfg.DB.Model(&models.PriceAlert{}).Where("id = ?", alert.ID).Updates(updates).Error; err != nil {
+		log.Printf("更新价格提醒 %d 失败: %v", alert.ID, err)
+	}
+
+	title, message := alertMessage(alert, value, price)
+	log.Printf("用户 %d 价格提醒 %d 触发: %s", alert.UserID, alert.ID, title)
+
+	EmitNotification(NotificationEvent{
+		UserID:  alert.UserID,
+		Event:   models.NotifyPriceAlert,
+		Title:   title,
+		Message: message,
+		Data: map[string]interface{}{
+			"alertId":   alert.ID,
+			"market":    alert.Market,
+			"symbol":    alert.Symbol,
+			"type":      alert.Type,
+			"threshold": alert.Threshold,
+			"value":     value,
+			"price":     price,
+		},
+	})
+}
+
+// alertMessage 生成提醒通知的标题和正文
+func alertMessage(alert models.PriceAlert, value, price float64) (string, string) {
+	name := alert.Name
+	if name == "" {
+		name = alert.Symbol
+	}
+
+	var message string
+	switch alert.Type {
+	case models.AlertPriceAbove:
+		message = fmt.Sprintf("%s 价格上穿 %.8f，当前 %.8f", alert.Symbol, alert.Threshold, price)
+	case models.AlertPriceBelow:
+		message = fmt.Sprintf("%s 价格下穿 %.8f，当前 %.8f", alert.Symbol, alert.Threshold, price)
+	case models.AlertPercentChange:
+		message = fmt.Sprintf("%s %d 分钟内涨跌幅 %.2f%%，当前 %.8f", alert.Symbol, alert.WindowMinutes, value, price)
+	case models.AlertVolumeSpike:
+		message = fmt.Sprintf("%s %d 分钟成交额达到近期平均的 %.2f 倍，当前 %.8f", alert.Symbol, alert.WindowMinutes, value, price)
+	case models.AlertFundingRateAbove, models.AlertFundingRateBelow:
+		message = fmt.Sprintf("%s 资金费率 %.4f%%，阈值 %.4f%%，标记价格 %.8f", alert.Symbol, value, alert.Threshold, price)
+	}
+	if alert.Note != "" {
+		message += "\n" + alert.Note
+	}
+	return "价格提醒: " + name, message
+}
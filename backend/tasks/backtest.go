@@ -0,0 +1,534 @@
+package tasks
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+)
+
+const (
+	// backtestDefaultFeeRate 现货默认手续费率（0.1%）
+	backtestDefaultFeeRate = 0.001
+	// backtestDepthLevels 回测合成深度的档位数，与实盘获取深度时的 limit 一致
+	backtestDepthLevels = 20
+	// backtestMinTriggerInterval 同一策略两次触发的最小间隔，与实盘 TryExecuteStrategy 一致
+	backtestMinTriggerInterval = 30 * time.Second
+	// backtestMaxEntrySize zip 包内单个 CSV 解压后的大小上限
+	backtestMaxEntrySize = 1 << 30
+)
+
+// BacktestTick 回放的一笔历史价格
+type BacktestTick struct {
+	Time  time.Time
+	Price float64
+}
+
+// BacktestOptions 回测参数
+type BacktestOptions struct {
+	MakerFeeRate float64         `json:"makerFeeRate"` // 挂单手续费率，默认0.001
+	TakerFeeRate float64         `json:"takerFeeRate"` // 吃单手续费率，默认0.001
+	MaxTriggers  int             `json:"maxTriggers"`  // 最多触发次数，0表示不限制
+	SymbolInfo   *binance.Symbol `json:"-"`            // 交易对精度信息，为空时使用默认精度
+}
+
+// BacktestFill 回测中的一笔成交
+type BacktestFill struct {
+	Trigger  int       `json:"trigger"` // 第几次触发，从1开始
+	Layer    int       `json:"layer"`   // 层级，从0开始
+	Side     string    `json:"side"`
+	Price    float64   `json:"price"`
+	Quantity float64   `json:"quantity"`
+	Fee      float64   `json:"fee"`   // 手续费，以计价资产计
+	Maker    bool      `json:"maker"` // 是否挂单成交
+	Time     time.Time `json:"time"`
+}
+
+// BacktestLayerStats 单层委托的回测统计
+type BacktestLayerStats struct {
+	Layer     int     `json:"layer"`
+	Orders    int     `json:"orders"`
+	Filled    int     `json:"filled"`
+	Cancelled int     `json:"cancelled"`
+	Open      int     `json:"open"`     // 回放结束时仍未成交的委托
+	FillRate  float64 `json:"fillRate"` // 成交委托数 / 委托数
+	FilledQty float64 `json:"filledQty"`
+	AvgPrice  float64 `json:"avgPrice"`
+	Fees      float64 `json:"fees"`
+	PnL       float64 `json:"pnl"` // 按回放结束价格计算的盈亏（已扣手续费）
+}
+
+// BacktestResult 回测结果
+type BacktestResult struct {
+	Symbol       string               `json:"symbol"`
+	StrategyType string               `json:"strategyType"`
+	Side         string               `json:"side"`
+	Start        time.Time            `json:"start"`
+	End          time.Time            `json:"end"`
+	Ticks        int                  `json:"ticks"`
+	Triggers     int                  `json:"triggers"`
+	Orders       int                  `json:"orders"`
+	Filled       int                  `json:"filled"`
+	Cancelled    int                  `json:"cancelled"`
+	Open         int                  `json:"open"`
+	FillRate     float64              `json:"fillRate"`
+	FilledQty    float64              `json:"filledQty"`
+	AvgPrice     float64              `json:"avgPrice"`
+	Fees         float64              `json:"fees"`
+	FinalPrice   float64              `json:"finalPrice"`
+	PnL          float64              `json:"pnl"`
+	Layers       []BacktestLayerStats `json:"layers"`
+	Fills        []BacktestFill       `json:"fills"`
+}
+
+// backtestOrder 回测中挂着的限价单
+type backtestOrder struct {
+	trigger  int
+	layer    int
+	price    float64
+	quantity float64
+	cancelAt time.Time
+}
+
+// ReadBacktestData 读取币安公开数据文件，支持原始 CSV 和 data.binance.vision 下载的 zip 包
+func ReadBacktestData(data []byte) ([]BacktestTick, error) {
+	var ticks []BacktestTick
+	err := readBacktestArchive(data, func(r io.Reader) error {
+		fileTicks, err := LoadBacktestTicks(r)
+		ticks = append(ticks, fileTicks...)
+		return err
+	})
+	return ticks, err
+}
+
+// readBacktestArchive 依次读取数据文件中的 CSV，zip 包内的每个 CSV 都交给 load 解析
+func readBacktestArchive(data []byte, load func(io.Reader) error) error {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		return load(bytes.NewReader(data))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("解析zip文件失败: %v", err)
+	}
+
+	found := false
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".csv") {
+			continue
+		}
+		if f.UncompressedSize64 > backtestMaxEntrySize {
+			return fmt.Errorf("%s 解压后超过 %dMB", f.Name, backtestMaxEntrySize>>20)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("打开 %s 失败: %v", f.Name, err)
+		}
+		// 文件头记录的大小不可信，读取时再限制一次，多读一个字节用于判断是否超限
+		limited := &io.LimitedReader{R: rc, N: backtestMaxEntrySize + 1}
+		err = load(limited)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %v", f.Name, err)
+		}
+		if limited.N == 0 {
+			return fmt.Errorf("%s 解压后超过 %dMB", f.Name, backtestMaxEntrySize>>20)
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("zip文件中没有CSV数据")
+	}
+	return nil
+}
+
+// LoadBacktestTicks 解析K线、归集成交（aggTrades）或逐笔成交（trades）CSV
+// K线每根展开为 开-低-高-收（阴线为 开-高-低-收）四个价格点，成交每行一个价格点
+func LoadBacktestTicks(r io.Reader) ([]BacktestTick, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var ticks []BacktestTick
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取CSV失败: %v", err)
+		}
+		line++
+
+		// 新版数据文件带表头，跳过
+		if line == 1 && len(record) > 0 {
+			if _, err := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case len(record) >= 11:
+			kline, err := parseBacktestKline(record)
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %v", line, err)
+			}
+			ticks = append(ticks, kline...)
+		case len(record) >= 6:
+			tick, err := parseBacktestTrade(record)
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %v", line, err)
+			}
+			ticks = append(ticks, tick)
+		default:
+			return nil, fmt.Errorf("第 %d 行: 无法识别的数据格式（%d 列）", line, len(record))
+		}
+	}
+
+	return ticks, nil
+}
+
+// parseBacktestKline 解析K线行：open_time,open,high,low,close,volume,close_time,...
+func parseBacktestKline(record []string) ([]BacktestTick, error) {
+	values := make([]float64, 4)
+	for i := range values {
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("解析价格失败: %v", err)
+		}
+		values[i] = v
+	}
+	openTime, err := parseBacktestTimestamp(record[0])
+	if err != nil {
+		return nil, err
+	}
+	closeTime, err := parseBacktestTimestamp(record[6])
+	if err != nil {
+		return nil, err
+	}
+
+	open, high, low, closePrice := values[0], values[1], values[2], values[3]
+	step := closeTime.Sub(openTime) / 3
+	first, second := low, high
+	if closePrice < open {
+		first, second = high, low
+	}
+
+	return []BacktestTick{
+		{Time: openTime, Price: open},
+		{Time: openTime.Add(step), Price: first},
+		{Time: openTime.Add(2 * step), Price: second},
+		{Time: closeTime, Price: closePrice},
+	}, nil
+}
+
+// parseBacktestTrade 解析成交行，价格均在第2列：
+// 归集成交 agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker[,is_best_match]
+// 逐笔成交 id,price,qty,quote_qty,time,is_buyer_maker[,is_best_match]
+func parseBacktestTrade(record []string) (BacktestTick, error) {
+	price, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+	if err != nil {
+		return BacktestTick{}, fmt.Errorf("解析价格失败: %v", err)
+	}
+	timeColumn := 5
+	if _, err := strconv.ParseBool(strings.TrimSpace(record[5])); err == nil {
+		timeColumn = 4
+	}
+	ts, err := parseBacktestTimestamp(record[timeColumn])
+	if err != nil {
+		return BacktestTick{}, err
+	}
+	return BacktestTick{Time: ts, Price: price}, nil
+}
+
+// parseBacktestTimestamp 解析时间戳，2025年起现货数据使用微秒
+func parseBacktestTimestamp(value string) (time.Time, error) {
+	ts, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("解析时间戳失败: %v", err)
+	}
+	if ts > 1e14 {
+		return time.UnixMicro(ts), nil
+	}
+	return time.UnixMilli(ts), nil
+}
+
+// RunBacktest 用历史价格回放现货策略
+// 触发规则和分层价格计算与实盘共用 spotStrategyTriggered / planStrategyOrders，
+// 指标条件按回放到当前时刻的价格合成K线求值；
+// 历史数据没有盘口，深度按当前价格和最小价格变动单位合成。
+// 穿价的委托按吃单立即成交，其余限价单在之后价格严格穿过委托价时按委托价成交。
+func RunBacktest(strategy models.Strategy, ticks []BacktestTick, opts BacktestOptions) (*BacktestResult, error) {
+	if strategy.StrategyType == "grid" {
+		return nil, fmt.Errorf("暂不支持网格策略回测")
+	}
+	if strategy.Side != "BUY" && strategy.Side != "SELL" {
+		return nil, fmt.Errorf("无效的交易方向: %s", strategy.Side)
+	}
+	if (!strategy.Price.IsPositive() && strategy.TriggerCondition == "") || !strategy.TotalQuantity.IsPositive() {
+		return nil, fmt.Errorf("触发价格（或指标条件）和总数量必须大于0")
+	}
+	var condition *services.Condition
+	if strategy.TriggerCondition != "" {
+		var err error
+		if condition, err = services.ParseCondition(strategy.TriggerCondition); err != nil {
+			return nil, fmt.Errorf("触发条件无效: %v", err)
+		}
+	}
+	if len(ticks) == 0 {
+		return nil, fmt.Errorf("没有历史价格数据")
+	}
+
+	if opts.MakerFeeRate <= 0 {
+		opts.MakerFeeRate = backtestDefaultFeeRate
+	}
+	if opts.TakerFeeRate <= 0 {
+		opts.TakerFeeRate = backtestDefaultFeeRate
+	}
+	// 没有交易规则时按8位精度、最小名义价值10计算
+	filters := &services.SymbolFilters{Symbol: strategy.Symbol, TickSize: 1e-8, StepSize: 1e-8, MinNotional: 10}
+	if opts.SymbolInfo != nil {
+		filters = services.ParseSymbolFilters(opts.SymbolInfo.Symbol, opts.SymbolInfo.Filters)
+	}
+	tickSize := filters.TickSize
+	if tickSize <= 0 {
+		tickSize = 1e-8
+	}
+
+	sort.SliceStable(ticks, func(i, j int) bool {
+		return ticks[i].Time.Before(ticks[j].Time)
+	})
+
+	result := &BacktestResult{
+		Symbol:       strategy.Symbol,
+		StrategyType: strategy.StrategyType,
+		Side:         strategy.Side,
+		Start:        ticks[0].Time,
+		End:          ticks[len(ticks)-1].Time,
+		Ticks:        len(ticks),
+		FinalPrice:   ticks[len(ticks)-1].Price,
+	}
+	layers := make(map[int]*BacktestLayerStats)
+	layerStats := func(layer int) *BacktestLayerStats {
+		if stats, ok := layers[layer]; ok {
+			return stats
+		}
+		stats := &BacktestLayerStats{Layer: layer}
+		layers[layer] = stats
+		return stats
+	}
+
+	fill := func(order *backtestOrder, price float64, maker bool, ts time.Time) {
+		feeRate := opts.TakerFeeRate
+		if maker {
+			feeRate = opts.MakerFeeRate
+		}
+		result.Fills = append(result.Fills, BacktestFill{
+			Trigger:  order.trigger,
+			Layer:    order.layer,
+			Side:     strategy.Side,
+			Price:    price,
+			Quantity: order.quantity,
+			Fee:      price * order.quantity * feeRate,
+			Maker:    maker,
+			Time:     ts,
+		})
+		layerStats(order.layer).Filled++
+	}
+
+	closes := newBacktestCloses(condition)
+
+	var open []*backtestOrder
+	var lastTrigger time.Time
+	for _, tick := range ticks {
+		closes.add(tick)
+
+		// 先处理超时撤单，再撮合之前挂出的限价单
+		remaining := open[:0]
+		for _, order := range open {
+			switch {
+			case !tick.Time.Before(order.cancelAt):
+				layerStats(order.layer).Cancelled++
+			case strategy.Side == "BUY" && tick.Price < order.price,
+				strategy.Side == "SELL" && tick.Price > order.price:
+				fill(order, order.price, true, tick.Time)
+			default:
+				remaining = append(remaining, order)
+			}
+		}
+		open = remaining
+
+		// 仍有未完成的委托时策略处于 pending_batch 状态，不会再次触发
+		if len(open) > 0 || !spotStrategyTriggered(strategy, tick.Price, func() bool {
+			return condition.Evaluate(tick.Price, closes.source).Result
+		}) {
+			continue
+		}
+		if opts.MaxTriggers > 0 && result.Triggers >= opts.MaxTriggers {
+			continue
+		}
+		if !lastTrigger.IsZero() && tick.Time.Sub(lastTrigger) < backtestMinTriggerInterval {
+			continue
+		}
+
+		planned, err := planStrategyOrders(strategy, strategy.Side, backtestDepth(tick.Price, tickSize), filters)
+		if err != nil {
+			return nil, fmt.Errorf("%s 计算委托失败: %v", tick.Time.Format(time.RFC3339), err)
+		}
+		lastTrigger = tick.Time
+		result.Triggers++
+
+		for _, layer := range planned {
+			price, quantity := layer.Price.InexactFloat64(), layer.Quantity.InexactFloat64()
+			if price <= 0 || quantity <= 0 {
+				continue
+			}
+			order := &backtestOrder{
+				trigger:  result.Triggers,
+				layer:    layer.Layer,
+				price:    price,
+				quantity: quantity,
+				cancelAt: tick.Time.Add(strategyCancelAfter(strategy)),
+			}
+			layerStats(layer.Layer).Orders++
+
+			// 穿过当前价格的委托作为吃单立即成交
+			if strategy.Side == "BUY" && price >= tick.Price || strategy.Side == "SELL" && price <= tick.Price {
+				fill(order, tick.Price, false, tick.Time)
+				continue
+			}
+			open = append(open, order)
+		}
+	}
+
+	for _, order := range open {
+		layerStats(order.layer).Open++
+	}
+
+	// 按回放结束价格计算盈亏
+	notional := make(map[int]float64)
+	for _, f := range result.Fills {
+		stats := layerStats(f.Layer)
+		stats.FilledQty += f.Quantity
+		stats.Fees += f.Fee
+		notional[f.Layer] += f.Price * f.Quantity
+
+		pnl := (result.FinalPrice - f.Price) * f.Quantity
+		if f.Side == "SELL" {
+			pnl = -pnl
+		}
+		stats.PnL += pnl - f.Fee
+	}
+
+	var totalNotional float64
+	for _, stats := range layers {
+		if stats.Orders > 0 {
+			stats.FillRate = float64(stats.Filled) / float64(stats.Orders)
+		}
+		if stats.FilledQty > 0 {
+			stats.AvgPrice = notional[stats.Layer] / stats.FilledQty
+		}
+		result.Orders += stats.Orders
+		result.Filled += stats.Filled
+		result.Cancelled += stats.Cancelled
+		result.Open += stats.Open
+		result.FilledQty += stats.FilledQty
+		result.Fees += stats.Fees
+		result.PnL += stats.PnL
+		totalNotional += notional[stats.Layer]
+		result.Layers = append(result.Layers, *stats)
+	}
+	sort.Slice(result.Layers, func(i, j int) bool {
+		return result.Layers[i].Layer < result.Layers[j].Layer
+	})
+	if result.Orders > 0 {
+		result.FillRate = float64(result.Filled) / float64(result.Orders)
+	}
+	if result.FilledQty > 0 {
+		result.AvgPrice = totalNotional / result.FilledQty
+	}
+
+	return result, nil
+}
+
+// backtestDepth 以成交价为中心合成盘口，买卖盘按最小价格变动单位逐档展开
+func backtestDepth(price, tickSize float64) *binance.DepthResponse {
+	depth := &binance.DepthResponse{}
+	for i := 0; i < backtestDepthLevels; i++ {
+		offset := float64(i) * tickSize
+		depth.Asks = append(depth.Asks, binance.Ask{
+			Price:    strconv.FormatFloat(price+offset, 'f', -1, 64),
+			Quantity: "0",
+		})
+		if bid := price - offset; bid > 0 {
+			depth.Bids = append(depth.Bids, binance.Bid{
+				Price:    strconv.FormatFloat(bid, 'f', -1, 64),
+				Quantity: "0",
+			})
+		}
+	}
+	return depth
+}
+
+// backtestCloses 按回放进度合成条件用到的各周期K线收盘价，最后一根为当前未收盘K线
+type backtestCloses struct {
+	series map[string]*backtestSeries
+}
+
+type backtestSeries struct {
+	duration time.Duration
+	current  time.Time
+	closes   []float64
+}
+
+func newBacktestCloses(condition *services.Condition) *backtestCloses {
+	c := &backtestCloses{series: make(map[string]*backtestSeries)}
+	if condition == nil {
+		return c
+	}
+	for _, tf := range condition.Timeframes() {
+		if duration, ok := services.ConditionTimeframes[tf]; ok {
+			c.series[tf] = &backtestSeries{duration: duration}
+		}
+	}
+	return c
+}
+
+// add 将一笔价格计入所在周期的K线
+func (c *backtestCloses) add(tick BacktestTick) {
+	for _, s := range c.series {
+		openTime := tick.Time.Truncate(s.duration)
+		if len(s.closes) == 0 || !openTime.Equal(s.current) {
+			s.closes = append(s.closes, tick.Price)
+			s.current = openTime
+			continue
+		}
+		s.closes[len(s.closes)-1] = tick.Price
+	}
+}
+
+// source 实现 services.CloseSource
+func (c *backtestCloses) source(timeframe string, bars int) ([]float64, error) {
+	s, ok := c.series[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("不支持的K线周期: %s", timeframe)
+	}
+	if len(s.closes) == 0 {
+		return nil, fmt.Errorf("回测数据不足")
+	}
+	if len(s.closes) > bars {
+		return s.closes[len(s.closes)-bars:], nil
+	}
+	return s.closes, nil
+}
@@ -0,0 +1,470 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 周期结束后等待迟到推送的时间
+	candleCloseGrace = 2 * time.Second
+	// 同一交易对两次REST同步的最小间隔，避免连接抖动时频繁请求
+	candleSyncCooldown = time.Minute
+	// 本地没有数据时每个周期回补的K线数量
+	candleBackfillBars = 500
+	// 单次K线请求的最大数量
+	candleKlinesPageSize = 1000
+	// 单个周期最多分页次数，1m 保留7天约需11页
+	candleMaxSyncPages = 20
+
+	// MaxCandleLimit 单次查询返回的最大K线数
+	MaxCandleLimit = 1500
+)
+
+// candleDurations 各周期的时长，1d 按UTC零点对齐，与币安一致
+var candleDurations = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// candleBar 内存中正在聚合的K线
+type candleBar struct {
+	market      string
+	symbol      string
+	interval    string
+	openTime    time.Time
+	open        float64
+	high        float64
+	low         float64
+	close       float64
+	volume      float64
+	quoteVolume float64
+	partial     bool // 推送未覆盖整个周期，收盘后以REST数据为准
+	dirty       bool // 有未落库的更新
+}
+
+// candleAggregator 从行情推送聚合K线，断线期间缺失的数据由REST补齐
+type candleAggregator struct {
+	mu        sync.Mutex
+	bars      map[string]*candleBar // market|symbol|interval -> 当前周期
+	pending   []*candleBar          // 已被新周期替换但尚未落库的K线
+	connected map[string]time.Time  // market|symbol -> 推送连接建立时间
+	syncDue   map[string]bool       // market|symbol -> 需要REST同步
+	syncing   map[string]bool       // market|symbol -> 同步进行中
+	lastSync  map[string]time.Time  // market|symbol -> 最近同步时间
+}
+
+var candleStore = &candleAggregator{
+	bars:      make(map[string]*candleBar),
+	connected: make(map[string]time.Time),
+	syncDue:   make(map[string]bool),
+	syncing:   make(map[string]bool),
+	lastSync:  make(map[string]time.Time),
+}
+
+func candleStreamKey(market, symbol string) string {
+	return market + "|" + symbol
+}
+
+func candleBarKey(market, symbol, interval string) string {
+	return market + "|" + symbol + "|" + interval
+}
+
+// StartCandleStore 定期落库已收盘K线、补齐缺口并清理过期数据
+func StartCandleStore(ctx context.Context, cfg *config.Config) {
+	spawnTask(ctx, "K线清理", func() { cleanupCandles(ctx, cfg) })
+
+	ticker := time.NewTicker(cfg.Tasks.CandleFlushInterval)
+	defer ticker.Stop()
+
+	log.Println("K线存储已启动")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		candleStore.flush(cfg)
+	}
+}
+
+// candleStreamConnected 行情推送连接建立，之前的K线可能缺数据，需要REST补齐
+func candleStreamConnected(market, symbol string) {
+	a := candleStore
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := candleStreamKey(market, symbol)
+	a.connected[key] = time.Now()
+	a.syncDue[key] = true
+	a.markPartial(market, symbol)
+}
+
+// RequestCandleSync 请求从REST同步交易对K线，用于没有行情推送的交易对按需回补
+func RequestCandleSync(market, symbol string) {
+	candleStore.mu.Lock()
+	defer candleStore.mu.Unlock()
+	candleStore.syncDue[candleStreamKey(market, symbol)] = true
+}
+
+// candleStreamDisconnected 行情推送断开，当前周期不再完整
+func candleStreamDisconnected(market, symbol string) {
+	a := candleStore
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.connected, candleStreamKey(market, symbol))
+	a.markPartial(market, symbol)
+}
+
+// markPartial 标记交易对所有正在聚合的K线为不完整，调用方持有锁
+func (a *candleAggregator) markPartial(market, symbol string) {
+	for _, interval := range models.CandleIntervals {
+		if bar := a.bars[candleBarKey(market, symbol, interval)]; bar != nil {
+			bar.partial = true
+		}
+	}
+}
+
+// recordCandleTrade 将一笔成交或一次标记价格计入各周期K线，quantity 为0时只更新价格
+func recordCandleTrade(market, symbol string, price, quantity float64, at time.Time) {
+	if price <= 0 {
+		return
+	}
+	a := candleStore
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	connectedAt, connected := a.connected[candleStreamKey(market, symbol)]
+	for _, interval := range models.CandleIntervals {
+		openTime := at.Truncate(candleDurations[interval])
+		key := candleBarKey(market, symbol, interval)
+		bar := a.bars[key]
+
+		if bar != nil && openTime.Before(bar.openTime) {
+			continue // 乱序的旧推送
+		}
+		if bar == nil || openTime.After(bar.openTime) {
+			if bar != nil {
+				if bar.dirty {
+					a.pending = append(a.pending, bar)
+				}
+				// 中间有周期没有推送，由REST补齐
+				if openTime.Sub(bar.openTime) > candleDurations[interval] {
+					a.syncDue[candleStreamKey(market, symbol)] = true
+				}
+			}
+			bar = &candleBar{
+				market:   market,
+				symbol:   symbol,
+				interval: interval,
+				openTime: openTime,
+				open:     price,
+				high:     price,
+				low:      price,
+				partial:  !connected || connectedAt.After(openTime),
+			}
+			a.bars[key] = bar
+		}
+
+		if price > bar.high {
+			bar.high = price
+		}
+		if price < bar.low {
+			bar.low = price
+		}
+		bar.close = price
+		bar.volume += quantity
+		bar.quoteVolume += price * quantity
+		bar.dirty = true
+	}
+}
+
+// flush 写入已收盘的完整K线，不完整的K线和缺口交由REST同步
+func (a *candleAggregator) flush(cfg *config.Config) {
+	now := time.Now()
+	var candles []models.Candle
+
+	a.mu.Lock()
+	closed := a.pending
+	a.pending = nil
+	for _, bar := range a.bars {
+		if bar.dirty && now.Sub(bar.openTime) >= candleDurations[bar.interval]+candleCloseGrace {
+			closed = append(closed, bar)
+		}
+	}
+	for _, bar := range closed {
+		bar.dirty = false
+		if bar.partial {
+			a.syncDue[candleStreamKey(bar.market, bar.symbol)] = true
+			continue
+		}
+		candles = append(candles, bar.candle())
+	}
+
+	var syncKeys []string
+	for key := range a.syncDue {
+		if a.syncing[key] || now.Sub(a.lastSync[key]) < candleSyncCooldown {
+			continue
+		}
+		delete(a.syncDue, key)
+		a.syncing[key] = true
+		a.lastSync[key] = now
+		syncKeys = append(syncKeys, key)
+	}
+	a.mu.Unlock()
+
+	if err := saveCandles(cfg.DB, candles); err != nil {
+		log.Printf("保存K线失败: %v", err)
+	}
+
+	for _, key := range syncKeys {
+		go func(key string) {
+			market, symbol := splitCandleStreamKey(key)
+			if err := syncCandles(cfg, market, symbol); err != nil {
+				log.Printf("同步 %s %s K线失败: %v", market, symbol, err)
+				a.mu.Lock()
+				a.syncDue[key] = true
+				a.mu.Unlock()
+			}
+			a.mu.Lock()
+			delete(a.syncing, key)
+			a.mu.Unlock()
+		}(key)
+	}
+}
+
+func splitCandleStreamKey(key string) (string, string) {
+	market, symbol, _ := strings.Cut(key, "|")
+	return market, symbol
+}
+
+// candle 转换为数据库记录
+func (b *candleBar) candle() models.Candle {
+	duration := candleDurations[b.interval]
+	return models.Candle{
+		Market:      b.market,
+		Symbol:      b.symbol,
+		Interval:    b.interval,
+		OpenTime:    b.openTime.UnixMilli(),
+		Open:        decimal.NewFromFloat(b.open),
+		High:        decimal.NewFromFloat(b.high),
+		Low:         decimal.NewFromFloat(b.low),
+		Close:       decimal.NewFromFloat(b.close),
+		Volume:      decimal.NewFromFloat(b.volume),
+		QuoteVolume: decimal.NewFromFloat(b.quoteVolume),
+		CloseTime:   b.openTime.Add(duration).UnixMilli() - 1,
+	}
+}
+
+// saveCandles 按唯一键写入K线，已存在时覆盖
+func saveCandles(db *gorm.DB, candles []models.Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "market"}, {Name: "symbol"}, {Name: "period"}, {Name: "open_time"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"open", "high", "low", "close", "volume", "quote_volume", "close_time", "updated_at",
+		}),
+	}).CreateInBatches(candles, 200).Error
+}
+
+// syncCandles 从最后一根已存K线开始拉取REST K线，本地没有数据时回补最近 candleBackfillBars 根
+func syncCandles(cfg *config.Config, market, symbol string) error {
+	exchange := services.NewExchange("", "")
+	now := time.Now()
+
+	for _, interval := range models.CandleIntervals {
+		duration := candleDurations[interval]
+		start := now.Add(-duration * candleBackfillBars).Truncate(duration)
+
+		var last models.Candle
+		err := cfg.DB.Where("market = ? AND symbol = ? AND period = ?", market, symbol, interval).
+			Order("open_time DESC").First(&last).Error
+		if err == nil {
+			// 包含最后一根，它可能在上次同步时尚未收盘
+			start = time.UnixMilli(last.OpenTime)
+		} else if err != gorm.ErrRecordNotFound {
+			return fmt.Errorf("查询最新K线失败: %v", err)
+		}
+		if retention := models.CandleRetention[interval]; retention > 0 && start.Before(now.Add(-retention)) {
+			start = now.Add(-retention).Truncate(duration)
+		}
+
+		startTime := start.UnixMilli()
+		for page := 0; page < candleMaxSyncPages; page++ {
+			candles, err := fetchCandles(exchange, market, symbol, interval, startTime)
+			if err != nil {
+				return err
+			}
+			if err := saveCandles(cfg.DB, candles); err != nil {
+				return fmt.Errorf("保存K线失败: %v", err)
+			}
+			if len(candles) < candleKlinesPageSize {
+				break
+			}
+			startTime = candles[len(candles)-1].OpenTime + 1
+		}
+	}
+	return nil
+}
+
+// fetchCandles 拉取一页REST K线，合约使用标记价格K线，只保留价格
+func fetchCandles(exchange services.Exchange, market, symbol, interval string, startTime int64) ([]models.Candle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var candles []models.Candle
+	if market == models.CandleMarketFutures {
+		klines, err := exchange.ListFuturesMarkPriceKlines(ctx, symbol, interval, startTime, 0, candleKlinesPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("获取合约K线失败: %v", err)
+		}
+		for _, k := range klines {
+			// 标记价格K线的成交量字段没有意义，与实时聚合一致置0
+			candles = append(candles, klineCandle(market, symbol, interval, k.OpenTime, k.CloseTime,
+				k.Open, k.High, k.Low, k.Close, "0", "0"))
+		}
+		return candles, nil
+	}
+
+	klines, err := exchange.ListKlines(ctx, symbol, interval, startTime, 0, candleKlinesPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("获取现货K线失败: %v", err)
+	}
+	for _, k := range klines {
+		candles = append(candles, klineCandle(market, symbol, interval, k.OpenTime, k.CloseTime,
+			k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteAssetVolume))
+	}
+	return candles, nil
+}
+
+func klineCandle(market, symbol, interval string, openTime, closeTime int64, open, high, low, close, volume, quoteVolume string) models.Candle {
+	parse := func(s string) decimal.Decimal {
+		d, err := decimal.NewFromString(s)
+		if err != nil {
+			return decimal.Zero
+		}
+		return d
+	}
+	return models.Candle{
+		Market:      market,
+		Symbol:      symbol,
+		Interval:    interval,
+		OpenTime:    openTime,
+		Open:        parse(open),
+		High:        parse(high),
+		Low:         parse(low),
+		Close:       parse(close),
+		Volume:      parse(volume),
+		QuoteVolume: parse(quoteVolume),
+		CloseTime:   closeTime,
+	}
+}
+
+// cleanupCandles 按保留策略删除过期K线
+func cleanupCandles(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.CandleCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for interval, retention := range models.CandleRetention {
+			if retention <= 0 {
+				continue
+			}
+			cutoff := now.Add(-retention).UnixMilli()
+			result := cfg.DB.Where("period = ? AND open_time < ?", interval, cutoff).Delete(&models.Candle{})
+			if result.Error != nil {
+				log.Printf("清理 %s K线失败: %v", interval, result.Error)
+			} else if result.RowsAffected > 0 {
+				log.Printf("清理了 %d 根过期的 %s K线", result.RowsAffected, interval)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetCandles 查询K线，按开盘时间升序，endTime 为0时包含正在聚合的当前K线
+func GetCandles(db *gorm.DB, market, symbol, interval string, endTime int64, limit int) ([]models.Candle, error) {
+	if _, ok := candleDurations[interval]; !ok {
+		return nil, fmt.Errorf("不支持的K线周期: %s", interval)
+	}
+	if limit <= 0 || limit > MaxCandleLimit {
+		limit = MaxCandleLimit
+	}
+
+	query := db.Where("market = ? AND symbol = ? AND period = ?", market, symbol, interval)
+	if endTime > 0 {
+		query = query.Where("open_time <= ?", endTime)
+	}
+	var candles []models.Candle
+	if err := query.Order("open_time DESC").Limit(limit).Find(&candles).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(candles)-1; i < j; i, j = i+1, j-1 {
+		candles[i], candles[j] = candles[j], candles[i]
+	}
+
+	if endTime > 0 {
+		return candles, nil
+	}
+	return candleStore.overlay(candles, market, symbol, interval, limit), nil
+}
+
+// overlay 用内存中的当前K线更新查询结果的最后一根
+func (a *candleAggregator) overlay(candles []models.Candle, market, symbol, interval string, limit int) []models.Candle {
+	a.mu.Lock()
+	bar := a.bars[candleBarKey(market, symbol, interval)]
+	var current models.Candle
+	partial := false
+	if bar != nil {
+		current = bar.candle()
+		partial = bar.partial
+	}
+	a.mu.Unlock()
+
+	if bar == nil {
+		return candles
+	}
+	if n := len(candles); n > 0 && candles[n-1].OpenTime == current.OpenTime {
+		if partial {
+			// 推送只覆盖了周期的后半段，与REST同步的数据合并，成交量为近似值
+			stored := candles[n-1]
+			current.Open = stored.Open
+			current.High = decimal.Max(stored.High, current.High)
+			current.Low = decimal.Min(stored.Low, current.Low)
+			current.Volume = stored.Volume.Add(current.Volume)
+			current.QuoteVolume = stored.QuoteVolume.Add(current.QuoteVolume)
+		}
+		candles[n-1] = current
+		return candles
+	}
+	if n := len(candles); n > 0 && candles[n-1].OpenTime > current.OpenTime {
+		return candles
+	}
+	candles = append(candles, current)
+	if len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles
+}
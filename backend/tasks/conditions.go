@@ -0,0 +1,166 @@
+package tasks
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"gorm.io/gorm"
+)
+
+const (
+	// 条件求值使用的K线缓存时间，逐笔求值时避免每次查询数据库
+	conditionCandleCacheTTL = 5 * time.Second
+)
+
+type conditionCandleEntry struct {
+	at      time.Time
+	candles []models.Candle
+}
+
+// conditionCandleCache 按 market|symbol|interval|limit 缓存K线
+var conditionCandleCache = struct {
+	mu      sync.Mutex
+	entries map[string]conditionCandleEntry
+}{entries: make(map[string]conditionCandleEntry)}
+
+// EvaluateCondition 以最新价格和K线数据对条件求值
+func EvaluateCondition(db *gorm.DB, market, symbol, expr string, price float64) (services.ConditionResult, error) {
+	condition, err := services.ParseCondition(expr)
+	if err != nil {
+		return services.ConditionResult{}, err
+	}
+	return condition.Evaluate(price, conditionCloseSource(db, market, symbol)), nil
+}
+
+// liveStrategyTriggered 现货策略是否触发，条件按K线存储求值
+func liveStrategyTriggered(cfg *config.Config, strategy models.Strategy, currentPrice float64) bool {
+	return spotStrategyTriggered(strategy, currentPrice, func() bool {
+		return conditionTriggered(cfg, models.CandleMarketSpot, strategy.Symbol, "策略", strategy.ID, strategy.TriggerCondition, currentPrice)
+	})
+}
+
+// spotStrategyTriggered 现货策略触发规则，实盘和回测共用：同时设置触发价格和条件时两者都需满足，
+// 只设置条件时忽略价格；condition 只在需要时调用
+func spotStrategyTriggered(strategy models.Strategy, currentPrice float64, condition func() bool) bool {
+	if strategy.TriggerCondition == "" || strategy.Price.IsPositive() {
+		if !strategyTriggered(strategy, currentPrice) {
+			return false
+		}
+	}
+	if strategy.TriggerCondition == "" {
+		return true
+	}
+	return condition()
+}
+
+// liveFuturesStrategyTriggered 合约策略是否触发，规则同 liveStrategyTriggered
+func liveFuturesStrategyTriggered(cfg *config.Config, strategy *models.FuturesStrategy, currentPrice float64) bool {
+	if strategy.TriggerCondition == "" || strategy.BasePrice.IsPositive() {
+		if !futuresStrategyTriggered(strategy, currentPrice) {
+			return false
+		}
+	}
+	if strategy.TriggerCondition == "" {
+		return true
+	}
+	return conditionTriggered(cfg, models.CandleMarketFutures, strategy.Symbol, "期货策略", strategy.ID, strategy.TriggerCondition, currentPrice)
+}
+
+func conditionTriggered(cfg *config.Config, market, symbol, kind string, strategyID uint, expr string, price float64) bool {
+	result, err := EvaluateCondition(cfg.DB, market, symbol, expr, price)
+	if err != nil {
+		log.Printf("%s %d 触发条件无效: %v", kind, strategyID, err)
+		return false
+	}
+	if result.Result {
+		log.Printf("%s %d 满足触发条件: %s", kind, strategyID, expr)
+	}
+	return result.Result
+}
+
+// conditionCloseSource 从K线存储读取收盘价，非存储周期由存储K线合成
+func conditionCloseSource(db *gorm.DB, market, symbol string) services.CloseSource {
+	return func(timeframe string, bars int) ([]float64, error) {
+		duration, ok := services.ConditionTimeframes[timeframe]
+		if !ok {
+			return nil, fmt.Errorf("不支持的K线周期: %s", timeframe)
+		}
+		base := services.ConditionBaseInterval(timeframe)
+		ratio := int(duration / candleDurations[base])
+		limit := (bars + 1) * ratio
+		if limit > MaxCandleLimit {
+			limit = MaxCandleLimit
+		}
+
+		candles, err := cachedConditionCandles(db, market, symbol, base, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(candles) == 0 {
+			RequestCandleSync(market, symbol)
+			return nil, fmt.Errorf("%s 暂无K线数据", symbol)
+		}
+
+		// 最新K线过旧说明行情中断，不能用于判断
+		last := time.UnixMilli(candles[len(candles)-1].OpenTime)
+		if last.Before(time.Now().Truncate(duration).Add(-duration)) {
+			RequestCandleSync(market, symbol)
+			return nil, fmt.Errorf("%s K线数据未更新，最新K线开盘于 %s", symbol, last.Format("2006-01-02 15:04"))
+		}
+
+		closes := resampleCloses(candles, duration)
+		if len(closes) > bars {
+			closes = closes[len(closes)-bars:]
+		}
+		return closes, nil
+	}
+}
+
+func cachedConditionCandles(db *gorm.DB, market, symbol, interval string, limit int) ([]models.Candle, error) {
+	key := fmt.Sprintf("%s|%s|%s|%d", market, symbol, interval, limit)
+	now := time.Now()
+
+	conditionCandleCache.mu.Lock()
+	if entry, ok := conditionCandleCache.entries[key]; ok && now.Sub(entry.at) < conditionCandleCacheTTL {
+		conditionCandleCache.mu.Unlock()
+		return entry.candles, nil
+	}
+	conditionCandleCache.mu.Unlock()
+
+	candles, err := GetCandles(db, market, symbol, interval, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	conditionCandleCache.mu.Lock()
+	for k, entry := range conditionCandleCache.entries {
+		if now.Sub(entry.at) > time.Minute {
+			delete(conditionCandleCache.entries, k)
+		}
+	}
+	conditionCandleCache.entries[key] = conditionCandleEntry{at: now, candles: candles}
+	conditionCandleCache.mu.Unlock()
+	return candles, nil
+}
+
+// resampleCloses 将K线按 duration 合并，返回每根合成K线的收盘价
+func resampleCloses(candles []models.Candle, duration time.Duration) []float64 {
+	var closes []float64
+	var current time.Time
+	for i, candle := range candles {
+		openTime := time.UnixMilli(candle.OpenTime).Truncate(duration)
+		closePrice := candle.Close.InexactFloat64()
+		if i == 0 || !openTime.Equal(current) {
+			closes = append(closes, closePrice)
+			current = openTime
+			continue
+		}
+		closes[len(closes)-1] = closePrice
+	}
+	return closes
+}
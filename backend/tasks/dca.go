@@ -0,0 +1,257 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StartDCAScheduler 定期执行到期的定投计划
+func StartDCAScheduler(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.DCAInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// 服务停止中不再执行新的定投
+		if !beginOrderPlacement() {
+			return
+		}
+		runDueDCAStrategies(cfg)
+		endOrderPlacement()
+	}
+}
+
+// runDueDCAStrategies 执行所有到期的定投策略
+func runDueDCAStrategies(cfg *config.Config) {
+	now := time.Now()
+
+	var strategies []models.DCAStrategy
+	if err := cfg.DB.Where("enabled = ? AND status = ? AND next_run_at <= ? AND deleted_at IS NULL", true, "active", now).
+		Find(&strategies).Error; err != nil {
+		log.Printf("获取到期定投策略失败: %v", err)
+		return
+	}
+
+	for _, strategy := range strategies {
+		// 先推进下次执行时间再下单，保证同一计划时间最多执行一次；
+		// 服务停机错过的多个周期只补执行一次
+		next, err := NextDCARun(strategy, now)
+		if err != nil {
+			log.Printf("定投策略 %d 计划无效，已停用: %v", strategy.ID, err)
+			cfg.DB.Model(&strategy).Updates(map[string]interface{}{"enabled": false, "last_error": err.Error()})
+			continue
+		}
+		result := cfg.DB.Model(&models.DCAStrategy{}).
+			Where("id = ? AND next_run_at = ?", strategy.ID, strategy.NextRunAt).
+			Update("next_run_at", next)
+		if result.Error != nil {
+			log.Printf("更新定投策略 %d 下次执行时间失败: %v", strategy.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := executeDCA(cfg, strategy); err != nil {
+			log.Printf("定投策略 %d 执行失败: %v", strategy.ID, err)
+			cfg.DB.Model(&strategy).Updates(map[string]interface{}{"last_run_at": now, "last_error": err.Error()})
+		}
+	}
+}
+
+// dcaMultiplier 价格低于平均成本时按最大满足的跌幅档位放大投入金额
+func dcaMultiplier(strategy models.DCAStrategy, price decimal.Decimal) decimal.Decimal {
+	if !strategy.AverageCost.IsPositive() {
+		return decimal.NewFromInt(1)
+	}
+	items, err := strategy.ParseDipMultipliers()
+	if err != nil {
+		log.Printf("定投策略 %d 加倍配置解析失败: %v", strategy.ID, err)
+		return decimal.NewFromInt(1)
+	}
+
+	drop := strategy.AverageCost.Sub(price).Div(strategy.AverageCost).Shift(2).InexactFloat64()
+	multiplier := 1.0
+	bestDrop := 0.0
+	for _, item := range items {
+		if item.Multiplier > 0 && drop >= item.DropPercent && item.DropPercent >= bestDrop {
+			bestDrop = item.DropPercent
+			multiplier = item.Multiplier
+		}
+	}
+	return decimal.NewFromFloat(multiplier)
+}
+
+// executeDCA 执行一次定投买入
+func executeDCA(cfg *config.Config, strategy models.DCAStrategy) error {
+	var user models.User
+	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
+		return fmt.Errorf("用户未找到: %v", err)
+	}
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
+	if err != nil {
+		return err
+	}
+
+	prices, err := exchange.ListPrices(context.Background(), strategy.Symbol)
+	if err != nil || len(prices) == 0 {
+		return fmt.Errorf("获取 %s 价格失败: %v", strategy.Symbol, err)
+	}
+	price, err := decimal.NewFromString(prices[0].Price)
+	if err != nil || !price.IsPositive() {
+		return fmt.Errorf("无效的价格: %s", prices[0].Price)
+	}
+
+	amount := strategy.QuoteAmount.Mul(dcaMultiplier(strategy, price))
+	if strategy.MaxTotalQuote.IsPositive() {
+		remaining := strategy.MaxTotalQuote.Sub(strategy.TotalQuote)
+		if !remaining.IsPositive() {
+			cfg.DB.Model(&strategy).Update("status", "completed")
+			log.Printf("定投策略 %d 已达到累计投入上限，策略完成", strategy.ID)
+			return nil
+		}
+		amount = decimal.Min(amount, remaining)
+	}
+
+	filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
+	if err != nil {
+		return err
+	}
+	quantity := filters.QuantizeQuantity(amount.Div(price), services.RoundDown)
+	quantityStr := filters.QuantityString(quantity)
+	notional := quantity.Mul(price).InexactFloat64()
+	if !quantity.IsPositive() || notional < filters.MinNotional {
+		return fmt.Errorf("投入金额 %s 小于最小名义价值 %.8f", amount.String(), filters.MinNotional)
+	}
+	if err := filters.Validate("BUY", price.InexactFloat64(), quantity.InexactFloat64(), price.InexactFloat64(), true); err != nil {
+		return err
+	}
+	if err := CheckOrderRisk(cfg, exchange, RiskOrder{
+		UserID:        strategy.UserID,
+		AccountID:     strategy.AccountID,
+		Market:        RiskMarketSpot,
+		Source:        "dca",
+		StrategyID:    strategy.ID,
+		Symbol:        strategy.Symbol,
+		Side:          "BUY",
+		Notional:      notional,
+		NotionalAsset: filters.QuoteAsset,
+		SpendAsset:    filters.QuoteAsset,
+		Spend:         notional,
+		Paper:         services.IsPaperExchange(exchange),
+	}); err != nil {
+		return err
+	}
+
+	resp, err := exchange.CreateOrder(context.Background(), services.SpotOrderRequest{
+		Symbol:   strategy.Symbol,
+		Side:     binance.SideTypeBuy,
+		Type:     binance.OrderTypeMarket,
+		Quantity: quantityStr,
+	})
+	if err != nil {
+		return fmt.Errorf("下单失败: %v", err)
+	}
+
+	executedQty, _ := decimal.NewFromString(resp.ExecutedQuantity)
+	quoteQty, _ := decimal.NewFromString(resp.CummulativeQuoteQuantity)
+	orderPrice := price
+	if executedQty.IsPositive() && quoteQty.IsPositive() {
+		orderPrice = quoteQty.Div(executedQty)
+	}
+
+	status := "pending"
+	if resp.Status == binance.OrderStatusTypeFilled {
+		status = "filled"
+	}
+	dbOrder := models.Order{
+		UserID:        strategy.UserID,
+		AccountID:     strategy.AccountID,
+		Symbol:        strategy.Symbol,
+		Side:          "BUY",
+		Price:         orderPrice,
+		Quantity:      quantity,
+		OrderID:       resp.OrderID,
+		Status:        status,
+		CancelAfter:   time.Now().Add(10 * time.Minute),
+		Paper:         services.IsPaperExchange(exchange),
+		DCAStrategyID: strategy.ID,
+	}
+	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+		log.Printf("保存定投订单失败: %v", err)
+	} else if status == "filled" {
+		recordOrderFills(cfg, exchange, dbOrder)
+	}
+
+	now := time.Now()
+	if err := cfg.DB.Model(&strategy).Updates(map[string]interface{}{
+		"run_count":   gorm.Expr("run_count + 1"),
+		"last_run_at": now,
+		"last_error":  "",
+	}).Error; err != nil {
+		log.Printf("更新定投策略 %d 执行记录失败: %v", strategy.ID, err)
+	}
+
+	if status == "filled" {
+		recordDCAFill(cfg, strategy.ID, executedQty, quoteQty)
+	}
+
+	log.Printf("定投策略 %d 买入 %s %s，投入 %s（%s）", strategy.ID, quantityStr, strategy.Symbol, amount.String(), status)
+	return nil
+}
+
+// recordDCAFill 在事务中累计定投成交并更新平均成本
+func recordDCAFill(cfg *config.Config, strategyID uint, quantity, quote decimal.Decimal) {
+	if !quantity.IsPositive() || !quote.IsPositive() {
+		return
+	}
+	err := cfg.DB.Transaction(func(tx *gorm.DB) error {
+		var strategy models.DCAStrategy
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&strategy, strategyID).Error; err != nil {
+			return err
+		}
+		strategy.RecordFill(quantity, quote)
+		return tx.Model(&strategy).Updates(map[string]interface{}{
+			"total_quantity": strategy.TotalQuantity,
+			"total_quote":    strategy.TotalQuote,
+			"average_cost":   strategy.AverageCost,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("更新定投策略 %d 成本失败: %v", strategyID, err)
+	}
+}
+
+// handleDCAOrderFilled 定投订单在订单检查中确认成交时更新成本，返回本次是否处理了该成交
+func handleDCAOrderFilled(cfg *config.Config, order models.Order, binanceOrder *binance.Order) bool {
+	executedQty, _ := decimal.NewFromString(binanceOrder.ExecutedQuantity)
+	quoteQty, _ := decimal.NewFromString(binanceOrder.CummulativeQuoteQuantity)
+
+	result := cfg.DB.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, "pending").
+		Update("status", "filled")
+	if result.Error != nil {
+		log.Printf("更新订单 %d 状态为 filled 失败: %v", order.OrderID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	log.Printf("订单 %d 状态更新为: filled", order.OrderID)
+	recordDCAFill(cfg, order.DCAStrategyID, executedQty, quoteQty)
+	return true
+}
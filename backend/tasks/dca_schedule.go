@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccj241/binance/models"
+)
+
+// cronSchedule 标准5段 cron 表达式：分 时 日 月 周，支持 * , - / 语法
+type cronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// 日和周同时限定时任一匹配即可，与 crontab 语义一致
+	dayRestricted     bool
+	weekdayRestricted bool
+}
+
+// parseCronSchedule 解析 cron 表达式
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要5段（分 时 日 月 周）: %q", expr)
+	}
+
+	s := &cronSchedule{}
+	if err := parseCronField(fields[0], 0, 59, s.minutes[:]); err != nil {
+		return nil, fmt.Errorf("分钟字段无效: %v", err)
+	}
+	if err := parseCronField(fields[1], 0, 23, s.hours[:]); err != nil {
+		return nil, fmt.Errorf("小时字段无效: %v", err)
+	}
+	if err := parseCronField(fields[2], 1, 31, s.days[:]); err != nil {
+		return nil, fmt.Errorf("日期字段无效: %v", err)
+	}
+	if err := parseCronField(fields[3], 1, 12, s.months[:]); err != nil {
+		return nil, fmt.Errorf("月份字段无效: %v", err)
+	}
+	// 周字段允许7表示周日
+	var weekdays [8]bool
+	if err := parseCronField(fields[4], 0, 7, weekdays[:]); err != nil {
+		return nil, fmt.Errorf("星期字段无效: %v", err)
+	}
+	copy(s.weekdays[:], weekdays[:7])
+	s.weekdays[0] = s.weekdays[0] || weekdays[7]
+
+	s.dayRestricted = fields[2] != "*"
+	s.weekdayRestricted = fields[4] != "*"
+	return s, nil
+}
+
+// parseCronField 解析单个字段，结果写入 set
+func parseCronField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("无效的步长: %q", part)
+			}
+			step = n
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, err1 := strconv.Atoi(bounds[0])
+			b, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("无效的范围: %q", part)
+			}
+			lo, hi = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("无效的值: %q", part)
+			}
+			lo = n
+			hi = n
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("取值超出范围 %d-%d: %q", min, max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// matchDay 判断日期是否满足日/月/周字段
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	if !s.months[int(t.Month())] {
+		return false
+	}
+	dayOK := s.days[t.Day()]
+	weekdayOK := s.weekdays[int(t.Weekday())]
+	if s.dayRestricted && s.weekdayRestricted {
+		return dayOK || weekdayOK
+	}
+	return dayOK && weekdayOK
+}
+
+// next 返回严格晚于 after 的下一个触发时间（精确到分钟）
+func (s *cronSchedule) next(after time.Time) (time.Time, error) {
+	start := after.Truncate(time.Minute).Add(time.Minute)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+
+	// 最多向后查找5年，覆盖2月29日这类稀疏表达式
+	for i := 0; i < 366*5; i++ {
+		d := day.AddDate(0, 0, i)
+		if !s.matchDay(d) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if !s.hours[h] {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if !s.minutes[m] {
+					continue
+				}
+				t := time.Date(d.Year(), d.Month(), d.Day(), h, m, 0, 0, d.Location())
+				if !t.Before(start) {
+					return t, nil
+				}
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("cron 表达式没有可执行的时间")
+}
+
+// dcaCronExpr 将定投计划转换为 cron 表达式
+func dcaCronExpr(strategy models.DCAStrategy) (string, error) {
+	switch strategy.ScheduleType {
+	case "daily", "weekly":
+		parts := strings.SplitN(strategy.ScheduleTime, ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("执行时间格式应为 HH:MM: %q", strategy.ScheduleTime)
+		}
+		hour, err1 := strconv.Atoi(parts[0])
+		minute, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+			return "", fmt.Errorf("执行时间格式应为 HH:MM: %q", strategy.ScheduleTime)
+		}
+		if strategy.ScheduleType == "daily" {
+			return fmt.Sprintf("%d %d * * *", minute, hour), nil
+		}
+		if strategy.Weekday < 0 || strategy.Weekday > 6 {
+			return "", fmt.Errorf("每周执行日必须在0-6之间")
+		}
+		return fmt.Sprintf("%d %d * * %d", minute, hour, strategy.Weekday), nil
+	case "custom":
+		return strategy.CronExpr, nil
+	default:
+		return "", fmt.Errorf("未知的定投计划类型: %s", strategy.ScheduleType)
+	}
+}
+
+// NextDCARun 计算定投策略在 after 之后的下一次执行时间，同时用于校验计划配置
+func NextDCARun(strategy models.DCAStrategy, after time.Time) (time.Time, error) {
+	expr, err := dcaCronExpr(strategy)
+	if err != nil {
+		return time.Time{}, err
+	}
+	schedule, err := parseCronSchedule(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.next(after)
+}
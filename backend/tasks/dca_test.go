@@ -0,0 +1,45 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/ccj241/binance/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestDCAMultiplier(t *testing.T) {
+	strategy := models.DCAStrategy{
+		AverageCost:    decimal.RequireFromString("100"),
+		DipMultipliers: `[{"dropPercent":5,"multiplier":1.5},{"dropPercent":10,"multiplier":2}]`,
+	}
+	cases := map[string]string{
+		"101": "1",   // 高于平均成本
+		"96":  "1",   // 跌幅不足5%
+		"95":  "1.5", // 恰好5%
+		"90":  "2",   // 取满足的最大档位
+		"50":  "2",
+	}
+	for price, want := range cases {
+		if got := dcaMultiplier(strategy, decimal.RequireFromString(price)); !got.Equal(decimal.RequireFromString(want)) {
+			t.Errorf("价格 %s 的倍数 = %s, want %s", price, got, want)
+		}
+	}
+
+	// 尚无成交时不加倍
+	strategy.AverageCost = decimal.Zero
+	if got := dcaMultiplier(strategy, decimal.RequireFromString("50")); !got.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("无平均成本时倍数 = %s, want 1", got)
+	}
+}
+
+func TestDCARecordFill(t *testing.T) {
+	var strategy models.DCAStrategy
+	strategy.RecordFill(decimal.RequireFromString("0.1"), decimal.RequireFromString("10"))
+	strategy.RecordFill(decimal.RequireFromString("0.2"), decimal.RequireFromString("10"))
+	if strategy.TotalQuantity.String() != "0.3" || strategy.TotalQuote.String() != "20" {
+		t.Fatalf("累计数量=%s 金额=%s", strategy.TotalQuantity, strategy.TotalQuote)
+	}
+	if got := strategy.AverageCost.Round(8).String(); got != "66.66666667" {
+		t.Errorf("平均成本 = %s", got)
+	}
+}
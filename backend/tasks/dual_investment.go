@@ -2,240 +2,79 @@ package tasks
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
-// 双币投资API响应结构体
-type DCIProductListResponse struct {
-	Total int                  `json:"total"`
-	List  []DCIProductListItem `json:"list"` // 币安实际返回的是list
-}
-
-type DCIProductListItem struct {
-	Id              string `json:"id"`
-	OrderId         int64  `json:"orderId"` // 添加orderId字段
-	Symbol          string `json:"symbol"`
-	Direction       string `json:"direction"` // UP/DOWN
-	StrikePrice     string `json:"strikePrice"`
-	Duration        int    `json:"duration"`
-	Apy             string `json:"apy"`
-	MinAmount       string `json:"minAmount"`
-	MaxAmount       string `json:"maxAmount"`
-	DeliveryDate    int64  `json:"deliveryDate"`
-	ProductStatus   string `json:"productStatus"`
-	PurchaseEndTime int64  `json:"purchaseEndTime"`
-	BaseAsset       string `json:"baseAsset"`
-	QuoteAsset      string `json:"quoteAsset"`
-	InvestAsset     string `json:"investAsset"`
-	InvestCoin      string `json:"investCoin"`    // 币安返回的字段名
-	ExercisedCoin   string `json:"exercisedCoin"` // 币安返回的字段名
-
-	// 添加更多可能的字段名
-	AnnualizedYield string `json:"annualizedYield"` // 可能的年化收益率字段
-	Yield           string `json:"yield"`           // 可能的收益率字段
-	SettleDate      int64  `json:"settleDate"`      // 可能的结算日期字段
-	ExpiryDate      int64  `json:"expiryDate"`      // 可能的到期日期字段
-	Status          string `json:"status"`          // 可能的状态字段
-
-	// 币安实际返回的字段名
-	APR                  string   `json:"apr"`                  // 年化收益率（币安使用apr而不是apy）
-	CanPurchase          bool     `json:"canPurchase"`          // 是否可购买
-	CreateTimestamp      int64    `json:"createTimestamp"`      // 创建时间戳
-	IsAutoCompoundEnable bool     `json:"isAutoCompoundEnable"` // 是否支持自动复投
-	OptionType           string   `json:"optionType"`           // 期权类型 PUT/CALL
-	PurchaseDecimal      int      `json:"purchaseDecimal"`      // 购买精度
-	AutoCompoundPlanList []string `json:"autoCompoundPlanList"` // 自动复投计划列表
-}
-
-type DCISubscribeResponse struct {
-	PositionId   string `json:"positionId"`
-	PurchaseTime int64  `json:"purchaseTime"`
-}
-
-type DCIPositionResponse struct {
-	Total int               `json:"total"`
-	Rows  []DCIPositionItem `json:"rows"`
-}
-
-type DCIPositionItem struct {
-	Id           string `json:"id"`
-	PositionId   string `json:"positionId"`
-	ProductId    string `json:"productId"`
-	Symbol       string `json:"symbol"`
-	Direction    string `json:"direction"`
-	StrikePrice  string `json:"strikePrice"`
-	Duration     int    `json:"duration"`
-	Apy          string `json:"apy"`
-	InvestAmount string `json:"investAmount"`
-	InvestAsset  string `json:"investAsset"`
-	PurchaseTime int64  `json:"purchaseTime"`
-	DeliveryDate int64  `json:"deliveryDate"`
-	Status       string `json:"status"` // PENDING/ACTIVE/SETTLED
-	SettleAsset  string `json:"settleAsset"`
-	SettleAmount string `json:"settleAmount"`
-	ProfitAmount string `json:"profitAmount"`
-	ProfitAsset  string `json:"profitAsset"`
-}
-
-// BinanceAPIError 币安API错误响应
-type BinanceAPIError struct {
-	Code int    `json:"code"`
-	Msg  string `json:"msg"`
-}
-
-// dciRequest 用于处理双币投资API请求的辅助函数
-func dciRequest(apiKey, secretKey, method, endpoint string, params map[string]interface{}) ([]byte, error) {
-	baseURL := "https://api.binance.com"
-
-	// 添加时间戳
-	params["timestamp"] = fmt.Sprintf("%d", time.Now().UnixMilli())
-
-	// 构建查询字符串
-	query := buildQueryString(params)
-
-	// 生成签名
-	signature := sign(query, secretKey)
-	query += "&signature=" + signature
-
-	// 构建完整URL
-	fullURL := baseURL + endpoint
-	if method == "GET" {
-		fullURL += "?" + query
-	}
-
-	// 创建请求
-	var req *http.Request
-	var err error
-
-	if method == "GET" {
-		req, err = http.NewRequest(method, fullURL, nil)
-	} else {
-		req, err = http.NewRequest(method, fullURL, strings.NewReader(query))
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("创建请求失败: %v", err)
-	}
-
-	// 设置请求头
-	req.Header.Set("X-MBX-APIKEY", apiKey)
-
-	// 发送请求
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("发送请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %v", err)
-	}
-
-	// 检查HTTP状态码
-	if resp.StatusCode != http.StatusOK {
-		var apiErr BinanceAPIError
-		if err := json.Unmarshal(body, &apiErr); err == nil {
-			return nil, fmt.Errorf("API错误 [%d]: %s", apiErr.Code, apiErr.Msg)
-		}
-		return nil, fmt.Errorf("HTTP错误 %d: %s", resp.StatusCode, string(body))
-	}
-
-	return body, nil
-}
-
-// buildQueryString 构建查询字符串
-func buildQueryString(params map[string]interface{}) string {
-	var keys []string
-	for k := range params {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	var parts []string
-	for _, k := range keys {
-		v := params[k]
-		parts = append(parts, fmt.Sprintf("%s=%v", k, url.QueryEscape(fmt.Sprintf("%v", v))))
-	}
-
-	return strings.Join(parts, "&")
-}
-
-// sign 生成签名
-func sign(message, secret string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(message))
-	return hex.EncodeToString(mac.Sum(nil))
-}
-
 // StartDualInvestmentTasks 启动双币投资相关任务
-func StartDualInvestmentTasks(cfg *config.Config) {
-	// 产品同步任务 - 每5分钟执行一次
-	go syncDualInvestmentProducts(cfg)
+func StartDualInvestmentTasks(ctx context.Context, cfg *config.Config) {
+	// 产品同步任务 - 默认每5分钟执行一次
+	spawnTask(ctx, "双币投资产品同步", func() { syncDualInvestmentProducts(ctx, cfg) })
 
-	// 策略执行任务 - 每分钟检查一次
-	go executeDualInvestmentStrategies(cfg)
+	// 策略执行任务 - 默认每分钟检查一次
+	spawnTask(ctx, "双币投资策略执行", func() { executeDualInvestmentStrategies(ctx, cfg) })
 
-	// 订单结算监控 - 每10分钟检查一次
-	go monitorDualInvestmentSettlement(cfg)
+	// 订单结算监控 - 默认每10分钟检查一次
+	spawnTask(ctx, "双币投资结算监控", func() { monitorDualInvestmentSettlement(ctx, cfg) })
 }
 
 // syncDualInvestmentProducts 同步双币投资产品
-func syncDualInvestmentProducts(cfg *config.Config) {
-	ticker := time.NewTicker(5 * time.Minute)
+func syncDualInvestmentProducts(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.DualProductSyncInterval)
 	defer ticker.Stop()
 
 	// 立即执行一次
 	doSyncProducts(cfg)
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		doSyncProducts(cfg)
 	}
 }
 
 // doSyncProducts 执行产品同步 - 真实API版本
 func doSyncProducts(cfg *config.Config) {
-	// 获取一个有效的用户API密钥用于同步
-	var user models.User
-	if err := cfg.DB.Where("api_key != ? AND secret_key != ?", "", "").First(&user).Error; err != nil {
+	// 获取一个开通双币投资权限、且与系统默认环境一致的交易所账户用于同步，测试网不提供双币投资
+	var candidates []models.ExchangeAccount
+	if err := cfg.DB.Where("status = ? AND api_key <> '' AND secret_key <> '' AND permissions LIKE ?",
+		"active", "%"+models.AccountPermDCI+"%").Order("id").Find(&candidates).Error; err != nil {
+		log.Printf("获取同步产品的账户失败: %v", err)
+		return
+	}
+	var account *models.ExchangeAccount
+	defaultEnv := services.DefaultEndpoints().Environment
+	for i := range candidates {
+		if services.AccountEnvironment(&candidates[i]) == defaultEnv {
+			account = &candidates[i]
+			break
+		}
+	}
+	if account == nil {
 		// 只在错误时记录
 		log.Printf("没有找到有效的API密钥用于同步产品")
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
+	exchange, err := services.NewAccountExchange(account)
 	if err != nil {
-		log.Printf("解密API Key失败: %v", err)
+		log.Printf("创建交易所客户端失败: %v", err)
 		return
 	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		log.Printf("解密Secret Key失败: %v", err)
-		return
-	}
-
-	client := binance.NewClient(apiKey, secretKey)
 
 	// 从数据库获取所有用户已添加的交易对
 	symbolMap := make(map[string]bool)
@@ -276,7 +115,7 @@ func doSyncProducts(cfg *config.Config) {
 
 	for _, symbol := range uniqueSymbols {
 		// 获取当前价格
-		prices, err := client.NewListPricesService().Symbol(symbol).Do(context.Background())
+		prices, err := exchange.ListPrices(context.Background(), symbol)
 		if err != nil || len(prices) == 0 {
 			errorCount++
 			continue
@@ -284,7 +123,7 @@ func doSyncProducts(cfg *config.Config) {
 		currentPrice, _ := strconv.ParseFloat(prices[0].Price, 64)
 
 		// 调用双币投资产品列表API
-		products, err := getDCIProductList(apiKey, secretKey, symbol)
+		products, err := getDCIProductList(exchange, symbol)
 		if err != nil {
 			errorCount++
 			continue
@@ -416,11 +255,16 @@ func mapProductStatus(status string) string {
 }
 
 // executeDualInvestmentStrategies 执行双币投资策略
-func executeDualInvestmentStrategies(cfg *config.Config) {
-	ticker := time.NewTicker(1 * time.Minute)
+func executeDualInvestmentStrategies(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.DualStrategyInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		// 查询所有启用的策略
 		var strategies []models.DualInvestmentStrategy
 		now := time.Now()
@@ -436,7 +280,14 @@ func executeDualInvestmentStrategies(cfg *config.Config) {
 		}
 
 		for _, strategy := range strategies {
-			go executeStrategy(cfg, strategy)
+			// 服务停止中不再执行新的申购
+			if !beginOrderPlacement() {
+				return
+			}
+			go func(s models.DualInvestmentStrategy) {
+				defer endOrderPlacement()
+				executeStrategy(cfg, s)
+			}(strategy)
 		}
 	}
 }
@@ -450,25 +301,13 @@ func executeStrategy(cfg *config.Config, strategy models.DualInvestmentStrategy)
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		log.Printf("解密用户 %d API Key失败: %v", user.ID, err)
-		return
-	}
-
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		log.Printf("解密用户 %d Secret Key失败: %v", user.ID, err)
-		return
-	}
-
-	if apiKey == "" || secretKey == "" {
+	// 策略所属账户需开通双币投资权限
+	if _, err := services.ResolveAccountWith(cfg.DB, user.ID, strategy.AccountID, models.AccountPermDCI); err != nil {
 		return
 	}
 
 	// 检查投资限额
-	if strategy.CurrentInvested >= strategy.TotalInvestmentLimit {
+	if strategy.CurrentInvested.GreaterThanOrEqual(strategy.TotalInvestmentLimit) {
 		return
 	}
 
@@ -488,29 +327,23 @@ func executeStrategy(cfg *config.Config, strategy models.DualInvestmentStrategy)
 
 // createDualInvestmentOrder 创建双币投资订单 - 真实API版本
 func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy models.DualInvestmentStrategy,
-	product *models.DualInvestmentProduct, investAmount float64) bool {
+	product *models.DualInvestmentProduct, investAmount decimal.Decimal) bool {
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
+	exchange, err := services.NewUserExchange(cfg.DB, user.ID, strategy.AccountID)
 	if err != nil {
-		log.Printf("创建订单时解密API Key失败: %v", err)
-		return false
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		log.Printf("创建订单时解密Secret Key失败: %v", err)
+		log.Printf("创建订单时创建交易所客户端失败: %v", err)
 		return false
 	}
 
 	// 首先需要获取产品的orderId
-	products, err := getDCIProductList(apiKey, secretKey, product.Symbol)
+	products, err := getDCIProductList(exchange, product.Symbol)
 	if err != nil {
 		log.Printf("获取产品列表失败: %v", err)
 		return false
 	}
 
 	// 查找匹配的产品以获取orderId
-	var targetProduct *DCIProductListItem
+	var targetProduct *services.DCIProductListItem
 	productIDParts := strings.Split(product.ProductID, "|")
 	searchProductID := productIDParts[0]
 
@@ -526,21 +359,45 @@ func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy mo
 		return false
 	}
 
+	// 申购金额按8位小数提交，订单记录保存同一数值
+	depositAmount := investAmount.Round(8)
+
+	// 申购前风控检查，看涨投入基础资产，看跌投入计价资产
+	spendAsset := product.QuoteAsset
+	if product.Direction == "UP" {
+		spendAsset = product.BaseAsset
+	}
+	if err := CheckOrderRisk(cfg, exchange, RiskOrder{
+		UserID:        user.ID,
+		AccountID:     strategy.AccountID,
+		Market:        RiskMarketDual,
+		Source:        "dual_investment",
+		StrategyID:    strategy.ID,
+		Symbol:        product.Symbol,
+		Side:          product.Direction,
+		Notional:      depositAmount.InexactFloat64(),
+		NotionalAsset: spendAsset,
+		SpendAsset:    spendAsset,
+		Spend:         depositAmount.InexactFloat64(),
+	}); err != nil {
+		log.Printf("双币投资下单未通过风控: %v", err)
+		return false
+	}
+
 	// 根据官方文档构造参数
-	params := map[string]interface{}{
-		"id":               targetProduct.Id,
-		"orderId":          fmt.Sprintf("%d", targetProduct.OrderId),
-		"depositAmount":    fmt.Sprintf("%.8f", investAmount),
-		"autoCompoundPlan": "NONE",
-		"recvWindow":       5000,
+	subscribeReq := services.DCISubscribeRequest{
+		ID:               targetProduct.Id,
+		OrderID:          fmt.Sprintf("%d", targetProduct.OrderId),
+		DepositAmount:    depositAmount,
+		AutoCompoundPlan: "NONE",
 	}
 
-	res, err := dciRequest(apiKey, secretKey, "POST", "/sapi/v1/dci/product/subscribe", params)
+	subscribeResp, err := exchange.SubscribeDCIProduct(context.Background(), subscribeReq)
 	if err != nil {
 		// 如果orderId为0，尝试只使用id
 		if targetProduct.OrderId == 0 {
-			params["orderId"] = targetProduct.Id
-			res, err = dciRequest(apiKey, secretKey, "POST", "/sapi/v1/dci/product/subscribe", params)
+			subscribeReq.OrderID = targetProduct.Id
+			subscribeResp, err = exchange.SubscribeDCIProduct(context.Background(), subscribeReq)
 			if err != nil {
 				log.Printf("双币投资下单失败: %v", err)
 				return false
@@ -551,13 +408,6 @@ func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy mo
 		}
 	}
 
-	// 解析响应
-	var subscribeResp map[string]interface{}
-	if err := json.Unmarshal(res, &subscribeResp); err != nil {
-		log.Printf("解析下单响应失败: %v", err)
-		return false
-	}
-
 	// 从响应中获取positionId
 	positionIdFloat, ok := subscribeResp["positionId"].(float64)
 	if !ok {
@@ -581,13 +431,14 @@ func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy mo
 	// 创建订单记录
 	order := models.DualInvestmentOrder{
 		UserID:         user.ID,
+		AccountID:      strategy.AccountID,
 		StrategyID:     &strategy.ID,
 		ProductID:      product.ID,
 		OrderID:        positionId,
 		Symbol:         product.Symbol,
 		InvestAsset:    investAsset,
-		InvestAmount:   investAmount,
-		StrikePrice:    product.StrikePrice,
+		InvestAmount:   depositAmount,
+		StrikePrice:    decimal.NewFromFloat(product.StrikePrice),
 		APY:            product.APY,
 		Direction:      product.Direction,
 		Duration:       product.Duration,
@@ -604,7 +455,7 @@ func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy mo
 
 		// 更新策略已投资金额
 		if err := tx.Model(&strategy).Updates(map[string]interface{}{
-			"current_invested": gorm.Expr("current_invested + ?", investAmount),
+			"current_invested": gorm.Expr("current_invested + ?", depositAmount),
 			"last_executed_at": time.Now(),
 		}).Error; err != nil {
 			return err
@@ -619,17 +470,22 @@ func createDualInvestmentOrder(cfg *config.Config, user models.User, strategy mo
 	}
 
 	// 只记录成功的关键信息
-	log.Printf("双币投资订单创建: %s %s %.2f %s @ %.2f%%",
-		product.Symbol, product.Direction, investAmount, investAsset, product.APY)
+	log.Printf("双币投资订单创建: %s %s %s %s @ %.2f%%",
+		product.Symbol, product.Direction, depositAmount, investAsset, product.APY)
 	return true
 }
 
 // monitorDualInvestmentSettlement 监控双币投资结算
-func monitorDualInvestmentSettlement(cfg *config.Config) {
-	ticker := time.NewTicker(10 * time.Minute)
+func monitorDualInvestmentSettlement(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.DualSettlementInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		// 查询所有活跃的订单
 		var orders []models.DualInvestmentOrder
 		if err := cfg.DB.Where("status = ?", "active").Find(&orders).Error; err != nil {
@@ -637,50 +493,40 @@ func monitorDualInvestmentSettlement(cfg *config.Config) {
 			continue
 		}
 
-		// 按用户分组
-		userOrders := make(map[uint][]models.DualInvestmentOrder)
+		// 按交易所账户分组
+		type orderGroup struct {
+			userID    uint
+			accountID uint
+		}
+		userOrders := make(map[orderGroup][]models.DualInvestmentOrder)
 		for _, order := range orders {
-			userOrders[order.UserID] = append(userOrders[order.UserID], order)
+			group := orderGroup{userID: order.UserID, accountID: order.AccountID}
+			userOrders[group] = append(userOrders[group], order)
 		}
 
-		// 处理每个用户的订单
-		for userID, orderList := range userOrders {
-			go checkUserOrders(cfg, userID, orderList)
+		// 处理每个账户的订单
+		for group, orderList := range userOrders {
+			go checkUserOrders(cfg, group.userID, group.accountID, orderList)
 		}
 	}
 }
 
-// checkUserOrders 检查用户的订单状态
-func checkUserOrders(cfg *config.Config, userID uint, orders []models.DualInvestmentOrder) {
-	// 获取用户信息
-	var user models.User
-	if err := cfg.DB.First(&user, userID).Error; err != nil {
-		return
-	}
-
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+// checkUserOrders 检查交易所账户的订单状态
+func checkUserOrders(cfg *config.Config, userID, accountID uint, orders []models.DualInvestmentOrder) {
+	exchange, err := services.NewUserExchange(cfg.DB, userID, accountID)
 	if err != nil {
 		return
 	}
 
-	if apiKey == "" || secretKey == "" {
-		return
-	}
-
-	// 获取用户的所有持仓
-	positions, err := getDCIPositions(apiKey, secretKey)
+	// 获取账户的所有持仓
+	positions, err := getDCIPositions(exchange)
 	if err != nil {
 		log.Printf("获取用户 %d 的双币投资持仓失败: %v", userID, err)
 		return
 	}
 
 	// 创建持仓映射
-	positionMap := make(map[string]DCIPositionItem)
+	positionMap := make(map[string]services.DCIPositionItem)
 	for _, pos := range positions {
 		positionMap[pos.PositionId] = pos
 	}
@@ -700,7 +546,7 @@ func checkUserOrders(cfg *config.Config, userID uint, orders []models.DualInvest
 }
 
 // getDCIProductList 获取双币投资产品列表
-func getDCIProductList(apiKey, secretKey, symbol string) ([]DCIProductListItem, error) {
+func getDCIProductList(exchange services.Exchange, symbol string) ([]services.DCIProductListItem, error) {
 	// 从交易对中解析基础资产和计价资产
 	var baseAsset, quoteAsset string
 	if strings.HasSuffix(symbol, "USDT") {
@@ -718,69 +564,49 @@ func getDCIProductList(apiKey, secretKey, symbol string) ([]DCIProductListItem,
 		}
 	}
 
-	var allProducts []DCIProductListItem
+	var allProducts []services.DCIProductListItem
 
 	// 重要修改：修正期权类型和方向的映射关系
 	// PUT期权 = 低买策略（用USDT买入基础资产）
-	params := map[string]interface{}{
-		"optionType":    "PUT",
-		"investCoin":    quoteAsset, // USDT - 投资币种
-		"exercisedCoin": baseAsset,  // SOL - 行权后获得的币种
-		"pageSize":      100,
-		"pageIndex":     1,
-	}
-
-	res, err := dciRequest(apiKey, secretKey, "GET", "/sapi/v1/dci/product/list", params)
+	// investCoin: USDT - 投资币种, exercisedCoin: SOL - 行权后获得的币种
+	list, err := exchange.ListDCIProducts(context.Background(), "PUT", quoteAsset, baseAsset)
 	if err != nil {
 		log.Printf("获取 %s PUT产品失败: %v", symbol, err)
 	} else {
-		var response DCIProductListResponse
-		if err := json.Unmarshal(res, &response); err == nil {
-			for i := range response.List {
-				// PUT期权对应UP方向（低买）
-				response.List[i].Direction = "UP"
-				if response.List[i].Symbol == "" {
-					response.List[i].Symbol = baseAsset + quoteAsset
-				}
-				response.List[i].BaseAsset = baseAsset
-				response.List[i].QuoteAsset = quoteAsset
-				response.List[i].InvestAsset = response.List[i].InvestCoin
+		for i := range list {
+			// PUT期权对应UP方向（低买）
+			list[i].Direction = "UP"
+			if list[i].Symbol == "" {
+				list[i].Symbol = baseAsset + quoteAsset
 			}
-			allProducts = append(allProducts, response.List...)
+			list[i].BaseAsset = baseAsset
+			list[i].QuoteAsset = quoteAsset
+			list[i].InvestAsset = list[i].InvestCoin
 		}
+		allProducts = append(allProducts, list...)
 	}
 
 	// CALL期权 = 高卖策略（用基础资产卖出）
-	params = map[string]interface{}{
-		"optionType":    "CALL",
-		"investCoin":    baseAsset,  // SOL - 投资币种
-		"exercisedCoin": quoteAsset, // USDT - 行权后获得的币种
-		"pageSize":      100,
-		"pageIndex":     1,
-	}
-
-	res, err = dciRequest(apiKey, secretKey, "GET", "/sapi/v1/dci/product/list", params)
+	// investCoin: SOL - 投资币种, exercisedCoin: USDT - 行权后获得的币种
+	list, err = exchange.ListDCIProducts(context.Background(), "CALL", baseAsset, quoteAsset)
 	if err != nil {
 		log.Printf("获取 %s CALL产品失败: %v", symbol, err)
 	} else {
-		var response DCIProductListResponse
-		if err := json.Unmarshal(res, &response); err == nil {
-			for i := range response.List {
-				// CALL期权对应DOWN方向（高卖）
-				response.List[i].Direction = "DOWN"
-				if response.List[i].Symbol == "" {
-					response.List[i].Symbol = baseAsset + quoteAsset
-				}
-				response.List[i].BaseAsset = baseAsset
-				response.List[i].QuoteAsset = quoteAsset
-				response.List[i].InvestAsset = response.List[i].InvestCoin
+		for i := range list {
+			// CALL期权对应DOWN方向（高卖）
+			list[i].Direction = "DOWN"
+			if list[i].Symbol == "" {
+				list[i].Symbol = baseAsset + quoteAsset
 			}
-			allProducts = append(allProducts, response.List...)
+			list[i].BaseAsset = baseAsset
+			list[i].QuoteAsset = quoteAsset
+			list[i].InvestAsset = list[i].InvestCoin
 		}
+		allProducts = append(allProducts, list...)
 	}
 
 	// 过滤只保留指定交易对的产品
-	var filteredProducts []DCIProductListItem
+	var filteredProducts []services.DCIProductListItem
 	for _, product := range allProducts {
 		if product.Symbol == symbol {
 			filteredProducts = append(filteredProducts, product)
@@ -791,27 +617,14 @@ func getDCIProductList(apiKey, secretKey, symbol string) ([]DCIProductListItem,
 }
 
 // getDCIPositions 获取双币投资持仓
-func getDCIPositions(apiKey, secretKey string) ([]DCIPositionItem, error) {
-	params := map[string]interface{}{
-		"pageSize":  100,
-		"pageIndex": 1,
-	}
-
-	res, err := dciRequest(apiKey, secretKey, "GET", "/sapi/v1/dci/product/positions", params)
-	if err != nil {
-		return nil, err
-	}
-
-	var response DCIPositionResponse
-	if err := json.Unmarshal(res, &response); err != nil {
-		return nil, fmt.Errorf("解析持仓响应失败: %v", err)
-	}
-
-	return response.Rows, nil
+func getDCIPositions(exchange services.Exchange) ([]services.DCIPositionItem, error) {
+	return exchange.GetDCIPositions(context.Background())
 }
 
 // updateOrderFromPosition 根据持仓信息更新订单
-func updateOrderFromPosition(cfg *config.Config, order *models.DualInvestmentOrder, position DCIPositionItem) {
+func updateOrderFromPosition(cfg *config.Config, order *models.DualInvestmentOrder, position services.DCIPositionItem) {
+	previousStatus := order.Status
+
 	// 更新订单状态
 	switch position.Status {
 	case "PENDING":
@@ -822,7 +635,7 @@ func updateOrderFromPosition(cfg *config.Config, order *models.DualInvestmentOrd
 		order.Status = "settled"
 
 		// 更新结算信息
-		settlementAmount, _ := strconv.ParseFloat(position.SettleAmount, 64)
+		settlementAmount, _ := decimal.NewFromString(position.SettleAmount)
 		profitAmount, _ := strconv.ParseFloat(position.ProfitAmount, 64)
 
 		order.SettlementAsset = position.SettleAsset
@@ -833,7 +646,7 @@ func updateOrderFromPosition(cfg *config.Config, order *models.DualInvestmentOrd
 		// 计算盈亏
 		if position.SettleAsset == position.InvestAsset {
 			order.PnL = profitAmount
-			order.PnLPercent = (profitAmount / order.InvestAmount) * 100
+			order.PnLPercent = (profitAmount / order.InvestAmount.InexactFloat64()) * 100
 		} else {
 			order.PnL = profitAmount
 			order.PnLPercent = 0
@@ -850,13 +663,29 @@ func updateOrderFromPosition(cfg *config.Config, order *models.DualInvestmentOrd
 				Update("current_invested", gorm.Expr("current_invested - ?", order.InvestAmount))
 		}
 
-		log.Printf("双币投资订单结算: 订单=%s, 结算金额=%.2f %s, 盈亏=%.2f",
+		log.Printf("双币投资订单结算: 订单=%s, 结算金额=%s %s, 盈亏=%.2f",
 			order.OrderID, settlementAmount, order.SettlementAsset, profitAmount)
 	}
 
 	// 保存更新
 	if err := cfg.DB.Save(order).Error; err != nil {
 		log.Printf("更新订单 %s 状态失败: %v", order.OrderID, err)
+		return
+	}
+
+	if order.Status == "settled" && previousStatus != "settled" {
+		EmitNotification(NotificationEvent{
+			UserID:  order.UserID,
+			Event:   models.NotifyDCISettled,
+			Title:   fmt.Sprintf("双币投资结算: %s %s", order.SettlementAmount, order.SettlementAsset),
+			Message: fmt.Sprintf("双币投资订单 %s 已结算，收益 %.8f（%.2f%%）", order.OrderID, order.PnL, order.PnLPercent),
+			Data: map[string]interface{}{
+				"orderId":          order.OrderID,
+				"settlementAsset":  order.SettlementAsset,
+				"settlementAmount": order.SettlementAmount.String(),
+				"pnl":              order.PnL,
+			},
+		})
 	}
 }
 
@@ -870,7 +699,7 @@ func executeSingleStrategy(cfg *config.Config, strategy models.DualInvestmentStr
 
 	// 计算投资金额
 	investAmount := calculateInvestAmount(strategy, product)
-	if investAmount <= 0 {
+	if !investAmount.IsPositive() {
 		return
 	}
 
@@ -897,32 +726,23 @@ func executeAutoReinvestStrategy(cfg *config.Config, strategy models.DualInvestm
 		}
 
 		// 使用结算金额进行复投
-		investAmount := order.SettlementAmount
-		if investAmount > strategy.MaxSingleAmount {
-			investAmount = strategy.MaxSingleAmount
-		}
+		investAmount := decimal.Min(order.SettlementAmount, strategy.MaxSingleAmount)
 
 		if createDualInvestmentOrder(cfg, user, strategy, product, investAmount) {
-			log.Printf("自动复投成功: 策略=%d, 原订单=%s, 复投金额=%.2f", strategy.ID, order.OrderID, investAmount)
+			log.Printf("自动复投成功: 策略=%d, 原订单=%s, 复投金额=%s", strategy.ID, order.OrderID, investAmount.StringFixed(2))
 		}
 	}
 }
 
 // executeLadderStrategy 执行梯度投资策略
 func executeLadderStrategy(cfg *config.Config, strategy models.DualInvestmentStrategy, user models.User, symbol string) {
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewUserExchange(cfg.DB, user.ID, strategy.AccountID)
 	if err != nil {
 		return
 	}
 
 	// 获取当前价格
-	client := binance.NewClient(apiKey, secretKey)
-	prices, err := client.NewListPricesService().Symbol(symbol).Do(context.Background())
+	prices, err := exchange.ListPrices(context.Background(), symbol)
 	if err != nil || len(prices) == 0 {
 		log.Printf("获取 %s 价格失败: %v", symbol, err)
 		return
@@ -984,7 +804,7 @@ func executeLadderStrategy(cfg *config.Config, strategy models.DualInvestmentStr
 	})
 
 	// 根据梯度配置进行投资
-	totalInvested := 0.0
+	totalInvested := decimal.Zero
 	successCount := 0
 
 	for _, config := range ladderConfig {
@@ -1002,27 +822,23 @@ func executeLadderStrategy(cfg *config.Config, strategy models.DualInvestmentStr
 		}
 
 		// 计算投资金额
-		investAmount := strategy.MaxSingleAmount * (config.Percentage / 100.0)
+		investAmount := strategy.MaxSingleAmount.Mul(decimal.NewFromFloat(config.Percentage)).Div(decimal.NewFromInt(100))
 
 		// 确保不超过剩余限额
-		remainingLimit := strategy.TotalInvestmentLimit - strategy.CurrentInvested - totalInvested
-		if investAmount > remainingLimit {
-			investAmount = remainingLimit
-		}
+		remainingLimit := strategy.TotalInvestmentLimit.Sub(strategy.CurrentInvested).Sub(totalInvested)
+		investAmount = decimal.Min(investAmount, remainingLimit)
 
 		// 确保满足产品最小投资额
-		if investAmount < targetProduct.MinAmount {
+		if investAmount.LessThan(decimal.NewFromFloat(targetProduct.MinAmount)) {
 			continue
 		}
 
 		// 确保不超过产品最大投资额
-		if investAmount > targetProduct.MaxAmount {
-			investAmount = targetProduct.MaxAmount
-		}
+		investAmount = decimal.Min(investAmount, decimal.NewFromFloat(targetProduct.MaxAmount))
 
 		// 创建订单
 		if createDualInvestmentOrder(cfg, user, strategy, targetProduct, investAmount) {
-			totalInvested += investAmount
+			totalInvested = totalInvested.Add(investAmount)
 			successCount++
 		}
 	}
@@ -1032,25 +848,19 @@ func executeLadderStrategy(cfg *config.Config, strategy models.DualInvestmentStr
 	cfg.DB.Model(&strategy).Update("next_check_time", nextCheckTime)
 
 	if successCount > 0 {
-		log.Printf("梯度策略 %d 执行: 成功投资 %d 笔，总金额 %.2f", strategy.ID, successCount, totalInvested)
+		log.Printf("梯度策略 %d 执行: 成功投资 %d 笔，总金额 %s", strategy.ID, successCount, totalInvested.StringFixed(2))
 	}
 }
 
 // executePriceTriggerStrategy 执行价格触发策略
 func executePriceTriggerStrategy(cfg *config.Config, strategy models.DualInvestmentStrategy, user models.User, symbol string) {
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewUserExchange(cfg.DB, user.ID, strategy.AccountID)
 	if err != nil {
 		return
 	}
 
 	// 获取当前价格
-	client := binance.NewClient(apiKey, secretKey)
-	prices, err := client.NewListPricesService().Symbol(symbol).Do(context.Background())
+	prices, err := exchange.ListPrices(context.Background(), symbol)
 	if err != nil || len(prices) == 0 {
 		return
 	}
@@ -1076,13 +886,13 @@ func executePriceTriggerStrategy(cfg *config.Config, strategy models.DualInvestm
 		strategy.ID, symbol, currentPrice, strategy.TriggerType, strategy.TriggerPrice)
 
 	// 检查是否还有可用额度
-	availableAmount := strategy.TotalInvestmentLimit - strategy.CurrentInvested
-	if availableAmount <= 0 {
+	availableAmount := strategy.TotalInvestmentLimit.Sub(strategy.CurrentInvested)
+	if !availableAmount.IsPositive() {
 		log.Printf("价格触发策略 %d 已达到总投资限额，标记为完成", strategy.ID)
 		// 达到限额，标记为完成
 		cfg.DB.Model(&strategy).Updates(map[string]interface{}{
 			"status": "completed",
-			"notes":  fmt.Sprintf("达到总投资限额 %.2f", strategy.TotalInvestmentLimit),
+			"notes":  fmt.Sprintf("达到总投资限额 %s", strategy.TotalInvestmentLimit.StringFixed(2)),
 		})
 		return
 	}
@@ -1106,7 +916,7 @@ func executePriceTriggerStrategy(cfg *config.Config, strategy models.DualInvestm
 		product.Direction, product.StrikePrice, product.APY, product.BaseAsset)
 
 	investAmount := calculateInvestAmount(strategy, product)
-	if investAmount <= 0 {
+	if !investAmount.IsPositive() {
 		log.Printf("计算的投资金额为0，跳过本次投资")
 		// 1分钟后再试
 		nextCheckTime := time.Now().Add(1 * time.Minute)
@@ -1117,7 +927,7 @@ func executePriceTriggerStrategy(cfg *config.Config, strategy models.DualInvestm
 	// 创建订单
 	if createDualInvestmentOrder(cfg, user, strategy, product, investAmount) {
 		// 更新策略信息
-		updatedInvested := strategy.CurrentInvested + investAmount
+		updatedInvested := strategy.CurrentInvested.Add(investAmount)
 
 		updateData := map[string]interface{}{
 			"last_executed_at": time.Now(),
@@ -1125,17 +935,17 @@ func executePriceTriggerStrategy(cfg *config.Config, strategy models.DualInvestm
 		}
 
 		// 检查是否达到限额
-		if updatedInvested >= strategy.TotalInvestmentLimit {
-			log.Printf("价格触发策略 %d 达到总投资限额 %.2f，标记为完成",
-				strategy.ID, strategy.TotalInvestmentLimit)
+		if updatedInvested.GreaterThanOrEqual(strategy.TotalInvestmentLimit) {
+			log.Printf("价格触发策略 %d 达到总投资限额 %s，标记为完成",
+				strategy.ID, strategy.TotalInvestmentLimit.StringFixed(2))
 			updateData["status"] = "completed"
-			updateData["notes"] = fmt.Sprintf("达到总投资限额 %.2f", strategy.TotalInvestmentLimit)
+			updateData["notes"] = fmt.Sprintf("达到总投资限额 %s", strategy.TotalInvestmentLimit.StringFixed(2))
 		} else {
-			log.Printf("价格触发策略 %d 执行成功，已投资 %.2f/%.2f，继续监控",
-				strategy.ID, updatedInvested, strategy.TotalInvestmentLimit)
+			log.Printf("价格触发策略 %d 执行成功，已投资 %s/%s，继续监控",
+				strategy.ID, updatedInvested.StringFixed(2), strategy.TotalInvestmentLimit.StringFixed(2))
 			// 保持 active 状态，继续执行
-			remainingAmount := strategy.TotalInvestmentLimit - updatedInvested
-			updateData["notes"] = fmt.Sprintf("剩余可投资额度 %.2f", remainingAmount)
+			remainingAmount := strategy.TotalInvestmentLimit.Sub(updatedInvested)
+			updateData["notes"] = fmt.Sprintf("剩余可投资额度 %s", remainingAmount.StringFixed(2))
 		}
 
 		cfg.DB.Model(&strategy).Updates(updateData)
@@ -1239,25 +1049,20 @@ func abs(x float64) float64 {
 }
 
 // calculateInvestAmount 计算投资金额
-func calculateInvestAmount(strategy models.DualInvestmentStrategy, product *models.DualInvestmentProduct) float64 {
+func calculateInvestAmount(strategy models.DualInvestmentStrategy, product *models.DualInvestmentProduct) decimal.Decimal {
 	// 可用额度
-	available := strategy.TotalInvestmentLimit - strategy.CurrentInvested
-	if available <= 0 {
-		return 0
+	available := strategy.TotalInvestmentLimit.Sub(strategy.CurrentInvested)
+	if !available.IsPositive() {
+		return decimal.Zero
 	}
 
 	// 单笔限额
-	amount := strategy.MaxSingleAmount
-	if amount > available {
-		amount = available
-	}
+	amount := decimal.Min(strategy.MaxSingleAmount, available)
 
 	// 产品限额
-	if amount > product.MaxAmount {
-		amount = product.MaxAmount
-	}
-	if amount < product.MinAmount {
-		return 0
+	amount = decimal.Min(amount, decimal.NewFromFloat(product.MaxAmount))
+	if amount.LessThan(decimal.NewFromFloat(product.MinAmount)) {
+		return decimal.Zero
 	}
 
 	return amount
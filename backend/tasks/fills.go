@@ -0,0 +1,149 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+// usdtStableAssets 按1:1折算USDT的稳定币
+var usdtStableAssets = map[string]bool{"USDT": true, "BUSD": true, "USDC": true, "FDUSD": true}
+
+// usdtRateCache 资产对USDT汇率缓存，避免每笔成交都查询行情
+var usdtRateCache sync.Map // asset -> usdtRate
+
+type usdtRate struct {
+	rate      float64
+	fetchedAt time.Time
+}
+
+// assetUSDTRate 返回资产折合USDT的汇率，查询失败时返回0
+func assetUSDTRate(asset string) float64 {
+	if asset == "" {
+		return 0
+	}
+	if usdtStableAssets[asset] {
+		return 1
+	}
+	if cached, ok := usdtRateCache.Load(asset); ok {
+		r := cached.(usdtRate)
+		if time.Since(r.fetchedAt) < time.Minute {
+			return r.rate
+		}
+	}
+
+	prices, err := services.NewExchange("", "").ListPrices(context.Background(), asset+"USDT")
+	if err != nil || len(prices) == 0 {
+		log.Printf("获取 %s 对USDT汇率失败: %v", asset, err)
+		return 0
+	}
+	rate, _ := strconv.ParseFloat(prices[0].Price, 64)
+	usdtRateCache.Store(asset, usdtRate{rate: rate, fetchedAt: time.Now()})
+	return rate
+}
+
+// recordOrderFills 拉取订单的成交明细写入成交账本，并汇总到订单记录
+func recordOrderFills(cfg *config.Config, exchange services.Exchange, order models.Order) {
+	trades, err := exchange.ListOrderTrades(context.Background(), order.Symbol, order.OrderID)
+	if err != nil {
+		log.Printf("获取订单 %d 成交明细失败: %v", order.OrderID, err)
+		return
+	}
+	if len(trades) == 0 {
+		return
+	}
+
+	baseAsset, quoteAsset := spotSymbolAssets(exchange, order.Symbol)
+	quoteRate := assetUSDTRate(quoteAsset)
+	paper := services.IsPaperExchange(exchange)
+
+	var executedQty, quoteQty decimal.Decimal
+	var commissionUSDT float64
+	for _, trade := range trades {
+		price, _ := decimal.NewFromString(trade.Price)
+		quantity, _ := decimal.NewFromString(trade.Quantity)
+		quote, _ := decimal.NewFromString(trade.QuoteQuantity)
+		commission, _ := decimal.NewFromString(trade.Commission)
+
+		side := "SELL"
+		if trade.IsBuyer {
+			side = "BUY"
+		}
+
+		fill := models.Fill{
+			UserID:          order.UserID,
+			AccountID:       order.AccountID,
+			Paper:           paper,
+			Symbol:          order.Symbol,
+			TradeID:         trade.ID,
+			OrderID:         trade.OrderID,
+			OrderRef:        order.ID,
+			StrategyID:      order.StrategyID,
+			DCAStrategyID:   order.DCAStrategyID,
+			BaseAsset:       baseAsset,
+			QuoteAsset:      quoteAsset,
+			Side:            side,
+			Price:           price,
+			Quantity:        quantity,
+			QuoteQuantity:   quote,
+			Commission:      commission,
+			CommissionAsset: trade.CommissionAsset,
+			QuoteUSDTRate:   quoteRate,
+			IsMaker:         trade.IsMaker,
+			TradeTime:       time.UnixMilli(trade.Time),
+		}
+		fill.CommissionUSDT = fillCommissionUSDT(fill)
+		if err := cfg.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&fill).Error; err != nil {
+			log.Printf("保存成交明细 %d 失败: %v", trade.ID, err)
+		}
+
+		executedQty = executedQty.Add(quantity)
+		quoteQty = quoteQty.Add(quote)
+		commissionUSDT += fill.CommissionUSDT
+	}
+
+	if !executedQty.IsPositive() {
+		return
+	}
+	if err := cfg.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+		"executed_qty":    executedQty,
+		"avg_price":       quoteQty.Div(executedQty),
+		"commission_usdt": commissionUSDT,
+	}).Error; err != nil {
+		log.Printf("更新订单 %d 成交汇总失败: %v", order.OrderID, err)
+	}
+}
+
+// fillCommissionUSDT 将成交手续费折算为USDT：计价币用计价币汇率，基础资产用成交价
+func fillCommissionUSDT(fill models.Fill) float64 {
+	commission := fill.Commission.InexactFloat64()
+	switch fill.CommissionAsset {
+	case fill.QuoteAsset:
+		return commission * fill.QuoteUSDTRate
+	case fill.BaseAsset:
+		return fill.Commission.Mul(fill.Price).InexactFloat64() * fill.QuoteUSDTRate
+	default:
+		return commission * assetUSDTRate(fill.CommissionAsset)
+	}
+}
+
+// spotSymbolAssets 返回交易对的基础资产和计价资产
+func spotSymbolAssets(exchange services.Exchange, symbol string) (string, string) {
+	if filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), symbol); err == nil && filters.BaseAsset != "" {
+		return filters.BaseAsset, filters.QuoteAsset
+	}
+	for _, quote := range []string{"USDT", "FDUSD", "USDC", "BUSD", "BTC", "ETH", "BNB"} {
+		if len(symbol) > len(quote) && symbol[len(symbol)-len(quote):] == quote {
+			return symbol[:len(symbol)-len(quote)], quote
+		}
+	}
+	return symbol, ""
+}
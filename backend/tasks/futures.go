@@ -10,22 +10,32 @@ import (
 	"sync"
 	"time"
 
-	"github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // FuturesMonitor 期货价格监控器（暂未使用，预留接口）
 // var FuturesMonitor sync.Map
 
-// FuturesWebSocketManager 期货WebSocket管理器
+// futuresEntryOrderTimeout 回测使用的开仓订单超时时间，与配置 tasks.futures_entry_timeout 的默认值一致
+// 实盘的简单策略和冰山策略按配置的超时时间撤销未成交的开仓订单
+const futuresEntryOrderTimeout = 10 * time.Minute
+
+// FuturesWebSocketManager 期货WebSocket管理器，每个交易所环境的每个交易对一个连接
 type FuturesWebSocketManager struct {
 	symbol       string
-	strategies   sync.Map // strategyID -> *models.FuturesStrategy
+	endpoints    services.Endpoints // 连接的交易所环境
+	public       bool               // 系统默认环境的连接，负责模拟盘撮合、价格提醒和K线聚合
+	strategies   sync.Map           // strategyID -> *models.FuturesStrategy
+	trailing     sync.Map           // strategyID -> *models.FuturesStrategy，持仓中且开启跟踪止损的策略
 	cfg          *config.Config
+	ctx          context.Context // 监管上下文，传给策略触发后启动的订单监控
 	wsConn       *websocket.Conn
 	stopChan     chan struct{}
 	mu           sync.RWMutex
@@ -34,27 +44,40 @@ type FuturesWebSocketManager struct {
 }
 
 // StartFuturesMonitoring 启动期货监控
-func StartFuturesMonitoring(cfg *config.Config) {
+func StartFuturesMonitoring(ctx context.Context, cfg *config.Config) {
+	// 先恢复上次停止时开仓未完成的策略，再开始触发新策略
+	recoverFuturesStrategies(ctx, cfg)
+
 	// 启动价格监控
-	go monitorFuturesPrices(cfg)
+	spawnTask(ctx, "期货价格监控", func() { monitorFuturesPrices(ctx, cfg) })
 
 	// 启动持仓监控
-	go monitorFuturesPositions(cfg)
+	spawnTask(ctx, "期货持仓监控", func() { monitorFuturesPositions(ctx, cfg) })
 
 	// 启动订单状态检查
-	go checkFuturesOrders(cfg)
+	spawnTask(ctx, "期货订单检查", func() { checkFuturesOrders(ctx, cfg) })
 }
 
 // monitorFuturesPrices 监控期货价格
-func monitorFuturesPrices(cfg *config.Config) {
-	ticker := time.NewTicker(1 * time.Second)
+func monitorFuturesPrices(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.FuturesPriceInterval)
 	defer ticker.Stop()
 
-	wsManagers := make(map[string]*FuturesWebSocketManager)
+	wsManagers := make(map[futuresStreamKey]*FuturesWebSocketManager)
 
 	log.Println("期货价格监控已启动")
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			// 关闭所有标记价格连接
+			for key, manager := range wsManagers {
+				close(manager.stopChan)
+				delete(wsManagers, key)
+			}
+			return
+		case <-ticker.C:
+		}
 		// 获取所有等待中的策略
 		var strategies []models.FuturesStrategy
 		if err := cfg.DB.Where("enabled = ? AND status = ? AND deleted_at IS NULL",
@@ -66,48 +89,135 @@ func monitorFuturesPrices(cfg *config.Config) {
 		// 只在策略数量变化时输出日志
 		// log.Printf("找到 %d 个等待中的策略", len(strategies))
 
-		// 按交易对分组
-		symbolStrategies := make(map[string][]models.FuturesStrategy)
+		// 按交易所环境和交易对分组，实盘策略使用所属账户的环境，模拟盘和公共行情使用系统默认环境
+		public := services.DefaultEndpoints()
+		resolved := make(map[string]services.Endpoints)
+		streamStrategies := make(map[futuresStreamKey][]models.FuturesStrategy)
 		for _, strategy := range strategies {
-			symbolStrategies[strategy.Symbol] = append(symbolStrategies[strategy.Symbol], strategy)
+			key := futuresStreamKey{endpoints: futuresStrategyEndpoints(cfg, &strategy, resolved), symbol: strategy.Symbol}
+			streamStrategies[key] = append(streamStrategies[key], strategy)
+		}
+
+		// 持仓中且开启跟踪止损的策略需要标记价格推进最高/最低价
+		var trailingStrategies []models.FuturesStrategy
+		if err := cfg.DB.Where("enabled = ? AND status = ? AND trailing_mode <> ? AND deleted_at IS NULL",
+			true, "position_opened", "").Find(&trailingStrategies).Error; err != nil {
+			log.Printf("查询跟踪止损策略失败: %v", err)
+		}
+		streamTrailing := make(map[futuresStreamKey]map[uint]*models.FuturesStrategy)
+		for i := range trailingStrategies {
+			s := &trailingStrategies[i]
+			key := futuresStreamKey{endpoints: futuresStrategyEndpoints(cfg, s, resolved), symbol: s.Symbol}
+			if streamTrailing[key] == nil {
+				streamTrailing[key] = make(map[uint]*models.FuturesStrategy)
+			}
+			streamTrailing[key][s.ID] = s
+			if _, exists := streamStrategies[key]; !exists {
+				streamStrategies[key] = nil
+			}
 		}
 
-		// 为每个交易对创建或更新WebSocket连接
-		for symbol, strats := range symbolStrategies {
-			if manager, exists := wsManagers[symbol]; exists {
+		// 有模拟盘挂单的交易对也需要保持价格推送，用于撮合
+		if paperSymbols, err := services.PaperOpenSymbols(cfg.DB, services.PaperMarketFutures); err == nil {
+			for _, symbol := range paperSymbols {
+				key := futuresStreamKey{endpoints: public, symbol: symbol}
+				if _, exists := streamStrategies[key]; !exists {
+					streamStrategies[key] = nil
+				}
+			}
+		}
+
+		// 有价格提醒的交易对同样需要标记价格推送
+		for _, symbol := range alertSymbols(models.AlertMarketFutures) {
+			key := futuresStreamKey{endpoints: public, symbol: symbol}
+			if _, exists := streamStrategies[key]; !exists {
+				streamStrategies[key] = nil
+			}
+		}
+
+		// 为每个环境的交易对创建或更新WebSocket连接
+		for key, strats := range streamStrategies {
+			if manager, exists := wsManagers[key]; exists {
 				// 更新策略列表
 				for _, s := range strats {
 					manager.strategies.Store(s.ID, &s)
 				}
+				manager.syncTrailing(streamTrailing[key])
 			} else {
 				// 创建新的WebSocket连接
 				manager := &FuturesWebSocketManager{
-					symbol:   symbol,
-					cfg:      cfg,
-					stopChan: make(chan struct{}),
+					symbol:    key.symbol,
+					endpoints: key.endpoints,
+					public:    key.endpoints == public,
+					cfg:       cfg,
+					ctx:       ctx,
+					stopChan:  make(chan struct{}),
 				}
 				for _, s := range strats {
 					manager.strategies.Store(s.ID, &s)
 				}
-				wsManagers[symbol] = manager
-				go manager.start()
+				manager.syncTrailing(streamTrailing[key])
+				wsManagers[key] = manager
+				spawnTask(ctx, "期货行情 "+key.symbol, manager.start)
 			}
 		}
 
-		// 清理不再需要的连接
-		for symbol, manager := range wsManagers {
-			if _, exists := symbolStrategies[symbol]; !exists {
+		// 清理不再需要的连接，系统默认环境变更后旧环境的公共连接也在这里关闭
+		for key, manager := range wsManagers {
+			if _, exists := streamStrategies[key]; !exists {
 				close(manager.stopChan)
-				delete(wsManagers, symbol)
+				delete(wsManagers, key)
 			}
 		}
 	}
 }
 
+// futuresStreamKey 标记价格连接的标识，不同环境的同一交易对使用不同连接
+type futuresStreamKey struct {
+	endpoints services.Endpoints
+	symbol    string
+}
+
+// futuresStrategyEndpoints 获取策略行情应连接的交易所环境，resolved 缓存本轮已解析的账户
+// 模拟盘策略按公共行情撮合，使用系统默认环境；账户无法解析时同样退回默认环境，下单时会再报错
+func futuresStrategyEndpoints(cfg *config.Config, strategy *models.FuturesStrategy, resolved map[string]services.Endpoints) services.Endpoints {
+	if strategy.Paper {
+		return services.DefaultEndpoints()
+	}
+	cacheKey := fmt.Sprintf("%d|%d", strategy.UserID, strategy.AccountID)
+	if endpoints, ok := resolved[cacheKey]; ok {
+		return endpoints
+	}
+	endpoints := services.DefaultEndpoints()
+	var user models.User
+	if err := cfg.DB.Select("id", "paper_trading").First(&user, strategy.UserID).Error; err != nil {
+		log.Printf("获取用户 %d 信息失败: %v", strategy.UserID, err)
+	} else if !user.PaperTrading {
+		endpoints = accountStreamEndpoints(cfg, strategy.UserID, strategy.AccountID)
+	}
+	resolved[cacheKey] = endpoints
+	return endpoints
+}
+
+// accountStreamEndpoints 实盘账户行情应连接的交易所环境，账户无法解析时退回系统默认环境，下单时会再报错
+func accountStreamEndpoints(cfg *config.Config, userID, accountID uint) services.Endpoints {
+	account, err := services.ResolveAccount(cfg.DB, userID, accountID)
+	if err != nil {
+		log.Printf("解析用户 %d 的交易所账户 %d 失败: %v", userID, accountID, err)
+		return services.DefaultEndpoints()
+	}
+	endpoints, err := services.AccountEndpoints(account)
+	if err != nil {
+		log.Printf("解析交易所账户 %s 的环境失败: %v", account.Label, err)
+		return services.DefaultEndpoints()
+	}
+	return endpoints
+}
+
 // start 启动WebSocket连接
 func (m *FuturesWebSocketManager) start() {
-	// 将交易对转换为小写
-	wsURL := fmt.Sprintf("wss://fstream.binance.com/ws/%s@markPrice@1s", strings.ToLower(m.symbol))
+	// 将交易对转换为小写，地址使用连接所属的交易所环境
+	wsURL := fmt.Sprintf("%s/%s@markPrice@1s", m.endpoints.FuturesWS, strings.ToLower(m.symbol))
 
 	// 减少日志输出
 	// log.Printf("准备连接 %s 的 WebSocket", m.symbol)
@@ -123,7 +233,12 @@ func (m *FuturesWebSocketManager) start() {
 					log.Printf("关闭WebSocket连接失败: %v", err)
 				}
 			}
-			time.Sleep(5 * time.Second) // 重连间隔
+			// 重连间隔
+			select {
+			case <-m.stopChan:
+				return
+			case <-time.After(5 * time.Second):
+			}
 		}
 	}
 }
@@ -145,6 +260,10 @@ func (m *FuturesWebSocketManager) connect(wsURL string) {
 	}()
 
 	m.wsConn = conn
+	if m.public {
+		candleStreamConnected(models.CandleMarketFutures, m.symbol)
+		defer candleStreamDisconnected(models.CandleMarketFutures, m.symbol)
+	}
 	// 减少连接成功日志
 	// log.Printf("WebSocket 连接成功: %s", m.symbol)
 
@@ -168,14 +287,45 @@ func (m *FuturesWebSocketManager) connect(wsURL string) {
 					m.lastPrice = markPrice
 					m.mu.Unlock()
 
+					// 撮合模拟盘挂单和止盈止损单，只使用系统默认环境的公共行情
+					if m.public {
+						matchPaperOrders(m.cfg, services.PaperMarketFutures, m.symbol, markPrice)
+					}
+
 					// 检查策略触发
 					m.checkStrategies(markPrice)
+
+					// 推进跟踪止损
+					m.checkTrailingStops(markPrice)
+
+					if m.public {
+						m.handlePublicMarkPrice(msg, markPrice)
+					}
 				}
 			}
 		}
 	}
 }
 
+// handlePublicMarkPrice 用系统默认环境的标记价格检查价格提醒并聚合K线
+func (m *FuturesWebSocketManager) handlePublicMarkPrice(msg map[string]interface{}, markPrice float64) {
+	// 检查价格提醒，资金费率缺失时不评估费率类提醒
+	fundingRate := math.NaN()
+	if rateStr, ok := msg["r"].(string); ok && rateStr != "" {
+		if rate, err := strconv.ParseFloat(rateStr, 64); err == nil {
+			fundingRate = rate
+		}
+	}
+	evaluateFuturesAlerts(m.cfg, m.symbol, markPrice, fundingRate)
+
+	// 按标记价格聚合K线，使用推送的事件时间；标记价格没有成交量，合约K线只有价格
+	eventTime := time.Now()
+	if ms, ok := msg["E"].(float64); ok && ms > 0 {
+		eventTime = time.UnixMilli(int64(ms))
+	}
+	recordCandleTrade(models.CandleMarketFutures, m.symbol, markPrice, 0, eventTime)
+}
+
 // checkStrategies 检查策略是否触发
 func (m *FuturesWebSocketManager) checkStrategies(currentPrice float64) {
 	// 移除调试日志
@@ -190,23 +340,18 @@ func (m *FuturesWebSocketManager) checkStrategies(currentPrice float64) {
 		// 	strategy.Status, strategy.Enabled)
 
 		// 检查是否触发
-		shouldTrigger := false
-		if strategy.Side == "LONG" && currentPrice <= strategy.BasePrice {
-			shouldTrigger = true
-			// 减少触发条件日志
-			// log.Printf("策略 %d 满足做多触发条件", strategy.ID)
-		} else if strategy.Side == "SHORT" && currentPrice >= strategy.BasePrice {
-			shouldTrigger = true
-			// 减少触发条件日志
-			// log.Printf("策略 %d 满足做空触发条件", strategy.ID)
-		}
-
-		if shouldTrigger {
+		if liveFuturesStrategyTriggered(m.cfg, strategy, currentPrice) {
+			// 服务停止中不再触发新策略
+			if !beginOrderPlacement() {
+				return false
+			}
+			started := false
+
 			// 使用事务确保并发安全
 			err := m.cfg.DB.Transaction(func(tx *gorm.DB) error {
 				// 重新查询策略状态
 				var currentStrategy models.FuturesStrategy
-				if err := tx.Set("gorm:query_option", "FOR UPDATE").
+				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
 					First(&currentStrategy, strategy.ID).Error; err != nil {
 					return err
 				}
@@ -230,11 +375,33 @@ func (m *FuturesWebSocketManager) checkStrategies(currentPrice float64) {
 					strategy.ID, strategy.Side, strategy.Symbol, currentPrice)
 
 				// 异步执行开仓
-				go m.executeStrategy(&currentStrategy)
+				started = true
+				go func() {
+					defer endOrderPlacement()
+					m.executeStrategy(&currentStrategy)
+				}()
+				publishStrategyState(currentStrategy.UserID, "futures", currentStrategy.ID, currentStrategy.Status)
+
+				EmitNotification(NotificationEvent{
+					UserID:  currentStrategy.UserID,
+					Event:   models.NotifyStrategyTriggered,
+					Title:   fmt.Sprintf("合约策略触发: %s %s", currentStrategy.Side, currentStrategy.Symbol),
+					Message: fmt.Sprintf("合约策略 %d 在标记价格 %.8f 触发，正在开仓", currentStrategy.ID, currentPrice),
+					Data: map[string]interface{}{
+						"strategyId": currentStrategy.ID,
+						"symbol":     currentStrategy.Symbol,
+						"side":       currentStrategy.Side,
+						"price":      currentPrice,
+						"paper":      currentStrategy.Paper,
+					},
+				})
 
 				return nil
 			})
 
+			if !started {
+				endOrderPlacement()
+			}
 			if err != nil && err.Error() != "策略状态已变更" {
 				log.Printf("更新策略状态失败: %v", err)
 			}
@@ -247,6 +414,18 @@ func (m *FuturesWebSocketManager) checkStrategies(currentPrice float64) {
 	})
 }
 
+// futuresStrategyTriggered 判断标记价格是否满足策略触发条件
+func futuresStrategyTriggered(strategy *models.FuturesStrategy, currentPrice float64) bool {
+	price := decimal.NewFromFloat(currentPrice)
+	if strategy.Side == "LONG" {
+		return price.LessThanOrEqual(strategy.BasePrice)
+	}
+	if strategy.Side == "SHORT" {
+		return price.GreaterThanOrEqual(strategy.BasePrice)
+	}
+	return false
+}
+
 // executeStrategy 执行策略开仓
 func (m *FuturesWebSocketManager) executeStrategy(strategy *models.FuturesStrategy) {
 	// 获取用户信息
@@ -256,46 +435,61 @@ func (m *FuturesWebSocketManager) executeStrategy(strategy *models.FuturesStrate
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
+	// 创建期货客户端（模拟盘策略使用模拟撮合引擎）
+	exchange, err := services.NewTradingExchange(m.cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
 	if err != nil {
-		log.Printf("解密API Key失败: %v", err)
+		log.Printf("创建交易所客户端失败: %v", err)
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		log.Printf("解密Secret Key失败: %v", err)
+
+	// 开仓前风控检查，冰山策略按全部层数计算挂单数
+	orders := 1
+	if strategy.StrategyType == "iceberg" {
+		orders = len(parseQuantities(strategy.IcebergQuantities))
+	}
+	if err := CheckOrderRisk(m.cfg, exchange, RiskOrder{
+		UserID:     strategy.UserID,
+		AccountID:  strategy.AccountID,
+		Market:     RiskMarketFutures,
+		Source:     "futures_strategy",
+		StrategyID: strategy.ID,
+		Symbol:     strategy.Symbol,
+		Side:       strategy.Side,
+		Notional:   strategy.OrderValue().InexactFloat64(),
+		Leverage:   strategy.Leverage,
+		Orders:     orders,
+		Paper:      services.IsPaperExchange(exchange),
+	}); err != nil {
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
-	// 创建期货客户端
-	client := binance.NewFuturesClient(apiKey, secretKey)
-
 	// 根据策略类型执行不同的开仓逻辑
 	switch strategy.StrategyType {
 	case "iceberg":
 		// 执行冰山策略
-		m.executeIcebergStrategy(strategy, client)
+		m.executeIcebergStrategy(strategy, exchange)
 	case "slow_iceberg":
 		// 执行慢冰山策略
-		m.executeSlowIcebergStrategy(strategy, client)
+		m.executeSlowIcebergStrategy(strategy, exchange)
 	default:
 		// 执行简单策略
-		m.executeSimpleStrategy(strategy, client)
+		m.executeSimpleStrategy(strategy, exchange)
 	}
 }
 
 // executeSimpleStrategy 执行简单策略
-func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.FuturesStrategy, client *futures.Client) {
+func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.FuturesStrategy, exchange services.Exchange) {
 	// 设置杠杆
-	if err := setLeverage(client, strategy.Symbol, strategy.Leverage); err != nil {
+	if err := setLeverage(exchange, strategy.Symbol, strategy.Leverage); err != nil {
 		log.Printf("设置杠杆失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
 	// 设置保证金模式（忽略已存在的错误）
-	if err := setMarginType(client, strategy.Symbol, strategy.MarginType); err != nil {
+	if err := setMarginType(exchange, strategy.Symbol, strategy.MarginType); err != nil {
 		// 检查是否是"不需要更改"的错误
 		if !strings.Contains(err.Error(), "No need to change margin type") {
 			log.Printf("设置保证金模式失败: %v", err)
@@ -303,68 +497,17 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 		}
 	}
 
-	// 获取交易规则（精度信息）
-	exchangeInfo, err := client.NewExchangeInfoService().Do(context.Background())
+	// 获取交易规则（tickSize、stepSize、最小数量等）
+	filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
 	if err != nil {
 		log.Printf("获取交易规则失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
-
-	// 查找当前交易对的规则
-	var symbolInfo *futures.Symbol
-	for _, s := range exchangeInfo.Symbols {
-		if s.Symbol == strategy.Symbol {
-			symbolInfo = &s
-			break
-		}
-	}
-
-	if symbolInfo == nil {
-		log.Printf("未找到交易对 %s 的规则", strategy.Symbol)
-		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", "未找到交易对规则")
-		return
-	}
-
-	// 获取价格和数量精度
-	var pricePrecision int
-	var quantityPrecision int
-	var tickSize float64
-	var stepSize float64
-	var minQty float64
-
-	// 直接使用 Symbol 结构体中的精度信息
-	pricePrecision = symbolInfo.PricePrecision
-	quantityPrecision = symbolInfo.QuantityPrecision
-
-	// 从过滤器中获取 tick size、step size 和最小数量
-	for _, filter := range symbolInfo.Filters {
-		if filterType, ok := filter["filterType"].(string); ok {
-			switch filterType {
-			case "PRICE_FILTER":
-				if tickSizeStr, ok := filter["tickSize"].(string); ok {
-					tickSize, _ = strconv.ParseFloat(tickSizeStr, 64)
-				}
-			case "LOT_SIZE":
-				if stepSizeStr, ok := filter["stepSize"].(string); ok {
-					stepSize, _ = strconv.ParseFloat(stepSizeStr, 64)
-				}
-				if minQtyStr, ok := filter["minQty"].(string); ok {
-					minQty, _ = strconv.ParseFloat(minQtyStr, 64)
-				}
-			}
-		}
-	}
-
-	// 减少规则日志
-	// log.Printf("交易对 %s 规则 - 价格精度: %d, 数量精度: %d, TickSize: %f, StepSize: %f, MinQty: %f",
-	// 	strategy.Symbol, pricePrecision, quantityPrecision, tickSize, stepSize, minQty)
+	tickSize, stepSize, minQty := filters.TickSize, filters.StepSize, filters.MinQty
 
 	// 获取深度数据以计算开仓价格
-	depth, err := client.NewDepthService().
-		Symbol(strategy.Symbol).
-		Limit(20). // 增加深度层级以便更好地避免吃单
-		Do(context.Background())
+	depth, err := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 20) // 增加深度层级以便更好地避免吃单
 	if err != nil {
 		log.Printf("获取深度失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
@@ -377,39 +520,13 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 		// 做多时使用卖一价
 		if len(depth.Asks) > 0 {
 			askPrice, _ := strconv.ParseFloat(depth.Asks[0].Price, 64)
-
-			// 如果设置了浮动，按浮动计算
-			if strategy.EntryPriceFloat > 0 {
-				entryPrice = askPrice * (1 - strategy.EntryPriceFloat/10000) // 万分比
-			} else {
-				// 没有设置浮动时，稍微低于卖一价挂单（避免立即吃单）
-				// 默认低于卖一价1个tick
-				entryPrice = askPrice - tickSize
-			}
-
-			// 确保价格不会高于卖一价（避免吃单）
-			if entryPrice >= askPrice {
-				entryPrice = askPrice - tickSize
-			}
+			entryPrice = makerEntryPrice(strategy, askPrice, 0, tickSize)
 		}
 	} else {
 		// 做空时使用买一价
 		if len(depth.Bids) > 0 {
 			bidPrice, _ := strconv.ParseFloat(depth.Bids[0].Price, 64)
-
-			// 如果设置了浮动，按浮动计算
-			if strategy.EntryPriceFloat > 0 {
-				entryPrice = bidPrice * (1 + strategy.EntryPriceFloat/10000) // 万分比
-			} else {
-				// 没有设置浮动时，稍微高于买一价挂单（避免立即吃单）
-				// 默认高于买一价1个tick
-				entryPrice = bidPrice + tickSize
-			}
-
-			// 确保价格不会低于买一价（避免吃单）
-			if entryPrice <= bidPrice {
-				entryPrice = bidPrice + tickSize
-			}
+			entryPrice = makerEntryPrice(strategy, bidPrice, 0, tickSize)
 		}
 	}
 
@@ -419,14 +536,9 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 		return
 	}
 
-	// 将价格调整为 tick size 的整数倍
-	if tickSize > 0 {
-		entryPrice = math.Round(entryPrice/tickSize) * tickSize
-	}
-
 	// 计算合约数量（使用本金×杠杆计算实际开仓价值）
-	actualOrderValue := strategy.Quantity * float64(strategy.Leverage) // 本金×杠杆=实际开仓价值
-	contractQuantity := actualOrderValue / entryPrice                  // 实际开仓价值÷价格=合约数量
+	actualOrderValue := strategy.OrderValue().InexactFloat64() // 本金×杠杆=实际开仓价值
+	contractQuantity := actualOrderValue / entryPrice          // 实际开仓价值÷价格=合约数量
 
 	// 减少开仓计算日志
 	// log.Printf("开仓计算 - 本金: %.2f USDT, 杠杆: %dx, 实际开仓价值: %.2f USDT, 合约数量: %.8f",
@@ -434,7 +546,7 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 
 	// 将数量调整为 step size 的整数倍
 	if stepSize > 0 {
-		contractQuantity = math.Floor(contractQuantity/stepSize) * stepSize
+		contractQuantity = services.RoundToStep(contractQuantity, stepSize, services.RoundDown)
 	}
 
 	// 检查数量是否小于最小数量
@@ -449,66 +561,82 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 
 	// 再次检查数量是否为0
 	if contractQuantity <= 0 {
-		errMsg := fmt.Sprintf("计算后的合约数量为0。本金: %.2f USDT, 杠杆: %dx, 价格: %.2f, 最小数量: %.8f",
-			strategy.Quantity, strategy.Leverage, entryPrice, minQty)
-		log.Printf(errMsg)
+		errMsg := fmt.Sprintf("计算后的合约数量为0。本金: %s USDT, 杠杆: %dx, 价格: %.2f, 最小数量: %.8f",
+			strategy.Quantity.String(), strategy.Leverage, entryPrice, minQty)
+		log.Print(errMsg)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", errMsg)
 		return
 	}
 
-	// 格式化数量和价格，确保不超过允许的精度
-	quantityFormat := fmt.Sprintf("%%.%df", quantityPrecision)
-	priceFormat := fmt.Sprintf("%%.%df", pricePrecision)
+	// 下单前按交易规则校验价格、数量和名义价值
+	side := futures.SideTypeBuy
+	if strategy.Side == "SHORT" {
+		side = futures.SideTypeSell
+	}
+	if err := filters.Validate(string(side), entryPrice, contractQuantity, entryPrice, false); err != nil {
+		log.Printf("期货策略 %d 开仓订单不符合交易规则: %v", strategy.ID, err)
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
+		return
+	}
 
-	formattedQuantity := fmt.Sprintf(quantityFormat, contractQuantity)
-	formattedPrice := fmt.Sprintf(priceFormat, entryPrice)
+	// 按 tickSize/stepSize 格式化数量和价格
+	formattedQuantity := filters.FormatQuantity(contractQuantity)
+	formattedPrice := filters.FormatPrice(entryPrice)
 
 	// 保留关键的开仓参数日志
 	log.Printf("开仓参数 - 策略ID: %d, 交易对: %s, 方向: %s, 数量: %s, 价格: %s",
 		strategy.ID, strategy.Symbol, strategy.Side, formattedQuantity, formattedPrice)
 
+	orderPrice, orderQty, err := parseFuturesOrderValues(formattedPrice, formattedQuantity)
+	if err != nil {
+		log.Printf("期货策略 %d 开仓参数无效: %v", strategy.ID, err)
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
+		return
+	}
+
 	// 更新策略的实际开仓价格
-	strategy.EntryPrice = entryPrice
+	strategy.EntryPrice = orderPrice
 	strategy.CalculateTakeProfitPrice()
 	strategy.CalculateStopLossPrice()
 	m.cfg.DB.Save(strategy)
 
-	// 创建开仓订单
-	side := futures.SideTypeBuy
-	if strategy.Side == "SHORT" {
-		side = futures.SideTypeSell
-	}
-
 	// 使用期货客户端创建订单
-	orderService := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeLimit).
-		TimeInForce(futures.TimeInForceTypeGTC).
-		Quantity(formattedQuantity).
-		Price(formattedPrice)
-
-	order, err := orderService.Do(context.Background())
+	orderReq := services.FuturesOrderRequest{
+		Symbol:        strategy.Symbol,
+		Side:          side,
+		PositionSide:  futures.PositionSideType(strategy.Side),
+		Type:          futures.OrderTypeLimit,
+		TimeInForce:   futures.TimeInForceTypeGTC,
+		Quantity:      formattedQuantity,
+		Price:         formattedPrice,
+		ClientOrderID: entryClientOrderID(strategy.ID),
+	}
+
+	order, err := exchange.CreateFuturesOrder(context.Background(), orderReq)
 	if err != nil {
 		log.Printf("创建开仓订单失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
+	// 订单挂出后立即记录执行状态，中断时能找回该订单
+	exec := startFuturesExecution(m.cfg, strategy, order.OrderID)
+
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "LIMIT",
-		Price:        entryPrice,
-		Quantity:     contractQuantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "entry",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := m.cfg.DB.Create(&dbOrder).Error; err != nil {
@@ -518,83 +646,36 @@ func (m *FuturesWebSocketManager) executeSimpleStrategy(strategy *models.Futures
 	log.Printf("期货策略 %d 开仓订单创建成功: OrderID=%d", strategy.ID, order.OrderID)
 
 	// 启动订单监控
-	go monitorEntryOrder(m.cfg, strategy, order.OrderID)
+	spawnTask(m.ctx, "开仓订单监控", func() { monitorEntryOrder(m.ctx, m.cfg, strategy, exec) })
 }
 
 // executeSlowIcebergStrategy 执行慢冰山策略
-func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.FuturesStrategy, client *futures.Client) {
+func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.FuturesStrategy, exchange services.Exchange) {
 	// 设置杠杆
-	if err := setLeverage(client, strategy.Symbol, strategy.Leverage); err != nil {
+	if err := setLeverage(exchange, strategy.Symbol, strategy.Leverage); err != nil {
 		log.Printf("设置杠杆失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
 	// 设置保证金模式（忽略已存在的错误）
-	if err := setMarginType(client, strategy.Symbol, strategy.MarginType); err != nil {
+	if err := setMarginType(exchange, strategy.Symbol, strategy.MarginType); err != nil {
 		if !strings.Contains(err.Error(), "No need to change margin type") {
 			log.Printf("设置保证金模式失败: %v", err)
 		}
 	}
 
-	// 获取交易规则（精度信息）
-	exchangeInfo, err := client.NewExchangeInfoService().Do(context.Background())
+	// 获取交易规则（tickSize、stepSize、最小数量等）
+	filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
 	if err != nil {
 		log.Printf("获取交易规则失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
-
-	// 查找当前交易对的规则
-	var symbolInfo *futures.Symbol
-	for _, s := range exchangeInfo.Symbols {
-		if s.Symbol == strategy.Symbol {
-			symbolInfo = &s
-			break
-		}
-	}
-
-	if symbolInfo == nil {
-		log.Printf("未找到交易对 %s 的规则", strategy.Symbol)
-		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", "未找到交易对规则")
-		return
-	}
-
-	// 获取价格和数量精度
-	var pricePrecision int
-	var quantityPrecision int
-	var tickSize float64
-	var stepSize float64
-	var minQty float64
-
-	// 直接使用 Symbol 结构体中的精度信息
-	pricePrecision = symbolInfo.PricePrecision
-	quantityPrecision = symbolInfo.QuantityPrecision
-
-	// 从过滤器中获取 tick size、step size 和最小数量
-	for _, filter := range symbolInfo.Filters {
-		if filterType, ok := filter["filterType"].(string); ok {
-			switch filterType {
-			case "PRICE_FILTER":
-				if tickSizeStr, ok := filter["tickSize"].(string); ok {
-					tickSize, _ = strconv.ParseFloat(tickSizeStr, 64)
-				}
-			case "LOT_SIZE":
-				if stepSizeStr, ok := filter["stepSize"].(string); ok {
-					stepSize, _ = strconv.ParseFloat(stepSizeStr, 64)
-				}
-				if minQtyStr, ok := filter["minQty"].(string); ok {
-					minQty, _ = strconv.ParseFloat(minQtyStr, 64)
-				}
-			}
-		}
-	}
+	tickSize, stepSize, minQty := filters.TickSize, filters.StepSize, filters.MinQty
 
 	// 获取当前市场深度
-	depth, err := client.NewDepthService().
-		Symbol(strategy.Symbol).
-		Limit(20).
-		Do(context.Background())
+	depth, err := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 20)
 	if err != nil {
 		log.Printf("获取深度失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
@@ -631,47 +712,17 @@ func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.Fu
 		return
 	}
 
-	// 计算第一层的价格
-	firstLayerPrice := basePrice * (1 + priceGaps[0]/10000)
-
-	// 应用开仓价格浮动（万分比）并避免吃单
-	if strategy.Side == "LONG" {
-		if strategy.EntryPriceFloat > 0 {
-			firstLayerPrice = firstLayerPrice * (1 - strategy.EntryPriceFloat/10000)
-		} else {
-			// 默认低于基准价1个tick避免吃单
-			firstLayerPrice = firstLayerPrice - tickSize
-		}
-		// 确保不会高于卖一价
-		if firstLayerPrice >= basePrice {
-			firstLayerPrice = basePrice - tickSize
-		}
-	} else {
-		if strategy.EntryPriceFloat > 0 {
-			firstLayerPrice = firstLayerPrice * (1 + strategy.EntryPriceFloat/10000)
-		} else {
-			// 默认高于基准价1个tick避免吃单
-			firstLayerPrice = firstLayerPrice + tickSize
-		}
-		// 确保不会低于买一价
-		if firstLayerPrice <= basePrice {
-			firstLayerPrice = basePrice + tickSize
-		}
-	}
-
-	// 将价格调整为 tick size 的整数倍
-	if tickSize > 0 {
-		firstLayerPrice = math.Round(firstLayerPrice/tickSize) * tickSize
-	}
+	// 计算第一层的价格（应用开仓价格浮动并避免吃单）
+	firstLayerPrice := makerEntryPrice(strategy, basePrice, priceGaps[0], tickSize)
 
 	// 计算第一层的价值和数量
-	totalOrderValue := strategy.Quantity * float64(strategy.Leverage)
+	totalOrderValue := strategy.OrderValue().InexactFloat64()
 	firstLayerValue := totalOrderValue * quantities[0]
 	firstLayerQuantity := firstLayerValue / firstLayerPrice
 
 	// 将数量调整为 step size 的整数倍
 	if stepSize > 0 {
-		firstLayerQuantity = math.Floor(firstLayerQuantity/stepSize) * stepSize
+		firstLayerQuantity = services.RoundToStep(firstLayerQuantity, stepSize, services.RoundDown)
 	}
 
 	// 检查第一层数量是否满足最小要求
@@ -681,13 +732,22 @@ func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.Fu
 		return
 	}
 
-	// 格式化数量和价格的格式字符串
-	quantityFormat := fmt.Sprintf("%%.%df", quantityPrecision)
-	priceFormat := fmt.Sprintf("%%.%df", pricePrecision)
+	// 创建开仓方向
+	side := futures.SideTypeBuy
+	if strategy.Side == "SHORT" {
+		side = futures.SideTypeSell
+	}
+
+	// 下单前按交易规则校验
+	if err := filters.Validate(string(side), firstLayerPrice, firstLayerQuantity, basePrice, false); err != nil {
+		log.Printf("慢冰山策略 %d 第一层订单不符合交易规则: %v", strategy.ID, err)
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
+		return
+	}
 
 	// 格式化第一层数量和价格
-	formattedQuantity := fmt.Sprintf(quantityFormat, firstLayerQuantity)
-	formattedPrice := fmt.Sprintf(priceFormat, firstLayerPrice)
+	formattedQuantity := filters.FormatQuantity(firstLayerQuantity)
+	formattedPrice := filters.FormatPrice(firstLayerPrice)
 
 	// 计算第一层使用的本金
 	firstLayerMargin := firstLayerValue / float64(strategy.Leverage)
@@ -695,42 +755,50 @@ func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.Fu
 	log.Printf("慢冰山策略 %d 第1层 - 价格: %s, 数量: %s (本金: %.2f USDT)",
 		strategy.ID, formattedPrice, formattedQuantity, firstLayerMargin)
 
-	// 创建开仓方向
-	side := futures.SideTypeBuy
-	if strategy.Side == "SHORT" {
-		side = futures.SideTypeSell
+	orderPrice, orderQty, err := parseFuturesOrderValues(formattedPrice, formattedQuantity)
+	if err != nil {
+		log.Printf("慢冰山策略 %d 第1层参数无效: %v", strategy.ID, err)
+		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
+		return
 	}
 
 	// 创建第一层限价订单
-	orderService := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeLimit).
-		TimeInForce(futures.TimeInForceTypeGTC).
-		Quantity(formattedQuantity).
-		Price(formattedPrice)
-
-	order, err := orderService.Do(context.Background())
+	orderReq := services.FuturesOrderRequest{
+		Symbol:        strategy.Symbol,
+		Side:          side,
+		PositionSide:  futures.PositionSideType(strategy.Side),
+		Type:          futures.OrderTypeLimit,
+		TimeInForce:   futures.TimeInForceTypeGTC,
+		Quantity:      formattedQuantity,
+		Price:         formattedPrice,
+		ClientOrderID: entryClientOrderID(strategy.ID),
+	}
+
+	order, err := exchange.CreateFuturesOrder(context.Background(), orderReq)
 	if err != nil {
 		log.Printf("创建慢冰山第1层订单失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
+	// 订单挂出后立即记录执行状态，中断时能找回该订单
+	exec := startFuturesExecution(m.cfg, strategy, order.OrderID)
+
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "LIMIT",
-		Price:        firstLayerPrice,
-		Quantity:     firstLayerQuantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "entry",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := m.cfg.DB.Create(&dbOrder).Error; err != nil {
@@ -739,85 +807,38 @@ func (m *FuturesWebSocketManager) executeSlowIcebergStrategy(strategy *models.Fu
 
 	log.Printf("慢冰山策略 %d 第1层订单创建成功: OrderID=%d", strategy.ID, order.OrderID)
 
-	// 启动慢冰山订单监控（传递必要的参数）
-	go monitorSlowIcebergOrders(m.cfg, strategy, order.OrderID, 0, quantities, priceGaps,
-		pricePrecision, quantityPrecision, tickSize, stepSize, minQty)
+	// 启动慢冰山订单监控
+	spawnTask(m.ctx, "慢冰山订单监控", func() {
+		monitorSlowIcebergOrders(m.ctx, m.cfg, strategy, exec, quantities, priceGaps, filters)
+	})
 }
 
 // executeIcebergStrategy 执行冰山策略
-func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.FuturesStrategy, client *futures.Client) {
+func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.FuturesStrategy, exchange services.Exchange) {
 	// 设置杠杆
-	if err := setLeverage(client, strategy.Symbol, strategy.Leverage); err != nil {
+	if err := setLeverage(exchange, strategy.Symbol, strategy.Leverage); err != nil {
 		log.Printf("设置杠杆失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
 	// 设置保证金模式（忽略已存在的错误）
-	if err := setMarginType(client, strategy.Symbol, strategy.MarginType); err != nil {
+	if err := setMarginType(exchange, strategy.Symbol, strategy.MarginType); err != nil {
 		if !strings.Contains(err.Error(), "No need to change margin type") {
 			log.Printf("设置保证金模式失败: %v", err)
 		}
 	}
 
-	// 获取交易规则（精度信息）
-	exchangeInfo, err := client.NewExchangeInfoService().Do(context.Background())
+	// 获取交易规则（tickSize、stepSize、最小数量等）
+	filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
 	if err != nil {
 		log.Printf("获取交易规则失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
 		return
 	}
 
-	// 查找当前交易对的规则
-	var symbolInfo *futures.Symbol
-	for _, s := range exchangeInfo.Symbols {
-		if s.Symbol == strategy.Symbol {
-			symbolInfo = &s
-			break
-		}
-	}
-
-	if symbolInfo == nil {
-		log.Printf("未找到交易对 %s 的规则", strategy.Symbol)
-		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", "未找到交易对规则")
-		return
-	}
-
-	// 获取价格和数量精度
-	var pricePrecision int
-	var quantityPrecision int
-	var tickSize float64
-	var stepSize float64
-	var minQty float64
-
-	// 直接使用 Symbol 结构体中的精度信息
-	pricePrecision = symbolInfo.PricePrecision
-	quantityPrecision = symbolInfo.QuantityPrecision
-
-	// 从过滤器中获取 tick size、step size 和最小数量
-	for _, filter := range symbolInfo.Filters {
-		if filterType, ok := filter["filterType"].(string); ok {
-			switch filterType {
-			case "PRICE_FILTER":
-				if tickSizeStr, ok := filter["tickSize"].(string); ok {
-					tickSize, _ = strconv.ParseFloat(tickSizeStr, 64)
-				}
-			case "LOT_SIZE":
-				if stepSizeStr, ok := filter["stepSize"].(string); ok {
-					stepSize, _ = strconv.ParseFloat(stepSizeStr, 64)
-				}
-				if minQtyStr, ok := filter["minQty"].(string); ok {
-					minQty, _ = strconv.ParseFloat(minQtyStr, 64)
-				}
-			}
-		}
-	}
-
 	// 获取当前市场深度
-	depth, err := client.NewDepthService().
-		Symbol(strategy.Symbol).
-		Limit(20).
-		Do(context.Background())
+	depth, err := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 20)
 	if err != nil {
 		log.Printf("获取深度失败: %v", err)
 		updateStrategyStatus(m.cfg.DB, strategy, "cancelled", err.Error())
@@ -861,122 +882,12 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 	}
 
 	var successfulOrders []int64
+	var exec *models.FuturesExecution
 	var totalExecutedQuantity float64
 	var weightedPriceSum float64
 
-	// 计算实际开仓价值（本金×杠杆）
-	totalOrderValue := strategy.Quantity * float64(strategy.Leverage)
-
-	// 格式化数量和价格的格式字符串
-	quantityFormat := fmt.Sprintf("%%.%df", quantityPrecision)
-	priceFormat := fmt.Sprintf("%%.%df", pricePrecision)
-
-	// 先计算所有层的价格和检查哪些层的数量太小
-	type layerInfo struct {
-		price    float64
-		quantity float64
-		value    float64 // 该层的价值（USDT）
-		skip     bool
-	}
-
-	layers := make([]layerInfo, len(quantities))
-	skippedValue := 0.0
-
-	// 第一遍：计算每层信息并标记需要跳过的层
-	for i := 0; i < len(quantities); i++ {
-		// 计算每层的价格
-		layerPrice := basePrice * (1 + priceGaps[i]/10000)
-
-		// 应用开仓价格浮动（万分比）并避免吃单
-		if i == 0 { // 只对第一层应用浮动和避免吃单逻辑
-			if strategy.Side == "LONG" {
-				if strategy.EntryPriceFloat > 0 {
-					layerPrice = layerPrice * (1 - strategy.EntryPriceFloat/10000)
-				} else if priceGaps[i] == 0 {
-					// 第一层价格间隔为0且没有设置浮动时，避免吃单
-					layerPrice = layerPrice - tickSize
-				}
-				// 确保不会高于卖一价
-				if layerPrice >= basePrice {
-					layerPrice = basePrice - tickSize
-				}
-			} else {
-				if strategy.EntryPriceFloat > 0 {
-					layerPrice = layerPrice * (1 + strategy.EntryPriceFloat/10000)
-				} else if priceGaps[i] == 0 {
-					// 第一层价格间隔为0且没有设置浮动时，避免吃单
-					layerPrice = layerPrice + tickSize
-				}
-				// 确保不会低于买一价
-				if layerPrice <= basePrice {
-					layerPrice = basePrice + tickSize
-				}
-			}
-		}
-
-		// 将价格调整为 tick size 的整数倍
-		if tickSize > 0 {
-			layerPrice = math.Round(layerPrice/tickSize) * tickSize
-		}
-
-		// 计算每层的价值（按比例分配总价值）
-		layerValue := totalOrderValue * quantities[i]
-
-		// 转换为合约数量
-		layerContractQuantity := layerValue / layerPrice
-
-		// 将数量调整为 step size 的整数倍
-		if stepSize > 0 {
-			layerContractQuantity = math.Floor(layerContractQuantity/stepSize) * stepSize
-		}
-
-		layers[i] = layerInfo{
-			price:    layerPrice,
-			quantity: layerContractQuantity,
-			value:    layerValue,
-			skip:     layerContractQuantity < minQty,
-		}
-
-		if layers[i].skip {
-			skippedValue += layerValue
-			// 减少跳过层的日志
-			// log.Printf("冰山第%d层将被跳过 - 价值: %.2f USDT, 数量: %.8f < 最小数量: %.8f",
-			// 	i+1, layerValue, layerContractQuantity, minQty)
-		}
-	}
-
-	// 如果有被跳过的金额，重新分配到有效层
-	if skippedValue > 0 {
-		validLayers := 0
-		for i := 0; i < len(layers); i++ {
-			if !layers[i].skip {
-				validLayers++
-			}
-		}
-
-		if validLayers > 0 {
-			// 将跳过的价值平均分配到有效层
-			additionalValuePerLayer := skippedValue / float64(validLayers)
-			// 减少重新分配日志
-			// log.Printf("将 %.2f USDT 价值重新分配到 %d 个有效层，每层增加 %.2f USDT",
-			// 	skippedValue, validLayers, additionalValuePerLayer)
-
-			for i := 0; i < len(layers); i++ {
-				if !layers[i].skip {
-					// 增加价值
-					newValue := layers[i].value + additionalValuePerLayer
-					// 重新计算合约数量
-					newContractQty := newValue / layers[i].price
-					// 调整为 step size 的整数倍
-					if stepSize > 0 {
-						newContractQty = math.Floor(newContractQty/stepSize) * stepSize
-					}
-					layers[i].quantity = newContractQty
-					layers[i].value = newValue
-				}
-			}
-		}
-	}
+	// 先计算所有层的价格和数量，数量太小的层会被跳过
+	layers := planIcebergLayers(strategy, basePrice, quantities, priceGaps, filters)
 
 	// 第二遍：创建订单
 	for i := 0; i < len(layers); i++ {
@@ -984,9 +895,15 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 			continue
 		}
 
+		// 下单前按交易规则校验，不符合的层跳过
+		if err := filters.Validate(string(side), layers[i].price, layers[i].quantity, basePrice, false); err != nil {
+			log.Printf("冰山策略 %d 第%d层不符合交易规则，跳过: %v", strategy.ID, i+1, err)
+			continue
+		}
+
 		// 格式化数量和价格
-		formattedQuantity := fmt.Sprintf(quantityFormat, layers[i].quantity)
-		formattedPrice := fmt.Sprintf(priceFormat, layers[i].price)
+		formattedQuantity := filters.FormatQuantity(layers[i].quantity)
+		formattedPrice := filters.FormatPrice(layers[i].price)
 
 		// 计算该层使用的本金
 		layerMargin := layers[i].value / float64(strategy.Leverage)
@@ -995,17 +912,25 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 		log.Printf("冰山策略 %d 第%d层 - 价格: %s, 数量: %s (本金: %.2f USDT)",
 			strategy.ID, i+1, formattedPrice, formattedQuantity, layerMargin)
 
+		orderPrice, orderQty, err := parseFuturesOrderValues(formattedPrice, formattedQuantity)
+		if err != nil {
+			log.Printf("冰山策略 %d 第%d层参数无效，跳过: %v", strategy.ID, i+1, err)
+			continue
+		}
+
 		// 创建限价订单
-		orderService := client.NewCreateOrderService().
-			Symbol(strategy.Symbol).
-			Side(side).
-			PositionSide(futures.PositionSideType(strategy.Side)).
-			Type(futures.OrderTypeLimit).
-			TimeInForce(futures.TimeInForceTypeGTC).
-			Quantity(formattedQuantity).
-			Price(formattedPrice)
-
-		order, err := orderService.Do(context.Background())
+		orderReq := services.FuturesOrderRequest{
+			Symbol:        strategy.Symbol,
+			Side:          side,
+			PositionSide:  futures.PositionSideType(strategy.Side),
+			Type:          futures.OrderTypeLimit,
+			TimeInForce:   futures.TimeInForceTypeGTC,
+			Quantity:      formattedQuantity,
+			Price:         formattedPrice,
+			ClientOrderID: entryClientOrderID(strategy.ID),
+		}
+
+		order, err := exchange.CreateFuturesOrder(context.Background(), orderReq)
 		if err != nil {
 			log.Printf("创建第%d层订单失败: %v", i+1, err)
 			// 如果是第一个有效订单就失败，取消整个策略
@@ -1019,6 +944,14 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 
 		successfulOrders = append(successfulOrders, order.OrderID)
 
+		// 每挂出一层就更新执行状态，中断时已挂出的订单都能被恢复
+		if exec == nil {
+			exec = startFuturesExecution(m.cfg, strategy, order.OrderID)
+		} else {
+			exec.OrderIDs = joinOrderIDs(successfulOrders)
+			saveFuturesExecution(m.cfg, exec)
+		}
+
 		// 累计加权价格用于计算平均开仓价
 		weightedPriceSum += layers[i].price * layers[i].quantity
 		totalExecutedQuantity += layers[i].quantity
@@ -1026,16 +959,18 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 		// 保存订单记录
 		dbOrder := models.FuturesOrder{
 			UserID:       strategy.UserID,
+			AccountID:    strategy.AccountID,
 			StrategyID:   strategy.ID,
 			Symbol:       strategy.Symbol,
 			Side:         string(side),
 			PositionSide: strategy.Side,
 			Type:         "LIMIT",
-			Price:        layers[i].price,
-			Quantity:     layers[i].quantity,
+			Price:        orderPrice,
+			Quantity:     orderQty,
 			OrderID:      order.OrderID,
 			Status:       string(order.Status),
 			OrderPurpose: "entry",
+			Paper:        services.IsPaperExchange(exchange),
 		}
 
 		if err := m.cfg.DB.Create(&dbOrder).Error; err != nil {
@@ -1051,7 +986,7 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 	// 计算并更新预估的平均开仓价格
 	if totalExecutedQuantity > 0 {
 		avgEntryPrice := weightedPriceSum / totalExecutedQuantity
-		strategy.EntryPrice = avgEntryPrice
+		strategy.EntryPrice = decimal.NewFromFloat(avgEntryPrice)
 		strategy.CalculateTakeProfitPrice()
 		strategy.CalculateStopLossPrice()
 		m.cfg.DB.Save(strategy)
@@ -1060,13 +995,36 @@ func (m *FuturesWebSocketManager) executeIcebergStrategy(strategy *models.Future
 	log.Printf("期货冰山策略 %d 开仓订单创建完成，共%d层成功", strategy.ID, len(successfulOrders))
 
 	// 启动订单监控
-	go monitorIcebergOrders(m.cfg, strategy, successfulOrders)
+	spawnTask(m.ctx, "冰山订单监控", func() { monitorIcebergOrders(m.ctx, m.cfg, strategy, exec) })
 }
 
 // monitorSlowIcebergOrders 监控慢冰山订单（移除重试上限，优化错误处理）
-func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
-	currentOrderID int64, currentLayer int, quantities []float64, priceGaps []float64,
-	pricePrecision int, quantityPrecision int, tickSize float64, stepSize float64, minQty float64) {
+// 当前层、订单和层超时起点从执行状态读取，换层和重新挂单时写回
+func monitorSlowIcebergOrders(ctx context.Context, cfg *config.Config, strategy *models.FuturesStrategy,
+	exec *models.FuturesExecution, quantities []float64, priceGaps []float64, filters *services.SymbolFilters) {
+
+	orderIDs := parseOrderIDs(exec.OrderIDs)
+	if len(orderIDs) == 0 || exec.Layer >= len(quantities) || len(priceGaps) != len(quantities) {
+		log.Printf("慢冰山策略 %d 执行状态无效，停止监控", strategy.ID)
+		finishFuturesExecution(cfg, exec)
+		return
+	}
+	currentOrderID, currentLayer := orderIDs[0], exec.Layer
+	tickSize, stepSize := filters.TickSize, filters.StepSize
+
+	// advanceLayer 记录进入下一层，下一层订单挂出后立即调用，避免中断后重复挂单
+	advanceLayer := func(orderID int64) {
+		exec.Layer = currentLayer + 1
+		exec.OrderIDs = joinOrderIDs([]int64{orderID})
+		exec.LayerStartedAt = time.Now()
+		saveFuturesExecution(cfg, exec)
+	}
+	// watchNextLayer 由新的监控继续处理下一层
+	watchNextLayer := func() {
+		spawnTask(ctx, "慢冰山订单监控", func() {
+			monitorSlowIcebergOrders(ctx, cfg, strategy, exec, quantities, priceGaps, filters)
+		})
+	}
 
 	// 获取用户信息
 	var user models.User
@@ -1074,33 +1032,27 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
 	if err != nil {
 		return
 	}
 
-	client := binance.NewFuturesClient(apiKey, secretKey)
-
-	// 定期检查订单状态
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	// 用户数据流推送订单更新时立即检查，数据流不可用时每2秒轮询
+	waiter := newFuturesOrderWaiter(user.ID, strategy.AccountID, services.IsPaperExchange(exchange), currentOrderID)
+	defer waiter.Stop()
 
 	// 获取策略配置的超时时间
 	layerTimeout := time.Duration(strategy.SlowIcebergTimeout) * time.Minute
-	layerStartTime := time.Now()
+	layerStartTime := exec.LayerStartedAt
 
 	for {
 		select {
-		case <-ticker.C:
-			order, err := client.NewGetOrderService().
-				Symbol(strategy.Symbol).
-				OrderID(currentOrderID).
-				Do(context.Background())
+		case <-ctx.Done():
+			// 订单保留在交易所，下次启动时继续处理
+			log.Printf("慢冰山策略 %d 第 %d 层订单监控中断，服务停止", strategy.ID, currentLayer+1)
+			return
+		case <-waiter.C:
+			order, err := exchange.GetFuturesOrder(context.Background(), strategy.Symbol, currentOrderID)
 
 			if err != nil {
 				log.Printf("查询慢冰山订单状态失败: %v", err)
@@ -1108,8 +1060,8 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 			}
 
 			// 更新订单状态
-			execQty, _ := strconv.ParseFloat(order.ExecutedQuantity, 64)
-			avgPrice, _ := strconv.ParseFloat(order.AvgPrice, 64)
+			execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
+			avgPrice, _ := decimal.NewFromString(order.AvgPrice)
 
 			cfg.DB.Model(&models.FuturesOrder{}).
 				Where("order_id = ?", currentOrderID).
@@ -1121,25 +1073,21 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 
 			// 检查订单是否成交
 			if order.Status == futures.OrderStatusTypeFilled {
-				log.Printf("慢冰山第%d层订单成交: 策略ID=%d, OrderID=%d, AvgPrice=%.8f",
+				log.Printf("慢冰山第%d层订单成交: 策略ID=%d, OrderID=%d, AvgPrice=%s",
 					currentLayer+1, strategy.ID, currentOrderID, avgPrice)
 
-				// 立即为当前层创建平仓订单
-				createLayerTakeProfitOrder(cfg, client, strategy, execQty, avgPrice, currentLayer)
-				if strategy.StopLossRate > 0 {
-					createLayerStopLossOrder(cfg, client, strategy, execQty, avgPrice, currentLayer)
+				// 先记录该订单已处理，再为当前层创建平仓订单并更新持仓；
+				// 获取深度失败重试或中断恢复时再次看到该成交，不会重复挂止盈止损
+				if exec.HandledOrderID != currentOrderID {
+					exec.HandledOrderID = currentOrderID
+					saveFuturesExecution(cfg, exec)
+					applyEntryFill(cfg, exchange, strategy, currentOrderID, execQty, avgPrice, currentLayer)
 				}
 
-				// 创建或更新持仓记录
-				updateOrCreatePosition(cfg, strategy, avgPrice, execQty, currentOrderID)
-
 				// 检查是否还有下一层
 				if currentLayer+1 < len(quantities) {
 					// 获取最新的市场深度
-					depth, depthErr := client.NewDepthService().
-						Symbol(strategy.Symbol).
-						Limit(20).
-						Do(context.Background())
+					depth, depthErr := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 20)
 
 					if depthErr != nil {
 						log.Printf("获取深度失败: %v", depthErr)
@@ -1164,82 +1112,61 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 						continue
 					}
 
-					// 计算下一层的价格和数量
-					nextLayerPrice := basePrice * (1 + priceGaps[currentLayer+1]/10000)
-
-					// 应用开仓价格浮动并避免吃单
-					if strategy.Side == "LONG" {
-						if strategy.EntryPriceFloat > 0 {
-							nextLayerPrice = nextLayerPrice * (1 - strategy.EntryPriceFloat/10000)
-						} else {
-							nextLayerPrice = nextLayerPrice - tickSize
-						}
-						if nextLayerPrice >= basePrice {
-							nextLayerPrice = basePrice - tickSize
-						}
-					} else {
-						if strategy.EntryPriceFloat > 0 {
-							nextLayerPrice = nextLayerPrice * (1 + strategy.EntryPriceFloat/10000)
-						} else {
-							nextLayerPrice = nextLayerPrice + tickSize
-						}
-						if nextLayerPrice <= basePrice {
-							nextLayerPrice = basePrice + tickSize
-						}
-					}
-
-					// 将价格调整为 tick size 的整数倍
-					if tickSize > 0 {
-						nextLayerPrice = math.Round(nextLayerPrice/tickSize) * tickSize
-					}
+					// 计算下一层的价格和数量（应用开仓价格浮动并避免吃单）
+					nextLayerPrice := makerEntryPrice(strategy, basePrice, priceGaps[currentLayer+1], tickSize)
 
 					// 计算下一层的数量
-					totalOrderValue := strategy.Quantity * float64(strategy.Leverage)
+					totalOrderValue := strategy.OrderValue().InexactFloat64()
 					nextLayerValue := totalOrderValue * quantities[currentLayer+1]
 					nextLayerQuantity := nextLayerValue / nextLayerPrice
 
 					// 将数量调整为 step size 的整数倍
 					if stepSize > 0 {
-						nextLayerQuantity = math.Floor(nextLayerQuantity/stepSize) * stepSize
+						nextLayerQuantity = services.RoundToStep(nextLayerQuantity, stepSize, services.RoundDown)
 					}
 
-					// 检查数量是否满足最小要求
-					if nextLayerQuantity < minQty {
-						log.Printf("第%d层数量 %.8f 小于最小数量 %.8f，跳过",
-							currentLayer+2, nextLayerQuantity, minQty)
+					// 按交易规则校验数量和名义价值，不符合时跳过该层
+					side := futures.SideTypeBuy
+					if strategy.Side == "SHORT" {
+						side = futures.SideTypeSell
+					}
+					if err := filters.Validate(string(side), nextLayerPrice, nextLayerQuantity, basePrice, false); err != nil {
+						log.Printf("第%d层订单不符合交易规则，跳过: %v", currentLayer+2, err)
 						// 继续处理下一层
 						if currentLayer+2 < len(quantities) {
-							go monitorSlowIcebergOrders(cfg, strategy, currentOrderID, currentLayer+1,
-								quantities, priceGaps, pricePrecision, quantityPrecision,
-								tickSize, stepSize, minQty)
+							advanceLayer(currentOrderID)
+							watchNextLayer()
+						} else {
+							finishFuturesExecution(cfg, exec)
 						}
 						return
 					}
 
 					// 格式化数量和价格
-					quantityFormat := fmt.Sprintf("%%.%df", quantityPrecision)
-					priceFormat := fmt.Sprintf("%%.%df", pricePrecision)
-					formattedQuantity := fmt.Sprintf(quantityFormat, nextLayerQuantity)
-					formattedPrice := fmt.Sprintf(priceFormat, nextLayerPrice)
+					formattedQuantity := filters.FormatQuantity(nextLayerQuantity)
+					formattedPrice := filters.FormatPrice(nextLayerPrice)
 
 					log.Printf("慢冰山策略 %d 第%d层 - 价格: %s, 数量: %s",
 						strategy.ID, currentLayer+2, formattedPrice, formattedQuantity)
 
-					// 创建下一层订单
-					side := futures.SideTypeBuy
-					if strategy.Side == "SHORT" {
-						side = futures.SideTypeSell
+					orderPrice, orderQty, err := parseFuturesOrderValues(formattedPrice, formattedQuantity)
+					if err != nil {
+						log.Printf("慢冰山策略 %d 第%d层参数无效，稍后重试: %v", strategy.ID, currentLayer+2, err)
+						continue
 					}
 
-					nextOrder, nextErr := client.NewCreateOrderService().
-						Symbol(strategy.Symbol).
-						Side(side).
-						PositionSide(futures.PositionSideType(strategy.Side)).
-						Type(futures.OrderTypeLimit).
-						TimeInForce(futures.TimeInForceTypeGTC).
-						Quantity(formattedQuantity).
-						Price(formattedPrice).
-						Do(context.Background())
+					// 创建下一层订单
+
+					nextOrder, nextErr := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+						Symbol:        strategy.Symbol,
+						Side:          side,
+						PositionSide:  futures.PositionSideType(strategy.Side),
+						Type:          futures.OrderTypeLimit,
+						TimeInForce:   futures.TimeInForceTypeGTC,
+						Quantity:      formattedQuantity,
+						Price:         formattedPrice,
+						ClientOrderID: entryClientOrderID(strategy.ID),
+					})
 
 					if nextErr != nil {
 						log.Printf("创建慢冰山第%d层订单失败: %v", currentLayer+2, nextErr)
@@ -1247,20 +1174,23 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 						time.Sleep(5 * time.Second)
 						continue
 					}
+					advanceLayer(nextOrder.OrderID)
 
 					// 保存订单记录
 					dbOrder := models.FuturesOrder{
 						UserID:       strategy.UserID,
+						AccountID:    strategy.AccountID,
 						StrategyID:   strategy.ID,
 						Symbol:       strategy.Symbol,
 						Side:         string(side),
 						PositionSide: strategy.Side,
 						Type:         "LIMIT",
-						Price:        nextLayerPrice,
-						Quantity:     nextLayerQuantity,
+						Price:        orderPrice,
+						Quantity:     orderQty,
 						OrderID:      nextOrder.OrderID,
 						Status:       string(nextOrder.Status),
 						OrderPurpose: "entry",
+						Paper:        services.IsPaperExchange(exchange),
 					}
 
 					if err := cfg.DB.Create(&dbOrder).Error; err != nil {
@@ -1268,16 +1198,16 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 					}
 
 					// 递归监控下一层
-					go monitorSlowIcebergOrders(cfg, strategy, nextOrder.OrderID, currentLayer+1,
-						quantities, priceGaps, pricePrecision, quantityPrecision,
-						tickSize, stepSize, minQty)
+					watchNextLayer()
 				} else {
 					// 所有层都已完成
 					log.Printf("慢冰山策略 %d 所有层完成", strategy.ID)
 
 					// 更新策略状态
 					strategy.Status = "position_opened"
-					cfg.DB.Save(strategy)
+					cfg.DB.Omit(trailingStateColumns...).Save(strategy)
+					publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
+					finishFuturesExecution(cfg, exec)
 				}
 
 				return
@@ -1301,6 +1231,7 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 						strategy.Status = "waiting"
 						strategy.TriggeredAt = nil
 						cfg.DB.Save(strategy)
+						finishFuturesExecution(cfg, exec)
 						return
 					}
 				}
@@ -1312,6 +1243,7 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 				// 重新获取市场深度并创建订单
 				// ... (这里可以复用上面创建下一层订单的逻辑)
 
+				finishFuturesExecution(cfg, exec)
 				return
 			}
 
@@ -1321,20 +1253,14 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 					currentLayer+1, currentOrderID)
 
 				// 撤销当前订单
-				_, cancelErr := client.NewCancelOrderService().
-					Symbol(strategy.Symbol).
-					OrderID(currentOrderID).
-					Do(context.Background())
+				cancelErr := exchange.CancelFuturesOrder(context.Background(), strategy.Symbol, currentOrderID)
 				if cancelErr != nil {
 					log.Printf("撤销订单失败: %v", cancelErr)
 					continue
 				}
 
 				// 获取最新的市场深度
-				depth, depthErr := client.NewDepthService().
-					Symbol(strategy.Symbol).
-					Limit(20).
-					Do(context.Background())
+				depth, depthErr := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 20)
 
 				if depthErr != nil {
 					log.Printf("获取深度失败: %v", depthErr)
@@ -1358,111 +1284,96 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 					continue
 				}
 
-				// 重新计算当前层的价格
-				newLayerPrice := basePrice * (1 + priceGaps[currentLayer]/10000)
-
-				// 应用开仓价格浮动并避免吃单
-				if strategy.Side == "LONG" {
-					if strategy.EntryPriceFloat > 0 {
-						newLayerPrice = newLayerPrice * (1 - strategy.EntryPriceFloat/10000)
-					} else {
-						newLayerPrice = newLayerPrice - tickSize
-					}
-					if newLayerPrice >= basePrice {
-						newLayerPrice = basePrice - tickSize
-					}
-				} else {
-					if strategy.EntryPriceFloat > 0 {
-						newLayerPrice = newLayerPrice * (1 + strategy.EntryPriceFloat/10000)
-					} else {
-						newLayerPrice = newLayerPrice + tickSize
-					}
-					if newLayerPrice <= basePrice {
-						newLayerPrice = basePrice + tickSize
-					}
-				}
-
-				// 将价格调整为 tick size 的整数倍
-				if tickSize > 0 {
-					newLayerPrice = math.Round(newLayerPrice/tickSize) * tickSize
-				}
+				// 重新计算当前层的价格（应用开仓价格浮动并避免吃单）
+				newLayerPrice := makerEntryPrice(strategy, basePrice, priceGaps[currentLayer], tickSize)
 
 				// 重新计算当前层的数量
-				totalOrderValue := strategy.Quantity * float64(strategy.Leverage)
+				totalOrderValue := strategy.OrderValue().InexactFloat64()
 				currentLayerValue := totalOrderValue * quantities[currentLayer]
 				currentLayerQuantity := currentLayerValue / newLayerPrice
 
 				// 将数量调整为 step size 的整数倍
 				if stepSize > 0 {
-					currentLayerQuantity = math.Floor(currentLayerQuantity/stepSize) * stepSize
+					currentLayerQuantity = services.RoundToStep(currentLayerQuantity, stepSize, services.RoundDown)
 				}
 
-				// 检查数量是否满足最小要求
-				if currentLayerQuantity < minQty {
-					log.Printf("重新计算的数量 %.8f 小于最小数量 %.8f，跳过当前层",
-						currentLayerQuantity, minQty)
+				// 按交易规则校验数量和名义价值，不符合时跳过当前层
+				side := futures.SideTypeBuy
+				if strategy.Side == "SHORT" {
+					side = futures.SideTypeSell
+				}
+				if err := filters.Validate(string(side), newLayerPrice, currentLayerQuantity, basePrice, false); err != nil {
+					log.Printf("重新计算的第%d层订单不符合交易规则，跳过当前层: %v", currentLayer+1, err)
 					// 如果还有下一层，继续处理
 					if currentLayer+1 < len(quantities) {
-						go monitorSlowIcebergOrders(cfg, strategy, currentOrderID, currentLayer+1,
-							quantities, priceGaps, pricePrecision, quantityPrecision,
-							tickSize, stepSize, minQty)
+						advanceLayer(currentOrderID)
+						watchNextLayer()
+					} else {
+						finishFuturesExecution(cfg, exec)
 					}
 					return
 				}
 
 				// 格式化数量和价格
-				quantityFormat := fmt.Sprintf("%%.%df", quantityPrecision)
-				priceFormat := fmt.Sprintf("%%.%df", pricePrecision)
-				formattedQuantity := fmt.Sprintf(quantityFormat, currentLayerQuantity)
-				formattedPrice := fmt.Sprintf(priceFormat, newLayerPrice)
+				formattedQuantity := filters.FormatQuantity(currentLayerQuantity)
+				formattedPrice := filters.FormatPrice(newLayerPrice)
 
 				log.Printf("慢冰山策略 %d 第%d层重新挂单 - 新价格: %s, 数量: %s",
 					strategy.ID, currentLayer+1, formattedPrice, formattedQuantity)
 
-				// 创建新订单
-				side := futures.SideTypeBuy
-				if strategy.Side == "SHORT" {
-					side = futures.SideTypeSell
+				orderPrice, orderQty, err := parseFuturesOrderValues(formattedPrice, formattedQuantity)
+				if err != nil {
+					log.Printf("慢冰山策略 %d 第%d层重新挂单参数无效: %v", strategy.ID, currentLayer+1, err)
+					continue
 				}
 
-				newOrder, newErr := client.NewCreateOrderService().
-					Symbol(strategy.Symbol).
-					Side(side).
-					PositionSide(futures.PositionSideType(strategy.Side)).
-					Type(futures.OrderTypeLimit).
-					TimeInForce(futures.TimeInForceTypeGTC).
-					Quantity(formattedQuantity).
-					Price(formattedPrice).
-					Do(context.Background())
+				// 创建新订单
+
+				newOrder, newErr := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+					Symbol:        strategy.Symbol,
+					Side:          side,
+					PositionSide:  futures.PositionSideType(strategy.Side),
+					Type:          futures.OrderTypeLimit,
+					TimeInForce:   futures.TimeInForceTypeGTC,
+					Quantity:      formattedQuantity,
+					Price:         formattedPrice,
+					ClientOrderID: entryClientOrderID(strategy.ID),
+				})
 
 				if newErr != nil {
 					log.Printf("重新创建慢冰山第%d层订单失败: %v", currentLayer+1, newErr)
 					continue
 				}
 
+				// 更新当前订单ID并重置超时计时器，挂出后立即写入执行状态
+				currentOrderID = newOrder.OrderID
+				layerStartTime = time.Now()
+				exec.OrderIDs = joinOrderIDs([]int64{currentOrderID})
+				exec.LayerStartedAt = layerStartTime
+				saveFuturesExecution(cfg, exec)
+				waiter.Watch(currentOrderID)
+
 				// 保存新订单记录
 				dbOrder := models.FuturesOrder{
 					UserID:       strategy.UserID,
+					AccountID:    strategy.AccountID,
 					StrategyID:   strategy.ID,
 					Symbol:       strategy.Symbol,
 					Side:         string(side),
 					PositionSide: strategy.Side,
 					Type:         "LIMIT",
-					Price:        newLayerPrice,
-					Quantity:     currentLayerQuantity,
+					Price:        orderPrice,
+					Quantity:     orderQty,
 					OrderID:      newOrder.OrderID,
 					Status:       string(newOrder.Status),
 					OrderPurpose: "entry",
+					Paper:        services.IsPaperExchange(exchange),
 				}
 
 				if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 					log.Printf("保存订单记录失败: %v", err)
 				}
 
-				// 更新当前订单ID并重置超时计时器
-				currentOrderID = newOrder.OrderID
-				layerStartTime = time.Now()
-
 				log.Printf("慢冰山策略 %d 第%d层重新挂单成功: OrderID=%d",
 					strategy.ID, currentLayer+1, newOrder.OrderID)
 			}
@@ -1470,45 +1381,62 @@ func monitorSlowIcebergOrders(cfg *config.Config, strategy *models.FuturesStrate
 	}
 }
 
-// monitorIcebergOrders 监控冰山订单
-func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy, orderIDs []int64) {
+// monitorIcebergOrders 监控冰山订单，已处理的订单和成交累计从执行状态恢复
+func monitorIcebergOrders(ctx context.Context, cfg *config.Config, strategy *models.FuturesStrategy, exec *models.FuturesExecution) {
 	// 获取用户信息
 	var user models.User
 	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
 		return
 	}
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
 	if err != nil {
 		return
 	}
 
-	client := binance.NewFuturesClient(apiKey, secretKey)
+	orderIDs := parseOrderIDs(exec.OrderIDs)
 
-	// 定期检查订单状态
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	// 用户数据流推送订单更新时立即检查，数据流不可用时每2秒轮询
+	waiter := newFuturesOrderWaiter(user.ID, strategy.AccountID, services.IsPaperExchange(exchange), orderIDs...)
+	defer waiter.Stop()
 
-	timeout := time.After(10 * time.Minute) // 10分钟超时
+	timeout := time.After(time.Until(exec.StartedAt.Add(cfg.Tasks.FuturesEntryTimeout)))
 
 	filledOrders := make(map[int64]bool)
-	var totalFilledQuantity float64
-	var weightedPriceSum float64
+	for _, orderID := range parseOrderIDs(exec.DoneOrderIDs) {
+		filledOrders[orderID] = true
+	}
+	totalFilledQuantity := exec.FilledQty
+	weightedPriceSum := exec.WeightedPriceSum
+
+	// markDone 订单处理完成后写入执行状态
+	markDone := func(orderID int64) {
+		filledOrders[orderID] = true
+		var done []int64
+		for _, id := range orderIDs {
+			if filledOrders[id] {
+				done = append(done, id)
+			}
+		}
+		exec.DoneOrderIDs = joinOrderIDs(done)
+		exec.FilledQty = totalFilledQuantity
+		exec.WeightedPriceSum = weightedPriceSum
+		saveFuturesExecution(cfg, exec)
+	}
 
 	// 跟踪每个订单的成交情况
 	orderDetails := make(map[int64]struct {
-		price    float64
-		quantity float64
+		price    decimal.Decimal
+		quantity decimal.Decimal
 		filled   bool
 	})
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ctx.Done():
+			// 订单保留在交易所，下次启动时继续处理
+			log.Printf("冰山策略 %d 订单监控中断，服务停止", strategy.ID)
+			return
+		case <-waiter.C:
 			allFilled := true
 
 			for _, orderID := range orderIDs {
@@ -1516,10 +1444,7 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 					continue // 已成交的订单跳过
 				}
 
-				order, err := client.NewGetOrderService().
-					Symbol(strategy.Symbol).
-					OrderID(orderID).
-					Do(context.Background())
+				order, err := exchange.GetFuturesOrder(context.Background(), strategy.Symbol, orderID)
 
 				if err != nil {
 					log.Printf("查询订单状态失败: %v", err)
@@ -1527,8 +1452,8 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 				}
 
 				// 更新订单状态
-				execQty, _ := strconv.ParseFloat(order.ExecutedQuantity, 64)
-				avgPrice, _ := strconv.ParseFloat(order.AvgPrice, 64)
+				execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
+				avgPrice, _ := decimal.NewFromString(order.AvgPrice)
 
 				cfg.DB.Model(&models.FuturesOrder{}).
 					Where("order_id = ?", orderID).
@@ -1540,14 +1465,13 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 
 				// 检查订单是否成交
 				if order.Status == futures.OrderStatusTypeFilled {
-					filledOrders[orderID] = true
-					totalFilledQuantity += execQty
-					weightedPriceSum += avgPrice * execQty
+					totalFilledQuantity = totalFilledQuantity.Add(execQty)
+					weightedPriceSum = weightedPriceSum.Add(avgPrice.Mul(execQty))
 
 					// 保存订单详情
 					orderDetails[orderID] = struct {
-						price    float64
-						quantity float64
+						price    decimal.Decimal
+						quantity decimal.Decimal
 						filled   bool
 					}{
 						price:    avgPrice,
@@ -1555,22 +1479,17 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 						filled:   true,
 					}
 
-					log.Printf("冰山订单成交: 策略ID=%d, OrderID=%d, AvgPrice=%.8f", strategy.ID, orderID, avgPrice)
-
-					// 立即为该订单创建平仓订单
-					createLayerTakeProfitOrder(cfg, client, strategy, execQty, avgPrice, -1) // -1表示普通冰山
-					if strategy.StopLossRate > 0 {
-						createLayerStopLossOrder(cfg, client, strategy, execQty, avgPrice, -1)
-					}
+					log.Printf("冰山订单成交: 策略ID=%d, OrderID=%d, AvgPrice=%s", strategy.ID, orderID, avgPrice)
 
-					// 更新或创建持仓
-					updateOrCreatePosition(cfg, strategy, avgPrice, execQty, orderID)
+					// 先标记为已处理，再为该订单创建平仓订单并更新持仓，-1表示普通冰山
+					markDone(orderID)
+					applyEntryFill(cfg, exchange, strategy, orderID, execQty, avgPrice, -1)
 
 				} else if order.Status == futures.OrderStatusTypeCanceled ||
 					order.Status == futures.OrderStatusTypeExpired ||
 					order.Status == futures.OrderStatusTypeRejected {
-					filledOrders[orderID] = true // 标记为已处理
 					log.Printf("冰山订单失败: OrderID=%d, Status=%s", orderID, order.Status)
+					markDone(orderID) // 标记为已处理
 				} else {
 					allFilled = false
 				}
@@ -1578,14 +1497,14 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 
 			// 如果所有订单都已处理完成
 			if allFilled {
-				if totalFilledQuantity > 0 {
-					avgEntryPrice := weightedPriceSum / totalFilledQuantity
+				if totalFilledQuantity.IsPositive() {
+					avgEntryPrice := weightedPriceSum.Div(totalFilledQuantity)
 
 					// 更新策略的平均开仓价格
 					strategy.EntryPrice = avgEntryPrice
-					cfg.DB.Save(strategy)
+					cfg.DB.Omit(trailingStateColumns...).Save(strategy)
 
-					log.Printf("冰山策略 %d 所有订单处理完成，总数量: %.8f，平均价格: %.8f",
+					log.Printf("冰山策略 %d 所有订单处理完成，总数量: %s，平均价格: %s",
 						strategy.ID, totalFilledQuantity, avgEntryPrice)
 				} else {
 					// 如果没有任何成交，重置策略状态
@@ -1594,6 +1513,7 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 					strategy.TriggeredAt = nil
 					cfg.DB.Save(strategy)
 				}
+				finishFuturesExecution(cfg, exec)
 				return
 			}
 
@@ -1602,10 +1522,7 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 			log.Printf("冰山订单超时，取消未成交订单")
 			for _, orderID := range orderIDs {
 				if !filledOrders[orderID] {
-					_, cancelErr := client.NewCancelOrderService().
-						Symbol(strategy.Symbol).
-						OrderID(orderID).
-						Do(context.Background())
+					cancelErr := exchange.CancelFuturesOrder(context.Background(), strategy.Symbol, orderID)
 					if cancelErr != nil {
 						log.Printf("取消订单失败: %v", cancelErr)
 					}
@@ -1613,12 +1530,12 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 			}
 
 			// 如果有部分成交，保持持仓
-			if totalFilledQuantity > 0 {
-				avgEntryPrice := weightedPriceSum / totalFilledQuantity
+			if totalFilledQuantity.IsPositive() {
+				avgEntryPrice := weightedPriceSum.Div(totalFilledQuantity)
 				strategy.EntryPrice = avgEntryPrice
-				cfg.DB.Save(strategy)
+				cfg.DB.Omit(trailingStateColumns...).Save(strategy)
 
-				log.Printf("冰山策略 %d 部分成交，总数量: %.8f，平均价格: %.8f",
+				log.Printf("冰山策略 %d 部分成交，总数量: %s，平均价格: %s",
 					strategy.ID, totalFilledQuantity, avgEntryPrice)
 			} else {
 				// 完全没有成交，重置策略
@@ -1627,11 +1544,191 @@ func monitorIcebergOrders(cfg *config.Config, strategy *models.FuturesStrategy,
 				cfg.DB.Save(strategy)
 			}
 
+			finishFuturesExecution(cfg, exec)
 			return
 		}
 	}
 }
 
+// makerEntryPrice 计算挂单开仓价格：基准价按万分比间隔偏移，再应用开仓价格浮动，
+// 未设置浮动时默认让出1个tick，并确保不会穿过对手盘第一档（避免吃单）
+func makerEntryPrice(strategy *models.FuturesStrategy, basePrice, priceGap, tickSize float64) float64 {
+	price := basePrice * (1 + priceGap/10000)
+
+	if strategy.Side == "LONG" {
+		if strategy.EntryPriceFloat > 0 {
+			price = price * (1 - strategy.EntryPriceFloat/10000)
+		} else {
+			price = price - tickSize
+		}
+		// 确保不会高于卖一价
+		if price >= basePrice {
+			price = basePrice - tickSize
+		}
+	} else {
+		if strategy.EntryPriceFloat > 0 {
+			price = price * (1 + strategy.EntryPriceFloat/10000)
+		} else {
+			price = price + tickSize
+		}
+		// 确保不会低于买一价
+		if price <= basePrice {
+			price = basePrice + tickSize
+		}
+	}
+
+	// 将价格调整为 tick size 的整数倍
+	if tickSize > 0 {
+		price = services.RoundToStep(price, tickSize, services.RoundNearest)
+	}
+	return price
+}
+
+// icebergLayer 冰山策略单层委托
+type icebergLayer struct {
+	price    float64
+	quantity float64
+	value    float64 // 该层的价值（USDT）
+	skip     bool
+}
+
+// planIcebergLayers 计算冰山策略各层的价格和数量，数量小于最小值的层被跳过，其价值平均分配到有效层
+func planIcebergLayers(strategy *models.FuturesStrategy, basePrice float64, quantities, priceGaps []float64,
+	filters *services.SymbolFilters) []icebergLayer {
+
+	tickSize, stepSize, minQty := filters.TickSize, filters.StepSize, filters.MinQty
+
+	// 计算实际开仓价值（本金×杠杆）
+	totalOrderValue := strategy.OrderValue().InexactFloat64()
+
+	layers := make([]icebergLayer, len(quantities))
+	skippedValue := 0.0
+
+	// 第一遍：计算每层信息并标记需要跳过的层
+	for i := 0; i < len(quantities); i++ {
+		// 计算每层的价格
+		layerPrice := basePrice * (1 + priceGaps[i]/10000)
+
+		// 应用开仓价格浮动（万分比）并避免吃单
+		if i == 0 { // 只对第一层应用浮动和避免吃单逻辑
+			if strategy.Side == "LONG" {
+				if strategy.EntryPriceFloat > 0 {
+					layerPrice = layerPrice * (1 - strategy.EntryPriceFloat/10000)
+				} else if priceGaps[i] == 0 {
+					// 第一层价格间隔为0且没有设置浮动时，避免吃单
+					layerPrice = layerPrice - tickSize
+				}
+				// 确保不会高于卖一价
+				if layerPrice >= basePrice {
+					layerPrice = basePrice - tickSize
+				}
+			} else {
+				if strategy.EntryPriceFloat > 0 {
+					layerPrice = layerPrice * (1 + strategy.EntryPriceFloat/10000)
+				} else if priceGaps[i] == 0 {
+					// 第一层价格间隔为0且没有设置浮动时，避免吃单
+					layerPrice = layerPrice + tickSize
+				}
+				// 确保不会低于买一价
+				if layerPrice <= basePrice {
+					layerPrice = basePrice + tickSize
+				}
+			}
+		}
+
+		// 将价格调整为 tick size 的整数倍
+		if tickSize > 0 {
+			layerPrice = services.RoundToStep(layerPrice, tickSize, services.RoundNearest)
+		}
+
+		// 计算每层的价值（按比例分配总价值）
+		layerValue := totalOrderValue * quantities[i]
+
+		// 转换为合约数量
+		layerContractQuantity := layerValue / layerPrice
+
+		// 将数量调整为 step size 的整数倍
+		if stepSize > 0 {
+			layerContractQuantity = services.RoundToStep(layerContractQuantity, stepSize, services.RoundDown)
+		}
+
+		layers[i] = icebergLayer{
+			price:    layerPrice,
+			quantity: layerContractQuantity,
+			value:    layerValue,
+			skip:     layerContractQuantity < minQty,
+		}
+
+		if layers[i].skip {
+			skippedValue += layerValue
+		}
+	}
+
+	// 如果有被跳过的金额，重新分配到有效层
+	if skippedValue > 0 {
+		validLayers := 0
+		for i := 0; i < len(layers); i++ {
+			if !layers[i].skip {
+				validLayers++
+			}
+		}
+
+		if validLayers > 0 {
+			// 将跳过的价值平均分配到有效层
+			additionalValuePerLayer := skippedValue / float64(validLayers)
+
+			for i := 0; i < len(layers); i++ {
+				if !layers[i].skip {
+					// 增加价值
+					newValue := layers[i].value + additionalValuePerLayer
+					// 重新计算合约数量
+					newContractQty := newValue / layers[i].price
+					// 调整为 step size 的整数倍
+					if stepSize > 0 {
+						newContractQty = services.RoundToStep(newContractQty, stepSize, services.RoundDown)
+					}
+					layers[i].quantity = newContractQty
+					layers[i].value = newValue
+				}
+			}
+		}
+	}
+
+	return layers
+}
+
+// layerTakeProfitPrice 根据单层成交价计算止盈价格（目标净收益率加双边手续费，按杠杆折算），未设置止盈时返回0
+func layerTakeProfitPrice(strategy *models.FuturesStrategy, entryPrice float64) float64 {
+	if strategy.TakeProfitRate <= 0 {
+		return 0
+	}
+
+	// 计算实际需要的价格变动率
+	feeRate := 0.0004 * 2 // 双边手续费
+	targetNetProfitRate := strategy.TakeProfitRate / 10000
+	requiredPriceChangeRate := (targetNetProfitRate + feeRate) / float64(strategy.Leverage)
+
+	if strategy.Side == "LONG" {
+		return entryPrice * (1 + requiredPriceChangeRate)
+	}
+	return entryPrice * (1 - requiredPriceChangeRate)
+}
+
+// layerStopLossPrice 根据单层成交价计算止损价格，未设置止损时返回0
+func layerStopLossPrice(strategy *models.FuturesStrategy, entryPrice float64) float64 {
+	if strategy.StopLossRate <= 0 {
+		return 0
+	}
+
+	targetLossRate := strategy.StopLossRate / 10000
+	requiredPriceChangeRate := targetLossRate / float64(strategy.Leverage)
+
+	if strategy.Side == "LONG" {
+		return entryPrice * (1 - requiredPriceChangeRate)
+	}
+	return entryPrice * (1 + requiredPriceChangeRate)
+}
+
 // 辅助函数：解析数量配置
 func parseQuantities(quantitiesStr string) []float64 {
 	if quantitiesStr == "" {
@@ -1665,38 +1762,39 @@ func parsePriceGaps(gapsStr string, side string) []float64 {
 	return gaps
 }
 
-// monitorEntryOrder 监控开仓订单
-func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, orderID int64) {
+// monitorEntryOrder 监控开仓订单，超时从执行状态记录的挂单时间起算
+func monitorEntryOrder(ctx context.Context, cfg *config.Config, strategy *models.FuturesStrategy, exec *models.FuturesExecution) {
+	orderIDs := parseOrderIDs(exec.OrderIDs)
+	if len(orderIDs) == 0 {
+		finishFuturesExecution(cfg, exec)
+		return
+	}
+	orderID := orderIDs[0]
+
 	// 获取用户信息
 	var user models.User
 	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
 		return
 	}
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
 	if err != nil {
 		return
 	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		return
-	}
-
-	client := binance.NewFuturesClient(apiKey, secretKey)
 
-	// 定期检查订单状态
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	// 用户数据流推送订单更新时立即检查，数据流不可用时每2秒轮询
+	waiter := newFuturesOrderWaiter(user.ID, strategy.AccountID, services.IsPaperExchange(exchange), orderID)
+	defer waiter.Stop()
 
-	timeout := time.After(10 * time.Minute) // 10分钟超时
+	timeout := time.After(time.Until(exec.StartedAt.Add(cfg.Tasks.FuturesEntryTimeout)))
 
 	for {
 		select {
-		case <-ticker.C:
-			order, err := client.NewGetOrderService().
-				Symbol(strategy.Symbol).
-				OrderID(orderID).
-				Do(context.Background())
+		case <-ctx.Done():
+			// 订单保留在交易所，下次启动时继续处理
+			log.Printf("策略 %d 开仓订单监控中断，服务停止", strategy.ID)
+			return
+		case <-waiter.C:
+			order, err := exchange.GetFuturesOrder(context.Background(), strategy.Symbol, orderID)
 
 			if err != nil {
 				log.Printf("查询订单状态失败: %v", err)
@@ -1714,14 +1812,23 @@ func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, ord
 
 			// 检查订单是否成交
 			if order.Status == futures.OrderStatusTypeFilled {
+				if exec.HandledOrderID == orderID {
+					// 上次已处理该成交，中断发生在处理过程中，按持仓收尾
+					finishFuturesExecution(cfg, exec)
+					finalizeInterruptedStrategy(cfg, strategy)
+					return
+				}
 				log.Printf("开仓订单成交: 策略ID=%d, OrderID=%d", strategy.ID, orderID)
+				exec.HandledOrderID = orderID
+				saveFuturesExecution(cfg, exec)
 
 				// 创建持仓记录
-				avgPrice, _ := strconv.ParseFloat(order.AvgPrice, 64)
-				execQty, _ := strconv.ParseFloat(order.ExecutedQuantity, 64)
+				avgPrice, _ := decimal.NewFromString(order.AvgPrice)
+				execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
 
 				position := models.FuturesPosition{
 					UserID:       strategy.UserID,
+					AccountID:    strategy.AccountID,
 					StrategyID:   strategy.ID,
 					Symbol:       strategy.Symbol,
 					PositionSide: strategy.Side,
@@ -1731,6 +1838,7 @@ func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, ord
 					MarginType:   strategy.MarginType,
 					Status:       "open",
 					OpenedAt:     time.Now(),
+					Paper:        services.IsPaperExchange(exchange),
 				}
 
 				cfg.DB.Create(&position)
@@ -1739,15 +1847,22 @@ func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, ord
 				strategy.Status = "position_opened"
 				strategy.CurrentPositionId = orderID
 				cfg.DB.Save(strategy)
+				publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
 
-				// 立即创建止盈订单
-				createTakeProfitOrder(cfg, client, strategy, execQty)
+				// 立即创建止盈订单（跟踪止盈替代固定止盈）
+				if !strategy.TrailingEnabled() || !strategy.TrailingTakeProfit {
+					createTakeProfitOrder(cfg, exchange, strategy, execQty)
+				}
 
 				// 如果设置了止损，创建止损订单
 				if strategy.StopLossRate > 0 {
-					createStopLossOrder(cfg, client, strategy, execQty)
+					createStopLossOrder(cfg, exchange, strategy, execQty)
 				}
 
+				// 如果开启了跟踪止损，挂出跟踪止损单
+				syncTrailingStop(cfg, exchange, strategy)
+
+				finishFuturesExecution(cfg, exec)
 				return
 			} else if order.Status == futures.OrderStatusTypeCanceled ||
 				order.Status == futures.OrderStatusTypeExpired ||
@@ -1760,16 +1875,14 @@ func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, ord
 				strategy.Status = "waiting"
 				strategy.TriggeredAt = nil
 				cfg.DB.Save(strategy)
+				finishFuturesExecution(cfg, exec)
 				return
 			}
 
 		case <-timeout:
 			// 超时取消订单
 			log.Printf("开仓订单超时，取消订单: OrderID=%d", orderID)
-			_, cancelErr := client.NewCancelOrderService().
-				Symbol(strategy.Symbol).
-				OrderID(orderID).
-				Do(context.Background())
+			cancelErr := exchange.CancelFuturesOrder(context.Background(), strategy.Symbol, orderID)
 			if cancelErr != nil {
 				log.Printf("取消订单失败: %v", cancelErr)
 			}
@@ -1778,29 +1891,19 @@ func monitorEntryOrder(cfg *config.Config, strategy *models.FuturesStrategy, ord
 			strategy.Status = "waiting"
 			strategy.TriggeredAt = nil
 			cfg.DB.Save(strategy)
+			finishFuturesExecution(cfg, exec)
 			return
 		}
 	}
 }
 
 // createLayerTakeProfitOrder 为单层创建止盈订单
-func createLayerTakeProfitOrder(cfg *config.Config, client *futures.Client,
-	strategy *models.FuturesStrategy, quantity float64, entryPrice float64, layerIndex int) {
+func createLayerTakeProfitOrder(cfg *config.Config, exchange services.Exchange,
+	strategy *models.FuturesStrategy, quantity, entryPrice decimal.Decimal, layerIndex int) {
 
 	// 计算止盈价格（基于该层的实际成交价）
-	var takeProfitPrice float64
-	if strategy.TakeProfitRate > 0 {
-		// 计算实际需要的价格变动率
-		feeRate := 0.0004 * 2 // 双边手续费
-		targetNetProfitRate := strategy.TakeProfitRate / 10000
-		requiredPriceChangeRate := (targetNetProfitRate + feeRate) / float64(strategy.Leverage)
-
-		if strategy.Side == "LONG" {
-			takeProfitPrice = entryPrice * (1 + requiredPriceChangeRate)
-		} else {
-			takeProfitPrice = entryPrice * (1 - requiredPriceChangeRate)
-		}
-	} else {
+	takeProfitPrice := layerTakeProfitPrice(strategy, entryPrice.InexactFloat64())
+	if takeProfitPrice <= 0 {
 		return // 没有设置止盈
 	}
 
@@ -1810,15 +1913,21 @@ func createLayerTakeProfitOrder(cfg *config.Config, client *futures.Client,
 		side = futures.SideTypeBuy
 	}
 
-	order, err := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeLimit).
-		TimeInForce(futures.TimeInForceTypeGTC).
-		Quantity(fmt.Sprintf("%.8f", quantity)).
-		Price(fmt.Sprintf("%.8f", takeProfitPrice)).
-		Do(context.Background())
+	priceStr, quantityStr := futuresOrderStrings(exchange, strategy.Symbol, decimal.NewFromFloat(takeProfitPrice), quantity)
+	orderPrice, orderQty, err := parseFuturesOrderValues(priceStr, quantityStr)
+	if err != nil {
+		log.Printf("创建第%d层止盈订单失败: %v", layerIndex+1, err)
+		return
+	}
+	order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeLimit,
+		TimeInForce:  futures.TimeInForceTypeGTC,
+		Quantity:     quantityStr,
+		Price:        priceStr,
+	})
 
 	if err != nil {
 		log.Printf("创建第%d层止盈订单失败: %v", layerIndex+1, err)
@@ -1828,42 +1937,35 @@ func createLayerTakeProfitOrder(cfg *config.Config, client *futures.Client,
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "LIMIT",
-		Price:        takeProfitPrice,
-		Quantity:     quantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "take_profit",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 		log.Printf("保存止盈订单失败: %v", err)
 	}
 
-	log.Printf("第%d层止盈订单创建成功: OrderID=%d, Price=%.8f, Quantity=%.8f",
-		layerIndex+1, order.OrderID, takeProfitPrice, quantity)
+	log.Printf("第%d层止盈订单创建成功: OrderID=%d, Price=%s, Quantity=%s",
+		layerIndex+1, order.OrderID, priceStr, quantityStr)
 }
 
 // createLayerStopLossOrder 为单层创建止损订单
-func createLayerStopLossOrder(cfg *config.Config, client *futures.Client,
-	strategy *models.FuturesStrategy, quantity float64, entryPrice float64, layerIndex int) {
+func createLayerStopLossOrder(cfg *config.Config, exchange services.Exchange,
+	strategy *models.FuturesStrategy, quantity, entryPrice decimal.Decimal, layerIndex int) {
 
 	// 计算止损价格（基于该层的实际成交价）
-	var stopLossPrice float64
-	if strategy.StopLossRate > 0 {
-		targetLossRate := strategy.StopLossRate / 10000
-		requiredPriceChangeRate := targetLossRate / float64(strategy.Leverage)
-
-		if strategy.Side == "LONG" {
-			stopLossPrice = entryPrice * (1 - requiredPriceChangeRate)
-		} else {
-			stopLossPrice = entryPrice * (1 + requiredPriceChangeRate)
-		}
-	} else {
+	stopLossPrice := layerStopLossPrice(strategy, entryPrice.InexactFloat64())
+	if stopLossPrice <= 0 {
 		return // 没有设置止损
 	}
 
@@ -1874,14 +1976,20 @@ func createLayerStopLossOrder(cfg *config.Config, client *futures.Client,
 	}
 
 	// 使用止损市价单
-	order, err := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeStopMarket).
-		StopPrice(fmt.Sprintf("%.8f", stopLossPrice)).
-		Quantity(fmt.Sprintf("%.8f", quantity)).
-		Do(context.Background())
+	priceStr, quantityStr := futuresOrderStrings(exchange, strategy.Symbol, decimal.NewFromFloat(stopLossPrice), quantity)
+	orderPrice, orderQty, err := parseFuturesOrderValues(priceStr, quantityStr)
+	if err != nil {
+		log.Printf("创建第%d层止损订单失败: %v", layerIndex+1, err)
+		return
+	}
+	order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeStopMarket,
+		StopPrice:    priceStr,
+		Quantity:     quantityStr,
+	})
 
 	if err != nil {
 		log.Printf("创建第%d层止损订单失败: %v", layerIndex+1, err)
@@ -1891,29 +1999,31 @@ func createLayerStopLossOrder(cfg *config.Config, client *futures.Client,
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "STOP_MARKET",
-		Price:        stopLossPrice,
-		Quantity:     quantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "stop_loss",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 		log.Printf("保存止损订单失败: %v", err)
 	}
 
-	log.Printf("第%d层止损订单创建成功: OrderID=%d, StopPrice=%.8f, Quantity=%.8f",
-		layerIndex+1, order.OrderID, stopLossPrice, quantity)
+	log.Printf("第%d层止损订单创建成功: OrderID=%d, StopPrice=%s, Quantity=%s",
+		layerIndex+1, order.OrderID, priceStr, quantityStr)
 }
 
 // updateOrCreatePosition 更新或创建持仓记录
 func updateOrCreatePosition(cfg *config.Config, strategy *models.FuturesStrategy,
-	price float64, quantity float64, orderID int64) {
+	price, quantity decimal.Decimal, orderID int64, paper bool) {
 
 	var position models.FuturesPosition
 	err := cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "open").First(&position).Error
@@ -1922,6 +2032,7 @@ func updateOrCreatePosition(cfg *config.Config, strategy *models.FuturesStrategy
 		// 创建新持仓
 		position = models.FuturesPosition{
 			UserID:       strategy.UserID,
+			AccountID:    strategy.AccountID,
 			StrategyID:   strategy.ID,
 			Symbol:       strategy.Symbol,
 			PositionSide: strategy.Side,
@@ -1931,6 +2042,7 @@ func updateOrCreatePosition(cfg *config.Config, strategy *models.FuturesStrategy
 			MarginType:   strategy.MarginType,
 			Status:       "open",
 			OpenedAt:     time.Now(),
+			Paper:        paper,
 		}
 		cfg.DB.Create(&position)
 
@@ -1938,24 +2050,22 @@ func updateOrCreatePosition(cfg *config.Config, strategy *models.FuturesStrategy
 		strategy.Status = "position_opened"
 		strategy.CurrentPositionId = orderID
 		cfg.DB.Save(strategy)
+		publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
 	} else {
 		// 更新现有持仓（计算新的平均价格）
-		totalValue := position.EntryPrice*position.Quantity + price*quantity
-		totalQuantity := position.Quantity + quantity
-		position.EntryPrice = totalValue / totalQuantity
+		totalValue := position.EntryPrice.Mul(position.Quantity).Add(price.Mul(quantity))
+		totalQuantity := position.Quantity.Add(quantity)
+		position.EntryPrice = totalValue.Div(totalQuantity)
 		position.Quantity = totalQuantity
 		cfg.DB.Save(&position)
 	}
 }
 
 // createTakeProfitOrder 创建止盈订单（优化避免吃单）
-func createTakeProfitOrder(cfg *config.Config, client *futures.Client,
-	strategy *models.FuturesStrategy, quantity float64) {
+func createTakeProfitOrder(cfg *config.Config, exchange services.Exchange,
+	strategy *models.FuturesStrategy, quantity decimal.Decimal) {
 	// 获取当前深度
-	depth, err := client.NewDepthService().
-		Symbol(strategy.Symbol).
-		Limit(5).
-		Do(context.Background())
+	depth, err := exchange.GetFuturesDepth(context.Background(), strategy.Symbol, 5)
 	if err != nil {
 		log.Printf("获取深度失败，使用策略预设止盈价: %v", err)
 		// 如果获取深度失败，使用策略中的止盈价格
@@ -1964,20 +2074,20 @@ func createTakeProfitOrder(cfg *config.Config, client *futures.Client,
 		if strategy.Side == "LONG" {
 			// 做多止盈是卖出，检查买一价
 			if len(depth.Bids) > 0 {
-				bidPrice, _ := strconv.ParseFloat(depth.Bids[0].Price, 64)
-				if strategy.TakeProfitPrice <= bidPrice {
+				bidPrice, _ := decimal.NewFromString(depth.Bids[0].Price)
+				if strategy.TakeProfitPrice.LessThanOrEqual(bidPrice) {
 					// 如果止盈价格低于或等于买一价，会立即吃单
-					log.Printf("警告：止盈价格 %.8f 低于买一价 %.8f，可能立即成交",
+					log.Printf("警告：止盈价格 %s 低于买一价 %s，可能立即成交",
 						strategy.TakeProfitPrice, bidPrice)
 				}
 			}
 		} else {
 			// 做空止盈是买入，检查卖一价
 			if len(depth.Asks) > 0 {
-				askPrice, _ := strconv.ParseFloat(depth.Asks[0].Price, 64)
-				if strategy.TakeProfitPrice >= askPrice {
+				askPrice, _ := decimal.NewFromString(depth.Asks[0].Price)
+				if strategy.TakeProfitPrice.GreaterThanOrEqual(askPrice) {
 					// 如果止盈价格高于或等于卖一价，会立即吃单
-					log.Printf("警告：止盈价格 %.8f 高于卖一价 %.8f，可能立即成交",
+					log.Printf("警告：止盈价格 %s 高于卖一价 %s，可能立即成交",
 						strategy.TakeProfitPrice, askPrice)
 				}
 			}
@@ -1990,15 +2100,21 @@ func createTakeProfitOrder(cfg *config.Config, client *futures.Client,
 		side = futures.SideTypeBuy
 	}
 
-	order, err := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeLimit).
-		TimeInForce(futures.TimeInForceTypeGTC).
-		Quantity(fmt.Sprintf("%.8f", quantity)).
-		Price(fmt.Sprintf("%.8f", strategy.TakeProfitPrice)).
-		Do(context.Background())
+	priceStr, quantityStr := futuresOrderStrings(exchange, strategy.Symbol, strategy.TakeProfitPrice, quantity)
+	orderPrice, orderQty, err := parseFuturesOrderValues(priceStr, quantityStr)
+	if err != nil {
+		log.Printf("创建止盈订单失败: %v", err)
+		return
+	}
+	order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeLimit,
+		TimeInForce:  futures.TimeInForceTypeGTC,
+		Quantity:     quantityStr,
+		Price:        priceStr,
+	})
 
 	if err != nil {
 		log.Printf("创建止盈订单失败: %v", err)
@@ -2008,29 +2124,31 @@ func createTakeProfitOrder(cfg *config.Config, client *futures.Client,
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "LIMIT",
-		Price:        strategy.TakeProfitPrice,
-		Quantity:     quantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "take_profit",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 		log.Printf("保存止盈订单失败: %v", err)
 	}
 
-	log.Printf("止盈订单创建成功: 策略ID=%d, OrderID=%d, Price=%.8f",
-		strategy.ID, order.OrderID, strategy.TakeProfitPrice)
+	log.Printf("止盈订单创建成功: 策略ID=%d, OrderID=%d, Price=%s",
+		strategy.ID, order.OrderID, priceStr)
 }
 
 // createStopLossOrder 创建止损订单
-func createStopLossOrder(cfg *config.Config, client *futures.Client,
-	strategy *models.FuturesStrategy, quantity float64) {
+func createStopLossOrder(cfg *config.Config, exchange services.Exchange,
+	strategy *models.FuturesStrategy, quantity decimal.Decimal) {
 	// 确定止损方向
 	side := futures.SideTypeSell
 	if strategy.Side == "SHORT" {
@@ -2038,14 +2156,20 @@ func createStopLossOrder(cfg *config.Config, client *futures.Client,
 	}
 
 	// 使用止损市价单
-	order, err := client.NewCreateOrderService().
-		Symbol(strategy.Symbol).
-		Side(side).
-		PositionSide(futures.PositionSideType(strategy.Side)).
-		Type(futures.OrderTypeStopMarket).
-		StopPrice(fmt.Sprintf("%.8f", strategy.StopLossPrice)).
-		Quantity(fmt.Sprintf("%.8f", quantity)).
-		Do(context.Background())
+	priceStr, quantityStr := futuresOrderStrings(exchange, strategy.Symbol, strategy.StopLossPrice, quantity)
+	orderPrice, orderQty, err := parseFuturesOrderValues(priceStr, quantityStr)
+	if err != nil {
+		log.Printf("创建止损订单失败: %v", err)
+		return
+	}
+	order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeStopMarket,
+		StopPrice:    priceStr,
+		Quantity:     quantityStr,
+	})
 
 	if err != nil {
 		log.Printf("创建止损订单失败: %v", err)
@@ -2055,72 +2179,105 @@ func createStopLossOrder(cfg *config.Config, client *futures.Client,
 	// 保存订单记录
 	dbOrder := models.FuturesOrder{
 		UserID:       strategy.UserID,
+		AccountID:    strategy.AccountID,
 		StrategyID:   strategy.ID,
 		Symbol:       strategy.Symbol,
 		Side:         string(side),
 		PositionSide: strategy.Side,
 		Type:         "STOP_MARKET",
-		Price:        strategy.StopLossPrice,
-		Quantity:     quantity,
+		Price:        orderPrice,
+		Quantity:     orderQty,
 		OrderID:      order.OrderID,
 		Status:       string(order.Status),
 		OrderPurpose: "stop_loss",
+		Paper:        services.IsPaperExchange(exchange),
 	}
 
 	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 		log.Printf("保存止损订单失败: %v", err)
 	}
 
-	log.Printf("止损订单创建成功: 策略ID=%d, OrderID=%d, StopPrice=%.8f",
-		strategy.ID, order.OrderID, strategy.StopLossPrice)
+	log.Printf("止损订单创建成功: 策略ID=%d, OrderID=%d, StopPrice=%s",
+		strategy.ID, order.OrderID, priceStr)
+}
+
+// futuresOrderStrings 按交易对 tickSize/stepSize 规整止盈止损单的价格和数量，规则获取失败时保留8位小数
+func futuresOrderStrings(exchange services.Exchange, symbol string, price, quantity decimal.Decimal) (string, string) {
+	filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), symbol)
+	if err != nil {
+		log.Printf("获取 %s 交易规则失败，按8位小数下单: %v", symbol, err)
+		return price.StringFixed(8), quantity.Truncate(8).StringFixed(8)
+	}
+	return filters.PriceString(filters.QuantizePrice(price)),
+		filters.QuantityString(filters.QuantizeQuantity(quantity, services.RoundDown))
+}
+
+// parseFuturesOrderValues 解析按交易规则格式化后的下单价格和数量，格式异常时返回错误而不是 panic
+func parseFuturesOrderValues(priceStr, quantityStr string) (decimal.Decimal, decimal.Decimal, error) {
+	price, err := decimal.NewFromString(priceStr)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("无效的价格 %q: %v", priceStr, err)
+	}
+	quantity, err := decimal.NewFromString(quantityStr)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("无效的数量 %q: %v", quantityStr, err)
+	}
+	return price, quantity, nil
 }
 
 // monitorFuturesPositions 监控期货持仓
-func monitorFuturesPositions(cfg *config.Config) {
-	ticker := time.NewTicker(30 * time.Second)
+func monitorFuturesPositions(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.FuturesPositionInterval)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		// 获取所有开仓中的持仓
 		var positions []models.FuturesPosition
 		if err := cfg.DB.Where("status = ?", "open").Find(&positions).Error; err != nil {
 			continue
 		}
 
-		// 按用户分组
-		userPositions := make(map[uint][]models.FuturesPosition)
+		// 按交易所账户和模拟盘标记分组
+		userPositions := make(map[futuresAccountKey][]models.FuturesPosition)
 		for _, pos := range positions {
-			userPositions[pos.UserID] = append(userPositions[pos.UserID], pos)
+			key := futuresAccountKey{userID: pos.UserID, accountID: pos.AccountID, paper: pos.Paper}
+			userPositions[key] = append(userPositions[key], pos)
 		}
 
-		// 更新每个用户的持仓
-		for userID, userPos := range userPositions {
-			go updateUserPositions(cfg, userID, userPos)
+		// 更新每个账户的持仓
+		for key, userPos := range userPositions {
+			go updateUserPositions(cfg, key.userID, key.accountID, key.paper, userPos)
 		}
 	}
 }
 
-// updateUserPositions 更新用户持仓
-func updateUserPositions(cfg *config.Config, userID uint, positions []models.FuturesPosition) {
+// futuresAccountKey 区分同一用户的各个交易所账户和模拟盘账户
+type futuresAccountKey struct {
+	userID    uint
+	accountID uint
+	paper     bool
+}
+
+// updateUserPositions 更新交易所账户的持仓
+func updateUserPositions(cfg *config.Config, userID, accountID uint, paper bool, positions []models.FuturesPosition) {
 	// 获取用户信息
 	var user models.User
 	if err := cfg.DB.First(&user, userID).Error; err != nil {
 		return
 	}
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, accountID, paper)
 	if err != nil {
 		return
 	}
 
-	client := binance.NewFuturesClient(apiKey, secretKey)
-
 	// 获取账户信息
-	account, err := client.NewGetAccountService().Do(context.Background())
+	account, err := exchange.GetFuturesAccount(context.Background())
 	if err != nil {
+		reportAPIKeyError(userID, err)
 		return
 	}
 
@@ -2132,11 +2289,12 @@ func updateUserPositions(cfg *config.Config, userID uint, positions []models.Fut
 	}
 
 	// 更新本地持仓
+	var updated []models.FuturesPosition
 	for _, pos := range positions {
 		key := pos.Symbol + "_" + pos.PositionSide
 		if accPos, exists := positionMap[key]; exists {
 			// 更新持仓信息
-			unrealizedPnl, _ := strconv.ParseFloat(accPos.UnrealizedProfit, 64)
+			unrealizedPnl, _ := decimal.NewFromString(accPos.UnrealizedProfit)
 
 			updates := map[string]interface{}{
 				"unrealized_pnl": unrealizedPnl,
@@ -2144,6 +2302,7 @@ func updateUserPositions(cfg *config.Config, userID uint, positions []models.Fut
 			}
 
 			cfg.DB.Model(&pos).Updates(updates)
+			pos.UnrealizedPnl = unrealizedPnl
 
 			// 检查是否已平仓
 			posAmt, _ := strconv.ParseFloat(accPos.PositionAmt, 64)
@@ -2154,23 +2313,34 @@ func updateUserPositions(cfg *config.Config, userID uint, positions []models.Fut
 				pos.ClosedAt = &now
 				cfg.DB.Save(&pos)
 
+				emitPositionClosed(pos, "")
+
 				// 更新策略状态
 				var strategy models.FuturesStrategy
 				if err := cfg.DB.First(&strategy, pos.StrategyID).Error; err == nil {
 					strategy.Status = "completed"
 					strategy.CompletedAt = &now
 					cfg.DB.Save(&strategy)
+					publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
 				}
 			}
+			updated = append(updated, pos)
 		}
 	}
+
+	publishPositions(userID, updated)
 }
 
 // checkFuturesOrders 检查期货订单状态
-func checkFuturesOrders(cfg *config.Config) {
-	ticker := time.NewTicker(10 * time.Second)
+func checkFuturesOrders(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.FuturesOrderInterval)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		// 获取所有未完成的订单
 		var orders []models.FuturesOrder
 		if err := cfg.DB.Where("status IN ?", []string{"NEW", "PARTIALLY_FILLED"}).
@@ -2178,154 +2348,226 @@ func checkFuturesOrders(cfg *config.Config) {
 			continue
 		}
 
-		// 按用户分组
-		userOrders := make(map[uint][]models.FuturesOrder)
+		// 按交易所账户和模拟盘标记分组
+		userOrders := make(map[futuresAccountKey][]models.FuturesOrder)
 		for _, order := range orders {
-			userOrders[order.UserID] = append(userOrders[order.UserID], order)
+			key := futuresAccountKey{userID: order.UserID, accountID: order.AccountID, paper: order.Paper}
+			userOrders[key] = append(userOrders[key], order)
 		}
 
-		// 处理每个用户的订单
-		for userID, userOrderList := range userOrders {
-			go checkFuturesUserOrders(cfg, userID, userOrderList)
+		// 处理每个账户的订单，用户数据流正常的账户只定期完整对账
+		for key, userOrderList := range userOrders {
+			if !key.paper && !userStreams.shouldReconcile(userStreamKey{userID: key.userID, accountID: key.accountID, market: userStreamFutures}) {
+				continue
+			}
+			go checkFuturesUserOrders(cfg, key.userID, key.accountID, key.paper, userOrderList)
 		}
 	}
 }
 
 // checkFuturesUserOrders 检查用户订单（重命名以避免冲突）
-func checkFuturesUserOrders(cfg *config.Config, userID uint, orders []models.FuturesOrder) {
+func checkFuturesUserOrders(cfg *config.Config, userID, accountID uint, paper bool, orders []models.FuturesOrder) {
 	// 获取用户信息
 	var user models.User
 	if err := cfg.DB.First(&user, userID).Error; err != nil {
 		return
 	}
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, accountID, paper)
 	if err != nil {
 		return
 	}
 
-	client := binance.NewFuturesClient(apiKey, secretKey)
-
 	// 批量查询订单
 	for _, order := range orders {
-		futuresOrder, err := client.NewGetOrderService().
-			Symbol(order.Symbol).
-			OrderID(order.OrderID).
-			Do(context.Background())
+		futuresOrder, err := exchange.GetFuturesOrder(context.Background(), order.Symbol, order.OrderID)
 
 		if err != nil {
 			continue
 		}
 
 		// 更新订单状态
-		execQty, _ := strconv.ParseFloat(futuresOrder.ExecutedQuantity, 64)
-		avgPrice, _ := strconv.ParseFloat(futuresOrder.AvgPrice, 64)
+		execQty, _ := decimal.NewFromString(futuresOrder.ExecutedQuantity)
+		avgPrice, _ := decimal.NewFromString(futuresOrder.AvgPrice)
+
+		updated := updateFuturesOrderStatus(cfg, order, string(futuresOrder.Status), execQty, avgPrice)
 
-		updates := map[string]interface{}{
-			"status":       string(futuresOrder.Status),
+		// 如果是止盈、止损或跟踪止损订单成交，更新相关记录
+		if updated && futuresOrder.Status == futures.OrderStatusTypeFilled && isExitOrderPurpose(order.OrderPurpose) {
+			handleExitOrderFilled(cfg, exchange, order, avgPrice, execQty)
+		}
+	}
+}
+
+// updateFuturesOrderStatus 更新未完成订单的状态和成交进度，订单已被其他途径更新为终态时返回 false
+// 轮询对账和用户数据流可能同时收到同一笔成交，只有更新成功的一方处理后续平仓逻辑
+func updateFuturesOrderStatus(cfg *config.Config, order models.FuturesOrder, status string, execQty, avgPrice decimal.Decimal) bool {
+	result := cfg.DB.Model(&models.FuturesOrder{}).
+		Where("id = ? AND status IN ?", order.ID, []string{"NEW", "PARTIALLY_FILLED"}).
+		Updates(map[string]interface{}{
+			"status":       status,
 			"executed_qty": execQty,
 			"avg_price":    avgPrice,
 			"updated_at":   time.Now(),
-		}
+		})
+	if result.Error != nil {
+		log.Printf("更新期货订单 %d 状态失败: %v", order.OrderID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	publishFuturesOrderStatus(order, status, execQty, avgPrice)
+
+	if status == string(futures.OrderStatusTypeFilled) {
+		EmitNotification(NotificationEvent{
+			UserID:  order.UserID,
+			Event:   models.NotifyOrderFilled,
+			Title:   fmt.Sprintf("合约订单成交: %s %s %s", order.Symbol, order.Side, order.PositionSide),
+			Message: fmt.Sprintf("合约订单 %d 成交 %s @ %s（%s）", order.OrderID, execQty, avgPrice, order.OrderPurpose),
+			Data: map[string]interface{}{
+				"market":     "futures",
+				"orderId":    order.OrderID,
+				"strategyId": order.StrategyID,
+				"symbol":     order.Symbol,
+				"side":       order.Side,
+				"purpose":    order.OrderPurpose,
+				"quantity":   execQty,
+				"price":      avgPrice,
+				"paper":      order.Paper,
+			},
+		})
+	}
+	return true
+}
 
-		cfg.DB.Model(&order).Updates(updates)
+// isExitOrderPurpose 是否为平仓订单
+func isExitOrderPurpose(purpose string) bool {
+	return purpose == "take_profit" || purpose == "stop_loss" || purpose == "trailing_stop"
+}
 
-		// 如果是止盈或止损订单成交，更新相关记录
-		if futuresOrder.Status == futures.OrderStatusTypeFilled &&
-			(order.OrderPurpose == "take_profit" || order.OrderPurpose == "stop_loss") {
+// handleExitOrderFilled 平仓订单成交：关闭持仓、完成策略并按需自动重启
+func handleExitOrderFilled(cfg *config.Config, exchange services.Exchange, order models.FuturesOrder, avgPrice, execQty decimal.Decimal) {
+	// 跟踪止损平掉全部持仓，撤销剩余的固定止盈止损；固定止盈止损成交时撤销跟踪止损
+	if order.OrderPurpose == "trailing_stop" {
+		cancelStrategyExitOrders(cfg, exchange, order.StrategyID, []string{"take_profit", "stop_loss"})
+	} else {
+		cancelStrategyExitOrders(cfg, exchange, order.StrategyID, []string{"trailing_stop"})
+	}
 
-			// 计算盈亏
-			var position models.FuturesPosition
-			if err := cfg.DB.Where("strategy_id = ? AND status = ?",
-				order.StrategyID, "open").First(&position).Error; err == nil {
+	// 计算盈亏
+	var position models.FuturesPosition
+	if err := cfg.DB.Where("strategy_id = ? AND status = ?",
+		order.StrategyID, "open").First(&position).Error; err == nil {
 
-				// 计算已实现盈亏
-				var realizedPnl float64
-				if order.PositionSide == "LONG" {
-					realizedPnl = (avgPrice - position.EntryPrice) * execQty
-				} else {
-					realizedPnl = (position.EntryPrice - avgPrice) * execQty
-				}
+		// 计算已实现盈亏
+		var realizedPnl decimal.Decimal
+		if order.PositionSide == "LONG" {
+			realizedPnl = avgPrice.Sub(position.EntryPrice).Mul(execQty)
+		} else {
+			realizedPnl = position.EntryPrice.Sub(avgPrice).Mul(execQty)
+		}
 
-				// 更新持仓状态
-				position.RealizedPnl = realizedPnl
-				position.Status = "closed"
-				now := time.Now()
-				position.ClosedAt = &now
-				cfg.DB.Save(&position)
+		// 更新持仓状态
+		position.RealizedPnl = realizedPnl
+		position.Status = "closed"
+		now := time.Now()
+		position.ClosedAt = &now
+		cfg.DB.Save(&position)
 
-				// 更新策略状态
-				var strategy models.FuturesStrategy
-				if err := cfg.DB.First(&strategy, order.StrategyID).Error; err == nil {
-					strategy.Status = "completed"
-					strategy.CompletedAt = &now
-					cfg.DB.Save(&strategy)
+		emitPositionClosed(position, order.OrderPurpose)
 
-					log.Printf("策略 %d 完成，盈亏: %.8f", strategy.ID, realizedPnl)
-
-					// 检查是否需要自动重启
-					if strategy.AutoRestart && strategy.Enabled {
-						log.Printf("策略 %d 设置了自动重启，正在创建新策略...", strategy.ID)
-
-						// 创建新的策略（复制原策略配置）
-						newStrategy := models.FuturesStrategy{
-							UserID:             strategy.UserID,
-							StrategyName:       strategy.StrategyName,
-							Symbol:             strategy.Symbol,
-							Side:               strategy.Side,
-							StrategyType:       strategy.StrategyType,
-							BasePrice:          strategy.BasePrice,
-							EntryPrice:         0, // 重置为0
-							EntryPriceFloat:    strategy.EntryPriceFloat,
-							Leverage:           strategy.Leverage,
-							Quantity:           strategy.Quantity,
-							TakeProfitRate:     strategy.TakeProfitRate,
-							TakeProfitPrice:    0, // 重置为0
-							StopLossRate:       strategy.StopLossRate,
-							StopLossPrice:      0, // 重置为0
-							MarginType:         strategy.MarginType,
-							IcebergLevels:      strategy.IcebergLevels,
-							IcebergQuantities:  strategy.IcebergQuantities,
-							IcebergPriceGaps:   strategy.IcebergPriceGaps,
-							SlowIcebergTimeout: strategy.SlowIcebergTimeout,
-							AutoRestart:        strategy.AutoRestart, // 保持自动重启设置
-							Enabled:            true,
-							Status:             "waiting",
-						}
+		// 更新策略状态
+		var strategy models.FuturesStrategy
+		if err := cfg.DB.First(&strategy, order.StrategyID).Error; err == nil {
+			strategy.Status = "completed"
+			strategy.CompletedAt = &now
+			cfg.DB.Save(&strategy)
+			publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
+
+			log.Printf("策略 %d 完成，盈亏: %s", strategy.ID, realizedPnl)
+
+			// 检查是否需要自动重启
+			if strategy.AutoRestart && strategy.Enabled {
+				log.Printf("策略 %d 设置了自动重启，正在创建新策略...", strategy.ID)
+
+				// 创建新的策略（复制原策略配置）
+				newStrategy := models.FuturesStrategy{
+					UserID:                  strategy.UserID,
+					AccountID:               strategy.AccountID,
+					StrategyName:            strategy.StrategyName,
+					Symbol:                  strategy.Symbol,
+					Side:                    strategy.Side,
+					StrategyType:            strategy.StrategyType,
+					BasePrice:               strategy.BasePrice,
+					EntryPrice:              decimal.Zero, // 重置为0
+					EntryPriceFloat:         strategy.EntryPriceFloat,
+					Leverage:                strategy.Leverage,
+					Quantity:                strategy.Quantity,
+					TakeProfitRate:          strategy.TakeProfitRate,
+					TakeProfitPrice:         decimal.Zero, // 重置为0
+					StopLossRate:            strategy.StopLossRate,
+					StopLossPrice:           decimal.Zero, // 重置为0
+					MarginType:              strategy.MarginType,
+					IcebergLevels:           strategy.IcebergLevels,
+					IcebergQuantities:       strategy.IcebergQuantities,
+					IcebergPriceGaps:        strategy.IcebergPriceGaps,
+					SlowIcebergTimeout:      strategy.SlowIcebergTimeout,
+					AutoRestart:             strategy.AutoRestart, // 保持自动重启设置
+					TrailingMode:            strategy.TrailingMode,
+					TrailingCallbackRate:    strategy.TrailingCallbackRate,
+					TrailingActivationPrice: strategy.TrailingActivationPrice,
+					TrailingTakeProfit:      strategy.TrailingTakeProfit,
+					Paper:                   strategy.Paper,
+					Enabled:                 true,
+					Status:                  "waiting",
+				}
 
-						if err := cfg.DB.Create(&newStrategy).Error; err != nil {
-							log.Printf("自动重启策略失败: %v", err)
-						} else {
-							log.Printf("策略 %d 已自动重启，新策略ID: %d", strategy.ID, newStrategy.ID)
-						}
-					}
+				if err := cfg.DB.Create(&newStrategy).Error; err != nil {
+					log.Printf("自动重启策略失败: %v", err)
+				} else {
+					log.Printf("策略 %d 已自动重启，新策略ID: %d", strategy.ID, newStrategy.ID)
 				}
 			}
 		}
 	}
 }
 
+// emitPositionClosed 发出平仓通知，purpose 为触发平仓的订单用途，为空表示持仓同步发现已平仓
+func emitPositionClosed(position models.FuturesPosition, purpose string) {
+	reason := "持仓已平"
+	switch purpose {
+	case "take_profit":
+		reason = "止盈成交"
+	case "stop_loss":
+		reason = "止损成交"
+	case "trailing_stop":
+		reason = "跟踪止损成交"
+	}
+	EmitNotification(NotificationEvent{
+		UserID:  position.UserID,
+		Event:   models.NotifyPositionClosed,
+		Title:   fmt.Sprintf("平仓: %s %s（%s）", position.Symbol, position.PositionSide, reason),
+		Message: fmt.Sprintf("策略 %d 的持仓已平仓，已实现盈亏 %s USDT", position.StrategyID, position.RealizedPnl),
+		Data: map[string]interface{}{
+			"strategyId":  position.StrategyID,
+			"symbol":      position.Symbol,
+			"side":        position.PositionSide,
+			"purpose":     purpose,
+			"realizedPnl": position.RealizedPnl,
+			"paper":       position.Paper,
+		},
+	})
+}
+
 // Helper functions
 // setLeverage 设置杠杆
-func setLeverage(client *futures.Client, symbol string, leverage int) error {
-	_, err := client.NewChangeLeverageService().
-		Symbol(symbol).
-		Leverage(leverage).
-		Do(context.Background())
-	return err
+func setLeverage(exchange services.Exchange, symbol string, leverage int) error {
+	return exchange.ChangeLeverage(context.Background(), symbol, leverage)
 }
 
 // setMarginType 设置保证金模式
-func setMarginType(client *futures.Client, symbol string, marginType string) error {
-	err := client.NewChangeMarginTypeService().
-		Symbol(symbol).
-		MarginType(futures.MarginType(marginType)).
-		Do(context.Background())
-	return err
+func setMarginType(exchange services.Exchange, symbol string, marginType string) error {
+	return exchange.ChangeMarginType(context.Background(), symbol, marginType)
 }
 
 // updateStrategyStatus 更新策略状态
@@ -2340,6 +2582,7 @@ func updateStrategyStatus(db *gorm.DB, strategy *models.FuturesStrategy, status
 	}
 
 	db.Model(strategy).Updates(updates)
+	publishStrategyState(strategy.UserID, "futures", strategy.ID, status)
 
 	if reason != "" {
 		log.Printf("策略 %d 状态更新为 %s: %s", strategy.ID, status, reason)
@@ -0,0 +1,910 @@
+package tasks
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// 币安U本位合约默认手续费率
+	futuresBacktestMakerFeeRate = 0.0002
+	futuresBacktestTakerFeeRate = 0.0005
+	// futuresBacktestFundingRate 没有资金费率数据时使用的固定费率（每8小时）
+	futuresBacktestFundingRate = 0.0001
+	// futuresBacktestFundingInterval 资金费结算间隔
+	futuresBacktestFundingInterval = 8 * time.Hour
+	// futuresBacktestMaintenanceRate 默认维持保证金率
+	futuresBacktestMaintenanceRate = 0.004
+	// futuresBacktestInitialBalance 默认初始资金（USDT）
+	futuresBacktestInitialBalance = 10000.0
+	// futuresBacktestEquityPoints 资金曲线默认最多输出的点数
+	futuresBacktestEquityPoints = 500
+)
+
+// FundingRate 历史资金费率
+type FundingRate struct {
+	Time time.Time
+	Rate float64
+}
+
+// FuturesBacktestData 期货回测数据，标记价格用于触发、资金费和强平，成交价格用于撮合；
+// 只提供其中一种时另一种使用同一份数据
+type FuturesBacktestData struct {
+	MarkPrices   []BacktestTick
+	Trades       []BacktestTick
+	FundingRates []FundingRate // 为空时按 FundingRate 参数每8小时结算一次
+}
+
+// FuturesBacktestOptions 期货回测参数
+type FuturesBacktestOptions struct {
+	MakerFeeRate          float64         `json:"makerFeeRate"`          // 挂单手续费率，默认0.0002
+	TakerFeeRate          float64         `json:"takerFeeRate"`          // 吃单手续费率，默认0.0005
+	FundingRate           *float64        `json:"fundingRate"`           // 无资金费率数据时的固定费率，默认0.0001
+	MaintenanceMarginRate float64         `json:"maintenanceMarginRate"` // 维持保证金率，默认0.004
+	InitialBalance        float64         `json:"initialBalance"`        // 初始资金，默认10000 USDT
+	MaxCycles             int             `json:"maxCycles"`             // 最多回测的开平仓周期数，0表示不限制
+	EquityPoints          int             `json:"equityPoints"`          // 资金曲线最多输出的点数，默认500
+	SymbolInfo            *futures.Symbol `json:"-"`                     // 交易对精度信息，为空时使用默认精度
+}
+
+// FuturesBacktestCycle 一次从触发开仓到平仓的周期
+type FuturesBacktestCycle struct {
+	Index            int        `json:"index"` // 从1开始
+	TriggeredAt      time.Time  `json:"triggeredAt"`
+	OpenedAt         time.Time  `json:"openedAt"`
+	ClosedAt         *time.Time `json:"closedAt"`
+	EntryOrders      int        `json:"entryOrders"` // 开仓委托数（含慢冰山超时重挂）
+	EntryFills       int        `json:"entryFills"`
+	EntryPrice       float64    `json:"entryPrice"` // 开仓均价
+	Quantity         float64    `json:"quantity"`   // 最大持仓数量
+	ExitPrice        float64    `json:"exitPrice"`  // 平仓均价
+	LiquidationPrice float64    `json:"liquidationPrice"`
+	TakeProfits      int        `json:"takeProfits"`
+	StopLosses       int        `json:"stopLosses"`
+	TrailingStops    int        `json:"trailingStops"`
+	ExitReason       string     `json:"exitReason"` // take_profit/stop_loss/trailing_stop/liquidation/open
+	RealizedPnl      float64    `json:"realizedPnl"`
+	UnrealizedPnl    float64    `json:"unrealizedPnl"` // 回放结束时仍未平仓的浮动盈亏
+	Fees             float64    `json:"fees"`
+	Funding          float64    `json:"funding"` // 资金费，正数为收入
+	NetPnl           float64    `json:"netPnl"`
+	AutoRestarted    bool       `json:"autoRestarted"` // 完成后是否自动重启
+}
+
+// EquityPoint 资金曲线上的一个点
+type EquityPoint struct {
+	Time      time.Time `json:"time"`
+	Equity    float64   `json:"equity"`
+	MarkPrice float64   `json:"markPrice"`
+}
+
+// FuturesBacktestResult 期货回测结果
+type FuturesBacktestResult struct {
+	Symbol         string                 `json:"symbol"`
+	StrategyType   string                 `json:"strategyType"`
+	Side           string                 `json:"side"`
+	Leverage       int                    `json:"leverage"`
+	MarginType     string                 `json:"marginType"`
+	Start          time.Time              `json:"start"`
+	End            time.Time              `json:"end"`
+	Triggers       int                    `json:"triggers"`
+	EntryTimeouts  int                    `json:"entryTimeouts"` // 开仓订单超时未成交、策略回到等待状态的次数
+	Cycles         []FuturesBacktestCycle `json:"cycles"`
+	AutoRestarts   int                    `json:"autoRestarts"`
+	Liquidations   int                    `json:"liquidations"`
+	TakeProfits    int                    `json:"takeProfits"`
+	StopLosses     int                    `json:"stopLosses"`
+	TrailingStops  int                    `json:"trailingStops"`
+	RealizedPnl    float64                `json:"realizedPnl"`
+	Fees           float64                `json:"fees"`
+	Funding        float64                `json:"funding"`
+	NetPnl         float64                `json:"netPnl"`
+	InitialBalance float64                `json:"initialBalance"`
+	FinalEquity    float64                `json:"finalEquity"`
+	MaxDrawdown    float64                `json:"maxDrawdown"`
+	MaxDrawdownPct float64                `json:"maxDrawdownPct"`
+	FinalStatus    string                 `json:"finalStatus"` // 回放结束时的策略状态
+	StatusReason   string                 `json:"statusReason,omitempty"`
+	EquityCurve    []EquityPoint          `json:"equityCurve"`
+}
+
+// futuresBacktestOrder 回测中挂着的期货委托
+type futuresBacktestOrder struct {
+	purpose  string // entry/take_profit/stop_loss/trailing_stop
+	layer    int
+	price    float64 // 限价单价格或止损触发价
+	quantity float64
+}
+
+// 回测事件类型，同一个价格点可以同时作为标记价格和成交价格
+const (
+	futuresEventMark = 1 << iota
+	futuresEventTrade
+	futuresEventFunding
+)
+
+type futuresBacktestEvent struct {
+	time  time.Time
+	price float64
+	rate  float64
+	kind  int
+}
+
+// futuresBacktest 期货回测状态机，状态流转与实盘一致：waiting -> triggered -> position_opened -> completed
+type futuresBacktest struct {
+	strategy models.FuturesStrategy
+	opts     FuturesBacktestOptions
+	filters  *services.SymbolFilters
+	result   *FuturesBacktestResult
+
+	status        string
+	entries       []*futuresBacktestOrder
+	exits         []*futuresBacktestOrder
+	entriesDone   bool
+	entryDeadline time.Time // 简单/冰山策略开仓超时时间
+	quantities    []float64 // 冰山配置
+	priceGaps     []float64
+	slowLayer     int       // 慢冰山当前层
+	layerStart    time.Time // 慢冰山当前层挂单时间
+
+	cycle         *FuturesBacktestCycle
+	positionQty   float64
+	entryPrice    float64
+	entryNotional float64
+	exitNotional  float64
+	exitQty       float64
+	lastExit      string
+
+	balance    float64
+	lastPrice  float64
+	markPrice  float64
+	peak       float64
+	curveStep  time.Duration
+	lastSample time.Time
+}
+
+// ReadFundingRateData 读取币安公开数据中的资金费率文件（calc_time,funding_interval_hours,last_funding_rate），支持 zip 包
+func ReadFundingRateData(data []byte) ([]FundingRate, error) {
+	var rates []FundingRate
+	err := readBacktestArchive(data, func(r io.Reader) error {
+		fileRates, err := LoadFundingRates(r)
+		rates = append(rates, fileRates...)
+		return err
+	})
+	return rates, err
+}
+
+// LoadFundingRates 解析资金费率 CSV，第一列为结算时间，最后一列为费率
+func LoadFundingRates(r io.Reader) ([]FundingRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var rates []FundingRate
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取CSV失败: %v", err)
+		}
+		line++
+		if len(record) < 2 {
+			return nil, fmt.Errorf("第 %d 行: 无法识别的数据格式（%d 列）", line, len(record))
+		}
+
+		ts, err := parseBacktestTimestamp(record[0])
+		if err != nil {
+			// 跳过表头
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[len(record)-1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: 解析资金费率失败: %v", line, err)
+		}
+		rates = append(rates, FundingRate{Time: ts, Rate: rate})
+	}
+
+	return rates, nil
+}
+
+// RunFuturesBacktest 用历史标记价格和成交回放期货策略
+// 开仓价格、冰山分层、止盈止损价格与实盘共用 makerEntryPrice / planIcebergLayers / layerTakeProfitPrice 等函数；
+// 盘口按成交价合成（买一=卖一=成交价），限价单在成交价严格穿过委托价时按委托价成交，止损单触发后按成交价成交。
+// 一个周期在持仓归零且没有未完成的开仓委托时结束，止盈止损平仓后按 AutoRestart 决定是否重新等待触发。
+func RunFuturesBacktest(strategy models.FuturesStrategy, data FuturesBacktestData, opts FuturesBacktestOptions) (*FuturesBacktestResult, error) {
+	if strategy.Side != "LONG" && strategy.Side != "SHORT" {
+		return nil, fmt.Errorf("无效的交易方向: %s", strategy.Side)
+	}
+	if !strategy.BasePrice.IsPositive() || !strategy.Quantity.IsPositive() {
+		return nil, fmt.Errorf("基准价格和开仓数量必须大于0")
+	}
+	if strategy.Leverage <= 0 {
+		return nil, fmt.Errorf("杠杆倍数必须大于0")
+	}
+	if len(data.MarkPrices) == 0 && len(data.Trades) == 0 {
+		return nil, fmt.Errorf("没有历史价格数据")
+	}
+
+	if strategy.StrategyType == "" {
+		strategy.StrategyType = "simple"
+	}
+	if strategy.MarginType == "" {
+		strategy.MarginType = "CROSSED"
+	}
+	if strategy.SlowIcebergTimeout <= 0 {
+		strategy.SlowIcebergTimeout = 5 // 默认5分钟
+	}
+	if opts.MakerFeeRate <= 0 {
+		opts.MakerFeeRate = futuresBacktestMakerFeeRate
+	}
+	if opts.TakerFeeRate <= 0 {
+		opts.TakerFeeRate = futuresBacktestTakerFeeRate
+	}
+	if opts.FundingRate == nil {
+		rate := futuresBacktestFundingRate
+		opts.FundingRate = &rate
+	}
+	if opts.MaintenanceMarginRate <= 0 {
+		opts.MaintenanceMarginRate = futuresBacktestMaintenanceRate
+	}
+	if opts.InitialBalance <= 0 {
+		opts.InitialBalance = futuresBacktestInitialBalance
+	}
+	if opts.EquityPoints <= 0 {
+		opts.EquityPoints = futuresBacktestEquityPoints
+	}
+
+	// 没有交易规则时按8位价格精度、0.001数量步长计算
+	filters := &services.SymbolFilters{Symbol: strategy.Symbol, TickSize: 1e-8, StepSize: 0.001, MinQty: 0.001}
+	if opts.SymbolInfo != nil {
+		filters = services.ParseSymbolFilters(opts.SymbolInfo.Symbol, opts.SymbolInfo.Filters)
+	}
+
+	events := buildFuturesBacktestEvents(data, *opts.FundingRate)
+	if len(events) == 0 {
+		return nil, fmt.Errorf("没有历史价格数据")
+	}
+
+	bt := &futuresBacktest{
+		strategy: strategy,
+		opts:     opts,
+		filters:  filters,
+		status:   "waiting",
+		balance:  opts.InitialBalance,
+		peak:     opts.InitialBalance,
+		result: &FuturesBacktestResult{
+			Symbol:         strategy.Symbol,
+			StrategyType:   strategy.StrategyType,
+			Side:           strategy.Side,
+			Leverage:       strategy.Leverage,
+			MarginType:     strategy.MarginType,
+			Start:          events[0].time,
+			End:            events[len(events)-1].time,
+			InitialBalance: opts.InitialBalance,
+		},
+	}
+	bt.curveStep = bt.result.End.Sub(bt.result.Start) / time.Duration(opts.EquityPoints)
+	bt.strategy.EntryPrice, bt.strategy.TakeProfitPrice, bt.strategy.StopLossPrice = decimal.Zero, decimal.Zero, decimal.Zero
+
+	for _, ev := range events {
+		if ev.kind&futuresEventFunding != 0 {
+			bt.settleFunding(ev.rate)
+		}
+		if ev.kind&futuresEventMark != 0 {
+			bt.onMarkPrice(ev.time, ev.price)
+		}
+		if ev.kind&futuresEventTrade != 0 {
+			bt.onTrade(ev.time, ev.price)
+		}
+	}
+
+	bt.finish()
+	return bt.result, nil
+}
+
+// buildFuturesBacktestEvents 合并标记价格、成交和资金费结算，按时间排序
+func buildFuturesBacktestEvents(data FuturesBacktestData, fundingRate float64) []futuresBacktestEvent {
+	var events []futuresBacktestEvent
+
+	markKind, tradeKind := futuresEventMark, futuresEventTrade
+	if len(data.MarkPrices) == 0 {
+		tradeKind |= futuresEventMark
+	}
+	if len(data.Trades) == 0 {
+		markKind |= futuresEventTrade
+	}
+	for _, tick := range data.MarkPrices {
+		events = append(events, futuresBacktestEvent{time: tick.Time, price: tick.Price, kind: markKind})
+	}
+	for _, tick := range data.Trades {
+		events = append(events, futuresBacktestEvent{time: tick.Time, price: tick.Price, kind: tradeKind})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time.Before(events[j].time)
+	})
+	start, end := events[0].time, events[len(events)-1].time
+
+	var funding []futuresBacktestEvent
+	if len(data.FundingRates) > 0 {
+		for _, rate := range data.FundingRates {
+			if rate.Time.Before(start) || rate.Time.After(end) {
+				continue
+			}
+			funding = append(funding, futuresBacktestEvent{time: rate.Time, rate: rate.Rate, kind: futuresEventFunding})
+		}
+	} else if fundingRate != 0 {
+		// 币安每天 00:00、08:00、16:00（UTC）结算资金费
+		for t := start.UTC().Truncate(futuresBacktestFundingInterval).Add(futuresBacktestFundingInterval); !t.After(end); t = t.Add(futuresBacktestFundingInterval) {
+			funding = append(funding, futuresBacktestEvent{time: t, rate: fundingRate, kind: futuresEventFunding})
+		}
+	}
+
+	if len(funding) > 0 {
+		events = append(events, funding...)
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].time.Before(events[j].time)
+		})
+	}
+	return events
+}
+
+// onMarkPrice 标记价格：检查强平、触发条件并记录资金曲线
+func (bt *futuresBacktest) onMarkPrice(t time.Time, price float64) {
+	bt.markPrice = price
+
+	if bt.positionQty > 0 && bt.shouldLiquidate() {
+		bt.liquidate(t)
+	}
+
+	if bt.positionQty > 0 && bt.strategy.TrailingEnabled() {
+		bt.checkTrailing(t, price)
+	}
+
+	if bt.status == "waiting" && futuresStrategyTriggered(&bt.strategy, price) {
+		if bt.opts.MaxCycles == 0 || len(bt.result.Cycles) < bt.opts.MaxCycles {
+			bt.trigger(t)
+		}
+	}
+
+	bt.recordEquity(t, false)
+}
+
+// onTrade 成交价格：撮合开仓和平仓委托
+func (bt *futuresBacktest) onTrade(t time.Time, price float64) {
+	bt.lastPrice = price
+	bt.matchEntries(t, price)
+	bt.matchExits(t, price)
+}
+
+// settleFunding 结算资金费，费率为正时多头支付给空头
+func (bt *futuresBacktest) settleFunding(rate float64) {
+	if bt.positionQty <= 0 || bt.markPrice <= 0 {
+		return
+	}
+	payment := bt.positionQty * bt.markPrice * rate
+	if bt.strategy.Side == "LONG" {
+		payment = -payment
+	}
+	bt.balance += payment
+	bt.cycle.Funding += payment
+}
+
+// trigger 策略触发后按策略类型挂出开仓委托
+func (bt *futuresBacktest) trigger(t time.Time) {
+	basePrice := bt.lastPrice
+	if basePrice <= 0 {
+		basePrice = bt.markPrice
+	}
+
+	strategy := &bt.strategy
+	bt.result.Triggers++
+	bt.status = "triggered"
+	bt.entriesDone = false
+	bt.cycle = &FuturesBacktestCycle{Index: len(bt.result.Cycles) + 1, TriggeredAt: t}
+
+	totalOrderValue := strategy.Quantity.InexactFloat64() * float64(strategy.Leverage)
+
+	switch strategy.StrategyType {
+	case "iceberg":
+		bt.quantities = parseQuantities(strategy.IcebergQuantities)
+		bt.priceGaps = parsePriceGaps(strategy.IcebergPriceGaps, strategy.Side)
+		if len(bt.quantities) != len(bt.priceGaps) {
+			bt.cancelStrategy("配置错误")
+			return
+		}
+
+		var weightedPriceSum, totalQuantity float64
+		for i, layer := range planIcebergLayers(strategy, basePrice, bt.quantities, bt.priceGaps, bt.filters) {
+			if layer.skip {
+				continue
+			}
+			bt.placeEntry(t, i, layer.price, layer.quantity)
+			weightedPriceSum += layer.price * layer.quantity
+			totalQuantity += layer.quantity
+		}
+		if totalQuantity == 0 {
+			bt.cancelStrategy("所有订单创建失败：金额太小")
+			return
+		}
+		strategy.EntryPrice = decimal.NewFromFloat(weightedPriceSum / totalQuantity)
+		strategy.CalculateTakeProfitPrice()
+		strategy.CalculateStopLossPrice()
+		bt.entryDeadline = t.Add(futuresEntryOrderTimeout)
+
+	case "slow_iceberg":
+		bt.quantities = parseQuantities(strategy.IcebergQuantities)
+		bt.priceGaps = parsePriceGaps(strategy.IcebergPriceGaps, strategy.Side)
+		if len(bt.quantities) != len(bt.priceGaps) {
+			bt.cancelStrategy("配置错误")
+			return
+		}
+
+		price := makerEntryPrice(strategy, basePrice, bt.priceGaps[0], bt.filters.TickSize)
+		quantity := bt.floorToStep(totalOrderValue * bt.quantities[0] / price)
+		if quantity < bt.filters.MinQty {
+			bt.cancelStrategy("第一层数量太小")
+			return
+		}
+		bt.slowLayer = 0
+		bt.layerStart = t
+		bt.placeEntry(t, 0, price, quantity)
+
+	default:
+		price := makerEntryPrice(strategy, basePrice, 0, bt.filters.TickSize)
+		quantity := bt.floorToStep(totalOrderValue / price)
+		// 小于最小数量时使用最小数量
+		if quantity < bt.filters.MinQty {
+			quantity = bt.filters.MinQty
+		}
+		if quantity <= 0 {
+			bt.cancelStrategy("计算后的合约数量为0")
+			return
+		}
+		strategy.EntryPrice = decimal.NewFromFloat(price)
+		strategy.CalculateTakeProfitPrice()
+		strategy.CalculateStopLossPrice()
+		bt.entryDeadline = t.Add(futuresEntryOrderTimeout)
+		bt.placeEntry(t, 0, price, quantity)
+	}
+}
+
+// placeEntry 挂出开仓限价单，穿过当前价格的委托作为吃单立即成交
+func (bt *futuresBacktest) placeEntry(t time.Time, layer int, price, quantity float64) {
+	order := &futuresBacktestOrder{purpose: "entry", layer: layer, price: price, quantity: quantity}
+	bt.cycle.EntryOrders++
+
+	if bt.lastPrice > 0 && (bt.strategy.Side == "LONG" && price >= bt.lastPrice ||
+		bt.strategy.Side == "SHORT" && price <= bt.lastPrice) {
+		bt.fillEntry(t, order, bt.lastPrice, false)
+		return
+	}
+	bt.entries = append(bt.entries, order)
+}
+
+// matchEntries 撮合开仓委托，处理开仓超时和慢冰山的逐层挂单
+func (bt *futuresBacktest) matchEntries(t time.Time, price float64) {
+	if bt.status != "triggered" && bt.status != "position_opened" || bt.entriesDone {
+		return
+	}
+
+	var filled []*futuresBacktestOrder
+	remaining := bt.entries[:0]
+	for _, order := range bt.entries {
+		if bt.strategy.Side == "LONG" && price < order.price || bt.strategy.Side == "SHORT" && price > order.price {
+			filled = append(filled, order)
+			continue
+		}
+		remaining = append(remaining, order)
+	}
+	bt.entries = remaining
+	for _, order := range filled {
+		bt.fillEntry(t, order, order.price, true)
+	}
+
+	if bt.strategy.StrategyType == "slow_iceberg" {
+		if len(filled) > 0 {
+			// 当前层成交后按最新价格挂下一层
+			bt.placeSlowLayer(t, price, bt.slowLayer+1)
+		} else if len(bt.entries) > 0 && t.Sub(bt.layerStart) >= time.Duration(bt.strategy.SlowIcebergTimeout)*time.Minute {
+			// 当前层超时，撤单后按最新价格重新挂单
+			bt.entries = nil
+			bt.placeSlowLayer(t, price, bt.slowLayer)
+		}
+		return
+	}
+
+	if len(bt.entries) == 0 {
+		bt.finishEntries(t)
+	} else if !t.Before(bt.entryDeadline) {
+		// 超时取消未成交订单
+		bt.entries = nil
+		bt.finishEntries(t)
+	}
+}
+
+// placeSlowLayer 从第 layer 层开始挂出慢冰山下一笔委托，数量太小的层会被跳过
+func (bt *futuresBacktest) placeSlowLayer(t time.Time, basePrice float64, layer int) {
+	totalOrderValue := bt.strategy.Quantity.InexactFloat64() * float64(bt.strategy.Leverage)
+	for ; layer < len(bt.quantities); layer++ {
+		price := makerEntryPrice(&bt.strategy, basePrice, bt.priceGaps[layer], bt.filters.TickSize)
+		quantity := bt.floorToStep(totalOrderValue * bt.quantities[layer] / price)
+		if quantity < bt.filters.MinQty {
+			continue
+		}
+		bt.slowLayer = layer
+		bt.layerStart = t
+		bt.placeEntry(t, layer, price, quantity)
+		if len(bt.entries) > 0 {
+			return
+		}
+		// 立即成交时继续下一层
+	}
+
+	// 所有层都已完成
+	bt.slowLayer = len(bt.quantities)
+	bt.finishEntries(t)
+}
+
+// finishEntries 开仓阶段结束：无成交则回到等待状态，持仓已全部平掉则结束周期
+func (bt *futuresBacktest) finishEntries(t time.Time) {
+	bt.entriesDone = true
+	if bt.cycle.EntryFills == 0 {
+		bt.result.EntryTimeouts++
+		bt.status = "waiting"
+		bt.cycle = nil
+		return
+	}
+	bt.status = "position_opened"
+	if bt.positionQty <= bt.quantityEpsilon() {
+		bt.completeCycle(t, bt.lastExit)
+	}
+}
+
+// fillEntry 开仓成交：更新持仓并挂出对应的止盈止损
+func (bt *futuresBacktest) fillEntry(t time.Time, order *futuresBacktestOrder, price float64, maker bool) {
+	strategy := &bt.strategy
+	bt.chargeFee(price*order.quantity, maker)
+
+	if bt.cycle.EntryFills == 0 {
+		bt.cycle.OpenedAt = t
+	}
+	bt.cycle.EntryFills++
+	bt.status = "position_opened"
+
+	bt.entryPrice = (bt.entryPrice*bt.positionQty + price*order.quantity) / (bt.positionQty + order.quantity)
+	bt.positionQty += order.quantity
+	bt.entryNotional += price * order.quantity
+	bt.cycle.Quantity = math.Max(bt.cycle.Quantity, bt.positionQty)
+	bt.cycle.LiquidationPrice = bt.liquidationPrice()
+
+	// 简单策略使用策略级止盈止损价格，冰山策略按每层成交价单独计算
+	var takeProfitPrice, stopLossPrice float64
+	if strategy.StrategyType == "iceberg" || strategy.StrategyType == "slow_iceberg" {
+		takeProfitPrice = layerTakeProfitPrice(strategy, price)
+		stopLossPrice = layerStopLossPrice(strategy, price)
+	} else {
+		if strategy.TakeProfitRate > 0 {
+			takeProfitPrice = strategy.TakeProfitPrice.InexactFloat64()
+		}
+		if strategy.StopLossRate > 0 {
+			stopLossPrice = strategy.StopLossPrice.InexactFloat64()
+		}
+	}
+
+	// 跟踪止盈替代固定止盈
+	if strategy.TrailingEnabled() && strategy.TrailingTakeProfit {
+		takeProfitPrice = 0
+	}
+
+	if takeProfitPrice > 0 {
+		bt.placeExit(t, &futuresBacktestOrder{purpose: "take_profit", layer: order.layer, price: takeProfitPrice, quantity: order.quantity})
+	}
+	if stopLossPrice > 0 {
+		bt.placeExit(t, &futuresBacktestOrder{purpose: "stop_loss", layer: order.layer, price: stopLossPrice, quantity: order.quantity})
+	}
+}
+
+// placeExit 挂出平仓委托，已经满足成交或触发条件的立即成交
+func (bt *futuresBacktest) placeExit(t time.Time, order *futuresBacktestOrder) {
+	bt.exits = append(bt.exits, order)
+	if bt.lastPrice > 0 && bt.exitCrossed(order, bt.lastPrice, true) {
+		bt.fillExit(t, order, bt.lastPrice, false)
+	}
+}
+
+// exitCrossed 判断平仓委托是否成交：止盈限价单需要价格严格穿过，止损单价格触及即触发；
+// immediate 为 true 时判断的是挂单时是否已穿价
+func (bt *futuresBacktest) exitCrossed(order *futuresBacktestOrder, price float64, immediate bool) bool {
+	long := bt.strategy.Side == "LONG"
+	if order.purpose == "stop_loss" {
+		if long {
+			return price <= order.price
+		}
+		return price >= order.price
+	}
+	if immediate {
+		if long {
+			return price >= order.price
+		}
+		return price <= order.price
+	}
+	if long {
+		return price > order.price
+	}
+	return price < order.price
+}
+
+// matchExits 撮合止盈止损委托
+func (bt *futuresBacktest) matchExits(t time.Time, price float64) {
+	for _, order := range append([]*futuresBacktestOrder(nil), bt.exits...) {
+		if bt.positionQty <= 0 {
+			break
+		}
+		if !bt.hasExit(order) || !bt.exitCrossed(order, price, false) {
+			continue
+		}
+		if order.purpose == "stop_loss" {
+			bt.fillExit(t, order, price, false)
+		} else {
+			bt.fillExit(t, order, order.price, true)
+		}
+	}
+}
+
+// fillExit 平仓成交，同一层的另一笔止盈/止损委托随之撤销
+func (bt *futuresBacktest) fillExit(t time.Time, order *futuresBacktestOrder, price float64, maker bool) {
+	quantity := math.Min(order.quantity, bt.positionQty)
+	bt.chargeFee(price*quantity, maker)
+	bt.realize(bt.pnlAt(price, quantity))
+
+	bt.positionQty -= quantity
+	bt.exitNotional += price * quantity
+	bt.exitQty += quantity
+	bt.lastExit = order.purpose
+	switch order.purpose {
+	case "take_profit":
+		bt.cycle.TakeProfits++
+	case "trailing_stop":
+		bt.cycle.TrailingStops++
+	default:
+		bt.cycle.StopLosses++
+	}
+
+	remaining := bt.exits[:0]
+	for _, o := range bt.exits {
+		if o == order || o.layer == order.layer {
+			continue
+		}
+		remaining = append(remaining, o)
+	}
+	bt.exits = remaining
+
+	if bt.positionQty <= bt.quantityEpsilon() && bt.entriesDone {
+		bt.completeCycle(t, order.purpose)
+	}
+}
+
+// checkTrailing 跟踪止损：与实盘一样按标记价格推进最高/最低价，标记价格回调到止损价时以成交价吃单平掉全部持仓
+func (bt *futuresBacktest) checkTrailing(t time.Time, markPrice float64) {
+	strategy := &bt.strategy
+	mark := decimal.NewFromFloat(markPrice)
+	if strategy.TrailingActivated && trailingStopCrossed(strategy, mark) {
+		price := bt.lastPrice
+		if price <= 0 {
+			price = markPrice
+		}
+		// 跟踪止损平掉全部持仓，其余止盈止损随之撤销
+		bt.exits = nil
+		bt.fillExit(t, &futuresBacktestOrder{purpose: "trailing_stop", layer: -1, quantity: bt.positionQty}, price, false)
+		return
+	}
+	advanceTrailing(strategy, trailingActivationPrice(strategy, decimal.NewFromFloat(bt.entryPrice)), mark)
+}
+
+func (bt *futuresBacktest) hasExit(order *futuresBacktestOrder) bool {
+	for _, o := range bt.exits {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldLiquidate 保证金加浮动盈亏低于维持保证金时强平；逐仓保证金为开仓价值/杠杆，全仓为钱包余额
+func (bt *futuresBacktest) shouldLiquidate() bool {
+	unrealized := bt.pnlAt(bt.markPrice, bt.positionQty)
+	maintenance := bt.positionQty * bt.markPrice * bt.opts.MaintenanceMarginRate
+	return bt.margin()+unrealized <= maintenance
+}
+
+func (bt *futuresBacktest) margin() float64 {
+	if bt.strategy.MarginType == "ISOLATED" {
+		return bt.positionQty * bt.entryPrice / float64(bt.strategy.Leverage)
+	}
+	return bt.balance
+}
+
+// liquidationPrice 当前持仓的强平价格
+func (bt *futuresBacktest) liquidationPrice() float64 {
+	if bt.positionQty <= 0 {
+		return 0
+	}
+	q, e, m, r := bt.positionQty, bt.entryPrice, bt.margin(), bt.opts.MaintenanceMarginRate
+	var price float64
+	if bt.strategy.Side == "LONG" {
+		price = (e*q - m) / (q * (1 - r))
+	} else {
+		price = (e*q + m) / (q * (1 + r))
+	}
+	return math.Max(price, 0)
+}
+
+// liquidate 强平：损失全部保证金，撤销所有委托，策略不再自动重启
+func (bt *futuresBacktest) liquidate(t time.Time) {
+	bt.realize(-bt.margin())
+	bt.exitNotional += bt.liquidationPrice() * bt.positionQty
+	bt.exitQty += bt.positionQty
+	bt.positionQty = 0
+	bt.entries = nil
+	bt.entriesDone = true
+	bt.result.Liquidations++
+	bt.completeCycle(t, "liquidation")
+}
+
+// completeCycle 结束当前周期，止盈止损平仓且开启自动重启时回到等待状态
+func (bt *futuresBacktest) completeCycle(t time.Time, reason string) {
+	cycle := bt.cycle
+	cycle.ClosedAt = &t
+	cycle.ExitReason = reason
+	if bt.exitQty > 0 {
+		cycle.ExitPrice = bt.exitNotional / bt.exitQty
+	}
+	bt.closeCycle()
+
+	bt.exits = nil
+	bt.entries = nil
+	bt.positionQty = 0
+	bt.strategy.EntryPrice, bt.strategy.TakeProfitPrice, bt.strategy.StopLossPrice = decimal.Zero, decimal.Zero, decimal.Zero
+	bt.strategy.TrailingActivated, bt.strategy.TrailingHighWater, bt.strategy.TrailingStopPrice = false, decimal.Zero, decimal.Zero
+
+	if reason != "liquidation" && bt.strategy.AutoRestart &&
+		(bt.opts.MaxCycles == 0 || len(bt.result.Cycles) < bt.opts.MaxCycles) {
+		bt.result.Cycles[len(bt.result.Cycles)-1].AutoRestarted = true
+		bt.result.AutoRestarts++
+		bt.status = "waiting"
+		return
+	}
+	bt.status = "completed"
+}
+
+// closeCycle 汇总当前周期并重置持仓统计
+func (bt *futuresBacktest) closeCycle() {
+	cycle := bt.cycle
+	if bt.positionQty+bt.exitQty > 0 {
+		cycle.EntryPrice = bt.entryNotional / (bt.positionQty + bt.exitQty)
+	}
+	cycle.NetPnl = cycle.RealizedPnl + cycle.UnrealizedPnl - cycle.Fees + cycle.Funding
+	bt.result.Cycles = append(bt.result.Cycles, *cycle)
+
+	bt.cycle = nil
+	bt.entryPrice, bt.entryNotional = 0, 0
+	bt.exitNotional, bt.exitQty = 0, 0
+	bt.lastExit = ""
+}
+
+// cancelStrategy 与实盘一致，开仓参数无效时策略被取消
+func (bt *futuresBacktest) cancelStrategy(reason string) {
+	bt.status = "cancelled"
+	bt.result.StatusReason = reason
+	bt.cycle = nil
+}
+
+// finish 回放结束，未平仓的周期按最后标记价格计算浮动盈亏
+func (bt *futuresBacktest) finish() {
+	if bt.cycle != nil && bt.cycle.EntryFills > 0 {
+		bt.cycle.ExitReason = "open"
+		bt.cycle.UnrealizedPnl = bt.pnlAt(bt.markPrice, bt.positionQty)
+		if bt.exitQty > 0 {
+			bt.cycle.ExitPrice = bt.exitNotional / bt.exitQty
+		}
+		unrealized := bt.cycle.UnrealizedPnl
+		bt.closeCycle()
+		bt.recordEquityWith(bt.result.End, unrealized, true)
+	} else {
+		bt.recordEquityWith(bt.result.End, 0, true)
+	}
+
+	result := bt.result
+	result.FinalStatus = bt.status
+	for _, cycle := range result.Cycles {
+		result.TakeProfits += cycle.TakeProfits
+		result.StopLosses += cycle.StopLosses
+		result.TrailingStops += cycle.TrailingStops
+		result.RealizedPnl += cycle.RealizedPnl
+		result.Fees += cycle.Fees
+		result.Funding += cycle.Funding
+		result.NetPnl += cycle.NetPnl
+	}
+	if len(result.EquityCurve) > 0 {
+		result.FinalEquity = result.EquityCurve[len(result.EquityCurve)-1].Equity
+	}
+}
+
+func (bt *futuresBacktest) chargeFee(notional float64, maker bool) {
+	rate := bt.opts.TakerFeeRate
+	if maker {
+		rate = bt.opts.MakerFeeRate
+	}
+	fee := notional * rate
+	bt.balance -= fee
+	bt.cycle.Fees += fee
+}
+
+func (bt *futuresBacktest) realize(pnl float64) {
+	bt.balance += pnl
+	bt.cycle.RealizedPnl += pnl
+}
+
+// pnlAt 按指定价格计算持仓盈亏
+func (bt *futuresBacktest) pnlAt(price, quantity float64) float64 {
+	if bt.strategy.Side == "LONG" {
+		return (price - bt.entryPrice) * quantity
+	}
+	return (bt.entryPrice - price) * quantity
+}
+
+func (bt *futuresBacktest) floorToStep(quantity float64) float64 {
+	return services.RoundToStep(quantity, bt.filters.StepSize, services.RoundDown)
+}
+
+// quantityEpsilon 浮点误差范围内视为持仓已归零
+func (bt *futuresBacktest) quantityEpsilon() float64 {
+	return bt.filters.StepSize / 2
+}
+
+// recordEquity 更新最大回撤并按间隔采样资金曲线
+func (bt *futuresBacktest) recordEquity(t time.Time, force bool) {
+	var unrealized float64
+	if bt.positionQty > 0 {
+		unrealized = bt.pnlAt(bt.markPrice, bt.positionQty)
+	}
+	bt.recordEquityWith(t, unrealized, force)
+}
+
+func (bt *futuresBacktest) recordEquityWith(t time.Time, unrealized float64, force bool) {
+	equity := bt.balance + unrealized
+	if equity > bt.peak {
+		bt.peak = equity
+	}
+	if drawdown := bt.peak - equity; drawdown > bt.result.MaxDrawdown {
+		bt.result.MaxDrawdown = drawdown
+		if bt.peak > 0 {
+			bt.result.MaxDrawdownPct = drawdown / bt.peak * 100
+		}
+	}
+
+	if force || bt.lastSample.IsZero() || t.Sub(bt.lastSample) >= bt.curveStep {
+		bt.result.EquityCurve = append(bt.result.EquityCurve, EquityPoint{Time: t, Equity: equity, MarkPrice: bt.markPrice})
+		bt.lastSample = t
+	}
+}
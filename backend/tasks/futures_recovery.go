@@ -0,0 +1,372 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+)
+
+// startFuturesExecution 开仓订单挂出后记录执行状态，同时清理该策略上一轮残留的记录
+func startFuturesExecution(cfg *config.Config, strategy *models.FuturesStrategy, orderIDs ...int64) *models.FuturesExecution {
+	now := time.Now()
+	exec := &models.FuturesExecution{
+		StrategyID:     strategy.ID,
+		StrategyType:   strategy.StrategyType,
+		OrderIDs:       joinOrderIDs(orderIDs),
+		StartedAt:      now,
+		LayerStartedAt: now,
+	}
+	cfg.DB.Unscoped().Where("strategy_id = ?", strategy.ID).Delete(&models.FuturesExecution{})
+	saveFuturesExecution(cfg, exec)
+	return exec
+}
+
+// saveFuturesExecution 保存执行状态，失败只记录日志，不影响订单监控
+func saveFuturesExecution(cfg *config.Config, exec *models.FuturesExecution) {
+	if err := cfg.DB.Save(exec).Error; err != nil {
+		log.Printf("保存策略 %d 执行状态失败: %v", exec.StrategyID, err)
+	}
+}
+
+// finishFuturesExecution 开仓流程结束，删除执行状态
+func finishFuturesExecution(cfg *config.Config, exec *models.FuturesExecution) {
+	if err := cfg.DB.Unscoped().Where("strategy_id = ?", exec.StrategyID).
+		Delete(&models.FuturesExecution{}).Error; err != nil {
+		log.Printf("删除策略 %d 执行状态失败: %v", exec.StrategyID, err)
+	}
+}
+
+// entryClientOrderPrefix 策略开仓订单自定义ID的前缀
+func entryClientOrderPrefix(strategyID uint) string {
+	return fmt.Sprintf("fs%d-", strategyID)
+}
+
+// entryClientOrderID 生成开仓订单的自定义ID，重启后按前缀找回挂出但未记录的订单
+func entryClientOrderID(strategyID uint) string {
+	return fmt.Sprintf("%s%d", entryClientOrderPrefix(strategyID), time.Now().UnixNano())
+}
+
+// parseOrderIDs 解析逗号分隔的订单ID
+func parseOrderIDs(s string) []int64 {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// joinOrderIDs 将订单ID拼接为逗号分隔的字符串
+func joinOrderIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// applyEntryFill 开仓订单成交后挂出该部分的止盈止损并更新持仓，layerIndex 为-1表示普通冰山或补处理的订单
+func applyEntryFill(cfg *config.Config, exchange services.Exchange, strategy *models.FuturesStrategy,
+	orderID int64, execQty, avgPrice decimal.Decimal, layerIndex int) {
+
+	if !strategy.TrailingEnabled() || !strategy.TrailingTakeProfit {
+		createLayerTakeProfitOrder(cfg, exchange, strategy, execQty, avgPrice, layerIndex)
+	}
+	if strategy.StopLossRate > 0 {
+		createLayerStopLossOrder(cfg, exchange, strategy, execQty, avgPrice, layerIndex)
+	}
+
+	// 创建或更新持仓记录
+	updateOrCreatePosition(cfg, strategy, avgPrice, execQty, orderID, services.IsPaperExchange(exchange))
+
+	// 按最新持仓数量挂出跟踪止损
+	syncTrailingStop(cfg, exchange, strategy)
+}
+
+// recoverFuturesStrategies 服务启动时恢复开仓阶段被中断的期货策略：
+// 有执行状态的从记录的层和订单继续监控，已触发但没有执行状态的（挂单前或挂单瞬间中断）
+// 撤销残留的开仓订单，补处理已成交部分，再按持仓决定策略状态
+func recoverFuturesStrategies(ctx context.Context, cfg *config.Config) {
+	var executions []models.FuturesExecution
+	if err := cfg.DB.Find(&executions).Error; err != nil {
+		log.Printf("加载期货策略执行状态失败: %v", err)
+		return
+	}
+
+	resumed := make(map[uint]bool)
+	for i := range executions {
+		exec := &executions[i]
+
+		var strategy models.FuturesStrategy
+		if err := cfg.DB.First(&strategy, exec.StrategyID).Error; err != nil ||
+			(strategy.Status != "triggered" && strategy.Status != "position_opened") {
+			// 策略已删除或已结束，执行状态作废
+			finishFuturesExecution(cfg, exec)
+			continue
+		}
+
+		if err := resumeFuturesExecution(ctx, cfg, &strategy, exec); err != nil {
+			log.Printf("恢复期货策略 %d 开仓监控失败: %v", strategy.ID, err)
+			continue
+		}
+		resumed[strategy.ID] = true
+		log.Printf("恢复期货策略 %d 开仓监控: 类型=%s, 层=%d, 订单=%s",
+			strategy.ID, exec.StrategyType, exec.Layer+1, exec.OrderIDs)
+	}
+
+	var triggered []models.FuturesStrategy
+	if err := cfg.DB.Where("status = ?", "triggered").Find(&triggered).Error; err != nil {
+		log.Printf("加载已触发的期货策略失败: %v", err)
+		return
+	}
+	for i := range triggered {
+		if resumed[triggered[i].ID] {
+			continue
+		}
+		finalizeInterruptedStrategy(cfg, &triggered[i])
+	}
+}
+
+// resumeFuturesExecution 按执行状态重新启动对应的开仓订单监控
+func resumeFuturesExecution(ctx context.Context, cfg *config.Config, strategy *models.FuturesStrategy,
+	exec *models.FuturesExecution) error {
+
+	var user models.User
+	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
+		return err
+	}
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
+	if err != nil {
+		return err
+	}
+
+	// 执行状态之外的开仓订单是挂出后未来得及记录的，撤销未完成的并补处理已成交部分
+	known := make(map[int64]bool)
+	for _, id := range parseOrderIDs(exec.OrderIDs) {
+		known[id] = true
+	}
+	for _, id := range parseOrderIDs(exec.DoneOrderIDs) {
+		known[id] = true
+	}
+	if err := reconcileOrphanEntryOrders(cfg, exchange, strategy, known); err != nil {
+		return err
+	}
+
+	switch exec.StrategyType {
+	case "iceberg":
+		spawnTask(ctx, "冰山订单监控", func() { monitorIcebergOrders(ctx, cfg, strategy, exec) })
+	case "slow_iceberg":
+		// 重新获取交易规则，层配置从策略中解析
+		filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
+		if err != nil {
+			return err
+		}
+		quantities := parseQuantities(strategy.IcebergQuantities)
+		priceGaps := parsePriceGaps(strategy.IcebergPriceGaps, strategy.Side)
+		spawnTask(ctx, "慢冰山订单监控", func() {
+			monitorSlowIcebergOrders(ctx, cfg, strategy, exec, quantities, priceGaps, filters)
+		})
+	default:
+		spawnTask(ctx, "开仓订单监控", func() { monitorEntryOrder(ctx, cfg, strategy, exec) })
+	}
+	return nil
+}
+
+// finalizeInterruptedStrategy 收尾没有执行状态的已触发策略
+func finalizeInterruptedStrategy(cfg *config.Config, strategy *models.FuturesStrategy) {
+	var user models.User
+	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
+		return
+	}
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
+	if err != nil {
+		log.Printf("收尾期货策略 %d 失败: %v", strategy.ID, err)
+		return
+	}
+
+	var orders []models.FuturesOrder
+	known := make(map[int64]bool)
+	cfg.DB.Where("strategy_id = ? AND order_purpose = ? AND status IN ?",
+		strategy.ID, "entry", []string{string(futures.OrderStatusTypeNew), string(futures.OrderStatusTypePartiallyFilled)}).
+		Find(&orders)
+
+	for _, dbOrder := range orders {
+		known[dbOrder.OrderID] = true
+		order, err := exchange.GetFuturesOrder(context.Background(), strategy.Symbol, dbOrder.OrderID)
+		if err != nil {
+			log.Printf("查询期货策略 %d 残留订单 %d 失败: %v", strategy.ID, dbOrder.OrderID, err)
+			continue
+		}
+
+		// 未完成的开仓订单无法确定所属的层和计划，直接撤销
+		if order.Status == futures.OrderStatusTypeNew || order.Status == futures.OrderStatusTypePartiallyFilled {
+			if err := exchange.CancelFuturesOrder(context.Background(), strategy.Symbol, dbOrder.OrderID); err != nil {
+				log.Printf("撤销期货策略 %d 残留订单 %d 失败: %v", strategy.ID, dbOrder.OrderID, err)
+				continue
+			}
+			if order, err = exchange.GetFuturesOrder(context.Background(), strategy.Symbol, dbOrder.OrderID); err != nil {
+				continue
+			}
+		}
+
+		execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
+		avgPrice, _ := decimal.NewFromString(order.AvgPrice)
+		cfg.DB.Model(&models.FuturesOrder{}).
+			Where("order_id = ?", dbOrder.OrderID).
+			Updates(map[string]interface{}{
+				"status":       string(order.Status),
+				"executed_qty": execQty,
+				"avg_price":    avgPrice,
+			})
+
+		if execQty.IsPositive() {
+			log.Printf("期货策略 %d 残留订单 %d 已成交 %s，补挂止盈止损", strategy.ID, dbOrder.OrderID, execQty)
+			applyEntryFill(cfg, exchange, strategy, dbOrder.OrderID, execQty, avgPrice, -1)
+		}
+	}
+
+	// 交易所上有、但数据库没有记录的开仓订单，包括重启前已经成交的
+	if err := reconcileOrphanEntryOrders(cfg, exchange, strategy, known); err != nil {
+		log.Printf("检查期货策略 %d 交易所订单失败: %v", strategy.ID, err)
+	}
+
+	// 有持仓则进入持仓阶段，否则重置为等待状态重新触发
+	var position models.FuturesPosition
+	if cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "open").First(&position).Error == nil {
+		if strategy.Status != "position_opened" {
+			strategy.Status = "position_opened"
+			cfg.DB.Omit(trailingStateColumns...).Save(strategy)
+			publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
+		}
+		log.Printf("期货策略 %d 开仓中断，按已有持仓进入持仓阶段", strategy.ID)
+		return
+	}
+
+	// 数据库没有持仓时再核对交易所：有同方向持仓说明可能有未找回的成交，重新触发会重复开仓，
+	// 且这部分持仓没有止盈止损，停用策略交由用户处理
+	held, err := futuresPositionHeld(exchange, strategy)
+	if err != nil || held {
+		strategy.Status = "waiting"
+		strategy.Enabled = false
+		strategy.TriggeredAt = nil
+		cfg.DB.Save(strategy)
+		publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
+		if err != nil {
+			log.Printf("期货策略 %d 开仓中断且无法确认交易所持仓，停用策略: %v", strategy.ID, err)
+		} else {
+			log.Printf("期货策略 %d 开仓中断，交易所有 %s %s 持仓但没有记录，停用策略避免重复开仓",
+				strategy.ID, strategy.Symbol, strategy.Side)
+		}
+		return
+	}
+
+	strategy.Status = "waiting"
+	strategy.TriggeredAt = nil
+	cfg.DB.Save(strategy)
+	publishStrategyState(strategy.UserID, "futures", strategy.ID, strategy.Status)
+	log.Printf("期货策略 %d 开仓中断且无持仓，重置为等待状态", strategy.ID)
+}
+
+// futuresPositionHeld 交易所上是否有策略方向的持仓，双向持仓模式按持仓方向，单向持仓模式按数量正负
+func futuresPositionHeld(exchange services.Exchange, strategy *models.FuturesStrategy) (bool, error) {
+	positions, err := exchange.GetPositionRisk(context.Background(), strategy.Symbol)
+	if err != nil {
+		return false, fmt.Errorf("查询交易所持仓失败: %v", err)
+	}
+	for _, position := range positions {
+		amount, err := decimal.NewFromString(position.PositionAmt)
+		if err != nil || amount.IsZero() {
+			continue
+		}
+		switch position.PositionSide {
+		case strategy.Side:
+			return true, nil
+		case string(futures.PositionSideTypeBoth):
+			if amount.IsPositive() == (strategy.Side == "LONG") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// reconcileOrphanEntryOrders 按自定义ID前缀在交易所的全部订单中查找属于该策略、但不在 known 中
+// 也没有订单记录的开仓订单：未完成的撤销，已成交部分补挂止盈止损，并补记订单避免再次处理
+func reconcileOrphanEntryOrders(cfg *config.Config, exchange services.Exchange, strategy *models.FuturesStrategy,
+	known map[int64]bool) error {
+
+	// 只查本轮触发之后的订单，之前轮次的订单都已记录
+	var startTime int64
+	if strategy.TriggeredAt != nil {
+		startTime = strategy.TriggeredAt.Add(-time.Minute).UnixMilli()
+	}
+	orders, err := exchange.ListFuturesOrders(context.Background(), strategy.Symbol, startTime)
+	if err != nil {
+		return fmt.Errorf("查询交易所订单失败: %v", err)
+	}
+
+	prefix := entryClientOrderPrefix(strategy.ID)
+	for _, order := range orders {
+		if known[order.OrderID] || !strings.HasPrefix(order.ClientOrderID, prefix) {
+			continue
+		}
+		var recorded int64
+		cfg.DB.Model(&models.FuturesOrder{}).Where("order_id = ? AND strategy_id = ?", order.OrderID, strategy.ID).Count(&recorded)
+		if recorded > 0 {
+			continue
+		}
+
+		if order.Status == futures.OrderStatusTypeNew || order.Status == futures.OrderStatusTypePartiallyFilled {
+			if err := exchange.CancelFuturesOrder(context.Background(), strategy.Symbol, order.OrderID); err != nil {
+				log.Printf("撤销期货策略 %d 未记录的开仓订单 %d 失败: %v", strategy.ID, order.OrderID, err)
+				continue
+			}
+			log.Printf("期货策略 %d 撤销未记录的开仓订单 %d", strategy.ID, order.OrderID)
+			if order, err = exchange.GetFuturesOrder(context.Background(), strategy.Symbol, order.OrderID); err != nil {
+				log.Printf("查询期货策略 %d 未记录的开仓订单 %d 失败: %v", strategy.ID, order.OrderID, err)
+				continue
+			}
+		}
+
+		price, _ := decimal.NewFromString(order.Price)
+		quantity, _ := decimal.NewFromString(order.OrigQuantity)
+		execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
+		avgPrice, _ := decimal.NewFromString(order.AvgPrice)
+		dbOrder := models.FuturesOrder{
+			UserID:       strategy.UserID,
+			AccountID:    strategy.AccountID,
+			StrategyID:   strategy.ID,
+			Symbol:       strategy.Symbol,
+			Side:         string(order.Side),
+			PositionSide: strategy.Side,
+			Type:         string(order.Type),
+			Price:        price,
+			Quantity:     quantity,
+			ExecutedQty:  execQty,
+			AvgPrice:     avgPrice,
+			OrderID:      order.OrderID,
+			Status:       string(order.Status),
+			OrderPurpose: "entry",
+			Paper:        services.IsPaperExchange(exchange),
+		}
+		if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+			log.Printf("补记期货策略 %d 开仓订单 %d 失败: %v", strategy.ID, order.OrderID, err)
+		}
+
+		if execQty.IsPositive() {
+			log.Printf("期货策略 %d 未记录的开仓订单 %d 已成交 %s，补挂止盈止损", strategy.ID, order.OrderID, execQty)
+			applyEntryFill(cfg, exchange, strategy, order.OrderID, execQty, avgPrice, -1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package tasks
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseFuturesOrderValues(t *testing.T) {
+	price, quantity, err := parseFuturesOrderValues("25000.10", "0.003")
+	if err != nil {
+		t.Fatalf("parseFuturesOrderValues: %v", err)
+	}
+	if price.String() != "25000.1" || quantity.String() != "0.003" {
+		t.Errorf("price=%s quantity=%s", price, quantity)
+	}
+
+	// 格式异常时返回错误，不能 panic
+	for _, c := range [][2]string{{"", "1"}, {"1", "NaN"}, {"1e", "1"}} {
+		if _, _, err := parseFuturesOrderValues(c[0], c[1]); err == nil {
+			t.Errorf("parseFuturesOrderValues(%q, %q) 应返回错误", c[0], c[1])
+		}
+	}
+}
+
+func TestFuturesStrategyOrderValue(t *testing.T) {
+	strategy := models.FuturesStrategy{Quantity: decimal.RequireFromString("33.3"), Leverage: 3}
+	if got := strategy.OrderValue(); got.String() != "99.9" {
+		t.Errorf("OrderValue = %s, want 99.9", got)
+	}
+}
+
+func TestFuturesStrategyTriggered(t *testing.T) {
+	long := &models.FuturesStrategy{Side: "LONG", BasePrice: decimal.RequireFromString("0.3")}
+	if !futuresStrategyTriggered(long, 0.1+0.2) {
+		t.Error("做多策略在标记价格等于基准价时应触发")
+	}
+	if futuresStrategyTriggered(long, 0.31) {
+		t.Error("做多策略在标记价格高于基准价时不应触发")
+	}
+	short := &models.FuturesStrategy{Side: "SHORT", BasePrice: decimal.RequireFromString("100")}
+	if !futuresStrategyTriggered(short, 100.5) || futuresStrategyTriggered(short, 99.5) {
+		t.Error("做空策略应在标记价格不低于基准价时触发")
+	}
+}
+
+func TestAdvanceTrailingUsesExactPrices(t *testing.T) {
+	strategy := &models.FuturesStrategy{Side: "LONG", TrailingCallbackRate: 1}
+	activation := decimal.RequireFromString("105")
+
+	if advanceTrailing(strategy, activation, decimal.RequireFromString("104.99")) {
+		t.Fatal("未到激活价时不应激活")
+	}
+	if !advanceTrailing(strategy, activation, decimal.RequireFromString("110")) {
+		t.Fatal("达到激活价时应激活")
+	}
+	// 110×(1-1%) 按定点计算正好是108.9，不能因二进制误差多出一个tick
+	if strategy.TrailingStopPrice.String() != "108.9" {
+		t.Errorf("TrailingStopPrice = %s, want 108.9", strategy.TrailingStopPrice)
+	}
+	if advanceTrailing(strategy, activation, decimal.RequireFromString("109.5")) {
+		t.Error("价格回落时最高价和止损价不应变化")
+	}
+	if !trailingStopCrossed(strategy, decimal.RequireFromString("108.9")) {
+		t.Error("标记价格回调到止损价时应触发")
+	}
+
+	short := &models.FuturesStrategy{Side: "SHORT", TrailingCallbackRate: 0.5}
+	advanceTrailing(short, decimal.Zero, decimal.RequireFromString("200"))
+	if short.TrailingStopPrice.String() != "201" || trailingStopCrossed(short, decimal.RequireFromString("200.99")) {
+		t.Errorf("做空跟踪止损价 = %s", short.TrailingStopPrice)
+	}
+}
+
+func TestFuturesPositionHeldFindsUnrecordedFill(t *testing.T) {
+	f := services.NewFakeExchange()
+	f.AddSymbol(services.FakeSymbol{Symbol: "BTCUSDT", TickSize: 0.01, StepSize: 0.001, MinQty: 0.001}, 100)
+	f.SetFuturesBalance(1000)
+	strategy := &models.FuturesStrategy{Symbol: "BTCUSDT", Side: "LONG"}
+	strategy.ID = 7
+
+	if held, err := futuresPositionHeld(f, strategy); err != nil || held {
+		t.Fatalf("没有持仓时 held=%v err=%v", held, err)
+	}
+
+	// 开仓订单挂出后还没记录就成交了：挂单列表里已经没有它，只能从全部订单按前缀找回
+	ctx := context.Background()
+	if _, err := f.CreateFuturesOrder(ctx, services.FuturesOrderRequest{
+		Symbol:        "BTCUSDT",
+		Side:          futures.SideTypeBuy,
+		PositionSide:  futures.PositionSideTypeLong,
+		Type:          futures.OrderTypeMarket,
+		Quantity:      "0.5",
+		ClientOrderID: entryClientOrderID(strategy.ID),
+	}); err != nil {
+		t.Fatalf("CreateFuturesOrder: %v", err)
+	}
+	if open, _ := f.ListFuturesOpenOrders(ctx, "BTCUSDT"); len(open) != 0 {
+		t.Fatalf("市价单不应留在挂单列表: %d", len(open))
+	}
+	orders, err := f.ListFuturesOrders(ctx, "BTCUSDT", 0)
+	if err != nil || len(orders) != 1 || !strings.HasPrefix(orders[0].ClientOrderID, entryClientOrderPrefix(strategy.ID)) ||
+		orders[0].Status != futures.OrderStatusTypeFilled {
+		t.Fatalf("全部订单中应能按前缀找到已成交的开仓订单: %+v err=%v", orders, err)
+	}
+
+	if held, err := futuresPositionHeld(f, strategy); err != nil || !held {
+		t.Errorf("有多头持仓时 held=%v err=%v", held, err)
+	}
+	short := &models.FuturesStrategy{Symbol: "BTCUSDT", Side: "SHORT"}
+	if held, _ := futuresPositionHeld(f, short); held {
+		t.Error("多头持仓不应算作空头策略的持仓")
+	}
+}
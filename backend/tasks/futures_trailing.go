@@ -0,0 +1,398 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+)
+
+// trailingMinAdjustInterval 本地跟踪止损两次重挂之间的最小间隔，避免频繁撤单触发限频
+const trailingMinAdjustInterval = 5 * time.Second
+
+// trailingStateColumns 由标记价格推进的跟踪状态字段，开仓监控保存策略时不能用内存中的旧值覆盖
+var trailingStateColumns = []string{"trailing_activated", "trailing_high_water", "trailing_stop_price"}
+
+var (
+	trailingBusy       sync.Map // strategyID -> struct{}，正在处理的策略
+	trailingAdjustedAt sync.Map // strategyID -> time.Time，上次重挂本地跟踪止损的时间
+)
+
+// trailingActivationPrice 跟踪激活价格：跟踪止盈以止盈价格激活（冰山策略按层止盈公式计算），否则使用配置的激活价格，0表示立即激活
+func trailingActivationPrice(strategy *models.FuturesStrategy, entryPrice decimal.Decimal) decimal.Decimal {
+	if !strategy.TrailingTakeProfit {
+		return strategy.TrailingActivationPrice
+	}
+	if strategy.StrategyType == "iceberg" || strategy.StrategyType == "slow_iceberg" {
+		return decimal.NewFromFloat(layerTakeProfitPrice(strategy, entryPrice.InexactFloat64()))
+	}
+
+	estimate := *strategy
+	estimate.EntryPrice = entryPrice
+	estimate.TakeProfitPrice = decimal.Zero
+	estimate.CalculateTakeProfitPrice()
+	return estimate.TakeProfitPrice
+}
+
+// trailingActivated 标记价格是否达到激活价格
+func trailingActivated(strategy *models.FuturesStrategy, activationPrice, markPrice decimal.Decimal) bool {
+	if !activationPrice.IsPositive() {
+		return true
+	}
+	if strategy.Side == "LONG" {
+		return markPrice.GreaterThanOrEqual(activationPrice)
+	}
+	return markPrice.LessThanOrEqual(activationPrice)
+}
+
+// trailingStopCrossed 标记价格是否已经回调到跟踪止损价
+func trailingStopCrossed(strategy *models.FuturesStrategy, markPrice decimal.Decimal) bool {
+	if strategy.Side == "LONG" {
+		return markPrice.LessThanOrEqual(strategy.TrailingStopPrice)
+	}
+	return strategy.Side == "SHORT" && markPrice.GreaterThanOrEqual(strategy.TrailingStopPrice)
+}
+
+// advanceTrailing 根据标记价格推进跟踪状态（激活、更新最高/最低价和止损价），返回状态是否变化
+func advanceTrailing(strategy *models.FuturesStrategy, activationPrice, markPrice decimal.Decimal) bool {
+	if !strategy.TrailingActivated {
+		if !trailingActivated(strategy, activationPrice, markPrice) {
+			return false
+		}
+		strategy.TrailingActivated = true
+		strategy.TrailingHighWater = markPrice
+	} else if (strategy.Side == "LONG" && markPrice.GreaterThan(strategy.TrailingHighWater)) ||
+		(strategy.Side == "SHORT" && markPrice.LessThan(strategy.TrailingHighWater)) {
+		strategy.TrailingHighWater = markPrice
+	} else {
+		return false
+	}
+
+	strategy.TrailingStopPrice = strategy.TrailingStopFor(strategy.TrailingHighWater)
+	return true
+}
+
+// syncTrailing 用最新查询结果替换需要跟踪的策略列表
+func (m *FuturesWebSocketManager) syncTrailing(strategies map[uint]*models.FuturesStrategy) {
+	m.trailing.Range(func(key, value interface{}) bool {
+		if _, ok := strategies[key.(uint)]; !ok {
+			m.trailing.Delete(key)
+		}
+		return true
+	})
+	for id, s := range strategies {
+		m.trailing.Store(id, s)
+	}
+}
+
+// checkTrailingStops 标记价格更新时推进开启了跟踪止损的持仓策略
+func (m *FuturesWebSocketManager) checkTrailingStops(markPrice float64) {
+	m.trailing.Range(func(key, value interface{}) bool {
+		strategyID := key.(uint)
+		if _, busy := trailingBusy.LoadOrStore(strategyID, struct{}{}); busy {
+			return true
+		}
+		// 服务停止中不再重挂止损单
+		if !beginOrderPlacement() {
+			trailingBusy.Delete(strategyID)
+			return false
+		}
+		go func() {
+			defer endOrderPlacement()
+			defer trailingBusy.Delete(strategyID)
+			updateTrailingStop(m.cfg, strategyID, markPrice)
+		}()
+		return true
+	})
+}
+
+// updateTrailingStop 更新策略的最高/最低价；本地跟踪模式下止损价改善至少一个tick时重挂止损单，
+// 标记价格已经穿过止损价时直接市价平仓
+func updateTrailingStop(cfg *config.Config, strategyID uint, markPrice float64) {
+	var strategy models.FuturesStrategy
+	if err := cfg.DB.First(&strategy, strategyID).Error; err != nil {
+		return
+	}
+	if strategy.Status != "position_opened" || !strategy.TrailingEnabled() {
+		return
+	}
+
+	var position models.FuturesPosition
+	if err := cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "open").
+		First(&position).Error; err != nil {
+		return
+	}
+
+	mark := decimal.NewFromFloat(markPrice)
+	if advanceTrailing(&strategy, trailingActivationPrice(&strategy, position.EntryPrice), mark) {
+		if err := cfg.DB.Model(&strategy).Updates(map[string]interface{}{
+			"trailing_activated":  strategy.TrailingActivated,
+			"trailing_high_water": strategy.TrailingHighWater,
+			"trailing_stop_price": strategy.TrailingStopPrice,
+		}).Error; err != nil {
+			log.Printf("更新策略 %d 跟踪状态失败: %v", strategy.ID, err)
+			return
+		}
+	}
+
+	// 交易所模式由币安跟踪，这里只记录最高/最低价
+	if strategy.TrailingMode != "local" || !strategy.TrailingActivated {
+		return
+	}
+
+	current := openTrailingOrder(cfg, strategy.ID)
+	crossed := trailingStopCrossed(&strategy, mark)
+	if crossed {
+		// 已挂的止损单同样被穿过时由交易所触发，避免重复平仓
+		if current != nil {
+			if (strategy.Side == "LONG" && mark.LessThanOrEqual(current.Price)) ||
+				(strategy.Side == "SHORT" && mark.GreaterThanOrEqual(current.Price)) {
+				return
+			}
+		}
+		// 已经市价平仓、等待订单检查确认时不再重复下单
+		var closing int64
+		cfg.DB.Model(&models.FuturesOrder{}).
+			Where("strategy_id = ? AND order_purpose = ? AND type = ? AND created_at >= ?",
+				strategy.ID, "trailing_stop", string(futures.OrderTypeMarket), position.OpenedAt).
+			Count(&closing)
+		if closing > 0 {
+			return
+		}
+	} else if current != nil {
+		if last, ok := trailingAdjustedAt.Load(strategy.ID); ok && time.Since(last.(time.Time)) < trailingMinAdjustInterval {
+			return
+		}
+		// 止损价只朝有利方向移动
+		if (strategy.Side == "LONG" && strategy.TrailingStopPrice.LessThanOrEqual(current.Price)) ||
+			(strategy.Side == "SHORT" && strategy.TrailingStopPrice.GreaterThanOrEqual(current.Price)) {
+			return
+		}
+	}
+
+	var user models.User
+	if err := cfg.DB.First(&user, strategy.UserID).Error; err != nil {
+		return
+	}
+	exchange, err := services.NewTradingExchange(cfg.DB, user.ID, strategy.AccountID, strategy.Paper || user.PaperTrading)
+	if err != nil {
+		log.Printf("创建交易所客户端失败: %v", err)
+		return
+	}
+
+	placeLocalTrailingStop(cfg, exchange, &strategy, position.Quantity, current, crossed)
+}
+
+// placeLocalTrailingStop 按当前跟踪止损价挂出新的止损单并撤销上一笔，每次调整都记录在 FuturesOrder 中
+func placeLocalTrailingStop(cfg *config.Config, exchange services.Exchange, strategy *models.FuturesStrategy,
+	quantity decimal.Decimal, current *models.FuturesOrder, crossed bool) {
+
+	stopPrice := strategy.TrailingStopPrice
+	stopQuantity := quantity
+	if filters, err := services.FuturesSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol); err == nil {
+		// 多头止损向下取整，空头向上取整，保证不比计算值更激进
+		mode := services.RoundDown
+		if strategy.Side == "SHORT" {
+			mode = services.RoundUp
+		}
+		stopPrice = services.QuantizeToStep(stopPrice, filters.TickSize, mode)
+		stopQuantity = filters.QuantizeQuantity(stopQuantity, services.RoundDown)
+	}
+	if current != nil && !crossed && stopPrice.Equal(current.Price) && stopQuantity.Equal(current.Quantity) {
+		return // 取整后止损价和数量都没有变化
+	}
+
+	side := futures.SideTypeSell
+	if strategy.Side == "SHORT" {
+		side = futures.SideTypeBuy
+	}
+
+	req := services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeStopMarket,
+		StopPrice:    stopPrice.String(),
+		Quantity:     stopQuantity.String(),
+	}
+	if crossed {
+		// 价格已经穿过止损价，止损单会被拒绝（立即触发），直接市价平仓
+		req.Type = futures.OrderTypeMarket
+		req.StopPrice = ""
+	}
+
+	order, err := exchange.CreateFuturesOrder(context.Background(), req)
+	if err != nil {
+		log.Printf("策略 %d 挂出跟踪止损单失败: %v", strategy.ID, err)
+		return
+	}
+	trailingAdjustedAt.Store(strategy.ID, time.Now())
+
+	dbOrder := models.FuturesOrder{
+		UserID:        strategy.UserID,
+		AccountID:     strategy.AccountID,
+		StrategyID:    strategy.ID,
+		Symbol:        strategy.Symbol,
+		Side:          string(side),
+		PositionSide:  strategy.Side,
+		Type:          string(req.Type),
+		Price:         stopPrice,
+		Quantity:      stopQuantity,
+		OrderID:       order.OrderID,
+		Status:        string(order.Status),
+		OrderPurpose:  "trailing_stop",
+		CallbackRate:  strategy.TrailingCallbackRate,
+		HighWaterMark: strategy.TrailingHighWater,
+		Paper:         services.IsPaperExchange(exchange),
+	}
+	if current != nil {
+		dbOrder.ReplacedOrderID = current.OrderID
+	}
+	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+		log.Printf("保存跟踪止损订单失败: %v", err)
+	}
+
+	// 新单挂出后再撤销旧单，避免调整期间持仓没有保护
+	if current != nil {
+		cancelFuturesOrderRecord(cfg, exchange, current)
+	}
+
+	// 市价平仓可能在下单时就已成交，此时订单检查不会再处理它
+	if order.Status == futures.OrderStatusTypeFilled {
+		avgPrice, _ := decimal.NewFromString(order.AvgPrice)
+		execQty, _ := decimal.NewFromString(order.ExecutedQuantity)
+		handleExitOrderFilled(cfg, exchange, dbOrder, avgPrice, execQty)
+	}
+
+	log.Printf("策略 %d 跟踪止损调整: 最高/最低价=%s, StopPrice=%s, OrderID=%d, 替换=%d",
+		strategy.ID, strategy.TrailingHighWater, stopPrice, order.OrderID, dbOrder.ReplacedOrderID)
+}
+
+// syncTrailingStop 开仓成交后按当前持仓数量挂出跟踪止损；持仓增加（冰山逐层成交）时替换为新数量
+func syncTrailingStop(cfg *config.Config, exchange services.Exchange, strategy *models.FuturesStrategy) {
+	if !strategy.TrailingEnabled() {
+		return
+	}
+
+	var position models.FuturesPosition
+	if err := cfg.DB.Where("strategy_id = ? AND status = ?", strategy.ID, "open").
+		First(&position).Error; err != nil {
+		log.Printf("策略 %d 查询持仓失败，无法挂出跟踪止损: %v", strategy.ID, err)
+		return
+	}
+	current := openTrailingOrder(cfg, strategy.ID)
+
+	if strategy.TrailingMode == "local" {
+		// 本地模式在激活后由标记价格驱动挂单，这里只在已挂单时按新数量重挂
+		var latest models.FuturesStrategy
+		if current != nil && cfg.DB.First(&latest, strategy.ID).Error == nil && latest.TrailingActivated {
+			placeLocalTrailingStop(cfg, exchange, &latest, position.Quantity, current, false)
+		}
+		return
+	}
+
+	side := futures.SideTypeSell
+	if strategy.Side == "SHORT" {
+		side = futures.SideTypeBuy
+	}
+	activationPrice := trailingActivationPrice(strategy, position.EntryPrice)
+	activationStr, quantityStr := futuresOrderStrings(exchange, strategy.Symbol, activationPrice, position.Quantity)
+
+	req := services.FuturesOrderRequest{
+		Symbol:       strategy.Symbol,
+		Side:         side,
+		PositionSide: futures.PositionSideType(strategy.Side),
+		Type:         futures.OrderTypeTrailingStopMarket,
+		Quantity:     quantityStr,
+		CallbackRate: decimal.NewFromFloat(strategy.TrailingCallbackRate).String(),
+	}
+	orderPrice, orderQty, err := parseFuturesOrderValues(activationStr, quantityStr)
+	if err != nil {
+		log.Printf("策略 %d 创建跟踪止损单失败: %v", strategy.ID, err)
+		return
+	}
+	if activationPrice.IsPositive() {
+		req.ActivationPrice = activationStr
+	} else {
+		orderPrice = decimal.Zero
+	}
+
+	order, err := exchange.CreateFuturesOrder(context.Background(), req)
+	if err != nil {
+		log.Printf("策略 %d 创建跟踪止损单失败: %v", strategy.ID, err)
+		return
+	}
+
+	dbOrder := models.FuturesOrder{
+		UserID:          strategy.UserID,
+		AccountID:       strategy.AccountID,
+		StrategyID:      strategy.ID,
+		Symbol:          strategy.Symbol,
+		Side:            string(side),
+		PositionSide:    strategy.Side,
+		Type:            string(futures.OrderTypeTrailingStopMarket),
+		Price:           orderPrice,
+		Quantity:        orderQty,
+		OrderID:         order.OrderID,
+		Status:          string(order.Status),
+		OrderPurpose:    "trailing_stop",
+		ActivationPrice: orderPrice,
+		CallbackRate:    strategy.TrailingCallbackRate,
+		HighWaterMark:   strategy.TrailingHighWater,
+		Paper:           services.IsPaperExchange(exchange),
+	}
+	if current != nil {
+		dbOrder.ReplacedOrderID = current.OrderID
+	}
+	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+		log.Printf("保存跟踪止损订单失败: %v", err)
+	}
+
+	if current != nil {
+		cancelFuturesOrderRecord(cfg, exchange, current)
+	}
+
+	log.Printf("跟踪止损订单创建成功: 策略ID=%d, OrderID=%d, ActivationPrice=%s, CallbackRate=%.1f%%, Quantity=%s",
+		strategy.ID, order.OrderID, orderPrice, strategy.TrailingCallbackRate, position.Quantity)
+}
+
+// openTrailingOrder 查询策略当前未完成的跟踪止损单
+func openTrailingOrder(cfg *config.Config, strategyID uint) *models.FuturesOrder {
+	var order models.FuturesOrder
+	if err := cfg.DB.Where("strategy_id = ? AND order_purpose = ? AND status IN ?",
+		strategyID, "trailing_stop", []string{"NEW", "PARTIALLY_FILLED"}).
+		Order("id desc").First(&order).Error; err != nil {
+		return nil
+	}
+	return &order
+}
+
+// cancelStrategyExitOrders 撤销策略未完成的指定用途平仓单
+func cancelStrategyExitOrders(cfg *config.Config, exchange services.Exchange, strategyID uint, purposes []string) {
+	var orders []models.FuturesOrder
+	if err := cfg.DB.Where("strategy_id = ? AND order_purpose IN ? AND status IN ?",
+		strategyID, purposes, []string{"NEW", "PARTIALLY_FILLED"}).Find(&orders).Error; err != nil {
+		return
+	}
+	for i := range orders {
+		cancelFuturesOrderRecord(cfg, exchange, &orders[i])
+	}
+}
+
+// cancelFuturesOrderRecord 撤销交易所订单并更新本地记录
+func cancelFuturesOrderRecord(cfg *config.Config, exchange services.Exchange, order *models.FuturesOrder) {
+	if err := exchange.CancelFuturesOrder(context.Background(), order.Symbol, order.OrderID); err != nil {
+		log.Printf("撤销订单 %d 失败: %v", order.OrderID, err)
+		return
+	}
+	cfg.DB.Model(order).Updates(map[string]interface{}{
+		"status":     string(futures.OrderStatusTypeCanceled),
+		"updated_at": time.Now(),
+	})
+}
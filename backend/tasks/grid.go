@@ -0,0 +1,327 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+// 网格策略：在 [GridLowerPrice, GridUpperPrice] 区间内等分 GridCount 格，
+// 当前价格以下的价格线挂买单、以上的挂卖单，最接近当前价格的一条空出。
+// 买单成交后在上一条价格线挂卖单，卖单成交后在下一条价格线挂买单；
+// 由成交挂出的配对单成交即完成一次往返，按两次成交价差记录网格利润（未扣除手续费）。
+
+// gridPriceInRange 判断价格是否在网格区间内
+func gridPriceInRange(strategy models.Strategy, price float64) bool {
+	p := decimal.NewFromFloat(price)
+	return p.GreaterThanOrEqual(strategy.GridLowerPrice) && p.LessThanOrEqual(strategy.GridUpperPrice)
+}
+
+// executeGridStrategy 执行网格策略：区间内首次布网，运行中价格离开区间时按配置重新平衡
+func (m *WebSocketManager) executeGridStrategy(exchange services.Exchange, strategy models.Strategy, userID uint, currentPrice float64) {
+	if strategy.PendingBatch {
+		if strategy.GridRebalance && !gridPriceInRange(strategy, currentPrice) {
+			rebalanceGrid(m.cfg, exchange, strategy, currentPrice)
+		}
+		return
+	}
+
+	if !gridPriceInRange(strategy, currentPrice) {
+		return
+	}
+
+	// 双重检查策略状态（使用事务）
+	tx := m.cfg.DB.Begin()
+	var currentStrategy models.Strategy
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&currentStrategy, strategy.ID).Error; err != nil {
+		tx.Rollback()
+		log.Printf("查询策略 %d 失败: %v", strategy.ID, err)
+		return
+	}
+	if currentStrategy.PendingBatch || !currentStrategy.Enabled {
+		tx.Rollback()
+		return
+	}
+	if err := tx.Model(&currentStrategy).Update("pending_batch", true).Error; err != nil {
+		tx.Rollback()
+		log.Printf("更新策略 %d pending_batch 失败: %v", strategy.ID, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("提交事务失败: %v", err)
+		return
+	}
+
+	log.Printf("网格策略 %d 开始布网: %s %s-%s 共 %d 格 @ %.8f",
+		strategy.ID, strategy.Symbol, strategy.GridLowerPrice.String(), strategy.GridUpperPrice.String(), strategy.GridCount, currentPrice)
+
+	if err := placeGridOrders(m.cfg, exchange, strategy, userID, currentPrice); err != nil {
+		log.Printf("网格策略 %d 布网失败: %v", strategy.ID, err)
+		m.cfg.DB.Model(&strategy).Update("pending_batch", false)
+	}
+}
+
+// placeGridOrders 按当前价格在各价格线挂出初始网格委托
+func placeGridOrders(cfg *config.Config, exchange services.Exchange, strategy models.Strategy, userID uint, currentPrice float64) error {
+	step := strategy.GridStep()
+	if !step.IsPositive() || !strategy.GridQuantity.IsPositive() {
+		return fmt.Errorf("网格配置无效")
+	}
+
+	filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
+	if err != nil {
+		return err
+	}
+
+	// 最接近当前价格的价格线不挂单，保证每格都留出一格利润空间
+	center := int(decimal.NewFromFloat(currentPrice).Sub(strategy.GridLowerPrice).Div(step).Round(0).IntPart()) + 1
+
+	// 下单前对整张网格做风控检查，只有买单增加敞口
+	orderCount, side := 0, "SELL"
+	buyNotional := decimal.Zero
+	for level := 1; level <= strategy.GridCount+1; level++ {
+		if level == center {
+			continue
+		}
+		orderCount++
+		if level < center {
+			side = "BUY"
+			buyNotional = buyNotional.Add(strategy.GridLevelPrice(level).Mul(strategy.GridQuantity))
+		}
+	}
+	if err := checkGridRisk(cfg, exchange, strategy, userID, filters, side, buyNotional.InexactFloat64(), orderCount); err != nil {
+		return err
+	}
+
+	successCount := 0
+	failCount := 0
+	for level := 1; level <= strategy.GridCount+1; level++ {
+		if level == center {
+			continue
+		}
+		side := "BUY"
+		if level > center {
+			side = "SELL"
+		}
+		if err := placeGridOrder(cfg, exchange, strategy, userID, side, level, decimal.Zero, filters); err != nil {
+			log.Printf("网格策略 %d 第 %d 格 %s 下单失败: %v", strategy.ID, level, side, err)
+			failCount++
+			continue
+		}
+		successCount++
+	}
+
+	if successCount == 0 {
+		return fmt.Errorf("所有网格订单都失败了")
+	}
+	if failCount > 0 {
+		log.Printf("网格策略 %d: 成功 %d 笔，失败 %d 笔", strategy.ID, successCount, failCount)
+	} else {
+		log.Printf("网格策略 %d: 成功挂单 %d 笔", strategy.ID, successCount)
+	}
+	return nil
+}
+
+// placeGridOrder 在指定价格线挂出一笔网格限价单，entryPrice>0 表示该单为配对平仓单
+func placeGridOrder(cfg *config.Config, exchange services.Exchange, strategy models.Strategy, userID uint,
+	side string, level int, entryPrice decimal.Decimal, filters *services.SymbolFilters) error {
+	price := filters.QuantizePrice(strategy.GridLevelPrice(level))
+	quantity := filters.QuantizeQuantity(strategy.GridQuantity, services.RoundDown)
+	priceStr := filters.PriceString(price)
+	quantityStr := filters.QuantityString(quantity)
+	if !price.IsPositive() || !quantity.IsPositive() {
+		return fmt.Errorf("价格或数量精度不足: %s x %s", priceStr, quantityStr)
+	}
+	if err := filters.Validate(side, price.InexactFloat64(), quantity.InexactFloat64(), 0, false); err != nil {
+		return err
+	}
+
+	order, err := exchange.CreateOrder(context.Background(), services.SpotOrderRequest{
+		Symbol:      strategy.Symbol,
+		Side:        binance.SideType(side),
+		Type:        binance.OrderTypeLimit,
+		TimeInForce: binance.TimeInForceTypeGTC,
+		Quantity:    quantityStr,
+		Price:       priceStr,
+	})
+	if err != nil {
+		return err
+	}
+
+	dbOrder := models.Order{
+		StrategyID:     strategy.ID,
+		UserID:         userID,
+		AccountID:      strategy.AccountID,
+		Symbol:         strategy.Symbol,
+		Side:           side,
+		Price:          price,
+		Quantity:       quantity,
+		OrderID:        order.OrderID,
+		Status:         "pending",
+		CancelAfter:    time.Now().Add(strategyCancelAfter(strategy)),
+		Paper:          services.IsPaperExchange(exchange),
+		GridLevel:      level,
+		GridEntryPrice: entryPrice,
+	}
+	if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+		// 取消刚下的订单
+		exchange.CancelOrder(context.Background(), strategy.Symbol, order.OrderID)
+		return fmt.Errorf("保存订单失败: %v", err)
+	}
+	return nil
+}
+
+// handleGridOrderFilled 网格订单成交：记录往返利润并在相邻价格线挂出配对单，返回本次是否处理了该成交
+func handleGridOrderFilled(cfg *config.Config, exchange services.Exchange, order models.Order, fillPrice decimal.Decimal) bool {
+	if !fillPrice.IsPositive() {
+		fillPrice = order.Price
+	}
+
+	profit := decimal.Zero
+	if order.GridEntryPrice.IsPositive() {
+		if order.Side == "SELL" {
+			profit = fillPrice.Sub(order.GridEntryPrice).Mul(order.Quantity)
+		} else {
+			profit = order.GridEntryPrice.Sub(fillPrice).Mul(order.Quantity)
+		}
+	}
+
+	// 只处理一次成交，避免重复挂出配对单
+	result := cfg.DB.Model(&models.Order{}).
+		Where("id = ? AND status = ?", order.ID, "pending").
+		Updates(map[string]interface{}{"status": "filled", "grid_profit": profit})
+	if result.Error != nil {
+		log.Printf("更新订单 %d 状态为 filled 失败: %v", order.OrderID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	log.Printf("订单 %d 状态更新为: filled", order.OrderID)
+	defer checkStrategyCompletion(cfg, order.StrategyID)
+
+	if order.GridEntryPrice.IsPositive() {
+		log.Printf("网格策略 %d 完成一次往返: 第 %d 格 %s @ %s，利润 %s",
+			order.StrategyID, order.GridLevel, order.Side, fillPrice, profit)
+	}
+
+	var strategy models.Strategy
+	if err := cfg.DB.Where("id = ? AND deleted_at IS NULL", order.StrategyID).First(&strategy).Error; err != nil {
+		log.Printf("策略未找到: ID=%d, error=%v", order.StrategyID, err)
+		return true
+	}
+	if !strategy.Enabled || strategy.Status != "active" || strategy.StrategyType != "grid" {
+		return true
+	}
+
+	side, level := "SELL", order.GridLevel+1
+	if order.Side == "SELL" {
+		side, level = "BUY", order.GridLevel-1
+	}
+	if level < 1 || level > strategy.GridCount+1 {
+		return true
+	}
+
+	// 开仓单成交后挂出的配对单为平仓单；平仓单成交后重新挂出开仓单
+	entryPrice := decimal.Zero
+	if order.GridEntryPrice.IsZero() {
+		entryPrice = fillPrice
+	}
+
+	filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
+	if err != nil {
+		log.Printf("网格策略 %d 挂配对单失败: %v", strategy.ID, err)
+		return true
+	}
+	buyNotional := 0.0
+	if side == "BUY" {
+		buyNotional = strategy.GridLevelPrice(level).Mul(strategy.GridQuantity).InexactFloat64()
+	}
+	if err := checkGridRisk(cfg, exchange, strategy, order.UserID, filters, side, buyNotional, 1); err != nil {
+		log.Printf("网格策略 %d 第 %d 格 %s 配对单未下单: %v", strategy.ID, level, side, err)
+		return true
+	}
+	if err := placeGridOrder(cfg, exchange, strategy, order.UserID, side, level, entryPrice, filters); err != nil {
+		log.Printf("网格策略 %d 第 %d 格 %s 配对单下单失败: %v", strategy.ID, level, side, err)
+	}
+	return true
+}
+
+// checkGridRisk 网格挂单前的风控检查，buyNotional 为新增买单的计价币金额
+func checkGridRisk(cfg *config.Config, exchange services.Exchange, strategy models.Strategy, userID uint,
+	filters *services.SymbolFilters, side string, buyNotional float64, orders int) error {
+	return CheckOrderRisk(cfg, exchange, RiskOrder{
+		UserID:        userID,
+		AccountID:     strategy.AccountID,
+		Market:        RiskMarketSpot,
+		Source:        "grid",
+		StrategyID:    strategy.ID,
+		Symbol:        strategy.Symbol,
+		Side:          side,
+		Notional:      buyNotional,
+		NotionalAsset: filters.QuoteAsset,
+		SpendAsset:    filters.QuoteAsset,
+		Spend:         buyNotional,
+		Orders:        orders,
+		Paper:         services.IsPaperExchange(exchange),
+	})
+}
+
+// rebalanceGrid 价格离开网格区间时撤销全部网格委托，并以当前价格为中心平移区间
+// 撤单后重置 pending_batch，下一次价格推送时按新区间重新布网
+func rebalanceGrid(cfg *config.Config, exchange services.Exchange, strategy models.Strategy, currentPrice float64) {
+	halfWidth := strategy.GridUpperPrice.Sub(strategy.GridLowerPrice).Div(decimal.NewFromInt(2))
+	lower := decimal.NewFromFloat(currentPrice).Sub(halfWidth)
+	upper := decimal.NewFromFloat(currentPrice).Add(halfWidth)
+	if !lower.IsPositive() {
+		log.Printf("网格策略 %d 无法重新平衡: 新区间下限 %s 小于等于0", strategy.ID, lower.String())
+		return
+	}
+
+	var orders []models.Order
+	if err := cfg.DB.Where("strategy_id = ? AND status = ? AND deleted_at IS NULL", strategy.ID, "pending").
+		Find(&orders).Error; err != nil {
+		log.Printf("查询网格策略 %d 待处理订单失败: %v", strategy.ID, err)
+		return
+	}
+	for _, order := range orders {
+		if err := exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID); err != nil && !isOrderNotFoundError(err) {
+			log.Printf("网格策略 %d 撤销订单 %d 失败: %v", strategy.ID, order.OrderID, err)
+			return
+		}
+		cfg.DB.Model(&order).Update("status", "cancelled")
+	}
+
+	if err := cfg.DB.Model(&strategy).Updates(map[string]interface{}{
+		"grid_lower_price": lower,
+		"grid_upper_price": upper,
+		"pending_batch":    false,
+	}).Error; err != nil {
+		log.Printf("更新网格策略 %d 区间失败: %v", strategy.ID, err)
+		return
+	}
+
+	log.Printf("网格策略 %d 价格 %.8f 超出区间，已撤销 %d 笔委托并重新平衡至 %s-%s",
+		strategy.ID, currentPrice, len(orders), lower.String(), upper.String())
+}
+
+// gridFillPrice 根据订单累计成交额计算成交均价
+func gridFillPrice(order *binance.Order) decimal.Decimal {
+	executed, err := decimal.NewFromString(order.ExecutedQuantity)
+	if err != nil || !executed.IsPositive() {
+		return decimal.Zero
+	}
+	quote, err := decimal.NewFromString(order.CummulativeQuoteQuantity)
+	if err != nil || !quote.IsPositive() {
+		return decimal.Zero
+	}
+	return quote.Div(executed)
+}
@@ -0,0 +1,301 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 紧急停止范围
+const (
+	KillSwitchScopeUser   = "user"
+	KillSwitchScopeSystem = "system"
+)
+
+// ActiveKillSwitch 返回对用户生效的紧急停止记录，用户级优先，未生效时返回 nil
+func ActiveKillSwitch(db *gorm.DB, userID uint) (*models.KillSwitchEvent, error) {
+	var event models.KillSwitchEvent
+	err := db.Where("active = ? AND (scope = ? OR (scope = ? AND target_user_id = ?))",
+		true, KillSwitchScopeSystem, KillSwitchScopeUser, userID).
+		Order("scope desc, id desc").
+		First(&event).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// TriggerKillSwitch 记录并执行紧急停止，记录先于执行写入，执行期间的新开仓即被拒绝
+// 系统级紧急停止对所有用户逐个执行，调用方可在后台运行
+func TriggerKillSwitch(cfg *config.Config, event *models.KillSwitchEvent) error {
+	event.Active = true
+	if err := cfg.DB.Create(event).Error; err != nil {
+		return fmt.Errorf("保存紧急停止记录失败: %v", err)
+	}
+	log.Printf("紧急停止已触发: 范围=%s, 用户=%d, 触发人=%d, 平仓=%v, 原因=%s",
+		event.Scope, event.TargetUserID, event.TriggeredBy, event.ClosePositions, event.Reason)
+	return nil
+}
+
+// RunKillSwitch 停用策略和提币规则、撤销挂单并按需平仓，结果写回紧急停止记录
+func RunKillSwitch(cfg *config.Config, event *models.KillSwitchEvent) {
+	var userIDs []uint
+	if event.Scope == KillSwitchScopeSystem {
+		if err := cfg.DB.Model(&models.User{}).Pluck("id", &userIDs).Error; err != nil {
+			log.Printf("获取用户列表失败: %v", err)
+		}
+	} else {
+		userIDs = []uint{event.TargetUserID}
+	}
+
+	var errs []string
+	for _, userID := range userIDs {
+		errs = append(errs, killUserTrading(cfg, event, userID)...)
+	}
+
+	now := time.Now()
+	event.Errors = strings.Join(errs, "\n")
+	event.CompletedAt = &now
+	if err := cfg.DB.Model(event).Updates(map[string]interface{}{
+		"strategies_disabled": event.StrategiesDisabled,
+		"rules_disabled":      event.RulesDisabled,
+		"orders_cancelled":    event.OrdersCancelled,
+		"positions_closed":    event.PositionsClosed,
+		"errors":              event.Errors,
+		"completed_at":        event.CompletedAt,
+	}).Error; err != nil {
+		log.Printf("更新紧急停止记录 %d 失败: %v", event.ID, err)
+	}
+
+	log.Printf("紧急停止 %d 执行完成: 停用策略 %d，停用提币规则 %d，撤单 %d，平仓 %d，错误 %d",
+		event.ID, event.StrategiesDisabled, event.RulesDisabled, event.OrdersCancelled, event.PositionsClosed, len(errs))
+}
+
+// ReleaseKillSwitch 解除紧急停止，已停用的策略和规则需要用户手动重新启用
+func ReleaseKillSwitch(db *gorm.DB, event *models.KillSwitchEvent, releasedBy uint) error {
+	now := time.Now()
+	return db.Model(event).Updates(map[string]interface{}{
+		"active":      false,
+		"released_by": releasedBy,
+		"released_at": &now,
+	}).Error
+}
+
+// killUserTrading 对单个用户执行紧急停止，返回执行中的错误
+func killUserTrading(cfg *config.Config, event *models.KillSwitchEvent, userID uint) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf("用户 %d: ", userID) + fmt.Sprintf(format, args...)
+		log.Printf("紧急停止 %d %s", event.ID, msg)
+		errs = append(errs, msg)
+	}
+
+	// 先停用策略和提币规则，避免撤单期间重新触发
+	disables := []struct {
+		model   interface{}
+		updates map[string]interface{}
+		rule    bool
+	}{
+		{&models.Strategy{}, map[string]interface{}{"enabled": false}, false},
+		{&models.DCAStrategy{}, map[string]interface{}{"enabled": false}, false},
+		{&models.DualInvestmentStrategy{}, map[string]interface{}{"enabled": false, "status": "paused"}, false},
+		{&models.Withdrawal{}, map[string]interface{}{"enabled": false, "status": "paused"}, true},
+	}
+	for _, d := range disables {
+		result := cfg.DB.Model(d.model).Where("user_id = ? AND enabled = ?", userID, true).Updates(d.updates)
+		if result.Error != nil {
+			fail("停用失败: %v", result.Error)
+			continue
+		}
+		if d.rule {
+			event.RulesDisabled += result.RowsAffected
+		} else {
+			event.StrategiesDisabled += result.RowsAffected
+		}
+	}
+
+	// 未开仓的合约策略直接取消，持仓中的策略保留状态由平仓或止盈止损结束
+	result := cfg.DB.Model(&models.FuturesStrategy{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"waiting", "triggered"}).
+		Updates(map[string]interface{}{"enabled": false, "status": "cancelled", "completed_at": time.Now()})
+	if result.Error != nil {
+		fail("取消合约策略失败: %v", result.Error)
+	}
+	event.StrategiesDisabled += result.RowsAffected
+	result = cfg.DB.Model(&models.FuturesStrategy{}).
+		Where("user_id = ? AND enabled = ?", userID, true).
+		Update("enabled", false)
+	if result.Error != nil {
+		fail("停用合约策略失败: %v", result.Error)
+	}
+	event.StrategiesDisabled += result.RowsAffected
+
+	// 模拟盘和每个交易所账户分别撤单
+	accounts := []killSwitchAccount{
+		{paper: true, exchange: services.NewUserPaperExchange(cfg.DB, userID)},
+	}
+	var exchangeAccounts []models.ExchangeAccount
+	if err := cfg.DB.Where("user_id = ?", userID).Find(&exchangeAccounts).Error; err != nil {
+		fail("查询交易所账户失败: %v", err)
+	}
+	for i := range exchangeAccounts {
+		// 停用的账户同样需要撤单平仓，不检查账户状态
+		exchange, err := services.NewAccountExchangeAnyStatus(&exchangeAccounts[i])
+		if err != nil {
+			fail("创建交易所账户 %s 客户端失败: %v", exchangeAccounts[i].Label, err)
+			continue
+		}
+		accounts = append(accounts, killSwitchAccount{accountID: exchangeAccounts[i].ID, exchange: exchange})
+	}
+
+	for _, account := range accounts {
+		cancelSpotOrders(cfg, event, userID, account, fail)
+		cancelFuturesOrders(cfg, event, userID, account, fail)
+		if event.ClosePositions {
+			closeFuturesPositions(cfg, event, userID, account, fail)
+		}
+	}
+
+	return errs
+}
+
+// killSwitchAccount 紧急停止时逐个处理的账户，模拟盘按用户记账不区分交易所账户
+type killSwitchAccount struct {
+	accountID uint
+	paper     bool
+	exchange  services.Exchange
+}
+
+// scope 将查询限定在该账户的记录
+func (a killSwitchAccount) scope(query *gorm.DB) *gorm.DB {
+	if a.paper {
+		return query.Where("paper = ?", true)
+	}
+	return query.Where("paper = ? AND account_id = ?", false, a.accountID)
+}
+
+// cancelSpotOrders 撤销交易所上的全部现货挂单，包括不是本系统创建的订单
+func cancelSpotOrders(cfg *config.Config, event *models.KillSwitchEvent, userID uint, account killSwitchAccount,
+	fail func(string, ...interface{})) {
+	exchange := account.exchange
+	openOrders, err := exchange.ListOpenOrders(context.Background(), "")
+	if err != nil {
+		fail("获取现货挂单失败: %v", err)
+		return
+	}
+	for _, order := range openOrders {
+		if err := exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID); err != nil && !isOrderNotFoundError(err) {
+			fail("撤销现货订单 %s/%d 失败: %v", order.Symbol, order.OrderID, err)
+			continue
+		}
+		account.scope(cfg.DB.Model(&models.Order{})).
+			Where("user_id = ? AND order_id = ? AND status = ?", userID, order.OrderID, "pending").
+			Update("status", "cancelled")
+		event.OrdersCancelled++
+	}
+}
+
+// killSwitchProtectiveTypes 止盈止损类合约订单，不平仓时保留，避免持仓失去保护
+var killSwitchProtectiveTypes = map[futures.OrderType]bool{
+	futures.OrderTypeStop:               true,
+	futures.OrderTypeStopMarket:         true,
+	futures.OrderTypeTakeProfit:         true,
+	futures.OrderTypeTakeProfitMarket:   true,
+	futures.OrderTypeTrailingStopMarket: true,
+}
+
+// cancelFuturesOrders 撤销交易所上的合约挂单，包括不是本系统创建的订单；不平仓时保留止盈止损单
+func cancelFuturesOrders(cfg *config.Config, event *models.KillSwitchEvent, userID uint, account killSwitchAccount,
+	fail func(string, ...interface{})) {
+	exchange := account.exchange
+	openOrders, err := exchange.ListFuturesOpenOrders(context.Background(), "")
+	if err != nil {
+		fail("获取合约挂单失败: %v", err)
+		return
+	}
+	for _, order := range openOrders {
+		if !event.ClosePositions && killSwitchProtectiveTypes[order.Type] {
+			continue
+		}
+		if err := exchange.CancelFuturesOrder(context.Background(), order.Symbol, order.OrderID); err != nil && !isOrderNotFoundError(err) {
+			fail("撤销合约订单 %s/%d 失败: %v", order.Symbol, order.OrderID, err)
+			continue
+		}
+		account.scope(cfg.DB.Model(&models.FuturesOrder{})).
+			Where("user_id = ? AND order_id = ? AND status IN ?", userID, order.OrderID,
+				[]string{string(futures.OrderStatusTypeNew), string(futures.OrderStatusTypePartiallyFilled)}).
+			Updates(map[string]interface{}{
+				"status":     string(futures.OrderStatusTypeCanceled),
+				"updated_at": time.Now(),
+			})
+		event.OrdersCancelled++
+	}
+}
+
+// closeFuturesPositions 市价平掉全部合约持仓，持仓记录由持仓监控同步为已平仓
+func closeFuturesPositions(cfg *config.Config, event *models.KillSwitchEvent, userID uint, account killSwitchAccount,
+	fail func(string, ...interface{})) {
+	exchange := account.exchange
+	positions, err := exchange.GetPositionRisk(context.Background(), "")
+	if err != nil {
+		fail("获取合约持仓失败: %v", err)
+		return
+	}
+	for _, position := range positions {
+		amount, err := decimal.NewFromString(position.PositionAmt)
+		if err != nil {
+			fail("解析 %s %s 持仓数量 %q 失败: %v", position.Symbol, position.PositionSide, position.PositionAmt, err)
+			continue
+		}
+		if amount.IsZero() {
+			continue
+		}
+		side := futures.SideTypeSell
+		if amount.IsNegative() {
+			side = futures.SideTypeBuy
+		}
+		quantity := amount.Abs()
+
+		order, err := exchange.CreateFuturesOrder(context.Background(), services.FuturesOrderRequest{
+			Symbol:       position.Symbol,
+			Side:         side,
+			PositionSide: futures.PositionSideType(position.PositionSide),
+			Type:         futures.OrderTypeMarket,
+			Quantity:     quantity.String(),
+		})
+		if err != nil {
+			fail("市价平仓 %s %s 失败: %v", position.Symbol, position.PositionSide, err)
+			continue
+		}
+
+		dbOrder := models.FuturesOrder{
+			UserID:       userID,
+			AccountID:    account.accountID,
+			Symbol:       position.Symbol,
+			Side:         string(side),
+			PositionSide: position.PositionSide,
+			Type:         string(futures.OrderTypeMarket),
+			Quantity:     quantity,
+			OrderID:      order.OrderID,
+			Status:       string(order.Status),
+			OrderPurpose: "kill_switch",
+			Paper:        account.paper,
+		}
+		if err := cfg.DB.Create(&dbOrder).Error; err != nil {
+			log.Printf("保存平仓订单失败: %v", err)
+		}
+		event.PositionsClosed++
+	}
+}
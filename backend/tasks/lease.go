@@ -0,0 +1,197 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activeTasks 本实例正在运行的任务名称 -> *taskGroup，集群模式下只包含持有租约的任务
+var activeTasks sync.Map
+
+// taskActive 任务是否在本实例运行，HTTP请求触发的后台动作据此判断是否由本实例处理
+func taskActive(name string) bool {
+	_, ok := activeTasks.Load(name)
+	return ok
+}
+
+// taskGroup 任务一次运行期间启动的全部goroutine，失去租约时等待它们退出后才算释放
+type taskGroup struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// spawn 启动属于任务的goroutine，任务停止后不再启动，返回 false
+func (g *taskGroup) spawn(name string, fn func()) bool {
+	g.mu.Lock()
+	if g.stopped {
+		g.mu.Unlock()
+		log.Printf("任务已停止，不再启动 %s", name)
+		return false
+	}
+	g.wg.Add(1)
+	g.mu.Unlock()
+
+	spawn(name, func() {
+		defer g.wg.Done()
+		fn()
+	})
+	return true
+}
+
+// wait 禁止启动新的goroutine并等待已启动的退出，超时返回 false
+func (g *taskGroup) wait(timeout time.Duration) bool {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	return waitGroupTimeout(&g.wg, timeout)
+}
+
+type taskGroupKey struct{}
+
+// withTaskGroup 把任务的 taskGroup 放入上下文，任务内通过 spawnTask 启动的goroutine计入其中
+func withTaskGroup(ctx context.Context, group *taskGroup) context.Context {
+	return context.WithValue(ctx, taskGroupKey{}, group)
+}
+
+// spawnTask 启动属于上下文所在任务的goroutine，上下文不属于任何任务时与 spawn 相同
+func spawnTask(ctx context.Context, name string, fn func()) {
+	if group, ok := ctx.Value(taskGroupKey{}).(*taskGroup); ok {
+		group.spawn(name, fn)
+		return
+	}
+	spawn(name, fn)
+}
+
+// spawnForTask 为本实例正在运行的任务启动goroutine，没有上下文的调用方（如HTTP请求）使用
+// 任务未在本实例运行或正在停止时不启动，返回 false
+func spawnForTask(task, name string, fn func()) bool {
+	value, ok := activeTasks.Load(task)
+	if !ok {
+		return false
+	}
+	return value.(*taskGroup).spawn(name, fn)
+}
+
+// tryAcquireLease 获取或续约任务租约，租约由本实例持有或已到期时成功；
+// grace 大于0时只接管到期超过 grace 的租约，让优先运行该任务的实例先获取
+// 到期判断使用数据库时间，避免各实例时钟不一致
+func tryAcquireLease(db *gorm.DB, name, holder string, ttl, grace time.Duration) (bool, error) {
+	lease := models.TaskLease{Name: name, ExpiresAt: time.Unix(0, 0)}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lease).Error; err != nil {
+		return false, err
+	}
+
+	result := db.Model(&models.TaskLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < DATE_SUB(NOW(3), INTERVAL ? MICROSECOND))",
+			name, holder, grace.Microseconds()).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": gorm.Expr("DATE_ADD(NOW(3), INTERVAL ? MICROSECOND)", ttl.Microseconds()),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// releaseLease 释放本实例持有的单个租约
+func releaseLease(db *gorm.DB, name, holder string) error {
+	return db.Model(&models.TaskLease{}).
+		Where("name = ? AND holder = ?", name, holder).
+		Updates(map[string]interface{}{
+			"holder":     "",
+			"expires_at": time.Unix(0, 0),
+		}).Error
+}
+
+// releaseLeases 释放本实例持有的全部租约，其他实例无需等待到期即可接管
+func releaseLeases(db *gorm.DB, holder string) error {
+	return db.Model(&models.TaskLease{}).
+		Where("holder = ?", holder).
+		Updates(map[string]interface{}{
+			"holder":     "",
+			"expires_at": time.Unix(0, 0),
+		}).Error
+}
+
+// runLeased 竞争任务租约，持有期间运行任务
+// 租约被其他实例接管，或连续续约失败接近租期时取消任务，等任务启动的goroutine全部退出后
+// 才释放租约并在一个租期后重新竞争，避免新旧两个实例同时运行同一任务
+func (s *Supervisor) runLeased(task supervisedTask) {
+	cluster := s.cfg.Cluster
+	grace := time.Duration(0)
+	if !s.preferred[task.name] {
+		grace = cluster.LeaseTTL
+	}
+
+	ticker := time.NewTicker(cluster.RenewInterval)
+	defer ticker.Stop()
+
+	var (
+		cancel     context.CancelFunc // 非nil表示任务正在运行
+		group      *taskGroup
+		renewedAt  time.Time
+		retryAfter time.Time
+	)
+	stop := func(reason string) {
+		log.Printf("停止任务 %s：%s", task.title, reason)
+		activeTasks.Delete(task.name)
+		cancel()
+		cancel = nil
+		// 旧的goroutine仍在运行时不能视为已释放，否则重新取得租约后会与它们同时运行
+		for !group.wait(cluster.LeaseTTL) {
+			log.Printf("任务 %s 的goroutine未在 %v 内退出，继续等待", task.title, cluster.LeaseTTL)
+			if s.ctx.Err() != nil {
+				return
+			}
+		}
+		group = nil
+		if err := releaseLease(s.cfg.DB, task.name, cluster.InstanceID); err != nil {
+			log.Printf("释放任务 %s 的租约失败: %v", task.title, err)
+		}
+		retryAfter = time.Now().Add(cluster.LeaseTTL)
+	}
+
+	for {
+		if cancel != nil || time.Now().After(retryAfter) {
+			held, err := tryAcquireLease(s.cfg.DB, task.name, cluster.InstanceID, cluster.LeaseTTL, grace)
+			switch {
+			case err != nil:
+				log.Printf("任务 %s 租约操作失败: %v", task.title, err)
+				if cancel != nil && time.Since(renewedAt) > cluster.LeaseTTL-cluster.RenewInterval {
+					stop("无法续约，租约即将到期")
+				}
+			case held:
+				renewedAt = time.Now()
+				if cancel == nil {
+					var ctx context.Context
+					ctx, cancel = context.WithCancel(s.ctx)
+					group = &taskGroup{}
+					ctx = withTaskGroup(ctx, group)
+					activeTasks.Store(task.name, group)
+					log.Printf("实例 %s 取得任务 %s 的租约，开始运行", cluster.InstanceID, task.title)
+					group.spawn(task.title, func() { task.run(ctx, s.cfg) })
+				}
+			case cancel != nil:
+				stop("租约已被其他实例接管")
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			if cancel != nil {
+				activeTasks.Delete(task.name)
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ccj241/binance/models"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestTaskGroupWaitsForGoroutines(t *testing.T) {
+	group := &taskGroup{}
+	release := make(chan struct{})
+	if !group.spawn("test", func() { <-release }) {
+		t.Fatal("运行中的任务应能启动goroutine")
+	}
+
+	if group.wait(20 * time.Millisecond) {
+		t.Fatal("goroutine未退出时 wait 应超时")
+	}
+	// 停止后不再启动新的goroutine，已启动的继续计数
+	started := false
+	if group.spawn("late", func() { started = true }) {
+		t.Fatal("停止后的任务不应再启动goroutine")
+	}
+
+	close(release)
+	if !group.wait(time.Second) {
+		t.Fatal("goroutine退出后 wait 应返回 true")
+	}
+	if started {
+		t.Error("停止后提交的函数被执行")
+	}
+}
+
+func TestSpawnTaskJoinsContextGroup(t *testing.T) {
+	group := &taskGroup{}
+	ctx := withTaskGroup(context.Background(), group)
+
+	release := make(chan struct{})
+	spawnTask(ctx, "child", func() { <-release })
+	if group.wait(20 * time.Millisecond) {
+		t.Fatal("通过上下文启动的goroutine应计入任务")
+	}
+	close(release)
+	if !group.wait(time.Second) {
+		t.Fatal("goroutine退出后 wait 应返回 true")
+	}
+}
+
+func TestSpawnForTaskRequiresActiveTask(t *testing.T) {
+	const name = "test_spawn_for_task"
+	if spawnForTask(name, "child", func() {}) {
+		t.Fatal("任务未在本实例运行时不应启动")
+	}
+
+	group := &taskGroup{}
+	activeTasks.Store(name, group)
+	defer activeTasks.Delete(name)
+	if !taskActive(name) {
+		t.Fatal("taskActive 应返回 true")
+	}
+
+	done := make(chan struct{})
+	if !spawnForTask(name, "child", func() { close(done) }) {
+		t.Fatal("任务运行中应能启动goroutine")
+	}
+	<-done
+	if !group.wait(time.Second) {
+		t.Fatal("goroutine应计入任务")
+	}
+	if spawnForTask(name, "child", func() {}) {
+		t.Error("任务停止后不应再启动")
+	}
+}
+
+// leaseTestDB 租约依赖 MySQL 的时间函数，设置 TEST_MYSQL_DSN 时才运行
+func leaseTestDB(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("未设置 TEST_MYSQL_DSN，跳过租约数据库测试")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("连接数据库失败: %v", err)
+	}
+	if err := models.MigrateLeaseTables(db); err != nil {
+		t.Fatalf("迁移租约表失败: %v", err)
+	}
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Where("name = ?", name).Delete(&models.TaskLease{})
+	})
+	return db, name
+}
+
+func TestLeaseAcquireAndRenew(t *testing.T) {
+	db, name := leaseTestDB(t)
+
+	if held, err := tryAcquireLease(db, name, "a", time.Minute, 0); err != nil || !held {
+		t.Fatalf("首次获取租约 = %v, %v", held, err)
+	}
+	if held, err := tryAcquireLease(db, name, "b", time.Minute, 0); err != nil || held {
+		t.Fatalf("未到期的租约被其他实例获取 = %v, %v", held, err)
+	}
+	if held, err := tryAcquireLease(db, name, "a", time.Minute, 0); err != nil || !held {
+		t.Fatalf("持有者续约 = %v, %v", held, err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	db, name := leaseTestDB(t)
+
+	if held, _ := tryAcquireLease(db, name, "a", 50*time.Millisecond, 0); !held {
+		t.Fatal("首次获取租约失败")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 非优先实例要等到期超过 grace 才接管
+	if held, err := tryAcquireLease(db, name, "b", time.Minute, time.Hour); err != nil || held {
+		t.Fatalf("到期未超过 grace 时被接管 = %v, %v", held, err)
+	}
+	if held, err := tryAcquireLease(db, name, "b", time.Minute, 0); err != nil || !held {
+		t.Fatalf("到期的租约应可被接管 = %v, %v", held, err)
+	}
+	if held, _ := tryAcquireLease(db, name, "a", time.Minute, 0); held {
+		t.Fatal("租约被接管后原持有者不能续约")
+	}
+}
+
+func TestLeaseRelease(t *testing.T) {
+	db, name := leaseTestDB(t)
+
+	if held, _ := tryAcquireLease(db, name, "a", time.Minute, 0); !held {
+		t.Fatal("首次获取租约失败")
+	}
+	// 非持有者释放不影响租约
+	if err := releaseLease(db, name, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := tryAcquireLease(db, name, "b", time.Minute, 0); held {
+		t.Fatal("非持有者释放了租约")
+	}
+	if err := releaseLease(db, name, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := tryAcquireLease(db, name, "b", time.Minute, 0); err != nil || !held {
+		t.Fatalf("释放后应立即可被接管 = %v, %v", held, err)
+	}
+}
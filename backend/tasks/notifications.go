@@ -0,0 +1,280 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/ccj241/binance/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// 事件队列容量，队列满时丢弃事件，不阻塞交易流程
+	notificationQueueSize = 1000
+	// 单条投递最多尝试次数
+	maxNotificationAttempts = 5
+	// 同一用户的API密钥失效通知间隔
+	apiKeyInvalidNotifyInterval = 6 * time.Hour
+)
+
+// NotificationEvent 任务发出的通知事件
+type NotificationEvent struct {
+	UserID  uint
+	Event   string
+	Title   string
+	Message string
+	Data    map[string]interface{}
+}
+
+var notificationQueue = make(chan NotificationEvent, notificationQueueSize)
+
+// EmitNotification 发出通知事件，不等待投递结果
+func EmitNotification(event NotificationEvent) {
+	select {
+	case notificationQueue <- event:
+	default:
+		log.Printf("通知队列已满，丢弃用户 %d 的 %s 事件", event.UserID, event.Event)
+	}
+}
+
+// StartNotificationDispatcher 消费本实例产生的通知事件，失败的投递由 retryNotifications 重试
+func StartNotificationDispatcher(ctx context.Context, cfg *config.Config) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-notificationQueue:
+			dispatchNotification(cfg, event)
+		}
+	}
+}
+
+// drainNotifications 停止时投递队列中剩余的通知事件
+func drainNotifications(cfg *config.Config) {
+	for {
+		select {
+		case event := <-notificationQueue:
+			dispatchNotification(cfg, event)
+		default:
+			return
+		}
+	}
+}
+
+// GetNotificationPreference 获取用户通知偏好，未配置时返回 nil
+func GetNotificationPreference(db *gorm.DB, userID uint) (*models.NotificationPreference, error) {
+	var pref models.NotificationPreference
+	err := db.Where("user_id = ?", userID).First(&pref).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// dispatchNotification 为每个已启用的渠道写入投递记录并立即发送
+func dispatchNotification(cfg *config.Config, event NotificationEvent) {
+	// 在线的页面直接收到通知，不依赖渠道配置
+	PublishUserEvent(event.UserID, StreamEventNotification, map[string]interface{}{
+		"event":   event.Event,
+		"title":   event.Title,
+		"message": event.Message,
+		"data":    event.Data,
+	})
+
+	pref, err := GetNotificationPreference(cfg.DB, event.UserID)
+	if err != nil {
+		log.Printf("获取用户 %d 通知偏好失败: %v", event.UserID, err)
+		return
+	}
+	if pref == nil || !pref.Subscribed(event.Event) {
+		return
+	}
+
+	payload, err := json.Marshal(services.Notification{
+		Event:   event.Event,
+		Title:   event.Title,
+		Message: event.Message,
+		Data:    event.Data,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		log.Printf("序列化通知失败: %v", err)
+		return
+	}
+
+	for _, channel := range pref.Channels() {
+		delivery := models.NotificationDelivery{
+			UserID:  event.UserID,
+			Event:   event.Event,
+			Channel: channel,
+			Title:   event.Title,
+			Payload: string(payload),
+			Status:  "pending",
+		}
+		if err := cfg.DB.Create(&delivery).Error; err != nil {
+			log.Printf("保存通知投递记录失败: %v", err)
+			continue
+		}
+		deliverNotification(cfg, pref, &delivery)
+	}
+}
+
+// retryNotifications 定期重试到期的失败投递
+func retryNotifications(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.NotificationRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var deliveries []models.NotificationDelivery
+		if err := cfg.DB.Where("status = ? AND next_attempt_at <= ?", "retrying", time.Now()).
+			Order("next_attempt_at").Limit(100).Find(&deliveries).Error; err != nil {
+			log.Printf("获取待重试通知失败: %v", err)
+			continue
+		}
+
+		prefs := make(map[uint]*models.NotificationPreference)
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			pref, ok := prefs[delivery.UserID]
+			if !ok {
+				pref, _ = GetNotificationPreference(cfg.DB, delivery.UserID)
+				prefs[delivery.UserID] = pref
+			}
+			if pref == nil {
+				markNotificationFailed(cfg, delivery, "通知偏好已删除")
+				continue
+			}
+			deliverNotification(cfg, pref, delivery)
+		}
+	}
+}
+
+// deliverNotification 发送一次并记录结果，失败时按指数退避安排重试
+func deliverNotification(cfg *config.Config, pref *models.NotificationPreference, delivery *models.NotificationDelivery) {
+	var notification services.Notification
+	if err := json.Unmarshal([]byte(delivery.Payload), &notification); err != nil {
+		markNotificationFailed(cfg, delivery, fmt.Sprintf("通知内容无效: %v", err))
+		return
+	}
+
+	notifier, err := newNotifier(pref, delivery.Channel)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err = notifier.Send(ctx, notification)
+		cancel()
+	}
+
+	delivery.Attempts++
+	if err == nil {
+		now := time.Now()
+		cfg.DB.Model(delivery).Updates(map[string]interface{}{
+			"status":          "sent",
+			"attempts":        delivery.Attempts,
+			"sent_at":         &now,
+			"next_attempt_at": nil,
+			"last_error":      "",
+		})
+		return
+	}
+
+	if delivery.Attempts >= maxNotificationAttempts {
+		markNotificationFailed(cfg, delivery, err.Error())
+		return
+	}
+
+	// 按重试间隔指数退避，默认30秒、1分钟、2分钟、4分钟后重试
+	next := time.Now().Add(cfg.Tasks.NotificationRetryInterval << (delivery.Attempts - 1))
+	log.Printf("用户 %d %s 通知 %d 发送失败，第 %d 次: %v", delivery.UserID, delivery.Channel, delivery.ID, delivery.Attempts, err)
+	cfg.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          "retrying",
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": &next,
+		"last_error":      truncateError(err.Error()),
+	})
+}
+
+// markNotificationFailed 放弃投递
+func markNotificationFailed(cfg *config.Config, delivery *models.NotificationDelivery, reason string) {
+	log.Printf("用户 %d %s 通知 %d 发送失败，不再重试: %s", delivery.UserID, delivery.Channel, delivery.ID, reason)
+	cfg.DB.Model(delivery).Updates(map[string]interface{}{
+		"status":          "failed",
+		"attempts":        delivery.Attempts,
+		"next_attempt_at": nil,
+		"last_error":      truncateError(reason),
+	})
+}
+
+// newNotifier 按渠道创建通知发送器
+func newNotifier(pref *models.NotificationPreference, channel string) (services.Notifier, error) {
+	switch channel {
+	case models.NotifyChannelTelegram:
+		return services.NewTelegramNotifier(pref.TelegramChatID)
+	case models.NotifyChannelEmail:
+		return services.NewEmailNotifier(pref.Email)
+	case models.NotifyChannelWebhook:
+		secret, err := utils.Decrypt(pref.WebhookSecret)
+		if err != nil {
+			return nil, fmt.Errorf("解密Webhook密钥失败: %v", err)
+		}
+		return services.NewWebhookNotifier(pref.WebhookURL, secret), nil
+	}
+	return nil, fmt.Errorf("未知的通知渠道: %s", channel)
+}
+
+// truncateError 截断错误信息以适应字段长度
+func truncateError(msg string) string {
+	if len(msg) > 1000 {
+		return msg[:1000]
+	}
+	return msg
+}
+
+var apiKeyInvalidNotified sync.Map // userID -> time.Time
+
+// isAPIKeyError 判断是否为API密钥无效、IP未授权或签名错误
+func isAPIKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := err.Error()
+	return strings.Contains(errStr, "code=-2014") ||
+		strings.Contains(errStr, "code=-2015") ||
+		strings.Contains(errStr, "code=-2008") ||
+		strings.Contains(errStr, "code=-1022")
+}
+
+// reportAPIKeyError 交易所拒绝用户API密钥时通知用户，同一用户限频
+func reportAPIKeyError(userID uint, err error) {
+	if !isAPIKeyError(err) {
+		return
+	}
+	now := time.Now()
+	if last, ok := apiKeyInvalidNotified.Load(userID); ok && now.Sub(last.(time.Time)) < apiKeyInvalidNotifyInterval {
+		return
+	}
+	apiKeyInvalidNotified.Store(userID, now)
+
+	EmitNotification(NotificationEvent{
+		UserID:  userID,
+		Event:   models.NotifyAPIKeyInvalid,
+		Title:   "API密钥失效",
+		Message: fmt.Sprintf("币安拒绝了您的API密钥，自动交易和提币已无法执行，请检查密钥权限和IP白名单: %v", err),
+	})
+}
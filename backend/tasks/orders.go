@@ -2,21 +2,29 @@ package tasks
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
 )
 
 // CheckOrders 定期检查订单状态并更新
-func CheckOrders(cfg *config.Config) {
-	ticker := time.NewTicker(30 * time.Second)
+func CheckOrders(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.OrderCheckInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		checkPendingOrders(cfg)
 	}
 }
@@ -37,60 +45,64 @@ func checkPendingOrders(cfg *config.Config) {
 
 	// 移除订单数量日志
 
-	// 按用户分组订单
-	userOrders := make(map[uint][]models.Order)
+	// 按交易所账户和模拟盘标记分组订单，模拟盘按用户记账
+	type orderGroup struct {
+		userID    uint
+		accountID uint
+		paper     bool
+	}
+	userOrders := make(map[orderGroup][]models.Order)
 	for _, order := range orders {
-		userOrders[order.UserID] = append(userOrders[order.UserID], order)
+		group := orderGroup{userID: order.UserID, accountID: order.AccountID, paper: order.Paper}
+		if order.Paper {
+			group.accountID = 0
+		}
+		userOrders[group] = append(userOrders[group], order)
 	}
 
-	// 处理每个用户的订单
-	for userID, userOrderList := range userOrders {
-		processUserOrders(cfg, userID, userOrderList)
+	// 处理每个账户的订单
+	for group, userOrderList := range userOrders {
+		// 用户数据流正常时订单状态由推送更新，轮询只处理超时撤单并定期完整对账
+		if !group.paper && !userStreams.shouldReconcile(userStreamKey{userID: group.userID, accountID: group.accountID, market: userStreamSpot}) {
+			userOrderList = timedOutOrders(userOrderList)
+			if len(userOrderList) == 0 {
+				continue
+			}
+		}
+		processUserOrders(cfg, group.userID, group.accountID, group.paper, userOrderList)
 	}
 }
 
-// processUserOrders 处理单个用户的订单
-func processUserOrders(cfg *config.Config, userID uint, orders []models.Order) {
-	// 获取用户信息
-	var user models.User
-	if err := cfg.DB.First(&user, userID).Error; err != nil {
-		log.Printf("订单用户未找到: userID=%d, error=%v", userID, err)
-		return
-	}
-
-	// 检查加密的API密钥是否存在
-	if user.APIKey == "" || user.SecretKey == "" {
-		// 移除日志，静默返回
-		return
-	}
-
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		log.Printf("解密用户 %d API Key失败: %v", userID, err)
-		return
+// timedOutOrders 筛选已超过撤单时间的订单
+func timedOutOrders(orders []models.Order) []models.Order {
+	now := time.Now()
+	var result []models.Order
+	for _, order := range orders {
+		if order.GridLevel == 0 && now.After(order.CancelAfter) {
+			result = append(result, order)
+		}
 	}
+	return result
+}
 
-	// 验证解密后的API Key格式
-	if apiKey == "" || len(apiKey) != 64 {
-		// 移除验证日志，只在真正出错时记录
+// processUserOrders 处理单个交易所账户的订单
+func processUserOrders(cfg *config.Config, userID, accountID uint, paper bool, orders []models.Order) {
+	// 模拟盘订单在模拟撮合引擎中查询，不需要API密钥
+	if paper {
+		processExchangeOrders(cfg, services.NewUserPaperExchange(cfg.DB, userID), orders)
 		return
 	}
 
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewUserExchange(cfg.DB, userID, accountID)
 	if err != nil {
-		log.Printf("解密用户 %d Secret Key失败: %v", userID, err)
-		return
-	}
-
-	// 验证解密后的Secret Key格式
-	if secretKey == "" || len(secretKey) != 64 {
-		// 移除验证日志
+		// 账户未配置或已停用时静默跳过
 		return
 	}
+	processExchangeOrders(cfg, exchange, orders)
+}
 
-	client := binance.NewClient(apiKey, secretKey)
-
+// processExchangeOrders 按交易对分组处理同一交易所实例下的订单
+func processExchangeOrders(cfg *config.Config, exchange services.Exchange, orders []models.Order) {
 	// 按交易对分组订单，减少API调用
 	symbolOrders := make(map[string][]models.Order)
 	for _, order := range orders {
@@ -99,14 +111,14 @@ func processUserOrders(cfg *config.Config, userID uint, orders []models.Order) {
 
 	// 处理每个交易对的订单
 	for symbol, symbolOrderList := range symbolOrders {
-		processSymbolOrders(cfg, client, symbol, symbolOrderList)
+		processSymbolOrders(cfg, exchange, symbol, symbolOrderList)
 	}
-} // processUserOrders 处理单个用户的订单
+}
 
 // processSymbolOrders 处理特定交易对的订单
-func processSymbolOrders(cfg *config.Config, client *binance.Client, symbol string, orders []models.Order) {
+func processSymbolOrders(cfg *config.Config, exchange services.Exchange, symbol string, orders []models.Order) {
 	// 批量获取该交易对的所有开放订单
-	openOrders, err := client.NewListOpenOrdersService().Symbol(symbol).Do(context.Background())
+	openOrders, err := exchange.ListOpenOrders(context.Background(), symbol)
 	if err != nil {
 		log.Printf("获取 %s 开放订单失败: %v", symbol, err)
 		return
@@ -120,19 +132,16 @@ func processSymbolOrders(cfg *config.Config, client *binance.Client, symbol stri
 
 	// 检查每个订单的状态
 	for _, order := range orders {
-		updateOrderStatus(cfg, client, order, openOrderMap)
+		updateOrderStatus(cfg, exchange, order, openOrderMap)
 	}
 }
 
 // updateOrderStatus 更新单个订单状态
-func updateOrderStatus(cfg *config.Config, client *binance.Client, order models.Order, openOrderMap map[int64]bool) {
+func updateOrderStatus(cfg *config.Config, exchange services.Exchange, order models.Order, openOrderMap map[int64]bool) {
 	// 如果订单不在开放订单列表中，需要查询具体状态
 	if !openOrderMap[order.OrderID] {
 		// 查询订单详情
-		binanceOrder, err := client.NewGetOrderService().
-			Symbol(order.Symbol).
-			OrderID(order.OrderID).
-			Do(context.Background())
+		binanceOrder, err := exchange.GetOrder(context.Background(), order.Symbol, order.OrderID)
 
 		if err != nil {
 			log.Printf("查询订单 %d 失败: %v", order.OrderID, err)
@@ -144,38 +153,88 @@ func updateOrderStatus(cfg *config.Config, client *binance.Client, order models.
 			return
 		}
 
-		// 根据币安订单状态更新本地状态
-		switch binanceOrder.Status {
-		case binance.OrderStatusTypeFilled:
-			updateOrderStatusInDB(cfg, &order, "filled")
-		case binance.OrderStatusTypeCanceled:
-			updateOrderStatusInDB(cfg, &order, "cancelled")
-		case binance.OrderStatusTypeExpired:
-			updateOrderStatusInDB(cfg, &order, "expired")
-		case binance.OrderStatusTypeRejected:
-			updateOrderStatusInDB(cfg, &order, "rejected")
-		case binance.OrderStatusTypePartiallyFilled:
-			// 部分成交仍然是待处理状态，但需要检查是否超时
-			checkOrderTimeout(cfg, client, &order)
-		default:
-			// 其他状态保持 pending
-			checkOrderTimeout(cfg, client, &order)
+		// 终态订单同步成交明细，撤销或过期的订单可能已部分成交
+		if isFinalOrderStatus(binanceOrder.Status) {
+			if executed, _ := strconv.ParseFloat(binanceOrder.ExecutedQuantity, 64); executed > 0 {
+				recordOrderFills(cfg, exchange, order)
+			}
 		}
+
+		applyOrderStatus(cfg, exchange, order, binanceOrder)
 	} else {
 		// 订单仍在开放列表中，检查是否需要超时取消
-		checkOrderTimeout(cfg, client, &order)
+		checkOrderTimeout(cfg, exchange, &order)
+	}
+}
+
+// applyOrderStatus 根据交易所订单状态更新本地订单，轮询和用户数据流共用
+func applyOrderStatus(cfg *config.Config, exchange services.Exchange, order models.Order, binanceOrder *binance.Order) {
+	switch binanceOrder.Status {
+	case binance.OrderStatusTypeFilled:
+		// 轮询和用户数据流可能同时看到成交，只有把订单改为 filled 的一方发出通知
+		var claimed bool
+		switch {
+		case order.GridLevel > 0:
+			// 网格和定投订单由各自的成交处理更新状态
+			if claimed = handleGridOrderFilled(cfg, exchange, order, gridFillPrice(binanceOrder)); claimed {
+				publishOrderStatus(order, "filled")
+			}
+		case order.DCAStrategyID > 0:
+			if claimed = handleDCAOrderFilled(cfg, order, binanceOrder); claimed {
+				publishOrderStatus(order, "filled")
+			}
+		default:
+			claimed = markOrderFilled(cfg, &order)
+		}
+		if claimed {
+			emitOrderFilled(order, binanceOrder)
+		}
+	case binance.OrderStatusTypeCanceled:
+		updateOrderStatusInDB(cfg, &order, "cancelled")
+	case binance.OrderStatusTypeExpired:
+		updateOrderStatusInDB(cfg, &order, "expired")
+	case binance.OrderStatusTypeRejected:
+		updateOrderStatusInDB(cfg, &order, "rejected")
+	case binance.OrderStatusTypePartiallyFilled:
+		// 部分成交仍然是待处理状态，但需要检查是否超时
+		checkOrderTimeout(cfg, exchange, &order)
+	default:
+		// 其他状态保持 pending
+		checkOrderTimeout(cfg, exchange, &order)
 	}
 }
 
+// emitOrderFilled 发出现货订单成交通知
+func emitOrderFilled(order models.Order, binanceOrder *binance.Order) {
+	EmitNotification(NotificationEvent{
+		UserID:  order.UserID,
+		Event:   models.NotifyOrderFilled,
+		Title:   fmt.Sprintf("订单成交: %s %s", order.Symbol, order.Side),
+		Message: fmt.Sprintf("订单 %d 成交 %s，成交额 %s", order.OrderID, binanceOrder.ExecutedQuantity, binanceOrder.CummulativeQuoteQuantity),
+		Data: map[string]interface{}{
+			"market":     "spot",
+			"orderId":    order.OrderID,
+			"strategyId": order.StrategyID,
+			"symbol":     order.Symbol,
+			"side":       order.Side,
+			"quantity":   binanceOrder.ExecutedQuantity,
+			"quote":      binanceOrder.CummulativeQuoteQuantity,
+			"paper":      order.Paper,
+		},
+	})
+}
+
 // checkOrderTimeout 检查订单是否超时
-func checkOrderTimeout(cfg *config.Config, client *binance.Client, order *models.Order) {
+func checkOrderTimeout(cfg *config.Config, exchange services.Exchange, order *models.Order) {
+	// 网格订单长期挂单，由区间重新平衡或停用策略时撤销
+	if order.GridLevel > 0 {
+		return
+	}
+
 	if time.Now().After(order.CancelAfter) {
 		log.Printf("订单 %d 已超时，准备取消", order.OrderID)
 
-		_, err := client.NewCancelOrderService().
-			Symbol(order.Symbol).
-			OrderID(order.OrderID).
-			Do(context.Background())
+		err := exchange.CancelOrder(context.Background(), order.Symbol, order.OrderID)
 
 		if err != nil {
 			if !isOrderNotFoundError(err) {
@@ -185,6 +244,7 @@ func checkOrderTimeout(cfg *config.Config, client *binance.Client, order *models
 		}
 
 		updateOrderStatusInDB(cfg, order, "cancelled")
+		recordOrderFills(cfg, exchange, *order)
 		log.Printf("订单 %d 因超时被取消", order.OrderID)
 	}
 }
@@ -197,6 +257,7 @@ func updateOrderStatusInDB(cfg *config.Config, order *models.Order, status strin
 	}
 
 	log.Printf("订单 %d 状态更新为: %s", order.OrderID, status)
+	publishOrderStatus(*order, status)
 
 	// 如果订单完成或取消，检查策略状态
 	if status == "filled" || status == "cancelled" || status == "expired" || status == "rejected" {
@@ -204,6 +265,23 @@ func updateOrderStatusInDB(cfg *config.Config, order *models.Order, status strin
 	}
 }
 
+// markOrderFilled 将订单标记为已成交，订单已是 filled 时返回 false
+func markOrderFilled(cfg *config.Config, order *models.Order) bool {
+	result := cfg.DB.Model(order).Where("status != ?", "filled").Update("status", "filled")
+	if result.Error != nil {
+		log.Printf("更新订单 %d 状态为 filled 失败: %v", order.OrderID, result.Error)
+		return false
+	}
+	if result.RowsAffected != 1 {
+		return false
+	}
+
+	log.Printf("订单 %d 状态更新为: filled", order.OrderID)
+	publishOrderStatus(*order, "filled")
+	checkStrategyCompletion(cfg, order.StrategyID)
+	return true
+}
+
 // checkStrategyCompletion 检查策略是否完成
 func checkStrategyCompletion(cfg *config.Config, strategyID uint) {
 	if strategyID == 0 {
@@ -232,11 +310,22 @@ func checkStrategyCompletion(cfg *config.Config, strategyID uint) {
 				log.Printf("重置策略 %d 的 pending_batch 失败: %v", strategy.ID, err)
 			} else {
 				log.Printf("策略 %d 的所有订单已完成，pending_batch 已重置", strategy.ID)
+				publishStrategyState(strategy.UserID, "spot", strategy.ID, "batch_completed")
 			}
 		}
 	}
 }
 
+// isFinalOrderStatus 判断交易所订单是否已结束
+func isFinalOrderStatus(status binance.OrderStatusType) bool {
+	switch status {
+	case binance.OrderStatusTypeFilled, binance.OrderStatusTypeCanceled,
+		binance.OrderStatusTypeExpired, binance.OrderStatusTypeRejected:
+		return true
+	}
+	return false
+}
+
 // isOrderNotFoundError 判断是否为订单不存在错误
 func isOrderNotFoundError(err error) bool {
 	if err == nil {
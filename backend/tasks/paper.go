@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/services"
+)
+
+// paperPriceRange 记录两次撮合之间的最高价和最低价
+type paperPriceRange struct {
+	mu      sync.Mutex
+	low     float64
+	high    float64
+	lastRun time.Time
+	running bool
+}
+
+var paperPriceRanges sync.Map // market|symbol -> *paperPriceRange
+
+// matchPaperOrders 收到价格推送时撮合模拟盘挂单（每个交易对每秒最多撮合一次）
+func matchPaperOrders(cfg *config.Config, market, symbol string, price float64) {
+	value, _ := paperPriceRanges.LoadOrStore(market+"|"+symbol, &paperPriceRange{})
+	r := value.(*paperPriceRange)
+
+	r.mu.Lock()
+	if r.low == 0 || price < r.low {
+		r.low = price
+	}
+	if price > r.high {
+		r.high = price
+	}
+	if r.running || time.Since(r.lastRun) < time.Second {
+		r.mu.Unlock()
+		return
+	}
+	low, high := r.low, r.high
+	r.low, r.high = 0, 0
+	r.running = true
+	r.lastRun = time.Now()
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			r.running = false
+			r.mu.Unlock()
+		}()
+		if err := services.MatchPaperOrders(cfg.DB, market, symbol, low, high, price); err != nil {
+			log.Printf("撮合 %s 模拟盘订单失败: %v", symbol, err)
+		}
+	}()
+}
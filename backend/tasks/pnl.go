@@ -0,0 +1,195 @@
+package tasks
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ccj241/binance/models"
+)
+
+// 成本计算方式
+const (
+	CostMethodFIFO    = "fifo"
+	CostMethodAverage = "average"
+)
+
+// PnLSummary 一个资产或一个策略的成本与盈亏汇总，金额均为USDT
+type PnLSummary struct {
+	Key                   string  `json:"key"`
+	StrategyID            uint    `json:"strategyId,omitempty"`
+	DCAStrategyID         uint    `json:"dcaStrategyId,omitempty"`
+	Symbol                string  `json:"symbol,omitempty"`
+	BaseAsset             string  `json:"baseAsset"`
+	Fills                 int     `json:"fills"`
+	BuyQuantity           float64 `json:"buyQuantity"`
+	SellQuantity          float64 `json:"sellQuantity"`
+	BuyVolume             float64 `json:"buyVolume"`
+	SellVolume            float64 `json:"sellVolume"`
+	Commission            float64 `json:"commission"`
+	Position              float64 `json:"position"`              // 当前持仓数量
+	CostBasis             float64 `json:"costBasis"`             // 当前持仓成本
+	AverageCost           float64 `json:"averageCost"`           // 持仓单位成本
+	RealizedPnL           float64 `json:"realizedPnl"`           // 已实现盈亏（已扣手续费）
+	UnrealizedPnL         float64 `json:"unrealizedPnl"`         // 按最新价计算的浮动盈亏
+	MarkPrice             float64 `json:"markPrice"`             // 基础资产最新USDT价格
+	UnmatchedSellQuantity float64 `json:"unmatchedSellQuantity"` // 超出账本持仓的卖出数量，按零盈亏处理
+}
+
+// costLot FIFO 的一笔买入批次，均价法只使用总量和总成本
+type costLot struct {
+	quantity float64
+	unitCost float64
+}
+
+// costLedger 按时间顺序回放成交，维护持仓成本
+type costLedger struct {
+	method   string
+	lots     []costLot
+	summary  *PnLSummary
+	position float64
+	cost     float64
+}
+
+// apply 记入一笔成交
+func (l *costLedger) apply(fill models.Fill) {
+	s := l.summary
+	s.Fills++
+	s.Commission += fill.CommissionUSDT
+
+	quantity := fill.NetQuantity()
+	value := fill.QuoteUSDT()
+	s.RealizedPnL -= fill.FeeUSDT()
+	if quantity <= 0 {
+		return
+	}
+
+	if fill.Side == "BUY" {
+		s.BuyQuantity += fill.Quantity.InexactFloat64()
+		s.BuyVolume += value
+		l.position += quantity
+		l.cost += value
+		if l.method == CostMethodFIFO {
+			l.lots = append(l.lots, costLot{quantity: quantity, unitCost: value / quantity})
+		}
+		return
+	}
+
+	s.SellQuantity += fill.Quantity.InexactFloat64()
+	s.SellVolume += value
+	matched := math.Min(quantity, l.position)
+	var matchedCost float64
+	if l.method == CostMethodAverage {
+		if l.position > 0 {
+			matchedCost = l.cost * matched / l.position
+		}
+	} else {
+		remaining := matched
+		for remaining > 1e-12 && len(l.lots) > 0 {
+			lot := &l.lots[0]
+			take := math.Min(remaining, lot.quantity)
+			matchedCost += take * lot.unitCost
+			lot.quantity -= take
+			remaining -= take
+			if lot.quantity <= 1e-12 {
+				l.lots = l.lots[1:]
+			}
+		}
+	}
+
+	l.position -= matched
+	l.cost -= matchedCost
+	if l.position <= 1e-12 {
+		l.position, l.cost, l.lots = 0, 0, nil
+	}
+
+	// 账本外的存量卖出没有成本记录，按卖出价计成本
+	s.UnmatchedSellQuantity += quantity - matched
+	s.RealizedPnL += value*matched/quantity - matchedCost
+}
+
+// finish 结束回放并按最新价格计算浮动盈亏
+func (l *costLedger) finish(markPrice float64) PnLSummary {
+	s := l.summary
+	s.Position = l.position
+	s.CostBasis = l.cost
+	if l.position > 0 {
+		s.AverageCost = l.cost / l.position
+	}
+	s.MarkPrice = markPrice
+	if markPrice > 0 && l.position > 0 {
+		s.UnrealizedPnL = l.position*markPrice - l.cost
+	}
+	return *s
+}
+
+// ComputePnL 按资产（groupBy=asset）或策略（groupBy=strategy）汇总成交的成本和盈亏
+// markPrices 为基础资产的最新USDT价格，缺失时不计算浮动盈亏
+func ComputePnL(fills []models.Fill, method, groupBy string, markPrices map[string]float64) ([]PnLSummary, error) {
+	if method == "" {
+		method = CostMethodFIFO
+	}
+	if method != CostMethodFIFO && method != CostMethodAverage {
+		return nil, fmt.Errorf("未知的成本计算方式: %s", method)
+	}
+	if groupBy == "" {
+		groupBy = "asset"
+	}
+	if groupBy != "asset" && groupBy != "strategy" {
+		return nil, fmt.Errorf("未知的汇总方式: %s", groupBy)
+	}
+
+	sorted := make([]models.Fill, len(fills))
+	copy(sorted, fills)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].TradeTime.Equal(sorted[j].TradeTime) {
+			return sorted[i].TradeID < sorted[j].TradeID
+		}
+		return sorted[i].TradeTime.Before(sorted[j].TradeTime)
+	})
+
+	ledgers := make(map[string]*costLedger)
+	var keys []string
+	for _, fill := range sorted {
+		key := fill.BaseAsset
+		summary := PnLSummary{Key: key, BaseAsset: fill.BaseAsset}
+		if groupBy == "strategy" {
+			switch {
+			case fill.StrategyID > 0:
+				key = fmt.Sprintf("strategy:%d", fill.StrategyID)
+			case fill.DCAStrategyID > 0:
+				key = fmt.Sprintf("dca:%d", fill.DCAStrategyID)
+			default:
+				key = "manual:" + fill.Symbol
+			}
+			summary = PnLSummary{Key: key, StrategyID: fill.StrategyID, DCAStrategyID: fill.DCAStrategyID,
+				Symbol: fill.Symbol, BaseAsset: fill.BaseAsset}
+		}
+
+		ledger, ok := ledgers[key]
+		if !ok {
+			ledger = &costLedger{method: method, summary: &summary}
+			ledgers[key] = ledger
+			keys = append(keys, key)
+		}
+		ledger.apply(fill)
+	}
+
+	result := make([]PnLSummary, 0, len(keys))
+	for _, key := range keys {
+		ledger := ledgers[key]
+		result = append(result, ledger.finish(markPrices[ledger.summary.BaseAsset]))
+	}
+	return result, nil
+}
+
+// PnLMarkPrices 查询汇总中持仓资产的最新USDT价格
+func PnLMarkPrices(fills []models.Fill) map[string]float64 {
+	prices := make(map[string]float64)
+	for _, fill := range fills {
+		if _, ok := prices[fill.BaseAsset]; !ok {
+			prices[fill.BaseAsset] = assetUSDTRate(fill.BaseAsset)
+		}
+	}
+	return prices
+}
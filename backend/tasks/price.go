@@ -4,6 +4,7 @@ package tasks
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -15,7 +16,10 @@ import (
 	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var PriceMonitor sync.Map
@@ -76,11 +80,14 @@ func (m *StrategyExecutionManager) TryExecuteStrategy(strategyID uint, minInterv
 	return unlock, true
 }
 
-// WebSocketManager 管理WebSocket连接
+// WebSocketManager 现货成交流连接，每个交易所环境的每个交易对一个连接
 type WebSocketManager struct {
 	symbol       string
-	users        sync.Map // userID -> true
+	endpoints    services.Endpoints // 连接的交易所环境
+	public       bool               // 系统默认环境的连接，负责价格推送、模拟盘撮合、价格提醒和K线聚合
+	users        sync.Map           // userID -> true
 	stopChan     chan struct{}
+	stopOnce     sync.Once
 	doneC        chan struct{}
 	cfg          *config.Config
 	lastPrice    float64
@@ -100,29 +107,101 @@ var dbUpdateManager = &DBUpdateManager{
 }
 
 // MonitorNewSymbol 启动对新交易对的监控
+// 集群模式下只有运行价格监控的实例建立连接，其他实例的请求由该实例定期同步
 func MonitorNewSymbol(symbol string, userID uint, cfg *config.Config) {
+	if !taskActive(taskSpot) {
+		return
+	}
+	monitorPublicSymbol(symbol, userID, cfg)
+
+	// 实盘策略所属账户不在系统默认环境时，另外连接账户环境的成交流触发策略
+	for _, endpoints := range spotAccountEndpoints(cfg, userID, symbol) {
+		joinSpotStream(spotStreamKey{endpoints: endpoints, symbol: symbol}, userID, cfg, spawnSpotStream)
+	}
+}
+
+// monitorPublicSymbol 把用户加入交易对在系统默认环境的连接，价格推送和价格提醒只需要这一个连接
+func monitorPublicSymbol(symbol string, userID uint, cfg *config.Config) {
+	if !taskActive(taskSpot) {
+		return
+	}
 	key := fmt.Sprintf("%s|%d", symbol, userID)
 	if _, loaded := MonitoredSymbols.LoadOrStore(key, true); !loaded {
 		log.Printf("为用户 %d 启动 %s 价格监控", userID, symbol)
+		if !joinSpotStream(spotStreamKey{endpoints: services.DefaultEndpoints(), symbol: symbol}, userID, cfg, spawnSpotStream) {
+			MonitoredSymbols.Delete(key)
+		}
+	}
+}
 
-		// 检查是否已有该交易对的WebSocket连接
-		if manager, ok := wsConnections.Load(symbol); ok {
-			// 将用户添加到现有连接
-			wsManager := manager.(*WebSocketManager)
-			wsManager.users.Store(userID, true)
-			log.Printf("用户 %d 加入现有 %s WebSocket 连接", userID, symbol)
-		} else {
-			// 创建新的WebSocket连接
-			wsManager := &WebSocketManager{
-				symbol:   symbol,
-				stopChan: make(chan struct{}),
-				cfg:      cfg,
-			}
-			wsManager.users.Store(userID, true)
-			wsConnections.Store(symbol, wsManager)
-			go wsManager.start()
+// spotStreamKey 现货成交流连接的标识，不同环境的同一交易对使用不同连接
+type spotStreamKey struct {
+	endpoints services.Endpoints
+	symbol    string
+}
+
+// spawnSpotStream 为价格监控任务启动连接，任务未在本实例运行或正在停止时返回 false
+func spawnSpotStream(name string, fn func()) bool {
+	return spawnForTask(taskSpot, name, fn)
+}
+
+// joinSpotStream 把用户加入交易对在指定环境的连接，连接不存在时创建并通过 start 启动
+// 启动失败时放弃连接，由下次取得租约的实例重新建立
+func joinSpotStream(key spotStreamKey, userID uint, cfg *config.Config, start func(name string, fn func()) bool) bool {
+	manager := &WebSocketManager{
+		symbol:    key.symbol,
+		endpoints: key.endpoints,
+		public:    key.endpoints == services.DefaultEndpoints(),
+		stopChan:  make(chan struct{}),
+		cfg:       cfg,
+	}
+	actual, loaded := wsConnections.LoadOrStore(key, manager)
+	actual.(*WebSocketManager).users.Store(userID, true)
+	if loaded {
+		return true
+	}
+	if !start("现货行情 "+key.symbol, manager.start) {
+		wsConnections.Delete(key)
+		return false
+	}
+	if !manager.public {
+		log.Printf("为用户 %d 连接 %s 环境的 %s 成交流", userID, key.endpoints.Environment, key.symbol)
+	}
+	return true
+}
+
+// spotAccountEndpoints 用户在交易对上启用的实盘策略所属账户的交易所环境，不含系统默认环境
+// 用户开启模拟盘时策略都按公共行情撮合，不需要其他环境的连接
+func spotAccountEndpoints(cfg *config.Config, userID uint, symbol string) []services.Endpoints {
+	var user models.User
+	if err := cfg.DB.Select("id", "paper_trading").First(&user, userID).Error; err != nil || user.PaperTrading {
+		return nil
+	}
+	var accountIDs []uint
+	if err := cfg.DB.Model(&models.Strategy{}).
+		Where("user_id = ? AND symbol = ? AND enabled = ? AND paper = ? AND deleted_at IS NULL", userID, symbol, true, false).
+		Distinct().Pluck("account_id", &accountIDs).Error; err != nil {
+		log.Printf("查询用户 %d 的 %s 实盘策略账户失败: %v", userID, symbol, err)
+		return nil
+	}
+
+	public := services.DefaultEndpoints()
+	seen := make(map[services.Endpoints]bool)
+	var result []services.Endpoints
+	for _, accountID := range accountIDs {
+		endpoints := accountStreamEndpoints(cfg, userID, accountID)
+		if endpoints == public || seen[endpoints] {
+			continue
 		}
+		seen[endpoints] = true
+		result = append(result, endpoints)
 	}
+	return result
+}
+
+// stop 停止WebSocket连接，可以重复调用
+func (m *WebSocketManager) stop() {
+	m.stopOnce.Do(func() { close(m.stopChan) })
 }
 
 // start 启动WebSocket连接
@@ -166,7 +245,12 @@ func (m *WebSocketManager) connect() {
 	// 移除连接日志，只在错误时记录
 
 	// 使用交易流而不是深度流
-	wsTradeHandler := func(event *binance.WsTradeEvent) {
+	wsTradeHandler := func(message []byte) {
+		event := new(binance.WsTradeEvent)
+		if err := json.Unmarshal(message, event); err != nil {
+			log.Printf("解析 %s 成交推送错误: %v", m.symbol, err)
+			return
+		}
 		price, err := strconv.ParseFloat(event.Price, 64)
 		if err != nil {
 			log.Printf("解析 %s 价格错误: %v", m.symbol, err)
@@ -180,31 +264,59 @@ func (m *WebSocketManager) connect() {
 		// 更新所有用户的价格
 		m.users.Range(func(userID, _ interface{}) bool {
 			uid := userID.(uint)
-			key := fmt.Sprintf("%s|%d", m.symbol, uid)
-			PriceMonitor.Store(key, price)
+			if m.public {
+				key := fmt.Sprintf("%s|%d", m.symbol, uid)
+				PriceMonitor.Store(key, price)
+				publishPrice(uid, m.symbol, price)
+			}
 
 			// 异步检查并执行策略
 			go m.checkStrategies(uid, price)
 			return true
 		})
 
+		// 价格展示、模拟盘撮合、价格提醒和K线只使用系统默认环境的公共行情
+		if !m.public {
+			return
+		}
+
 		// 更新数据库价格（限流）
 		m.updatePriceInDB(price)
+
+		// 撮合模拟盘挂单
+		matchPaperOrders(m.cfg, services.PaperMarketSpot, m.symbol, price)
+
+		// 检查价格提醒
+		quantity, _ := strconv.ParseFloat(event.Quantity, 64)
+		evaluateSpotAlerts(m.cfg, m.symbol, price, price*quantity)
+
+		// 聚合K线
+		recordCandleTrade(models.CandleMarketSpot, m.symbol, price, quantity, time.UnixMilli(event.TradeTime))
 	}
 
 	wsErrHandler := func(err error) {
 		log.Printf("%s WebSocket 错误: %v", m.symbol, err)
 	}
 
-	// 使用交易流获取实时成交价
-	doneC, _, err := binance.WsTradeServe(m.symbol, wsTradeHandler, wsErrHandler)
+	// 使用交易流获取实时成交价，地址使用连接所属的交易所环境
+	wsURL := fmt.Sprintf("%s/%s@trade", m.endpoints.SpotWS, strings.ToLower(m.symbol))
+	doneC, stopC, err := serveStream(wsURL, wsTradeHandler, wsErrHandler)
 	if err != nil {
 		log.Printf("启动 %s WebSocket 失败: %v", m.symbol, err)
 		return
 	}
 
 	m.doneC = doneC
-	<-doneC
+	if m.public {
+		candleStreamConnected(models.CandleMarketSpot, m.symbol)
+		defer candleStreamDisconnected(models.CandleMarketSpot, m.symbol)
+	}
+	select {
+	case <-doneC:
+	case <-m.stopChan:
+		close(stopC)
+		<-doneC
+	}
 	// 移除连接关闭日志
 }
 
@@ -223,7 +335,7 @@ func (m *WebSocketManager) updatePriceInDB(price float64) {
 	go func() {
 		priceModel := models.Price{
 			Symbol:    m.symbol,
-			Price:     fmt.Sprintf("%.8f", price),
+			Price:     decimal.NewFromFloat(price),
 			UpdatedAt: time.Now(),
 		}
 
@@ -265,28 +377,17 @@ func (m *WebSocketManager) checkStrategies(userID uint, currentPrice float64) {
 		userCache.Store(userID, user)
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
-	if err != nil {
-		return
-	}
-	secretKey, err := user.GetDecryptedSecretKey()
-	if err != nil {
-		return
-	}
-
-	if apiKey == "" || secretKey == "" {
-		return
-	}
-
 	// 查询用户的活跃策略 - 使用更精确的查询
 	var strategies []models.Strategy
 	if err := m.cfg.DB.
-		Select("id", "symbol", "side", "price", "enabled", "pending_batch", "strategy_type", "total_quantity",
+		Select("id", "account_id", "symbol", "side", "price", "enabled", "pending_batch", "strategy_type", "total_quantity",
 			"buy_quantities", "sell_quantities", "buy_depth_levels", "sell_depth_levels",
-			"buy_basis_points", "sell_basis_points", "cancel_after_minutes").
-		Where("user_id = ? AND symbol = ? AND status = ? AND enabled = ? AND pending_batch = ?",
-			userID, m.symbol, "active", true, false).
+			"buy_basis_points", "sell_basis_points", "cancel_after_minutes", "paper",
+			"grid_lower_price", "grid_upper_price", "grid_count", "grid_quantity", "grid_rebalance",
+			"trigger_condition").
+		Where("user_id = ? AND symbol = ? AND status = ? AND enabled = ?", userID, m.symbol, "active", true).
+		// 运行中的网格策略仍需检查是否超出区间
+		Where("pending_batch = ? OR strategy_type = ?", false, "grid").
 		Where("deleted_at IS NULL").
 		Find(&strategies).Error; err != nil {
 		if err != gorm.ErrRecordNotFound {
@@ -299,34 +400,63 @@ func (m *WebSocketManager) checkStrategies(userID uint, currentPrice float64) {
 		return
 	}
 
-	client := binance.NewClient(apiKey, secretKey)
+	// 实盘策略按所属交易所账户创建客户端（模拟盘策略不需要API密钥）
+	liveExchanges := make(map[uint]services.Exchange)
+	paperExchange := services.NewUserPaperExchange(m.cfg.DB, userID)
 
 	for _, strategy := range strategies {
+		// 用户开启模拟盘时所有策略都走模拟撮合
+		strategy.Paper = strategy.Paper || user.PaperTrading
+		exchange := paperExchange
+		if strategy.Paper && !m.public {
+			continue // 模拟盘策略只按系统默认环境的公共行情触发
+		}
+		if !strategy.Paper {
+			liveExchange, ok := liveExchanges[strategy.AccountID]
+			if !ok {
+				var err error
+				if liveExchange, err = services.NewUserExchange(m.cfg.DB, userID, strategy.AccountID); err != nil {
+					liveExchange = nil
+				}
+				liveExchanges[strategy.AccountID] = liveExchange
+			}
+			// 实盘策略只由所属账户环境的成交流触发
+			if liveExchange == nil || services.ExchangeEndpoints(liveExchange) != m.endpoints {
+				continue
+			}
+			exchange = liveExchange
+		}
+
 		// 使用新的并发控制机制
 		unlock, canExecute := strategyManager.TryExecuteStrategy(strategy.ID, 30*time.Second)
 		if !canExecute {
 			continue
 		}
 
+		// 服务停止中不再触发新的下单
+		if !beginOrderPlacement() {
+			unlock()
+			return
+		}
+
 		// 在goroutine中执行策略
 		go func(s models.Strategy) {
+			defer endOrderPlacement()
 			defer unlock()
-			m.executeStrategy(client, s, userID, currentPrice)
+			m.executeStrategy(exchange, s, userID, currentPrice)
 		}(strategy)
 	}
 }
 
 // executeStrategy 执行策略 - 修复版本
-func (m *WebSocketManager) executeStrategy(client *binance.Client, strategy models.Strategy, userID uint, currentPrice float64) {
-	// 检查策略触发条件
-	shouldExecute := false
-	if strategy.Side == "SELL" && currentPrice >= strategy.Price {
-		shouldExecute = true
-	} else if strategy.Side == "BUY" && currentPrice <= strategy.Price {
-		shouldExecute = true
+func (m *WebSocketManager) executeStrategy(exchange services.Exchange, strategy models.Strategy, userID uint, currentPrice float64) {
+	if strategy.StrategyType == "grid" {
+		m.executeGridStrategy(exchange, strategy, userID, currentPrice)
+		return
 	}
 
-	if !shouldExecute {
+	// 检查策略触发价格和指标条件
+	if !liveStrategyTriggered(m.cfg, strategy, currentPrice) {
 		return
 	}
 
@@ -343,7 +473,7 @@ func (m *WebSocketManager) executeStrategy(client *binance.Client, strategy mode
 	}()
 
 	var currentStrategy models.Strategy
-	if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&currentStrategy, strategy.ID).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&currentStrategy, strategy.ID).Error; err != nil {
 		tx.Rollback()
 		log.Printf("查询策略 %d 失败: %v", strategy.ID, err)
 		return
@@ -368,7 +498,7 @@ func (m *WebSocketManager) executeStrategy(client *binance.Client, strategy mode
 	}
 
 	// 获取市场深度
-	depth, err := client.NewDepthService().Symbol(strategy.Symbol).Limit(20).Do(context.Background())
+	depth, err := exchange.GetDepth(context.Background(), strategy.Symbol, 20)
 	if err != nil {
 		log.Printf("获取 %s 深度失败: %v", strategy.Symbol, err)
 		m.cfg.DB.Model(&strategy).Update("pending_batch", false)
@@ -376,15 +506,43 @@ func (m *WebSocketManager) executeStrategy(client *binance.Client, strategy mode
 	}
 
 	// 执行下单
-	err = placeOrders(client, strategy, userID, currentPrice, depth, strategy.Side, m.cfg)
+	err = placeOrders(exchange, strategy, userID, currentPrice, depth, strategy.Side, m.cfg)
 	if err != nil {
 		log.Printf("策略 %d 下单失败: %v", strategy.ID, err)
 		m.cfg.DB.Model(&strategy).Update("pending_batch", false)
+		return
 	}
+
+	publishStrategyState(userID, "spot", strategy.ID, "orders_placed")
+	EmitNotification(NotificationEvent{
+		UserID:  userID,
+		Event:   models.NotifyStrategyTriggered,
+		Title:   fmt.Sprintf("策略触发: %s %s", strategy.Side, strategy.Symbol),
+		Message: fmt.Sprintf("策略 %d 在价格 %.8f 触发并已下单", strategy.ID, currentPrice),
+		Data: map[string]interface{}{
+			"strategyId": strategy.ID,
+			"symbol":     strategy.Symbol,
+			"side":       strategy.Side,
+			"price":      currentPrice,
+			"paper":      exchange != nil && services.IsPaperExchange(exchange),
+		},
+	})
+}
+
+// strategyTriggered 判断当前价格是否满足策略触发条件
+func strategyTriggered(strategy models.Strategy, currentPrice float64) bool {
+	price := decimal.NewFromFloat(currentPrice)
+	if strategy.Side == "SELL" {
+		return price.GreaterThanOrEqual(strategy.Price)
+	}
+	if strategy.Side == "BUY" {
+		return price.LessThanOrEqual(strategy.Price)
+	}
+	return false
 }
 
 // StartPriceMonitoring 开始监控价格
-func StartPriceMonitoring(cfg *config.Config) {
+func StartPriceMonitoring(ctx context.Context, cfg *config.Config) {
 	if cfg.DB == nil {
 		log.Println("数据库未初始化，跳过价格监控")
 		return
@@ -414,56 +572,117 @@ func StartPriceMonitoring(cfg *config.Config) {
 		}
 	}
 
-	// 按交易对分组
-	symbolUsers := make(map[string][]uint)
+	// 每个交易对在系统默认环境建立一个连接，实盘策略账户在其他环境时再连接该环境
+	start := func(name string, fn func()) bool {
+		spawnTask(ctx, name, fn)
+		return true
+	}
+	public := services.DefaultEndpoints()
 	for _, s := range symbols {
-		symbolUsers[s.Symbol] = append(symbolUsers[s.Symbol], s.UserID)
+		MonitoredSymbols.Store(fmt.Sprintf("%s|%d", s.Symbol, s.UserID), true)
+		joinSpotStream(spotStreamKey{endpoints: public, symbol: s.Symbol}, s.UserID, cfg, start)
+		for _, endpoints := range spotAccountEndpoints(cfg, s.UserID, s.Symbol) {
+			joinSpotStream(spotStreamKey{endpoints: endpoints, symbol: s.Symbol}, s.UserID, cfg, start)
+		}
 	}
 
-	// 为每个交易对创建一个WebSocket连接
-	for symbol, users := range symbolUsers {
-		wsManager := &WebSocketManager{
-			symbol:   symbol,
-			stopChan: make(chan struct{}),
-			cfg:      cfg,
-		}
+	// 集群模式下交易对和策略可能在其他实例上添加，定期同步
+	if cfg.Cluster.Enabled {
+		spawnTask(ctx, "同步监控交易对", func() { syncMonitoredSymbols(ctx, cfg) })
+	}
+
+	// 清理任务，停止时关闭所有行情连接
+	cleanupInactiveConnections(ctx, cfg)
+}
 
-		// 添加所有用户
-		for _, userID := range users {
-			wsManager.users.Store(userID, true)
-			MonitoredSymbols.Store(fmt.Sprintf("%s|%d", symbol, userID), true)
+// syncMonitoredSymbols 为上次同步后新添加的交易对和启用的策略建立行情连接
+func syncMonitoredSymbols(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Cluster.RenewInterval)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		wsConnections.Store(symbol, wsManager)
-		go wsManager.start()
+		now := time.Now()
+		var symbols []models.CustomSymbol
+		cfg.DB.Select("DISTINCT symbol, user_id").
+			Where("created_at >= ? AND deleted_at IS NULL", since).
+			Find(&symbols)
+		var strategies []models.Strategy
+		cfg.DB.Select("DISTINCT symbol, user_id").
+			Where("updated_at >= ? AND enabled = ? AND deleted_at IS NULL", since, true).
+			Find(&strategies)
+		since = now
+
+		for _, s := range symbols {
+			MonitorNewSymbol(s.Symbol, s.UserID, cfg)
+		}
+		for _, s := range strategies {
+			MonitorNewSymbol(s.Symbol, s.UserID, cfg)
+		}
 	}
+}
 
-	// 启动清理任务
-	go cleanupInactiveConnections(cfg)
+// stopPriceStreams 关闭所有现货行情连接并清空监控记录，重新取得租约时全部重新建立
+func stopPriceStreams() {
+	wsConnections.Range(func(key, value interface{}) bool {
+		value.(*WebSocketManager).stop()
+		wsConnections.Delete(key)
+		return true
+	})
+	MonitoredSymbols.Range(func(key, _ interface{}) bool {
+		MonitoredSymbols.Delete(key)
+		return true
+	})
 }
 
 // cleanupInactiveConnections 清理不活跃的连接
-func cleanupInactiveConnections(cfg *config.Config) {
-	ticker := time.NewTicker(5 * time.Minute)
+func cleanupInactiveConnections(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.PriceCleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		wsConnections.Range(func(symbol, value interface{}) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			stopPriceStreams()
+			return
+		case <-ticker.C:
+		}
+		wsConnections.Range(func(key, value interface{}) bool {
 			manager := value.(*WebSocketManager)
+			symbol := manager.symbol
 			activeUsers := 0
 
 			manager.users.Range(func(userID, _ interface{}) bool {
 				uid := userID.(uint)
-				// 检查用户是否还有活跃的策略
-				var count int64
-				cfg.DB.Model(&models.Strategy{}).Where(
-					"user_id = ? AND symbol = ? AND enabled = ? AND deleted_at IS NULL",
-					uid, symbol, true,
-				).Count(&count)
-
-				if count == 0 {
+				active := false
+				if manager.public {
+					// 检查用户是否还有活跃的策略
+					var count int64
+					cfg.DB.Model(&models.Strategy{}).Where(
+						"user_id = ? AND symbol = ? AND enabled = ? AND deleted_at IS NULL",
+						uid, symbol, true,
+					).Count(&count)
+					active = count > 0 || hasActiveAlerts(uid, symbol)
+				} else {
+					// 其他环境的连接只服务该环境账户的实盘策略
+					for _, endpoints := range spotAccountEndpoints(cfg, uid, symbol) {
+						if endpoints == manager.endpoints {
+							active = true
+						}
+					}
+				}
+
+				if !active {
 					manager.users.Delete(userID)
-					MonitoredSymbols.Delete(fmt.Sprintf("%s|%d", symbol, uid))
+					if manager.public {
+						MonitoredSymbols.Delete(fmt.Sprintf("%s|%d", symbol, uid))
+					}
 					log.Printf("移除用户 %d 对 %s 的监控", uid, symbol)
 				} else {
 					activeUsers++
@@ -473,8 +692,8 @@ func cleanupInactiveConnections(cfg *config.Config) {
 
 			// 如果没有活跃用户，关闭连接
 			if activeUsers == 0 {
-				close(manager.stopChan)
-				wsConnections.Delete(symbol)
+				manager.stop()
+				wsConnections.Delete(key)
 				log.Printf("关闭 %s WebSocket 连接（无活跃用户）", symbol)
 			}
 
@@ -484,95 +703,66 @@ func cleanupInactiveConnections(cfg *config.Config) {
 }
 
 // placeOrders 下单函数 - 支持自定义取消时间（使用解密后的API密钥）
-func placeOrders(client *binance.Client, strategy models.Strategy, userID uint, currentPrice float64, depth *binance.DepthResponse, side string, cfg *config.Config) error {
-	var quantities []float64
-	var depthLevels []float64
+func placeOrders(exchange services.Exchange, strategy models.Strategy, userID uint, currentPrice float64, depth *binance.DepthResponse, side string, cfg *config.Config) error {
 	var placedOrders []models.Order
 
-	// 获取交易所信息
-	exchangeInfo, err := client.NewExchangeInfoService().Symbol(strategy.Symbol).Do(context.Background())
+	// 获取交易规则
+	filters, err := services.SpotSymbolFilters(context.Background(), services.ExchangeEndpoints(exchange), strategy.Symbol)
 	if err != nil {
-		return fmt.Errorf("获取交易所信息失败: %v", err)
-	}
-
-	var symbolInfo binance.Symbol
-	for _, s := range exchangeInfo.Symbols {
-		if s.Symbol == strategy.Symbol {
-			symbolInfo = s
-			break
-		}
-	}
-
-	// 解析精度信息
-	pricePrecision, quantityPrecision, minNotional := parseSymbolInfo(symbolInfo)
-
-	// 解析数量和深度配置
-	if side == "SELL" {
-		quantities, depthLevels, err = parseQuantitiesAndDepthLevels(
-			strategy.SellQuantities, strategy.SellDepthLevels, strategy.ID)
-	} else {
-		quantities, depthLevels, err = parseQuantitiesAndDepthLevels(
-			strategy.BuyQuantities, strategy.BuyDepthLevels, strategy.ID)
+		return err
 	}
 
+	// 计算各层订单的价格和数量
+	layers, err := planStrategyOrders(strategy, side, depth, filters)
 	if err != nil {
 		return err
 	}
 
-	// 验证数量分配
-	if err := validateQuantities(quantities); err != nil {
-		return fmt.Errorf("数量配置错误: %v", err)
+	// 下单前风控检查
+	var notional, spend decimal.Decimal
+	for _, layer := range layers {
+		notional = notional.Add(layer.Price.Mul(layer.Quantity))
 	}
-
-	// 如果没有配置，使用默认值
-	if len(quantities) == 0 {
-		quantities = []float64{1.0}
-		depthLevels = []float64{1.0}
+	spendAsset := filters.QuoteAsset
+	spend = notional
+	if side == "SELL" {
+		spendAsset = filters.BaseAsset
+		spend = strategy.TotalQuantity
 	}
-
-	// 计算各层订单价格
-	priceLevels, err := calculatePriceLevels(strategy, side, depth, quantities, depthLevels, pricePrecision)
-	if err != nil {
+	if err := CheckOrderRisk(cfg, exchange, RiskOrder{
+		UserID:        userID,
+		AccountID:     strategy.AccountID,
+		Market:        RiskMarketSpot,
+		Source:        "strategy",
+		StrategyID:    strategy.ID,
+		Symbol:        strategy.Symbol,
+		Side:          side,
+		Notional:      notional.InexactFloat64(),
+		NotionalAsset: filters.QuoteAsset,
+		SpendAsset:    spendAsset,
+		Spend:         spend.InexactFloat64(),
+		Orders:        len(layers),
+		Paper:         services.IsPaperExchange(exchange),
+	}); err != nil {
 		return err
 	}
 
 	// 计算订单取消时间
-	cancelAfterMinutes := strategy.CancelAfterMinutes
-	if cancelAfterMinutes <= 0 {
-		cancelAfterMinutes = 120 // 默认120分钟
-	}
-	cancelAfterDuration := time.Duration(cancelAfterMinutes) * time.Minute
+	cancelAfterDuration := strategyCancelAfter(strategy)
 
 	// 执行下单
 	successCount := 0
 	failCount := 0
 
-	for i, priceLevel := range priceLevels {
-		if i >= len(quantities) {
-			break
-		}
-
-		price := priceLevel.Price
-		quantity := strategy.TotalQuantity * quantities[i]
-
-		// 确保满足最小名义价值
-		if price*quantity < minNotional {
-			quantity = math.Ceil(minNotional/price*math.Pow(10, float64(quantityPrecision))) /
-				math.Pow(10, float64(quantityPrecision))
-		}
-
-		// 格式化价格和数量
-		priceStr := fmt.Sprintf("%.*f", pricePrecision, price)
-		quantityStr := fmt.Sprintf("%.*f", quantityPrecision, quantity)
-
-		order, err := client.NewCreateOrderService().
-			Symbol(strategy.Symbol).
-			Side(binance.SideType(side)).
-			Type(binance.OrderTypeLimit).
-			TimeInForce(binance.TimeInForceTypeGTC).
-			Quantity(quantityStr).
-			Price(priceStr).
-			Do(context.Background())
+	for _, layer := range layers {
+		order, err := exchange.CreateOrder(context.Background(), services.SpotOrderRequest{
+			Symbol:      strategy.Symbol,
+			Side:        binance.SideType(side),
+			Type:        binance.OrderTypeLimit,
+			TimeInForce: binance.TimeInForceTypeGTC,
+			Quantity:    layer.QuantityStr,
+			Price:       layer.PriceStr,
+		})
 
 		if err != nil {
 			failCount++
@@ -588,19 +778,21 @@ func placeOrders(client *binance.Client, strategy models.Strategy, userID uint,
 		dbOrder := models.Order{
 			StrategyID:  strategy.ID,
 			UserID:      userID,
+			AccountID:   strategy.AccountID,
 			Symbol:      strategy.Symbol,
 			Side:        side,
-			Price:       price,
-			Quantity:    quantity,
+			Price:       layer.Price,
+			Quantity:    layer.Quantity,
 			OrderID:     order.OrderID,
 			Status:      "pending",
 			CancelAfter: time.Now().Add(cancelAfterDuration),
+			Paper:       services.IsPaperExchange(exchange),
 		}
 
 		if err := cfg.DB.Create(&dbOrder).Error; err != nil {
 			log.Printf("保存订单失败: %v", err)
 			// 取消刚下的订单
-			client.NewCancelOrderService().Symbol(strategy.Symbol).OrderID(order.OrderID).Do(context.Background())
+			exchange.CancelOrder(context.Background(), strategy.Symbol, order.OrderID)
 			continue
 		}
 
@@ -622,14 +814,96 @@ func placeOrders(client *binance.Client, strategy models.Strategy, userID uint,
 	return nil
 }
 
+// OrderLayer 策略单次触发时某一层的委托
+type OrderLayer struct {
+	Layer       int // 层级，从0开始
+	Price       decimal.Decimal
+	Quantity    decimal.Decimal
+	PriceStr    string // 下单使用的字符串，与 Price 数值一致
+	QuantityStr string
+}
+
+// planStrategyOrders 根据策略配置和市场深度计算各层委托的价格和数量
+func planStrategyOrders(strategy models.Strategy, side string, depth *binance.DepthResponse, filters *services.SymbolFilters) ([]OrderLayer, error) {
+	var quantities []float64
+	var depthLevels []float64
+	var err error
+
+	// 解析数量和深度配置
+	if side == "SELL" {
+		quantities, depthLevels, err = parseQuantitiesAndDepthLevels(
+			strategy.SellQuantities, strategy.SellDepthLevels, strategy.ID)
+	} else {
+		quantities, depthLevels, err = parseQuantitiesAndDepthLevels(
+			strategy.BuyQuantities, strategy.BuyDepthLevels, strategy.ID)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 验证数量分配
+	if err := validateQuantities(quantities); err != nil {
+		return nil, fmt.Errorf("数量配置错误: %v", err)
+	}
+
+	// 如果没有配置，使用默认值
+	if len(quantities) == 0 {
+		quantities = []float64{1.0}
+		depthLevels = []float64{1.0}
+	}
+
+	// 计算各层订单价格
+	priceLevels, err := calculatePriceLevels(strategy, side, depth, quantities, depthLevels, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []OrderLayer
+	for i, priceLevel := range priceLevels {
+		if i >= len(quantities) {
+			break
+		}
+
+		price := priceLevel.Price
+		quantity := filters.QuantizeQuantity(strategy.TotalQuantity.Mul(decimal.NewFromFloat(quantities[i])), services.RoundDown)
+
+		// 确保满足最小名义价值
+		minNotional := decimal.NewFromFloat(filters.MinNotional)
+		if price.Mul(quantity).LessThan(minNotional) {
+			quantity = filters.QuantizeQuantity(minNotional.Div(price), services.RoundUp)
+		}
+
+		// 格式化价格和数量
+		layers = append(layers, OrderLayer{
+			Layer:       i,
+			Price:       price,
+			Quantity:    quantity,
+			PriceStr:    filters.PriceString(price),
+			QuantityStr: filters.QuantityString(quantity),
+		})
+	}
+
+	return layers, nil
+}
+
+// strategyCancelAfter 返回策略订单的自动取消时长
+func strategyCancelAfter(strategy models.Strategy) time.Duration {
+	cancelAfterMinutes := strategy.CancelAfterMinutes
+	if cancelAfterMinutes <= 0 {
+		cancelAfterMinutes = 120 // 默认120分钟
+	}
+	return time.Duration(cancelAfterMinutes) * time.Minute
+}
+
 // PriceLevel 价格级别
 type PriceLevel struct {
-	Price float64
+	Price decimal.Decimal
 }
 
 // calculatePriceLevels 根据市场深度计算价格级别 - 支持自定义万分比
 func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.DepthResponse,
-	quantities []float64, depthLevels []float64, pricePrecision int) ([]PriceLevel, error) {
+	quantities []float64, depthLevels []float64, filters *services.SymbolFilters) ([]PriceLevel, error) {
 
 	var priceLevels []PriceLevel
 	var depthData []binance.Ask
@@ -646,7 +920,7 @@ func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.
 	}
 
 	// 获取基准价格
-	basePrice, err := strconv.ParseFloat(depthData[0].Price, 64)
+	basePrice, err := decimal.NewFromString(depthData[0].Price)
 	if err != nil {
 		return nil, fmt.Errorf("解析基准价格失败: %v", err)
 	}
@@ -667,9 +941,7 @@ func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.
 		}
 
 		for i := 0; i < len(quantities) && i < len(basisPoints); i++ {
-			multiplier := 1 + (basisPoints[i] / 10000)
-			price := basePrice * multiplier
-			priceLevels = append(priceLevels, PriceLevel{Price: price})
+			priceLevels = append(priceLevels, PriceLevel{Price: applyBasisPoints(basePrice, basisPoints[i])})
 		}
 
 	case "custom":
@@ -703,7 +975,7 @@ func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.
 					level = len(depthData) - 1
 				}
 
-				price, err := strconv.ParseFloat(depthData[level].Price, 64)
+				price, err := decimal.NewFromString(depthData[level].Price)
 				if err != nil {
 					continue
 				}
@@ -713,12 +985,11 @@ func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.
 		} else {
 			// 使用万分比计算价格
 			for i := 0; i < len(quantities) && i < len(basisPoints); i++ {
-				multiplier := 1 + (basisPoints[i] / 10000)
-				price := basePrice * multiplier
+				price := applyBasisPoints(basePrice, basisPoints[i])
 
 				// 确保价格为正数
-				if price <= 0 {
-					price = basePrice * 0.9999
+				if !price.IsPositive() {
+					price = applyBasisPoints(basePrice, -1)
 				}
 
 				priceLevels = append(priceLevels, PriceLevel{Price: price})
@@ -729,54 +1000,17 @@ func calculatePriceLevels(strategy models.Strategy, side string, depth *binance.
 		return nil, fmt.Errorf("未知策略类型: %s", strategy.StrategyType)
 	}
 
-	// 对价格进行精度处理
+	// 价格取整到 tickSize
 	for i := range priceLevels {
-		priceLevels[i].Price = math.Round(priceLevels[i].Price*math.Pow(10, float64(pricePrecision))) /
-			math.Pow(10, float64(pricePrecision))
+		priceLevels[i].Price = filters.QuantizePrice(priceLevels[i].Price)
 	}
 
 	return priceLevels, nil
 }
 
-// parseSymbolInfo 解析交易对信息
-func parseSymbolInfo(symbol binance.Symbol) (pricePrecision, quantityPrecision int, minNotional float64) {
-	// 设置默认值
-	pricePrecision = 8
-	quantityPrecision = 8
-	minNotional = 10.0
-
-	for _, filter := range symbol.Filters {
-		switch filter["filterType"] {
-		case "PRICE_FILTER":
-			if tickSize, ok := filter["tickSize"].(string); ok {
-				if ts, err := strconv.ParseFloat(tickSize, 64); err == nil && ts > 0 {
-					pricePrecision = int(-math.Log10(ts))
-				}
-			}
-		case "LOT_SIZE":
-			if stepSize, ok := filter["stepSize"].(string); ok {
-				if ss, err := strconv.ParseFloat(stepSize, 64); err == nil && ss > 0 {
-					quantityPrecision = int(-math.Log10(ss))
-				}
-			}
-		case "MIN_NOTIONAL", "NOTIONAL":
-			if minNot, ok := filter["minNotional"].(string); ok {
-				if mn, err := strconv.ParseFloat(minNot, 64); err == nil {
-					minNotional = mn
-				}
-			}
-		}
-	}
-
-	// 限制精度范围
-	if pricePrecision > 8 {
-		pricePrecision = 8
-	}
-	if quantityPrecision > 8 {
-		quantityPrecision = 8
-	}
-
-	return
+// applyBasisPoints 按万分比偏移价格，使用定点运算避免浮点误差
+func applyBasisPoints(price decimal.Decimal, basisPoints float64) decimal.Decimal {
+	return price.Mul(decimal.NewFromInt(1).Add(decimal.NewFromFloat(basisPoints).Shift(-4)))
 }
 
 // parseQuantitiesAndDepthLevels 解析数量和深度级别配置
@@ -859,9 +1093,12 @@ func StopSymbolMonitoring(symbol string, userID uint) {
 	MonitoredSymbols.Delete(key)
 	PriceMonitor.Delete(key)
 
-	// 检查是否还有其他用户在监控这个交易对
-	if manager, ok := wsConnections.Load(symbol); ok {
+	// 检查各环境的连接是否还有其他用户在监控这个交易对
+	wsConnections.Range(func(connKey, manager interface{}) bool {
 		wsManager := manager.(*WebSocketManager)
+		if wsManager.symbol != symbol {
+			return true
+		}
 		wsManager.users.Delete(userID)
 
 		// 检查是否还有其他用户
@@ -873,18 +1110,14 @@ func StopSymbolMonitoring(symbol string, userID uint) {
 
 		// 如果没有其他用户，关闭WebSocket连接
 		if activeUsers == 0 {
-			select {
-			case <-wsManager.stopChan:
-				// 已经关闭
-			default:
-				close(wsManager.stopChan)
-			}
-			wsConnections.Delete(symbol)
+			wsManager.stop()
+			wsConnections.Delete(connKey)
 			log.Printf("停止 %s WebSocket 连接（用户 %d 移除后无其他用户）", symbol, userID)
 		} else {
 			log.Printf("用户 %d 停止监控 %s，还有 %d 个其他用户在监控", userID, symbol, activeUsers)
 		}
-	}
+		return true
+	})
 
 	log.Printf("用户 %d 停止监控交易对 %s", userID, symbol)
 }
@@ -0,0 +1,493 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 风控下单市场
+const (
+	RiskMarketSpot    = "spot"
+	RiskMarketFutures = "futures"
+	RiskMarketDual    = "dual"
+)
+
+// 同一策略重复触发同一规则时，间隔内只记录一次风控事件
+const riskEventDedupWindow = 10 * time.Minute
+
+// RiskOrder 一次下单（可包含多笔委托）的风控检查参数
+type RiskOrder struct {
+	UserID        uint
+	AccountID     uint   // 交易所账户，0表示默认账户；模拟盘不区分账户
+	Market        string // spot/futures/dual
+	Source        string // strategy/grid/dca/futures_strategy/dual_investment/manual
+	StrategyID    uint
+	Symbol        string
+	Side          string  // 现货 BUY/SELL，合约 LONG/SHORT
+	Notional      float64 // 本次新增的名义价值，合约为本金×杠杆
+	NotionalAsset string  // 名义价值的计价资产，为空表示USDT
+	Leverage      int     // 合约杠杆
+	SpendAsset    string  // 现货和双币投资消耗余额的资产
+	Spend         float64 // 消耗的资产数量
+	Orders        int     // 本次新增的挂单数
+	Paper         bool
+}
+
+// RiskViolation 风控拒单错误
+type RiskViolation struct {
+	Rule    string
+	Message string
+}
+
+func (v *RiskViolation) Error() string {
+	return "风控拒绝: " + v.Message
+}
+
+// IsRiskViolation 判断错误是否为风控拒单
+func IsRiskViolation(err error) bool {
+	var violation *RiskViolation
+	return errors.As(err, &violation)
+}
+
+// RiskDayStart 单日亏损按服务器时区的自然日统计
+func RiskDayStart(now time.Time) time.Time {
+	year, month, day := now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+}
+
+// GetRiskLimit 获取用户的风控限额，未配置时返回 nil
+func GetRiskLimit(db *gorm.DB, userID uint) (*models.RiskLimit, error) {
+	var limit models.RiskLimit
+	err := db.Where("user_id = ? AND deleted_at IS NULL", userID).First(&limit).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// CheckOrderRisk 下单前检查用户的风控限额，超限时记录风控事件并返回 *RiskViolation
+// 未配置限额或关闭风控时直接放行；无法获取账户数据时拒绝下单
+func CheckOrderRisk(cfg *config.Config, exchange services.Exchange, order RiskOrder) error {
+	// 紧急停止生效期间拒绝一切新开仓，不受风控开关影响
+	killSwitch, err := ActiveKillSwitch(cfg.DB, order.UserID)
+	if err != nil {
+		return fmt.Errorf("查询紧急停止状态失败: %v", err)
+	}
+	if killSwitch != nil {
+		message := fmt.Sprintf("紧急停止生效中（%s）: %s", killSwitch.Scope, killSwitch.Reason)
+		recordRiskEvent(cfg, models.RiskEvent{
+			UserID:     order.UserID,
+			Rule:       models.RiskRuleKillSwitch,
+			Source:     order.Source,
+			StrategyID: order.StrategyID,
+			Symbol:     order.Symbol,
+			Message:    message,
+			Paper:      order.Paper,
+		})
+		return &RiskViolation{Rule: models.RiskRuleKillSwitch, Message: message}
+	}
+
+	limit, err := GetRiskLimit(cfg.DB, order.UserID)
+	if err != nil {
+		return fmt.Errorf("查询风控限额失败: %v", err)
+	}
+	if limit == nil || !limit.Enabled {
+		return nil
+	}
+
+	// 实盘敞口、挂单数和钱包余额都按下单的交易所账户统计
+	if !order.Paper {
+		account, err := services.ResolveAccount(cfg.DB, order.UserID, order.AccountID)
+		if err != nil {
+			return err
+		}
+		order.AccountID = account.ID
+	}
+
+	// 限额按USDT精确存储，与按行情估算的敞口和余额比较时转换为浮点数
+	maxSymbolNotional := limit.MaxSymbolNotional.InexactFloat64()
+	maxDailyLoss := limit.MaxDailyLoss.InexactFloat64()
+	minFreeBalance := limit.MinFreeBalance.InexactFloat64()
+
+	// 名义价值统一折算为USDT
+	if order.NotionalAsset != "" && order.NotionalAsset != "USDT" && order.Notional > 0 {
+		rate := assetUSDTRate(order.NotionalAsset)
+		if rate <= 0 {
+			return fmt.Errorf("获取 %s 对USDT汇率失败", order.NotionalAsset)
+		}
+		order.Notional *= rate
+	}
+
+	reject := func(rule string, value, threshold float64, message string) error {
+		recordRiskEvent(cfg, models.RiskEvent{
+			UserID:     order.UserID,
+			Rule:       rule,
+			Source:     order.Source,
+			StrategyID: order.StrategyID,
+			Symbol:     order.Symbol,
+			Value:      value,
+			Threshold:  threshold,
+			Message:    message,
+			Paper:      order.Paper,
+		})
+		return &RiskViolation{Rule: rule, Message: message}
+	}
+
+	// 单日亏损超限后当天不再开新仓，模拟盘不受影响
+	if !order.Paper && limit.HaltedSince(RiskDayStart(time.Now())) {
+		return reject(models.RiskRuleDailyLoss, 0, maxDailyLoss,
+			fmt.Sprintf("今日亏损已超过 %.2f USDT，暂停交易至次日", maxDailyLoss))
+	}
+
+	if limit.MaxOpenOrders > 0 && order.Orders > 0 {
+		openOrders, err := countOpenOrders(cfg.DB, order)
+		if err != nil {
+			return fmt.Errorf("统计挂单数失败: %v", err)
+		}
+		if openOrders+int64(order.Orders) > int64(limit.MaxOpenOrders) {
+			return reject(models.RiskRuleOpenOrders, float64(openOrders+int64(order.Orders)), float64(limit.MaxOpenOrders),
+				fmt.Sprintf("挂单数 %d + %d 超过上限 %d", openOrders, order.Orders, limit.MaxOpenOrders))
+		}
+	}
+
+	// 现货卖出减少敞口，不检查名义价值和余额
+	increasesExposure := !(order.Market == RiskMarketSpot && order.Side == "SELL")
+
+	if maxSymbolNotional > 0 && increasesExposure {
+		exposure, err := symbolExposure(cfg.DB, services.ExchangeEndpoints(exchange), order)
+		if err != nil {
+			return fmt.Errorf("统计 %s 敞口失败: %v", order.Symbol, err)
+		}
+		if exposure+order.Notional > maxSymbolNotional {
+			return reject(models.RiskRuleSymbolNotional, exposure+order.Notional, maxSymbolNotional,
+				fmt.Sprintf("%s 名义价值 %.2f + %.2f 超过上限 %.2f USDT", order.Symbol, exposure, order.Notional, maxSymbolNotional))
+		}
+	}
+
+	if order.Market == RiskMarketFutures {
+		if limit.MaxLeverageExposure <= 0 && minFreeBalance <= 0 {
+			return nil
+		}
+		account, err := exchange.GetFuturesAccount(context.Background())
+		if err != nil {
+			return fmt.Errorf("获取合约账户失败: %v", err)
+		}
+
+		if limit.MaxLeverageExposure > 0 {
+			wallet, _ := strconv.ParseFloat(account.TotalWalletBalance, 64)
+			exposure, err := futuresExposure(cfg.DB, order, "")
+			if err != nil {
+				return fmt.Errorf("统计合约敞口失败: %v", err)
+			}
+			leverage := 0.0
+			if wallet > 0 {
+				leverage = (exposure + order.Notional) / wallet
+			}
+			if wallet <= 0 || leverage > limit.MaxLeverageExposure {
+				return reject(models.RiskRuleLeverageExposure, leverage, limit.MaxLeverageExposure,
+					fmt.Sprintf("合约总名义价值 %.2f USDT 对钱包余额 %.2f USDT 的杠杆 %.2fx 超过上限 %.2fx",
+						exposure+order.Notional, wallet, leverage, limit.MaxLeverageExposure))
+			}
+		}
+
+		if minFreeBalance > 0 {
+			available, _ := strconv.ParseFloat(account.AvailableBalance, 64)
+			margin := order.Notional
+			if order.Leverage > 0 {
+				margin = order.Notional / float64(order.Leverage)
+			}
+			if available-margin < minFreeBalance {
+				return reject(models.RiskRuleBalanceReserve, available-margin, minFreeBalance,
+					fmt.Sprintf("合约可用余额 %.2f 扣除保证金 %.2f 后低于保留额 %.2f USDT", available, margin, minFreeBalance))
+			}
+		}
+		return nil
+	}
+
+	if minFreeBalance > 0 && increasesExposure && order.SpendAsset != "" {
+		account, err := exchange.GetAccount(context.Background())
+		if err != nil {
+			return fmt.Errorf("获取账户余额失败: %v", err)
+		}
+		var free float64
+		for _, balance := range account.Balances {
+			if balance.Asset == order.SpendAsset {
+				free, _ = strconv.ParseFloat(balance.Free, 64)
+				break
+			}
+		}
+		rate := assetUSDTRate(order.SpendAsset)
+		if rate <= 0 {
+			return fmt.Errorf("获取 %s 对USDT汇率失败", order.SpendAsset)
+		}
+		remaining := (free - order.Spend) * rate
+		if remaining < minFreeBalance {
+			return reject(models.RiskRuleBalanceReserve, remaining, minFreeBalance,
+				fmt.Sprintf("%s 可用余额 %.8f 扣除 %.8f 后折合 %.2f USDT，低于保留额 %.2f USDT",
+					order.SpendAsset, free, order.Spend, remaining, minFreeBalance))
+		}
+	}
+
+	return nil
+}
+
+// riskScope 限定查询到下单所在的账户：实盘按交易所账户，模拟盘按用户
+func riskScope(db *gorm.DB, order RiskOrder) *gorm.DB {
+	if order.Paper {
+		return db.Where("user_id = ? AND paper = ?", order.UserID, true)
+	}
+	return db.Where("user_id = ? AND paper = ? AND account_id = ?", order.UserID, false, order.AccountID)
+}
+
+// countOpenOrders 统计下单账户现货和合约的未完成订单数
+func countOpenOrders(db *gorm.DB, order RiskOrder) (int64, error) {
+	var spot, futuresCount int64
+	if err := riskScope(db.Model(&models.Order{}), order).
+		Where("status = ? AND deleted_at IS NULL", "pending").
+		Count(&spot).Error; err != nil {
+		return 0, err
+	}
+	if err := riskScope(db.Model(&models.FuturesOrder{}), order).
+		Where("status IN ? AND deleted_at IS NULL",
+			[]string{string(futures.OrderStatusTypeNew), string(futures.OrderStatusTypePartiallyFilled)}).
+		Count(&futuresCount).Error; err != nil {
+		return 0, err
+	}
+	return spot + futuresCount, nil
+}
+
+// symbolExposure 下单账户中交易对在现货买单、合约和双币投资中的名义价值合计（USDT）
+func symbolExposure(db *gorm.DB, endpoints services.Endpoints, order RiskOrder) (float64, error) {
+	var exposure float64
+	symbol := order.Symbol
+
+	// 现货未成交买单
+	var orders []models.Order
+	if err := riskScope(db.Select("price", "quantity", "executed_qty"), order).
+		Where("symbol = ? AND side = ? AND status = ? AND deleted_at IS NULL", symbol, "BUY", "pending").
+		Find(&orders).Error; err != nil {
+		return 0, err
+	}
+	if len(orders) > 0 {
+		quoteRate := 1.0
+		if filters, err := services.SpotSymbolFilters(context.Background(), endpoints, symbol); err == nil {
+			quoteRate = assetUSDTRate(filters.QuoteAsset)
+		}
+		for _, o := range orders {
+			remaining := o.Quantity.Sub(o.ExecutedQty)
+			exposure += o.Price.Mul(remaining).InexactFloat64() * quoteRate
+		}
+	}
+
+	futuresValue, err := futuresExposure(db, order, symbol)
+	if err != nil {
+		return 0, err
+	}
+	exposure += futuresValue
+
+	// 双币投资只有实盘
+	if !order.Paper {
+		var dualOrders []models.DualInvestmentOrder
+		if err := db.Select("invest_asset", "invest_amount").
+			Where("user_id = ? AND account_id = ? AND symbol = ? AND status IN ? AND deleted_at IS NULL",
+				order.UserID, order.AccountID, symbol, []string{"pending", "active"}).
+			Find(&dualOrders).Error; err != nil {
+			return 0, err
+		}
+		for _, o := range dualOrders {
+			exposure += o.InvestAmount.InexactFloat64() * assetUSDTRate(o.InvestAsset)
+		}
+	}
+
+	return exposure, nil
+}
+
+// futuresExposure 下单账户合约持仓和未成交开仓单的名义价值（USDT），symbol 为空时统计全部交易对
+func futuresExposure(db *gorm.DB, order RiskOrder, symbol string) (float64, error) {
+	var exposure float64
+
+	positionQuery := riskScope(db, order).Where("status = ? AND deleted_at IS NULL", "open")
+	if symbol != "" {
+		positionQuery = positionQuery.Where("symbol = ?", symbol)
+	}
+	var positions []models.FuturesPosition
+	if err := positionQuery.Find(&positions).Error; err != nil {
+		return 0, err
+	}
+	for _, position := range positions {
+		price := position.MarkPrice
+		if !price.IsPositive() {
+			price = position.EntryPrice
+		}
+		exposure += position.Quantity.Mul(price).InexactFloat64()
+	}
+
+	orderQuery := riskScope(db, order).Where("order_purpose = ? AND status IN ? AND deleted_at IS NULL",
+		"entry", []string{string(futures.OrderStatusTypeNew), string(futures.OrderStatusTypePartiallyFilled)})
+	if symbol != "" {
+		orderQuery = orderQuery.Where("symbol = ?", symbol)
+	}
+	var orders []models.FuturesOrder
+	if err := orderQuery.Find(&orders).Error; err != nil {
+		return 0, err
+	}
+	for _, o := range orders {
+		exposure += o.Price.Mul(o.Quantity.Sub(o.ExecutedQty)).InexactFloat64()
+	}
+
+	return exposure, nil
+}
+
+// recordRiskEvent 记录风控事件，同一规则和策略在去重间隔内只记录一次
+func recordRiskEvent(cfg *config.Config, event models.RiskEvent) {
+	log.Printf("用户 %d 风控[%s] %s: %s", event.UserID, event.Rule, event.Source, event.Message)
+
+	var recent int64
+	cfg.DB.Model(&models.RiskEvent{}).
+		Where("user_id = ? AND rule = ? AND source = ? AND strategy_id = ? AND symbol = ? AND created_at > ?",
+			event.UserID, event.Rule, event.Source, event.StrategyID, event.Symbol, time.Now().Add(-riskEventDedupWindow)).
+		Count(&recent)
+	if recent > 0 {
+		return
+	}
+	if err := cfg.DB.Create(&event).Error; err != nil {
+		log.Printf("保存风控事件失败: %v", err)
+	}
+}
+
+// StartRiskMonitor 定期检查单日亏损，超限时停止当日交易并停用策略
+func StartRiskMonitor(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.RiskInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkDailyLosses(cfg)
+	}
+}
+
+// checkDailyLosses 检查配置了单日亏损限额的用户
+func checkDailyLosses(cfg *config.Config) {
+	var limits []models.RiskLimit
+	if err := cfg.DB.Where("enabled = ? AND max_daily_loss > 0 AND deleted_at IS NULL", true).
+		Find(&limits).Error; err != nil {
+		log.Printf("获取风控限额失败: %v", err)
+		return
+	}
+
+	dayStart := RiskDayStart(time.Now())
+	for _, limit := range limits {
+		if limit.HaltedSince(dayStart) {
+			continue
+		}
+		pnl, err := DailyPnL(cfg.DB, limit.UserID, dayStart)
+		if err != nil {
+			log.Printf("计算用户 %d 当日盈亏失败: %v", limit.UserID, err)
+			continue
+		}
+		if decimal.NewFromFloat(-pnl).GreaterThanOrEqual(limit.MaxDailyLoss) {
+			haltTrading(cfg, limit, pnl)
+		}
+	}
+}
+
+// DailyPnL 实盘当日盈亏（USDT）：现货当日已实现盈亏、合约当日平仓盈亏和持仓浮动盈亏
+func DailyPnL(db *gorm.DB, userID uint, dayStart time.Time) (float64, error) {
+	var fills []models.Fill
+	if err := db.Where("user_id = ? AND paper = ? AND deleted_at IS NULL", userID, false).
+		Find(&fills).Error; err != nil {
+		return 0, err
+	}
+
+	// 当日已实现盈亏 = 全部成交回放的已实现盈亏 - 当日之前成交回放的已实现盈亏
+	var before []models.Fill
+	for _, fill := range fills {
+		if fill.TradeTime.Before(dayStart) {
+			before = append(before, fill)
+		}
+	}
+	total, err := ComputePnL(fills, CostMethodFIFO, "asset", nil)
+	if err != nil {
+		return 0, err
+	}
+	prior, err := ComputePnL(before, CostMethodFIFO, "asset", nil)
+	if err != nil {
+		return 0, err
+	}
+	var pnl float64
+	for _, s := range total {
+		pnl += s.RealizedPnL
+	}
+	for _, s := range prior {
+		pnl -= s.RealizedPnL
+	}
+
+	var futuresPnL struct {
+		Realized   float64
+		Unrealized float64
+	}
+	if err := db.Model(&models.FuturesPosition{}).
+		Select("COALESCE(SUM(CASE WHEN status = 'closed' AND closed_at >= ? THEN realized_pnl ELSE 0 END), 0) AS realized, "+
+			"COALESCE(SUM(CASE WHEN status = 'open' THEN unrealized_pnl ELSE 0 END), 0) AS unrealized", dayStart).
+		Where("user_id = ? AND paper = ? AND deleted_at IS NULL", userID, false).
+		Scan(&futuresPnL).Error; err != nil {
+		return 0, err
+	}
+
+	return pnl + futuresPnL.Realized + futuresPnL.Unrealized, nil
+}
+
+// haltTrading 单日亏损超限：当日拒绝新开仓并停用实盘策略，已有持仓的止盈止损不受影响
+func haltTrading(cfg *config.Config, limit models.RiskLimit, pnl float64) {
+	now := time.Now()
+	reason := fmt.Sprintf("当日亏损 %.2f USDT 超过上限 %.2f USDT，已停用全部实盘策略", -pnl, limit.MaxDailyLoss.InexactFloat64())
+
+	if err := cfg.DB.Model(&limit).Updates(map[string]interface{}{
+		"halted_at":   &now,
+		"halt_reason": reason,
+	}).Error; err != nil {
+		log.Printf("更新用户 %d 风控状态失败: %v", limit.UserID, err)
+		return
+	}
+
+	db := cfg.DB
+	db.Model(&models.Strategy{}).
+		Where("user_id = ? AND paper = ? AND enabled = ?", limit.UserID, false, true).
+		Update("enabled", false)
+	db.Model(&models.FuturesStrategy{}).
+		Where("user_id = ? AND paper = ? AND enabled = ? AND status = ?", limit.UserID, false, true, "waiting").
+		Update("enabled", false)
+	db.Model(&models.DCAStrategy{}).
+		Where("user_id = ? AND paper = ? AND enabled = ?", limit.UserID, false, true).
+		Update("enabled", false)
+	db.Model(&models.DualInvestmentStrategy{}).
+		Where("user_id = ? AND enabled = ?", limit.UserID, true).
+		Update("enabled", false)
+
+	recordRiskEvent(cfg, models.RiskEvent{
+		UserID:    limit.UserID,
+		Rule:      models.RiskRuleDailyLoss,
+		Source:    "monitor",
+		Value:     -pnl,
+		Threshold: limit.MaxDailyLoss.InexactFloat64(),
+		Message:   reason,
+	})
+}
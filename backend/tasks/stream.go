@@ -0,0 +1,175 @@
+package tasks
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/models"
+	"github.com/shopspring/decimal"
+)
+
+// 推送事件类型
+const (
+	StreamEventPrice        = "price"
+	StreamEventOrder        = "order"
+	StreamEventFuturesOrder = "futures_order"
+	StreamEventPositions    = "positions"
+	StreamEventStrategy     = "strategy"
+	StreamEventNotification = "notification"
+
+	// 每个连接的事件缓冲
+	streamSubscriberBuffer = 256
+	// 同一交易对价格推送的最小间隔
+	streamPriceThrottle = time.Second
+)
+
+// StreamEvent 推送给前端的事件
+type StreamEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// streamHub 按用户分发推送事件，订阅者消费过慢时丢弃事件而不阻塞交易流程
+type streamHub struct {
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan StreamEvent]struct{}
+	priceSent   sync.Map // symbol|userID -> time.Time
+}
+
+var userStreamHub = &streamHub{
+	subscribers: make(map[uint]map[chan StreamEvent]struct{}),
+}
+
+// SubscribeUserEvents 订阅用户的推送事件，调用返回的函数取消订阅
+func SubscribeUserEvents(userID uint) (<-chan StreamEvent, func()) {
+	ch := make(chan StreamEvent, streamSubscriberBuffer)
+
+	userStreamHub.mu.Lock()
+	if userStreamHub.subscribers[userID] == nil {
+		userStreamHub.subscribers[userID] = make(map[chan StreamEvent]struct{})
+	}
+	userStreamHub.subscribers[userID][ch] = struct{}{}
+	userStreamHub.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			userStreamHub.mu.Lock()
+			delete(userStreamHub.subscribers[userID], ch)
+			if len(userStreamHub.subscribers[userID]) == 0 {
+				delete(userStreamHub.subscribers, userID)
+			}
+			userStreamHub.mu.Unlock()
+		})
+	}
+}
+
+// hasStreamSubscribers 用户是否有在线的推送连接
+func hasStreamSubscribers(userID uint) bool {
+	userStreamHub.mu.RLock()
+	defer userStreamHub.mu.RUnlock()
+	return len(userStreamHub.subscribers[userID]) > 0
+}
+
+// PublishUserEvent 向用户的所有推送连接发送事件
+func PublishUserEvent(userID uint, eventType string, data interface{}) {
+	userStreamHub.mu.RLock()
+	defer userStreamHub.mu.RUnlock()
+
+	subscribers := userStreamHub.subscribers[userID]
+	if len(subscribers) == 0 {
+		return
+	}
+	event := StreamEvent{Type: eventType, Data: data, Time: time.Now()}
+	for ch := range subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("用户 %d 推送连接积压，丢弃 %s 事件", userID, eventType)
+		}
+	}
+}
+
+// publishPrice 推送价格，同一用户同一交易对每秒最多一次
+func publishPrice(userID uint, symbol string, price float64) {
+	if !hasStreamSubscribers(userID) {
+		return
+	}
+	key := fmt.Sprintf("%s|%d", symbol, userID)
+	now := time.Now()
+	if last, ok := userStreamHub.priceSent.Load(key); ok && now.Sub(last.(time.Time)) < streamPriceThrottle {
+		return
+	}
+	userStreamHub.priceSent.Store(key, now)
+
+	PublishUserEvent(userID, StreamEventPrice, map[string]interface{}{
+		"symbol": symbol,
+		"price":  price,
+	})
+}
+
+// publishOrderStatus 推送现货订单状态变化
+func publishOrderStatus(order models.Order, status string) {
+	PublishUserEvent(order.UserID, StreamEventOrder, map[string]interface{}{
+		"id":         order.ID,
+		"orderId":    order.OrderID,
+		"strategyId": order.StrategyID,
+		"symbol":     order.Symbol,
+		"side":       order.Side,
+		"status":     status,
+		"paper":      order.Paper,
+	})
+}
+
+// publishFuturesOrderStatus 推送合约订单状态和成交进度
+func publishFuturesOrderStatus(order models.FuturesOrder, status string, execQty, avgPrice decimal.Decimal) {
+	PublishUserEvent(order.UserID, StreamEventFuturesOrder, map[string]interface{}{
+		"id":           order.ID,
+		"orderId":      order.OrderID,
+		"strategyId":   order.StrategyID,
+		"symbol":       order.Symbol,
+		"side":         order.Side,
+		"positionSide": order.PositionSide,
+		"purpose":      order.OrderPurpose,
+		"status":       status,
+		"executedQty":  execQty,
+		"avgPrice":     avgPrice,
+		"paper":        order.Paper,
+	})
+}
+
+// publishPositions 推送合约持仓和未实现盈亏
+func publishPositions(userID uint, positions []models.FuturesPosition) {
+	if len(positions) == 0 {
+		return
+	}
+	PublishUserEvent(userID, StreamEventPositions, positions)
+}
+
+// publishStrategyState 推送策略状态变化，kind 为 spot/futures
+func publishStrategyState(userID uint, kind string, strategyID uint, status string) {
+	PublishUserEvent(userID, StreamEventStrategy, map[string]interface{}{
+		"kind":       kind,
+		"strategyId": strategyID,
+		"status":     status,
+	})
+}
+
+// UserPrices 用户监控中的交易对最新价格
+func UserPrices(userID uint) map[string]float64 {
+	suffix := fmt.Sprintf("|%d", userID)
+	prices := make(map[string]float64)
+	PriceMonitor.Range(func(key, value interface{}) bool {
+		symbolUser, ok := key.(string)
+		price, valid := value.(float64)
+		if ok && valid && strings.HasSuffix(symbolUser, suffix) {
+			prices[strings.TrimSuffix(symbolUser, suffix)] = price
+		}
+		return true
+	})
+	return prices
+}
@@ -0,0 +1,209 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccj241/binance/config"
+)
+
+// taskLifecycle 后台任务的运行状态
+// 停止分两步：先关闭下单入口并等待进行中的下单完成，再取消上下文让任务和策略监控退出
+type taskLifecycle struct {
+	mu       sync.Mutex
+	draining bool           // 停止中，不再触发新的策略下单
+	stopped  bool           // 上下文已取消，不再启动新的goroutine
+	orders   sync.WaitGroup // 进行中的策略下单
+	tasks    sync.WaitGroup // 后台任务和策略监控
+}
+
+var lifecycle = &taskLifecycle{}
+
+// beginOrderPlacement 策略触发下单前调用，服务停止中返回 false，调用方放弃本次触发
+// 返回 true 时调用方必须在下单流程结束后调用 endOrderPlacement
+func beginOrderPlacement() bool {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	if lifecycle.draining {
+		return false
+	}
+	lifecycle.orders.Add(1)
+	return true
+}
+
+// endOrderPlacement 策略下单流程结束
+func endOrderPlacement() {
+	lifecycle.orders.Done()
+}
+
+// spawn 启动受监管的goroutine，停止时等待其退出；上下文取消后不再启动
+func spawn(name string, fn func()) {
+	lifecycle.mu.Lock()
+	if lifecycle.stopped {
+		lifecycle.mu.Unlock()
+		log.Printf("服务正在停止，不再启动 %s", name)
+		return
+	}
+	lifecycle.tasks.Add(1)
+	lifecycle.mu.Unlock()
+
+	go func() {
+		defer lifecycle.tasks.Done()
+		fn()
+	}()
+}
+
+// waitGroupTimeout 等待 WaitGroup 结束，超时返回 false
+func waitGroupTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// 需要租约的任务名称，也是 cluster.tasks 配置中使用的名称
+const (
+	taskSpot              = "spot"
+	taskOrders            = "orders"
+	taskUserStreams       = "user_streams"
+	taskDCA               = "dca"
+	taskRisk              = "risk"
+	taskWithdrawals       = "withdrawals"
+	taskDualInvestment    = "dual_investment"
+	taskFutures           = "futures"
+	taskNotificationRetry = "notification_retry"
+)
+
+// supervisedTask 受监管的后台任务
+// leased 的任务会下单、撤单或提币，集群模式下同一时间只在持有租约的实例上运行；
+// 其他任务只处理本实例内存中的数据（通知队列、行情推送的K线和提醒、交易规则缓存），每个实例都运行
+type supervisedTask struct {
+	name   string
+	title  string
+	leased bool
+	run    func(ctx context.Context, cfg *config.Config)
+}
+
+var supervisedTasks = []supervisedTask{
+	{"notifications", "通知分发", false, StartNotificationDispatcher},
+	{taskNotificationRetry, "通知重试", true, retryNotifications},
+	{taskSpot, "价格监控", true, StartPriceMonitoring},
+	{"alerts", "价格提醒", false, StartPriceAlerts},
+	{"candles", "K线存储", false, StartCandleStore},
+	{"symbol_info", "交易规则刷新", false, StartSymbolInfoRefresh},
+	{taskOrders, "订单检查", true, CheckOrders},
+	{taskUserStreams, "用户数据流", true, StartUserDataStreams},
+	{taskDCA, "定投调度", true, StartDCAScheduler},
+	{taskRisk, "风控监控", true, StartRiskMonitor},
+	{taskWithdrawals, "自动提币", true, CheckWithdrawals},
+	{taskDualInvestment, "双币投资", true, StartDualInvestmentTasks},
+	{taskFutures, "期货监控", true, StartFuturesMonitoring},
+}
+
+// Supervisor 启动所有后台任务并负责优雅停止
+type Supervisor struct {
+	cfg       *config.Config
+	ctx       context.Context
+	cancel    context.CancelFunc
+	preferred map[string]bool // 集群模式下本实例优先运行的任务
+}
+
+// NewSupervisor 创建后台任务监管器，校验 cluster.tasks 中的任务名称
+func NewSupervisor(cfg *config.Config) (*Supervisor, error) {
+	preferred := make(map[string]bool)
+	leased := make(map[string]bool)
+	for _, task := range supervisedTasks {
+		if task.leased {
+			leased[task.name] = true
+		}
+	}
+	for _, name := range strings.Split(cfg.Cluster.Tasks, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !leased[name] {
+			return nil, fmt.Errorf("cluster.tasks 包含未知任务: %s", name)
+		}
+		preferred[name] = true
+	}
+	// 未指定时优先运行全部任务
+	if len(preferred) == 0 {
+		preferred = leased
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{cfg: cfg, ctx: ctx, cancel: cancel, preferred: preferred}, nil
+}
+
+// Start 启动后台任务，所有任务共享可取消的上下文
+// 集群模式下需要租约的任务由各自的租约循环在取得租约后启动
+func (s *Supervisor) Start() {
+	ctx, cfg := s.ctx, s.cfg
+	if cfg.Cluster.Enabled {
+		log.Printf("集群模式，实例ID: %s", cfg.Cluster.InstanceID)
+	}
+	for _, task := range supervisedTasks {
+		if task.leased && cfg.Cluster.Enabled {
+			spawn(task.title+"租约", func() { s.runLeased(task) })
+			continue
+		}
+		group := &taskGroup{}
+		activeTasks.Store(task.name, group)
+		taskCtx := withTaskGroup(ctx, group)
+		group.spawn(task.title, func() { task.run(taskCtx, cfg) })
+	}
+}
+
+// Shutdown 优雅停止：不再触发新策略，等待进行中的下单完成，
+// 然后取消上下文关闭行情和用户数据流、结束策略监控，最后落库内存中的K线和通知
+// 超过 timeout 仍未结束时返回错误
+func (s *Supervisor) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	lifecycle.mu.Lock()
+	lifecycle.draining = true
+	lifecycle.mu.Unlock()
+	log.Println("停止触发新策略，等待进行中的下单完成")
+	ordersDone := waitGroupTimeout(&lifecycle.orders, time.Until(deadline))
+	if !ordersDone {
+		log.Println("等待下单完成超时")
+	}
+
+	lifecycle.mu.Lock()
+	lifecycle.stopped = true
+	lifecycle.mu.Unlock()
+	s.cancel()
+	log.Println("正在停止后台任务和策略监控")
+	tasksDone := waitGroupTimeout(&lifecycle.tasks, time.Until(deadline))
+	if !tasksDone {
+		log.Println("等待后台任务退出超时")
+	}
+
+	// 后台任务已退出，最后一次落库
+	candleStore.flush(s.cfg)
+	drainNotifications(s.cfg)
+
+	// 释放租约，其他实例立即接管
+	if s.cfg.Cluster.Enabled {
+		if err := releaseLeases(s.cfg.DB, s.cfg.Cluster.InstanceID); err != nil {
+			log.Printf("释放任务租约失败: %v", err)
+		}
+	}
+
+	if !ordersDone || !tasksDone {
+		return fmt.Errorf("停止超时（%v）", timeout)
+	}
+	log.Println("后台任务已全部停止")
+	return nil
+}
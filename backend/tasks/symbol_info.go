@@ -0,0 +1,24 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/services"
+)
+
+// StartSymbolInfoRefresh 定时刷新各交易所环境的交易规则缓存，缓存在进程内，每个实例都运行
+func StartSymbolInfoRefresh(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(services.SymbolInfoRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			services.RefreshSymbolInfo(ctx)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/ccj241/binance/config"
+	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 用户数据流市场
+const (
+	userStreamSpot    = "spot"
+	userStreamFutures = "futures"
+)
+
+const (
+	userStreamKeepalive    = 30 * time.Minute // listenKey 有效期60分钟，每30分钟续期
+	userStreamRetryMin     = 5 * time.Second
+	userStreamRetryMax     = 5 * time.Minute
+	orderPollFallback      = 2 * time.Second  // 数据流不可用时单个订单的轮询间隔
+	orderPollStreaming     = 30 * time.Second // 数据流正常时单个订单的兜底轮询间隔
+	streamOrderLookupDelay = 2 * time.Second  // 推送早于订单入库时延迟重查
+)
+
+var errUserStreamUnsupported = errors.New("交易所不支持用户数据流")
+
+// userStreamKey 交易所账户和市场确定一条数据流
+type userStreamKey struct {
+	userID    uint
+	accountID uint
+	market    string
+}
+
+// userStream 单条数据流的运行状态
+type userStream struct {
+	stopC        chan struct{}
+	connectedAt  time.Time // 为零表示当前未连接
+	reconciledAt time.Time // 上次完整轮询对账时间
+}
+
+// userStreamManager 为每个交易所账户维护现货和U本位合约的用户数据流
+type userStreamManager struct {
+	mu             sync.Mutex
+	streams        map[userStreamKey]*userStream
+	reconcileEvery time.Duration // 数据流正常时轮询对账的间隔
+}
+
+// userStreams 全局数据流管理器，轮询任务通过它判断是否可以降低轮询频率
+var userStreams = &userStreamManager{streams: make(map[userStreamKey]*userStream), reconcileEvery: 5 * time.Minute}
+
+// StartUserDataStreams 为实盘用户的交易所账户建立用户数据流，推送订单和持仓变化
+func StartUserDataStreams(ctx context.Context, cfg *config.Config) {
+	userStreams.mu.Lock()
+	userStreams.reconcileEvery = cfg.Tasks.UserStreamReconcileInterval
+	userStreams.mu.Unlock()
+	userStreams.sync(ctx, cfg)
+
+	ticker := time.NewTicker(cfg.Tasks.UserStreamScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			userStreams.stopAll()
+			return
+		case <-ticker.C:
+		}
+		userStreams.sync(ctx, cfg)
+	}
+}
+
+// stopAll 停止所有数据流并关闭 listenKey
+func (m *userStreamManager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, stream := range m.streams {
+		close(stream.stopC)
+		delete(m.streams, key)
+	}
+}
+
+// sync 按当前交易所账户列表启动新数据流并停止不再需要的数据流
+func (m *userStreamManager) sync(ctx context.Context, cfg *config.Config) {
+	var accounts []models.ExchangeAccount
+	if err := cfg.DB.Joins("JOIN users ON users.id = exchange_accounts.user_id").
+		Where("users.status = ? AND users.paper_trading = ? AND users.deleted_at IS NULL", "active", false).
+		Where("exchange_accounts.status = ? AND exchange_accounts.api_key <> '' AND exchange_accounts.secret_key <> ''", "active").
+		Find(&accounts).Error; err != nil {
+		log.Printf("获取数据流账户失败: %v", err)
+		return
+	}
+
+	wanted := make(map[userStreamKey]bool)
+	for _, account := range accounts {
+		if account.HasPermission(models.AccountPermSpot) {
+			wanted[userStreamKey{userID: account.UserID, accountID: account.ID, market: userStreamSpot}] = true
+		}
+		if account.HasPermission(models.AccountPermFutures) {
+			wanted[userStreamKey{userID: account.UserID, accountID: account.ID, market: userStreamFutures}] = true
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, stream := range m.streams {
+		if !wanted[key] {
+			close(stream.stopC)
+			delete(m.streams, key)
+		}
+	}
+	for key := range wanted {
+		if _, ok := m.streams[key]; ok {
+			continue
+		}
+		stream := &userStream{stopC: make(chan struct{})}
+		m.streams[key] = stream
+		spawnTask(ctx, "用户数据流", func() { m.run(cfg, key, stream) })
+	}
+}
+
+// run 保持数据流连接，断开后按退避间隔重连
+func (m *userStreamManager) run(cfg *config.Config, key userStreamKey, stream *userStream) {
+	retry := userStreamRetryMin
+	for {
+		connected, err := m.serve(cfg, key, stream)
+		m.setConnected(stream, false)
+		if errors.Is(err, errUserStreamUnsupported) {
+			return
+		}
+		if connected {
+			retry = userStreamRetryMin
+		}
+		if err != nil {
+			log.Printf("账户 %d %s数据流断开，%v 后重连: %v", key.accountID, key.market, retry, err)
+		}
+
+		select {
+		case <-stream.stopC:
+			return
+		case <-time.After(retry):
+		}
+		if !connected {
+			retry *= 2
+			if retry > userStreamRetryMax {
+				retry = userStreamRetryMax
+			}
+		}
+	}
+}
+
+// serve 创建 listenKey 并订阅推送，直到连接断开、listenKey 失效或数据流被停止
+// 返回值 connected 表示本次是否成功建立过连接
+func (m *userStreamManager) serve(cfg *config.Config, key userStreamKey, stream *userStream) (bool, error) {
+	exchange, err := services.NewUserExchange(cfg.DB, key.userID, key.accountID)
+	if err != nil {
+		return false, err
+	}
+	streamExchange, ok := exchange.(services.UserStreamExchange)
+	if !ok {
+		return false, errUserStreamUnsupported
+	}
+
+	ctx := context.Background()
+	start, keepalive, closeKey := streamExchange.StartUserStream, streamExchange.KeepaliveUserStream, streamExchange.CloseUserStream
+	if key.market == userStreamFutures {
+		start, keepalive, closeKey = streamExchange.StartFuturesUserStream, streamExchange.KeepaliveFuturesUserStream, streamExchange.CloseFuturesUserStream
+	}
+
+	listenKey, err := start(ctx)
+	if err != nil {
+		reportAPIKeyError(key.userID, err)
+		return false, fmt.Errorf("创建 listenKey 失败: %v", err)
+	}
+
+	expiredC := make(chan struct{}, 1)
+	errHandler := func(err error) {
+		log.Printf("账户 %d %s数据流错误: %v", key.accountID, key.market, err)
+	}
+
+	// go-binance 的数据流地址是全局的，这里按账户环境自行拼接地址
+	endpoints := streamExchange.StreamEndpoints()
+	var doneC, stopC chan struct{}
+	if key.market == userStreamSpot {
+		doneC, stopC, err = serveStream(endpoints.SpotWS+"/"+listenKey, func(message []byte) {
+			event := new(binance.WsUserDataEvent)
+			if err := json.Unmarshal(message, event); err != nil {
+				errHandler(err)
+				return
+			}
+			if event.Event != binance.UserDataEventTypeExecutionReport {
+				return
+			}
+			if err := json.Unmarshal(message, &event.OrderUpdate); err != nil {
+				errHandler(err)
+				return
+			}
+			applySpotOrderUpdate(cfg, exchange, key.userID, key.accountID, event.OrderUpdate, true)
+		}, errHandler)
+	} else {
+		doneC, stopC, err = serveStream(endpoints.FuturesWS+"/"+listenKey, func(message []byte) {
+			event := new(futures.WsUserDataEvent)
+			if err := json.Unmarshal(message, event); err != nil {
+				errHandler(err)
+				return
+			}
+			switch event.Event {
+			case futures.UserDataEventTypeOrderTradeUpdate:
+				applyFuturesOrderTradeUpdate(cfg, exchange, key.userID, key.accountID, event.OrderTradeUpdate, true)
+			case futures.UserDataEventTypeAccountUpdate:
+				applyFuturesAccountUpdate(cfg, key.userID, key.accountID, event.AccountUpdate)
+			case futures.UserDataEventTypeListenKeyExpired:
+				select {
+				case expiredC <- struct{}{}:
+				default:
+				}
+			}
+		}, errHandler)
+	}
+	if err != nil {
+		closeKey(ctx, listenKey)
+		return false, fmt.Errorf("连接数据流失败: %v", err)
+	}
+
+	m.setConnected(stream, true)
+	log.Printf("账户 %d %s数据流已连接", key.accountID, key.market)
+
+	ticker := time.NewTicker(userStreamKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.stopC:
+			close(stopC)
+			closeKey(ctx, listenKey)
+			log.Printf("账户 %d %s数据流已停止", key.accountID, key.market)
+			return true, nil
+		case <-doneC:
+			return true, fmt.Errorf("连接已关闭")
+		case <-expiredC:
+			close(stopC)
+			return true, fmt.Errorf("listenKey 已过期")
+		case <-ticker.C:
+			if err := keepalive(ctx, listenKey); err != nil {
+				close(stopC)
+				return true, fmt.Errorf("listenKey 续期失败: %v", err)
+			}
+		}
+	}
+}
+
+// serveStream 连接数据流地址并逐条处理推送，关闭 stopC 断开连接，连接结束后关闭 doneC
+func serveStream(endpoint string, handler func(message []byte), errHandler func(error)) (doneC, stopC chan struct{}, err error) {
+	dialer := websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  45 * time.Second,
+		EnableCompression: true,
+	}
+	conn, _, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+	go func() {
+		defer close(doneC)
+		var stopped atomic.Bool
+		go func() {
+			select {
+			case <-stopC:
+				stopped.Store(true)
+			case <-doneC:
+			}
+			conn.Close()
+		}()
+		for {
+			// 服务端的 ping 由默认处理函数回复 pong
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if !stopped.Load() {
+					errHandler(err)
+				}
+				return
+			}
+			handler(message)
+		}
+	}()
+	return doneC, stopC, nil
+}
+
+// setConnected 更新连接状态；重新连接后断线期间可能漏掉推送，清空对账时间使下一轮轮询完整对账
+func (m *userStreamManager) setConnected(stream *userStream, connected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if connected {
+		stream.connectedAt = time.Now()
+	} else {
+		stream.connectedAt = time.Time{}
+	}
+	stream.reconciledAt = time.Time{}
+}
+
+// healthy 判断账户的数据流当前是否已连接
+func (m *userStreamManager) healthy(key userStreamKey) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[key]
+	return ok && !stream.connectedAt.IsZero()
+}
+
+// shouldReconcile 判断轮询任务本轮是否需要完整检查该账户的订单
+// 数据流未连接时每轮都检查；已连接时每 reconcileEvery 检查一次作为兜底
+func (m *userStreamManager) shouldReconcile(key userStreamKey) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[key]
+	if !ok || stream.connectedAt.IsZero() {
+		return true
+	}
+	if time.Since(stream.reconciledAt) < m.reconcileEvery {
+		return false
+	}
+	stream.reconciledAt = time.Now()
+	return true
+}
+
+// ==================== 推送处理 ====================
+
+// applySpotOrderUpdate 处理现货 executionReport：记录成交明细并在订单结束时更新本地状态
+// retry 为 true 时，找不到订单（推送早于下单后的入库）会延迟重查一次
+func applySpotOrderUpdate(cfg *config.Config, exchange services.Exchange, userID, accountID uint, update binance.WsOrderUpdate, retry bool) {
+	var order models.Order
+	err := cfg.DB.Where("user_id = ? AND account_id = ? AND order_id = ? AND symbol = ? AND paper = ? AND deleted_at IS NULL",
+		userID, accountID, update.Id, update.Symbol, false).First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if retry {
+			time.AfterFunc(streamOrderLookupDelay, func() {
+				applySpotOrderUpdate(cfg, exchange, userID, accountID, update, false)
+			})
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("查询推送订单 %d 失败: %v", update.Id, err)
+		return
+	}
+
+	if update.ExecutionType == "TRADE" {
+		recordStreamFill(cfg, exchange, order, update)
+	}
+
+	status := binance.OrderStatusType(update.Status)
+	if order.Status != "pending" || !isFinalOrderStatus(status) {
+		return
+	}
+
+	// 断线期间可能漏掉部分成交推送，账本数量不足时补拉成交明细
+	executed, _ := strconv.ParseFloat(update.FilledVolume, 64)
+	if executed > 0 {
+		var recorded float64
+		cfg.DB.Model(&models.Fill{}).
+			Where("user_id = ? AND account_id = ? AND paper = ? AND symbol = ? AND order_id = ?", userID, accountID, false, order.Symbol, order.OrderID).
+			Select("COALESCE(SUM(quantity), 0)").Scan(&recorded)
+		if recorded < executed-1e-12 {
+			recordOrderFills(cfg, exchange, order)
+		}
+	}
+
+	applyOrderStatus(cfg, exchange, order, &binance.Order{
+		Symbol:                   update.Symbol,
+		OrderID:                  update.Id,
+		Price:                    update.Price,
+		OrigQuantity:             update.Volume,
+		ExecutedQuantity:         update.FilledVolume,
+		CummulativeQuoteQuantity: update.FilledQuoteVolume,
+		Status:                   status,
+		Type:                     binance.OrderType(update.Type),
+		Side:                     binance.SideType(update.Side),
+	})
+}
+
+// recordStreamFill 将 executionReport 中的单笔成交写入成交账本并累计到订单
+func recordStreamFill(cfg *config.Config, exchange services.Exchange, order models.Order, update binance.WsOrderUpdate) {
+	price, _ := decimal.NewFromString(update.LatestPrice)
+	quantity, _ := decimal.NewFromString(update.LatestVolume)
+	quote, _ := decimal.NewFromString(update.LatestQuoteVolume)
+	commission, _ := decimal.NewFromString(update.FeeCost)
+	if !quantity.IsPositive() {
+		return
+	}
+
+	baseAsset, quoteAsset := spotSymbolAssets(exchange, order.Symbol)
+	fill := models.Fill{
+		UserID:          order.UserID,
+		AccountID:       order.AccountID,
+		Paper:           false,
+		Symbol:          order.Symbol,
+		TradeID:         update.TradeId,
+		OrderID:         update.Id,
+		OrderRef:        order.ID,
+		StrategyID:      order.StrategyID,
+		DCAStrategyID:   order.DCAStrategyID,
+		BaseAsset:       baseAsset,
+		QuoteAsset:      quoteAsset,
+		Side:            update.Side,
+		Price:           price,
+		Quantity:        quantity,
+		QuoteQuantity:   quote,
+		Commission:      commission,
+		CommissionAsset: update.FeeAsset,
+		QuoteUSDTRate:   assetUSDTRate(quoteAsset),
+		IsMaker:         update.IsMaker,
+		TradeTime:       time.UnixMilli(update.TransactionTime),
+	}
+	fill.CommissionUSDT = fillCommissionUSDT(fill)
+
+	result := cfg.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&fill)
+	if result.Error != nil {
+		log.Printf("保存成交明细 %d 失败: %v", update.TradeId, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	executedQty, _ := decimal.NewFromString(update.FilledVolume)
+	quoteQty, _ := decimal.NewFromString(update.FilledQuoteVolume)
+	updates := map[string]interface{}{
+		"commission_usdt": gorm.Expr("commission_usdt + ?", fill.CommissionUSDT),
+	}
+	if executedQty.IsPositive() {
+		updates["executed_qty"] = executedQty
+		updates["avg_price"] = quoteQty.Div(executedQty)
+	}
+	if err := cfg.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updates).Error; err != nil {
+		log.Printf("更新订单 %d 成交汇总失败: %v", order.OrderID, err)
+	}
+}
+
+// applyFuturesOrderTradeUpdate 处理合约 ORDER_TRADE_UPDATE：更新订单进度、处理平仓成交并唤醒监控协程
+func applyFuturesOrderTradeUpdate(cfg *config.Config, exchange services.Exchange, userID, accountID uint, update futures.WsOrderTradeUpdate, retry bool) {
+	var order models.FuturesOrder
+	err := cfg.DB.Where("user_id = ? AND account_id = ? AND order_id = ? AND symbol = ? AND paper = ?",
+		userID, accountID, update.ID, update.Symbol, false).First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if retry {
+			time.AfterFunc(streamOrderLookupDelay, func() {
+				applyFuturesOrderTradeUpdate(cfg, exchange, userID, accountID, update, false)
+			})
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("查询推送期货订单 %d 失败: %v", update.ID, err)
+		return
+	}
+
+	execQty, _ := decimal.NewFromString(update.AccumulatedFilledQty)
+	avgPrice, _ := decimal.NewFromString(update.AveragePrice)
+	updated := updateFuturesOrderStatus(cfg, order, string(update.Status), execQty, avgPrice)
+
+	if updated && update.Status == futures.OrderStatusTypeFilled && isExitOrderPurpose(order.OrderPurpose) {
+		handleExitOrderFilled(cfg, exchange, order, avgPrice, execQty)
+	}
+
+	notifyOrderWatchers(userStreamFutures, order.OrderID)
+}
+
+// applyFuturesAccountUpdate 处理合约 ACCOUNT_UPDATE，同步持仓的未实现盈亏
+// 持仓归零后的平仓处理仍由持仓监控对账完成
+func applyFuturesAccountUpdate(cfg *config.Config, userID, accountID uint, update futures.WsAccountUpdate) {
+	for _, pos := range update.Positions {
+		unrealizedPnl, _ := strconv.ParseFloat(pos.UnrealizedPnL, 64)
+
+		query := cfg.DB.Model(&models.FuturesPosition{}).
+			Where("user_id = ? AND account_id = ? AND paper = ? AND status = ? AND symbol = ?", userID, accountID, false, "open", pos.Symbol)
+		if pos.Side != futures.PositionSideTypeBoth {
+			query = query.Where("position_side = ?", string(pos.Side))
+		}
+		if err := query.Updates(map[string]interface{}{
+			"unrealized_pnl": unrealizedPnl,
+			"updated_at":     time.Now(),
+		}).Error; err != nil {
+			log.Printf("更新用户 %d %s 持仓失败: %v", userID, pos.Symbol, err)
+		}
+	}
+
+	// 推送更新后的持仓
+	if hasStreamSubscribers(userID) {
+		var positions []models.FuturesPosition
+		if err := cfg.DB.Where("user_id = ? AND paper = ? AND status = ?", userID, false, "open").
+			Find(&positions).Error; err == nil {
+			publishPositions(userID, positions)
+		}
+	}
+}
+
+// ==================== 订单等待 ====================
+
+// orderWatchKey 订单号只在同一市场内唯一
+type orderWatchKey struct {
+	market  string
+	orderID int64
+}
+
+var (
+	orderWatchersMu sync.Mutex
+	orderWatchers   = make(map[orderWatchKey]map[chan struct{}]bool)
+)
+
+// notifyOrderWatchers 唤醒等待该订单的监控协程
+func notifyOrderWatchers(market string, orderID int64) {
+	orderWatchersMu.Lock()
+	defer orderWatchersMu.Unlock()
+	for wake := range orderWatchers[orderWatchKey{market: market, orderID: orderID}] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// orderWaiter 监控协程的检查节拍：收到订单推送时立即触发，
+// 数据流正常时按 orderPollStreaming 兜底，不可用时按 orderPollFallback 轮询
+type orderWaiter struct {
+	C      <-chan struct{}
+	market string
+	wake   chan struct{}
+	stopC  chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	keys []orderWatchKey
+}
+
+// newFuturesOrderWaiter 创建等待合约订单变化的节拍，模拟盘订单始终按短间隔轮询
+func newFuturesOrderWaiter(userID, accountID uint, paper bool, orderIDs ...int64) *orderWaiter {
+	c := make(chan struct{}, 1)
+	w := &orderWaiter{
+		C:      c,
+		market: userStreamFutures,
+		wake:   make(chan struct{}, 1),
+		stopC:  make(chan struct{}),
+	}
+	w.Watch(orderIDs...)
+
+	key := userStreamKey{userID: userID, accountID: accountID, market: userStreamFutures}
+	go func() {
+		for {
+			interval := orderPollFallback
+			if !paper && userStreams.healthy(key) {
+				interval = orderPollStreaming
+			}
+			timer := time.NewTimer(interval)
+			select {
+			case <-w.stopC:
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-w.wake:
+				timer.Stop()
+			}
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return w
+}
+
+// Watch 追加需要等待的订单
+func (w *orderWaiter) Watch(orderIDs ...int64) {
+	orderWatchersMu.Lock()
+	defer orderWatchersMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, orderID := range orderIDs {
+		key := orderWatchKey{market: w.market, orderID: orderID}
+		if orderWatchers[key] == nil {
+			orderWatchers[key] = make(map[chan struct{}]bool)
+		}
+		orderWatchers[key][w.wake] = true
+		w.keys = append(w.keys, key)
+	}
+}
+
+// Stop 停止节拍并取消所有订单的等待
+func (w *orderWaiter) Stop() {
+	w.once.Do(func() {
+		close(w.stopC)
+		orderWatchersMu.Lock()
+		defer orderWatchersMu.Unlock()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, key := range w.keys {
+			delete(orderWatchers[key], w.wake)
+			if len(orderWatchers[key]) == 0 {
+				delete(orderWatchers, key)
+			}
+		}
+	})
+}
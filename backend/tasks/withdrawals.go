@@ -4,24 +4,33 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	"github.com/adshao/go-binance/v2"
 	"github.com/ccj241/binance/config"
 	"github.com/ccj241/binance/models"
+	"github.com/ccj241/binance/services"
+	"github.com/shopspring/decimal"
 )
 
 // CheckWithdrawals 定期检查并执行自动提币规则
-func CheckWithdrawals(cfg *config.Config) {
-	ticker := time.NewTicker(5 * time.Minute) // 每5分钟检查一次
+func CheckWithdrawals(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(cfg.Tasks.WithdrawalInterval)
 	defer ticker.Stop()
 
-	// 立即执行一次
-	processWithdrawalRules(cfg)
-
-	for range ticker.C {
+	// 立即执行一次，之后按间隔执行
+	for {
+		// 服务停止中不再发起新的提币
+		if !beginOrderPlacement() {
+			return
+		}
 		processWithdrawalRules(cfg)
+		endOrderPlacement()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -39,20 +48,25 @@ func processWithdrawalRules(cfg *config.Config) {
 
 	log.Printf("检查 %d 个自动提币规则", len(rules))
 
-	// 按用户分组规则
-	userRules := make(map[uint][]models.Withdrawal)
+	// 按交易所账户分组规则
+	type ruleGroup struct {
+		userID    uint
+		accountID uint
+	}
+	userRules := make(map[ruleGroup][]models.Withdrawal)
 	for _, rule := range rules {
-		userRules[rule.UserID] = append(userRules[rule.UserID], rule)
+		group := ruleGroup{userID: rule.UserID, accountID: rule.AccountID}
+		userRules[group] = append(userRules[group], rule)
 	}
 
-	// 处理每个用户的规则
-	for userID, userRuleList := range userRules {
-		processUserWithdrawalRules(cfg, userID, userRuleList)
+	// 处理每个账户的规则
+	for group, userRuleList := range userRules {
+		processUserWithdrawalRules(cfg, group.userID, group.accountID, userRuleList)
 	}
 }
 
-// processUserWithdrawalRules 处理单个用户的提币规则
-func processUserWithdrawalRules(cfg *config.Config, userID uint, rules []models.Withdrawal) {
+// processUserWithdrawalRules 处理单个交易所账户的提币规则
+func processUserWithdrawalRules(cfg *config.Config, userID, accountID uint, rules []models.Withdrawal) {
 	// 获取用户信息
 	var user models.User
 	if err := cfg.DB.First(&user, userID).Error; err != nil {
@@ -60,58 +74,52 @@ func processUserWithdrawalRules(cfg *config.Config, userID uint, rules []models.
 		return
 	}
 
-	// 解密API密钥
-	apiKey, err := user.GetDecryptedAPIKey()
+	// 提币需要账户开通提币权限
+	exchangeAccount, err := services.ResolveAccountWith(cfg.DB, userID, accountID, models.AccountPermWithdraw)
 	if err != nil {
-		log.Printf("解密用户 %d API Key失败: %v", userID, err)
+		log.Printf("用户 %d 跳过提币规则检查: %v", user.ID, err)
 		return
 	}
-	secretKey, err := user.GetDecryptedSecretKey()
+	exchange, err := services.NewAccountExchange(exchangeAccount)
 	if err != nil {
-		log.Printf("解密用户 %d Secret Key失败: %v", userID, err)
-		return
-	}
-
-	if apiKey == "" || secretKey == "" {
-		log.Printf("用户 %d 未设置 API 密钥，跳过提币规则检查", user.ID)
+		log.Printf("用户 %d 跳过提币规则检查: %v", user.ID, err)
 		return
 	}
 
-	client := binance.NewClient(apiKey, secretKey)
-
 	// 获取账户余额
-	account, err := client.NewGetAccountService().Do(context.Background())
+	account, err := exchange.GetAccount(context.Background())
 	if err != nil {
 		log.Printf("获取用户 %d 账户余额失败: %v", userID, err)
+		reportAPIKeyError(userID, err)
 		return
 	}
 
 	// 创建余额映射
-	balanceMap := make(map[string]float64)
+	balanceMap := make(map[string]decimal.Decimal)
 	for _, balance := range account.Balances {
-		free, _ := strconv.ParseFloat(balance.Free, 64)
-		if free > 0 {
+		free, _ := decimal.NewFromString(balance.Free)
+		if free.IsPositive() {
 			balanceMap[balance.Asset] = free
 		}
 	}
 
 	// 检查每个规则
 	for _, rule := range rules {
-		processWithdrawalRule(cfg, client, user, rule, balanceMap)
+		processWithdrawalRule(cfg, exchange, user, rule, balanceMap)
 	}
 }
 
 // processWithdrawalRule 处理单个提币规则
-func processWithdrawalRule(cfg *config.Config, client *binance.Client, user models.User, rule models.Withdrawal, balanceMap map[string]float64) {
+func processWithdrawalRule(cfg *config.Config, exchange services.Exchange, user models.User, rule models.Withdrawal, balanceMap map[string]decimal.Decimal) {
 	balance, exists := balanceMap[rule.Asset]
-	if !exists || balance == 0 {
+	if !exists || balance.IsZero() {
 		log.Printf("用户 %d 的 %s 余额为0，跳过规则 %d", user.ID, rule.Asset, rule.ID)
 		return
 	}
 
 	// 检查是否达到阈值
-	if balance < rule.Threshold {
-		log.Printf("用户 %d 的 %s 余额 %.4f 未达到阈值 %.4f，跳过规则 %d",
+	if balance.LessThan(rule.Threshold) {
+		log.Printf("用户 %d 的 %s 余额 %s 未达到阈值 %s，跳过规则 %d",
 			user.ID, rule.Asset, balance, rule.Threshold, rule.ID)
 		return
 	}
@@ -128,68 +136,98 @@ func processWithdrawalRule(cfg *config.Config, client *binance.Client, user mode
 	// }
 
 	// 确定提币金额
-	var withdrawAmount float64
-	if rule.Amount == 0 {
+	var withdrawAmount decimal.Decimal
+	if rule.Amount.IsZero() {
 		// 如果规则金额为0，提取最大可用金额
 		withdrawAmount = balance
-		log.Printf("规则 %d 设置为提取最大金额，将提取 %.4f %s", rule.ID, withdrawAmount, rule.Asset)
+		log.Printf("规则 %d 设置为提取最大金额，将提取 %s %s", rule.ID, withdrawAmount, rule.Asset)
 	} else {
 		// 否则提取指定金额，但不超过可用余额
 		withdrawAmount = rule.Amount
-		if withdrawAmount > balance {
+		if withdrawAmount.GreaterThan(balance) {
 			withdrawAmount = balance
-			log.Printf("规则 %d 指定金额 %.4f 超过可用余额，调整为 %.4f %s",
+			log.Printf("规则 %d 指定金额 %s 超过可用余额，调整为 %s %s",
 				rule.ID, rule.Amount, withdrawAmount, rule.Asset)
 		}
 	}
+	// 提币金额保留4位小数，向下截断避免超过可用余额
+	withdrawAmount = withdrawAmount.Truncate(4)
 
 	// 获取提币手续费和最小提币金额
 	// 注意：暂时使用默认网络信息，等数据库模型更新后再使用rule.Network
-	withdrawInfo, err := getWithdrawInfo(client, rule.Asset, "")
+	withdrawInfo, err := getWithdrawInfo(exchange, rule.Asset, "")
 	if err != nil {
 		log.Printf("获取 %s 提币信息失败: %v", rule.Asset, err)
 		return
 	}
 
 	// 检查是否满足最小提币金额
-	if withdrawAmount < withdrawInfo.MinWithdrawAmount {
-		log.Printf("提币金额 %.4f %s 小于最小提币金额 %.4f，跳过",
+	if withdrawAmount.LessThan(decimal.NewFromFloat(withdrawInfo.MinWithdrawAmount)) {
+		log.Printf("提币金额 %s %s 小于最小提币金额 %.4f，跳过",
 			withdrawAmount, rule.Asset, withdrawInfo.MinWithdrawAmount)
 		return
 	}
 
 	// 计算实际到账金额（扣除手续费）
-	actualAmount := withdrawAmount - withdrawInfo.WithdrawFee
-	if actualAmount <= 0 {
+	actualAmount := withdrawAmount.Sub(decimal.NewFromFloat(withdrawInfo.WithdrawFee))
+	if !actualAmount.IsPositive() {
 		log.Printf("扣除手续费后金额为负，跳过提币")
 		return
 	}
 
 	// 执行提币
-	log.Printf("准备提币: 用户=%d, 资产=%s, 金额=%.4f, 地址=%s",
+	log.Printf("准备提币: 用户=%d, 资产=%s, 金额=%s, 地址=%s",
 		user.ID, rule.Asset, withdrawAmount, rule.Address)
 
 	// 创建提币请求
-	withdrawReq := client.NewCreateWithdrawService().
-		Coin(rule.Asset).
-		Address(rule.Address).
-		Amount(fmt.Sprintf("%.4f", withdrawAmount))
-	// 注意：暂时不添加网络参数，等数据库模型更新后再启用
-	// .Network(rule.Network)
-
-	withdrawResp, err := withdrawReq.Do(context.Background())
+	withdrawReq := services.WithdrawRequest{
+		Coin:    rule.Asset,
+		Address: rule.Address,
+		Amount:  withdrawAmount.String(),
+		// 注意：暂时不添加网络参数，等数据库模型更新后再启用
+		// Network: rule.Network,
+	}
+
+	withdrawResp, err := exchange.Withdraw(context.Background(), withdrawReq)
 	if err != nil {
 		log.Printf("提币失败: %v", err)
 		// 记录失败历史
 		recordWithdrawalHistory(cfg, user.ID, rule, withdrawAmount, "", "failed", err.Error())
+		reportAPIKeyError(user.ID, err)
+		EmitNotification(NotificationEvent{
+			UserID:  user.ID,
+			Event:   models.NotifyWithdrawalFailed,
+			Title:   fmt.Sprintf("提币失败: %s %s", withdrawAmount, rule.Asset),
+			Message: fmt.Sprintf("规则 %d 向 %s 提币失败: %v", rule.ID, rule.Address, err),
+			Data: map[string]interface{}{
+				"ruleId":  rule.ID,
+				"asset":   rule.Asset,
+				"amount":  withdrawAmount.String(),
+				"address": rule.Address,
+			},
+		})
 		return
 	}
 
 	// 记录成功的提币历史
 	recordWithdrawalHistory(cfg, user.ID, rule, withdrawAmount, withdrawResp.ID, "processing", "")
 
-	log.Printf("提币成功: ID=%s, 用户=%d, %s %.8f -> %s",
+	log.Printf("提币成功: ID=%s, 用户=%d, %s %s -> %s",
 		withdrawResp.ID, user.ID, rule.Asset, withdrawAmount, rule.Address)
+
+	EmitNotification(NotificationEvent{
+		UserID:  user.ID,
+		Event:   models.NotifyWithdrawalSent,
+		Title:   fmt.Sprintf("提币已提交: %s %s", withdrawAmount, rule.Asset),
+		Message: fmt.Sprintf("规则 %d 已向 %s 提币，币安提币ID %s", rule.ID, rule.Address, withdrawResp.ID),
+		Data: map[string]interface{}{
+			"ruleId":       rule.ID,
+			"withdrawalId": withdrawResp.ID,
+			"asset":        rule.Asset,
+			"amount":       withdrawAmount.String(),
+			"address":      rule.Address,
+		},
+	})
 }
 
 // WithdrawInfo 提币信息
@@ -199,7 +237,7 @@ type WithdrawInfo struct {
 }
 
 // getWithdrawInfo 获取特定网络的提币信息
-func getWithdrawInfo(client *binance.Client, asset, network string) (*WithdrawInfo, error) {
+func getWithdrawInfo(exchange services.Exchange, asset, network string) (*WithdrawInfo, error) {
 	// 根据币种和网络返回相应的提币信息
 	networkInfo := getAssetNetworkInfo(asset, network)
 	if networkInfo != nil {
@@ -423,10 +461,11 @@ func getSupportedNetworks(asset string) []string {
 }
 
 // recordWithdrawalHistory 记录提币历史
-func recordWithdrawalHistory(cfg *config.Config, userID uint, rule models.Withdrawal, amount float64, withdrawalID, status, errorMsg string) {
+func recordWithdrawalHistory(cfg *config.Config, userID uint, rule models.Withdrawal, amount decimal.Decimal, withdrawalID, status, errorMsg string) {
 	history := models.WithdrawalHistory{
-		UserID: userID,
-		Asset:  rule.Asset,
+		UserID:    userID,
+		AccountID: rule.AccountID,
+		Asset:     rule.Asset,
 		// Network:      rule.Network, // TODO: 等数据库模型更新后再启用
 		Amount:       amount,
 		Address:      rule.Address,
@@ -10,12 +10,25 @@ import (
 	"os"
 )
 
-// GetEncryptionKey 从环境变量获取加密密钥
+// DevEncryptionKey 未配置加密密钥时使用的默认密钥，仅用于开发
+const DevEncryptionKey = "dev-encryption-key-32-bytes-long"
+
+var encryptionKey string
+
+// SetEncryptionKey 设置加密密钥，由配置加载时调用
+func SetEncryptionKey(key string) {
+	encryptionKey = key
+}
+
+// GetEncryptionKey 获取加密密钥，未通过配置设置时读取 ENCRYPTION_KEY 环境变量
 func GetEncryptionKey() []byte {
-	key := os.Getenv("ENCRYPTION_KEY")
+	key := encryptionKey
+	if key == "" {
+		key = os.Getenv("ENCRYPTION_KEY")
+	}
 	if key == "" {
 		// 如果没有设置，使用默认密钥（仅用于开发）
-		key = "dev-encryption-key-32-bytes-long"
+		key = DevEncryptionKey
 	}
 	// 确保密钥长度为32字节（AES-256）
 	keyBytes := []byte(key)